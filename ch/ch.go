@@ -43,6 +43,10 @@ const (
 	Tusr          // first user defined type value
 )
 
+// Compressed msg (see Mux.Compress).
+// It has a fixed value, out of the way of user defined types.
+const Tzip uint16 = 0xFFFF
+
 const (
 	hdrSz = 4 + 4 + 2
 
@@ -141,37 +145,45 @@ func writeBytes(w io.Writer, tag uint32, typ uint16, b []byte) (int64, error) {
 // Write []byte, or Ign, string, error, Stringer, Byteser or discard the write.
 // If the write is discarded, ErrDiscarded is returned.
 func WriteMsg(w io.Writer, tag uint32, m face{}) (int64, error) {
+	typ, b, err := msgBytes(m)
+	if err != nil {
+		return 0, err
+	}
+	return writeBytes(w, tag, typ, b)
+}
+
+// Return the type and data sent for m.
+func msgBytes(m face{}) (uint16, []byte, error) {
 	switch m := m.(type) {
 	case []byte:
-		return writeBytes(w, tag, Tbytes, m)
+		return Tbytes, m, nil
 	case Ign:
-		return writeBytes(w, tag, m.Typ, m.Dat)
+		return m.Typ, m.Dat, nil
 	case string:
-		return writeBytes(w, tag, Tstr, []byte(m))
+		return Tstr, []byte(m), nil
 	case error:
 		if m == nil {
-			return writeBytes(w, tag, Terr, nil)
+			return Terr, nil, nil
 		}
-		return writeBytes(w, tag, Terr, []byte(m.Error()))
+		return Terr, []byte(m.Error()), nil
 	case io.WriterTo:
 		var buf bytes.Buffer
-		n, err := m.WriteTo(&buf)
-		if err != nil {
-			return n, fmt.Errorf("%s: %s", ErrIO, err)
+		if _, err := m.WriteTo(&buf); err != nil {
+			return 0, nil, fmt.Errorf("%s: %s", ErrIO, err)
 		}
 		typ := Tign
 		if ti, ok := m.(Typer); ok {
 			typ = ti.TypeId()
 		}
-		return writeBytes(w, tag, typ, buf.Bytes())
+		return typ, buf.Bytes(), nil
 	case fmt.Stringer:
 		typ := Tign
 		if ti, ok := m.(Typer); ok {
 			typ = ti.TypeId()
 		}
-		return writeBytes(w, tag, typ, []byte(m.String()))
+		return typ, []byte(m.String()), nil
 	}
-	return 0, ErrDiscarded
+	return 0, nil, ErrDiscarded
 }
 
 func decHdr(hdr []byte) (int, uint32, uint16) {
@@ -217,20 +229,28 @@ func ReadMsg(r io.Reader) (n int, tag uint32, m face{}, err error) {
 	} else {
 		sz += hdrSz
 	}
+	if typ == Tzip {
+		if typ, b, err = unzip(b); err != nil {
+			return sz, tag, nil, err
+		}
+	}
+	m, err = unpackMsg(typ, b)
+	return sz, tag, m, err
+}
+
+func unpackMsg(typ uint16, b []byte) (face{}, error) {
 	switch typ {
 	case Tbytes:
-		return sz, tag, b, nil
+		return b, nil
 	case Tstr:
-		return sz, tag, string(b), nil
+		return string(b), nil
 	case Terr:
-		err := errors.New(string(b))
-		return sz, tag, err, nil
+		return errors.New(string(b)), nil
 	default:
 		if mk := unpackers[typ]; mk != nil {
-			m, err = mk.Unpack(b)
-			return sz, tag, m, err
+			return mk.Unpack(b)
 		}
-		return sz, tag, Ign{typ, b}, nil
+		return Ign{typ, b}, nil
 	}
 }
 
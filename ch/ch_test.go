@@ -541,6 +541,105 @@ func TestMuxRpc(t *testing.T) {
 	wg.Wait()
 }
 
+func TestMuxTrace(t *testing.T) {
+	m1, m2, _ := NewMuxPair()
+	m1.Tag = "m1"
+	m1.Debug = testing.Verbose()
+	printf := m1.Dprintf
+	m2.Tag = "m2"
+	m2.Debug = testing.Verbose()
+
+	go func() {
+		for c := range m2.In {
+			printf("%s call trace '%s'\n", m2.Tag, c.Trace)
+			for d := range c.In {
+				c.Out <- fmt.Sprintf("%s %v", c.Trace, d)
+			}
+			close(c.Out)
+		}
+	}()
+	traces := []string{"a trace", "", "another"}
+	for _, tr := range traces {
+		r := m1.TracedRpc(tr)
+		r.Out <- "req"
+		close(r.Out)
+		rs := []string{}
+		for s := range r.In {
+			printf("got reply %v\n", s)
+			rs = append(rs, s.(string))
+		}
+		if len(rs) != 1 || rs[0] != tr+" req" {
+			t.Fatalf("bad replies %q", rs)
+		}
+	}
+	m1.Close()
+	m2.Close()
+}
+
+func TestMuxZip(t *testing.T) {
+	m1, m2, _ := NewMuxPair()
+	m1.Tag = "m1"
+	m1.Debug = testing.Verbose()
+	printf := m1.Dprintf
+	m2.Tag = "m2"
+	m2.Debug = testing.Verbose()
+	if err := m1.Compress("none"); err == nil {
+		t.Fatal("unknown compression did not fail")
+	}
+	if a := PickZip("lz4", "snappy", "flate"); a != "snappy" {
+		t.Fatalf("picked '%s'", a)
+	}
+	if err := m2.Compress("zstd"); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for c := range m2.In {
+			for d := range c.In {
+				c.Out <- d
+			}
+			close(c.Out)
+		}
+	}()
+	big := []byte(strings.Repeat("some text to compress ", 1000))
+	msgs := []face{}{"small", big, strings.Repeat("x", 2*zipMin), big[:10]}
+	r := m1.Rpc()
+	for _, m := range msgs {
+		r.Out <- m
+	}
+	close(r.Out)
+	n := 0
+	for m := range r.In {
+		printf("got reply %T\n", m)
+		if n >= len(msgs) || fmt.Sprint(m) != fmt.Sprint(msgs[n]) {
+			t.Fatalf("bad reply %d", n)
+		}
+		n++
+	}
+	if n != len(msgs) {
+		t.Fatalf("got %d replies", n)
+	}
+	for _, alg := range ZipAlgs {
+		var buf bytes.Buffer
+		z, err := newZipper(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := z.zip(Tbytes, big)
+		if b == nil || len(b) >= len(big) {
+			t.Fatalf("%s: did not compress", alg)
+		}
+		printf("%s: %d -> %d bytes\n", alg, len(big), len(b))
+		writeBytes(&buf, 1, Tzip, b)
+		_, _, m, err := ReadMsg(&buf)
+		if err != nil || !bytes.Equal(m.([]byte), big) {
+			t.Fatalf("%s: bad unzip: %v", alg, err)
+		}
+	}
+	m1.Close()
+	m2.Close()
+}
+
 func TestMuxFlow(t *testing.T) {
 	nbuf = 10
 	m1, m2, _ := NewMuxPair()
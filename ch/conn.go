@@ -9,10 +9,13 @@ import (
 // A Conn is a channel-pair used as a duplex connection.
 // The tag may be used for debugging or to convey the address
 // of the other end of the connection.
+// Conns made by a Mux may carry a trace context in Trace
+// (see Mux.TracedRpc).
 struct Conn {
-	Tag string // debug
-	In  <-chan face{}
-	Out chan<- face{}
+	Tag   string // debug
+	Trace string // trace context for requests, if any
+	In    <-chan face{}
+	Out   chan<- face{}
 }
 
 // Creates an io.Pipe with a Conn interface, using channels with
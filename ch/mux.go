@@ -14,17 +14,21 @@ const (
 	// The first tag has the first bit set
 	// The last tag has the end bit set
 	// RPC tags have the rpc bit set
+	// Trace tags carry the trace context for the conn,
+	// before any other message.
 	firsttag uint32 = (1 << (31 - iota))
 	rpctag
 	flowtag
 	endtag
-	tagmask = firsttag | rpctag | flowtag | endtag
+	tracetag
+	tagmask = firsttag | rpctag | flowtag | endtag | tracetag
 )
 
 struct conn {
 	tag     uint32
 	in, out chan face{}
 	flow    chan bool
+	trace   string
 }
 
 interface flusher {
@@ -48,6 +52,7 @@ struct Mux {
 	err  error
 	lk   sync.Mutex // for everything buf for writemsg
 	wlk  sync.Mutex // for writemsg
+	zip  *zipper    // to compress msgs sent, if not nil
 	dbg.Flag
 }
 
@@ -122,6 +127,12 @@ func (m *Mux) Out() Conn {
 // Ask for a channel to send an output stream that expects
 // an input stream as its reply.
 func (m *Mux) Rpc() Conn {
+	return m.TracedRpc("")
+}
+
+// Like Rpc, but the conn carries the given trace context,
+// which is set in the Trace field of the conn at the other end.
+func (m *Mux) TracedRpc(trace string) Conn {
 	m.lk.Lock()
 	defer m.lk.Unlock()
 	if (m.tag+2)&tagmask != 0 {
@@ -132,8 +143,9 @@ func (m *Mux) Rpc() Conn {
 	in := make(chan face{}, nbuf)
 	out := make(chan face{}, nbuf)
 	stag := fmt.Sprintf("%s!%x", m.Tag, tv)
-	uc := Conn{Tag: stag, In: in, Out: out}
+	uc := Conn{Tag: stag, Trace: trace, In: in, Out: out}
 	mc := m.newConn(tv, in, out)
+	mc.trace = trace
 	go m.out(mc, false)
 	return uc
 }
@@ -154,6 +166,15 @@ func (m *Mux) out(mc *conn, isreply bool) {
 	// in the chan buffer.
 	<-mc.flow
 	nmsgs := nbuf / 2
+	if mc.trace != "" && !isreply {
+		m.wlk.Lock()
+		_, err := WriteMsg(m.rw, tag|tracetag, mc.trace)
+		m.wlk.Unlock()
+		m.Dprintf("-> %x trace %s sts %v\n", tag|tracetag, mc.trace, err)
+		if err == nil {
+			tag &^= firsttag
+		}
+	}
 	for {
 		d, ok := <-c
 		if !ok {
@@ -172,7 +193,7 @@ func (m *Mux) out(mc *conn, isreply bool) {
 			panic("mux out nbuf too large")
 		}
 		m.wlk.Lock()
-		_, err := WriteMsg(m.rw, tag, d)
+		_, err := m.writeMsg(tag, d)
 		if err == nil && m.fl != nil {
 			err = m.fl.Flush()
 			if err != nil {
@@ -270,7 +291,12 @@ func (m *Mux) demux() {
 			stag := fmt.Sprintf("%s!%x", m.Tag, tv)
 			in := make(chan face{}, nbuf)
 			m.Dprintf("in<-%x\n", tag)
-			in <- d
+			trace := ""
+			if tag&tracetag != 0 {
+				trace, _ = d.(string)
+			} else {
+				in <- d
+			}
 			mc = m.newConn(tv, in, nil)
 			if tag&rpctag != 0 {
 				mc.out = make(chan face{}, nbuf)
@@ -278,7 +304,7 @@ func (m *Mux) demux() {
 				close(mc.flow)
 			}
 			uin := make(chan face{}, 0)
-			uc := Conn{Tag: stag, In: uin, Out: mc.out}
+			uc := Conn{Tag: stag, Trace: trace, In: uin, Out: mc.out}
 			go m.flowproc(tv, in, uin)
 			m.lk.Unlock()
 			if ok := m.in <- uc; !ok {
@@ -297,6 +323,10 @@ func (m *Mux) demux() {
 			}
 		} else {
 			m.lk.Unlock()
+			if tag&tracetag != 0 {
+				// only for new conns
+				continue
+			}
 			// flow control: If this is a grant, make a ticket for out
 			if tag&flowtag != 0 {
 				m.Dprintf("flow<-%x\n", tag)
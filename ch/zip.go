@@ -0,0 +1,197 @@
+package ch
+
+import (
+	"bytes"
+	"clive/x/github.com/golang/snappy"
+	"clive/x/github.com/klauspost/compress/zstd"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+/*
+	A mux may compress the messages it sends, once both ends agree
+	on it (eg., zx/rzx asks for it during its handshake).
+	Each compressed message is sent as a Tzip message with the format
+
+	alg[1] type[2] data[]
+
+	where alg identifies the algorithm (zstd, snappy, or flate)
+	and data is the compressed data for a message of the given type.
+	Because each message says how it was compressed, the receiver
+	does not need to be told, and can always read Tzip messages.
+	Small messages, and those that do not shrink, are sent as they are.
+*/
+
+const (
+	zipFlate byte = 1 + iota
+	zipSnappy
+	zipZstd
+
+	// messages smaller than this are not compressed
+	zipMin = 512
+)
+
+var (
+	// Compression algorithms known, in order of preference.
+	ZipAlgs = []string{"zstd", "snappy", "flate"}
+
+	zipIds = map[string]byte{"flate": zipFlate, "snappy": zipSnappy, "zstd": zipZstd}
+
+	// zstd decoding is safe for concurrent use, and shared.
+	unzstd, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(MaxMsgSz))
+)
+
+// Return the first of the given algorithms known, or "" if none is.
+func PickZip(algs ...string) string {
+	for _, a := range algs {
+		if _, ok := zipIds[a]; ok {
+			return a
+		}
+	}
+	return ""
+}
+
+struct zipper {
+	id  byte
+	buf bytes.Buffer
+	w   *flate.Writer
+	zw  *zstd.Encoder
+	out []byte
+}
+
+func newZipper(alg string) (*zipper, error) {
+	id, ok := zipIds[alg]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression '%s'", alg)
+	}
+	z := &zipper{id: id}
+	var err error
+	switch id {
+	case zipFlate:
+		z.w, err = flate.NewWriter(&z.buf, flate.BestSpeed)
+	case zipZstd:
+		z.zw, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Return the Tzip data for a message, or nil if it's not worth it.
+// The data returned is valid until the next call.
+func (z *zipper) zip(typ uint16, b []byte) []byte {
+	if len(b) < zipMin {
+		return nil
+	}
+	var hdr [3]byte
+	hdr[0] = z.id
+	binary.LittleEndian.PutUint16(hdr[1:], typ)
+	switch z.id {
+	case zipSnappy:
+		n := 3 + snappy.MaxEncodedLen(len(b))
+		if cap(z.out) < n {
+			z.out = make([]byte, n)
+		}
+		z.out = z.out[:n]
+		copy(z.out, hdr[:])
+		z.out = z.out[:3+len(snappy.Encode(z.out[3:], b))]
+	case zipZstd:
+		z.out = z.zw.EncodeAll(b, append(z.out[:0], hdr[:]...))
+	default:
+		z.buf.Reset()
+		z.buf.Write(hdr[:])
+		z.w.Reset(&z.buf)
+		if _, err := z.w.Write(b); err != nil {
+			return nil
+		}
+		if err := z.w.Close(); err != nil {
+			return nil
+		}
+		z.out = z.buf.Bytes()
+	}
+	if len(z.out) >= len(b) {
+		return nil
+	}
+	return z.out
+}
+
+// Return the type and data for a Tzip message.
+func unzip(b []byte) (uint16, []byte, error) {
+	if len(b) < 3 {
+		return 0, nil, ErrTooSmall
+	}
+	typ := binary.LittleEndian.Uint16(b[1:])
+	var dat []byte
+	var err error
+	switch b[0] {
+	case zipFlate:
+		r := flate.NewReader(bytes.NewReader(b[3:]))
+		defer r.Close()
+		dat, err = ioutil.ReadAll(io.LimitReader(r, MaxMsgSz+1))
+	case zipSnappy:
+		var n int
+		if n, err = snappy.DecodedLen(b[3:]); err == nil {
+			if n > MaxMsgSz {
+				return 0, nil, ErrTooLarge
+			}
+			dat, err = snappy.Decode(nil, b[3:])
+		}
+	case zipZstd:
+		if unzstd == nil {
+			return 0, nil, fmt.Errorf("%s: unzip: no zstd decoder", ErrIO)
+		}
+		dat, err = unzstd.DecodeAll(b[3:], nil)
+		if err == zstd.ErrDecoderSizeExceeded || err == zstd.ErrWindowSizeExceeded {
+			return 0, nil, ErrTooLarge
+		}
+	default:
+		return 0, nil, fmt.Errorf("unknown compression %d", b[0])
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: unzip: %s", ErrIO, err)
+	}
+	if len(dat) > MaxMsgSz {
+		return 0, nil, ErrTooLarge
+	}
+	return typ, dat, nil
+}
+
+// Compress messages sent from now on using the given algorithm
+// (one of ZipAlgs), or stop compressing them if alg is "".
+// The other end must know how to read compressed messages,
+// which is not checked here.
+func (m *Mux) Compress(alg string) error {
+	var z *zipper
+	if alg != "" {
+		var err error
+		if z, err = newZipper(alg); err != nil {
+			return err
+		}
+	}
+	m.wlk.Lock()
+	m.zip = z
+	m.wlk.Unlock()
+	return nil
+}
+
+// Write a message, compressing it if we should.
+// Called with m.wlk held.
+func (m *Mux) writeMsg(tag uint32, d face{}) (int64, error) {
+	if m.zip == nil {
+		return WriteMsg(m.rw, tag, d)
+	}
+	typ, b, err := msgBytes(d)
+	if err != nil {
+		return 0, err
+	}
+	if zb := m.zip.zip(typ, b); zb != nil {
+		return writeBytes(m.rw, tag, Tzip, zb)
+	}
+	return writeBytes(m.rw, tag, typ, b)
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"clive/zx"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+/*
+	GetAddr and PutAddr get and replace the part of a file given by
+	a zx.Addr, as printed by gr and used by ix.
+	If the address has a rune range (#p0,#p1), that is used;
+	otherwise its line range (lines ln0 to ln1, both included)
+	is used; an address without ranges refers to the whole file.
+
+	GetAddr stops reading the file after the range, and PutAddr sends
+	only the data from the start of the range on.
+*/
+
+// parts of the file data for an address
+const (
+	addrBefore = iota
+	addrIn
+	addrAfter
+)
+
+var errAddrStop = errors.New("done")
+
+// Return the byte offset in b for rune number n.
+func runeOff(b []byte, n int) int {
+	off := 0
+	for ; n > 0 && off < len(b); n-- {
+		_, sz := utf8.DecodeRune(b[off:])
+		off += sz
+	}
+	return off
+}
+
+// Split the data from c in lines (without breaking bytes) and call fn with
+// the data before, in, and after the range of a.
+// If fn returns false, c is closed and no further data is read.
+// Returns the byte offset for the start of the range.
+func walkAddr(c <-chan []byte, a zx.Addr, fn func(part int, b []byte) bool) (int64, error) {
+	whole := a.P0 == 0 && a.P1 == 0 && a.Ln0 == 0 && a.Ln1 == 0
+	bylns := a.P0 == 0 && a.P1 == 0
+	var off, start int64
+	started := false
+	ln, rn := 0, 0
+	line := func(b []byte) bool {
+		ln++
+		var i0, i1 int
+		switch {
+		case whole:
+			i0, i1 = 0, len(b)
+		case bylns:
+			if ln < a.Ln0 {
+				i0, i1 = len(b), len(b)
+			} else if ln <= a.Ln1 {
+				i0, i1 = 0, len(b)
+			}
+		default:
+			i0, i1 = runeOff(b, a.P0-rn), runeOff(b, a.P1-rn)
+			if i1 < i0 {
+				i1 = i0
+			}
+		}
+		if !started && (i0 < len(b) || i1 > i0) {
+			started = true
+			start = off + int64(i0)
+		}
+		rn += utf8.RuneCount(b)
+		off += int64(len(b))
+		for part, pb := range [][]byte{b[:i0], b[i0:i1], b[i1:]} {
+			if len(pb) > 0 && !fn(part, pb) {
+				return false
+			}
+		}
+		return true
+	}
+	var saved []byte
+	for d := range c {
+		if len(saved) > 0 {
+			d = append(saved, d...)
+			saved = nil
+		}
+		for len(d) > 0 {
+			n := bytes.IndexByte(d, '\n')
+			if n < 0 {
+				saved = append([]byte{}, d...)
+				break
+			}
+			if !line(d[:n+1]) {
+				close(c, errAddrStop)
+				return start, nil
+			}
+			d = d[n+1:]
+		}
+	}
+	if len(saved) > 0 && !line(saved) {
+		return start, nil
+	}
+	if !started {
+		start = off
+	}
+	return start, cerror(c)
+}
+
+// Get the data for the part of the file at path given by a (a.Name is ignored).
+func GetAddr(path string, a zx.Addr) <-chan []byte {
+	dc := Get(path, 0, -1)
+	rc := make(chan []byte)
+	go func() {
+		var err error
+		_, werr := walkAddr(dc, a, func(part int, b []byte) bool {
+			switch part {
+			case addrIn:
+				if ok := rc <- b; !ok {
+					err = cerror(rc)
+					return false
+				}
+			case addrAfter:
+				return false
+			}
+			return true
+		})
+		if err == nil {
+			err = werr
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Replace the part of the file at path given by a (a.Name is ignored)
+// with the data from dc.
+// The rest of the file is kept as it was; the reply is as in Put.
+func PutAddr(path string, a zx.Addr, dc <-chan []byte) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		var tail [][]byte
+		start, err := walkAddr(Get(path, 0, -1), a, func(part int, b []byte) bool {
+			if part == addrAfter {
+				tail = append(tail, b)
+			}
+			return true
+		})
+		if err != nil {
+			close(dc, err)
+			close(rc, err)
+			return
+		}
+		nc := make(chan []byte)
+		go func() {
+			for d := range dc {
+				if ok := nc <- d; !ok {
+					close(dc, cerror(nc))
+					return
+				}
+			}
+			if err := cerror(dc); err != nil {
+				close(nc, err)
+				return
+			}
+			for _, d := range tail {
+				if ok := nc <- d; !ok {
+					return
+				}
+			}
+			close(nc)
+		}()
+		pc := Put(path, zx.Dir{"size": strconv.FormatInt(start, 10)}, start, nc)
+		for d := range pc {
+			rc <- d
+		}
+		close(rc, cerror(pc))
+	}()
+	return rc
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+/*
+	Output sent to UNIX files that are not terminals (pipes, files,
+	or programs like ix reading the output of commands) is stripped
+	of ANSI escape sequences, so that commands may colorize their
+	output when run on a terminal and still produce clean output
+	otherwise.
+	Commands may use IsTTY to decide if it's worth colorizing at all,
+	and SetANSI to change what's done for a chan.
+	If $NO_COLOR is set, escapes are stripped even for terminals.
+*/
+
+// What to do with ANSI escapes in output chans.
+type AnsiMode int32
+
+const (
+	AnsiAuto  AnsiMode = iota // strip them unless the chan goes to a tty
+	AnsiKeep                  // keep them
+	AnsiStrip                 // strip them
+)
+
+// Return a copy of b without ANSI escape sequences, or b itself
+// if there are none.
+func StripANSI(b []byte) []byte {
+	if bytes.IndexByte(b, 0x1b) < 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x1b {
+			out = append(out, b[i])
+			continue
+		}
+		i++
+		if i == len(b) {
+			break
+		}
+		switch b[i] {
+		case '[':
+			// CSI: parameters and a final byte in @-~
+			for i++; i < len(b) && (b[i] < 0x40 || b[i] > 0x7e); i++ {
+			}
+		case ']':
+			// OSC: up to BEL or ESC \
+			for i++; i < len(b); i++ {
+				if b[i] == 0x07 {
+					break
+				}
+				if b[i] == 0x1b && i+1 < len(b) && b[i+1] == '\\' {
+					i++
+					break
+				}
+			}
+		default:
+			// intermediate bytes in space-/ and a final byte
+			for ; i < len(b)-1 && b[i] >= 0x20 && b[i] <= 0x2f; i++ {
+			}
+		}
+	}
+	return out
+}
+
+func (cr *ioChan) stripping() bool {
+	switch AnsiMode(atomic.LoadInt32(&cr.ansi)) {
+	case AnsiKeep:
+		return false
+	case AnsiStrip:
+		return true
+	}
+	return !cr.tty || cr.nocolor
+}
+
+// Return a chan sending what's sent to c, stripping ANSI escapes
+// from []byte messages when cr is doing so.
+func (cr *ioChan) ansiFilter(c <-chan face{}) <-chan face{} {
+	nc := make(chan face{})
+	go func() {
+		for m := range c {
+			if b, ok := m.([]byte); ok && cr.stripping() {
+				m = StripANSI(b)
+			}
+			if ok := nc <- m; !ok {
+				close(c, cerror(nc))
+				break
+			}
+		}
+		close(nc, cerror(c))
+	}()
+	return nc
+}
+
+// Does the named chan of c refer to a terminal?
+func (c *Ctx) IsTTY(name string) bool {
+	c.lk.Lock()
+	io := c.io
+	c.lk.Unlock()
+	cr := io.get(name)
+	return cr != nil && cr.tty
+}
+
+// Does the named chan refer to a terminal?
+func IsTTY(name string) bool {
+	return ctx().IsTTY(name)
+}
+
+// Set what to do with ANSI escapes sent to the named output chan of c.
+func (c *Ctx) SetANSI(name string, m AnsiMode) error {
+	c.lk.Lock()
+	io := c.io
+	c.lk.Unlock()
+	cr := io.get(name)
+	if cr == nil || cr.isIn {
+		return ErrIO
+	}
+	atomic.StoreInt32(&cr.ansi, int32(m))
+	return nil
+}
+
+// Set what to do with ANSI escapes sent to the named output chan.
+func SetANSI(name string, m AnsiMode) error {
+	return ctx().SetANSI(name, m)
+}
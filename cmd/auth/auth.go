@@ -1,12 +1,14 @@
 /*
 	Create authentication keys for Clive.
 
-	usage: auth [-f] [-d adir] name user secret [group...]
+	usage: auth [-f] [-d adir] name user [secret [group...]]
 		-d adir: clive auth dir
 		-f: force write of key file when file already exists
 
 	Creates a key file at the clive auth dir for the authdomain name
 	and user given, containing the key corresponding to the given secret.
+	If no secret is given, it is read from the input, without echo
+	if it's a terminal.
 
 	Under flag -f it rewrites the key file even if it exists.
 */
@@ -22,7 +24,7 @@ import (
 var (
 	dir   string
 	force bool
-	opts  = opt.New("name user secret [group...]")
+	opts  = opt.New("name user [secret [group...]]")
 )
 
 func main() {
@@ -32,11 +34,21 @@ func main() {
 	opts.NewFlag("d", "adir: clive auth dir", &dir)
 	opts.NewFlag("f", "force write of key file when file already exists", &force)
 	args := opts.Parse()
-	if len(args) < 3 {
+	if len(args) < 2 {
 		opts.Usage()
 	}
-	name, user, secret := args[0], args[1], args[2]
-	groups := args[3:]
+	name, user := args[0], args[1]
+	var secret string
+	var groups []string
+	if len(args) > 2 {
+		secret, groups = args[2], args[3:]
+	} else {
+		s, err := cmd.PromptSecret("secret: ")
+		if err != nil {
+			cmd.Fatal("secret: %s", err)
+		}
+		secret = s
+	}
 	file := auth.KeyFile(dir, name)
 	fi, _ := os.Stat(file)
 	if fi != nil && !force {
@@ -42,6 +42,8 @@ import (
 	"bytes"
 	"clive/dbg"
 	"clive/ns"
+	"clive/trace"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -67,6 +69,20 @@ struct Ctx {
 	env *envSet // environment
 	io  *ioSet  // io chans
 
+	trace trace.Ctx // trace context
+
+	job *job // for contexts made by New (see jobs.go)
+
+	parent *Ctx          // the one calling New for this, under ctxlk
+	kids   map[*Ctx]bool // live children, under ctxlk
+
+	cctx  context.Context // see Context
+	log   *Logger         // see Log
+	retry *Retry          // see SetRetry
+	modes *Modes          // see SetModes
+
+	atexit []func() // see AtExit
+
 	Debug, Verb bool
 }
 
@@ -102,15 +118,56 @@ func ctx() *Ctx {
 
 func (c *Ctx) close(sts string) {
 	if c != nil {
+		c.unhandleSigs()
+		c.killKids()
+		c.runAtExit()
+		if c.job != nil && len(c.Args) > 0 {
+			mx.exited(c.Args[0], sts)
+		}
+		c.io.close() // still in ctxs, ioChan.close needs ctx()
+		// gone before its waiters are notified, for Jobs.
+		ctxlk.Lock()
+		delete(ctxs, c.id)
+		if c.parent != nil {
+			delete(c.parent.kids, c)
+		}
+		ctxlk.Unlock()
 		if sts != "" {
 			close(c.wc, sts)
 		} else {
 			close(c.wc)
 		}
-		c.io.close()
-		ctxlk.Lock()
-		delete(ctxs, c.id)
-		ctxlk.Unlock()
+	}
+}
+
+// Make fn run when c exits, before its IO chans are closed.
+// Functions run in the reverse order they were added, after the
+// descendants of c have been killed.
+func (c *Ctx) AtExit(fn func()) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.atexit = append(c.atexit, fn)
+}
+
+// Make fn run when the current command exits, see Ctx.AtExit.
+func AtExit(fn func()) {
+	ctx().AtExit(fn)
+}
+
+func (c *Ctx) runAtExit() {
+	c.lk.Lock()
+	fns := c.atexit
+	c.atexit = nil
+	c.lk.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					dbg.Warn("atexit: %v", r)
+				}
+			}()
+			fns[i]()
+		}()
 	}
 }
 
@@ -126,6 +183,7 @@ func mkCtx() *Ctx {
 	if len(c.Args) > 0 {
 		c.Args[0] = fpath.Base(c.Args[0])
 	}
+	c.log = newLogger(c)
 	ctxlk.Lock()
 	runtime.NewApp() // we use the main AtExit for our main proc
 	c.id = runtime.AppId()
@@ -133,6 +191,7 @@ func mkCtx() *Ctx {
 	ctxlk.Unlock()
 	c.ns = mkNS()
 	runtime.AtExit(func() {
+		c.runAtExit() // if main returns without Exit
 		close(wc)
 	})
 	return c
@@ -142,7 +201,20 @@ func mkCtx() *Ctx {
 // If wc is supplied, the new function won't run until wc is closed and the caller has
 // time to adjust the new context for the function to run, eg. to set the Args, etc.
 // The new conext shares everything with the parent, but for io, which is a dup.
+// The new context is a child of the current one, and it's killed when
+// the parent exits, unless it's detached (see NewDetached and Ctx.Detach).
 func New(fun func(), wc ...chan bool) *Ctx {
+	return newCtx(fun, false, wc...)
+}
+
+// Like New, but the new context is not a child of the current one
+// and it's not killed when the parent exits, eg. for background
+// jobs and for windows made by others that must outlive them.
+func NewDetached(fun func(), wc ...chan bool) *Ctx {
+	return newCtx(fun, true, wc...)
+}
+
+func newCtx(fun func(), detached bool, wc ...chan bool) *Ctx {
 	ctxc := make(chan *Ctx, 1)
 	var w chan bool
 	if wc != nil {
@@ -158,7 +230,11 @@ func New(fun func(), wc ...chan bool) *Ctx {
 		ns := old.ns
 		dot := old.dot
 		dbg, verb := old.Debug, old.Verb
+		tr := old.trace
 		io := old.io.dup()
+		log := old.log
+		retry := old.retry
+		modes := old.modes
 		args := make([]string, len(old.Args))
 		for i := range old.Args {
 			args[i] = old.Args[i]
@@ -166,17 +242,29 @@ func New(fun func(), wc ...chan bool) *Ctx {
 		old.lk.Unlock()
 		wc := make(chan error)
 		c := &Ctx{
-			Args: args,
-			wc:   wc,
-			env:  env,
-			io:   io,
-			dot:  dot,
-			ns:   ns,
+			Args:  args,
+			wc:    wc,
+			env:   env,
+			io:    io,
+			dot:   dot,
+			ns:    ns,
+			trace: tr,
+			retry: retry,
+			modes: modes,
 		}
+		c.log = log.forCtx(c)
 		c.Debug, c.Verb = dbg, verb
 		c.id = runtime.NewApp()
 		ctxlk.Lock()
 		ctxs[c.id] = c
+		c.job = newJob()
+		if !detached {
+			c.parent = old
+			if old.kids == nil {
+				old.kids = map[*Ctx]bool{}
+			}
+			old.kids[c] = true
+		}
 		ctxlk.Unlock()
 		ctxc <- c
 		if w != nil {
@@ -404,6 +492,24 @@ func SetEnv(name, value string) {
 	ctx().SetEnv(name, value)
 }
 
+// Return a chan that gets the names of the given variables (all if
+// none is given) when they are set or unset in the env of c
+// (changes made by any context sharing the env, until ForkEnv).
+// A name is sent just once if the variable changes again before it's
+// received; use GetEnv to get its value.
+// The caller must close the chan when no longer interested.
+func (c *Ctx) WatchEnv(names ...string) <-chan string {
+	c.lk.Lock()
+	e := c.env
+	c.lk.Unlock()
+	return e.watch(names...).c
+}
+
+// Watch changes in the env for the current command (see Ctx.WatchEnv).
+func WatchEnv(names ...string) <-chan string {
+	return ctx().WatchEnv(names...)
+}
+
 // Return a copy of the environment in the format expected by go os.
 func OSEnv() []string {
 	var env []string
@@ -479,8 +585,31 @@ func (c *Ctx) CloseIO(name string) {
 	io.del(name)
 }
 
-func (c *Ctx) Waitc() chan error {
-	return c.wc
+// Return a chan closed with the exit status of c once it exits.
+// If tree is given and true, the chan is closed once c and all
+// its descendants have exited, still with the status of c.
+// Descendants running functions are sent os.Kill through Sigs()
+// when c exits, but they exit only if they handle it, and the chan
+// is not closed until they do.
+func (c *Ctx) Waitc(tree ...bool) chan error {
+	if len(tree) == 0 || !tree[0] {
+		return c.wc
+	}
+	rc := make(chan error)
+	go func() {
+		<-c.wc
+		for {
+			ds := c.descendants()
+			if len(ds) == 0 {
+				break
+			}
+			for _, d := range ds {
+				<-d.wc
+			}
+		}
+		close(rc, cerror(c.wc))
+	}()
+	return rc
 }
 
 func CloseIO(name string) {
@@ -509,6 +638,21 @@ func (c *Ctx) SetOut(name string, ioc chan<- face{}) {
 	io.addOut(name, ioc)
 }
 
+// Send what's sent to the named output chan also to extra,
+// which is closed when the chan is closed.
+// The chan is a fan-out for this context and may be teed to
+// several consumers (eg., the ink viewer and a log file).
+func (c *Ctx) TeeOut(name string, extra chan<- face{}) error {
+	c.lk.Lock()
+	io := c.io
+	c.lk.Unlock()
+	return io.teeOut(name, extra)
+}
+
+func TeeOut(name string, extra chan<- face{}) error {
+	return ctx().TeeOut(name, extra)
+}
+
 func (c *Ctx) cprintf(name, f string, args ...face{}) (n int, err error) {
 	out := c.Out(name)
 	if out == nil {
@@ -537,7 +681,7 @@ func Cprintf(io, f string, args ...face{}) (n int, err error) {
 func Dprintf(f string, args ...face{}) (n int, err error) {
 	c := ctx()
 	if c.Debug {
-		return c.cprintf("err", f, args...)
+		return c.Log().write(DebugLevel, fmt.Sprintf(f, args...))
 	}
 	return 0, nil
 }
@@ -556,7 +700,7 @@ func FlagPrintf(flag *bool) dbg.PrintFunc {
 func VWarn(f string, args ...face{}) (n int, err error) {
 	c := ctx()
 	if c.Verb {
-		return c.cprintf("err", "%s: %s\n", c.Args[0], fmt.Sprintf(f, args...))
+		return c.Log().write(InfoLevel, fmt.Sprintf(f, args...))
 	}
 	return 0, nil
 }
@@ -569,6 +713,8 @@ func SetOut(name string, c chan<- face{}) {
 	ctx().SetOut(name, c)
 }
 
+// Return a chan where interrupts for the process are sent.
+// To handle them (and others) only while a command runs, see HandleSigs.
 func HandleIntr() <-chan os.Signal {
 	sigc := make(chan os.Signal, 16)
 	signal.Notify(sigc, os.Interrupt)
@@ -577,6 +723,8 @@ func HandleIntr() <-chan os.Signal {
 
 func init() {
 	mainctx = mkCtx()
+	initTrace()
+	initDebug()
 	ns.AddLfsPath("/", nil)
 	cdot := GetEnv("dot")
 	if cdot != "" {
@@ -644,7 +792,18 @@ func Fatal(args ...face{}) {
 
 // Printf to stderr, prefixed with app name and terminating with \n.
 // Each warn is atomic.
+// It's logged at the warn level, no matter the logger level (see Log).
 func Warn(f string, args ...face{}) (n int, err error) {
-	c := ctx()
-	return c.cprintf("err", "%s: %s\n", c.Args[0], fmt.Sprintf(f, args...))
+	return ctx().Log().write(WarnLevel, fmt.Sprintf(f, args...))
+}
+
+// Raise an alert for the user.
+// When running within ix, it's sent as "alert:msg" to the "ink" chan and
+// ix shows it in its messages window (and as a browser notification);
+// otherwise it's just a Warn.
+func Alert(f string, args ...face{}) {
+	msg := fmt.Sprintf(f, args...)
+	if _, err := Cprintf("ink", "alert:%s", msg); err != nil {
+		Warn("%s", msg)
+	}
 }
@@ -1,9 +1,22 @@
 package cmd
 
 import (
+	"bytes"
 	"clive/u"
+	"clive/zx"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestCmd(t *testing.T) {
@@ -34,3 +47,672 @@ func TestCmd(t *testing.T) {
 	Warn("ho")
 	close(out)
 }
+
+func TestJobs(t *testing.T) {
+	startc := make(chan bool)
+	c := New(func() {
+		sig := <-Sigs()
+		Exit(sig.String())
+	}, startc)
+	c.Args = []string{"sleeper"}
+	close(startc)
+	n := c.JobId()
+	if n == 0 {
+		t.Fatalf("no job for new ctx")
+	}
+	found := false
+	for _, j := range Jobs() {
+		t.Logf("job %s", j)
+		found = found || j.Id == n && j.Args[0] == "sleeper"
+	}
+	if !found {
+		t.Fatalf("job %d not listed", n)
+	}
+	if jn, err := ParseJob("%" + strconv.Itoa(n)); err != nil || jn != n {
+		t.Fatalf("parse job: %d %v", jn, err)
+	}
+	if err := Signal(n, os.Interrupt); err != nil {
+		t.Fatalf("signal: %s", err)
+	}
+	if err := c.Wait(); err == nil || err.Error() != os.Interrupt.String() {
+		t.Fatalf("bad exit sts %v", err)
+	}
+	if JobCtx(n) != nil {
+		t.Fatalf("job %d still there", n)
+	}
+	if err := Signal(n, os.Interrupt); err == nil {
+		t.Fatalf("could signal a gone job")
+	}
+}
+
+func TestCtxTree(t *testing.T) {
+	kidc := make(chan *Ctx, 1)
+	startc := make(chan bool)
+	c := New(func() {
+		kidc <- New(func() {
+			sig := <-Sigs()
+			Exit(sig.String())
+		})
+		Exit("parent done")
+	}, startc)
+	wc := c.Waitc(true)
+	close(startc)
+	kid := <-kidc
+	<-wc
+	if err := cerror(wc); err == nil || err.Error() != "parent done" {
+		t.Fatalf("bad parent sts %v", err)
+	}
+	select {
+	case <-kid.Waitc():
+	default:
+		t.Fatalf("tree wait did not wait for the child")
+	}
+	if err := kid.Wait(); err == nil || err.Error() != os.Kill.String() {
+		t.Fatalf("child not killed: %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	x, cancel := context.WithTimeout(Context(), 100*time.Millisecond)
+	defer cancel()
+	err := WithContext(x, func(x context.Context) error {
+		<-x.Done()
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("bad sts %v", err)
+	}
+	err = WithContext(Context(), func(x context.Context) error {
+		return errors.New("failed")
+	})
+	if err == nil || err.Error() != "failed" {
+		t.Fatalf("bad sts %v", err)
+	}
+	var cx context.Context
+	c := New(func() {
+		cx = Context()
+	})
+	c.Wait()
+	select {
+	case <-cx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("context not cancelled on exit")
+	}
+}
+
+struct testSink {
+	lines []string
+}
+
+func (s *testSink) Log(e *LogEntry) error {
+	s.lines = append(s.lines, e.String())
+	return nil
+}
+
+func TestLog(t *testing.T) {
+	s := &testSink{}
+	l := Log()
+	l.SetSinks(s)
+	defer l.SetSinks(ErrSink)
+	l.SetLevel(WarnLevel)
+	defer l.SetLevel(InfoLevel)
+	l.Infof("not shown")
+	l.With("file", "/a", "ln", 3).Warnf("bad %s", "thing")
+	l.Log(ErrorLevel, "failed", "sts", "x")
+	Warn("a warn")
+	app := Args()[0]
+	outs := []string{
+		app + ": bad thing file=/a ln=3\n",
+		app + ": failed sts=x\n",
+		app + ": a warn\n",
+	}
+	if len(s.lines) != len(outs) {
+		t.Fatalf("got %q", s.lines)
+	}
+	for i := range outs {
+		if s.lines[i] != outs[i] {
+			t.Fatalf("got %q; expected %q", s.lines[i], outs[i])
+		}
+	}
+	if lvl, err := ParseLevel("error"); err != nil || lvl != ErrorLevel {
+		t.Fatalf("parse level: %v %v", lvl, err)
+	}
+}
+
+func TestTeeOut(t *testing.T) {
+	a, b := make(chan face{}), make(chan face{})
+	c := New(func() {
+		SetOut("out", a)
+		if err := TeeOut("out", b); err != nil {
+			Exit(err)
+		}
+		Printf("hi\n")
+		Printf("there\n")
+	})
+	outs := make(chan string, 2)
+	for _, oc := range []chan face{}{a, b} {
+		oc := oc
+		go func() {
+			s := ""
+			for m := range oc {
+				if b, ok := m.([]byte); ok {
+					s += string(b)
+				}
+			}
+			outs <- s
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if s := <-outs; s != "hi\nthere\n" {
+			t.Fatalf("got %q", s)
+		}
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	res := make(chan face{})
+	c := New(func() {
+		SetOut("out", res)
+		wc := Pipe(func() {
+			Printf("hola\n")
+			Printf("caracola\n")
+		}, func() {
+			for m := range In("in") {
+				if b, ok := m.([]byte); ok {
+					Out("out") <- bytes.ToUpper(b)
+				}
+			}
+		})
+		<-wc
+		if err := cerror(wc); err != nil {
+			Exit(err)
+		}
+		wc = Pipe(func() {
+			Exit("oops")
+		}, func() {
+			in := In("in")
+			for range in {
+			}
+			if err := cerror(in); err == nil || err.Error() != "oops" {
+				Exit("no error from the previous stage")
+			}
+		})
+		<-wc
+		if err := cerror(wc); err == nil || err.Error() != "oops" {
+			Exit(fmt.Sprintf("bad pipe sts %v", err))
+		}
+	})
+	s := ""
+	for m := range res {
+		if b, ok := m.([]byte); ok {
+			s += string(b)
+		}
+	}
+	if s != "HOLA\nCARACOLA\n" {
+		t.Fatalf("got %q", s)
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	outs := []string{
+		"plain\n", "plain\n",
+		"\x1b[1;31mred\x1b[0m text\n", "red text\n",
+		"\x1b]0;title\x07x\x1b]2;t\x1b\\y", "xy",
+		"a\x1b(Bb\x1b", "ab",
+	}
+	for i := 0; i < len(outs); i += 2 {
+		if s := string(StripANSI([]byte(outs[i]))); s != outs[i+1] {
+			t.Fatalf("strip %q: got %q", outs[i], s)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tdir := "/tmp/cmd_globtest"
+	os.RemoveAll(tdir)
+	defer os.RemoveAll(tdir)
+	os.MkdirAll(tdir+"/sub/deep", 0755)
+	for _, f := range []string{"a.go", "b.txt", ".h.go", "sub/c.go", "sub/deep/d.go"} {
+		if err := ioutil.WriteFile(tdir+"/"+f, []byte("x\n"), 0644); err != nil {
+			t.Fatalf("create: %s", err)
+		}
+	}
+	outs := []string{
+		"/*.go", "a.go",
+		"/s?b/*", "sub/c.go sub/deep",
+		"/**/*.go", "a.go sub/c.go sub/deep/d.go",
+		"/sub/**", "sub/c.go sub/deep sub/deep/d.go",
+		"/.*.go", ".h.go",
+		"/*.c", "",
+	}
+	for i := 0; i < len(outs); i += 2 {
+		ds, err := Glob(tdir + outs[i])
+		if err != nil {
+			t.Fatalf("glob %s: %s", outs[i], err)
+		}
+		var nms []string
+		for _, d := range ds {
+			nms = append(nms, zx.Suffix(d["path"], tdir)[1:])
+		}
+		if s := strings.Join(nms, " "); s != outs[i+1] {
+			t.Fatalf("glob %s: got %q", outs[i], s)
+		}
+	}
+	if _, err := Glob(tdir + "/[a"); err == nil {
+		t.Fatalf("bad pattern didn't fail")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	fn := "/tmp/cmd_addrtest"
+	defer os.Remove(fn)
+	if err := ioutil.WriteFile(fn, []byte("one\ntwo\nthree\nfour"), 0644); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	get := func(a zx.Addr) string {
+		var buf bytes.Buffer
+		for b := range GetAddr(fn, a) {
+			buf.Write(b)
+		}
+		return buf.String()
+	}
+	outs := []face{}{
+		zx.Addr{}, "one\ntwo\nthree\nfour",
+		zx.Addr{Ln0: 2, Ln1: 3}, "two\nthree\n",
+		zx.Addr{Ln0: 4, Ln1: 4}, "four",
+		zx.Addr{Ln0: 7, Ln1: 9}, "",
+		zx.Addr{P0: 5, P1: 10}, "wo\nth",
+	}
+	for i := 0; i < len(outs); i += 2 {
+		if s := get(outs[i].(zx.Addr)); s != outs[i+1] {
+			t.Fatalf("get %s: got %q", outs[i], s)
+		}
+	}
+	dc := make(chan []byte, 1)
+	dc <- []byte("2\n")
+	close(dc)
+	pc := PutAddr(fn, zx.Addr{Ln0: 2, Ln1: 3}, dc)
+	for range pc {
+	}
+	if err := cerror(pc); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if s := get(zx.Addr{}); s != "one\n2\nfour" {
+		t.Fatalf("put: got %q", s)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	inc := make(chan face{}, 3)
+	inc <- []byte("one\ntw")
+	inc <- "o\r\nthree\n"
+	inc <- []byte("four")
+	close(inc)
+	var lns []string
+	c := New(func() {
+		SetIn("in", inc)
+		for {
+			ln, err := ReadLine()
+			if err != nil {
+				if err != io.EOF {
+					Exit(err)
+				}
+				break
+			}
+			lns = append(lns, ln)
+		}
+	})
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+	if s := strings.Join(lns, "|"); s != "one|two|three|four" {
+		t.Fatalf("got %q", s)
+	}
+}
+
+func TestSigs(t *testing.T) {
+	readyc := make(chan bool)
+	c := New(func() {
+		sc := HandleSigs(syscall.SIGHUP)
+		close(readyc)
+		select {
+		case m := <-sc:
+			if m != "hup" {
+				Exit(fmt.Sprintf("got %v", m))
+			}
+		case <-time.After(5 * time.Second):
+			Exit("no signal")
+		}
+	})
+	<-readyc
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("signal: %s", err)
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+	siglk.Lock()
+	defer siglk.Unlock()
+	if len(sigroutes) != 0 || len(sigcs) != 0 {
+		t.Fatalf("signals still handled")
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	fn := "/tmp/cmd_envtest"
+	defer os.Remove(fn)
+	vars := map[string]string{
+		"a":    "x y",
+		"lst":  ListEnv([]string{"a", "b c"}),
+		"map":  MapEnv(map[string][]string{"k": {"v1", "v2"}}),
+		"crlf": "one\r\ntwo",
+	}
+	if err := writeEnvFile(fn, vars); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	nvars, err := readEnvFile(fn)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if len(nvars) != len(vars) {
+		t.Fatalf("got %v", nvars)
+	}
+	for k, v := range vars {
+		if nvars[k] != v {
+			t.Fatalf("%s: got %q", k, nvars[k])
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var r *Retry
+	if r.again(NS(), "/tmp", 1, time.Now(), zx.ErrIO) {
+		t.Fatalf("nil policy did retry")
+	}
+	r = &Retry{Tries: 3, Backoff: time.Millisecond}
+	t0 := time.Now()
+	if !r.again(NS(), "/tmp", 1, t0, zx.ErrIO) || !r.again(NS(), "/tmp", 2, t0, zx.ErrIO) {
+		t.Fatalf("didn't retry")
+	}
+	if r.again(NS(), "/tmp", 3, t0, zx.ErrIO) {
+		t.Fatalf("too many tries")
+	}
+	if r.again(NS(), "/tmp", 1, t0, zx.ErrNotExist) {
+		t.Fatalf("did retry a non i/o error")
+	}
+	r.Timeout = time.Millisecond
+	if r.again(NS(), "/tmp", 1, t0.Add(-time.Second), zx.ErrIO) {
+		t.Fatalf("retry after timeout")
+	}
+	SetRetry(r)
+	defer SetRetry(nil)
+	if _, err := Stat("/tmp"); err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	addr, err := ServeDebug("localhost:0")
+	if err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+	for _, p := range []string{"ctxs", "ns", "pprof/"} {
+		r, err := http.Get("http://" + addr + "/debug/" + p)
+		if err != nil {
+			t.Fatalf("get %s: %s", p, err)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		t.Logf("%s:\n%s", p, b)
+		if r.StatusCode != http.StatusOK || len(b) == 0 {
+			t.Fatalf("get %s: %s", p, r.Status)
+		}
+	}
+}
+
+func TestAtExit(t *testing.T) {
+	var order []string
+	c := New(func() {
+		AtExit(func() {
+			order = append(order, "first")
+		})
+		AtExit(func() {
+			order = append(order, "second")
+			panic("oops")
+		})
+		Exit("bye")
+	})
+	if err := c.Wait(); err == nil || err.Error() != "bye" {
+		t.Fatalf("sts %v", err)
+	}
+	if s := strings.Join(order, " "); s != "second first" {
+		t.Fatalf("got %q", s)
+	}
+}
+
+func TestInFiles(t *testing.T) {
+	inc := make(chan face{}, 6)
+	inc <- []byte("stdin\n")
+	inc <- zx.Dir{"path": "/a", "type": "-"}
+	inc <- []byte("a1\n")
+	inc <- []byte("a2\n")
+	inc <- zx.Dir{"path": "/b", "type": "-"}
+	inc <- []byte("b\n")
+	close(inc)
+	var got []string
+	c := New(func() {
+		SetIn("in", inc)
+		fc := InFiles()
+		for f := range fc {
+			var buf bytes.Buffer
+			for b := range f.Data {
+				buf.Write(b)
+			}
+			got = append(got, f.Dir["path"]+":"+buf.String())
+		}
+		if err := cerror(fc); err != nil {
+			Exit(err)
+		}
+	})
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+	if s := strings.Join(got, "|"); s != "in:stdin\n|/a:a1\na2\n|/b:b\n" {
+		t.Fatalf("got %q", s)
+	}
+}
+
+func TestFindDirs(t *testing.T) {
+	tdir := "/tmp/cmd_findtest"
+	os.RemoveAll(tdir)
+	defer os.RemoveAll(tdir)
+	os.MkdirAll(tdir+"/sub/deep", 0755)
+	for _, f := range []string{"a.go", "b.txt", "sub/c.go", "sub/deep/d.go"} {
+		if err := ioutil.WriteFile(tdir+"/"+f, []byte("x\n"), 0644); err != nil {
+			t.Fatalf("create: %s", err)
+		}
+	}
+	find := func(pred string, depth int) string {
+		var nms []string
+		for m := range FindDirs(pred, depth, tdir) {
+			if d, ok := m.(zx.Dir); ok {
+				nms = append(nms, d["Rpath"])
+			}
+		}
+		sort.Strings(nms)
+		return strings.Join(nms, " ")
+	}
+	if s := find("name~*.go", -1); s != "/a.go /sub/c.go /sub/deep/d.go" {
+		t.Fatalf("got %q", s)
+	}
+	if s := find("name~*.go", 1); s != "/a.go" {
+		t.Fatalf("got %q", s)
+	}
+	since := ModifiedSince(time.Now().Add(time.Hour))
+	if s := find("type=-&"+since, -1); s != "" {
+		t.Fatalf("got %q", s)
+	}
+}
+
+func TestStats(t *testing.T) {
+	paths := []string{"/tmp", "/a/file/not/there", "/"}
+	ds, errs := Stats(paths...)
+	if len(ds) != 3 || len(errs) != 3 {
+		t.Fatalf("bad lengths")
+	}
+	if errs[0] != nil || errs[2] != nil || errs[1] == nil {
+		t.Fatalf("bad errors %v", errs)
+	}
+	if ds[0]["Upath"] != "/tmp" || ds[2]["Upath"] != "/" {
+		t.Fatalf("bad order")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	c := New(func() {
+		Stat("/tmp")
+		Exit("oops")
+	})
+	c.Wait()
+	var buf bytes.Buffer
+	mx.writeTo(&buf)
+	s := buf.String()
+	t.Logf("metrics:\n%s", s)
+	for _, m := range []string{
+		`clive_cmd_errors_total{cmd="cmd.test"}`,
+		`clive_zx_seconds_count{op="stat"}`,
+		`clive_zx_seconds_bucket{op="stat",le="+Inf"}`,
+	} {
+		if !strings.Contains(s, m) {
+			t.Fatalf("no %s", m)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	tdir := "/tmp/cmd_modestest"
+	os.RemoveAll(tdir)
+	defer os.RemoveAll(tdir)
+	c := New(func() {
+		SetModes(&Modes{File: 0660, Dir: 0770})
+		SetEnv("umask", "027")
+		defer SetEnv("umask", "")
+		if err := MkDir(tdir+"/a/b", true); err != nil {
+			Exit(err)
+		}
+		if err := PutAll(tdir+"/a/f", []byte("x\n")); err != nil {
+			Exit(err)
+		}
+		if err := PutAll(tdir+"/a/g", []byte("x\n"), "0600"); err != nil {
+			Exit(err)
+		}
+	})
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+	modes := map[string]os.FileMode{"a/b": 0750, "a/f": 0640, "a/g": 0600}
+	for name, mode := range modes {
+		fi, err := os.Stat(tdir + "/" + name)
+		if err != nil {
+			t.Fatalf("stat: %s", err)
+		}
+		if m := fi.Mode().Perm(); m != mode {
+			t.Fatalf("%s: mode %o", name, m)
+		}
+	}
+}
+
+func TestWatchEnv(t *testing.T) {
+	c := New(func() {
+		ForkEnv()
+		wc := WatchEnv("wa", "wb")
+		SetEnv("wx", "x")
+		SetEnv("wa", "1")
+		if n := <-wc; n != "wa" {
+			Exit(fmt.Errorf("got %q", n))
+		}
+		SetEnv("wa", "1") // no change
+		SetEnv("wb", "2")
+		if n := <-wc; n != "wb" {
+			Exit(fmt.Errorf("got %q", n))
+		}
+		SetEnv("wb", "")
+		if n := <-wc; n != "wb" || GetEnv("wb") != "" {
+			Exit(fmt.Errorf("got %q", n))
+		}
+		close(wc)
+		SetEnv("wa", "3")
+	})
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+}
+
+func TestIOTimeout(t *testing.T) {
+	c := New(func() {
+		pc := make(chan face{})
+		SetIn("tin", pc)
+		if err := SetIOTimeout("tin", 100*time.Millisecond); err != nil {
+			Exit(err)
+		}
+		go func() {
+			pc <- []byte("one")
+			// and stall
+		}()
+		in := In("tin")
+		if m := <-in; string(m.([]byte)) != "one" {
+			Exit(fmt.Errorf("got %v", m))
+		}
+		t0 := time.Now()
+		if _, ok := <-in; ok || cerror(in) != ErrTimeout {
+			Exit(fmt.Errorf("no timeout: %v", cerror(in)))
+		}
+		if time.Since(t0) < 50*time.Millisecond {
+			Exit("timeout too soon")
+		}
+		if cerror(pc) != ErrTimeout {
+			Exit("producer not stopped")
+		}
+		if err := SetIOTimeout("nochan", time.Second); err != ErrIO {
+			Exit(fmt.Errorf("nochan: %v", err))
+		}
+	})
+	if err := c.Wait(); err != nil {
+		t.Fatalf("sts %v", err)
+	}
+}
+
+func TestDetachedCtx(t *testing.T) {
+	kidc := make(chan *Ctx, 1)
+	donec := make(chan bool)
+	c := New(func() {
+		kidc <- NewDetached(func() {
+			select {
+			case sig := <-Sigs():
+				Exit(sig.String())
+			case <-donec:
+			}
+		})
+		Exit("parent done")
+	})
+	if err := c.Wait(); err == nil || err.Error() != "parent done" {
+		t.Fatalf("bad parent sts %v", err)
+	}
+	kid := <-kidc
+	select {
+	case <-kid.Waitc():
+		t.Fatalf("detached child exited with its parent: %v", kid.Wait())
+	default:
+	}
+	close(donec)
+	if err := kid.Wait(); err != nil {
+		t.Fatalf("detached child sts %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+)
+
+/*
+	Commands may call libraries expecting a context.Context (eg.,
+	for HTTP or database requests) using the one for their Ctx,
+	which is cancelled when the command exits, or run a function
+	in a new Ctx under a deadline using WithContext, as in
+
+		x, cancel := context.WithTimeout(cmd.Context(), 5*time.Second)
+		defer cancel()
+		err := cmd.WithContext(x, func(x context.Context) error {
+			...
+		})
+*/
+
+// Make x the parent of the context.Context for c; called with c locked.
+func (c *Ctx) setContext(x context.Context) context.Context {
+	cx, cancel := context.WithCancel(x)
+	c.cctx = cx
+	go func() {
+		<-c.wc
+		cancel()
+	}()
+	return cx
+}
+
+// Return a context.Context cancelled when c (or its parent) exits.
+func (c *Ctx) Context() context.Context {
+	c.lk.Lock()
+	cx := c.cctx
+	c.lk.Unlock()
+	if cx != nil {
+		return cx
+	}
+	ctxlk.Lock()
+	p := c.parent
+	ctxlk.Unlock()
+	px := context.Background()
+	if p != nil {
+		px = p.Context()
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.cctx != nil {
+		return c.cctx
+	}
+	return c.setContext(px)
+}
+
+// Return a context.Context cancelled when the current command exits.
+func Context() context.Context {
+	return ctx().Context()
+}
+
+// Run fn in a new Ctx (see New) and wait for it.
+// The Ctx has a context.Context derived from x and that of the
+// current command, and it's also given to fn.
+// If x is done before fn returns, the new Ctx is killed and
+// x.Err() is returned.
+// If fn exits (see Exit), its status is returned.
+func WithContext(x context.Context, fn func(context.Context) error) error {
+	pc := ctx()
+	cx, cancel := context.WithCancel(x)
+	defer cancel()
+	go func() {
+		select {
+		case <-pc.Context().Done():
+			cancel()
+		case <-cx.Done():
+		}
+	}()
+	errc := make(chan error, 1)
+	startc := make(chan bool)
+	c := New(func() {
+		errc <- fn(Context())
+	}, startc)
+	c.lk.Lock()
+	c.setContext(cx)
+	c.lk.Unlock()
+	close(startc)
+	select {
+	case err := <-errc:
+		return err
+	case <-c.wc:
+		select {
+		case err := <-errc:
+			return err
+		default:
+		}
+		return cerror(c.wc)
+	case <-cx.Done():
+		c.Kill()
+		return cx.Err()
+	}
+}
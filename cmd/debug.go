@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bytes"
+	"clive/dbg"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+/*
+	If $clivedebug is set to an address (eg. localhost:6060), the
+	process serves HTTP there to help diagnose it while it runs:
+		/debug/pprof/	the net/http/pprof profiles
+		/debug/ctxs	the contexts, with their jobs and IO chans
+		/debug/ns?ctx=id	the name space for a context (main by default)
+		/debug/vars	expvar variables, including the metrics
+		/metrics	the metrics in Prometheus format (see metrics.go)
+	Nothing is served unless asked for, and there is no authentication,
+	so the address should be a local one.
+	Commands started by the process do not get $clivedebug.
+*/
+
+func initDebug() {
+	if addr := os.Getenv("clivedebug"); addr != "" {
+		mainctx.env.set("clivedebug", "") // not for the commands we run
+		if _, err := ServeDebug(addr); err != nil {
+			dbg.Warn("clivedebug: %s", err)
+		}
+	}
+}
+
+// Serve the debug endpoints at the given address and return
+// the address used (eg., when the port is 0).
+func ServeDebug(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/ctxs", debugCtxs)
+	mux.HandleFunc("/debug/ns", debugNS)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", debugMetrics)
+	go func() {
+		err := http.Serve(l, mux)
+		dbg.Warn("clivedebug: %s", err)
+	}()
+	return l.Addr().String(), nil
+}
+
+type byCtxId []*Ctx
+
+func (b byCtxId) Len() int           { return len(b) }
+func (b byCtxId) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byCtxId) Less(i, j int) bool { return b[i].id < b[j].id }
+
+func allCtxs() []*Ctx {
+	ctxlk.Lock()
+	cs := make([]*Ctx, 0, len(ctxs))
+	for _, c := range ctxs {
+		cs = append(cs, c)
+	}
+	ctxlk.Unlock()
+	sort.Sort(byCtxId(cs))
+	return cs
+}
+
+// Print the state of the IO chans in the set.
+func (io *ioSet) dump(buf *bytes.Buffer) {
+	io.Lock()
+	defer io.Unlock()
+	var names []string
+	for n := range io.set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		cr := io.set[n]
+		cr.Lock()
+		dir, started := "out", cr.outc != nil
+		if cr.isIn {
+			dir, started = "in", cr.inc != nil
+		}
+		fmt.Fprintf(buf, "\t%s\t%s ref %d", n, dir, atomic.LoadInt32(&cr.ref))
+		if cr.uxfd >= 0 {
+			fmt.Fprintf(buf, " fd %d", cr.uxfd)
+			if cr.tty {
+				buf.WriteString(" tty")
+			}
+		}
+		if !started {
+			buf.WriteString(" idle")
+		}
+		if cr.fan != nil {
+			buf.WriteString(" tee")
+		}
+		if cr.tmo != nil {
+			buf.WriteString(" timeout")
+		}
+		cr.Unlock()
+		buf.WriteString("\n")
+	}
+}
+
+func debugCtxs(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	for _, c := range allCtxs() {
+		ctxlk.Lock()
+		pid := int64(0)
+		if c.parent != nil {
+			pid = c.parent.id
+		}
+		ctxlk.Unlock()
+		c.lk.Lock()
+		args := c.Args
+		io := c.io
+		c.lk.Unlock()
+		fmt.Fprintf(&buf, "ctx %d parent %d", c.id, pid)
+		if n := c.JobId(); n != 0 {
+			fmt.Fprintf(&buf, " job %%%d", n)
+		}
+		fmt.Fprintf(&buf, " dot %s: %q\n", c.Dot(), args)
+		io.dump(&buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func debugNS(w http.ResponseWriter, r *http.Request) {
+	c := mainctx
+	if s := r.FormValue("ctx"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		ctxlk.Lock()
+		c = ctxs[id]
+		ctxlk.Unlock()
+		if err != nil || c == nil {
+			http.Error(w, "no such context", http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s\n", c.NS())
+}
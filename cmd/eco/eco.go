@@ -12,36 +12,38 @@ import (
 	"clive/cmd/opt"
 )
 
+struct ecoFlags {
+	NoNl bool   `opt:"n" help:"don't add a final newline"`
+	Msgs bool   `opt:"m" help:"issue one message per arg"`
+	Ux   bool   `opt:"u" help:"use unix out"`
+	Out  string `opt:"o" help:"chan: output to this chan (for testing other tools)"`
+	In   string `opt:"i" help:"chan: echo input from this chan (for testing other tools)"`
+}
+
 var (
-	nflag, mflag bool
-	ux           bool
-	oname        = "out"
-	iname        string
-	opts         = opt.New("{arg}")
+	flags ecoFlags
+	opts  = opt.New("{arg}")
 )
 
 // Run echo in the current app context.
 func main() {
 	cmd.UnixIO("err")
 	c := cmd.AppCtx()
+	flags.Out = "out"
 	opts.NewFlag("D", "debug", &c.Debug)
-	opts.NewFlag("n", "don't add a final newline", &nflag)
-	opts.NewFlag("m", "issue one message per arg", &mflag)
-	opts.NewFlag("u", "use unix out", &ux)
-	opts.NewFlag("o", "chan: output to this chan (for testing other tools)", &oname)
-	opts.NewFlag("i", "chan: echo input from this chan (for testing other tools)", &iname)
+	opts.Bind(&flags)
 	args := opts.Parse()
-	if ux {
-		cmd.UnixIO(oname)
+	if flags.Ux {
+		cmd.UnixIO(flags.Out)
 	}
 	var b bytes.Buffer
-	out := cmd.Out(oname)
+	out := cmd.Out(flags.Out)
 	if out == nil {
-		cmd.Fatal("no output chan '%s'", oname)
+		cmd.Fatal("no output chan '%s'", flags.Out)
 	}
-	mflag = mflag || iname != ""
+	flags.Msgs = flags.Msgs || flags.In != ""
 	for i, arg := range args {
-		if mflag {
+		if flags.Msgs {
 			ok := out <- []byte(arg)
 			if !ok {
 				cmd.Fatal("out: %s", cerror(out))
@@ -53,8 +55,8 @@ func main() {
 			}
 		}
 	}
-	if iname != "" {
-		for x := range cmd.In(iname) {
+	if flags.In != "" {
+		for x := range cmd.In(flags.In) {
 			x := x
 			if b, ok := x.([]byte); ok {
 				ok := out <- []byte(b)
@@ -64,10 +66,10 @@ func main() {
 			}
 		}
 	}
-	if mflag {
+	if flags.Msgs {
 		cmd.Exit(nil)
 	}
-	if !nflag {
+	if !flags.NoNl {
 		b.WriteString("\n")
 	}
 	ok := out <- b.Bytes()
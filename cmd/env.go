@@ -7,10 +7,20 @@ import (
 )
 
 struct envSet {
-	vars map[string]string
+	vars    map[string]string
+	watches map[*envWatch]bool
 	sync.Mutex
 }
 
+// A request to be notified of changes in variables of an env.
+struct envWatch {
+	names   map[string]bool // nil means all
+	c       chan string
+	kickc   chan bool
+	lk      sync.Mutex
+	pending []string
+}
+
 // Initialize a new env from the os
 func osenv() map[string]string {
 	env := map[string]string{}
@@ -26,12 +36,79 @@ func osenv() map[string]string {
 func (e *envSet) set(n, v string) {
 	e.Lock()
 	defer e.Unlock()
+	old, had := e.vars[n]
 	if v == "" {
 		delete(e.vars, n)
 	} else {
 		e.vars[n] = v
 	}
 	os.Setenv(n, v) // in case someone execs...
+	if had == (v != "") && old == v {
+		return
+	}
+	for w := range e.watches {
+		if w.names == nil || w.names[n] {
+			w.post(n)
+		}
+	}
+}
+
+// Start watching the given variables (all if none given) in e.
+func (e *envSet) watch(names ...string) *envWatch {
+	w := &envWatch{
+		c:     make(chan string),
+		kickc: make(chan bool, 1),
+	}
+	if len(names) > 0 {
+		w.names = map[string]bool{}
+		for _, n := range names {
+			w.names[n] = true
+		}
+	}
+	e.Lock()
+	if e.watches == nil {
+		e.watches = map[*envWatch]bool{}
+	}
+	e.watches[w] = true
+	e.Unlock()
+	go w.deliver(e)
+	return w
+}
+
+// Queue the name of a changed variable, unless it's already
+// pending, so that set does not block on slow receivers.
+func (w *envWatch) post(n string) {
+	w.lk.Lock()
+	for _, p := range w.pending {
+		if p == n {
+			w.lk.Unlock()
+			return
+		}
+	}
+	w.pending = append(w.pending, n)
+	w.lk.Unlock()
+	select {
+	case w.kickc <- true:
+	default:
+	}
+}
+
+// Send the names posted until the receiver closes the chan.
+func (w *envWatch) deliver(e *envSet) {
+	for range w.kickc {
+		w.lk.Lock()
+		names := w.pending
+		w.pending = nil
+		w.lk.Unlock()
+		for _, n := range names {
+			if ok := w.c <- n; !ok {
+				e.Lock()
+				delete(e.watches, w)
+				e.Unlock()
+				return
+			}
+		}
+	}
 }
 
 func (e *envSet) get(n string) string {
@@ -50,6 +127,7 @@ func mkEnv() *envSet {
 	return ne
 }
 
+// Watches are not copied: they are for the env they were made for.
 func (e *envSet) dup() *envSet {
 	e.Lock()
 	defer e.Unlock()
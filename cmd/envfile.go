@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"clive/net/auth"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	SaveEnv and LoadEnv keep environment variables in a file at the
+	auth key dir, so that ix and ql may restore them across sessions
+	instead of starting just with the OS environment.
+	The file has a line "name=value" per variable, the value quoted
+	as a Go string, so that lists and maps keep their encoding.
+*/
+
+// Return the path for the file used by SaveEnv and LoadEnv.
+func EnvFile() string {
+	return fpath.Join(auth.KeyDir(), "clive.env")
+}
+
+func readEnvFile(fn string) (map[string]string, error) {
+	vars := map[string]string{}
+	fd, err := os.Open(fn)
+	if err != nil {
+		return vars, err
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for nln := 1; scn.Scan(); nln++ {
+		ln := strings.TrimSpace(scn.Text())
+		if ln == "" || ln[0] == '#' {
+			continue
+		}
+		toks := strings.SplitN(ln, "=", 2)
+		if len(toks) != 2 {
+			return vars, fmt.Errorf("%s:%d: no '='", fn, nln)
+		}
+		v, err := strconv.Unquote(toks[1])
+		if err != nil {
+			return vars, fmt.Errorf("%s:%d: %s", fn, nln, err)
+		}
+		vars[toks[0]] = v
+	}
+	return vars, scn.Err()
+}
+
+func writeEnvFile(fn string, vars map[string]string) error {
+	var names []string
+	for n := range vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&buf, "%s=%s\n", n, strconv.Quote(vars[n]))
+	}
+	if err := os.MkdirAll(fpath.Dir(fn), 0700); err != nil {
+		return err
+	}
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// Save the named variables of c (all if none given) in EnvFile(),
+// keeping other variables already saved there.
+// Named variables that are not set are removed from the file.
+func (c *Ctx) SaveEnv(names ...string) error {
+	fn := EnvFile()
+	vars, err := readEnvFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.lk.Lock()
+	e := c.env
+	c.lk.Unlock()
+	e.Lock()
+	if len(names) == 0 {
+		for n := range e.vars {
+			names = append(names, n)
+		}
+	}
+	for _, n := range names {
+		if v, ok := e.vars[n]; ok {
+			vars[n] = v
+		} else {
+			delete(vars, n)
+		}
+	}
+	e.Unlock()
+	return writeEnvFile(fn, vars)
+}
+
+// Save the named variables (all if none given) in EnvFile().
+func SaveEnv(names ...string) error {
+	return ctx().SaveEnv(names...)
+}
+
+// Set in the env of c the variables saved in EnvFile().
+// It's not an error if there is no such file.
+func (c *Ctx) LoadEnv() error {
+	vars, err := readEnvFile(EnvFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for n, v := range vars {
+		c.SetEnv(n, v)
+	}
+	return nil
+}
+
+// Set the variables saved in EnvFile().
+func LoadEnv() error {
+	return ctx().LoadEnv()
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"clive/zx"
+	fpath "path"
+	"strings"
+)
+
+/*
+	Glob expands shell patterns using the name space, not the
+	underlying OS, so that patterns work the same for all files
+	no matter where they are mounted from.
+
+	Each path element may use the * ? and [...] of path.Match, and
+	an element ** matches zero or more path elements (any number of
+	directories), as in a/** followed by *.go to find the Go files
+	anywhere under a.
+	A final ** matches everything under the path before it.
+	As in shells, names starting with "." are matched only by
+	elements starting with "." too.
+*/
+
+// Does the path element use pattern characters?
+func isGlob(el string) bool {
+	return strings.ContainsAny(el, "*?[")
+}
+
+// Return the dir entries for the files matching pattern, in the
+// order of a walk of the tree using DirOrder() for each directory.
+// The Upath attribute in the entries mimics the pattern given,
+// and is relative if the pattern is relative.
+// It's not an error if there are no matches.
+// Errors reading directories are ignored, like shells do.
+func Glob(pattern string) ([]zx.Dir, error) {
+	upref := ""
+	if len(pattern) == 0 || pattern[0] != '/' {
+		upref = Dot()
+	}
+	apath := AbsPath(pattern)
+	els := zx.Elems(apath)
+	for _, el := range els {
+		if _, err := fpath.Match(el, ""); err != nil {
+			return nil, err
+		}
+	}
+	root, err := Stat("/")
+	if err != nil {
+		return nil, err
+	}
+	ds := []zx.Dir{root}
+	for i, el := range els {
+		var nds []zx.Dir
+		for _, d := range ds {
+			switch {
+			case el == "**":
+				last := i == len(els)-1
+				if !last {
+					nds = append(nds, d)
+				}
+				nds = globWalk(d, !last, nds)
+			case !isGlob(el):
+				p := fpath.Join(d["path"], el)
+				if nd, err := Stat(p); err == nil {
+					nd["path"] = p
+					nds = append(nds, nd)
+				}
+			case d["type"] == "d":
+				nds = globDir(d, el, nds)
+			}
+		}
+		ds = nds
+		if len(ds) == 0 {
+			return nil, nil
+		}
+	}
+	for _, d := range ds {
+		d["Upath"] = d["path"]
+		if upref != "" && zx.HasPrefix(d["path"], upref) {
+			d["Upath"] = "."
+			if r := zx.Suffix(d["path"], upref); r != "/" {
+				d["Upath"] = r[1:]
+			}
+		}
+		d["Rpath"] = d["Upath"]
+	}
+	return ds, nil
+}
+
+// Append to ds the entries in dir d matching el and return them.
+func globDir(d zx.Dir, el string, ds []zx.Dir) []zx.Dir {
+	cs, err := GetDir(d["path"])
+	if err != nil {
+		Dprintf("glob: %s\n", err)
+		return ds
+	}
+	for _, c := range cs {
+		nm := c["name"]
+		if strings.HasPrefix(nm, ".") && !strings.HasPrefix(el, ".") {
+			continue
+		}
+		if ok, _ := fpath.Match(el, nm); ok {
+			ds = append(ds, c)
+		}
+	}
+	return ds
+}
+
+// Append to ds all entries (or just directories) under d,
+// walking the tree, and return them.
+func globWalk(d zx.Dir, dirsonly bool, ds []zx.Dir) []zx.Dir {
+	if d["type"] != "d" {
+		return ds
+	}
+	cs, err := GetDir(d["path"])
+	if err != nil {
+		Dprintf("glob: %s\n", err)
+		return ds
+	}
+	for _, c := range cs {
+		if strings.HasPrefix(c["name"], ".") {
+			continue
+		}
+		if !dirsonly || c["type"] == "d" {
+			ds = append(ds, c)
+		}
+		ds = globWalk(c, dirsonly, ds)
+	}
+	return ds
+}
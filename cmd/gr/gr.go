@@ -23,12 +23,12 @@ struct rgRep {
 }
 
 var (
-	opts    = opt.New("rexp [rexp]")
+	opts    = opt.New("rexp [rexp] | -r rexp name...")
 	found   bool
 	re, ere *sre.ReProg
 	out     chan<- face{}
 
-	sflag, aflag, mflag, vflag, fflag, lflag, xflag, eflag bool
+	sflag, aflag, mflag, vflag, fflag, lflag, xflag, eflag, rflag bool
 )
 
 // update ql/builtin.go bltin table if new aliases are added or some are removed.
@@ -354,6 +354,41 @@ func fullgr(in <-chan face{}) {
 	}
 }
 
+// Search the named files ("name,pred") where they are,
+// reporting each group of matching lines like -e would.
+func rgr(rexp string, names []string) error {
+	var sts error
+	for _, name := range names {
+		p, pr := cmd.CleanName(name)
+		rc := zx.Grep(cmd.NS(), cmd.AbsPath(p), pr, rexp, 0)
+		var rg *rgRep
+		last := ""
+		for m := range rc {
+			switch d := m.(type) {
+			case zx.Addr:
+				rg = &rgRep{name: d.Name, p0: d.Ln0, p1: d.Ln1}
+			case string:
+				if rg == nil {
+					continue
+				}
+				found = true
+				if (sflag || lflag) && rg.name == last {
+					continue
+				}
+				last = rg.name
+				rg.b.WriteString(d)
+				rgreport(rg)
+				rg = nil
+			}
+		}
+		if err := cerror(rc); err != nil {
+			cmd.Warn("%s: %s", name, err)
+			sts = err
+		}
+	}
+	return sts
+}
+
 func chkFlags() {
 	flgs := []bool{sflag, aflag, mflag, lflag, xflag}
 	n := 0
@@ -362,7 +397,7 @@ func chkFlags() {
 			n++
 		}
 	}
-	if n > 1 {
+	if n > 1 || rflag && (fflag || vflag || eflag) {
 		cmd.Warn("incompatible flags supplied")
 		opts.Usage()
 	}
@@ -381,6 +416,7 @@ func main() {
 	opts.NewFlag("f", "print addresses for matches in full files (like sam)", &fflag)
 	opts.NewFlag("x", "print selections for further editing commands", &xflag)
 	opts.NewFlag("e", "extend regexps to match all the text", &eflag)
+	opts.NewFlag("r", "search the files named by the args after rexp where they are", &rflag)
 	ux := false
 	opts.NewFlag("u", "use unix out", &ux)
 	aliases()
@@ -390,6 +426,24 @@ func main() {
 		cmd.UnixIO("out")
 	}
 	chkFlags()
+	if rflag {
+		if len(args) < 2 {
+			cmd.Warn("wrong number or arguments")
+			opts.Usage()
+		}
+		out = cmd.Out("out")
+		err := rgr(args[0], args[1:])
+		if !found && !xflag {
+			if !sflag && err == nil {
+				cmd.Fatal("no match")
+			}
+			cmd.Exit("no match")
+		}
+		if err != nil {
+			cmd.Exit(err)
+		}
+		return
+	}
 	if len(args) == 0 || len(args) > 2 {
 		cmd.Warn("wrong number or arguments")
 		opts.Usage()
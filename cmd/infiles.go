@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"clive/zx"
+)
+
+/*
+	Filters usually process the files named in their arguments or,
+	if none is given, the in chan, where dir entries are followed by
+	the data for the files.
+	InFiles does that, and sends one InFile per file, so the filter
+	does not need to track file boundaries in the stream.
+*/
+
+// A file for a filter: its dir entry and a chan with its data.
+// The data must be consumed (or the chan closed) before the
+// next file is received.
+struct InFile {
+	Dir  zx.Dir
+	Data <-chan []byte
+}
+
+// Return the files named (as in Files), or those found in the in chan
+// if no name is given.
+// Data in the in chan before any dir entry is sent as a file with a
+// dir entry for the chan named "in", with type "c", as Files does.
+// Errors in the input are reported with Warn, and the returned chan
+// is closed with the last one; other messages are ignored.
+func InFiles(names ...string) <-chan InFile {
+	var in <-chan face{}
+	if len(names) > 0 {
+		in = Files(names...)
+	} else {
+		in = In("in")
+	}
+	rc := make(chan InFile)
+	if in == nil {
+		close(rc, ErrIO)
+		return rc
+	}
+	go func() {
+		var dc chan []byte
+		skip := false // the consumer closed dc
+		var err error
+		newFile := func(d zx.Dir) bool {
+			if dc != nil {
+				close(dc)
+			}
+			dc = make(chan []byte)
+			skip = false
+			if ok := rc <- (InFile{Dir: d, Data: dc}); !ok {
+				close(in, cerror(rc))
+				return false
+			}
+			return true
+		}
+		for m := range in {
+			switch m := m.(type) {
+			case zx.Dir:
+				if !newFile(m) {
+					close(dc, cerror(rc))
+					return
+				}
+			case []byte:
+				if dc == nil {
+					d := zx.Dir{"path": "in", "name": "in",
+						"Upath": "in", "type": "c"}
+					if !newFile(d) {
+						close(dc, cerror(rc))
+						return
+					}
+				}
+				if !skip {
+					if ok := dc <- m; !ok {
+						skip = true
+					}
+				}
+			case error:
+				Warn("%s", m)
+				err = m
+			default:
+				Dprintf("infiles: ignored %T\n", m)
+			}
+		}
+		if ierr := cerror(in); ierr != nil {
+			err = ierr
+		}
+		if dc != nil {
+			close(dc, err)
+		}
+		close(rc, err)
+	}()
+	return rc
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"clive/ch"
+	"clive/cmd/tty"
 	"clive/dbg"
 	"io"
 	"os"
@@ -15,15 +16,28 @@ import (
 
 struct ioChan {
 	sync.Mutex
-	isIn  bool
-	inc   <-chan face{}
-	outc  chan<- face{}
-	donec chan bool
-	fd    io.Closer // will go in the future
-	ref   int32     // <0 means it's never closed.
-	name  string
-	ux    bool
-	uxfd  int
+	isIn    bool
+	inc     <-chan face{}
+	outc    chan<- face{}
+	donec   chan bool
+	fd      io.Closer // will go in the future
+	ref     int32     // <0 means it's never closed.
+	name    string
+	ux      bool
+	uxfd    int
+	fan     *fanOut // for output chans with several consumers
+	tty     bool    // uxfd is a terminal
+	nocolor bool    // $NO_COLOR was set when started
+	ansi    int32   // AnsiMode for output chans, see SetANSI
+	rdlk    sync.Mutex
+	rdbuf   []byte     // data after the last line read by ReadLine
+	tmo     *ioTimeout // for relays made by SetIOTimeout
+}
+
+// Consumers for an output chan, see Ctx.TeeOut.
+struct fanOut {
+	sync.Mutex
+	outs []chan<- face{}
 }
 
 struct ioSet {
@@ -74,6 +88,24 @@ func (cr *ioChan) close() {
 // does not print them.
 // But It's worth considering.
 
+// Return the OS file for a chan with a UNIX fd.
+func (cr *ioChan) file() *os.File {
+	switch cr.uxfd {
+	case 0:
+		return os.Stdin
+	case 1:
+		return os.Stdout
+	case 2:
+		return os.Stderr
+	}
+	if fd, ok := cr.fd.(*os.File); ok {
+		return fd
+	}
+	fd := os.NewFile(uintptr(cr.uxfd), cr.name)
+	cr.fd = fd
+	return fd
+}
+
 func (cr *ioChan) start() {
 	c := make(chan face{})
 	if cr.uxfd < 0 {
@@ -85,18 +117,9 @@ func (cr *ioChan) start() {
 		}
 		return
 	}
-	var fd *os.File
-	switch cr.uxfd {
-	case 0:
-		fd = os.Stdin
-	case 1:
-		fd = os.Stdout
-	case 2:
-		fd = os.Stderr
-	default:
-		fd = os.NewFile(uintptr(cr.uxfd), cr.name)
-		cr.fd = fd
-	}
+	fd := cr.file()
+	cr.tty = tty.IsTTY(fd)
+	cr.nocolor = os.Getenv("NO_COLOR") != ""
 	if cr.isIn {
 		cr.inc = c
 		rfn := ch.ReadMsgs
@@ -104,7 +127,7 @@ func (cr *ioChan) start() {
 			rfn = ch.ReadBytes
 		}
 		go func() {
-			_, _, err := rfn(fd, c)
+			_, _, err := rfn(countReader{fd, &mx.inb}, c)
 			close(c, err)
 		}()
 	} else {
@@ -113,13 +136,13 @@ func (cr *ioChan) start() {
 		cr.donec = donec
 		if cr.ux {
 			go func() {
-				_, _, err := ch.WriteBytes(fd, c)
+				_, _, err := ch.WriteBytes(countWriter{fd, &mx.outb}, cr.ansiFilter(c))
 				close(c, err)
 				close(donec)
 			}()
 		} else {
 			go func() {
-				_, _, err := ch.WriteMsgs(fd, 1, c)
+				_, _, err := ch.WriteMsgs(countWriter{fd, &mx.outb}, 1, cr.ansiFilter(c))
 				close(c, err)
 				close(donec)
 			}()
@@ -188,6 +211,84 @@ func (io *ioSet) addUXOut(name string, fd int) *ioChan {
 	return nc
 }
 
+func (f *fanOut) add(c chan<- face{}) {
+	f.Lock()
+	defer f.Unlock()
+	f.outs = append(f.outs, c)
+}
+
+// Send what's sent to c to all consumers, dropping those that are
+// closed, until c is closed. Then close the extra consumers and
+// release prim, the original chan, and close donec.
+func (f *fanOut) loop(c chan face{}, prim *ioChan, donec chan bool) {
+	for m := range c {
+		f.Lock()
+		outs := f.outs
+		f.Unlock()
+		var live []chan<- face{}
+		for _, oc := range outs {
+			if ok := oc <- m; ok {
+				live = append(live, oc)
+			}
+		}
+		f.Lock()
+		if len(live) < len(outs) {
+			// keep those added while sending
+			f.outs = append(live, f.outs[len(outs):]...)
+		}
+		n := len(f.outs)
+		f.Unlock()
+		if n == 0 {
+			close(c, "no consumers")
+			break
+		}
+	}
+	err := cerror(c)
+	f.Lock()
+	outs := f.outs
+	f.outs = nil
+	f.Unlock()
+	for _, oc := range outs {
+		if oc != prim.outc {
+			close(oc, err)
+		}
+	}
+	prim.close()
+	close(donec)
+}
+
+// Make the named output chan send its messages also to extra.
+// The first time, the chan is replaced with one that sends to the old
+// one and to extra; for this io set, not for others sharing the chan.
+// Extra consumers are closed when the chan is closed.
+func (io *ioSet) teeOut(name string, extra chan<- face{}) error {
+	io.Lock()
+	defer io.Unlock()
+	cr, ok := io.set[name]
+	if !ok || cr.isIn {
+		return ErrIO
+	}
+	if cr.fan == nil {
+		cr.Lock()
+		if cr.outc == nil {
+			cr.start()
+		}
+		outc := cr.outc
+		cr.Unlock()
+		c := make(chan face{})
+		nc := &ioChan{name: name, ref: 1, outc: c, isIn: false, uxfd: -1}
+		nc.inc = make(chan face{})
+		close(nc.inc, "not for input")
+		nc.fan = &fanOut{outs: []chan<- face{}{outc}}
+		nc.donec = make(chan bool)
+		go nc.fan.loop(c, cr, nc.donec)
+		io.set[name] = nc
+		cr = nc
+	}
+	cr.fan.add(extra)
+	return nil
+}
+
 func (io *ioSet) del(name string) {
 	io.Lock()
 	defer io.Unlock()
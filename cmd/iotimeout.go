@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+	An IO chan may have an idle timeout and/or a deadline, so that
+	a command reading from a stalled producer (or writing to a stalled
+	consumer) fails instead of blocking forever.
+
+	The first time, the chan is replaced, for this io set, with
+	one relaying its messages and watching the time spent waiting.
+	When the time is exhausted, both the original chan and the relay
+	are closed with ErrTimeout, which is the error seen by the command
+	(eg., with cerror(In(name)) after a receive fails).
+*/
+
+var ErrTimeout = errors.New("io timeout")
+
+struct ioTimeout {
+	sync.Mutex
+	idle     time.Duration // max time waiting for a message, if not zero
+	deadline time.Time     // time to fail, if not zero
+	kickc    chan bool     // settings changed
+}
+
+// Return a chan to wait for the timeout for the next message (nil if
+// there's no limit) and a func to release it.
+func (t *ioTimeout) timer() (<-chan time.Time, func()) {
+	t.Lock()
+	defer t.Unlock()
+	w := time.Duration(-1)
+	if t.idle > 0 {
+		w = t.idle
+	}
+	if !t.deadline.IsZero() {
+		dl := t.deadline.Sub(time.Now())
+		if dl < 0 {
+			dl = 0
+		}
+		if w < 0 || dl < w {
+			w = dl
+		}
+	}
+	if w < 0 {
+		return nil, func() {}
+	}
+	tm := time.NewTimer(w)
+	return tm.C, func() { tm.Stop() }
+}
+
+func (t *ioTimeout) set(idle time.Duration, deadline time.Time) {
+	t.Lock()
+	t.idle, t.deadline = idle, deadline
+	t.Unlock()
+	select {
+	case t.kickc <- true:
+	default:
+	}
+}
+
+// Relay messages from prim to c, failing if waiting for them takes too long.
+func (t *ioTimeout) relayIn(prim *ioChan, c chan face{}) {
+	in := prim.inc
+	defer prim.close()
+	for {
+		var m face{}
+		var ok bool
+		tc, stop := t.timer()
+		select {
+		case m, ok = <-in:
+		case <-t.kickc:
+			stop()
+			continue
+		case <-tc:
+			close(in, ErrTimeout)
+			close(c, ErrTimeout)
+			return
+		}
+		stop()
+		if !ok {
+			close(c, cerror(in))
+			return
+		}
+		if ok := c <- m; !ok {
+			return
+		}
+	}
+}
+
+// Relay messages from c to prim, failing if sending them takes too long.
+func (t *ioTimeout) relayOut(prim *ioChan, c chan face{}, donec chan bool) {
+	out := prim.outc
+	defer close(donec)
+	defer prim.close()
+	for m := range c {
+		for sent := false; !sent; {
+			tc, stop := t.timer()
+			select {
+			case out <- m:
+				sent = true
+			case <-t.kickc:
+			case <-tc:
+				close(out, ErrTimeout)
+				close(c, ErrTimeout)
+				return
+			}
+			stop()
+		}
+		if err := cerror(out); err != nil {
+			close(c, err)
+			return
+		}
+	}
+}
+
+// Set the idle timeout and deadline for the named chan in the set.
+func (io *ioSet) setTimeout(name string, idle time.Duration, deadline time.Time) error {
+	io.Lock()
+	defer io.Unlock()
+	cr, ok := io.set[name]
+	if !ok {
+		return ErrIO
+	}
+	if cr.tmo != nil {
+		cr.tmo.set(idle, deadline)
+		return nil
+	}
+	cr.Lock()
+	if (cr.isIn && cr.inc == nil) || (!cr.isIn && cr.outc == nil) {
+		cr.start()
+	}
+	cr.Unlock()
+	t := &ioTimeout{idle: idle, deadline: deadline, kickc: make(chan bool, 1)}
+	c := make(chan face{})
+	nc := &ioChan{name: name, ref: 1, isIn: cr.isIn, uxfd: -1, tmo: t}
+	if cr.isIn {
+		nc.inc = c
+		nc.outc = make(chan face{})
+		close(nc.outc, "not for output")
+		go t.relayIn(cr, c)
+	} else {
+		nc.outc = c
+		nc.inc = make(chan face{})
+		close(nc.inc, "not for input")
+		nc.donec = make(chan bool)
+		go t.relayOut(cr, c, nc.donec)
+	}
+	io.set[name] = nc
+	return nil
+}
+
+// Make IO on the named chan fail with ErrTimeout if we wait for more
+// than d for a message to be received (for input chans) or
+// sent (for output chans).
+// A zero d removes the timeout.
+// This affects the chan for c and the contexts it creates later,
+// but not for others sharing the chan.
+func (c *Ctx) SetIOTimeout(name string, d time.Duration) error {
+	c.lk.Lock()
+	io := c.io
+	c.lk.Unlock()
+	dl := time.Time{}
+	if t := io.timeout(name); t != nil {
+		t.Lock()
+		dl = t.deadline
+		t.Unlock()
+	}
+	return io.setTimeout(name, d, dl)
+}
+
+func SetIOTimeout(name string, d time.Duration) error {
+	return ctx().SetIOTimeout(name, d)
+}
+
+// Make IO on the named chan fail with ErrTimeout after the given time.
+// A zero time removes the deadline.
+// See SetIOTimeout.
+func (c *Ctx) SetIODeadline(name string, t time.Time) error {
+	c.lk.Lock()
+	io := c.io
+	c.lk.Unlock()
+	idle := time.Duration(0)
+	if tmo := io.timeout(name); tmo != nil {
+		tmo.Lock()
+		idle = tmo.idle
+		tmo.Unlock()
+	}
+	return io.setTimeout(name, idle, t)
+}
+
+func SetIODeadline(name string, t time.Time) error {
+	return ctx().SetIODeadline(name, t)
+}
+
+func (io *ioSet) timeout(name string) *ioTimeout {
+	io.Lock()
+	defer io.Unlock()
+	if cr, ok := io.set[name]; ok {
+		return cr.tmo
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"clive/sre"
+	"clive/zx"
+	"strconv"
+	"strings"
+)
+
+/*
+	Addresses in looks may be those of zx.Addr (:12, :12,20, :#p0,#p1)
+	and also those printed by compilers and grep:
+		:12:34	line 12, column 34 (in runes, starting at 1)
+		:12-20	lines 12 to 20
+		:/regexp/	first match of regexp
+	Trailing colons, as in file.go:12:34:, are ignored.
+*/
+
+// Parse an address for ed, with the syntax described above.
+func (ed *Ed) parseAddr(s string) zx.Addr {
+	s = strings.TrimRight(strings.TrimPrefix(s, ":"), ":")
+	a := zx.Addr{Name: ed.tag}
+	if s == "" {
+		return a
+	}
+	if s[0] == '/' {
+		expr := s[1:]
+		if n := len(expr); n > 0 && expr[n-1] == '/' && (n < 2 || expr[n-2] != '\\') {
+			expr = expr[:n-1]
+		}
+		re, err := sre.CompileStr(expr, sre.Fwd)
+		if err != nil {
+			ed.ix.Warn("%s: %s", s, err)
+			return a
+		}
+		rs := []rune(string(ed.text()))
+		if m := re.ExecRunes(rs, 0, len(rs)); len(m) > 0 {
+			a.P0, a.P1 = m[0].P0, m[0].P1
+			a.Ln0, a.Ln1 = ed.win.LinesAt(a.P0, a.P1)
+		} else {
+			ed.ix.Warn("%s: %s: no match", ed.tag, s)
+		}
+		return a
+	}
+	if i := strings.IndexRune(s, '-'); i > 0 {
+		ln0, err0 := strconv.Atoi(s[:i])
+		ln1, err1 := strconv.Atoi(s[i+1:])
+		if err0 == nil && err1 == nil {
+			a.Ln0, a.Ln1 = ln0, ln1
+			return a
+		}
+	}
+	if els := strings.Split(s, ":"); len(els) == 2 {
+		ln, err0 := strconv.Atoi(els[0])
+		col, err1 := strconv.Atoi(els[1])
+		if err0 == nil && err1 == nil && ln > 0 {
+			p0, p1 := ed.win.LinesOff(ln, ln)
+			p := p0 + col - 1
+			if p < p0 {
+				p = p0
+			}
+			if p > p1 {
+				p = p1
+			}
+			a.P0, a.P1 = p, p
+			return a
+		}
+	}
+	pa := zx.ParseAddr(":" + s)
+	pa.Name = ed.tag
+	return pa
+}
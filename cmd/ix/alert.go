@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*
+	Commands may raise alerts with cmd.Alert, which sends
+	"alert:msg" through their ink chan. Alerts are shown in the
+	messages window followed by an Alert n command that shows
+	where the command was run, and as browser notifications
+	(unless $ixnotify is off, or Alert -n off is used), which
+	do the same when clicked.
+*/
+
+// Max number of alerts kept.
+var AlertLen = 100
+
+struct alert {
+	n    int
+	ed   *Ed    // where the command was run
+	mark string // in ed
+	name string // of the command
+	msg  string
+	t    time.Time
+}
+
+func alertMark(n int) string {
+	return fmt.Sprintf("alert%d", n)
+}
+
+// Raise an alert for the command c.
+func (c *Cmd) alert(msg string) {
+	ed := c.ed
+	off := 0
+	if c.mark != "" {
+		if m := ed.win.Mark(c.mark); m != nil {
+			off = m.Off
+		}
+	}
+	ix.Lock()
+	ix.alertgen++
+	a := &alert{n: ix.alertgen, ed: ed, name: c.name, msg: msg, t: time.Now()}
+	a.mark = alertMark(a.n)
+	ix.alerts = append(ix.alerts, a)
+	var old []*alert
+	if len(ix.alerts) > AlertLen {
+		old = ix.alerts[:len(ix.alerts)-AlertLen]
+		ix.alerts = ix.alerts[len(ix.alerts)-AlertLen:]
+	}
+	notify := !ix.nonotify
+	ix.Unlock()
+	for _, o := range old {
+		o.ed.win.DelMark(o.mark)
+	}
+	ed.win.SetMark(a.mark, off)
+	ix.Warn("%s: %s\n\tAlert %d", a.name, msg, a.n)
+	if notify {
+		ix.pg.Notify("ix: "+a.name, msg, strconv.Itoa(a.n))
+	}
+}
+
+// Show where the command for alert n was run.
+func (ix *IX) showAlert(n int) error {
+	ix.Lock()
+	var a *alert
+	for _, x := range ix.alerts {
+		if x.n == n {
+			a = x
+		}
+	}
+	ix.Unlock()
+	if a == nil {
+		return fmt.Errorf("no alert %d", n)
+	}
+	if ix.goneEd(a.ed) {
+		return fmt.Errorf("alert %d: window is gone", n)
+	}
+	m := a.ed.win.Mark(a.mark)
+	if m == nil {
+		return fmt.Errorf("alert %d: no mark", n)
+	}
+	a.ed.win.Show()
+	a.ed.dot.P0, a.ed.dot.P1 = m.Off, m.Off
+	a.ed.setSel(m.Off, m.Off)
+	return nil
+}
+
+// Alert
+//	list the alerts raised.
+// Alert n
+//	show where the command raising alert n was run.
+// Alert -n on|off
+//	enable or disable browser notifications for alerts.
+func bAlert(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	switch {
+	case len(args) == 1:
+		var buf bytes.Buffer
+		ix.Lock()
+		for _, a := range ix.alerts {
+			fmt.Fprintf(&buf, "%d\t%s\t%s: %s\n", a.n, a.t.Format(time.Stamp), a.name, a.msg)
+		}
+		ix.Unlock()
+		if buf.Len() == 0 {
+			buf.WriteString("no alerts\n")
+		}
+		c.printf("%s", buf.String())
+	case len(args) == 3 && args[1] == "-n" && (args[2] == "on" || args[2] == "off"):
+		ix.Lock()
+		ix.nonotify = args[2] == "off"
+		ix.Unlock()
+	case len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err == nil {
+			err = ix.showAlert(n)
+		}
+		if err != nil {
+			c.printf("Alert: %s\n", err)
+		}
+	default:
+		c.printf("usage: Alert [n | -n on|off]\n")
+	}
+	c.printf("--\n")
+}
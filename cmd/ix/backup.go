@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"clive/cmd"
+	"clive/u"
+	"clive/zx"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path"
+	"strings"
+	"time"
+)
+
+/*
+	Dirty edits are saved every AutosaveIval to a backup file at
+	$ixbackup (or ~/.ix/backup), named after a hash of the file path.
+	The first line of the backup is the file path and the rest is the text.
+	Backups are removed once the file is saved or the edit is clean.
+
+	When ix starts, it looks for backups newer than their files
+	(left by a crash) and suggests using Recover to restore them.
+	If the file is gone, the text is shown in a new dirty window,
+	and the file is created only if that is saved.
+*/
+var AutosaveIval = 30 * time.Second
+
+// Backup for a file.
+struct backup {
+	path  string // the file it's for
+	bpath string // the backup file
+	mtime time.Time
+}
+
+func backupDir() string {
+	if d := cmd.GetEnv("ixbackup"); d != "" {
+		return d
+	}
+	return fpath.Join(u.Home, ".ix", "backup")
+}
+
+func backupPath(path string) string {
+	return fpath.Join(backupDir(), fmt.Sprintf("%x", sha1.Sum([]byte(path))))
+}
+
+// Write a backup with the given text for path.
+func writeBackup(path string, dat []byte) error {
+	dir := backupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	bpath := backupPath(path)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", path)
+	buf.Write(dat)
+	tmp := bpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, bpath)
+}
+
+func removeBackup(path string) {
+	os.Remove(backupPath(path))
+}
+
+// Read a backup, returning the path it's for and the text.
+func readBackup(bpath string) (string, []byte, error) {
+	dat, err := ioutil.ReadFile(bpath)
+	if err != nil {
+		return "", nil, err
+	}
+	n := bytes.IndexByte(dat, '\n')
+	if n <= 0 {
+		return "", nil, fmt.Errorf("%s: not an ix backup", bpath)
+	}
+	return string(dat[:n]), dat[n+1:], nil
+}
+
+// Return the backups found.
+func backups() []backup {
+	dir := backupDir()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var bs []backup
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		bpath := fpath.Join(dir, fi.Name())
+		fd, err := os.Open(bpath)
+		if err != nil {
+			continue
+		}
+		path, err := bufio.NewReader(fd).ReadString('\n')
+		fd.Close()
+		if err != nil || len(path) < 2 {
+			continue
+		}
+		b := backup{path: path[:len(path)-1], bpath: bpath, mtime: fi.ModTime()}
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// Return the text of ed.
+func (ed *Ed) text() []byte {
+	var buf bytes.Buffer
+	for rs := range ed.win.Get(0, -1) {
+		buf.WriteString(string(rs))
+	}
+	return buf.Bytes()
+}
+
+// Start the autosaver for ed, if it's not running.
+func (ed *Ed) autosave() {
+	if ed.temp || ed.iscmd || ed.d == nil || ed.d["type"] != "-" {
+		return
+	}
+	ed.ix.Lock()
+	if ed.autosaving {
+		ed.ix.Unlock()
+		return
+	}
+	ed.autosaving = true
+	ed.ix.Unlock()
+	go ed.autosaver()
+}
+
+func (ed *Ed) autosaver() {
+	path := ed.path()
+	vers := -1
+	defer func() {
+		ed.ix.Lock()
+		ed.autosaving = false
+		ed.ix.Unlock()
+	}()
+	for {
+		time.Sleep(AutosaveIval)
+		if ed.ix.goneEd(ed) {
+			// keep the backup, if any
+			return
+		}
+		if p := ed.path(); p != path {
+			// moved
+			removeBackup(path)
+			path = p
+			vers = -1
+		}
+		if !ed.win.IsDirty() {
+			removeBackup(path)
+			return
+		}
+		if v := ed.win.Vers(); v != vers {
+			if err := writeBackup(path, ed.text()); err != nil {
+				cmd.Warn("autosave %s: %s", path, err)
+				continue
+			}
+			cmd.Dprintf("autosave %s vers %d\n", path, v)
+			vers = v
+		}
+	}
+}
+
+// Look for backups newer than their files and suggest recovering them.
+// Stale backups are removed.
+func (ix *IX) checkBackups() {
+	n := 0
+	bs := backups()
+	paths := make([]string, len(bs))
+	for i, b := range bs {
+		paths[i] = b.path
+	}
+	ds, errs := cmd.Stats(paths...)
+	for i, b := range bs {
+		d, err := ds[i], errs[i]
+		if err == nil && !d.Time("mtime").Before(b.mtime) {
+			cmd.Dprintf("stale backup for %s\n", b.path)
+			os.Remove(b.bpath)
+			continue
+		}
+		ix.Warn("%s: unsaved edits from %s", b.path, b.mtime.Format(time.Stamp))
+		n++
+	}
+	if n > 0 {
+		ix.Warn("use Recover [file...] to restore them, or Recover -d to discard them")
+	}
+}
+
+// Recover [file...]
+//	restore the backups for the named files (all by default)
+//	in their windows, leaving them dirty.
+// Recover -d [file...]
+//	discard the backups instead.
+func bRecover(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	args = args[1:]
+	discard := len(args) > 0 && args[0] == "-d"
+	if discard {
+		args = args[1:]
+	}
+	names := map[string]bool{}
+	for _, a := range args {
+		names[cmd.AbsPath(a)] = true
+	}
+	n := 0
+	for _, b := range backups() {
+		if len(names) > 0 && !names[b.path] {
+			continue
+		}
+		n++
+		if discard {
+			os.Remove(b.bpath)
+			c.printf("%s: backup discarded\n", b.path)
+			continue
+		}
+		if err := c.ed.ix.recoverBackup(b); err != nil {
+			c.printf("%s: %s\n", b.path, err)
+		} else {
+			c.printf("%s: recovered\n", b.path)
+		}
+	}
+	if n == 0 {
+		c.printf("Recover: no backups\n")
+	}
+	c.printf("--\n")
+}
+
+func (ix *IX) recoverBackup(b backup) error {
+	path, dat, err := readBackup(b.bpath)
+	if err != nil {
+		return err
+	}
+	ed := ix.editFor(path)
+	if ed == nil {
+		if _, err := cmd.Stat(path); err != nil {
+			// the file is gone, it's created only if saved
+			ed = ix.newEdit(path)
+			ed.dir = fpath.Dir(path)
+			ed.d = zx.Dir{"type": "-", "path": path, "name": fpath.Base(path)}
+			ed.defLineNbs()
+			ed.winid, _ = ix.pg.AddAt(ed.win, -1)
+		} else {
+			ed = ix.lookFile(path, "", -1)
+		}
+		if ed == nil {
+			return fmt.Errorf("can't edit")
+		}
+	}
+	ed.dot.P0, ed.dot.P1 = 0, ed.win.Len()
+	ed.replDot(string(dat))
+	ed.win.Dirty()
+	ed.autosave()
+	return nil
+}
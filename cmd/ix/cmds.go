@@ -37,6 +37,45 @@ func init() {
 	btab["load"] = bload
 	btab["win"] = bwin
 	btab["rules"] = brules
+	btab["policy"] = bpolicy
+	btab["Edit"] = bEdit
+	btab["Zerox"] = bZerox
+	btab["Recover"] = bRecover
+	btab["Gsearch"] = bGsearch
+	btab["Col"] = bCol
+	btab["Wins"] = bWins
+	btab["Diff"] = bDiff
+	btab["Enc"] = bEnc
+	btab["Hex"] = bHex
+	btab["Ro"] = bRo
+	btab["Indent"] = bindent
+	btab["Unindent"] = bindent
+	btab["Spell"] = bSpell
+	btab["Macro"] = bMacro
+	btab["Term"] = bTerm
+	btab["Mark"] = bMark
+	btab["Goto"] = bMark
+	btab["Back"] = bJump
+	btab["Fwd"] = bJump
+	btab["Quit"] = bQuit
+	btab["Alert"] = bAlert
+	btab["Font"] = bStyle
+	btab["Theme"] = bStyle
+	btab["Diffw"] = bDiffw
+	btab["Addtag"] = bAddtag
+	btab["Repl"] = bRepl
+	btab["Lines"] = bLines
+	btab["Sort"] = bFilter
+	btab["Fmt"] = bFilter
+	btab["Tab"] = bFilter
+	btab["Detab"] = bFilter
+	btab["Preview"] = bURL
+	btab["Browse"] = bURL
+	btab["Def"] = bGopls
+	btab["Refs"] = bGopls
+	btab["Snarf"] = bSnarf
+	btab["Cut"] = bSnarf
+	btab["Paste"] = bSnarf
 }
 
 // NB: All builtins must do a c.ed.win.DelMark(c.mark) once no
@@ -61,9 +100,55 @@ func init() {
 //	X [expr] c	// like x expr c, but apply to all the edit text
 //	. ...	// like x . ... (apply ... to dot)
 //	, ...	// like X . ... (apply ... to all text in dot's edit)
-//	>...	// like . > ...
-//	< ...	// like . > ...
-//	| ...	// like . | ...
+//	>...	// like . > ... (send dot to the command)
+//	<...	// like . < ... (replace dot with the command output)
+//	|...	// like . | ... (send dot to the command and replace it with the output)
+//	Rename old new [file...]	// rename a word, with a review window
+//	policy [spec]	// print or set the run policy for commands in this window
+//	Edit [addr] cmd	// apply sam commands to dot (see edit.go)
+//	Zerox	// open another window for dot's text, with its own dot
+//	Recover [-d] [file...]	// restore (or discard) autosaved edits
+//	Gsearch expr	// search all edits for expr, printing addresses
+//	Col [-c|-e|-m|-n name|col]	// list columns, or move/collapse/expand/maximize dot
+//	Wins [-d|-p|-r] [expr]	// open a live list of windows; look at one to show it
+//	Diff	// open a window with the diff between dot and its file at HEAD
+//	Enc [utf8|latin1|utf16le|utf16be]	// print or set the encoding for dot
+//	Hex [w|off xx...|-d off [n]]	// open a hex dump for dot, or edit and write the one at dot
+//	Ro [on|off]	// print or set the read-only flag for dot
+//	Indent	// add a level of indentation to the lines in dot (see indent.go)
+//	Unindent	// remove a level of indentation from the lines in dot
+//	Spell [-x | -r addr word]	// underline misspelled words in dot, stop, or fix one
+//	Macro start|stop|play [n]	// record edits at dot, or play them at dot n times
+//	Term cmd [arg...]	// run cmd on a tty, sending lines typed after its output (see term.go)
+//	Mark [name]	// set a bookmark at dot, or list them (see jump.go)
+//	Goto name	// show the bookmark and set dot there
+//	Back	// go to the previous dot in the jump list
+//	Fwd	// go to the next dot in the jump list
+//	Quit [-f]	// exit, or list dirty windows to save or discard them (see quit.go)
+//	Alert [n | -n on|off]	// list alerts, show where n was raised, or toggle notifications (see alert.go)
+//	Font [spec]	// print or set the font for dot, eg. t14 or r:Georgia (see font.go)
+//	Theme [name]	// print or set the color theme for dot: light, dark, paper
+//	Diffw win1 [win2]	// open a window with the diff between two windows, or one and its file
+//	Addtag [-d] [verb cmd...] | -r	// list or add verbs for the tag of dot, or reload them (see verbs.go)
+//	Repl [-w|-a] /re/sub/	// replace in dot, its window, or all windows, with a review window
+//	Lines [on|off]	// print or set if line numbers are shown for dot (see pos.go)
+//	Sort [-rnuf]	// sort the lines in dot (see filter.go)
+//	Fmt [width]	// reflow the paragraphs in dot
+//	Tab [n]	// turn leading runs of n spaces in dot into tabs
+//	Detab [n]	// expand tabs in dot to spaces
+//	Preview url	// show url in the Preview panel (see preview.go)
+//	Browse url	// open url in a new browser tab
+//	Def	// show the definition of the Go identifier at dot (see gopls.go)
+//	Refs	// print the references to the Go identifier at dot; look again for the next
+//	Snarf	// copy dot to the snarf buffer, shared with the clipboard (see snarf.go)
+//	Cut	// move dot to the snarf buffer
+//	Paste	// replace dot with the snarf buffer
+//	!!	// run again the last command (see hist.go)
+//	!prefix	// run again the last command starting with prefix
+//
+// Commands run get $% set to the file at dot and $ixdot to the address
+// of dot (eg. /a/file:#3,#5), for the window where they are run if it's
+// a file, or for dot otherwise.
 //
 // builtin() and some of the builtin funcs change the args[] so there is no
 // need to type spaces when using ,>..., >..., |..., etc.
@@ -127,7 +212,7 @@ func bcd(c *Cmd, args ...string) {
 			flds := strings.Split(old, "!")
 			flds = flds[:len(flds)-1]
 			flds = append(flds, cmd.Dot())
-			c.ed.tag = strings.Join(flds, "!")
+			c.ed.setPath(strings.Join(flds, "!"))
 			c.ed.win.SetTag(c.ed.tag)
 		}
 	}
@@ -174,6 +259,50 @@ func brules(c *Cmd, args ...string) {
 	c.ed.win.DelMark(c.mark)
 }
 
+func bpolicy(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	if len(args) > 1 {
+		p, err := run.ParsePolicy(strings.Join(args[1:], " "))
+		if err != nil {
+			c.printf("policy: %s\n", err)
+			c.printf("--\n")
+			return
+		}
+		run.SetPolicy(cmd.AppCtx(), p)
+	}
+	p, err := run.CurrentPolicy()
+	if err != nil {
+		c.printf("policy: %s\n", err)
+	} else if p == nil {
+		c.printf("policy: none\n")
+	} else {
+		c.printf("policy: %s\n", p)
+	}
+	c.printf("--\n")
+}
+
+func bEdit(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	dot := c.ed.ix.dot
+	if dot == nil {
+		c.printf("Edit: no window\n")
+		c.printf("--\n")
+		return
+	}
+	line := strings.TrimSpace(strings.TrimPrefix(c.line, args[0]))
+	if err := dot.samEdit(line, c.printf); err != nil {
+		c.printf("%s\n", err)
+	}
+	c.printf("--\n")
+}
+
+func bZerox(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	if dot := c.ed.ix.dot; dot != nil {
+		dot.ix.zerox(dot)
+	}
+}
+
 func (ix *IX) load1(tag string, nc int) {
 	if strings.HasPrefix(tag, "ql!") {
 		toks := strings.Split(tag, "!")
@@ -191,6 +320,34 @@ func (ix *IX) load(fname string) error {
 		return err
 	}
 	lns := strings.Split(string(dat), "\n")
+	// make the columns first, and then load the windows
+	ncols := len(ix.pg.ColNames())
+	names := map[int]string{}
+	for _, ln := range lns {
+		if ix.loadStyle(ln) {
+			continue
+		}
+		toks := strings.Fields(ln)
+		if len(toks) == 3 && toks[0] == "col" {
+			nc, err := strconv.Atoi(toks[1])
+			if err != nil || nc < 0 {
+				continue
+			}
+			names[nc] = toks[2]
+			toks = toks[1:2]
+		}
+		if len(toks) > 0 {
+			if nc, err := strconv.Atoi(toks[0]); err == nil && nc >= ncols {
+				ncols = nc + 1
+			}
+		}
+	}
+	if ncols > len(ix.pg.ColNames()) {
+		ix.pg.SetNumCols(ncols)
+	}
+	for nc, name := range names {
+		ix.pg.NameCol(nc, name)
+	}
 	for _, ln := range lns {
 		toks := strings.Fields(ln)
 		if len(toks) != 2 {
@@ -222,6 +379,12 @@ func bload(c *Cmd, args ...string) {
 
 func bdump(c *Cmd, args ...string) {
 	var buf bytes.Buffer
+	for i, name := range c.ed.ix.pg.ColNames() {
+		if name != "" {
+			fmt.Fprintf(&buf, "col\t%d\t%s\n", i, name)
+		}
+	}
+	c.ed.ix.dumpStyles(&buf)
 	cols := c.ed.ix.layout()
 	for i, c := range cols {
 		for _, ed := range c {
@@ -322,11 +485,7 @@ func be(c *Cmd, args ...string) {
 
 func bd(c *Cmd, args ...string) {
 	if dot := c.ed.ix.dot; dot != nil && dot != c.ed {
-		if dot.win != nil {
-			dot.win.Close()
-		} else {
-			dot.ix.delEd(dot)
-		}
+		dot.del()
 	}
 	c.ed.win.DelMark(c.mark)
 }
@@ -485,11 +644,16 @@ func (c *Cmd) pipeEdTo(ed *Ed) bool {
 
 func (c *Cmd) pipeTo(eds []*Ed, args ...string) {
 	inkc := make(chan face{})
+	var ded *Ed
+	if len(eds) > 0 {
+		ded = eds[0]
+	}
 	setio := func(c *cmd.Ctx) {
 		c.ForkEnv()
 		c.ForkNS()
 		c.ForkDot()
 		c.SetOut("ink", inkc)
+		setDotEnv(c, ded)
 	}
 	cmd.Dprintf("pipe to %s\n", args)
 	args = append([]string{"ql", "-uc"}, args...)
@@ -632,6 +796,10 @@ func (c *Cmd) inkio(inkc <-chan face{}) {
 			go c.ed.exec(s[5:], "")
 			continue
 		}
+		if strings.HasPrefix(s, "alert:") {
+			go c.alert(s[6:])
+			continue
+		}
 		if strings.HasPrefix(s, "http") || strings.HasPrefix(s, "https") ||
 			strings.HasPrefix(s, "file://") || strings.HasPrefix(s, "//") {
 			nb++
@@ -654,11 +822,13 @@ func (c *Cmd) pipeFrom(eds []*Ed, args ...string) {
 // the output/ink output is shown only if there's some.
 func (c *Cmd) exec(tag string, args ...string) {
 	inkc := make(chan face{})
+	ded := c.ed
 	setio := func(c *cmd.Ctx) {
 		c.ForkEnv()
 		c.ForkNS()
 		c.ForkDot()
 		c.SetOut("ink", inkc)
+		setDotEnv(c, ded)
 	}
 	cmd.Dprintf("exec %s\n", args)
 	ix := c.ed.ix
@@ -713,6 +883,7 @@ func (c *Cmd) pipe(ed *Ed, sendin bool, args ...string) {
 		c.ForkNS()
 		c.ForkDot()
 		c.SetOut("ink", inkc)
+		setDotEnv(c, ed)
 	}
 	cmd.Dprintf("pipe from %s\n", args)
 	args = append([]string{"ql", "-uc"}, args...)
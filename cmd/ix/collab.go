@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+	Several browsers may edit the same window at the same time.
+	ink serializes their edits, reloading views that race with others,
+	and shows in each view the selections of the others, colored and
+	named after their users.
+	The tag of a window lists the users editing it when there's more
+	than one view, and saves are serialized by the ed disk lock
+	(a save with edits made while writing leaves the window dirty).
+*/
+
+// Return the users looking at ed, but for the view gone.
+func (ed *Ed) editors(gone string) []string {
+	var whos []string
+	nviews := 0
+	seen := map[string]bool{}
+	for _, id := range ed.win.Views() {
+		if id == gone {
+			continue
+		}
+		nviews++
+		who := ed.win.Viewer(id)
+		if who == "" {
+			who = "?"
+		}
+		if !seen[who] {
+			seen[who] = true
+			whos = append(whos, who)
+		}
+	}
+	if nviews < 2 {
+		return nil
+	}
+	sort.Strings(whos)
+	return whos
+}
+
+// Update the users shown in the tag of ed after a view
+// started or ended (gone).
+func (ed *Ed) showEditors(gone string) {
+	if ed.iscmd || ed.temp || ed.hex != nil {
+		return
+	}
+	s := ""
+	if whos := ed.editors(gone); len(whos) > 0 {
+		s = " [" + strings.Join(whos, " ") + "]"
+	}
+	if s != ed.editing {
+		ed.editing = s
+		ed.showTag()
+	}
+}
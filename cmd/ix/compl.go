@@ -0,0 +1,119 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/zx"
+	fpath "path"
+	"sort"
+	"strings"
+)
+
+/*
+	Tab in commands windows completes the word before the
+	insertion point. The first word in a line is completed using
+	the commands found in the path, and other words (or those with a /)
+	are completed using the file names in the window's dir.
+	If there's nothing to add and there are several
+	names, they are reported.
+*/
+
+// Return the names in dir (relative to the dir of ed) starting with pref.
+// Directories have a trailing /.
+func (ed *Ed) dirNames(dir, pref string, dirs bool) []string {
+	if !fpath.IsAbs(dir) {
+		dir = fpath.Join(ed.dir, dir)
+	}
+	var names []string
+	dc := cmd.Dirs(dir + ",depth==1")
+	for x := range dc {
+		d, ok := x.(zx.Dir)
+		if !ok || !strings.HasPrefix(d["name"], pref) {
+			continue
+		}
+		if d["type"] == "d" {
+			if dirs {
+				names = append(names, d["name"]+"/")
+			}
+		} else {
+			names = append(names, d["name"])
+		}
+	}
+	return names
+}
+
+// Return the commands in the path starting with pref.
+func (ed *Ed) cmdNames(pref string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, dir := range cmd.Path() {
+		for _, nm := range ed.dirNames(dir, pref, false) {
+			if !seen[nm] {
+				seen[nm] = true
+				names = append(names, nm)
+			}
+		}
+	}
+	return names
+}
+
+// Return the longest common prefix for names.
+func commonPrefix(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pref := []rune(names[0])
+	for _, nm := range names[1:] {
+		for !strings.HasPrefix(nm, string(pref)) {
+			pref = pref[:len(pref)-1]
+		}
+	}
+	return string(pref)
+}
+
+// Complete the word before p.
+func (ed *Ed) complete(p int) {
+	rs := []rune(string(ed.text()))
+	if p < 0 || p > len(rs) {
+		return
+	}
+	s := p
+	for s > 0 && !strings.ContainsRune(" \t\n", rs[s-1]) {
+		s--
+	}
+	first := true
+	for i := s - 1; i >= 0 && rs[i] != '\n'; i-- {
+		if rs[i] != ' ' && rs[i] != '\t' {
+			first = false
+			break
+		}
+	}
+	w := string(rs[s:p])
+	var names []string
+	base := w
+	if first && !strings.Contains(w, "/") {
+		names = ed.cmdNames(w)
+	} else {
+		dir := "."
+		if i := strings.LastIndex(w, "/"); i >= 0 {
+			dir, base = w[:i+1], w[i+1:]
+		}
+		names = ed.dirNames(dir, base, true)
+	}
+	if len(names) == 0 {
+		return
+	}
+	more := []rune(commonPrefix(names)[len(base):])
+	if len(more) == 0 {
+		if len(names) > 1 {
+			sort.Strings(names)
+			ed.ix.Warn("%s: %s", w, strings.Join(names, " "))
+		}
+		return
+	}
+	t := ed.win.GetText()
+	t.Ins(more, p)
+	ed.win.PutText()
+	p += len(more)
+	ed.dot.P0, ed.dot.P1 = p, p
+	ed.setSel(p, p)
+}
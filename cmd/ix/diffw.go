@@ -0,0 +1,213 @@
+package main
+
+import (
+	"clive/cmd"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	fpath "path"
+	"strconv"
+	"strings"
+)
+
+/*
+	Diffw compares the text of two windows (or a window and a file
+	on disk) and shows the diff in a +Diffw window.
+	Looking at a hunk shows it in both windows, setting their dots
+	to the lines changed.
+*/
+
+// A text compared by Diffw.
+struct diffSide {
+	name string
+	ed   *Ed // window for the text, or nil if it's a file on disk
+}
+
+// The two sides of a Diffw window.
+struct diffw {
+	a, b diffSide
+}
+
+// Parse a range in a hunk header, as in -3,4 or +7 (for 7,1).
+func hunkRange(s, pref string) (int, int, bool) {
+	if !strings.HasPrefix(s, pref) {
+		return 0, 0, false
+	}
+	toks := strings.SplitN(s[1:], ",", 2)
+	l0, err := strconv.Atoi(toks[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	n := 1
+	if len(toks) == 2 {
+		if n, err = strconv.Atoi(toks[1]); err != nil {
+			return 0, 0, false
+		}
+	}
+	return l0, n, true
+}
+
+// Parse a hunk header line "@@ -a0,na +b0,nb @@".
+func parseHunk(ln string) (a0, na, b0, nb int, ok bool) {
+	toks := strings.Fields(ln)
+	if len(toks) < 4 || toks[0] != "@@" || toks[3] != "@@" {
+		return 0, 0, 0, 0, false
+	}
+	a0, na, ok = hunkRange(toks[1], "-")
+	if ok {
+		b0, nb, ok = hunkRange(toks[2], "+")
+	}
+	return a0, na, b0, nb, ok
+}
+
+// Return the side for what, a window id, the name of a
+// file being edited, or the name of a file on disk,
+// and its text.
+func (ix *IX) diffSide(what string) (diffSide, []byte, error) {
+	ed := ix.winEd(what)
+	if ed == nil {
+		ed = ix.editFor(cmd.AbsPath(what))
+	}
+	if ed != nil {
+		return diffSide{name: ed.tag, ed: ed}, ed.text(), nil
+	}
+	path := cmd.AbsPath(what)
+	dat, err := cmd.GetAll(path)
+	if err != nil {
+		return diffSide{}, nil, err
+	}
+	return diffSide{name: path}, dat, nil
+}
+
+func tempFile(dat []byte) (string, error) {
+	f, err := ioutil.TempFile("", "ix")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(dat)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Return the diff -u from a to b.
+func diffTexts(a, b string, adat, bdat []byte) (string, error) {
+	af, err := tempFile(adat)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(af)
+	bf, err := tempFile(bdat)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(bf)
+	out, err := exec.Command("diff", "-u", "-L", a, "-L", b, af, bf).Output()
+	if _, ok := err.(*exec.ExitError); ok && len(out) > 0 {
+		// diff exits with 1 when there are differences
+		err = nil
+	}
+	return string(out), err
+}
+
+// Show lines ln0,ln1 of the side s, opening it if it's not in a window.
+func (ix *IX) showSide(s diffSide, ln0, ln1 int) {
+	ed := s.ed
+	if ed == nil || ix.goneEd(ed) {
+		if ed = ix.lookFile(s.name, "", -1); ed == nil {
+			return
+		}
+	}
+	ed.win.Show()
+	if ln1 < ln0 {
+		// nothing on this side, just the place
+		ln1 = ln0
+	}
+	ed.SetAddr(ed.parseAddr(fmt.Sprintf("%d-%d", ln0, ln1)))
+}
+
+// Show in both windows the hunk at p in the Diffw window ed.
+func (ed *Ed) diffLook(p int) {
+	rs := []rune(string(ed.text()))
+	if p > len(rs) {
+		return
+	}
+	for p0 := p; ; {
+		for p0 > 0 && rs[p0-1] != '\n' {
+			p0--
+		}
+		if a0, na, b0, nb, ok := parseHunk(lineText(rs, p0)); ok {
+			if dw := ed.dw; dw.a.ed != nil || dw.a.name != dw.b.name {
+				// not the file on disk for the window at b
+				ed.ix.showSide(dw.a, a0, a0+na-1)
+			}
+			ed.ix.showSide(ed.dw.b, b0, b0+nb-1)
+			return
+		}
+		if p0 == 0 {
+			return
+		}
+		p0--
+	}
+}
+
+// Diffw win1 [win2]
+//	open a window with the diff between the texts of win1 and
+//	win2 (or the file for win1 on disk), given as window ids
+//	or file names. Looking at a hunk shows it in both.
+func bDiffw(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	if len(args) < 2 || len(args) > 3 {
+		c.printf("usage: Diffw win1 [win2]\n--\n")
+		return
+	}
+	a, adat, err := ix.diffSide(args[1])
+	if err != nil {
+		c.printf("Diffw: %s\n--\n", err)
+		return
+	}
+	var b diffSide
+	var bdat []byte
+	if len(args) == 3 {
+		b, bdat, err = ix.diffSide(args[2])
+	} else if a.ed == nil {
+		err = fmt.Errorf("%s: not being edited", a.name)
+	} else {
+		// compare the file with the window for it
+		a, b, bdat = diffSide{name: a.name}, a, adat
+		adat, err = cmd.GetAll(a.name)
+	}
+	if err != nil {
+		c.printf("Diffw: %s\n--\n", err)
+		return
+	}
+	bname := b.name
+	if b.name == a.name {
+		bname += " (edited)"
+	}
+	s, err := diffTexts(a.name, bname, adat, bdat)
+	if err != nil {
+		c.printf("Diffw: %s\n--\n", err)
+		return
+	}
+	if s == "" {
+		c.printf("Diffw: no differences\n--\n")
+		return
+	}
+	ded := ix.newEdit(fpath.Join(c.ed.dir, "+Diffw"))
+	ded.temp = true
+	ded.dw = &diffw{a: a, b: b}
+	ded.win.DoesntGetDirty()
+	ded.winid, _ = ix.pg.Add(ded.win)
+	ded.dot.P0, ded.dot.P1 = 0, ded.win.Len()
+	ded.replDot(s)
+	ded.dot.P0, ded.dot.P1 = 0, 0
+	ded.win.SetSel(0, 0)
+	c.printf("--\n")
+}
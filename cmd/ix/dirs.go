@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"clive/zx"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+	Dir windows start with a line of verbs, which can be
+	executed there to refresh the listing, sort it by name, mtime, or size,
+	toggle dot files, and toggle between one entry per line and names in columns.
+*/
+const dirVerbs = "Refresh Name Mtime Size Dots Cols"
+
+// Width for dir windows listing names in columns.
+var DirWidth = 80
+
+// Options for dir windows.
+struct dirOpts {
+	by     string // "name", "mtime", "size"
+	nodots bool   // hide dot files
+	cols   bool   // list names in columns
+}
+
+type dirsByTime []zx.Dir
+
+func (ds dirsByTime) Len() int      { return len(ds) }
+func (ds dirsByTime) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+func (ds dirsByTime) Less(i, j int) bool {
+	return ds[i].Time("mtime").After(ds[j].Time("mtime"))
+}
+
+type dirsBySize []zx.Dir
+
+func (ds dirsBySize) Len() int           { return len(ds) }
+func (ds dirsBySize) Swap(i, j int)      { ds[i], ds[j] = ds[j], ds[i] }
+func (ds dirsBySize) Less(i, j int) bool { return ds[i].Size() > ds[j].Size() }
+
+// Return the name of d as listed in columns.
+func colName(d zx.Dir) string {
+	if d["type"] == "d" {
+		return d["name"] + "/"
+	}
+	return d["name"]
+}
+
+// List the names in columns, sorted by column, as lc does.
+func listCols(ds []zx.Dir, width int) string {
+	var buf bytes.Buffer
+	w := 1
+	for _, d := range ds {
+		if n := len([]rune(colName(d))) + 1; n > w {
+			w = n
+		}
+	}
+	ncols := width / w
+	if ncols < 1 {
+		ncols = 1
+	}
+	nrows := (len(ds) + ncols - 1) / ncols
+	for r := 0; r < nrows; r++ {
+		for c := 0; c < ncols; c++ {
+			i := c*nrows + r
+			if i >= len(ds) {
+				break
+			}
+			nm := colName(ds[i])
+			if c < ncols-1 && i+nrows < len(ds) {
+				nm += strings.Repeat(" ", w-len([]rune(nm)))
+			}
+			buf.WriteString(nm)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// Return the text for the dir window ed, listing ds.
+func (ed *Ed) dirText(ds []zx.Dir) string {
+	o := ed.dopts
+	if o == nil {
+		o = &dirOpts{by: "name"}
+		ed.dopts = o
+	}
+	var sel []zx.Dir
+	for _, d := range ds {
+		if !o.nodots || !strings.HasPrefix(d["name"], ".") {
+			sel = append(sel, d)
+		}
+	}
+	switch o.by {
+	case "mtime":
+		sort.Stable(dirsByTime(sel))
+	case "size":
+		sort.Stable(dirsBySize(sel))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", dirVerbs)
+	if o.cols {
+		buf.WriteString(listCols(sel, DirWidth))
+	} else {
+		for _, d := range sel {
+			fmt.Fprintf(&buf, "%s\n", d.Fmt())
+		}
+	}
+	return buf.String()
+}
+
+// Run a verb for the dir window ed, if it's one.
+func (ed *Ed) dirVerb(verb string) bool {
+	if ed.iscmd || ed.d["type"] != "d" || !strings.Contains(" "+dirVerbs+" ", " "+verb+" ") {
+		return false
+	}
+	o := ed.dopts
+	if o == nil {
+		o = &dirOpts{by: "name"}
+		ed.dopts = o
+	}
+	switch verb {
+	case "Name", "Mtime", "Size":
+		o.by = strings.ToLower(verb)
+	case "Dots":
+		o.nodots = !o.nodots
+	case "Cols":
+		o.cols = !o.cols
+	}
+	ed.load(nil)
+	return true
+}
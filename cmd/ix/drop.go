@@ -0,0 +1,110 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/u"
+	"clive/zx"
+	"encoding/base64"
+	"fmt"
+	fpath "path"
+	"strconv"
+	"strings"
+)
+
+/*
+	Paths dropped on the page (eg. from a file manager or another
+	window) are opened in the column where they are dropped.
+	Files dropped from outside the namespace are uploaded into
+	$ixuploads (or ~/.ix/uploads) and then opened.
+*/
+
+func uploadDir() string {
+	if d := cmd.GetEnv("ixuploads"); d != "" {
+		return d
+	}
+	return fpath.Join(u.Home, ".ix", "uploads")
+}
+
+// Return the column number for the page column id, or -1.
+func colNb(id string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "column"))
+	if err != nil || !strings.HasPrefix(id, "column") {
+		return -1
+	}
+	return n
+}
+
+// Open the paths dropped on the column with the given id.
+func (ix *IX) dropped(what, col string) {
+	at := colNb(col)
+	for _, ln := range strings.Split(what, "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || ln[0] == '#' {
+			// text/uri-list comments
+			continue
+		}
+		ln = strings.TrimPrefix(ln, "file://")
+		if !strings.HasPrefix(ln, "/") {
+			ix.Warn("drop: %s: not a path", ln)
+			continue
+		}
+		ix.lookFile(ln, "", at)
+	}
+}
+
+// Create dir, and its parents, if it does not exist.
+func mkDir(dir string) error {
+	d, err := cmd.Stat(dir)
+	if err == nil {
+		if d["type"] != "d" {
+			return fmt.Errorf("%s: %s", dir, zx.ErrNotDir)
+		}
+		return nil
+	}
+	if pdir := fpath.Dir(dir); pdir != dir {
+		if err := mkDir(pdir); err != nil {
+			return err
+		}
+	}
+	rc := cmd.Put(dir, zx.Dir{"type": "d", "mode": "0750"}, 0, nil)
+	<-rc
+	return cerror(rc)
+}
+
+// Return a path for name in dir not used by other files.
+func freePath(dir, name string) string {
+	path := fpath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := cmd.Stat(path); err != nil {
+			return path
+		}
+		path = fpath.Join(dir, fmt.Sprintf("%s.%d", name, i))
+	}
+}
+
+// Save a file dropped on the column with the given id in
+// the uploads dir, and open it there.
+func (ix *IX) upload(name, data, col string) {
+	name = fpath.Base(fpath.Clean("/" + name))
+	if name == "/" || name == "." {
+		ix.Warn("upload: bad file name")
+		return
+	}
+	dat, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		ix.Warn("upload: %s: %s", name, err)
+		return
+	}
+	dir := uploadDir()
+	if err := mkDir(dir); err != nil {
+		ix.Warn("upload: %s", err)
+		return
+	}
+	path := freePath(dir, name)
+	if err := cmd.PutAll(path, dat, "0640"); err != nil {
+		ix.Warn("upload: %s: %s", path, err)
+		return
+	}
+	ix.Warn("uploaded %s", path)
+	ix.lookFile(path, "", colNb(col))
+}
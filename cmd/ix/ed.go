@@ -4,8 +4,10 @@ import (
 	"clive/cmd"
 	"clive/cmd/look"
 	"clive/cmd/run"
+	"clive/net/auth"
 	"clive/net/ink"
 	"clive/txt"
+	"clive/u"
 	"clive/zx"
 	"errors"
 	"fmt"
@@ -13,6 +15,7 @@ import (
 	fpath "path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,30 +26,60 @@ struct Cmd {
 	mark  string
 	hasnl bool
 	p     *run.Proc
-	all   bool // replace all text with output, for c.pipe()
+	all   bool   // replace all text with output, for c.pipe()
+	line  string // command line, for builtins parsing it on their own
 }
 
 struct Dot {
 	P0, P1 int
 }
 
-// edit
-struct Ed {
+// Buffer for an edit, shared by all the windows showing it (see Zerox).
+struct edbuf {
 	tag     string
 	dir     string
 	d       zx.Dir
-	dot     Dot
 	ix      *IX
 	win     *ink.Txt
-	winid   string
 	markgen int
 	gone    bool
 	ncmds   int
 	waitc   chan func()
 	ctx     *cmd.Ctx
-	temp    bool    // don't save, don't ever flag as dirty
-	iscmd   bool    // it's a command win, used by the event loop
-	laddr   zx.Addr // last look addr
+	temp    bool       // don't save, don't ever flag as dirty
+	iscmd   bool       // it's a command win, used by the event loop
+	ro      bool       // read-only (see ro.go)
+	spell   bool       // spell checked (see spell.go)
+	rn      *renaming  // pending rename, for Rename review windows
+	wins    *winsel    // options, for Wins windows
+	dopts   *dirOpts   // options, for dir windows
+	hex     *hexed     // bytes, for Hex windows
+	term    *Cmd       // command running on a tty (see term.go)
+	quitrv  bool       // it's a Quit review window (see quit.go)
+	dw      *diffw     // texts compared, for Diffw windows
+	rp      *replacing // pending replace, for Repl review windows
+
+	autosaving bool       // an autosaver is running (see backup.go)
+	conflict   bool       // file changed on disk while dirty (see watch.go)
+	vcs        string     // vcs status shown in the tag (see git.go)
+	editing    string     // users editing, shown in the tag (see collab.go)
+	pos        string     // line:col for dot, shown in the tag (see pos.go)
+	enc        string     // file encoding, "" for utf8 (see enc.go)
+	disklk     sync.Mutex // for saves and checks of the file
+	hist       []string   // lines run, for commands windows (see hist.go)
+	histpos    int        // position in hist for up/down keys
+}
+
+// edit: a window showing a buffer.
+// Zeroxes are other windows for the same buffer, with their own dot.
+struct Ed {
+	*edbuf
+	dot   Dot
+	winid string
+	view  *ink.TxtView  // page element, for zeroxes
+	laddr zx.Addr       // last look addr
+	evcs  []chan string // readers of the event file (see ixfs.go)
+	used  time.Time     // last focused, for Wins
 }
 
 var notDirty = errors.New("not dirty")
@@ -55,32 +88,110 @@ func (d Dot) String() string {
 	return fmt.Sprintf(":#%d,#%d", d.P0, d.P1)
 }
 
+// Remove ed and its zeroxes, the buffer is gone.
 func (ix *IX) delEd(ed *Ed) int {
 	ix.Lock()
 	defer ix.Unlock()
 	ed.gone = true
-	if ix.dot == ed {
+	if ix.dot != nil && ix.dot.edbuf == ed.edbuf {
 		ix.dot = nil
 	}
-	if ix.msgs == ed {
+	if ix.msgs != nil && ix.msgs.edbuf == ed.edbuf {
 		ix.msgs = nil
 		for _, e := range ix.eds {
-			if e != ed && e.iscmd {
+			if e.edbuf != ed.edbuf && e.iscmd {
 				ix.msgs = e
 			}
 		}
 	}
-	for i, e := range ix.eds {
-		if e == ed {
+	for i := 0; i < len(ix.eds); {
+		if e := ix.eds[i]; e.edbuf == ed.edbuf {
 			copy(ix.eds[i:], ix.eds[i+1:])
 			ix.eds = ix.eds[:len(ix.eds)-1]
-			ix.pg.Del(ed.winid)
-			return ed.ncmds
+			ix.pg.Del(e.winid)
+			e.closeEvs()
+		} else {
+			i++
 		}
 	}
 	return ed.ncmds
 }
 
+// Remove the window for ed if there are other windows for its buffer.
+// Return false if it's the only one, and nothing was done.
+func (ix *IX) delZerox(ed *Ed) bool {
+	ix.Lock()
+	defer ix.Unlock()
+	pos, n := -1, 0
+	for i, e := range ix.eds {
+		if e.edbuf == ed.edbuf {
+			n++
+			if e == ed {
+				pos = i
+			}
+		}
+	}
+	if pos < 0 || n < 2 {
+		return false
+	}
+	copy(ix.eds[pos:], ix.eds[pos+1:])
+	ix.eds = ix.eds[:len(ix.eds)-1]
+	for _, e := range ix.eds {
+		if e.edbuf != ed.edbuf {
+			continue
+		}
+		if ix.dot == ed {
+			ix.dot = e
+		}
+		if ix.msgs == ed {
+			ix.msgs = e
+		}
+	}
+	ix.pg.Del(ed.winid)
+	ed.closeEvs()
+	return true
+}
+
+// Delete the window for ed, and its buffer if it's the only one.
+func (ed *Ed) del() {
+	if ed.ix.delZerox(ed) {
+		// other windows remain for the text
+	} else if ed.win != nil {
+		ed.win.Close()
+	} else {
+		ed.ix.delEd(ed)
+	}
+}
+
+// Make a new window for the buffer of ed.
+func (ix *IX) zerox(ed *Ed) *Ed {
+	ed.refreshDot()
+	zed := &Ed{edbuf: ed.edbuf, dot: ed.dot, laddr: ed.laddr}
+	zed.view = ed.win.Zerox()
+	ix.Lock()
+	ix.eds = append(ix.eds, zed)
+	ix.Unlock()
+	zed.winid, _ = ix.pg.Add(zed.view)
+	zed.setSel(zed.dot.P0, zed.dot.P1)
+	return zed
+}
+
+// Return the window for the event, which might be a zerox of ed.
+func (ed *Ed) evEd(ev *ink.Ev) *Ed {
+	el := ed.win.ElemOf(ev.Src)
+	if el == "" || el == ed.winid {
+		return ed
+	}
+	ed.ix.Lock()
+	defer ed.ix.Unlock()
+	for _, e := range ed.ix.eds {
+		if e.edbuf == ed.edbuf && e.winid == el {
+			return e
+		}
+	}
+	return ed
+}
+
 func (ix *IX) addCmd(c *Cmd) {
 	ix.Lock()
 	defer ix.Unlock()
@@ -102,18 +213,48 @@ func (ix *IX) delCmd(c *Cmd) int {
 	return c.ed.ncmds
 }
 
+// Kill the commands running for the buffer of ed, and
+// those they started, so they don't outlive the window.
+func (ix *IX) killCmds(ed *Ed) {
+	ix.Lock()
+	var ps []*run.Proc
+	for _, c := range ix.cmds {
+		if c.ed.edbuf == ed.edbuf && c.p != nil {
+			ps = append(ps, c.p)
+		}
+	}
+	ix.Unlock()
+	for _, p := range ps {
+		p.Kill()
+	}
+}
+
 func (ix *IX) goneEd(ed *Ed) bool {
 	ix.Lock()
 	defer ix.Unlock()
 	return ed.gone
 }
 
+// The path for the file in ed, which may change while autosaves and
+// checks for changes on disk use it.
+func (ed *Ed) path() string {
+	ed.ix.Lock()
+	defer ed.ix.Unlock()
+	return ed.tag
+}
+
+func (ed *Ed) setPath(p string) {
+	ed.ix.Lock()
+	defer ed.ix.Unlock()
+	ed.tag = p
+}
+
 func (ix *IX) newEd(tag string) *Ed {
 	win := ink.NewTxt()
 	win.SetTag(tag)
 	win.ClientDoesUndoRedo()
-	win.SetFont("t")
-	ed := &Ed{win: win, ix: ix, tag: tag, waitc: make(chan func())}
+	ix.setStyle(win, tag)
+	ed := &Ed{edbuf: &edbuf{win: win, ix: ix, tag: tag, waitc: make(chan func())}}
 	ed.dir = cmd.Dot()
 	return ed
 }
@@ -142,6 +283,7 @@ func (ix *IX) newCmds(dir, tag string) *Ed {
 	ed := ix.newEd(tag)
 	ed.temp = true
 	ed.iscmd = true
+	ed.win.Completes()
 	ed.d = zx.Dir{
 		"type": "-",
 		"path": tag,
@@ -159,7 +301,9 @@ func (ix *IX) newCmds(dir, tag string) *Ed {
 	// wait for all outstanding commands to die.
 	// 2. the new windows must have their event loops in the same
 	// context, or changes in the NS/env/... will be gone.
-	ed.ctx = cmd.New(func() {
+	// Windows are made from the context of others, but they
+	// are not their children and must outlive them.
+	ed.ctx = cmd.NewDetached(func() {
 		if err := cmd.Cd(dir); err != nil {
 			go ed.win.Ins([]rune("can't cd to "+dir+": "+err.Error()+"\n"), 0)
 		}
@@ -180,7 +324,7 @@ func (ix *IX) newEdit(path string) *Ed {
 	ix.Lock()
 	defer ix.Unlock()
 	ix.eds = append(ix.eds, ed)
-	ed.ctx = cmd.New(func() {
+	ed.ctx = cmd.NewDetached(func() {
 		cmd.ForkDot()
 		cmd.Cd(fpath.Dir(ed.tag))
 		cmd.Dprintf("edit %s dot %s\n", ed.tag, cmd.Dot())
@@ -216,6 +360,7 @@ func (ix *IX) reopen(ed *Ed) {
 		win.SetMark(m, 0)
 	}
 	ed.win = win
+	ed.view = nil
 	ed.temp = true
 	ix.eds = append(ix.eds, ed)
 	ed.waitc <- ed.editLoop
@@ -253,7 +398,7 @@ func (ed *Ed) replDot(s string) {
 		t.Ins(rs, ed.dot.P0)
 		ed.dot.P1 = ed.dot.P0 + len(rs)
 	}
-	ed.win.SetSel(ed.dot.P0, ed.dot.P1)
+	ed.setSel(ed.dot.P0, ed.dot.P1)
 	return
 	// This is how we should do it, but it's quite slow
 	// Safari takes a very long time to post the ins events
@@ -275,7 +420,7 @@ func (ed *Ed) replDot(s string) {
 	if some {
 		ed.dot.P1 = ed.dot.P0 + len(rs)
 		// sets p0 and p1 marks
-		ed.win.SetSel(ed.dot.P0, ed.dot.P1)
+		ed.setSel(ed.dot.P0, ed.dot.P1)
 	}
 }
 
@@ -307,7 +452,23 @@ func (ed *Ed) SetAddr(a zx.Addr) {
 	cmd.Dprintf("%s: dot set to %s (%s) for %s\n", ed, ed.dot, ed.Addr(), a)
 	a.P0, a.P1 = p0, p1
 	ed.laddr = a
-	ed.win.SetSel(p0, p1)
+	ed.setSel(p0, p1)
+}
+
+// Set $% to the file and $ixdot to the address of dot in the
+// env of c, for commands run on ed (or on dot, if ed is not a file).
+func setDotEnv(c *cmd.Ctx, ed *Ed) {
+	if ed == nil || ed.iscmd || ed.temp {
+		ix.Lock()
+		ed = ix.dot
+		ix.Unlock()
+	}
+	if ed == nil || ed.iscmd || ed.temp {
+		return
+	}
+	ed.refreshDot()
+	c.SetEnv("%", ed.tag)
+	c.SetEnv("ixdot", ed.Addr().String())
 }
 
 func (c *Cmd) printf(f string, args ...face{}) {
@@ -331,7 +492,27 @@ func (ed *Ed) runCmd(at int, line string) {
 	if len(ln) == 0 {
 		return
 	}
+	if ed.iscmd {
+		if strings.HasPrefix(ln, "!") {
+			rln, ok := ed.recall(ln)
+			if !ok {
+				ed.ix.Warn("%s: not in history", ln)
+				return
+			}
+			ln = rln
+		}
+		ed.addHist(ln)
+	}
 	args := strings.Fields(ln)
+	if len(args) == 1 && ed.dirVerb(args[0]) {
+		return
+	}
+	if len(args) == 1 {
+		if vln, ok := ed.verbCmd(args[0]); ok {
+			ln = vln
+			args = strings.Fields(ln)
+		}
+	}
 	// If the command is the name of a dir, then use cd dir
 	// if it's a commands window, or reload the window in
 	// another dir for dir windows.
@@ -343,9 +524,10 @@ func (ed *Ed) runCmd(at int, line string) {
 				return
 			}
 			if !ed.iscmd {
-				ed.tag = d["path"]
-				if ed.tag != "/" {
-					ed.tag += "/"
+				if p := d["path"]; p != "/" {
+					ed.setPath(p + "/")
+				} else {
+					ed.setPath(p)
 				}
 				ed.load(d)
 				ed.win.SetTag(ed.tag)
@@ -354,6 +536,7 @@ func (ed *Ed) runCmd(at int, line string) {
 			args = []string{"cd", args[0]}
 		}
 	}
+	ded := ed
 	if !ed.iscmd && !ed.temp {
 		ced := ed.ix.lookCmds(ed.dir, 0)
 		// command on a plain edit window, locate or start
@@ -367,6 +550,7 @@ func (ed *Ed) runCmd(at int, line string) {
 		ed:    ed,
 		mark:  ed.newMark(at),
 		hasnl: hasnl,
+		line:  ln,
 	}
 	if b := builtin(args[0]); b != nil {
 		b(c, args...)
@@ -382,6 +566,7 @@ func (ed *Ed) runCmd(at int, line string) {
 		c.ForkNS()
 		c.ForkDot()
 		c.SetOut("ink", inkc)
+		setDotEnv(c, ded)
 	}
 	p, err := run.CtxCmd(setio, args...)
 	if err != nil {
@@ -411,10 +596,16 @@ func (ed *Ed) lookFiles(name string) {
 
 func (ed *Ed) look(what string) {
 	s := strings.TrimSpace(what)
-	c, err := rules.CmdFor(s)
+	rs, err := lookRules(ed.dir)
+	if err != nil {
+		ed.ix.Warn("rules: %s", err)
+	}
+	c, err := rs.CmdFor(s)
 	if err == nil {
 		cmd.Dprintf("look rule %q\n", s)
-		ed.exec(c, s)
+		if !ed.ix.urlCmd(c) {
+			ed.exec(c, s)
+		}
 		return
 	}
 	if err != look.ErrNoMatch {
@@ -435,6 +626,11 @@ func (ed *Ed) look(what string) {
 		ed.ix.lookFile(names[0], names[1], -1)
 		return
 	}
+	if p := ed.resolveIncl(names[0]); p != "" {
+		cmd.Dprintf("look include %q %q\n", s, p)
+		ed.ix.lookFile(p, "", -1)
+		return
+	}
 	if strings.HasPrefix(s, "file:///zx/") {
 		n := len("file://")
 		s = "https://localhost:8181" + s[n:]
@@ -444,7 +640,7 @@ func (ed *Ed) look(what string) {
 		uri, err := url.Parse(toks[0])
 		if err == nil && uri.IsAbs() {
 			cmd.Dprintf("look url %q\n", s)
-			ed.ix.lookURL(s)
+			ed.ix.openURL(s)
 			return
 		}
 	}
@@ -505,7 +701,7 @@ func (ed *Ed) lookText(what string, p0 int) {
 		ed.dot.P0 = pos
 		ed.dot.P1 = pos + len(rs)
 		cmd.Dprintf("%s: dot set to %s (%s)\n", ed, ed.dot, ed.Addr())
-		ed.win.SetSel(ed.dot.P0, ed.dot.P1)
+		ed.setSel(ed.dot.P0, ed.dot.P1)
 	}
 }
 
@@ -537,15 +733,84 @@ func (ed *Ed) click248(ev *ink.Ev) {
 		}
 		ed.refreshDot()
 		go ed.lookText(what, ed.dot.P1)
+	} else if ed.wins != nil {
+		go ed.raiseWin(p0)
+	} else if ed.quitrv {
+		go ed.reviewDirty(p0, ev.Args[1])
+	} else if ed.dw != nil {
+		go ed.diffLook(p0)
 	} else if p0 == ed.laddr.P0 && p1 == ed.laddr.P1 {
 		go ed.ix.lookNext(ed.laddr)
 	} else {
-		go ed.look(ev.Args[1])
+		go func() {
+			if !ed.spellLook(p0) {
+				ed.look(ev.Args[1])
+			}
+		}()
+	}
+}
+
+// A double click with an empty selection within the indentation
+// of a line selects the block of lines indented at least as much.
+func (ed *Ed) click1(ev *ink.Ev) {
+	if len(ev.Args) < 4 || ev.Args[2] != ev.Args[3] {
+		return
+	}
+	p0, err := strconv.Atoi(ev.Args[2])
+	if err != nil {
+		return
+	}
+	ln := ed.win.LineAt(p0)
+	in := ed.win.IndentAt(ln)
+	if in == nil || in.Blank || p0-ed.win.LineOff(ln) > len([]rune(in.Ind)) {
+		return
+	}
+	ed.setSel(ed.win.LineOff(in.Ln0), ed.win.LineOff(in.Ln1+1))
+	ed.refreshDot()
+}
+
+var (
+	uinfo   *auth.Info
+	uinfolk sync.Mutex
+)
+
+// Return the auth info for the user running ix, as servers
+// see it: the user and groups of the default key, or just
+// the local user if there's no key.
+func userInfo() *auth.Info {
+	uinfolk.Lock()
+	defer uinfolk.Unlock()
+	if uinfo != nil {
+		return uinfo
+	}
+	uinfo = &auth.Info{Uid: u.Uid, SpeaksFor: u.Uid, Gids: map[string]bool{}, Ok: true}
+	if ks, _ := auth.LoadKey("", "default"); len(ks) > 0 {
+		uinfo.Uid, uinfo.SpeaksFor = ks[0].Uid, ks[0].Uid
+		for _, g := range ks[0].Gids {
+			uinfo.Gids[g] = true
+		}
+	}
+	return uinfo
+}
+
+// Highlight the bracket matching the one at or right before dot.
+func (ed *Ed) showMatch() {
+	m := -1
+	if ed.dot.P0 == ed.dot.P1 {
+		m = ed.win.Match(ed.dot.P0)
+		if m < 0 && ed.dot.P0 > 0 {
+			m = ed.win.Match(ed.dot.P0 - 1)
+		}
+	}
+	if m >= 0 {
+		ed.win.SetMark("bmatch", m)
+	} else if ed.win.Mark("bmatch") != nil {
+		ed.win.DelMark("bmatch")
 	}
 }
 
 func (ed *Ed) clear() {
-	ed.win.SetSel(0, 0)
+	ed.setSel(0, 0)
 	t := ed.win.GetText()
 	defer ed.win.PutText()
 	t.DelAll()
@@ -580,7 +845,7 @@ func (ed *Ed) undoRedo(isredo bool) bool {
 	}
 	ed.win.PutText()
 	if some {
-		ed.win.SetSel(ed.dot.P0, ed.dot.P1)
+		ed.setSel(ed.dot.P0, ed.dot.P1)
 	}
 	return some
 }
@@ -591,8 +856,10 @@ func (ed *Ed) move(to string) error {
 	if err == nil && d["type"] == "d" {
 		return fmt.Errorf("%s: %s", to, zx.ErrIsDir)
 	}
-	ed.tag = to
-	ed.win.SetTag(ed.tag)
+	ed.setPath(to)
+	ed.conflict = false
+	ed.vcs = vcsStatus(to)
+	ed.showTag()
 	return nil
 }
 
@@ -618,6 +885,12 @@ func (ed *Ed) wasChanged() error {
 }
 
 func (ed *Ed) save() error {
+	ed.disklk.Lock()
+	defer ed.disklk.Unlock()
+	if ed.ro {
+		ed.win.Clean()
+		return fmt.Errorf("%s: read-only", ed.tag)
+	}
 	if !ed.win.IsDirty() {
 		cmd.Dprintf("save: %s not dirty\n", ed.tag)
 		ed.win.Clean()
@@ -637,26 +910,53 @@ func (ed *Ed) save() error {
 	if err := ed.wasChanged(); err != nil {
 		return err
 	}
-	defer ed.win.Clean()
+	// other views may edit while saving, and then it's still dirty
+	vers := ed.win.Vers()
+	defer func() {
+		if ed.win.Vers() == vers {
+			ed.win.Clean()
+		}
+	}()
+	sp := cmd.StartSpan("ix save")
+	sp.Set("file", ed.tag)
+	defer sp.Finish()
 	dc := make(chan []byte)
 	rc := cmd.Put(ed.tag, zx.Dir{"type": "-"}, 0, dc)
 	tc := ed.win.Get(0, -1)
+	first := true
+	var eerr error
 	for rs := range tc {
-		dat := []byte(string(rs))
+		dat, err := encode(rs, ed.enc, first)
+		if err != nil {
+			eerr = err
+			close(tc, err)
+			break
+		}
+		first = false
 		if ok := dc <- dat; !ok {
 			close(tc, cerror(dc))
 			break
 		}
 	}
-	close(dc)
+	close(dc, eerr)
 	rd := <-rc
 	if err := cerror(rc); err != nil {
 		ed.ix.Warn("save %s: %s", ed, err)
+		sp.Fail(err)
 		return err
 	}
 	if mt, ok := rd["mtime"]; ok {
 		ed.d["mtime"] = mt
 	}
+	if ed.win.Vers() == vers {
+		removeBackup(ed.tag)
+	}
+	ed.saveUndo()
+	if ed.spell {
+		go ed.spellCheck()
+	}
+	ed.setConflict(false)
+	ed.updVCS(true)
 	return nil
 }
 
@@ -686,13 +986,19 @@ func (ed *Ed) load(nd zx.Dir) error {
 		dc = c
 		go func() {
 			ds, err := cmd.GetDir(what)
-			for _, d := range ds {
-				c <- []byte(d.Fmt()+"\n")
-			}
+			c <- []byte(ed.dirText(ds))
 			close(c, err)
 		}()
 	} else {
-		dc = cmd.Get(what, 0, -1)
+		c := make(chan []byte)
+		dc = c
+		go func() {
+			dat, err := cmd.GetAll(what)
+			rs, enc := decode(dat)
+			ed.enc = enc
+			c <- []byte(string(rs))
+			close(c, err)
+		}()
 	}
 	for m := range dc {
 		runes := []rune(string(m))
@@ -705,6 +1011,16 @@ func (ed *Ed) load(nd zx.Dir) error {
 	err := cerror(dc)
 	if err != nil {
 		ed.ix.Warn("%s: get: %s", what, err)
+	} else {
+		ed.setConflict(false)
+		if ed.d["type"] == "-" {
+			ed.loadUndo()
+			if !ed.d.CanPut(userInfo()) {
+				ed.setRO(true)
+			}
+		}
+		ed.showTag()
+		ed.updVCS(true)
 	}
 	ed.win.Clean()
 	return err
@@ -714,14 +1030,24 @@ func (ed *Ed) refreshDot() {
 	if ed.win == nil {
 		return
 	}
-	if p0 := ed.win.Mark("p0"); p0 != nil {
+	pref := ""
+	if ed.view != nil {
+		// see ink.Txt.SetElSel
+		pref = ed.winid
+	}
+	if p0 := ed.win.Mark(pref + "p0"); p0 != nil {
 		ed.dot.P0 = p0.Off
 	}
-	if p1 := ed.win.Mark("p1"); p1 != nil {
+	if p1 := ed.win.Mark(pref + "p1"); p1 != nil {
 		ed.dot.P1 = p1.Off
 	}
 }
 
+// Set the selection in the window, and not in its zeroxes.
+func (ed *Ed) setSel(p0, p1 int) {
+	ed.win.SetElSel(ed.winid, p0, p1)
+}
+
 func (ed *Ed) editLoop() {
 	if ed.iscmd {
 		cmd.ForkDot()
@@ -733,20 +1059,44 @@ func (ed *Ed) editLoop() {
 	for ev := range c {
 		ev := ev
 		cmd.Dprintf("ix ev %v\n", ev)
+		ed := ed.evEd(ev)
+		if ev.Args[0] != "tick" {
+			ed.postEv(ev)
+		}
+		switch ev.Args[0] {
+		case "eins", "edel", "tick":
+			ed.ix.record(ed, ev.Args)
+		}
 		switch ev.Args[0] {
 		case "focus":
+			if ed.ix.dot != ed {
+				ed.ix.pushJump(ed.ix.dot)
+			}
 			ed.ix.dot = ed
+			ed.used = time.Now()
 		case "tick":
 			ed.refreshDot()
+			ed.showMatch()
+			ed.showPos()
 		case "click1":
 			ed.ix.lookstr = ev.Args[1]
+			ed.click1(ev)
 		case "click2", "click4", "click8":
 			ed.click248(ev)
+		case "start":
+			ed.showEditors("")
 		case "end":
 			if len(ed.win.Views()) == 0 {
 				cmd.Dprintf("%s w/o views\n", ed)
 			}
+			ed.showEditors(ev.Src)
 		case "quit":
+			if ed.ix.delZerox(ed) {
+				cmd.Dprintf("%s zerox terminated\n", ed)
+				continue
+			}
+			ed.termHup()
+			ed.ix.killCmds(ed)
 			n := ed.ix.delEd(ed)
 			cmd.Dprintf("%s terminated\n", ed)
 			close(c, "quit")
@@ -760,15 +1110,47 @@ func (ed *Ed) editLoop() {
 			} else {
 				ed.load(nil)
 			}
+		case "hist":
+			if ed.iscmd && len(ev.Args) > 1 {
+				ed.histKey(ev.Args[1] == "prev")
+			}
+		case "complete":
+			if ed.iscmd && len(ev.Args) > 1 {
+				if p, err := strconv.Atoi(ev.Args[1]); err == nil {
+					ed.complete(p)
+				}
+			}
+		case "intr":
+			if ed.iscmd {
+				ed.termIntr()
+			}
 		case "eundo", "eredo":
-			if ed.undoRedo(ev.Args[0] == "eredo") {
+			if !ed.ro && ed.undoRedo(ev.Args[0] == "eredo") {
 				ed.win.Dirty()
+				ed.autosave()
+			}
+		}
+		if ed.iscmd && ev.Args[0] == "eins" && len(ev.Args) > 2 &&
+			strings.Contains(ev.Args[1], "\n") {
+			if p, err := strconv.Atoi(ev.Args[2]); err == nil {
+				ed.termInput(p)
 			}
 		}
 		if !ed.iscmd {
 			switch ev.Args[0] {
 			case "eins", "edel":
+				if ed.ro {
+					// the viewer raced with NoEdits
+					ed.undoRedo(false)
+					continue
+				}
 				ed.win.Dirty()
+				ed.autosave()
+				if ev.Args[0] == "eins" && len(ev.Args) > 2 && ev.Args[1] == "\n" {
+					if p, err := strconv.Atoi(ev.Args[2]); err == nil {
+						ed.autoIndent(p)
+					}
+				}
 			case "save":
 				ed.save()
 			}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"clive/sre"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode"
+)
+
+/*
+	Sam-style commands for the Edit builtin:
+		Edit [addr] cmd
+	Addresses are
+		.	dot
+		,	all the text (also a1,a2 with either one missing)
+		$	the end of text
+		n	line n
+		#n	the empty string after rune n
+		/re/	the next match of re (wrapping around)
+		?re?	the previous match of re (wrapping around)
+	Commands are
+		x/re/ cmd	run cmd for each match of re in dot
+		y/re/ cmd	run cmd for the text between matches of re
+		g/re/ cmd	run cmd if dot contains a match of re
+		v/re/ cmd	run cmd if dot has no match of re
+		c/text/	change dot to text
+		a/text/	append text after dot
+		i/text/	insert text before dot
+		d	delete dot
+		s/re/repl/[g]	replace the first (or all) matches in dot
+		p	print dot
+		=	print the address of dot
+		{ cmd ... }	run each cmd with the same dot
+	As in sam, all changes are computed on the original text and
+	applied at once, and must not overlap. Replacement texts use
+	\0...\9 for the matched (sub)strings, and \n for newlines.
+	All the changes are a single edit regarding undo and redo.
+*/
+
+// A parsed Edit command.
+struct samCmd {
+	addr   *samAddr
+	op     rune
+	re     *sre.ReProg
+	text   string // for c, a, i, s
+	global bool   // s///g
+	sub    []*samCmd
+}
+
+struct samAddr {
+	kind        rune // . $ l # / ? ,
+	n           int
+	re          *sre.ReProg
+	left, right *samAddr
+}
+
+struct samChg {
+	P0, P1 int
+	text   []rune
+	n      int // order, to keep inserts at the same place in order
+}
+
+type samChgs []samChg
+
+// Running state for an Edit command
+struct samRun {
+	rs   []rune
+	chgs samChgs
+	dot  Dot // set by address-only commands
+	out  func(string, ...face{})
+}
+
+struct samParser {
+	s []rune
+	i int
+}
+
+func (x samChgs) Len() int      { return len(x) }
+func (x samChgs) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x samChgs) Less(i, j int) bool {
+	if x[i].P0 != x[j].P0 {
+		return x[i].P0 < x[j].P0
+	}
+	return x[i].n < x[j].n
+}
+
+var errSamSyntax = errors.New("Edit: syntax error")
+
+func (p *samParser) more() bool {
+	return p.i < len(p.s)
+}
+
+func (p *samParser) peek() rune {
+	if p.i >= len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *samParser) skipBlanks() {
+	for p.i < len(p.s) && unicode.IsSpace(p.s[p.i]) {
+		p.i++
+	}
+}
+
+func (p *samParser) num() int {
+	i0 := p.i
+	for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+	n, _ := strconv.Atoi(string(p.s[i0:p.i]))
+	return n
+}
+
+// Get text up to the delimiter, with \delim escaping it.
+// The final delimiter may be missing at the end of the command.
+func (p *samParser) delimited(delim rune) string {
+	var out []rune
+	for p.i < len(p.s) {
+		r := p.s[p.i]
+		p.i++
+		if r == delim {
+			return string(out)
+		}
+		if r == '\\' && p.i < len(p.s) && p.s[p.i] == delim {
+			r = delim
+			p.i++
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func (p *samParser) rexp(dir sre.Dir) (*sre.ReProg, error) {
+	if !p.more() {
+		return nil, errSamSyntax
+	}
+	delim := p.s[p.i]
+	p.i++
+	if delim == '\\' || delim == '\n' || unicode.IsSpace(delim) {
+		return nil, errSamSyntax
+	}
+	s := p.delimited(delim)
+	if s == "" {
+		return nil, errors.New("Edit: empty regexp")
+	}
+	re, err := sre.CompileStr(s, dir)
+	if err != nil {
+		return nil, fmt.Errorf("Edit: %s: %s", s, err)
+	}
+	return re, nil
+}
+
+func (p *samParser) simpleAddr() (*samAddr, error) {
+	switch r := p.peek(); {
+	case r == '.' || r == '$':
+		p.i++
+		return &samAddr{kind: r}, nil
+	case r == '#':
+		p.i++
+		return &samAddr{kind: '#', n: p.num()}, nil
+	case r >= '0' && r <= '9':
+		return &samAddr{kind: 'l', n: p.num()}, nil
+	case r == '/' || r == '?':
+		dir := sre.Dir(sre.Fwd)
+		if r == '?' {
+			dir = sre.Bck
+		}
+		re, err := p.rexp(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &samAddr{kind: r, re: re}, nil
+	}
+	return nil, nil
+}
+
+func (p *samParser) addr() (*samAddr, error) {
+	a, err := p.simpleAddr()
+	if err != nil || p.peek() != ',' {
+		return a, err
+	}
+	p.i++
+	b, err := p.simpleAddr()
+	if err != nil {
+		return nil, err
+	}
+	return &samAddr{kind: ',', left: a, right: b}, nil
+}
+
+func (p *samParser) cmd() (*samCmd, error) {
+	p.skipBlanks()
+	a, err := p.addr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlanks()
+	c := &samCmd{addr: a}
+	if !p.more() {
+		if a == nil {
+			return nil, errSamSyntax
+		}
+		// just an address: set dot
+		c.op = 'k'
+		return c, nil
+	}
+	c.op = p.s[p.i]
+	p.i++
+	switch c.op {
+	case 'x', 'y', 'g', 'v':
+		if c.re, err = p.rexp(sre.Fwd); err != nil {
+			return nil, err
+		}
+		p.skipBlanks()
+		if !p.more() || p.peek() == '}' {
+			c.sub = []*samCmd{{op: 'p'}}
+			return c, nil
+		}
+		sc, err := p.cmd()
+		if err != nil {
+			return nil, err
+		}
+		c.sub = []*samCmd{sc}
+	case 'c', 'a', 'i':
+		if !p.more() {
+			return nil, errSamSyntax
+		}
+		delim := p.s[p.i]
+		p.i++
+		c.text = unescapeNl(p.delimited(delim))
+	case 's':
+		delim := p.peek()
+		if c.re, err = p.rexp(sre.Fwd); err != nil {
+			return nil, err
+		}
+		c.text = unescapeNl(p.delimited(delim))
+		if p.peek() == 'g' {
+			c.global = true
+			p.i++
+		}
+	case 'd', 'p', '=':
+	case '{':
+		for {
+			p.skipBlanks()
+			if !p.more() {
+				return nil, errors.New("Edit: missing }")
+			}
+			if p.peek() == '}' {
+				p.i++
+				break
+			}
+			sc, err := p.cmd()
+			if err != nil {
+				return nil, err
+			}
+			c.sub = append(c.sub, sc)
+		}
+	default:
+		return nil, fmt.Errorf("Edit: unknown command '%c'", c.op)
+	}
+	return c, nil
+}
+
+// Parse an Edit command.
+func parseSam(s string) (*samCmd, error) {
+	p := &samParser{s: []rune(s)}
+	c, err := p.cmd()
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlanks()
+	if p.more() {
+		return nil, fmt.Errorf("Edit: extra text '%s'", string(p.s[p.i:]))
+	}
+	return c, nil
+}
+
+// Replace \n with a newline, leaving other escapes alone.
+func unescapeNl(s string) string {
+	var out []rune
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] == '\\' && i+1 < len(rs) {
+			if rs[i+1] == 'n' {
+				out = append(out, '\n')
+			} else {
+				out = append(out, rs[i], rs[i+1])
+			}
+			i++
+			continue
+		}
+		out = append(out, rs[i])
+	}
+	return string(out)
+}
+
+func (r *samRun) lineRange(n int) (Dot, error) {
+	if n == 0 {
+		return Dot{}, nil
+	}
+	ln, p0 := 1, 0
+	for i := 0; i < len(r.rs) && ln < n; i++ {
+		if r.rs[i] == '\n' {
+			ln++
+			p0 = i + 1
+		}
+	}
+	if ln < n {
+		return Dot{}, fmt.Errorf("Edit: no line %d", n)
+	}
+	p1 := p0
+	for p1 < len(r.rs) && r.rs[p1] != '\n' {
+		p1++
+	}
+	if p1 < len(r.rs) {
+		p1++
+	}
+	return Dot{p0, p1}, nil
+}
+
+func (r *samRun) search(re *sre.ReProg, back bool, dot Dot) (Dot, error) {
+	n := len(r.rs)
+	if back {
+		if m := re.ExecRunes(r.rs, dot.P0, n); len(m) > 0 {
+			return Dot{m[0].P0, m[0].P1}, nil
+		}
+		if m := re.ExecRunes(r.rs, n, n); len(m) > 0 {
+			return Dot{m[0].P0, m[0].P1}, nil
+		}
+	} else {
+		if m := re.ExecRunes(r.rs, dot.P1, n); len(m) > 0 {
+			return Dot{m[0].P0, m[0].P1}, nil
+		}
+		if m := re.ExecRunes(r.rs, 0, n); len(m) > 0 {
+			return Dot{m[0].P0, m[0].P1}, nil
+		}
+	}
+	return dot, errors.New("Edit: no match")
+}
+
+func (r *samRun) eval(a *samAddr, dot Dot) (Dot, error) {
+	switch a.kind {
+	case '.':
+		return dot, nil
+	case '$':
+		return Dot{len(r.rs), len(r.rs)}, nil
+	case '#':
+		if a.n > len(r.rs) {
+			return dot, fmt.Errorf("Edit: #%d out of range", a.n)
+		}
+		return Dot{a.n, a.n}, nil
+	case 'l':
+		return r.lineRange(a.n)
+	case '/', '?':
+		return r.search(a.re, a.kind == '?', dot)
+	case ',':
+		d0, d1 := Dot{}, Dot{len(r.rs), len(r.rs)}
+		var err error
+		if a.left != nil {
+			if d0, err = r.eval(a.left, dot); err != nil {
+				return dot, err
+			}
+		}
+		if a.right != nil {
+			if d1, err = r.eval(a.right, dot); err != nil {
+				return dot, err
+			}
+		}
+		if d1.P1 < d0.P0 {
+			return dot, errors.New("Edit: addresses out of order")
+		}
+		return Dot{d0.P0, d1.P1}, nil
+	}
+	return dot, errSamSyntax
+}
+
+// Return the matches of re within dot.
+// Empty matches right after a previous match are skipped, as in sam.
+func (r *samRun) matches(re *sre.ReProg, dot Dot) [][]sre.Range {
+	var ms [][]sre.Range
+	p, last := dot.P0, -1
+	for p <= dot.P1 {
+		m := re.ExecRunes(r.rs, p, dot.P1)
+		if len(m) == 0 || m[0].P0 > dot.P1 {
+			break
+		}
+		if m[0].P0 == m[0].P1 && m[0].P0 == last {
+			p = m[0].P0 + 1
+			continue
+		}
+		ms = append(ms, m)
+		last = m[0].P1
+		p = m[0].P1
+		if m[0].P0 == m[0].P1 {
+			p++
+		}
+	}
+	return ms
+}
+
+func (r *samRun) change(p0, p1 int, s string) {
+	r.chgs = append(r.chgs, samChg{P0: p0, P1: p1, text: []rune(s), n: len(r.chgs)})
+}
+
+func (r *samRun) substrs(m []sre.Range) []string {
+	ss := make([]string, len(m))
+	for i, rg := range m {
+		if rg.P0 >= 0 && rg.P1 >= rg.P0 && rg.P1 <= len(r.rs) {
+			ss[i] = string(r.rs[rg.P0:rg.P1])
+		}
+	}
+	return ss
+}
+
+func (r *samRun) run(c *samCmd, dot Dot) error {
+	if c.addr != nil {
+		d, err := r.eval(c.addr, dot)
+		if err != nil {
+			return err
+		}
+		dot = d
+	}
+	switch c.op {
+	case 'k':
+		r.dot = dot
+	case 'p':
+		r.out("%s", string(r.rs[dot.P0:dot.P1]))
+	case '=':
+		r.out("%s\n", dot)
+	case 'd':
+		r.change(dot.P0, dot.P1, "")
+	case 'c':
+		r.change(dot.P0, dot.P1, c.text)
+	case 'a':
+		r.change(dot.P1, dot.P1, c.text)
+	case 'i':
+		r.change(dot.P0, dot.P0, c.text)
+	case 's':
+		for _, m := range r.matches(c.re, dot) {
+			r.change(m[0].P0, m[0].P1, sre.Repl(r.substrs(m), c.text))
+			if !c.global {
+				break
+			}
+		}
+	case 'x':
+		for _, m := range r.matches(c.re, dot) {
+			if err := r.runAll(c.sub, Dot{m[0].P0, m[0].P1}); err != nil {
+				return err
+			}
+		}
+	case 'y':
+		p := dot.P0
+		for _, m := range r.matches(c.re, dot) {
+			if err := r.runAll(c.sub, Dot{p, m[0].P0}); err != nil {
+				return err
+			}
+			p = m[0].P1
+		}
+		return r.runAll(c.sub, Dot{p, dot.P1})
+	case 'g', 'v':
+		m := c.re.ExecRunes(r.rs, dot.P0, dot.P1)
+		found := len(m) > 0 && m[0].P1 <= dot.P1
+		if found == (c.op == 'g') {
+			return r.runAll(c.sub, dot)
+		}
+	case '{':
+		return r.runAll(c.sub, dot)
+	}
+	return nil
+}
+
+func (r *samRun) runAll(cs []*samCmd, dot Dot) error {
+	for _, c := range cs {
+		if err := r.run(c, dot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run an Edit command on the ed and apply its changes as a single edit.
+// Dot is left at the changed text, or where the command's address said.
+func (ed *Ed) samEdit(s string, out func(string, ...face{})) error {
+	c, err := parseSam(s)
+	if err != nil {
+		return err
+	}
+	ed.refreshDot()
+	t := ed.win.GetText()
+	r := &samRun{rs: []rune(t.String()), out: out}
+	dot := ed.dot
+	if dot.P1 > len(r.rs) {
+		dot.P1 = len(r.rs)
+	}
+	if dot.P0 > dot.P1 {
+		dot.P0 = dot.P1
+	}
+	if err := r.run(c, dot); err != nil {
+		ed.win.UngetText()
+		return err
+	}
+	if c.op == 'k' {
+		ed.win.UngetText()
+		ed.dot = r.dot
+		ed.setSel(ed.dot.P0, ed.dot.P1)
+		return nil
+	}
+	if len(r.chgs) == 0 {
+		ed.win.UngetText()
+		return nil
+	}
+	sort.Sort(r.chgs)
+	for i := 1; i < len(r.chgs); i++ {
+		if r.chgs[i].P0 < r.chgs[i-1].P1 {
+			ed.win.UngetText()
+			return errors.New("Edit: changes overlap")
+		}
+	}
+	// apply from the end, so offsets remain valid.
+	n := 0
+	for i := len(r.chgs) - 1; i >= 0; i-- {
+		ch := r.chgs[i]
+		if ch.P1 > ch.P0 {
+			if n > 0 {
+				t.ContdEdit()
+			}
+			t.Del(ch.P0, ch.P1-ch.P0)
+			n++
+		}
+		if len(ch.text) > 0 {
+			if n > 0 {
+				t.ContdEdit()
+			}
+			t.Ins(ch.text, ch.P0)
+			n++
+		}
+	}
+	ed.win.PutText()
+	if n > 0 {
+		ed.win.Dirty()
+		ed.autosave()
+	}
+	delta := 0
+	for i, ch := range r.chgs {
+		if i == 0 {
+			ed.dot.P0 = ch.P0 + delta
+		}
+		delta += len(ch.text) - (ch.P1 - ch.P0)
+		ed.dot.P1 = ch.P1 + delta
+	}
+	ed.setSel(ed.dot.P0, ed.dot.P1)
+	return nil
+}
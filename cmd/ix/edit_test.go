@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+struct samTst {
+	p0, p1   int
+	cmd, out string
+}
+
+// Run an Edit command on txt with dot at p0,p1 and return the new text.
+func samTest(t *testing.T, txt string, p0, p1 int, c string) string {
+	sc, err := parseSam(c)
+	if err != nil {
+		t.Fatalf("parse %q: %s", c, err)
+	}
+	r := &samRun{rs: []rune(txt), out: t.Logf}
+	if err := r.run(sc, Dot{p0, p1}); err != nil {
+		t.Fatalf("run %q: %s", c, err)
+	}
+	sort.Sort(r.chgs)
+	rs := r.rs
+	for i := len(r.chgs) - 1; i >= 0; i-- {
+		ch := r.chgs[i]
+		nrs := append([]rune{}, rs[:ch.P0]...)
+		nrs = append(nrs, ch.text...)
+		rs = append(nrs, rs[ch.P1:]...)
+	}
+	return string(rs)
+}
+
+func TestSamEdit(t *testing.T) {
+	txt := "one two\nthree two\nfour\n"
+	n := len(txt)
+	outs := []samTst{
+		{0, n, ",x/two/ c/2/", "one 2\nthree 2\nfour\n"},
+		{0, 7, "x/two/ c/2/", "one 2\nthree two\nfour\n"},
+		{0, n, ",s/o/0/g", "0ne tw0\nthree tw0\nf0ur\n"},
+		{0, n, ",s/t(w)o/<\\1>/", "one <w>\nthree two\nfour\n"},
+		{0, 0, "2d", "one two\nfour\n"},
+		{0, 0, "/four/ i/4 /", "one two\nthree two\n4 four\n"},
+		{0, n, ",x/.*\\n/ g/two/ a/--\\n/", "one two\n--\nthree two\n--\nfour\n"},
+		{0, n, ",x/.*\\n/ v/two/ d", "one two\nthree two\n"},
+		{0, n, ",y/two/ c/x/", "xtwoxtwox"},
+		{0, n, ", x/two/ { i/[/ a/]/ }", "one [two]\nthree [two]\nfour\n"},
+		{0, 0, "$ a/five\\n/", "one two\nthree two\nfour\nfive\n"},
+	}
+	for _, o := range outs {
+		got := samTest(t, txt, o.p0, o.p1, o.cmd)
+		t.Logf("%s -> %q", o.cmd, got)
+		if got != o.out {
+			t.Fatalf("%s: got %q", o.cmd, got)
+		}
+	}
+	bad := []string{"", "q", ",x", ",s//a/", "{ d"}
+	for _, b := range bad {
+		if _, err := parseSam(b); err == nil {
+			t.Fatalf("%q: no error", b)
+		}
+	}
+}
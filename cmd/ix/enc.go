@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+/*
+	Files are decoded when loaded, and encoded again in the
+	same way when saved. UTF-16 is detected by its BOM, and
+	files that are not valid UTF-8 are taken as latin-1.
+	The encoding is shown in the tag unless it's utf8,
+	and Enc can be used to change it.
+*/
+
+// Decode dat and return its runes and encoding ("" for utf8).
+func decode(dat []byte) ([]rune, string) {
+	switch {
+	case len(dat) >= 2 && dat[0] == 0xFF && dat[1] == 0xFE:
+		return decode16(dat[2:], false), "utf16le"
+	case len(dat) >= 2 && dat[0] == 0xFE && dat[1] == 0xFF:
+		return decode16(dat[2:], true), "utf16be"
+	case utf8.Valid(dat):
+		return []rune(string(dat)), ""
+	}
+	rs := make([]rune, len(dat))
+	for i, b := range dat {
+		rs[i] = rune(b)
+	}
+	return rs, "latin1"
+}
+
+func decode16(dat []byte, be bool) []rune {
+	u := make([]uint16, len(dat)/2)
+	for i := range u {
+		if be {
+			u[i] = uint16(dat[2*i])<<8 | uint16(dat[2*i+1])
+		} else {
+			u[i] = uint16(dat[2*i+1])<<8 | uint16(dat[2*i])
+		}
+	}
+	return utf16.Decode(u)
+}
+
+// Encode rs with the given encoding.
+// If first is set, the BOM is included for utf16.
+func encode(rs []rune, enc string, first bool) ([]byte, error) {
+	var buf bytes.Buffer
+	switch enc {
+	case "", "utf8":
+		return []byte(string(rs)), nil
+	case "latin1":
+		for _, r := range rs {
+			if r > 0xFF {
+				return nil, fmt.Errorf("rune %q not in latin1", r)
+			}
+			buf.WriteByte(byte(r))
+		}
+	case "utf16le", "utf16be":
+		be := enc == "utf16be"
+		if first {
+			if be {
+				buf.Write([]byte{0xFE, 0xFF})
+			} else {
+				buf.Write([]byte{0xFF, 0xFE})
+			}
+		}
+		for _, u := range utf16.Encode(rs) {
+			if be {
+				buf.Write([]byte{byte(u >> 8), byte(u)})
+			} else {
+				buf.Write([]byte{byte(u), byte(u >> 8)})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", enc)
+	}
+	return buf.Bytes(), nil
+}
+
+// Enc [enc]
+//	print or set the encoding used to save dot,
+//	one of utf8, latin1, utf16le, utf16be.
+func bEnc(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.temp || dot.iscmd {
+		c.printf("Enc: no file at dot\n--\n")
+		return
+	}
+	if len(args) == 1 {
+		enc := dot.enc
+		if enc == "" {
+			enc = "utf8"
+		}
+		c.printf("%s: %s\n--\n", dot.tag, enc)
+		return
+	}
+	enc := args[1]
+	if _, err := encode(nil, enc, false); err != nil {
+		c.printf("Enc: %s\n--\n", err)
+		return
+	}
+	if enc == "utf8" {
+		enc = ""
+	}
+	if enc != dot.enc {
+		dot.enc = enc
+		dot.showTag()
+		dot.win.Dirty()
+	}
+	c.printf("--\n")
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"clive/txt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	Filters replace the lines in dot with the result of
+	a transformation, as a single edit, without running
+	external commands:
+		Sort [-rnuf]	sort lines (reverse, numeric, unique, fold case)
+		Fmt [width]	reflow paragraphs to width (70 by default)
+		Tab [n]	turn leading runs of n spaces into tabs
+		Detab [n]	expand tabs to spaces, with tab stops every n
+	Tab and Detab use the indentation width for the file by default.
+*/
+
+// Default width for Fmt.
+var FmtWidth = 70
+
+// Return the offsets for the full lines in dot and the text.
+func (ed *Ed) dotLines() (int, int, []rune) {
+	ed.refreshDot()
+	rs := []rune(string(ed.text()))
+	p0, p1 := ed.dot.P0, ed.dot.P1
+	if p1 > len(rs) {
+		p1 = len(rs)
+	}
+	if p0 > p1 {
+		p0 = p1
+	}
+	for p0 > 0 && rs[p0-1] != '\n' {
+		p0--
+	}
+	if p1 > p0 && rs[p1-1] == '\n' {
+		p1--
+	}
+	for p1 < len(rs) && rs[p1] != '\n' {
+		p1++
+	}
+	return p0, p1, rs
+}
+
+// Replace the lines in dot with those returned by fn.
+func (ed *Ed) filterLines(fn func([]string) []string) {
+	p0, p1, rs := ed.dotLines()
+	lns := fn(strings.Split(string(rs[p0:p1]), "\n"))
+	ed.dot.P0, ed.dot.P1 = p0, p1
+	ed.replDot(strings.Join(lns, "\n"))
+	ed.setSel(ed.dot.P0, ed.dot.P1)
+	ed.win.Dirty()
+	ed.autosave()
+}
+
+// Lines sorted by Sort.
+struct sortedLns {
+	lns       []string
+	num, fold bool
+}
+
+func (s sortedLns) Len() int      { return len(s.lns) }
+func (s sortedLns) Swap(i, j int) { s.lns[i], s.lns[j] = s.lns[j], s.lns[i] }
+func (s sortedLns) Less(i, j int) bool {
+	a, b := s.lns[i], s.lns[j]
+	if s.num {
+		na, nb := lineNb(a), lineNb(b)
+		if na != nb {
+			return na < nb
+		}
+	}
+	if s.fold {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+	}
+	return a < b
+}
+
+// Return the number at the start of ln, or 0.
+func lineNb(ln string) float64 {
+	ln = strings.TrimSpace(ln)
+	n := 0
+	for n < len(ln) && (ln[n] >= '0' && ln[n] <= '9' || ln[n] == '.' ||
+		n == 0 && (ln[n] == '-' || ln[n] == '+')) {
+		n++
+	}
+	f, _ := strconv.ParseFloat(ln[:n], 64)
+	return f
+}
+
+func sortLines(lns []string, flags string) []string {
+	s := sortedLns{lns: lns, num: strings.ContainsRune(flags, 'n'),
+		fold: strings.ContainsRune(flags, 'f')}
+	if strings.ContainsRune(flags, 'r') {
+		sort.Stable(sort.Reverse(s))
+	} else {
+		sort.Stable(s)
+	}
+	if !strings.ContainsRune(flags, 'u') {
+		return lns
+	}
+	var out []string
+	for i, ln := range lns {
+		if i == 0 || s.fold && !strings.EqualFold(ln, lns[i-1]) ||
+			!s.fold && ln != lns[i-1] {
+			out = append(out, ln)
+		}
+	}
+	return out
+}
+
+// Return the leading blanks of ln.
+func lineIndent(ln string) string {
+	return ln[:len(ln)-len(strings.TrimLeft(ln, " \t"))]
+}
+
+// Return the width of s, with tabs at txt.TabWidth.
+func textWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		if r == '\t' {
+			n += txt.TabWidth - n%txt.TabWidth
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// Reflow the paragraphs in lns (separated by blank lines) to width,
+// keeping the indentation of their first lines.
+func fmtLines(lns []string, width int) []string {
+	var out, words []string
+	ind := ""
+	flush := func() {
+		if len(words) == 0 {
+			return
+		}
+		ln := ind + words[0]
+		for _, w := range words[1:] {
+			if textWidth(ln)+1+utf8.RuneCountInString(w) > width {
+				out = append(out, ln)
+				ln = ind + w
+			} else {
+				ln += " " + w
+			}
+		}
+		out = append(out, ln)
+		words = nil
+	}
+	for _, ln := range lns {
+		if strings.TrimSpace(ln) == "" {
+			flush()
+			out = append(out, ln)
+			continue
+		}
+		if len(words) == 0 {
+			ind = lineIndent(ln)
+		}
+		words = append(words, strings.Fields(ln)...)
+	}
+	flush()
+	return out
+}
+
+// Turn leading runs of n spaces into tabs.
+func tabLines(lns []string, n int) []string {
+	sp := strings.Repeat(" ", n)
+	for i, ln := range lns {
+		ind := lineIndent(ln)
+		nind := ""
+		for len(ind) > 0 {
+			if strings.HasPrefix(ind, sp) {
+				nind += "\t"
+				ind = ind[n:]
+			} else if ind[0] == '\t' {
+				nind += "\t"
+				ind = ind[1:]
+			} else {
+				break
+			}
+		}
+		lns[i] = nind + ind + strings.TrimLeft(ln, " \t")
+	}
+	return lns
+}
+
+// Expand tabs to spaces, with tab stops every n columns.
+func detabLines(lns []string, n int) []string {
+	for i, ln := range lns {
+		if !strings.ContainsRune(ln, '\t') {
+			continue
+		}
+		var out []rune
+		for _, r := range ln {
+			if r == '\t' {
+				for pad := n - len(out)%n; pad > 0; pad-- {
+					out = append(out, ' ')
+				}
+			} else {
+				out = append(out, r)
+			}
+		}
+		lns[i] = string(out)
+	}
+	return lns
+}
+
+// Sort [-rnuf]
+//	sort the lines in dot.
+// Fmt [width]
+//	reflow the paragraphs in dot.
+// Tab [n]
+// Detab [n]
+//	turn leading spaces into tabs, or tabs into spaces.
+func bFilter(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.iscmd || dot.temp || dot.ro || dot.hex != nil {
+		c.printf("%s: no file at dot\n--\n", args[0])
+		return
+	}
+	var fn func([]string) []string
+	switch args[0] {
+	case "Sort":
+		flags := ""
+		for _, a := range args[1:] {
+			if len(a) < 2 || a[0] != '-' || strings.Trim(a[1:], "rnuf") != "" {
+				c.printf("usage: Sort [-rnuf]\n--\n")
+				return
+			}
+			flags += a[1:]
+		}
+		fn = func(lns []string) []string { return sortLines(lns, flags) }
+	default:
+		n := FmtWidth
+		if args[0] != "Fmt" {
+			n = indentFor(dot.tag).width
+			if n == 0 {
+				n = txt.TabWidth
+			}
+		}
+		if len(args) > 2 {
+			c.printf("usage: %s [n]\n--\n", args[0])
+			return
+		}
+		if len(args) == 2 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+				c.printf("usage: %s [n]\n--\n", args[0])
+				return
+			}
+		}
+		switch args[0] {
+		case "Fmt":
+			fn = func(lns []string) []string { return fmtLines(lns, n) }
+		case "Tab":
+			fn = func(lns []string) []string { return tabLines(lns, n) }
+		case "Detab":
+			fn = func(lns []string) []string { return detabLines(lns, n) }
+		}
+	}
+	dot.filterLines(fn)
+	c.printf("--\n")
+}
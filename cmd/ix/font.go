@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	"clive/net/ink"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+	Windows use the font $ixfont ("t" by default) and the color
+	theme $ixtheme ("light" by default).
+	Font and Theme change them for the window at dot, and the
+	choices are kept by file (by dir for commands windows), applied
+	to new windows for the same file, and saved in session dumps.
+	Fonts are as in ink.Txt.SetFont: t (fixed) or r (variable),
+	with b and i for bold and italic, an optional size in points,
+	and an optional :family, as in t14, rb, or r10:Georgia.
+	Themes are those in ink.Themes.
+*/
+
+// Font and theme chosen for a file.
+struct winStyle {
+	font, theme string
+}
+
+// Return the key for the style of the window with the given tag.
+func styleKey(tag string) string {
+	if strings.HasPrefix(tag, "ql!") {
+		toks := strings.Split(tag, "!")
+		return "ql!" + toks[len(toks)-1]
+	}
+	return tag
+}
+
+func defFont() string {
+	if f := cmd.GetEnv("ixfont"); f != "" {
+		return f
+	}
+	return "t"
+}
+
+func defTheme() string {
+	return cmd.GetEnv("ixtheme")
+}
+
+// Is f a valid font spec?
+func isFont(f string) bool {
+	toks := strings.SplitN(f, ":", 2)
+	s := toks[0]
+	if s == "" || s[0] != 't' && s[0] != 'r' {
+		return false
+	}
+	s = strings.TrimLeft(s[1:], "bi")
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(toks) == 1 || toks[1] != ""
+}
+
+// Set the font and theme for a new window with the given tag.
+func (ix *IX) setStyle(win *ink.Txt, tag string) {
+	font, theme := defFont(), defTheme()
+	ix.Lock()
+	if l := ix.styles[styleKey(tag)]; l != nil {
+		if l.font != "" {
+			font = l.font
+		}
+		if l.theme != "" {
+			theme = l.theme
+		}
+	}
+	ix.Unlock()
+	win.SetFont(font)
+	if theme != "" {
+		if err := win.SetTheme(theme); err != nil {
+			cmd.Warn("%s: %s", tag, err)
+		}
+	}
+}
+
+// Record the font or theme chosen for ed.
+func (ix *IX) recordStyle(ed *Ed, font, theme string) {
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.styles == nil {
+		ix.styles = map[string]*winStyle{}
+	}
+	k := styleKey(ed.tag)
+	l := ix.styles[k]
+	if l == nil {
+		l = &winStyle{}
+		ix.styles[k] = l
+	}
+	if font != "" {
+		l.font = font
+	}
+	if theme != "" {
+		l.theme = theme
+	}
+}
+
+// Write the styles recorded to a session dump.
+func (ix *IX) dumpStyles(buf *bytes.Buffer) {
+	ix.Lock()
+	defer ix.Unlock()
+	var ks []string
+	for k := range ix.styles {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		l := ix.styles[k]
+		if l.font != "" {
+			fmt.Fprintf(buf, "font\t%s\t%s\n", k, l.font)
+		}
+		if l.theme != "" {
+			fmt.Fprintf(buf, "theme\t%s\t%s\n", k, l.theme)
+		}
+	}
+}
+
+// Load a style line from a session dump, returning false if it's not one.
+func (ix *IX) loadStyle(ln string) bool {
+	toks := strings.Split(ln, "\t")
+	if len(toks) != 3 || toks[0] != "font" && toks[0] != "theme" {
+		return false
+	}
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.styles == nil {
+		ix.styles = map[string]*winStyle{}
+	}
+	l := ix.styles[toks[1]]
+	if l == nil {
+		l = &winStyle{}
+		ix.styles[toks[1]] = l
+	}
+	if toks[0] == "font" {
+		l.font = toks[2]
+	} else {
+		l.theme = toks[2]
+	}
+	return true
+}
+
+// Font [spec]
+//	print or set the font for the window at dot.
+// Theme [name]
+//	print or set the color theme for the window at dot.
+func bStyle(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil {
+		c.printf("%s: no window at dot\n--\n", args[0])
+		return
+	}
+	if len(args) > 2 {
+		c.printf("usage: Font [spec] | Theme [name]\n--\n")
+		return
+	}
+	ix.Lock()
+	l := winStyle{font: defFont(), theme: defTheme()}
+	if wl := ix.styles[styleKey(dot.tag)]; wl != nil {
+		if wl.font != "" {
+			l.font = wl.font
+		}
+		if wl.theme != "" {
+			l.theme = wl.theme
+		}
+	}
+	ix.Unlock()
+	switch {
+	case args[0] == "Font" && len(args) == 1:
+		c.printf("%s: font %s\n", dot.tag, l.font)
+	case args[0] == "Theme" && len(args) == 1:
+		if l.theme == "" {
+			l.theme = ink.Themes[0]
+		}
+		c.printf("%s: theme %s (%s)\n", dot.tag, l.theme, strings.Join(ink.Themes, " "))
+	case args[0] == "Font":
+		if !isFont(args[1]) {
+			c.printf("Font: bad font %s\n--\n", args[1])
+			return
+		}
+		dot.win.SetFont(args[1])
+		ix.recordStyle(dot, args[1], "")
+	default:
+		if err := dot.win.SetTheme(args[1]); err != nil {
+			c.printf("Theme: %s\n--\n", err)
+			return
+		}
+		ix.recordStyle(dot, "", args[1])
+	}
+	c.printf("--\n")
+}
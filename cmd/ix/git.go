@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"clive/cmd"
+	"clive/zx"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	fpath "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Files within a git repo show in their tag the branch and
+	the status of the file (M when modified, ? when not tracked, etc.).
+	Only files in local trees are considered; git knows nothing of
+	the others.
+
+	The status is kept per repo, and git runs again for the repo
+	when a file in it is loaded or saved, or when its index changes
+	(checked when files are checked for changes on disk, see watch.go).
+*/
+
+// The status of the files in a repo.
+struct vcsRepo {
+	br    string
+	st    map[string]string // by path relative to the repo
+	mtime time.Time         // of the index when git was run
+}
+
+var (
+	vcslk    sync.Mutex
+	vcsrepos = map[string]*vcsRepo{}
+)
+
+// Run git in the dir of path and return its output.
+func git(path string, args ...string) ([]byte, error) {
+	args = append([]string{"-C", fpath.Dir(path)}, args...)
+	return exec.Command("git", args...).Output()
+}
+
+// Return the path in the local file system for a path in the
+// name space, or "" if it's not in a local tree.
+func localPath(path string) string {
+	_, mnts, err := cmd.NS().Resolve(path)
+	if err != nil || len(mnts) == 0 || mnts[0].Proto() != "lfs" {
+		return ""
+	}
+	toks := strings.Split(mnts[0]["addr"], "!") // lfs!root!/path
+	if len(toks) != 3 {
+		return ""
+	}
+	return fpath.Join(toks[1], toks[2])
+}
+
+// Return the top dir of the git repo for the local path, or "".
+func repoDir(lpath string) string {
+	for dir := fpath.Dir(lpath); ; dir = fpath.Dir(dir) {
+		if _, err := os.Stat(fpath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		if dir == "/" || dir == "." {
+			return ""
+		}
+	}
+}
+
+// Return the status of the repo at dir, running git if forced,
+// if it was never run, or if the index changed since.
+func repoStatus(dir string, force bool) *vcsRepo {
+	var mt time.Time
+	if fi, err := os.Stat(fpath.Join(dir, ".git", "index")); err == nil {
+		mt = fi.ModTime()
+	}
+	vcslk.Lock()
+	r := vcsrepos[dir]
+	vcslk.Unlock()
+	if r != nil && !force && r.mtime.Equal(mt) {
+		return r
+	}
+	out, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--branch").Output()
+	if err != nil {
+		return nil
+	}
+	r = &vcsRepo{st: map[string]string{}, mtime: mt}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		ln := sc.Text()
+		if strings.HasPrefix(ln, "## ") {
+			r.br = strings.TrimPrefix(ln[3:], "No commits yet on ")
+			if i := strings.Index(r.br, "..."); i >= 0 {
+				r.br = r.br[:i]
+			}
+			continue
+		}
+		if len(ln) > 3 {
+			name := ln[3:]
+			if i := strings.Index(name, " -> "); i >= 0 {
+				name = name[i+4:]
+			}
+			r.st[strings.Trim(name, `"`)] = strings.TrimSpace(ln[:2])
+		}
+	}
+	vcslk.Lock()
+	vcsrepos[dir] = r
+	vcslk.Unlock()
+	return r
+}
+
+// Return the vcs status for path to be shown in its tag,
+// or "" if it's not in a git repo.
+// Git runs again for its repo if force is set or the index changed.
+func vcsStatus(path string, force bool) string {
+	lpath := localPath(path)
+	if lpath == "" {
+		return ""
+	}
+	dir := repoDir(lpath)
+	if dir == "" {
+		return ""
+	}
+	r := repoStatus(dir, force)
+	if r == nil || r.br == "" {
+		return ""
+	}
+	rel := strings.TrimPrefix(zx.Suffix(lpath, dir), "/")
+	st := r.st[rel]
+	for st == "" && rel != "." {
+		// untracked dirs are listed, not the files within
+		rel = fpath.Dir(rel)
+		st = r.st[rel+"/"]
+	}
+	if st != "" {
+		return fmt.Sprintf(" [%s %s]", r.br, st)
+	}
+	return fmt.Sprintf(" [%s]", r.br)
+}
+
+// Set the tag for ed, including the position of dot, the vcs status,
+// encoding, conflicts, users editing, and verbs.
+func (ed *Ed) showTag() {
+	tag := ed.tag + ed.pos + ed.vcs
+	if ed.enc != "" {
+		tag += " [" + ed.enc + "]"
+	}
+	if ed.ro {
+		tag += " [ro]"
+	}
+	if ed.conflict {
+		tag += conflictTag
+	}
+	tag += ed.editing
+	tag += ed.verbsTag()
+	ed.win.SetTag(tag)
+}
+
+// Update the vcs status shown in the tag of ed.
+// If force is not set, git runs only if the index of the repo changed.
+func (ed *Ed) updVCS(force bool) {
+	if ed.temp || ed.iscmd || ed.d == nil || ed.d["type"] != "-" {
+		return
+	}
+	if s := vcsStatus(ed.path(), force); s != ed.vcs {
+		ed.vcs = s
+		ed.showTag()
+	}
+}
+
+// Return the diff between the text of ed and its file at HEAD.
+// Each hunk is preceded by the address for it in the file.
+func (ed *Ed) gitDiff() (string, error) {
+	lpath := localPath(ed.path())
+	if lpath == "" {
+		return "", fmt.Errorf("%s: not in a local tree", ed.tag)
+	}
+	old, err := git(lpath, "show", "HEAD:./"+fpath.Base(lpath))
+	if err != nil {
+		return "", fmt.Errorf("%s: not in HEAD", ed.tag)
+	}
+	f, err := ioutil.TempFile("", "ix")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(old)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return "", err
+	}
+	x := exec.Command("diff", "-u", "-L", "HEAD:"+ed.tag, "-L", ed.tag, f.Name(), "-")
+	x.Stdin = bytes.NewReader(ed.text())
+	out, err := x.Output()
+	if _, ok := err.(*exec.ExitError); ok && len(out) > 0 {
+		// diff exits with 1 when there are differences
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		ln := sc.Text()
+		var o0, o1, n0 int
+		if _, err := fmt.Sscanf(ln, "@@ -%d,%d +%d", &o0, &o1, &n0); err == nil {
+			fmt.Fprintf(&buf, "%s:%d\n", ed.tag, n0)
+		} else if _, err := fmt.Sscanf(ln, "@@ -%d +%d", &o0, &n0); err == nil {
+			fmt.Fprintf(&buf, "%s:%d\n", ed.tag, n0)
+		}
+		fmt.Fprintf(&buf, "%s\n", ln)
+	}
+	return buf.String(), nil
+}
+
+// Diff
+//	open a window with the diff between dot and its file at HEAD.
+//	Each hunk is preceded by its address, and looking at it
+//	shows the hunk in the window for the file.
+func bDiff(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.temp || dot.iscmd {
+		c.printf("Diff: no file at dot\n--\n")
+		return
+	}
+	s, err := dot.gitDiff()
+	if err != nil {
+		c.printf("Diff: %s\n--\n", err)
+		return
+	}
+	if s == "" {
+		c.printf("Diff: %s: no changes\n--\n", dot.tag)
+		return
+	}
+	ded := ix.newEdit(fpath.Join(dot.dir, "+Diff"))
+	ded.temp = true
+	ded.win.DoesntGetDirty()
+	ded.winid, _ = ix.pg.Add(ded.win)
+	ded.dot.P0, ded.dot.P1 = 0, ded.win.Len()
+	ded.replDot(s)
+	ded.dot.P0, ded.dot.P1 = 0, 0
+	ded.win.SetSel(0, 0)
+	c.printf("--\n")
+}
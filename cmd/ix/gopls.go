@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"clive/cmd"
+	"clive/zx"
+	"fmt"
+	"os/exec"
+	fpath "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	Def and Refs ask gopls for the definition of (or the references
+	to) the Go identifier at dot, and print their addresses.
+	The first one is shown, and looking again at the text selected
+	shows the next one (see IX.lookNext).
+	gopls reads the files from disk, so dot must be saved.
+*/
+
+// Run gopls for the identifier at dot in ed.
+func (ed *Ed) gopls(verb string) ([]byte, error) {
+	if ed.win.IsDirty() {
+		return nil, fmt.Errorf("%s: not saved", ed.tag)
+	}
+	ed.refreshDot()
+	rs := []rune(string(ed.text()))
+	p := ed.dot.P0
+	if p > len(rs) {
+		p = len(rs)
+	}
+	off := len(string(rs[:p]))
+	pos := fmt.Sprintf("%s:#%d", ed.tag, off)
+	x := exec.Command("gopls", verb, pos)
+	x.Dir = fpath.Dir(ed.tag)
+	out, err := x.Output()
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		ln := strings.SplitN(string(ee.Stderr), "\n", 2)[0]
+		err = fmt.Errorf("%s", strings.TrimSpace(ln))
+	}
+	return out, err
+}
+
+// gopls spans, as in /a/file.go:12:6-10 or /a/file.go:12:6-13:2,
+// with columns in bytes.
+var spanRe = regexp.MustCompile(`^(.+):([0-9]+):([0-9]+)(-(([0-9]+):)?([0-9]+))?$`)
+
+// Parse a gopls span into file name, lines, and columns.
+func parseSpan(s string) (string, int, int, int, int, bool) {
+	m := spanRe.FindStringSubmatch(s)
+	if m == nil {
+		return "", 0, 0, 0, 0, false
+	}
+	ln0, _ := strconv.Atoi(m[2])
+	col0, _ := strconv.Atoi(m[3])
+	ln1, col1 := ln0, col0
+	if m[6] != "" {
+		ln1, _ = strconv.Atoi(m[6])
+	}
+	if m[7] != "" {
+		col1, _ = strconv.Atoi(m[7])
+	}
+	return m[1], ln0, col0, ln1, col1, true
+}
+
+// Return the rune offset for line ln and byte column col in dat.
+func runeOff(dat []byte, ln, col int) int {
+	off := 0
+	for l := 1; l < ln; l++ {
+		i := bytes.IndexByte(dat, '\n')
+		if i < 0 {
+			break
+		}
+		off += utf8.RuneCount(dat[:i+1])
+		dat = dat[i+1:]
+	}
+	if col-1 > len(dat) {
+		col = len(dat) + 1
+	}
+	return off + utf8.RuneCount(dat[:col-1])
+}
+
+// Return the address for a gopls span.
+func spanAddr(s string) (zx.Addr, bool) {
+	name, ln0, col0, ln1, col1, ok := parseSpan(s)
+	if !ok {
+		return zx.Addr{}, false
+	}
+	a := zx.Addr{Name: name, Ln0: ln0, Ln1: ln1}
+	var dat []byte
+	if ed := ix.editFor(name); ed != nil && !ed.win.IsDirty() {
+		dat = ed.text()
+	} else if dat, _ = cmd.GetAll(name); dat == nil {
+		return a, true
+	}
+	a.P0, a.P1 = runeOff(dat, ln0, col0), runeOff(dat, ln1, col1)
+	return a, true
+}
+
+// Def
+//	print the definition for the Go identifier at dot and show it.
+// Refs
+//	print the references to the Go identifier at dot and show the
+//	first one; looking at it again shows the next one.
+func bGopls(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.iscmd || dot.temp || fpath.Ext(dot.tag) != ".go" {
+		c.printf("%s: no Go file at dot\n--\n", args[0])
+		return
+	}
+	verb := "definition"
+	if args[0] == "Refs" {
+		verb = "references"
+	}
+	out, err := dot.gopls(verb)
+	if err != nil {
+		c.printf("%s: %s\n--\n", args[0], err)
+		return
+	}
+	ix.cleanAddrs()
+	var first *zx.Addr
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// definition adds ": defined here as ..." after the span
+		sp := strings.SplitN(sc.Text(), ": ", 2)[0]
+		a, ok := spanAddr(strings.TrimSpace(sp))
+		if !ok {
+			continue
+		}
+		c.printf("%s\n", a)
+		ix.addAddr(a)
+		if first == nil {
+			first = &a
+		}
+	}
+	if first == nil {
+		c.printf("%s: nothing found\n--\n", args[0])
+		return
+	}
+	ix.pushJump(dot)
+	if ed := ix.lookFile(first.Name, "", -1); ed != nil {
+		ed.SetAddr(*first)
+	}
+	c.printf("--\n")
+}
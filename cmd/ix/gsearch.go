@@ -0,0 +1,61 @@
+package main
+
+import (
+	"clive/sre"
+	"clive/zx"
+	"strings"
+)
+
+// Gsearch expr
+//	search for expr in all edits (but commands windows),
+//	and print the addresses for the matches with their lines.
+//	The first match is shown, and looking at a match shown
+//	jumps to the next one, as with addresses printed by commands.
+func bGsearch(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	if len(args) < 2 {
+		c.printf("usage: Gsearch expr\n--\n")
+		return
+	}
+	expr := strings.Join(args[1:], " ")
+	re, err := sre.CompileStr(expr, sre.Fwd)
+	if err != nil {
+		c.printf("Gsearch: %s\n--\n", err)
+		return
+	}
+	ix.cleanAddrs()
+	n := 0
+	seen := map[*edbuf]bool{}
+	for _, ed := range ix.edits() {
+		if seen[ed.edbuf] || ed.iscmd || ed.d["type"] == "d" {
+			continue
+		}
+		seen[ed.edbuf] = true
+		rs := []rune(string(ed.text()))
+		for p := 0; p <= len(rs); {
+			m := re.ExecRunes(rs, p, len(rs))
+			if len(m) == 0 {
+				break
+			}
+			a := zx.Addr{Name: ed.tag, P0: m[0].P0, P1: m[0].P1}
+			a.Ln0, a.Ln1 = ed.win.LinesAt(a.P0, a.P1)
+			c.printf("%s\t%s\n", a, lineText(rs, a.P0))
+			if n == 0 {
+				ed.win.Show()
+				ed.SetAddr(a)
+			}
+			ix.addAddr(a)
+			n++
+			p = a.P1
+			if a.P1 == a.P0 {
+				p++
+			}
+		}
+	}
+	if n == 0 {
+		c.printf("Gsearch: no matches\n")
+	} else {
+		c.printf("%d matches\n", n)
+	}
+	c.printf("--\n")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	"fmt"
+	fpath "path"
+	"strconv"
+	"strings"
+)
+
+// Hex windows: a dump of the bytes of a file, edited with Hex.
+struct hexed {
+	path  string // file dumped
+	dat   []byte
+	dirty bool
+}
+
+// Return the dump for dat, 16 bytes per line, with the offset,
+// the bytes, and the printable ones.
+func hexDump(dat []byte) string {
+	var buf bytes.Buffer
+	for off := 0; off < len(dat); off += 16 {
+		end := off + 16
+		if end > len(dat) {
+			end = len(dat)
+		}
+		fmt.Fprintf(&buf, "%08x ", off)
+		for i := off; i < off+16; i++ {
+			if i%8 == 0 {
+				buf.WriteByte(' ')
+			}
+			if i < end {
+				fmt.Fprintf(&buf, "%02x ", dat[i])
+			} else {
+				buf.WriteString("   ")
+			}
+		}
+		buf.WriteString(" |")
+		for _, b := range dat[off:end] {
+			if b < ' ' || b > '~' {
+				b = '.'
+			}
+			buf.WriteByte(b)
+		}
+		buf.WriteString("|\n")
+	}
+	return buf.String()
+}
+
+// Show the dump in the hex window ed.
+func (ed *Ed) showHex() {
+	ed.dot.P0, ed.dot.P1 = 0, ed.win.Len()
+	ed.replDot(hexDump(ed.hex.dat))
+	ed.dot.P0, ed.dot.P1 = 0, 0
+	ed.win.SetSel(0, 0)
+	tag := ed.tag
+	if ed.hex.dirty {
+		tag += " [modified]"
+	}
+	ed.win.SetTag(tag)
+}
+
+// Set the bytes at off to those given in hex.
+func (h *hexed) set(off int, args []string) error {
+	var bs []byte
+	for _, a := range args {
+		b, err := strconv.ParseUint(a, 16, 8)
+		if err != nil {
+			return fmt.Errorf("bad byte %q", a)
+		}
+		bs = append(bs, byte(b))
+	}
+	if off+len(bs) > len(h.dat) {
+		h.dat = append(h.dat, make([]byte, off+len(bs)-len(h.dat))...)
+	}
+	copy(h.dat[off:], bs)
+	h.dirty = true
+	return nil
+}
+
+// Remove n bytes at off.
+func (h *hexed) del(off, n int) {
+	if off+n > len(h.dat) {
+		n = len(h.dat) - off
+	}
+	h.dat = append(h.dat[:off], h.dat[off+n:]...)
+	h.dirty = true
+}
+
+// Write the bytes to the file and reload its clean windows.
+func (h *hexed) write() error {
+	if err := cmd.PutAll(h.path, h.dat); err != nil {
+		return err
+	}
+	h.dirty = false
+	if ed := ix.editFor(h.path); ed != nil && !ed.win.IsDirty() {
+		ed.load(nil)
+	}
+	return nil
+}
+
+// Parse an offset in hex, as printed in the dump, perhaps with a 0x prefix.
+func parseOff(s string) (int64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return strconv.ParseInt(s, 16, 64)
+}
+
+// Hex
+//	open a window with a dump of the bytes of dot's file, read-only.
+// Hex off xx...
+//	with dot at a hex window, set the bytes at off
+//	(in hex, as shown in the dump, may be at the end to append).
+// Hex -d off [n]
+//	with dot at a hex window, remove n (1) bytes at off.
+// Hex w
+//	with dot at a hex window, write the bytes to the file.
+func bHex(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.iscmd || (dot.temp && dot.hex == nil) {
+		c.printf("Hex: no file at dot\n--\n")
+		return
+	}
+	if dot.hex == nil {
+		if len(args) > 1 {
+			c.printf("Hex: dot is not a hex window\n--\n")
+			return
+		}
+		dat, err := cmd.GetAll(dot.tag)
+		if err != nil {
+			c.printf("Hex: %s\n--\n", err)
+			return
+		}
+		hed := ix.newEdit(fpath.Join(dot.dir, "+Hex"))
+		hed.temp = true
+		hed.hex = &hexed{path: dot.tag, dat: dat}
+		hed.win.DoesntGetDirty()
+		hed.win.NoEdits()
+		hed.winid, _ = ix.pg.Add(hed.win)
+		hed.showHex()
+		c.printf("--\n")
+		return
+	}
+	h := dot.hex
+	if len(args) < 2 {
+		c.printf("%s: %d bytes\n--\n", h.path, len(h.dat))
+		return
+	}
+	var err error
+	switch args[1] {
+	case "w":
+		err = h.write()
+	case "-d":
+		n := 1
+		var off int64
+		if len(args) < 3 {
+			err = fmt.Errorf("usage: Hex -d off [n]")
+			break
+		}
+		off, err = parseOff(args[2])
+		if err == nil && len(args) > 3 {
+			n, err = strconv.Atoi(args[3])
+		}
+		if err == nil && (off < 0 || int(off) >= len(h.dat) || n < 0) {
+			err = fmt.Errorf("offset out of range")
+		}
+		if err == nil {
+			h.del(int(off), n)
+		}
+	default:
+		var off int64
+		off, err = parseOff(args[1])
+		if err == nil && (off < 0 || int(off) > len(h.dat)) {
+			err = fmt.Errorf("offset out of range")
+		}
+		if err == nil {
+			err = h.set(int(off), args[2:])
+		}
+	}
+	if err != nil {
+		c.printf("Hex: %s\n--\n", err)
+		return
+	}
+	dot.showHex()
+	c.printf("--\n")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+	Commands windows keep the lines executed, and so does ix
+	for all of them. Ctrl-up/down replace the line at dot with
+	the previous/next one in the window history (or the global one
+	if the window has none), and lines starting with ! recall
+	the last command (!!) or the last one starting with the prefix
+	given (!prefix).
+*/
+
+// Max number of lines kept in each history.
+var HistLen = 500
+
+// Add a line to the history, skipping repeats of the last one.
+func addHist(h []string, ln string) []string {
+	if len(h) > 0 && h[len(h)-1] == ln {
+		return h
+	}
+	h = append(h, ln)
+	if len(h) > HistLen {
+		h = h[len(h)-HistLen:]
+	}
+	return h
+}
+
+// Return the last line in h starting with pref.
+func findHist(h []string, pref string) (string, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if strings.HasPrefix(h[i], pref) {
+			return h[i], true
+		}
+	}
+	return "", false
+}
+
+func (ed *Ed) addHist(ln string) {
+	ed.hist = addHist(ed.hist, ln)
+	ed.histpos = len(ed.hist)
+	ed.ix.Lock()
+	ed.ix.hist = addHist(ed.ix.hist, ln)
+	ed.ix.Unlock()
+}
+
+// If ln is !! or !prefix, return the line recalled, looking
+// first in the window history and then in the global one.
+func (ed *Ed) recall(ln string) (string, bool) {
+	if ln == "!!" {
+		ln = "!"
+	}
+	pref := ln[1:]
+	if s, ok := findHist(ed.hist, pref); ok {
+		return s, true
+	}
+	ed.ix.Lock()
+	defer ed.ix.Unlock()
+	return findHist(ed.ix.hist, pref)
+}
+
+// Replace the line at dot with the previous/next line in the history.
+func (ed *Ed) histKey(prev bool) {
+	h := ed.hist
+	if len(h) == 0 {
+		ed.ix.Lock()
+		h = append([]string{}, ed.ix.hist...)
+		ed.ix.Unlock()
+	}
+	if len(h) == 0 {
+		return
+	}
+	if ed.histpos > len(h) {
+		ed.histpos = len(h)
+	}
+	if prev {
+		if ed.histpos == 0 {
+			return
+		}
+		ed.histpos--
+	} else {
+		if ed.histpos >= len(h) {
+			return
+		}
+		ed.histpos++
+	}
+	s := ""
+	if ed.histpos < len(h) {
+		s = h[ed.histpos]
+	}
+	ed.refreshDot()
+	rs := []rune(string(ed.text()))
+	p0, p1 := ed.dot.P0, ed.dot.P0
+	if p0 > len(rs) {
+		p0, p1 = len(rs), len(rs)
+	}
+	for p0 > 0 && rs[p0-1] != '\n' {
+		p0--
+	}
+	for p1 < len(rs) && rs[p1] != '\n' {
+		p1++
+	}
+	ed.dot.P0, ed.dot.P1 = p0, p1
+	ed.replDot(s)
+	ed.dot.P0 = ed.dot.P1
+	ed.setSel(ed.dot.P0, ed.dot.P1)
+}
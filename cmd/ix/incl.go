@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	fpath "path"
+	"strconv"
+	"strings"
+)
+
+/*
+	Looking at an include or import path in a C or Go file
+	opens the file (or dir) it refers to, as found in the
+	include path for the language of the file:
+		c: the dir of the file, $CPATH, /usr/local/include, /usr/include
+		go: $GOROOT/src, $GOPATH/src, and the module cache
+	$lookpath_c and $lookpath_go can be set to a list of dirs
+	(separated by :) to use instead.
+*/
+
+// Return the language for includes in the named file, or "".
+func inclLang(name string) string {
+	switch fpath.Ext(name) {
+	case ".c", ".h", ".cc", ".cpp", ".hpp", ".y", ".l":
+		return "c"
+	case ".go":
+		return "go"
+	}
+	return ""
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ":") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// Return the dirs to search for includes in lang.
+func (ed *Ed) inclPath(lang string) []string {
+	if p := cmd.GetEnv("lookpath_" + lang); p != "" {
+		return splitList(p)
+	}
+	switch lang {
+	case "c":
+		dirs := []string{ed.dir}
+		dirs = append(dirs, splitList(cmd.GetEnv("CPATH"))...)
+		return append(dirs, "/usr/local/include", "/usr/include")
+	case "go":
+		var dirs []string
+		if r := cmd.GetEnv("GOROOT"); r != "" {
+			dirs = append(dirs, fpath.Join(r, "src"))
+		}
+		for _, p := range splitList(cmd.GetEnv("GOPATH")) {
+			dirs = append(dirs, fpath.Join(p, "src"))
+		}
+		return dirs
+	}
+	return nil
+}
+
+// Escape path as done in the module cache, where upper case
+// letters are written as ! followed by the lower case letter.
+func modEscape(path string) string {
+	var b bytes.Buffer
+	for _, r := range path {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Compare two dot-separated lists of numbers or identifiers,
+// as found in semantic versions, and return -1, 0, or 1.
+func cmpIds(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return -1 // numbers go before identifiers
+		case berr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// Compare two module versions (eg. v1.10.0, v1.2.0-rc.1+incompatible)
+// following semantic versioning and return -1, 0, or 1.
+func cmpVers(a, b string) int {
+	split := func(v string) (string, string) {
+		v = strings.TrimPrefix(v, "v")
+		if i := strings.IndexByte(v, '+'); i >= 0 {
+			v = v[:i]
+		}
+		if i := strings.IndexByte(v, '-'); i >= 0 {
+			return v[:i], v[i+1:]
+		}
+		return v, ""
+	}
+	a, apre := split(a)
+	b, bpre := split(b)
+	if c := cmpIds(a, b); c != 0 {
+		return c
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1 // a release goes after its pre-releases
+	case bpre == "":
+		return -1
+	}
+	return cmpIds(apre, bpre)
+}
+
+// Look for the Go module for path in the module cache,
+// returning the dir for the latest version found.
+func modDir(path string) string {
+	for _, p := range splitList(cmd.GetEnv("GOPATH")) {
+		for mp := path; mp != "." && mp != "/"; mp = fpath.Dir(mp) {
+			dir, base := fpath.Split(fpath.Join(p, "pkg", "mod", modEscape(mp)))
+			ds, err := cmd.GetDir(dir)
+			if err != nil {
+				continue
+			}
+			last, lastv := "", ""
+			for _, d := range ds {
+				nm := d["name"]
+				if !strings.HasPrefix(nm, base+"@") {
+					continue
+				}
+				if v := nm[len(base)+1:]; last == "" || cmpVers(v, lastv) > 0 {
+					last, lastv = nm, v
+				}
+			}
+			if last == "" {
+				continue
+			}
+			dir = fpath.Join(dir, last, strings.TrimPrefix(path, mp))
+			if _, err := cmd.Stat(dir); err == nil {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+// If s is an include or import path for the language of ed,
+// return the path for the file or dir it refers to.
+func (ed *Ed) resolveIncl(s string) string {
+	lang := inclLang(ed.tag)
+	s = strings.Trim(s, "\"<>`")
+	if lang == "" || s == "" || fpath.IsAbs(s) {
+		return ""
+	}
+	var paths []string
+	for _, dir := range ed.inclPath(lang) {
+		paths = append(paths, fpath.Join(dir, s))
+	}
+	_, errs := cmd.Stats(paths...)
+	for i, p := range paths {
+		if errs[i] == nil {
+			return p
+		}
+	}
+	if lang == "go" && cmd.GetEnv("lookpath_go") == "" {
+		return modDir(s)
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCmpVers(t *testing.T) {
+	lt := [][2]string{
+		{"v1.9.0", "v1.10.0"},
+		{"v1.2.3", "v2.0.0"},
+		{"v1.0.0-rc.1", "v1.0.0"},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1"},
+		{"v1.0.0-alpha.1", "v1.0.0-beta"},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10"},
+		{"v2.0.0+incompatible", "v2.0.1+incompatible"},
+	}
+	for _, v := range lt {
+		if c := cmpVers(v[0], v[1]); c != -1 {
+			t.Fatalf("cmp %s %s: %d", v[0], v[1], c)
+		}
+		if c := cmpVers(v[1], v[0]); c != 1 {
+			t.Fatalf("cmp %s %s: %d", v[1], v[0], c)
+		}
+	}
+	if c := cmpVers("v1.2.0", "v1.2.0+meta"); c != 0 {
+		t.Fatalf("cmp eq: %d", c)
+	}
+}
+
+func TestModEscape(t *testing.T) {
+	if p := modEscape("github.com/BurntSushi/toml"); p != "github.com/!burnt!sushi/toml" {
+		t.Fatalf("escape: %s", p)
+	}
+}
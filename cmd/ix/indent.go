@@ -0,0 +1,132 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/txt"
+	fpath "path"
+	"strconv"
+	"strings"
+)
+
+/*
+	Indentation is set per file extension by $indent,
+	a list of ext=how, where how is tab or the number of spaces,
+	as in
+		indent=go=tab py=4 js=2 *=tab
+	with * for other files (tab by default).
+	New lines in edits get the indentation of the previous line,
+	and Indent and Unindent add or remove a level to the lines in dot.
+*/
+
+// Indentation for a file.
+struct indentOpts {
+	tabs  bool
+	width int // for spaces
+}
+
+// Return the indentation settings for the named file.
+func indentFor(name string) indentOpts {
+	ext := strings.TrimPrefix(fpath.Ext(name), ".")
+	o := indentOpts{tabs: true}
+	for _, f := range strings.Fields(cmd.GetEnv("indent")) {
+		toks := strings.SplitN(f, "=", 2)
+		if len(toks) != 2 || toks[0] != ext && toks[0] != "*" {
+			continue
+		}
+		if toks[1] == "tab" {
+			o = indentOpts{tabs: true}
+		} else if n, err := strconv.Atoi(toks[1]); err == nil && n > 0 {
+			o = indentOpts{width: n}
+		}
+		if toks[0] == ext {
+			break
+		}
+	}
+	return o
+}
+
+// Return the string for one level of indentation.
+func (o indentOpts) unit() string {
+	if o.tabs {
+		return "\t"
+	}
+	return strings.Repeat(" ", o.width)
+}
+
+// Remove one level of indentation from ln.
+func (o indentOpts) unindent(ln string) string {
+	if strings.HasPrefix(ln, "\t") {
+		return ln[1:]
+	}
+	max := o.width
+	if o.tabs {
+		max = txt.TabWidth
+	}
+	n := 0
+	for n < len(ln) && n < max && ln[n] == ' ' {
+		n++
+	}
+	return ln[n:]
+}
+
+// Called after a newline is inserted at p in ed to indent the new line
+// like the previous one.
+func (ed *Ed) autoIndent(p int) {
+	if ed.iscmd || ed.temp || ed.ro {
+		return
+	}
+	rs := []rune(string(ed.text()))
+	if p < 0 || p >= len(rs) || rs[p] != '\n' {
+		return
+	}
+	s := p
+	for s > 0 && rs[s-1] != '\n' {
+		s--
+	}
+	e := s
+	for e < p && (rs[e] == ' ' || rs[e] == '\t') {
+		e++
+	}
+	if e == s {
+		return
+	}
+	ind := rs[s:e]
+	t := ed.win.GetText()
+	t.ContdEdit()
+	t.Ins(ind, p+1)
+	ed.win.PutText()
+	np := p + 1 + len(ind)
+	ed.dot.P0, ed.dot.P1 = np, np
+	ed.setSel(np, np)
+}
+
+// Add (or remove) a level of indentation to the lines in dot.
+func (ed *Ed) reindent(more bool) {
+	o := indentFor(ed.tag)
+	ed.filterLines(func(lns []string) []string {
+		for i, ln := range lns {
+			if strings.TrimSpace(ln) == "" {
+				continue
+			}
+			if more {
+				lns[i] = o.unit() + ln
+			} else {
+				lns[i] = o.unindent(ln)
+			}
+		}
+		return lns
+	})
+}
+
+func bindent(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.iscmd || dot.temp || dot.ro {
+		c.printf("%s: no file at dot\n--\n", args[0])
+		return
+	}
+	dot.reindent(args[0] == "Indent")
+	c.printf("--\n")
+}
@@ -8,10 +8,14 @@ import (
 	"clive/cmd"
 	"clive/cmd/look"
 	"clive/cmd/opt"
+	"clive/cmd/run"
+	"clive/net/auth"
 	"clive/net/ink"
+	"clive/u"
 	"clive/zx"
 	"fmt"
 	fpath "path"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -23,14 +27,26 @@ struct IX {
 	cmds  []*Cmd
 	addrs []zx.Addr
 	sync.Mutex
-	msgs    *Ed // commands window used to notify the user
+	msgs    *Ed      // commands window used to notify the user
 	idgen   int
 	lookstr string
+	hist    []string // lines run in all commands windows (see hist.go)
+	macro   *macro   // recorded edits (see macro.go)
+	jumps   []jump   // recent dots (see jump.go)
+	jpos    int      // position in jumps for Back/Fwd
+
+	bmarks   map[string]jump // bookmarks set with Mark (see jump.go)
+	alerts   []*alert        // raised by commands (see alert.go)
+	alertgen int
+	nonotify bool                 // don't show alerts as browser notifications
+	styles   map[string]*winStyle // fonts and themes chosen (see font.go)
+	preview  string               // page element for the Preview panel (see preview.go)
 }
 
 var (
 	ix     *IX
-	rules  look.Rules
+	rules  look.Rules // from $look, or the default ones
+	rulesf *look.File // ~/lib/look or ~/.look, reloaded when changed
 	dryrun bool
 
 	defaultRules = `
@@ -40,7 +56,7 @@ var (
 )
 
 func newIX() *IX {
-	ix := &IX{}
+	ix := &IX{nonotify: cmd.GetEnv("ixnotify") == "off"}
 	cmds := ix.newCmds(cmd.Dot(), "")
 	if cmds == nil {
 		cmd.Fatal("can't create command window")
@@ -92,9 +108,6 @@ func (ix *IX) Warn(fmts string, arg ...face{}) {
 	}()
 }
 
-func (x *IX) quit() {
-}
-
 func (ix *IX) loop() {
 	cmd.Dprintf("%s started\n", ix)
 	defer cmd.Dprintf("%s terminated\n", ix)
@@ -114,8 +127,25 @@ func (ix *IX) loop() {
 					}
 				}()
 			case "quit":
-				// XXX: MUST save everything here.
-				cmd.Fatal("user quit")
+				go ix.quit(false)
+			}
+		case "click4":
+			// text dropped on a column
+			if len(ev.Args) < 3 {
+				continue
+			}
+			go ix.dropped(ev.Args[1], ev.Args[2])
+		case "upload":
+			if len(ev.Args) < 4 {
+				continue
+			}
+			go ix.upload(ev.Args[1], ev.Args[2], ev.Args[3])
+		case "notified":
+			if len(ev.Args) < 2 {
+				continue
+			}
+			if n, err := strconv.Atoi(ev.Args[1]); err == nil {
+				go ix.showAlert(n)
 			}
 		}
 	}
@@ -164,8 +194,7 @@ func (ix *IX) lookNext(a zx.Addr) {
 			((na.Ln0 == a.Ln0 && na.Ln1 == a.Ln1 && a.Ln0 > 0) ||
 				(na.P0 == a.P0 && na.P1 == a.P1)) && i < len(ix.addrs)-1 {
 			na = ix.addrs[i+1]
-			if ed := ix.editFor(na.Name); ed != nil {
-				ed.win.Show()
+			if ed := ix.lookFile(na.Name, "", -1); ed != nil {
 				ed.SetAddr(na)
 			}
 			break
@@ -192,15 +221,16 @@ func (ix *IX) lookCmds(dir string, at int) *Ed {
 func (ix *IX) lookFile(file, addr string, at int) *Ed {
 	file = cmd.AbsPath(strings.TrimSpace(file))
 	var ed *Ed
+	if addr != "" {
+		ix.pushJump(ix.dot)
+	}
 	if ed = ix.editFor(file); ed != nil {
 		ed.win.Show()
 	} else {
 		ed = ix.editFile(file, at)
 	}
 	if ed != nil && addr != "" {
-		a := zx.ParseAddr(addr)
-		a.Name = ed.tag
-		ed.SetAddr(a)
+		ed.SetAddr(ed.parseAddr(addr))
 	}
 	return ed
 }
@@ -224,6 +254,7 @@ func (ix *IX) editFile(what string, at int) *Ed {
 	ed := ix.newEdit(what)
 	ed.dir = dot
 	ed.load(d) // sets temp
+	ed.defLineNbs()
 	ed.winid, _ = ix.pg.AddAt(ed.win, at)
 	return ed
 }
@@ -254,9 +285,49 @@ func (ix *IX) layout() [][]*Ed {
 	return cols
 }
 
+// Look for messages posted to the plumber as if the user did
+// in the commands window for their dir (or the messages one).
+func (ix *IX) plumbs() {
+	mc, err := look.ServePlumb()
+	if err != nil {
+		ix.Warn("plumb: %s", err)
+		return
+	}
+	cmd.Dprintf("plumber at %s\n", look.PlumbAddr())
+	for m := range mc {
+		m := m
+		ed := ix.cmdsAt(m.Dir)
+		if ed == nil {
+			ix.Lock()
+			ed = ix.msgs
+			ix.Unlock()
+		}
+		if ed == nil {
+			ix.Warn("plumb: %s: no commands window", m)
+			continue
+		}
+		cmd.New(func() {
+			cmd.ForkDot()
+			if err := cmd.Cd(m.Dir); err != nil {
+				ix.Warn("plumb: %s", err)
+				return
+			}
+			ed.look(m.Data)
+		})
+	}
+}
+
 func makeRules() error {
-	r := cmd.DotFile("look")
+	rulesf = nil
+	r := cmd.GetEnv("look")
 	if r == "" {
+		for _, fn := range []string{fpath.Join(u.Home, "lib", "look"), fpath.Join(u.Home, ".look")} {
+			if _, err := cmd.Stat(fn); err == nil {
+				rulesf = &look.File{Path: fn}
+				_, err := rulesf.Rules()
+				return err
+			}
+		}
 		r = defaultRules
 	}
 	rs, err := look.ParseRules(r)
@@ -264,25 +335,86 @@ func makeRules() error {
 	return err
 }
 
+// Return the rules for looks at dir: those in .look files at dir
+// and its parents, and then the global ones.
+// Rule files are parsed again if they change.
+func lookRules(dir string) (look.Rules, error) {
+	rs, err := look.DirRules(dir)
+	grs := rules
+	if rulesf != nil {
+		var gerr error
+		grs, gerr = rulesf.Rules()
+		if err == nil {
+			err = gerr
+		}
+	}
+	return append(rs, grs...), err
+}
+
+// Load the ix config ($ix, or ~/.ix/config; ~/.ix is a directory
+// also keeping backups, uploads, and the profile).
+// Each line is name=value and sets an env var for ix and the commands
+// it runs, eg. dirsort=natural sorts dir windows in natural order.
+func loadConfig() {
+	cfg := cmd.GetEnv("ix")
+	if cfg == "" {
+		dat, err := cmd.GetAll(fpath.Join(u.Home, ".ix", "config"))
+		if err != nil {
+			return
+		}
+		cfg = string(dat)
+	}
+	for _, ln := range strings.Split(cfg, "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || ln[0] == '#' {
+			continue
+		}
+		toks := strings.SplitN(ln, "=", 2)
+		if len(toks) != 2 {
+			cmd.Warn("ix config: bad line '%s'", ln)
+			continue
+		}
+		cmd.SetEnv(strings.TrimSpace(toks[0]), strings.TrimSpace(toks[1]))
+	}
+}
+
 func main() {
 	opts := opt.New("{file}")
 	c := cmd.AppCtx()
 	opts.NewFlag("D", "debug", &c.Debug)
 	opts.NewFlag("I", "debug ink", &ink.Debug)
 	opts.NewFlag("n", "dry run (don't ever save)", &dryrun)
-	var dmpf string
+	var dmpf, pols, acmes string
 	opts.NewFlag("l", "file: load the session from the given file", &dmpf)
+	opts.NewFlag("S", "policy: restrict commands run (eg. 'deny=HOME paths=/tmp sandbox')", &pols)
+	opts.NewFlag("C", "acme: get certificates from an ACME CA (eg. 'domains=a.org email=me@a.org')", &acmes)
 	cmd.UnixIO()
 	args := opts.Parse()
+	if acmes != "" {
+		a, err := auth.ParseACME(acmes)
+		if err == nil {
+			err = a.Enable()
+		}
+		if err != nil {
+			cmd.Fatal(err)
+		}
+	}
+	if pols != "" {
+		pol, err := run.ParsePolicy(pols)
+		if err != nil {
+			cmd.Fatal(err)
+		}
+		run.SetPolicy(c, pol)
+	}
 	look.Debug = c.Debug
+	if err := cmd.LoadEnv(); err != nil {
+		cmd.Warn("env: %s", err)
+	}
+	loadConfig()
 	ix = newIX()
+	ix.serveFS() // before the profile may use it
 	ink.ServeZX()
 	done := make(chan bool)
-	go func() {
-		if err := ink.Serve(); err != nil {
-			cmd.Fatal("can't listen")
-		}
-	}()
 	go func() {
 		ix.loop()
 		close(done)
@@ -303,6 +435,18 @@ func main() {
 	if err != nil {
 		ix.Warn("rules: %s", err)
 	}
+	if err := loadVerbs(); err != nil {
+		ix.Warn("verbs: %s", err)
+	}
+	ix.runProfile()
+	go func() {
+		if err := ink.Serve(); err != nil {
+			cmd.Fatal("can't listen")
+		}
+	}()
+	ix.checkBackups()
+	go ix.plumbs()
+	go ix.watch()
 	if dmpf != "" {
 		if err := ix.load(dmpf); err != nil {
 			ix.Warn("load: %s: %s", dmpf, err)
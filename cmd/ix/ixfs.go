@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	"clive/net/auth"
+	"clive/net/ink"
+	"clive/ns"
+	"clive/u"
+	"clive/zx"
+	"clive/zx/rzx"
+	"fmt"
+	"os"
+	fpath "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	The ix file system exports the editor state as a zx tree,
+	like acme does with 9p, so commands can script the editor
+	using cmd.Get and cmd.Put.
+
+	It's served at unix!local!ix.$user.$pid, which is set in $ixfs,
+	and mounted at /ix in the name space of ix and its commands:
+
+		/ix/index	one line per window: id, kind, and tag
+		/ix/ctl	puts make requests to ix (see profile.go)
+		/ix/<id>/body	the text; puts replace it, or append with off<0
+		/ix/<id>/addr	dot, as in :#p0,#p1; puts set it (eg. :12 or :#3,#5)
+		/ix/<id>/tag	the file name
+		/ix/<id>/ctl	the index line; puts make requests, one per line:
+				save, get, clean, dirty, show, del, name path, ro, rw
+		/ix/<id>/event	events for the window, one per line,
+				until the window is gone
+
+	The id is that of the window in the ink page.
+*/
+struct ixfs {
+	ix *IX
+}
+
+var ixfiles = []string{"addr", "body", "ctl", "event", "tag"}
+
+func isIxFile(name string) bool {
+	for _, f := range ixfiles {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve the ix file system and mount it at /ix.
+// Each ix uses its own address, or it would be serving the
+// windows of another (eg. when ix is run from ix).
+func (ix *IX) serveFS() {
+	addr := fmt.Sprintf("unix!local!ix.%s.%d", u.Uid, os.Getpid())
+	srv, err := rzx.NewServer(addr, auth.TLSserver)
+	if err != nil {
+		cmd.Fatal("ixfs: %s", err)
+	}
+	if err := srv.Serve("main", &ixfs{ix}); err != nil {
+		cmd.Fatal("ixfs: %s", err)
+	}
+	cmd.SetEnv("ixfs", addr)
+	d := zx.Dir{"path": "/ix", "addr": "zx!" + addr + "!main!/"}
+	if err := cmd.NS().Mount(d, ns.Repl); err != nil {
+		cmd.Warn("ixfs: %s", err)
+	}
+}
+
+func (fs *ixfs) String() string {
+	return "ixfs"
+}
+
+// Return the window and file name for p.
+// The window is nil for /, /index, and /ctl, and the name is "" for dirs.
+func (fs *ixfs) walk(p string) (*Ed, string, error) {
+	p = fpath.Clean(p)
+	if p == "/" {
+		return nil, "", nil
+	}
+	els := strings.Split(p[1:], "/")
+	if len(els) == 1 && (els[0] == "index" || els[0] == "ctl") {
+		return nil, els[0], nil
+	}
+	ed := fs.ix.winEd(els[0])
+	if ed == nil || len(els) > 2 || len(els) == 2 && !isIxFile(els[1]) {
+		return nil, "", fmt.Errorf("%s: %s", p, zx.ErrNotExist)
+	}
+	if len(els) == 1 {
+		return ed, "", nil
+	}
+	return ed, els[1], nil
+}
+
+func ixdir(p, typ string, size int) zx.Dir {
+	mode := "0644"
+	if typ == "d" {
+		mode = "0755"
+	}
+	d := zx.Dir{
+		"path": p,
+		"name": fpath.Base(p),
+		"type": typ,
+		"mode": mode,
+		"uid":  u.Uid,
+		"gid":  u.Uid,
+		"wuid": u.Uid,
+	}
+	d.SetSize(int64(size))
+	d.SetTime("mtime", time.Now())
+	return d
+}
+
+func (ed *Ed) indexLine() string {
+	return fmt.Sprintf("%s %s\n", ed.winid, ed.menuLine())
+}
+
+func (fs *ixfs) index() []byte {
+	var buf bytes.Buffer
+	fs.ix.Lock()
+	eds := append([]*Ed{}, fs.ix.eds...)
+	fs.ix.Unlock()
+	for _, ed := range eds {
+		if ed.winid != "" {
+			buf.WriteString(ed.indexLine())
+		}
+	}
+	return buf.Bytes()
+}
+
+// Return the data for the named file of ed.
+func (fs *ixfs) data(ed *Ed, name string) []byte {
+	switch name {
+	case "index":
+		return fs.index()
+	case "body":
+		return ed.text()
+	case "addr":
+		ed.refreshDot()
+		return []byte(ed.dot.String() + "\n")
+	case "tag":
+		return []byte(ed.tag + "\n")
+	case "ctl":
+		if ed == nil {
+			return nil
+		}
+		return []byte(ed.indexLine())
+	}
+	return nil
+}
+
+func (fs *ixfs) stat(p string) (zx.Dir, error) {
+	ed, name, err := fs.walk(p)
+	if err != nil {
+		return nil, err
+	}
+	p = fpath.Clean(p)
+	switch name {
+	case "":
+		n := len(ixfiles)
+		if ed == nil {
+			n = len(fs.ix.winIds()) + 2
+		}
+		return ixdir(p, "d", n), nil
+	case "event":
+		return ixdir(p, "-", 0), nil
+	default:
+		return ixdir(p, "-", len(fs.data(ed, name))), nil
+	}
+}
+
+// Return the ids for the windows.
+func (ix *IX) winIds() []string {
+	ix.Lock()
+	defer ix.Unlock()
+	var ids []string
+	for _, ed := range ix.eds {
+		if ed.winid != "" {
+			ids = append(ids, ed.winid)
+		}
+	}
+	return ids
+}
+
+func (fs *ixfs) Stat(p string) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	d, err := fs.stat(p)
+	if err == nil {
+		c <- d
+	}
+	close(c, err)
+	return c
+}
+
+func (fs *ixfs) Get(p string, off, count int64) <-chan []byte {
+	c := make(chan []byte)
+	go func() {
+		close(c, fs.get(p, off, count, c))
+	}()
+	return c
+}
+
+func (fs *ixfs) get(p string, off, count int64, c chan []byte) error {
+	ed, name, err := fs.walk(p)
+	if err != nil {
+		return err
+	}
+	p = fpath.Clean(p)
+	switch name {
+	case "":
+		var ds []zx.Dir
+		if ed == nil {
+			ds = append(ds, ixdir("/index", "-", len(fs.index())))
+			ds = append(ds, ixdir("/ctl", "-", 0))
+			for _, id := range fs.ix.winIds() {
+				ds = append(ds, ixdir("/"+id, "d", len(ixfiles)))
+			}
+		} else {
+			for _, f := range ixfiles {
+				d, err := fs.stat(fpath.Join(p, f))
+				if err == nil {
+					ds = append(ds, d)
+				}
+			}
+		}
+		for i, d := range ds {
+			if int64(i) < off {
+				continue
+			}
+			if count >= 0 && int64(i) >= off+count {
+				break
+			}
+			if ok := c <- d.Bytes(); !ok {
+				return cerror(c)
+			}
+		}
+		return nil
+	case "event":
+		return ed.events(c)
+	}
+	dat := fs.data(ed, name)
+	if off > int64(len(dat)) {
+		off = int64(len(dat))
+	}
+	dat = dat[off:]
+	if count >= 0 && count < int64(len(dat)) {
+		dat = dat[:count]
+	}
+	if len(dat) > 0 {
+		if ok := c <- dat; !ok {
+			return cerror(c)
+		}
+	}
+	return nil
+}
+
+func (fs *ixfs) Put(p string, d zx.Dir, off int64, dc <-chan []byte) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	go func() {
+		var buf bytes.Buffer
+		for dat := range dc {
+			buf.Write(dat)
+		}
+		err := cerror(dc)
+		if err == nil {
+			err = fs.put(p, off, buf.String())
+		}
+		if err == nil {
+			var rd zx.Dir
+			rd, err = fs.stat(p)
+			if err == nil {
+				c <- rd
+			}
+		}
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *ixfs) put(p string, off int64, s string) error {
+	ed, name, err := fs.walk(p)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "body":
+		ed.putBody(s, off < 0)
+		return nil
+	case "addr":
+		a := zx.ParseAddr("x" + strings.TrimSpace(s))
+		a.Name = ed.tag
+		ed.SetAddr(a)
+		return nil
+	case "ctl":
+		for _, ln := range strings.Split(s, "\n") {
+			if ed == nil {
+				err = fs.ix.ctl(ln)
+			} else {
+				err = ed.ctl(ln)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "":
+		return fmt.Errorf("%s: %s", p, zx.ErrIsDir)
+	}
+	return fmt.Errorf("%s: %s", p, zx.ErrPerm)
+}
+
+// Replace the text of ed with s, or append s to it.
+func (ed *Ed) putBody(s string, app bool) {
+	t := ed.win.GetText()
+	n := t.Len()
+	del := !app && n > 0
+	if del {
+		t.Del(0, n)
+		n = 0
+	}
+	if s != "" {
+		if del {
+			t.ContdEdit()
+		}
+		t.Ins([]rune(s), n)
+	}
+	ed.win.PutText()
+	if !ed.temp {
+		ed.win.Dirty()
+		ed.autosave()
+	}
+}
+
+// Process a ctl request for ed.
+func (ed *Ed) ctl(ln string) error {
+	toks := strings.Fields(ln)
+	if len(toks) == 0 {
+		return nil
+	}
+	switch toks[0] {
+	case "save":
+		if err := ed.save(); err != nil && err != notDirty {
+			return err
+		}
+	case "get":
+		if ed.iscmd {
+			return fmt.Errorf("get: %s", zx.ErrBadCtl)
+		}
+		return ed.load(nil)
+	case "clean":
+		ed.win.Clean()
+	case "ro", "rw":
+		if ed.temp || ed.iscmd {
+			return fmt.Errorf("%s: %s", toks[0], zx.ErrBadCtl)
+		}
+		ed.setRO(toks[0] == "ro")
+	case "dirty":
+		if !ed.temp && !ed.ro {
+			ed.win.Dirty()
+			ed.autosave()
+		}
+	case "show":
+		ed.win.Show()
+	case "del":
+		ed.del()
+	case "name":
+		if len(toks) != 2 {
+			return fmt.Errorf("name: %s", zx.ErrBadCtl)
+		}
+		return ed.move(toks[1])
+	default:
+		return fmt.Errorf("%s: %s", toks[0], zx.ErrBadCtl)
+	}
+	return nil
+}
+
+// Post an event to those reading the event file of ed.
+// Args are quoted so that events are a single line.
+func (ed *Ed) postEv(ev *ink.Ev) {
+	ed.ix.Lock()
+	defer ed.ix.Unlock()
+	if len(ed.evcs) == 0 {
+		return
+	}
+	s := ev.Args[0]
+	for _, a := range ev.Args[1:] {
+		s += " " + strconv.Quote(a)
+	}
+	s += "\n"
+	for _, ec := range ed.evcs {
+		select {
+		case ec <- s:
+		default:
+			// slow reader, drop it
+		}
+	}
+}
+
+// Stop posting events for ed; called with ix locked.
+func (ed *Ed) closeEvs() {
+	for _, ec := range ed.evcs {
+		close(ec)
+	}
+	ed.evcs = nil
+}
+
+// Send the events for ed to c until it's gone or c is closed.
+func (ed *Ed) events(c chan []byte) error {
+	ec := make(chan string, 64)
+	ed.ix.Lock()
+	ed.evcs = append(ed.evcs, ec)
+	ed.ix.Unlock()
+	defer func() {
+		ed.ix.Lock()
+		defer ed.ix.Unlock()
+		for i, e := range ed.evcs {
+			if e == ec {
+				copy(ed.evcs[i:], ed.evcs[i+1:])
+				ed.evcs = ed.evcs[:len(ed.evcs)-1]
+				break
+			}
+		}
+	}()
+	for s := range ec {
+		if ok := c <- []byte(s); !ok {
+			return cerror(c)
+		}
+	}
+	return nil
+}
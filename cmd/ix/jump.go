@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"clive/zx"
+	"fmt"
+	"sort"
+)
+
+/*
+	Bookmarks are named positions in files, set at dot with Mark
+	and shown again with Goto. They are kept as marks in the text
+	while the file is being edited, so they move along with edits.
+
+	The jump list records the dot left behind when looking at a file
+	or moving to another window, so that Back and Fwd can go through
+	the recent dots across windows.
+*/
+
+// Max number of dots kept in the jump list.
+var JumpLen = 100
+
+// A position in a file
+struct jump {
+	path   string
+	P0, P1 int
+}
+
+func (j jump) String() string {
+	return fmt.Sprintf("%s:#%d,#%d", j.path, j.P0, j.P1)
+}
+
+func bmarkName(name string, i int) string {
+	return fmt.Sprintf("bm:%s.%d", name, i)
+}
+
+// Return the position of dot in ed, if it's a file.
+func (ed *Ed) jumpAt() (jump, bool) {
+	if ed == nil || ed.iscmd || ed.temp || ed.hex != nil {
+		return jump{}, false
+	}
+	ed.refreshDot()
+	return jump{ed.tag, ed.dot.P0, ed.dot.P1}, true
+}
+
+// Add the dot of ed to the jump list, dropping the forward entries.
+func (ix *IX) pushJump(ed *Ed) {
+	j, ok := ed.jumpAt()
+	if !ok {
+		return
+	}
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.jpos < len(ix.jumps) {
+		ix.jumps = ix.jumps[:ix.jpos+1]
+	}
+	if n := len(ix.jumps); n == 0 || ix.jumps[n-1] != j {
+		ix.jumps = append(ix.jumps, j)
+	}
+	if len(ix.jumps) > JumpLen {
+		ix.jumps = ix.jumps[len(ix.jumps)-JumpLen:]
+	}
+	ix.jpos = len(ix.jumps)
+}
+
+// Move back (or forward) in the jump list, returning the position to go.
+func (ix *IX) moveJump(back bool) (jump, bool) {
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	cur, curok := dot.jumpAt()
+	ix.Lock()
+	defer ix.Unlock()
+	if back {
+		if ix.jpos >= len(ix.jumps) {
+			if n := len(ix.jumps); curok && (n == 0 || ix.jumps[n-1] != cur) {
+				ix.jumps = append(ix.jumps, cur)
+			}
+			ix.jpos = len(ix.jumps) - 1
+		}
+		if ix.jpos <= 0 {
+			return jump{}, false
+		}
+		ix.jpos--
+	} else {
+		if ix.jpos+1 >= len(ix.jumps) {
+			return jump{}, false
+		}
+		ix.jpos++
+	}
+	return ix.jumps[ix.jpos], true
+}
+
+// Show the file for j and set its dot, without recording a jump.
+func (ix *IX) goJump(j jump) error {
+	ed := ix.editFor(j.path)
+	if ed != nil {
+		ed.win.Show()
+	} else if ed = ix.editFile(j.path, -1); ed == nil {
+		return fmt.Errorf("%s: can't edit", j.path)
+	}
+	n := ed.win.Len()
+	if j.P1 > n {
+		j.P1 = n
+	}
+	if j.P0 > j.P1 {
+		j.P0 = j.P1
+	}
+	ed.SetAddr(zx.Addr{Name: j.path, P0: j.P0, P1: j.P1})
+	return nil
+}
+
+// Set the bookmark name at dot in ed.
+func (ix *IX) setBmark(name string, ed *Ed) error {
+	j, ok := ed.jumpAt()
+	if !ok {
+		return fmt.Errorf("no file at dot")
+	}
+	ix.Lock()
+	if ix.bmarks == nil {
+		ix.bmarks = map[string]jump{}
+	}
+	ix.bmarks[name] = j
+	ix.Unlock()
+	ed.win.SetMark(bmarkName(name, 0), j.P0)
+	ed.win.SetMark(bmarkName(name, 1), j.P1)
+	return nil
+}
+
+// Return the position for the bookmark name, as moved by edits
+// if its file is being edited.
+func (ix *IX) bmark(name string) (jump, bool) {
+	ix.Lock()
+	j, ok := ix.bmarks[name]
+	ix.Unlock()
+	if !ok {
+		return j, false
+	}
+	if ed := ix.editFor(j.path); ed != nil {
+		m0, m1 := ed.win.Mark(bmarkName(name, 0)), ed.win.Mark(bmarkName(name, 1))
+		if m0 != nil && m1 != nil {
+			j.P0, j.P1 = m0.Off, m1.Off
+		}
+	}
+	return j, true
+}
+
+// Mark [name]
+//	set the bookmark name at dot, or list the bookmarks.
+// Goto name
+//	show the file for the bookmark and set dot there.
+func bMark(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	var names []string
+	for n := range ix.bmarks {
+		names = append(names, n)
+	}
+	ix.Unlock()
+	switch {
+	case args[0] == "Mark" && len(args) == 1:
+		sort.Strings(names)
+		var buf bytes.Buffer
+		for _, n := range names {
+			if j, ok := ix.bmark(n); ok {
+				fmt.Fprintf(&buf, "%s\t%s\n", n, j)
+			}
+		}
+		c.printf("%s", buf.String())
+	case args[0] == "Mark" && len(args) == 2:
+		if err := ix.setBmark(args[1], dot); err != nil {
+			c.printf("Mark: %s\n--\n", err)
+			return
+		}
+	case args[0] == "Goto" && len(args) == 2:
+		j, ok := ix.bmark(args[1])
+		if !ok {
+			c.printf("Goto: %s: no such bookmark\n--\n", args[1])
+			return
+		}
+		ix.pushJump(dot)
+		if err := ix.goJump(j); err != nil {
+			c.printf("Goto: %s\n--\n", err)
+			return
+		}
+	default:
+		c.printf("usage: Mark [name] | Goto name\n")
+	}
+	c.printf("--\n")
+}
+
+// Back
+//	show the previous dot in the jump list.
+// Fwd
+//	show the next dot in the jump list.
+func bJump(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	j, ok := ix.moveJump(args[0] == "Back")
+	if !ok {
+		c.printf("%s: no more jumps\n--\n", args[0])
+		return
+	}
+	if err := ix.goJump(j); err != nil {
+		c.printf("%s: %s\n", args[0], err)
+	}
+	c.printf("--\n")
+}
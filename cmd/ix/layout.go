@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Return the column for name, which might be its number.
+// Unknown names are given to the first empty unnamed column,
+// or to a new column if there's none.
+func (ix *IX) colFor(name string) (int, error) {
+	names := ix.pg.ColNames()
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 0 || n >= len(names) {
+			return -1, fmt.Errorf("no column %d", n)
+		}
+		return n, nil
+	}
+	for i, nm := range names {
+		if nm == name {
+			return i, nil
+		}
+	}
+	cols := ix.pg.Cols()
+	for i, nm := range names {
+		if nm == "" && len(cols[i]) == 0 {
+			return i, ix.pg.NameCol(i, name)
+		}
+	}
+	n := len(names)
+	ix.pg.SetNumCols(n + 1)
+	return n, ix.pg.NameCol(n, name)
+}
+
+// Return the column showing ed, or -1.
+func (ix *IX) colOf(ed *Ed) int {
+	for i, col := range ix.pg.Cols() {
+		for _, id := range col {
+			if id == ed.winid {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Col
+//	print the columns and their windows
+// Col col
+//	move dot to the column, given by number or name.
+//	unknown names make new columns.
+// Col -n name
+//	name the column for dot
+// Col -c | -e | -m
+//	collapse, expand, or maximize dot
+func bCol(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if len(args) == 1 {
+		names := ix.pg.ColNames()
+		for i, col := range ix.layout() {
+			c.printf("%d %s\n", i, names[i])
+			for _, ed := range col {
+				c.printf("\t%s\n", ed.tag)
+			}
+		}
+		c.printf("--\n")
+		return
+	}
+	if dot == nil || dot.winid == "" {
+		c.printf("Col: no dot\n--\n")
+		return
+	}
+	var err error
+	switch args[1] {
+	case "-c":
+		ix.pg.Collapse(dot.winid)
+	case "-e":
+		ix.pg.Expand(dot.winid)
+	case "-m":
+		ix.pg.Maximize(dot.winid)
+	case "-n":
+		if len(args) < 3 {
+			err = fmt.Errorf("usage: Col -n name")
+			break
+		}
+		n := ix.colOf(dot)
+		if n < 0 {
+			err = fmt.Errorf("%s: not shown", dot)
+			break
+		}
+		err = ix.pg.NameCol(n, args[2])
+	default:
+		var n int
+		n, err = ix.colFor(args[1])
+		if err == nil {
+			err = ix.pg.Move(dot.winid, n)
+		}
+	}
+	if err != nil {
+		c.printf("Col: %s\n", err)
+	}
+	c.printf("--\n")
+}
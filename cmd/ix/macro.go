@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+	Macros record the edits (and moves of dot) made by the user
+	in a window, with offsets relative to dot when the recording
+	started, so they can be played again at a different dot.
+	There's a single macro, kept by ix.
+*/
+
+// A recorded event: eins text p0, edel p0 p1, or tick p0 p1,
+// with offsets relative to the start of the recording.
+struct macroEv {
+	op     string
+	text   string
+	p0, p1 int
+}
+
+struct macro {
+	ed   *Ed // being recorded, or nil
+	base int
+	evs  []macroEv
+}
+
+// Record the edit event ev for ed if a macro is being recorded there.
+func (ix *IX) record(ed *Ed, args []string) {
+	ix.Lock()
+	defer ix.Unlock()
+	m := ix.macro
+	if m == nil || m.ed == nil || m.ed.edbuf != ed.edbuf || len(args) < 3 {
+		return
+	}
+	mev := macroEv{op: args[0]}
+	var err0, err1 error
+	switch args[0] {
+	case "eins":
+		mev.text = args[1]
+		mev.p0, err0 = strconv.Atoi(args[2])
+		mev.p1 = mev.p0
+	case "edel", "tick":
+		mev.p0, err0 = strconv.Atoi(args[1])
+		mev.p1, err1 = strconv.Atoi(args[2])
+	default:
+		return
+	}
+	if err0 != nil || err1 != nil {
+		return
+	}
+	mev.p0 -= m.base
+	mev.p1 -= m.base
+	if n := len(m.evs); args[0] == "tick" && n > 0 && m.evs[n-1].op == "tick" {
+		m.evs[n-1] = mev
+		return
+	}
+	m.evs = append(m.evs, mev)
+}
+
+// Play the macro evs at dot in ed.
+func (ed *Ed) play(evs []macroEv) error {
+	ed.refreshDot()
+	base := ed.dot.P0
+	t := ed.win.GetText()
+	defer ed.win.PutText()
+	p0, p1 := ed.dot.P0, ed.dot.P1
+	for i, mev := range evs {
+		q0, q1 := base+mev.p0, base+mev.p1
+		if q0 < 0 || q1 < q0 || q1 > t.Len() {
+			return fmt.Errorf("macro out of the text")
+		}
+		if i > 0 {
+			t.ContdEdit()
+		}
+		switch mev.op {
+		case "eins":
+			if err := t.Ins([]rune(mev.text), q0); err != nil {
+				return err
+			}
+			p0 = q0 + len([]rune(mev.text))
+			p1 = p0
+		case "edel":
+			t.Del(q0, q1-q0)
+			p0, p1 = q0, q0
+		case "tick":
+			p0, p1 = q0, q1
+		}
+	}
+	ed.dot.P0, ed.dot.P1 = p0, p1
+	ed.setSel(p0, p1)
+	return nil
+}
+
+// Macro start
+//	start recording edits at dot's window.
+// Macro stop
+//	stop recording.
+// Macro play [n]
+//	play the edits recorded at dot, n times (1).
+func bMacro(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if len(args) < 2 {
+		c.printf("usage: Macro start|stop|play [n]\n--\n")
+		return
+	}
+	switch args[1] {
+	case "start":
+		if dot == nil || dot.iscmd || dot.ro {
+			c.printf("Macro: no edit at dot\n--\n")
+			return
+		}
+		dot.refreshDot()
+		ix.Lock()
+		ix.macro = &macro{ed: dot, base: dot.dot.P0}
+		ix.Unlock()
+		c.printf("recording at %s\n", dot.tag)
+	case "stop":
+		ix.Lock()
+		m := ix.macro
+		if m != nil {
+			m.ed = nil
+		}
+		ix.Unlock()
+		if m == nil {
+			c.printf("Macro: not recording\n--\n")
+			return
+		}
+		c.printf("%d events recorded\n", len(m.evs))
+	case "play":
+		n := 1
+		if len(args) > 2 {
+			var err error
+			if n, err = strconv.Atoi(args[2]); err != nil || n < 1 {
+				c.printf("Macro: bad count %s\n--\n", args[2])
+				return
+			}
+		}
+		ix.Lock()
+		m := ix.macro
+		var evs []macroEv
+		if m != nil && m.ed == nil {
+			evs = append(evs, m.evs...)
+		}
+		ix.Unlock()
+		if len(evs) == 0 {
+			c.printf("Macro: nothing to play\n--\n")
+			return
+		}
+		if dot == nil || dot.iscmd || dot.ro {
+			c.printf("Macro: no edit at dot\n--\n")
+			return
+		}
+		for i := 0; i < n; i++ {
+			if err := dot.play(evs); err != nil {
+				c.printf("Macro: %s\n", err)
+				break
+			}
+		}
+		dot.win.Dirty()
+		dot.autosave()
+	default:
+		c.printf("usage: Macro start|stop|play [n]\n")
+	}
+	c.printf("--\n")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"clive/cmd"
+	"fmt"
+)
+
+/*
+	The tag of file windows shows the line and column for the
+	start of dot, as in /a/file:12:5, updated as dot moves,
+	so it can be compared with (or copied from) compiler errors.
+	Lines shows line numbers at the left of the text.
+	They are shown for new file windows if $ixlines is on.
+*/
+
+// Return the line:col position for dot in ed.
+func (ed *Ed) dotPos() string {
+	ln := ed.win.LineAt(ed.dot.P0)
+	col := ed.dot.P0 - ed.win.LineOff(ln) + 1
+	if col < 1 {
+		col = 1
+	}
+	return fmt.Sprintf(":%d:%d", ln, col)
+}
+
+// Update the position of dot shown in the tag of ed.
+func (ed *Ed) showPos() {
+	if ed.iscmd || ed.temp || ed.hex != nil || ed.d == nil || ed.d["type"] != "-" {
+		return
+	}
+	if pos := ed.dotPos(); pos != ed.pos {
+		ed.pos = pos
+		ed.showTag()
+	}
+}
+
+// Show line numbers in file windows if $ixlines is on.
+func (ed *Ed) defLineNbs() {
+	if !ed.iscmd && !ed.temp && cmd.GetEnv("ixlines") == "on" {
+		ed.win.ShowLineNbs(true)
+	}
+}
+
+// Lines [on|off]
+//	print or set if line numbers are shown for dot.
+func bLines(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil {
+		c.printf("Lines: no window\n--\n")
+		return
+	}
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		dot.win.ShowLineNbs(args[1] == "on")
+	default:
+		c.printf("usage: Lines [on|off]\n--\n")
+		return
+	}
+	st := "off"
+	if dot.win.LineNbs() {
+		st = "on"
+	}
+	c.printf("%s: lines %s\n--\n", dot.tag, st)
+}
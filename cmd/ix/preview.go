@@ -0,0 +1,85 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/net/ink"
+	"strings"
+)
+
+/*
+	Looking at an https URL shows it in a new panel in the page,
+	unless $ixurls is "preview", to show it in a single Preview
+	panel (replaced by later previews), or "browse", to open it
+	in a new browser tab.
+	Look rules may choose instead for some URLs, with Preview
+	or Browse as their commands, as in
+		^https://pkg\.go\.dev/.*$
+			Preview \0
+	Many sites don't let their pages be shown in panels, and
+	must be browsed.
+*/
+
+// Show url in the Preview panel, replacing the previous one.
+func (ix *IX) previewURL(url string) {
+	name := url
+	if !strings.Contains(url, "|") {
+		url += "|Preview " + url
+	}
+	ix.Lock()
+	old := ix.preview
+	ix.Unlock()
+	if old != "" {
+		ix.pg.Del(old)
+	}
+	id, err := ix.pg.Add(ink.Url(url))
+	if err != nil {
+		ix.Warn("preview %s: %s", name, err)
+		return
+	}
+	ix.Lock()
+	ix.preview = id
+	ix.Unlock()
+}
+
+// Show url as set by $ixurls.
+func (ix *IX) openURL(url string) {
+	switch cmd.GetEnv("ixurls") {
+	case "preview":
+		ix.previewURL(url)
+	case "browse":
+		ix.pg.Browse(strings.SplitN(url, "|", 2)[0])
+	default:
+		ix.lookURL(url)
+	}
+}
+
+// If the look rule command c is Preview or Browse, do it.
+func (ix *IX) urlCmd(c string) bool {
+	args := strings.Fields(c)
+	if len(args) != 2 {
+		return false
+	}
+	switch args[0] {
+	case "Preview":
+		ix.previewURL(args[1])
+	case "Browse":
+		ix.pg.Browse(args[1])
+	default:
+		return false
+	}
+	return true
+}
+
+// Preview url
+//	show url in the Preview panel.
+// Browse url
+//	open url in a new browser tab.
+func bURL(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	if len(args) != 2 {
+		c.printf("usage: %s url\n--\n", args[0])
+		return
+	}
+	ix.urlCmd(strings.Join(args, " "))
+	c.printf("--\n")
+}
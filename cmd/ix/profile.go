@@ -0,0 +1,139 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/cmd/run"
+	"clive/ns"
+	"clive/u"
+	"clive/zx"
+	"fmt"
+	fpath "path"
+	"strings"
+)
+
+/*
+	On start, before the page is served, ix runs ~/.ix/profile
+	(or $ixprofile) as a ql script. It runs in a context of its
+	own, without a window, and what it prints is shown as warnings.
+
+	The script is a separate process; to open windows, set the
+	environment, define look rules, or mount trees for ix itself,
+	it writes requests to /ix/ctl, one per line:
+		look path[:addr]	open (or show) a file
+		env name value...	set $name for ix and later commands
+		saveenv name...	save $name for later sessions (see cmd.SaveEnv)
+		mount path addr	mount addr at path in the ix name space
+		rules	parse the look rules again (eg. after env look ...)
+		win [dir]	open a commands window
+	eg.
+		echo env ixlines on >/ix/ctl
+		echo mount /zx unix!local!zx >/ix/ctl
+		echo look $home/todo:1 >/ix/ctl
+*/
+
+func profilePath() string {
+	if p := cmd.GetEnv("ixprofile"); p != "" {
+		return p
+	}
+	return fpath.Join(u.Home, ".ix", "profile")
+}
+
+// Run the profile script, if any, and wait for it.
+func (ix *IX) runProfile() {
+	path := profilePath()
+	if _, err := cmd.Stat(path); err != nil {
+		return
+	}
+	setio := func(c *cmd.Ctx) {
+		c.ForkEnv()
+		c.ForkNS()
+		c.ForkDot()
+		c.SetEnv("ixprofile", path)
+	}
+	p, err := run.CtxCmd(setio, "ql", path)
+	if err != nil {
+		ix.Warn("profile: %s", err)
+		return
+	}
+	donec := make(chan bool, 2)
+	warn := func(c <-chan face{}) {
+		for m := range c {
+			switch m := m.(type) {
+			case []byte:
+				ix.Warn("profile: %s", strings.TrimSpace(string(m)))
+			case error:
+				ix.Warn("profile: %s", m)
+			}
+		}
+		donec <- true
+	}
+	go warn(p.Out)
+	go warn(p.Err)
+	<-donec
+	<-donec
+	if err := p.Wait(); err != nil {
+		ix.Warn("profile: %s", err)
+	}
+}
+
+// Process a request written to /ix/ctl.
+func (ix *IX) ctl(ln string) error {
+	toks := strings.Fields(ln)
+	if len(toks) == 0 {
+		return nil
+	}
+	switch toks[0] {
+	case "look":
+		if len(toks) != 2 {
+			break
+		}
+		a := zx.ParseAddr(toks[1])
+		ed := ix.lookFile(a.Name, "", -1)
+		if ed == nil {
+			return fmt.Errorf("look: %s: %s", a.Name, zx.ErrNotExist)
+		}
+		if a.Ln0 != 0 || a.P0 != 0 || a.P1 != 0 {
+			ed.SetAddr(a)
+		}
+		return nil
+	case "env":
+		if len(toks) < 2 {
+			break
+		}
+		cmd.SetEnv(toks[1], strings.Join(toks[2:], " "))
+		return nil
+	case "saveenv":
+		return cmd.SaveEnv(toks[1:]...)
+	case "mount":
+		if len(toks) != 3 {
+			break
+		}
+		n, err := ns.Parse(toks[1] + " " + toks[2] + "\n")
+		if err != nil {
+			return fmt.Errorf("mount: %s", err)
+		}
+		for _, d := range n.Entries() {
+			if d["path"] != fpath.Clean(toks[1]) {
+				continue
+			}
+			if err := cmd.NS().Mount(d, ns.Repl); err != nil {
+				return fmt.Errorf("mount: %s", err)
+			}
+		}
+		return nil
+	case "rules":
+		return makeRules()
+	case "win":
+		dir := cmd.Dot()
+		if len(toks) > 1 {
+			dir = cmd.AbsPath(toks[1])
+		}
+		ed := ix.newCmds(dir, "")
+		if ed == nil {
+			return fmt.Errorf("win: can't create commands window")
+		}
+		ed.winid, _ = ix.pg.Add(ed.win)
+		return nil
+	}
+	return fmt.Errorf("%s: %s", toks[0], zx.ErrBadCtl)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	"fmt"
+	fpath "path"
+	"strings"
+)
+
+/*
+	Quitting (with Quit or the page quit button) is refused while
+	there are dirty windows. A +Quit window lists them instead,
+	and looking at Save or Discard in a line saves the file or
+	discards its edits. Quit -f quits anyway.
+	The page also asks before being closed while there
+	are dirty windows (see ink's pg.js).
+*/
+
+// Return the dirty windows, one per file.
+func (ix *IX) dirtyEds() []*Ed {
+	ix.Lock()
+	defer ix.Unlock()
+	var eds []*Ed
+	seen := map[*edbuf]bool{}
+	for _, ed := range ix.eds {
+		if ed.iscmd || seen[ed.edbuf] || !isDirty(ed) {
+			continue
+		}
+		seen[ed.edbuf] = true
+		eds = append(eds, ed)
+	}
+	return eds
+}
+
+// Return the listing for the +Quit window.
+func (ix *IX) dirtyList() string {
+	eds := ix.dirtyEds()
+	if len(eds) == 0 {
+		return "no dirty windows, use Quit to exit\n"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "dirty windows, look at Save or Discard for each:\n")
+	for _, ed := range eds {
+		fmt.Fprintf(&buf, "%s\tSave\tDiscard\n", ed.tag)
+	}
+	return buf.String()
+}
+
+// Update the listing in the +Quit window ed.
+func (ed *Ed) showDirty() {
+	ed.dot.P0, ed.dot.P1 = 0, ed.win.Len()
+	ed.replDot(ed.ix.dirtyList())
+	ed.dot.P0, ed.dot.P1 = 0, 0
+	ed.win.SetSel(0, 0)
+}
+
+// Discard the edits in ed, loading its file again.
+func (ed *Ed) discard() error {
+	if _, err := cmd.Stat(ed.tag); err != nil {
+		// a new file, not yet saved
+		ed.win.Clean()
+	} else if err := ed.load(nil); err != nil {
+		return err
+	}
+	removeBackup(ed.tag)
+	return nil
+}
+
+// Save or discard the file listed at p in the +Quit window ed,
+// as told by what.
+func (ed *Ed) reviewDirty(p int, what string) {
+	rs := []rune(string(ed.text()))
+	if p > len(rs) {
+		return
+	}
+	toks := strings.Split(lineText(rs, p), "\t")
+	if len(toks) < 2 {
+		return
+	}
+	fed := ed.ix.editFor(toks[0])
+	if fed == nil {
+		ed.showDirty()
+		return
+	}
+	var err error
+	switch strings.TrimSpace(what) {
+	case "Save":
+		if err = fed.save(); err == notDirty {
+			err = nil
+		}
+	case "Discard":
+		err = fed.discard()
+	default:
+		return
+	}
+	if err != nil {
+		ed.ix.Warn("%s: %s", fed.tag, err)
+	}
+	ed.showDirty()
+}
+
+// Quit unless there are dirty windows (or force is set),
+// in which case they are listed in a +Quit window.
+func (ix *IX) quit(force bool) {
+	if force || len(ix.dirtyEds()) == 0 {
+		cmd.Fatal("user quit")
+	}
+	ix.Lock()
+	var qed *Ed
+	for _, e := range ix.eds {
+		if e.quitrv {
+			qed = e
+			break
+		}
+	}
+	ix.Unlock()
+	if qed == nil {
+		qed = ix.newEdit(fpath.Join(cmd.Dot(), "+Quit"))
+		qed.temp = true
+		qed.quitrv = true
+		qed.win.DoesntGetDirty()
+		qed.winid, _ = ix.pg.Add(qed.win)
+	} else {
+		qed.win.Show()
+	}
+	qed.showDirty()
+}
+
+// Quit [-f]
+//	exit ix, or list the dirty windows to save or discard them
+//	if there are any and -f is not given.
+func bQuit(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	force := len(args) > 1 && args[1] == "-f"
+	if len(args) > 2 || len(args) == 2 && !force {
+		c.printf("usage: Quit [-f]\n--\n")
+		return
+	}
+	ix.quit(force)
+	c.printf("--\n")
+}
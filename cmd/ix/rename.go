@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	"clive/zx"
+	"fmt"
+	fpath "path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A word found by Rename
+struct renameHit {
+	file   string
+	ln     int
+	p0, p1 int
+}
+
+type byOffDown []renameHit
+
+func (hs byOffDown) Len() int           { return len(hs) }
+func (hs byOffDown) Less(i, j int) bool { return hs[i].p0 > hs[j].p0 }
+func (hs byOffDown) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+
+// A pending rename, kept by its review window.
+struct renaming {
+	old, new string
+	hits     []renameHit
+}
+
+func init() {
+	btab["Rename"] = brename
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Return the offsets and line numbers for old in rs, as a word.
+func findWords(rs, old []rune) ([]int, []int) {
+	var offs, lns []int
+	ln := 1
+	for i := 0; i < len(rs); i++ {
+		if rs[i] == '\n' {
+			ln++
+			continue
+		}
+		if i+len(old) > len(rs) || (i > 0 && isWordRune(rs[i-1])) {
+			continue
+		}
+		j := 0
+		for j < len(old) && rs[i+j] == old[j] {
+			j++
+		}
+		if j < len(old) || (i+j < len(rs) && isWordRune(rs[i+j])) {
+			continue
+		}
+		offs = append(offs, i)
+		lns = append(lns, ln)
+		i += len(old) - 1
+	}
+	return offs, lns
+}
+
+func lineText(rs []rune, p0 int) string {
+	s, e := p0, p0
+	for s > 0 && rs[s-1] != '\n' {
+		s--
+	}
+	for e < len(rs) && rs[e] != '\n' {
+		e++
+	}
+	return strings.TrimSpace(string(rs[s:e]))
+}
+
+// Rename old new [names...]
+//	look for old as a word in the named files (. by default)
+//	and show the hits in a review window.
+// Rename
+//	within a review window, apply the hits still starting with "+";
+//	change the "+" to "-" to exclude a hit.
+// Each edited file gets all its changes as a single edit, so
+// they can be undone at once. Files are left dirty, not saved.
+func brename(c *Cmd, args ...string) {
+	if c.ed.rn != nil && len(args) == 1 {
+		go c.applyRename()
+		return
+	}
+	defer c.ed.win.DelMark(c.mark)
+	if len(args) < 3 {
+		c.printf("usage: Rename old new [file...]\n")
+		c.printf("--\n")
+		return
+	}
+	old := args[1]
+	names := args[3:]
+	if len(names) == 0 {
+		names = []string{".,-"}
+	}
+	rn := &renaming{old: old, new: args[2]}
+	orunes := []rune(old)
+	var buf bytes.Buffer
+	var d zx.Dir
+	fc := cmd.FullFiles(cmd.Files(names...))
+	for m := range fc {
+		switch m := m.(type) {
+		case zx.Dir:
+			d = m
+		case []byte:
+			if d == nil || d["type"] != "-" || bytes.IndexByte(m, 0) >= 0 {
+				continue
+			}
+			rs := []rune(string(m))
+			offs, lns := findWords(rs, orunes)
+			for i, off := range offs {
+				h := renameHit{file: d["path"], ln: lns[i],
+					p0: off, p1: off + len(orunes)}
+				rn.hits = append(rn.hits, h)
+				fmt.Fprintf(&buf, "+ %s:%d:#%d,#%d\t%s\n", h.file, h.ln,
+					h.p0, h.p1, lineText(rs, off))
+			}
+		case error:
+			c.printf("Rename: %s\n", m)
+		}
+	}
+	if len(rn.hits) == 0 {
+		c.printf("Rename: no %s found\n", old)
+		c.printf("--\n")
+		return
+	}
+	hdr := fmt.Sprintf("# %d hits for %s -> %s; use - to exclude, then run:\nRename\n\n",
+		len(rn.hits), old, rn.new)
+	ix := c.ed.ix
+	red := ix.newEdit(fpath.Join(c.ed.dir, "+Rename"))
+	red.temp = true
+	red.rn = rn
+	red.win.DoesntGetDirty()
+	red.winid, _ = ix.pg.Add(red.win)
+	red.dot.P0 = 0
+	red.dot.P1 = red.win.Len()
+	red.replDot(hdr + buf.String())
+	red.dot.P0, red.dot.P1 = 0, 0
+	red.win.SetSel(0, 0)
+	c.printf("Rename: %d hits for %s\n", len(rn.hits), old)
+	c.printf("--\n")
+}
+
+// Return the hits excluded in the review window, as file:p0,
+// those with lines starting with "- ".
+func (ed *Ed) excludedHits() map[string]bool {
+	excl := map[string]bool{}
+	t := ed.win.GetText()
+	s := t.String()
+	ed.win.UngetText()
+	for _, ln := range strings.Split(s, "\n") {
+		if len(ln) < 2 || ln[0] != '-' || ln[1] != ' ' {
+			continue
+		}
+		toks := strings.Fields(ln[2:])
+		if len(toks) > 0 {
+			a := zx.ParseAddr(toks[0])
+			excl[fmt.Sprintf("%s:%d", a.Name, a.P0)] = true
+		}
+	}
+	return excl
+}
+
+// Return the hits selected in the review window, by file.
+func (ed *Ed) renameHits() map[string][]renameHit {
+	excl := ed.excludedHits()
+	sel := map[string][]renameHit{}
+	for _, h := range ed.rn.hits {
+		if !excl[fmt.Sprintf("%s:%d", h.file, h.p0)] {
+			sel[h.file] = append(sel[h.file], h)
+		}
+	}
+	return sel
+}
+
+func (c *Cmd) applyRename() {
+	defer c.ed.win.DelMark(c.mark)
+	rn := c.ed.rn
+	ix := c.ed.ix
+	nrs := []rune(rn.new)
+	ors := []rune(rn.old)
+	for file, hits := range c.ed.renameHits() {
+		ed := ix.lookFile(file, "", -1)
+		if ed == nil {
+			c.printf("%s: can't edit\n", file)
+			continue
+		}
+		// apply from the end, so offsets remain valid.
+		sort.Sort(byOffDown(hits))
+		n := 0
+		t := ed.win.GetText()
+		for _, h := range hits {
+			if !ed.hasText(ors, h.p0) {
+				c.printf("%s:%d: text changed, not renamed\n", file, h.ln)
+				continue
+			}
+			if n > 0 {
+				t.ContdEdit()
+			}
+			t.Del(h.p0, h.p1-h.p0)
+			t.ContdEdit()
+			t.Ins(nrs, h.p0)
+			n++
+		}
+		if n == 0 {
+			ed.win.UngetText()
+			continue
+		}
+		ed.win.PutText()
+		ed.win.Dirty()
+		ed.autosave()
+		c.printf("%s: %d renames\n", file, n)
+	}
+	c.ed.rn = nil
+	c.printf("--\n")
+}
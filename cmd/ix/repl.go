@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"clive/sre"
+	"fmt"
+	fpath "path"
+	"sort"
+	"strings"
+)
+
+// A change found by Repl
+struct replHit {
+	file     string
+	ln       int
+	p0, p1   int
+	old, new string
+}
+
+type replsDown []replHit
+
+func (hs replsDown) Len() int           { return len(hs) }
+func (hs replsDown) Less(i, j int) bool { return hs[i].p0 > hs[j].p0 }
+func (hs replsDown) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+
+// A pending replace, kept by its review window.
+struct replacing {
+	expr string
+	hits []replHit
+}
+
+// Parse /re/sub/ (any delimiter may be used instead of /).
+func parseRepl(s string) (*sre.ReProg, string, error) {
+	p := &samParser{s: []rune(s)}
+	delim := p.peek()
+	re, err := p.rexp(sre.Fwd)
+	if err != nil {
+		return nil, "", fmt.Errorf("Repl: %s", strings.TrimPrefix(err.Error(), "Edit: "))
+	}
+	sub := unescapeNl(p.delimited(delim))
+	if p.more() {
+		return nil, "", fmt.Errorf("Repl: text after %s", string(p.s[:p.i]))
+	}
+	return re, sub, nil
+}
+
+// Return the changes made by re and sub within dot in ed.
+func (ed *Ed) replHits(re *sre.ReProg, sub string, dot Dot) []replHit {
+	rs := []rune(string(ed.text()))
+	if dot.P1 > len(rs) {
+		dot.P1 = len(rs)
+	}
+	r := &samRun{rs: rs}
+	var hits []replHit
+	ln, p := 1, 0
+	for _, m := range r.matches(re, dot) {
+		for ; p < m[0].P0; p++ {
+			if rs[p] == '\n' {
+				ln++
+			}
+		}
+		h := replHit{file: ed.tag, ln: ln, p0: m[0].P0, p1: m[0].P1,
+			old: string(rs[m[0].P0:m[0].P1]), new: sre.Repl(r.substrs(m), sub)}
+		if h.old != h.new {
+			hits = append(hits, h)
+		}
+	}
+	return hits
+}
+
+// Return the file windows for Repl, one per file.
+func (ix *IX) replEds(dot *Ed, all bool) []*Ed {
+	if !all {
+		if dot == nil || dot.iscmd || dot.temp || dot.hex != nil {
+			return nil
+		}
+		return []*Ed{dot}
+	}
+	ix.Lock()
+	defer ix.Unlock()
+	var eds []*Ed
+	seen := map[*edbuf]bool{}
+	for _, ed := range ix.eds {
+		if ed.iscmd || ed.temp || ed.hex != nil || ed.ro || seen[ed.edbuf] ||
+			ed.d == nil || ed.d["type"] != "-" {
+			continue
+		}
+		seen[ed.edbuf] = true
+		eds = append(eds, ed)
+	}
+	return eds
+}
+
+// Repl [-w|-a] /re/sub/
+//	replace re with sub (as in Edit s) within dot, the window
+//	for dot (-w), or all the file windows (-a), showing the
+//	changes in a review window.
+// Repl
+//	within a review window, apply the changes still starting with "+";
+//	change the "+" to "-" to reject a change.
+// Each window gets all its changes as a single edit, so
+// they can be undone at once. Files are left dirty, not saved.
+func bRepl(c *Cmd, args ...string) {
+	if c.ed.rp != nil && len(args) == 1 {
+		go c.applyRepl()
+		return
+	}
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	expr := strings.TrimSpace(strings.TrimPrefix(c.line, args[0]))
+	scope := ""
+	if len(args) > 1 && (args[1] == "-w" || args[1] == "-a") {
+		scope = args[1]
+		expr = strings.TrimSpace(strings.TrimPrefix(expr, scope))
+	}
+	if expr == "" {
+		c.printf("usage: Repl [-w|-a] /re/sub/\n--\n")
+		return
+	}
+	re, sub, err := parseRepl(expr)
+	if err != nil {
+		c.printf("%s\n--\n", err)
+		return
+	}
+	eds := ix.replEds(dot, scope == "-a")
+	if len(eds) == 0 {
+		c.printf("Repl: no file windows\n--\n")
+		return
+	}
+	rp := &replacing{expr: expr}
+	var buf bytes.Buffer
+	for _, ed := range eds {
+		rg := Dot{0, ed.win.Len()}
+		if scope == "" {
+			ed.refreshDot()
+			rg = ed.dot
+		}
+		for _, h := range ed.replHits(re, sub, rg) {
+			rp.hits = append(rp.hits, h)
+			fmt.Fprintf(&buf, "+ %s:%d:#%d,#%d\t%q -> %q\n", h.file, h.ln,
+				h.p0, h.p1, h.old, h.new)
+		}
+	}
+	if len(rp.hits) == 0 {
+		c.printf("Repl: no changes for %s\n--\n", expr)
+		return
+	}
+	hdr := fmt.Sprintf("# %d changes for %s; use - to reject, then run:\nRepl\n\n",
+		len(rp.hits), expr)
+	red := ix.newEdit(fpath.Join(c.ed.dir, "+Repl"))
+	red.temp = true
+	red.rp = rp
+	red.win.DoesntGetDirty()
+	red.winid, _ = ix.pg.Add(red.win)
+	red.dot.P0, red.dot.P1 = 0, red.win.Len()
+	red.replDot(hdr + buf.String())
+	red.dot.P0, red.dot.P1 = 0, 0
+	red.win.SetSel(0, 0)
+	c.printf("Repl: %d changes for %s\n--\n", len(rp.hits), expr)
+}
+
+func (c *Cmd) applyRepl() {
+	defer c.ed.win.DelMark(c.mark)
+	rp := c.ed.rp
+	excl := c.ed.excludedHits()
+	sel := map[string][]replHit{}
+	for _, h := range rp.hits {
+		if !excl[fmt.Sprintf("%s:%d", h.file, h.p0)] {
+			sel[h.file] = append(sel[h.file], h)
+		}
+	}
+	for file, hits := range sel {
+		ed := ix.editFor(file)
+		if ed == nil {
+			c.printf("%s: not being edited\n", file)
+			continue
+		}
+		// apply from the end, so offsets remain valid.
+		sort.Sort(replsDown(hits))
+		n, nops := 0, 0
+		t := ed.win.GetText()
+		for _, h := range hits {
+			if !ed.hasText([]rune(h.old), h.p0) {
+				c.printf("%s:%d: text changed, not replaced\n", file, h.ln)
+				continue
+			}
+			if h.p1 > h.p0 {
+				if nops > 0 {
+					t.ContdEdit()
+				}
+				t.Del(h.p0, h.p1-h.p0)
+				nops++
+			}
+			if h.new != "" {
+				if nops > 0 {
+					t.ContdEdit()
+				}
+				t.Ins([]rune(h.new), h.p0)
+				nops++
+			}
+			n++
+		}
+		if nops == 0 {
+			ed.win.UngetText()
+			continue
+		}
+		ed.win.PutText()
+		ed.win.Dirty()
+		ed.autosave()
+		c.printf("%s: %d changes\n", file, n)
+	}
+	c.ed.rp = nil
+	c.printf("--\n")
+}
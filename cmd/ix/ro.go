@@ -0,0 +1,55 @@
+package main
+
+/*
+	Read-only windows reject edits made by the user, are never
+	flagged as dirty, and are not saved. Windows for files we can't
+	write are made read-only when loaded, and Ro can be
+	used to change it.
+*/
+
+// Make ed read-only, or not.
+func (ed *Ed) setRO(ro bool) {
+	if ed.ro == ro {
+		return
+	}
+	ed.ro = ro
+	if ro {
+		ed.win.NoEdits()
+		ed.win.Clean()
+		removeBackup(ed.tag)
+	} else {
+		ed.win.Edits()
+	}
+	ed.showTag()
+}
+
+// Ro [on|off]
+//	print or set the read-only flag for dot.
+func bRo(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.temp || dot.iscmd {
+		c.printf("Ro: no file at dot\n--\n")
+		return
+	}
+	if len(args) == 1 {
+		st := "off"
+		if dot.ro {
+			st = "on"
+		}
+		c.printf("%s: ro %s\n--\n", dot.tag, st)
+		return
+	}
+	switch args[1] {
+	case "on":
+		dot.setRO(true)
+	case "off":
+		dot.setRO(false)
+	default:
+		c.printf("usage: Ro [on|off]\n--\n")
+		return
+	}
+	c.printf("--\n")
+}
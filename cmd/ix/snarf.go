@@ -0,0 +1,67 @@
+package main
+
+import (
+	"clive/snarf"
+)
+
+/*
+	The snarf buffer is shared with the system clipboard (when
+	there is one) and that of the browser showing the page.
+	Snarf, Cut, and Paste work on dot, like the keyboard and
+	mouse chords do on the selection of a window.
+*/
+
+// Return the text in dot.
+func (ed *Ed) dotText() string {
+	ed.refreshDot()
+	if ed.dot.P1 <= ed.dot.P0 {
+		return ""
+	}
+	var rs []rune
+	for r := range ed.win.Get(ed.dot.P0, ed.dot.P1-ed.dot.P0) {
+		rs = append(rs, r...)
+	}
+	return string(rs)
+}
+
+// Snarf
+//	copy dot to the snarf buffer.
+// Cut
+//	move dot to the snarf buffer.
+// Paste
+//	replace dot with the snarf buffer.
+func bSnarf(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil {
+		c.printf("%s: no dot\n--\n", args[0])
+		return
+	}
+	if args[0] != "Snarf" && (dot.ro || dot.hex != nil) {
+		c.printf("%s: %s: read only\n--\n", args[0], dot.tag)
+		return
+	}
+	switch args[0] {
+	case "Snarf", "Cut":
+		dot.win.Snarf(dot.dotText())
+		if args[0] == "Snarf" {
+			break
+		}
+		dot.replDot("")
+		dot.win.Dirty()
+		dot.autosave()
+	case "Paste":
+		s, err := snarf.Get()
+		if err != nil {
+			c.printf("Paste: %s\n--\n", err)
+			return
+		}
+		dot.refreshDot()
+		dot.replDot(s)
+		dot.win.Dirty()
+		dot.autosave()
+	}
+	c.printf("--\n")
+}
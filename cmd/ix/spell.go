@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"clive/cmd"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+/*
+	Spell checks the words in a window using the dictionary
+	at $dict (or /usr/share/dict/words) and underlines those
+	not found. The window is checked again when saved, until
+	Spell -x is used.
+	Looking at an underlined word prints corrections as commands
+	that can be executed to replace the word.
+*/
+
+var (
+	dictlk sync.Mutex
+	dict   map[string]bool
+)
+
+// Load the dictionary, if not loaded.
+func loadDict() (map[string]bool, error) {
+	dictlk.Lock()
+	defer dictlk.Unlock()
+	if dict != nil {
+		return dict, nil
+	}
+	fn := cmd.GetEnv("dict")
+	if fn == "" {
+		fn = "/usr/share/dict/words"
+	}
+	dat, err := cmd.GetAll(fn)
+	if err != nil {
+		return nil, err
+	}
+	d := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(dat))
+	for sc.Scan() {
+		if w := strings.TrimSpace(sc.Text()); w != "" {
+			d[strings.ToLower(w)] = true
+		}
+	}
+	dict = d
+	return dict, nil
+}
+
+func isSpellRune(r rune) bool {
+	return unicode.IsLetter(r) || r == '\''
+}
+
+// Is w (or its lower case version) in d?
+func spellOk(d map[string]bool, w string) bool {
+	w = strings.Trim(w, "'")
+	if len([]rune(w)) < 2 {
+		return true
+	}
+	lw := strings.ToLower(w)
+	return d[lw] || d[strings.TrimSuffix(lw, "'s")]
+}
+
+// Check the words in ed, underlining the misspelled ones.
+// Return the number of misspelled words.
+func (ed *Ed) spellCheck() (int, error) {
+	d, err := loadDict()
+	if err != nil {
+		return 0, err
+	}
+	ed.win.DelSpans("spell")
+	rs := []rune(string(ed.text()))
+	n := 0
+	for i := 0; i < len(rs); {
+		if !unicode.IsLetter(rs[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(rs) && isSpellRune(rs[j]) {
+			j++
+		}
+		if !spellOk(d, string(rs[i:j])) {
+			ed.win.SetSpan(fmt.Sprintf("spell%d", n), i, j)
+			n++
+		}
+		i = j
+	}
+	return n, nil
+}
+
+// Return the words in d at edit distance 1 from w, sorted.
+func spellFixes(d map[string]bool, w string) []string {
+	lw := []rune(strings.ToLower(w))
+	set := map[string]bool{}
+	try := func(rs []rune) {
+		if s := string(rs); d[s] {
+			set[s] = true
+		}
+	}
+	for i := 0; i <= len(lw); i++ {
+		if i < len(lw) {
+			try(append(append([]rune{}, lw[:i]...), lw[i+1:]...))
+		}
+		if i+1 < len(lw) {
+			t := append([]rune{}, lw...)
+			t[i], t[i+1] = t[i+1], t[i]
+			try(t)
+		}
+		for c := 'a'; c <= 'z'; c++ {
+			if i < len(lw) {
+				t := append([]rune{}, lw...)
+				t[i] = c
+				try(t)
+			}
+			try(append(append(append([]rune{}, lw[:i]...), c), lw[i:]...))
+		}
+	}
+	var fixes []string
+	for s := range set {
+		fixes = append(fixes, s)
+	}
+	sort.Strings(fixes)
+	return fixes
+}
+
+// If p is within a misspelled word in ed, print the corrections
+// in the commands window and return true.
+func (ed *Ed) spellLook(p int) bool {
+	if !ed.spell {
+		return false
+	}
+	_, p0, p1, ok := ed.win.SpanAt("spell", p)
+	if !ok {
+		return false
+	}
+	d, err := loadDict()
+	if err != nil {
+		return false
+	}
+	rs := []rune(string(ed.text()))
+	if p1 > len(rs) || p0 >= p1 {
+		return false
+	}
+	w := string(rs[p0:p1])
+	fixes := spellFixes(d, w)
+	if len(fixes) == 0 {
+		ed.ix.Warn("%s: no corrections", w)
+		return true
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:", w)
+	for _, f := range fixes {
+		fmt.Fprintf(&buf, "\n\tSpell -r %s:#%d,#%d %s", ed.tag, p0, p1, f)
+	}
+	ed.ix.Warn("%s", buf.String())
+	return true
+}
+
+// Replace the text at addr (a file and #p0,#p1) with w.
+func spellFix(addr, w string) error {
+	toks := strings.SplitN(addr, ":", 2)
+	if len(toks) != 2 {
+		return fmt.Errorf("bad address %s", addr)
+	}
+	ed := ix.editFor(toks[0])
+	if ed == nil {
+		return fmt.Errorf("%s: not being edited", toks[0])
+	}
+	if ed.ro {
+		return fmt.Errorf("%s: read-only", toks[0])
+	}
+	a := ed.parseAddr(toks[1])
+	if a.P1 <= a.P0 || a.P1 > ed.win.Len() {
+		return fmt.Errorf("bad address %s", addr)
+	}
+	ed.dot.P0, ed.dot.P1 = a.P0, a.P1
+	ed.replDot(w)
+	ed.win.Dirty()
+	ed.autosave()
+	if ed.spell {
+		ed.spellCheck()
+	}
+	return nil
+}
+
+// Spell
+//	check the words in dot's window and underline the misspelled ones.
+//	The window is checked again when saved, and looking at
+//	a misspelled word prints corrections.
+// Spell -x
+//	stop spell checking dot's window.
+// Spell -r addr word
+//	replace the text at addr with word.
+func bSpell(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	if len(args) == 4 && args[1] == "-r" {
+		if err := spellFix(args[2], args[3]); err != nil {
+			c.printf("Spell: %s\n", err)
+		}
+		c.printf("--\n")
+		return
+	}
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	if dot == nil || dot.iscmd || dot.temp {
+		c.printf("Spell: no file at dot\n--\n")
+		return
+	}
+	switch {
+	case len(args) == 2 && args[1] == "-x":
+		dot.spell = false
+		dot.win.DelSpans("spell")
+	case len(args) == 1:
+		n, err := dot.spellCheck()
+		if err != nil {
+			c.printf("Spell: %s\n--\n", err)
+			return
+		}
+		dot.spell = true
+		c.printf("%s: %d misspelled words\n", dot.tag, n)
+	default:
+		c.printf("usage: Spell [-x | -r addr word]\n")
+	}
+	c.printf("--\n")
+}
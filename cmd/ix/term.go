@@ -0,0 +1,203 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/cmd/run"
+	"unicode/utf8"
+)
+
+/*
+	Term runs a command on a tty in a commands window, for programs
+	that need one (ssh, python, ...).
+	The output goes to the window as for other commands, and
+	the text typed after it is sent to the tty when a newline is
+	typed (the tty echoes it back). Esc sends an interrupt (^C).
+	Only basic control sequences are handled: \r, \b, and the bell;
+	escape sequences are removed.
+	There can be a single Term running in each commands window.
+*/
+
+// Filters the output from a tty.
+struct termFilter {
+	st   int    // 0: text, 1: after esc, 2: in csi, 3: in osc
+	rest []byte // incomplete utf8 from the last write
+}
+
+// Return the text in dat and the number of runes to be deleted
+// before it, to handle \b.
+func (f *termFilter) filter(dat []byte) (string, int) {
+	dat = append(f.rest, dat...)
+	f.rest = nil
+	var out []rune
+	nb := 0
+	for i := 0; i < len(dat); {
+		b := dat[i]
+		switch f.st {
+		case 1:
+			switch b {
+			case '[':
+				f.st = 2
+			case ']':
+				f.st = 3
+			default:
+				f.st = 0
+			}
+			i++
+			continue
+		case 2:
+			if b >= 0x40 && b <= 0x7e {
+				f.st = 0
+			}
+			i++
+			continue
+		case 3:
+			if b == '\a' || b == '\x1b' {
+				f.st = 0
+			}
+			i++
+			continue
+		}
+		switch b {
+		case '\x1b':
+			f.st = 1
+		case '\r', '\a', 0:
+		case '\b':
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			} else {
+				nb++
+			}
+		default:
+			if !utf8.FullRune(dat[i:]) {
+				f.rest = append(f.rest, dat[i:]...)
+				return string(out), nb
+			}
+			r, n := utf8.DecodeRune(dat[i:])
+			out = append(out, r)
+			i += n
+			continue
+		}
+		i++
+	}
+	return string(out), nb
+}
+
+// Copy the tty output to the window until the command exits.
+func (c *Cmd) termio() {
+	cmd.Dprintf("term io started\n")
+	defer cmd.Dprintf("term io terminated\n")
+	p := c.p
+	ed := c.ed
+	var f termFilter
+	c.printf("\n")
+	for m := range p.Out {
+		dat, ok := m.([]byte)
+		if !ok {
+			continue
+		}
+		s, nb := f.filter(dat)
+		if nb > 0 {
+			if m := ed.win.Mark(c.mark); m != nil && m.Off >= nb {
+				ed.win.Del(m.Off-nb, nb)
+			}
+		}
+		if s != "" {
+			c.printf("%s", s)
+		}
+	}
+	if err := p.Wait(); err != nil {
+		c.printf("cmd error: %s\n", err)
+	}
+	c.printf("--\n")
+	ix.Lock()
+	if ed.term == c {
+		ed.term = nil
+	}
+	ix.Unlock()
+	ed.win.DelMark(c.mark)
+	if n := ed.ix.delCmd(c); n == 0 && ed.gone {
+		close(ed.waitc)
+	}
+}
+
+func (ed *Ed) termCmd() *Cmd {
+	ix.Lock()
+	defer ix.Unlock()
+	return ed.term
+}
+
+// Called after a newline is typed at p in a commands window to send the
+// lines typed after the output of its Term (if any) to the tty.
+func (ed *Ed) termInput(p int) {
+	c := ed.termCmd()
+	if c == nil {
+		return
+	}
+	m := ed.win.Mark(c.mark)
+	if m == nil || p < m.Off {
+		return
+	}
+	rs := []rune(string(ed.text()))
+	p0, p1 := m.Off, -1
+	for i := len(rs) - 1; i >= p0 && i >= 0; i-- {
+		if rs[i] == '\n' {
+			p1 = i + 1
+			break
+		}
+	}
+	if p0 > len(rs) || p1 < 0 {
+		return
+	}
+	in := string(rs[p0:p1])
+	ed.win.Del(p0, p1-p0)
+	c.p.In <- []byte(in)
+}
+
+// Send an interrupt to the Term running in the window, if any.
+func (ed *Ed) termIntr() {
+	if c := ed.termCmd(); c != nil {
+		c.p.In <- []byte("\x03")
+	}
+}
+
+// Close the tty for the Term running in the window, if any.
+func (ed *Ed) termHup() {
+	if c := ed.termCmd(); c != nil {
+		close(c.p.In)
+	}
+}
+
+// Term cmd [arg...]
+//	run cmd on a tty in this commands window.
+func bTerm(c *Cmd, args ...string) {
+	ed := c.ed
+	if len(args) < 2 || !ed.iscmd {
+		c.printf("usage: Term cmd [arg...], in a commands window\n--\n")
+		ed.win.DelMark(c.mark)
+		return
+	}
+	if ed.termCmd() != nil {
+		c.printf("Term: already running here\n--\n")
+		ed.win.DelMark(c.mark)
+		return
+	}
+	setio := func(c *cmd.Ctx) {
+		c.ForkEnv()
+		c.ForkNS()
+		c.ForkDot()
+		setDotEnv(c, nil)
+	}
+	p, err := run.PtyCmd(setio, args[1:]...)
+	if err != nil {
+		c.printf("Term: %s\n--\n", err)
+		ed.win.DelMark(c.mark)
+		return
+	}
+	c.p = p
+	ix.Lock()
+	ed.term = c
+	ix.Unlock()
+	ed.ix.addCmd(c)
+	// c.termio dels the cmd mark
+	go c.termio()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/txt"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path"
+)
+
+/*
+	The undo history of a file is saved when the file is saved,
+	in the undo dir next to the backups (see backup.go), and
+	restored when the file is loaded with the same text, so that
+	undo works across reloads and ix sessions.
+	Only the last UndoLen edits are kept.
+*/
+var UndoLen = 1000
+
+// Undo history for a file.
+struct undoHist {
+	Path  string     // the file
+	Sum   string     // of the text for the history
+	N     int        // number of edits applied
+	Edits []undoEdit // edits for undo/redo
+}
+
+struct undoEdit {
+	Op    int
+	Off   int
+	Data  string
+	Contd bool
+}
+
+func undoPath(path string) string {
+	return fpath.Join(backupDir(), "undo", fmt.Sprintf("%x", sha1.Sum([]byte(path))))
+}
+
+func textSum(dat []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(dat))
+}
+
+// Save the undo history for ed, which has just been saved.
+func (ed *Ed) saveUndo() {
+	es, n := ed.win.GetText().Edits()
+	ed.win.UngetText()
+	if len(es) > UndoLen {
+		skip := len(es) - UndoLen
+		es = es[skip:]
+		n -= skip
+		if n < 0 {
+			// can't undo past the edits dropped
+			return
+		}
+	}
+	h := &undoHist{Path: ed.tag, Sum: textSum(ed.text()), N: n}
+	for _, e := range es {
+		h.Edits = append(h.Edits, undoEdit{int(e.Op), e.Off, string(e.Data), e.Contd})
+	}
+	dat, err := json.Marshal(h)
+	if err != nil {
+		cmd.Dprintf("%s: undo: %s\n", ed.tag, err)
+		return
+	}
+	upath := undoPath(ed.tag)
+	if err := os.MkdirAll(fpath.Dir(upath), 0700); err != nil {
+		cmd.Dprintf("%s: undo: %s\n", ed.tag, err)
+		return
+	}
+	if err := ioutil.WriteFile(upath, dat, 0600); err != nil {
+		cmd.Dprintf("%s: undo: %s\n", ed.tag, err)
+	}
+}
+
+// Restore the undo history for ed, which has just been loaded,
+// if there's one for its text.
+func (ed *Ed) loadUndo() {
+	dat, err := ioutil.ReadFile(undoPath(ed.tag))
+	if err != nil {
+		return
+	}
+	var h undoHist
+	if err := json.Unmarshal(dat, &h); err != nil || h.Path != ed.tag {
+		return
+	}
+	if h.Sum != textSum(ed.text()) {
+		cmd.Dprintf("%s: undo history is stale\n", ed.tag)
+		return
+	}
+	es := make([]txt.Edit, len(h.Edits))
+	for i, e := range h.Edits {
+		es[i] = txt.Edit{Op: txt.Tedit(e.Op), Off: e.Off, Data: []rune(e.Data), Contd: e.Contd}
+	}
+	ed.win.GetText().SetEdits(es, h.N)
+	ed.win.UngetText()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"clive/cmd"
+	"fmt"
+	fpath "path"
+	"strings"
+	"sync"
+)
+
+/*
+	Tag verbs are commands added to the tag of file windows, for
+	files matching a pattern: *.go (a pattern for the file name)
+	or /a/dir/ (files under the dir).
+	They are read from ~/lib/ixverbs or ~/.ixverbs (or taken from
+	$ixverbs), with a line per verb:
+		*.go	Vet	go vet $%
+		/zx/sys/src/	Mk	cd /zx/sys/src ; mk
+	and can be added with Addtag.
+	Executing the name of a verb in the window, or in a commands
+	window while the window is dot, runs its command as ql does,
+	with $% set to the file and $ixdot to the address of dot.
+*/
+
+// A verb for the tag of some file windows.
+struct tagVerb {
+	pat  string // *.ext for file names, or /dir/ for files under dir
+	name string
+	cmd  string
+}
+
+var (
+	verbs   []tagVerb
+	verbsLk sync.Mutex
+)
+
+func (v tagVerb) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", v.pat, v.name, v.cmd)
+}
+
+// Does the verb apply to the file at path?
+func (v tagVerb) matches(path string) bool {
+	if strings.HasSuffix(v.pat, "/") {
+		return strings.HasPrefix(path, v.pat)
+	}
+	ok, _ := fpath.Match(v.pat, fpath.Base(path))
+	return ok
+}
+
+func parseVerb(ln string) (tagVerb, error) {
+	ln = strings.TrimSpace(ln)
+	toks := strings.Fields(ln)
+	if len(toks) < 3 {
+		return tagVerb{}, fmt.Errorf("bad verb '%s'", ln)
+	}
+	v := tagVerb{pat: toks[0], name: toks[1]}
+	rest := strings.TrimSpace(ln[len(toks[0]):])
+	v.cmd = strings.TrimSpace(rest[len(toks[1]):])
+	if _, err := fpath.Match(v.pat, ""); err != nil {
+		return tagVerb{}, fmt.Errorf("bad pattern '%s'", v.pat)
+	}
+	if builtin(v.name) != nil {
+		return tagVerb{}, fmt.Errorf("%s: is a builtin", v.name)
+	}
+	return v, nil
+}
+
+// (Re)load the tag verbs from the ixverbs file.
+func loadVerbs() error {
+	var vs []tagVerb
+	var err error
+	for _, ln := range strings.Split(cmd.DotFile("ixverbs"), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || ln[0] == '#' {
+			continue
+		}
+		v, verr := parseVerb(ln)
+		if verr != nil {
+			if err == nil {
+				err = verr
+			}
+			continue
+		}
+		vs = append(vs, v)
+	}
+	verbsLk.Lock()
+	verbs = vs
+	verbsLk.Unlock()
+	return err
+}
+
+// Add v to the tag verbs, replacing a previous one
+// with the same name and pattern.
+func addVerb(v tagVerb) {
+	verbsLk.Lock()
+	defer verbsLk.Unlock()
+	for i := range verbs {
+		if verbs[i].pat == v.pat && verbs[i].name == v.name {
+			verbs[i] = v
+			return
+		}
+	}
+	verbs = append(verbs, v)
+}
+
+// Return the verbs for the tag of ed. Later verbs replace
+// previous ones with the same name.
+func (ed *Ed) tagVerbs() []tagVerb {
+	if ed == nil || ed.iscmd || ed.temp || ed.hex != nil ||
+		ed.d == nil || ed.d["type"] != "-" {
+		return nil
+	}
+	verbsLk.Lock()
+	defer verbsLk.Unlock()
+	var vs []tagVerb
+	seen := map[string]bool{}
+	for i := len(verbs) - 1; i >= 0; i-- {
+		v := verbs[i]
+		if !seen[v.name] && v.matches(ed.tag) {
+			seen[v.name] = true
+			vs = append([]tagVerb{v}, vs...)
+		}
+	}
+	return vs
+}
+
+// Return the text for the verbs in the tag of ed.
+func (ed *Ed) verbsTag() string {
+	vs := ed.tagVerbs()
+	if len(vs) == 0 {
+		return ""
+	}
+	s := " |"
+	for _, v := range vs {
+		s += " " + v.name
+	}
+	return s
+}
+
+// If name is a verb for ed (or for dot, if ed is a commands window),
+// return its command.
+func (ed *Ed) verbCmd(name string) (string, bool) {
+	if ed.iscmd || ed.temp {
+		ix.Lock()
+		ed = ix.dot
+		ix.Unlock()
+	}
+	for _, v := range ed.tagVerbs() {
+		if v.name == name {
+			return v.cmd, true
+		}
+	}
+	return "", false
+}
+
+// Update the tags of all windows after changing the verbs.
+func (ix *IX) showVerbs() {
+	ix.Lock()
+	eds := append([]*Ed{}, ix.eds...)
+	ix.Unlock()
+	for _, ed := range eds {
+		if !ed.iscmd && !ed.temp && ed.hex == nil {
+			ed.showTag()
+		}
+	}
+}
+
+// Addtag
+//	list the verbs for dot.
+// Addtag [-d] verb cmd...
+//	add a verb for files named like dot (*.ext), or for
+//	files in the dir of dot if -d is given.
+// Addtag -r
+//	load the verbs again from the ixverbs file.
+func bAddtag(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ix.Lock()
+	dot := ix.dot
+	ix.Unlock()
+	switch {
+	case len(args) == 2 && args[1] == "-r":
+		if err := loadVerbs(); err != nil {
+			c.printf("Addtag: %s\n", err)
+		}
+		ix.showVerbs()
+	case len(args) == 1:
+		var buf bytes.Buffer
+		for _, v := range dot.tagVerbs() {
+			fmt.Fprintf(&buf, "%s\n", v)
+		}
+		if buf.Len() == 0 {
+			buf.WriteString("no verbs\n")
+		}
+		c.printf("%s", buf.String())
+	default:
+		if dot == nil || dot.iscmd || dot.temp || dot.hex != nil {
+			c.printf("Addtag: no file at dot\n--\n")
+			return
+		}
+		dir := len(args) > 1 && args[1] == "-d"
+		if dir {
+			args = args[1:]
+		}
+		if len(args) < 3 {
+			c.printf("usage: Addtag [-d] [verb cmd...] | Addtag -r\n--\n")
+			return
+		}
+		pat := "*" + fpath.Ext(dot.tag)
+		if dir || pat == "*" {
+			pat = fpath.Dir(dot.tag)
+			if pat != "/" {
+				pat += "/"
+			}
+		}
+		v, err := parseVerb(pat + " " + strings.Join(args[1:], " "))
+		if err != nil {
+			c.printf("Addtag: %s\n--\n", err)
+			return
+		}
+		addVerb(v)
+		ix.showVerbs()
+	}
+	c.printf("--\n")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"clive/cmd"
+	"clive/zx"
+	"time"
+)
+
+/*
+	Files edited are polled every WatchIval to see if they changed
+	on disk. Clean windows are reloaded (keeping dot), and dirty ones
+	are flagged as in conflict in their tag, until they are saved
+	or reloaded. Saving a window in conflict fails once, as before.
+*/
+var WatchIval = 3 * time.Second
+
+const conflictTag = " [changed on disk]"
+
+// Return one window for each file being edited.
+func (ix *IX) files() []*Ed {
+	ix.Lock()
+	defer ix.Unlock()
+	var eds []*Ed
+	seen := map[*edbuf]bool{}
+	for _, ed := range ix.eds {
+		if seen[ed.edbuf] || ed.temp || ed.iscmd || ed.d == nil || ed.d["type"] != "-" {
+			continue
+		}
+		seen[ed.edbuf] = true
+		eds = append(eds, ed)
+	}
+	return eds
+}
+
+func (ix *IX) watch() {
+	for {
+		time.Sleep(WatchIval)
+		// a single request for all the files in the same tree
+		eds := ix.files()
+		paths := make([]string, len(eds))
+		for i, ed := range eds {
+			paths[i] = ed.path()
+		}
+		ds, errs := cmd.Stats(paths...)
+		for i, ed := range eds {
+			ed.checkDisk(ds[i], errs[i])
+			ed.updVCS(false)
+		}
+	}
+}
+
+// Reload ed if its file, now at nd, changed and it's clean,
+// or flag the conflict.
+func (ed *Ed) checkDisk(nd zx.Dir, err error) {
+	ed.disklk.Lock()
+	defer ed.disklk.Unlock()
+	if ed.conflict || ed.ix.goneEd(ed) {
+		return
+	}
+	if err != nil || nd["type"] != "-" || nd["path"] != ed.path() {
+		return
+	}
+	nt := nd.Time("mtime").Truncate(time.Second)
+	ot := ed.d.Time("mtime").Truncate(time.Second)
+	if nt.Equal(ot) {
+		return
+	}
+	if ed.win.IsDirty() {
+		cmd.Dprintf("%s: changed on disk, dirty\n", ed.tag)
+		ed.setConflict(true)
+		ed.ix.Warn("%s: changed on disk while being edited", ed.tag)
+		return
+	}
+	cmd.Dprintf("%s: changed on disk, reloading\n", ed.tag)
+	ed.refreshDot()
+	dot := ed.dot
+	if err := ed.load(nd); err != nil {
+		return
+	}
+	n := ed.win.Len()
+	if dot.P1 > n {
+		dot.P1 = n
+	}
+	if dot.P0 > dot.P1 {
+		dot.P0 = dot.P1
+	}
+	ed.dot = dot
+	ed.setSel(dot.P0, dot.P1)
+}
+
+// Flag ed as in conflict with its file, or not.
+func (ed *Ed) setConflict(c bool) {
+	if ed.conflict == c {
+		return
+	}
+	ed.conflict = c
+	ed.showTag()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"clive/sre"
+	"fmt"
+	fpath "path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Wins windows are refreshed every WinsIval.
+var WinsIval = time.Second
+
+// Options for a Wins window.
+struct winsel {
+	by string      // "" (layout), "d" (dirty first), "p" (by dir), "r" (recent first)
+	re *sre.ReProg // list just the windows matching, if not nil
+}
+
+struct winsBy {
+	eds  []*Ed
+	less func(e1, e2 *Ed) bool
+}
+
+func (ws winsBy) Len() int           { return len(ws.eds) }
+func (ws winsBy) Less(i, j int) bool { return ws.less(ws.eds[i], ws.eds[j]) }
+func (ws winsBy) Swap(i, j int)      { ws.eds[i], ws.eds[j] = ws.eds[j], ws.eds[i] }
+
+func isDirty(ed *Ed) bool {
+	return !ed.temp && ed.win.IsDirty()
+}
+
+// Return the listing for the Wins window ed.
+func (ws *winsel) list(ed *Ed) string {
+	var eds []*Ed
+	for _, col := range ed.ix.layout() {
+		for _, e := range col {
+			if e.edbuf == ed.edbuf {
+				continue
+			}
+			if ws.re != nil {
+				s := e.menuLine()
+				if len(ws.re.ExecStr(s, 0, len(s))) == 0 {
+					continue
+				}
+			}
+			eds = append(eds, e)
+		}
+	}
+	var less func(e1, e2 *Ed) bool
+	switch ws.by {
+	case "d":
+		less = func(e1, e2 *Ed) bool { return isDirty(e1) && !isDirty(e2) }
+	case "p":
+		less = func(e1, e2 *Ed) bool {
+			if e1.dir != e2.dir {
+				return e1.dir < e2.dir
+			}
+			return e1.tag < e2.tag
+		}
+	case "r":
+		less = func(e1, e2 *Ed) bool { return e1.used.After(e2.used) }
+	}
+	if less != nil {
+		sort.Stable(winsBy{eds, less})
+	}
+	var buf bytes.Buffer
+	for _, e := range eds {
+		fmt.Fprintf(&buf, "%s\t%s\n", e.menuLine(), e.winid)
+	}
+	if buf.Len() == 0 {
+		buf.WriteString("none\n")
+	}
+	return buf.String()
+}
+
+// Keep the listing of the Wins window ed up to date.
+func (ed *Ed) winsLoop() {
+	last := ""
+	for !ed.ix.goneEd(ed) {
+		if s := ed.wins.list(ed); s != last {
+			ed.dot.P0, ed.dot.P1 = 0, ed.win.Len()
+			ed.replDot(s)
+			ed.dot.P0, ed.dot.P1 = 0, 0
+			ed.win.SetSel(0, 0)
+			last = s
+		}
+		time.Sleep(WinsIval)
+	}
+}
+
+// Raise the window listed at p in the Wins window ed.
+func (ed *Ed) raiseWin(p int) {
+	rs := []rune(string(ed.text()))
+	if p > len(rs) {
+		return
+	}
+	toks := strings.Fields(lineText(rs, p))
+	if len(toks) == 0 {
+		return
+	}
+	if e := ed.ix.winEd(toks[len(toks)-1]); e != nil {
+		e.win.Show()
+	}
+}
+
+// Wins [-d|-p|-r] [expr]
+//	open a window listing the windows, or those with
+//	kind and tag matching expr, with dirty ones first (-d),
+//	sorted by dir (-p), or recently used first (-r).
+//	The list is kept up to date, and looking at a line
+//	shows its window.
+func bWins(c *Cmd, args ...string) {
+	defer c.ed.win.DelMark(c.mark)
+	ws := &winsel{}
+	args = args[1:]
+	for len(args) > 0 && len(args[0]) == 2 && args[0][0] == '-' {
+		switch args[0] {
+		case "-d", "-p", "-r":
+			ws.by = args[0][1:]
+		default:
+			c.printf("usage: Wins [-d|-p|-r] [expr]\n--\n")
+			return
+		}
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		re, err := sre.CompileStr(strings.Join(args, " "), sre.Fwd)
+		if err != nil {
+			c.printf("Wins: %s\n--\n", err)
+			return
+		}
+		ws.re = re
+	}
+	ix := c.ed.ix
+	wed := ix.newEdit(fpath.Join(c.ed.dir, "+Wins"))
+	wed.temp = true
+	wed.wins = ws
+	wed.win.DoesntGetDirty()
+	wed.winid, _ = ix.pg.Add(wed.win)
+	go wed.winsLoop()
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Each context created by New is a job, numbered in the order
+	they are created, and known until it exits.
+	Jobs may be listed, signaled, and waited for, eg. to implement
+	jobs and kill %n in shells, or to clean up on exit.
+
+	Contexts running an OS process (eg., those made by the run
+	package) record it with SetProc, and signals for the job are
+	sent to the process. Otherwise, they are sent to the
+	Sigs chan for the job, and it's up to the command to check it.
+
+	Jobs form a tree: each is a child of the context calling New.
+	When a context exits, its descendants are sent os.Kill, and Waitc(true)
+	waits for the whole tree. Those running functions exit only if they
+	check their Sigs chan. Contexts that must outlive their parents
+	(eg., background jobs, or windows made from others) are made with
+	NewDetached, or call Detach.
+*/
+
+// A job, as reported by Jobs.
+struct Job {
+	Id    int       // job number, as in %n
+	Pid   int       // OS process, or 0
+	Args  []string  // command line arguments
+	Start time.Time // when it was created
+}
+
+struct job {
+	id    int
+	start time.Time
+	proc  *os.Process
+	sigc  chan os.Signal
+}
+
+type byJobId []Job
+
+func (b byJobId) Len() int           { return len(b) }
+func (b byJobId) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byJobId) Less(i, j int) bool { return b[i].Id < b[j].Id }
+
+var (
+	jobgen int // under ctxlk
+
+	ErrNoJob = errors.New("no such job")
+)
+
+// called with ctxlk locked
+func newJob() *job {
+	jobgen++
+	return &job{
+		id:    jobgen,
+		start: time.Now(),
+		sigc:  make(chan os.Signal, 16),
+	}
+}
+
+func (j Job) String() string {
+	s := fmt.Sprintf("%%%d", j.Id)
+	if j.Pid != 0 {
+		s += fmt.Sprintf(" pid %d", j.Pid)
+	}
+	return s + " " + strings.Join(j.Args, " ")
+}
+
+// Return the job number for c, or 0 if it's not a job.
+func (c *Ctx) JobId() int {
+	if c.job == nil {
+		return 0
+	}
+	return c.job.id
+}
+
+// Record the OS process run by c, to send it the signals for the job.
+func (c *Ctx) SetProc(p *os.Process) {
+	if c.job == nil {
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.job.proc = p
+}
+
+// Return the chan where signals for the job of c are sent, when
+// c does not run an OS process.
+func (c *Ctx) Sigs() <-chan os.Signal {
+	if c.job == nil {
+		return nil
+	}
+	return c.job.sigc
+}
+
+// Return the chan where signals for the current job are sent.
+func Sigs() <-chan os.Signal {
+	return ctx().Sigs()
+}
+
+// Send a signal to the job of c.
+func (c *Ctx) Signal(sig os.Signal) error {
+	if c.job == nil {
+		return ErrNoJob
+	}
+	c.lk.Lock()
+	p := c.job.proc
+	c.lk.Unlock()
+	if p != nil {
+		return p.Signal(sig)
+	}
+	select {
+	case c.job.sigc <- sig:
+	default:
+		// already plenty of signals pending
+	}
+	return nil
+}
+
+// Wait for c to exit and return its status.
+func (c *Ctx) Wait() error {
+	<-c.wc
+	return cerror(c.wc)
+}
+
+// Return the running jobs, sorted by number.
+func Jobs() []Job {
+	ctxlk.Lock()
+	cs := make([]*Ctx, 0, len(ctxs))
+	for _, c := range ctxs {
+		if c.job != nil {
+			cs = append(cs, c)
+		}
+	}
+	ctxlk.Unlock()
+	js := make([]Job, 0, len(cs))
+	for _, c := range cs {
+		c.lk.Lock()
+		j := Job{Id: c.job.id, Args: append([]string{}, c.Args...), Start: c.job.start}
+		if c.job.proc != nil {
+			j.Pid = c.job.proc.Pid
+		}
+		c.lk.Unlock()
+		js = append(js, j)
+	}
+	sort.Sort(byJobId(js))
+	return js
+}
+
+// Return the context for job number n, or nil if it's gone.
+func JobCtx(n int) *Ctx {
+	ctxlk.Lock()
+	defer ctxlk.Unlock()
+	for _, c := range ctxs {
+		if c.job != nil && c.job.id == n {
+			return c
+		}
+	}
+	return nil
+}
+
+// Parse a job number, as in %3 or 3.
+func ParseJob(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "%"))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s: %s", s, ErrNoJob)
+	}
+	return n, nil
+}
+
+// Send a signal to job number n.
+func Signal(n int, sig os.Signal) error {
+	c := JobCtx(n)
+	if c == nil {
+		return fmt.Errorf("%%%d: %s", n, ErrNoJob)
+	}
+	return c.Signal(sig)
+}
+
+// Wait for job number n to exit and return its status.
+func Wait(n int) error {
+	c := JobCtx(n)
+	if c == nil {
+		return fmt.Errorf("%%%d: %s", n, ErrNoJob)
+	}
+	return c.Wait()
+}
+
+// Send a signal to all jobs (eg., os.Kill before exiting).
+func SignalAll(sig os.Signal) {
+	for _, j := range Jobs() {
+		Signal(j.Id, sig)
+	}
+}
+
+// Return the live descendants of c.
+func (c *Ctx) descendants() []*Ctx {
+	ctxlk.Lock()
+	defer ctxlk.Unlock()
+	var ds []*Ctx
+	var walk func(*Ctx)
+	walk = func(c *Ctx) {
+		for k := range c.kids {
+			ds = append(ds, k)
+			walk(k)
+		}
+	}
+	walk(c)
+	return ds
+}
+
+// Send os.Kill to the descendants of c.
+func (c *Ctx) killKids() {
+	for _, d := range c.descendants() {
+		d.Signal(os.Kill)
+	}
+}
+
+// Kill c and its descendants.
+func (c *Ctx) Kill() error {
+	c.killKids()
+	return c.Signal(os.Kill)
+}
+
+// Make c no longer a child of its parent, so it's not killed when
+// the parent exits.
+// The parent may exit before this is called; use NewDetached
+// to make contexts that are never its children.
+func (c *Ctx) Detach() {
+	ctxlk.Lock()
+	defer ctxlk.Unlock()
+	if c.parent != nil {
+		delete(c.parent.kids, c)
+		c.parent = nil
+	}
+}
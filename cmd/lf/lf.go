@@ -10,20 +10,104 @@ import (
 	"clive/cmd/opt"
 	"clive/zx"
 	"fmt"
+	fpath "path"
+	"sort"
+	"strings"
 )
 
 var (
 	opts      = opt.New("{file}")
 	ux, gflag bool
+	order     string
 	printf    = cmd.Printf
 )
 
+struct byPath {
+	ds []zx.Dir
+	c  zx.Collation
+}
+
+func (x byPath) Len() int           { return len(x.ds) }
+func (x byPath) Less(i, j int) bool { return x.c.LessPath(x.ds[i]["path"], x.ds[j]["path"]) }
+func (x byPath) Swap(i, j int)      { x.ds[i], x.ds[j] = x.ds[j], x.ds[i] }
+
+// collect the dirs from dc and send them sorted by path.
+func sorted(dc <-chan face{}, c zx.Collation) <-chan face{} {
+	rc := make(chan face{})
+	go func() {
+		var ds []zx.Dir
+		for m := range dc {
+			if d, ok := m.(zx.Dir); ok {
+				ds = append(ds, d)
+			} else if ok := rc <- m; !ok {
+				close(dc, cerror(rc))
+				return
+			}
+		}
+		sort.Stable(byPath{ds, c})
+		for _, d := range ds {
+			if ok := rc <- d; !ok {
+				return
+			}
+		}
+		close(rc, cerror(dc))
+	}()
+	return rc
+}
+
+// Like cmd.Dirs, but names without a predicate are stated all at once
+// and not found one by one.
+func dirs(names ...string) <-chan face{} {
+	var plain []string
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" && n[0] != '|' && !strings.Contains(n, ",") {
+			plain = append(plain, fpath.Clean(n))
+		}
+	}
+	if len(plain) == 0 {
+		return cmd.Dirs(names...)
+	}
+	ds, errs := cmd.Stats(plain...)
+	rc := make(chan face{})
+	go func() {
+		var err error
+		i := 0
+		for _, n := range names {
+			if n = strings.TrimSpace(n); n == "" || n[0] == '|' || strings.Contains(n, ",") {
+				dc := cmd.Dirs(n)
+				for m := range dc {
+					if ok := rc <- m; !ok {
+						close(dc, cerror(rc))
+						return
+					}
+				}
+				if derr := cerror(dc); derr != nil {
+					err = derr
+				}
+				continue
+			}
+			var m face{} = ds[i]
+			if errs[i] != nil {
+				err = errs[i]
+				m = err
+			}
+			i++
+			if ok := rc <- m; !ok {
+				return
+			}
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
 func main() {
 	cmd.UnixIO("err")
 	c := cmd.AppCtx()
 	opts.NewFlag("D", "debug", &c.Debug)
 	opts.NewFlag("u", "unix IO", &ux)
 	opts.NewFlag("g", "get contents", &gflag)
+	opts.NewFlag("o", "order: sort the listing (natural, locale, locale,natural)", &order)
 	if cmd.Args()[0] == "gf" {
 		gflag = true
 	}
@@ -35,15 +119,25 @@ func main() {
 		args = append(args, ".,1")
 	}
 
+	if order == "" {
+		order = cmd.GetEnv("dirsort")
+	}
+	col, err := zx.ParseCollation(order)
+	if err != nil {
+		cmd.Fatal(err)
+	}
+
 	var dc <-chan face{}
 	if !gflag {
-		dc = cmd.Dirs(args...)
+		dc = dirs(args...)
+		if col != zx.ByteOrder {
+			dc = sorted(dc, col)
+		}
 	} else {
 		dc = cmd.Files(args...)
 	}
 
 	out := cmd.Out("out")
-	var err error
 	for m := range dc {
 		cmd.Dprintf("got %T\n", m)
 		switch m := m.(type) {
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"bytes"
+	"clive/zx"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Each Ctx has a logger, shared with the contexts it creates,
+	with a level, key/value fields added to entries, and a set of
+	sinks where entries are written.
+	By default entries go to the err chan of the context logging,
+	as Warn does, and are printed as "app: msg k=v ...".
+	Other sinks (eg., FileSink, ZxSink) write timestamped entries.
+
+	Warn, VWarn, and Dprintf log through the logger, at the warn, info,
+	and debug levels, but they don't check the logger level, because
+	they check the Ctx flags as they always did.
+*/
+
+// Log levels.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// A log entry, as given to sinks.
+struct LogEntry {
+	Time   time.Time
+	Level  Level
+	App    string   // Args[0] for the context logging
+	Msg    string   // without a final newline
+	Fields []string // as in key=value
+	ctx    *Ctx
+}
+
+// Where log entries are written.
+interface LogSink {
+	Log(e *LogEntry) error
+}
+
+// Logger for a Ctx.
+struct Logger {
+	cfg    *logCfg
+	c      *Ctx
+	fields []string
+}
+
+// level and sinks, shared by the loggers for a context tree.
+struct logCfg {
+	sync.Mutex
+	level Level
+	sinks []LogSink
+}
+
+struct errSink {}
+
+struct fileSink {
+	sync.Mutex
+	fd *os.File
+}
+
+struct zxSink {
+	path string
+}
+
+// Sink writing to the err chan of the context logging.
+var ErrSink LogSink = errSink{}
+
+var lvlnames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level%d", int(l))
+	}
+	return lvlnames[l]
+}
+
+// Parse a level name, as printed by Level.String.
+func ParseLevel(s string) (Level, error) {
+	for i, n := range lvlnames {
+		if n == s {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level '%s'", s)
+}
+
+func newLogger(c *Ctx) *Logger {
+	return &Logger{
+		cfg: &logCfg{level: InfoLevel, sinks: []LogSink{ErrSink}},
+		c:   c,
+	}
+}
+
+// Return a logger for c sharing the level and sinks of l.
+func (l *Logger) forCtx(c *Ctx) *Logger {
+	return &Logger{cfg: l.cfg, c: c, fields: l.fields}
+}
+
+// The entry as printed by ErrSink.
+// Debug entries are printed without the app name, as Dprintf did.
+func (e *LogEntry) String() string {
+	var buf bytes.Buffer
+	if e.Level == DebugLevel {
+		buf.WriteString(e.Msg)
+	} else {
+		fmt.Fprintf(&buf, "%s: %s", e.App, e.Msg)
+	}
+	for _, f := range e.Fields {
+		buf.WriteString(" " + f)
+	}
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// The entry as printed by FileSink and ZxSink.
+func (e *LogEntry) Line() string {
+	return fmt.Sprintf("%s %s %s", e.Time.Format(time.RFC3339), e.Level, e.String())
+}
+
+func (errSink) Log(e *LogEntry) error {
+	_, err := e.ctx.cprintf("err", "%s", e.String())
+	return err
+}
+
+// Return a sink appending entries to the given (OS) file.
+func FileSink(path string) (LogSink, error) {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{fd: fd}, nil
+}
+
+func (s *fileSink) Log(e *LogEntry) error {
+	s.Lock()
+	defer s.Unlock()
+	_, err := s.fd.WriteString(e.Line())
+	return err
+}
+
+// Return a sink appending entries to the given file in the name space.
+func ZxSink(path string) LogSink {
+	return &zxSink{path: AbsPath(path)}
+}
+
+func (s *zxSink) Log(e *LogEntry) error {
+	dc := make(chan []byte, 1)
+	dc <- []byte(e.Line())
+	close(dc)
+	rc := e.ctx.NS().Put(s.path, zx.Dir{"type": "-", "mode": "0640"}, -1, dc)
+	<-rc
+	return cerror(rc)
+}
+
+// Return the logger for c.
+func (c *Ctx) Log() *Logger {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.log
+}
+
+// Return the logger for the current command.
+func Log() *Logger {
+	return ctx().Log()
+}
+
+// Return a logger adding the given key, value pairs to its entries.
+func (l *Logger) With(kvs ...face{}) *Logger {
+	nl := &Logger{cfg: l.cfg, c: l.c}
+	nl.fields = append(nl.fields, l.fields...)
+	for i := 0; i < len(kvs); i += 2 {
+		var v face{} = "?"
+		if i+1 < len(kvs) {
+			v = kvs[i+1]
+		}
+		nl.fields = append(nl.fields, fmt.Sprintf("%v=%v", kvs[i], v))
+	}
+	return nl
+}
+
+// Set the level for l and the loggers sharing its sinks.
+func (l *Logger) SetLevel(lvl Level) {
+	l.cfg.Lock()
+	defer l.cfg.Unlock()
+	l.cfg.level = lvl
+}
+
+func (l *Logger) Level() Level {
+	l.cfg.Lock()
+	defer l.cfg.Unlock()
+	return l.cfg.level
+}
+
+// Add a sink for l and the loggers sharing its sinks.
+func (l *Logger) AddSink(s LogSink) {
+	l.cfg.Lock()
+	defer l.cfg.Unlock()
+	l.cfg.sinks = append(l.cfg.sinks, s)
+}
+
+// Replace the sinks for l and the loggers sharing them.
+func (l *Logger) SetSinks(s ...LogSink) {
+	l.cfg.Lock()
+	defer l.cfg.Unlock()
+	l.cfg.sinks = s
+}
+
+// Write an entry to all sinks, no matter the level,
+// and return the first error.
+func (l *Logger) write(lvl Level, msg string) (int, error) {
+	e := &LogEntry{
+		Time:   time.Now(),
+		Level:  lvl,
+		Msg:    strings.TrimSuffix(msg, "\n"),
+		Fields: l.fields,
+		ctx:    l.c,
+	}
+	if len(l.c.Args) > 0 {
+		e.App = l.c.Args[0]
+	}
+	l.cfg.Lock()
+	sinks := l.cfg.sinks
+	l.cfg.Unlock()
+	var err error
+	for _, s := range sinks {
+		if serr := s.Log(e); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return len(msg), err
+}
+
+// Log an entry with the given level and message, and the given
+// key, value pairs as fields, if the level is enabled.
+func (l *Logger) Log(lvl Level, msg string, kvs ...face{}) error {
+	if lvl < l.Level() {
+		return nil
+	}
+	if len(kvs) > 0 {
+		l = l.With(kvs...)
+	}
+	_, err := l.write(lvl, msg)
+	return err
+}
+
+func (l *Logger) Debugf(f string, args ...face{}) error {
+	return l.Log(DebugLevel, fmt.Sprintf(f, args...))
+}
+
+func (l *Logger) Infof(f string, args ...face{}) error {
+	return l.Log(InfoLevel, fmt.Sprintf(f, args...))
+}
+
+func (l *Logger) Warnf(f string, args ...face{}) error {
+	return l.Log(WarnLevel, fmt.Sprintf(f, args...))
+}
+
+func (l *Logger) Errorf(f string, args ...face{}) error {
+	return l.Log(ErrorLevel, fmt.Sprintf(f, args...))
+}
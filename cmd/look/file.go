@@ -0,0 +1,94 @@
+package look
+
+import (
+	"clive/cmd"
+	"fmt"
+	fpath "path"
+	"sync"
+	"time"
+)
+
+// A file with rules, parsed again when it changes.
+// If Ival is not zero, the file is not checked for changes
+// more often than that.
+struct File {
+	Path string
+	Ival time.Duration
+
+	sync.Mutex
+	vers    string    // mtime and size when parsed
+	checked time.Time // when last checked
+	rs      Rules
+}
+
+// Interval to check for changes in .look files used by DirRules.
+var DirIval = 5 * time.Second
+
+var (
+	dirfslk sync.Mutex
+	dirfs   = map[string]*File{}
+)
+
+// Return the rules in the file, parsing it again if it changed
+// since the last call. A missing file has no rules.
+// Errors are reported just when the file is parsed, and the rules
+// that could be parsed are returned.
+func (f *File) Rules() (Rules, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.Ival > 0 && time.Since(f.checked) < f.Ival {
+		return f.rs, nil
+	}
+	f.checked = time.Now()
+	d, err := cmd.Stat(f.Path)
+	if err != nil || d["type"] != "-" {
+		f.vers, f.rs = "", nil
+		return nil, nil
+	}
+	vers := fmt.Sprintf("%s %s", d["mtime"], d["size"])
+	if vers == f.vers {
+		return f.rs, nil
+	}
+	dprintf("look: reading %s\n", f.Path)
+	f.vers = vers
+	dat, err := cmd.GetAll(f.Path)
+	if err != nil {
+		f.rs = nil
+		return nil, fmt.Errorf("%s: %s", f.Path, err)
+	}
+	f.rs, err = ParseRules(string(dat))
+	if err != nil {
+		err = fmt.Errorf("%s: %s", f.Path, err)
+	}
+	return f.rs, err
+}
+
+// Return the rules in .look files at dir and its parents,
+// those for inner dirs first.
+// Files are parsed again when they change, but they are checked
+// at most once per DirIval, to save requests to remote trees.
+func DirRules(dir string) (Rules, error) {
+	var rs Rules
+	var err error
+	dir = cmd.AbsPath(dir)
+	for {
+		fn := fpath.Join(dir, ".look")
+		dirfslk.Lock()
+		f := dirfs[fn]
+		if f == nil {
+			f = &File{Path: fn, Ival: DirIval}
+			dirfs[fn] = f
+		}
+		dirfslk.Unlock()
+		frs, ferr := f.Rules()
+		if ferr != nil && err == nil {
+			err = ferr
+		}
+		rs = append(rs, frs...)
+		if dir == "/" || dir == "." || dir == "" {
+			break
+		}
+		dir = fpath.Dir(dir)
+	}
+	return rs, err
+}
@@ -11,6 +11,11 @@
 	rules to match.
 	Back-references may be used to build a command from parts
 	of the matching text.
+	Groups may be named, as in (?<file>[^:]+), and then $file
+	may be used in the command instead of the back-reference.
+	Besides the global rules, .look files in the directory
+	of the look and its parents may keep rules for that tree
+	(see DirRules).
 */
 package look
 
@@ -27,12 +32,14 @@ import (
 // If the user looks for something matching Rexp, then
 // Cmd leads to a result string.
 // Backquoting to refer to \0...\9 is ok in Cmd.
+// Named groups in Rexp may be referred to as $name in Cmd.
 struct Rule {
 	Rexp string
 	Cmd  string
 
 	sync.Mutex
-	re *sre.ReProg
+	re  *sre.ReProg
+	cmd string // Cmd with $names replaced by back-references
 }
 
 type Rules []*Rule
@@ -59,19 +66,110 @@ func (r *Rule) CmdFor(s string) (string, error) {
 	r.Lock()
 	defer r.Unlock()
 	if r.re == nil {
-		re, err := sre.Compile([]rune(r.Rexp), sre.Fwd)
+		rexp, names := named(r.Rexp)
+		re, err := sre.Compile([]rune(rexp), sre.Fwd)
 		if err != nil {
 			dprintf("look: %s: %v\n", r.Rexp, err)
 			return "", fmt.Errorf("look: rexp: %s", err)
 		}
 		r.re = re
+		r.cmd = expand(r.Cmd, names)
 	}
 	outs := r.re.Match(s)
 	dprintf("look: %s: %v\n", r.Rexp, outs)
 	if len(outs) == 0 {
 		return "", ErrNoMatch
 	}
-	return sre.Repl(outs, r.Cmd), nil
+	return sre.Repl(outs, r.cmd), nil
+}
+
+// Remove the names from (?<name>...) groups in rexp and
+// return the group number for each name.
+func named(rexp string) (string, map[string]int) {
+	var buf bytes.Buffer
+	names := map[string]int{}
+	rs := []rune(rexp)
+	ngrp := 0
+	for i := 0; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\':
+			buf.WriteRune(rs[i])
+			if i+1 < len(rs) {
+				i++
+				buf.WriteRune(rs[i])
+			}
+			continue
+		case '[':
+			// copy the class, ] is not special right after [ or [^
+			j := i + 1
+			if j < len(rs) && rs[j] == '^' {
+				j++
+			}
+			if j < len(rs) && rs[j] == ']' {
+				j++
+			}
+			for j < len(rs) && rs[j] != ']' {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				j = len(rs) - 1
+			}
+			buf.WriteString(string(rs[i : j+1]))
+			i = j
+			continue
+		case '(':
+			ngrp++
+			buf.WriteRune(rs[i])
+			s := string(rs[i+1:])
+			if !strings.HasPrefix(s, "?<") {
+				continue
+			}
+			e := strings.IndexRune(s, '>')
+			if e < 0 {
+				continue
+			}
+			names[s[2:e]] = ngrp
+			i += len([]rune(s[:e+1]))
+			continue
+		}
+		buf.WriteRune(rs[i])
+	}
+	return buf.String(), names
+}
+
+func isNameRune(r rune, first bool) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		!first && r >= '0' && r <= '9'
+}
+
+// Replace $name in cmd with the back-reference for the named group.
+// Unknown names are left alone, and so are names for groups past \9.
+func expand(cmd string, names map[string]int) string {
+	if len(names) == 0 {
+		return cmd
+	}
+	var buf bytes.Buffer
+	rs := []rune(cmd)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '$' {
+			buf.WriteRune(rs[i])
+			continue
+		}
+		j := i + 1
+		for j < len(rs) && isNameRune(rs[j], j == i+1) {
+			j++
+		}
+		if n, ok := names[string(rs[i+1:j])]; ok && n <= 9 {
+			fmt.Fprintf(&buf, "\\%d", n)
+			i = j - 1
+			continue
+		}
+		buf.WriteRune(rs[i])
+	}
+	return buf.String()
 }
 
 // Return the command for a user look, if any.
@@ -1,6 +1,9 @@
 package look
 
 import (
+	"clive/cmd"
+	"fmt"
+	"os"
 	"testing"
 )
 
@@ -13,6 +16,15 @@ func TestCmdFor(t *testing.T) {
 	}
 }
 
+func TestNamed(t *testing.T) {
+	r := &Rule{Rexp: `^(?<file>[^:(]+):(?<line>[0-9]+)$`, Cmd: `ix $file:$line $other`}
+	s, err := r.CmdFor("a/b.go:42")
+	t.Logf("got %v %v\n", s, err)
+	if s != "ix a/b.go:42 $other" {
+		t.Fatalf("didn't get the expected match")
+	}
+}
+
 func TestParse(t *testing.T) {
 	txt := `# example
 
@@ -37,3 +49,26 @@ foo
 		t.Fatalf("bad rules")
 	}
 }
+
+func TestPlumb(t *testing.T) {
+	cmd.SetEnv("plumb", fmt.Sprintf("/tmp/plumbtest.%d", os.Getpid()))
+	mc, err := ServePlumb()
+	if err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+	defer close(mc)
+	if err := Plumb("/a/dir", "file.go:42", "", "foo(1)"); err != nil {
+		t.Fatalf("plumb: %s", err)
+	}
+	xs := []string{"/a/dir: file.go:42", "/a/dir: foo(1)"}
+	for _, x := range xs {
+		m, ok := <-mc
+		if !ok {
+			t.Fatalf("plumb: %v", cerror(mc))
+		}
+		t.Logf("got %s", m)
+		if m.String() != x {
+			t.Fatalf("bad msg")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package look
+
+import (
+	"bufio"
+	"clive/cmd"
+	"clive/u"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+/*
+	The plumber is a unix socket where any process may post looks,
+	so that programs outside ix (compilers, git hooks, terminals, ...)
+	can ask it to open files at a given address, or to look for
+	anything else as if the user did.
+
+	The protocol is text, one message per line, so that
+		echo /a/file.go:42 | nc -U /tmp/clive.plumb.nemo
+	works. A line
+		cd /some/dir
+	sets the directory for relative names in the following messages.
+	The plumber is at $plumb or /tmp/clive.plumb.$user by default.
+*/
+struct Msg {
+	Dir  string // dir for relative names
+	Data string // what to look for, eg. file.go:42
+}
+
+// Return the path of the plumber socket.
+func PlumbAddr() string {
+	if p := cmd.GetEnv("plumb"); p != "" {
+		return p
+	}
+	return "/tmp/clive.plumb." + u.Uid
+}
+
+func (m Msg) String() string {
+	return fmt.Sprintf("%s: %s", m.Dir, m.Data)
+}
+
+// Post messages to the plumber, with relative names looked up at dir.
+func Plumb(dir string, msgs ...string) error {
+	c, err := net.Dial("unix", PlumbAddr())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if dir != "" {
+		if _, err := fmt.Fprintf(c, "cd %s\n", dir); err != nil {
+			return err
+		}
+	}
+	for _, m := range msgs {
+		m = strings.TrimSpace(strings.Replace(m, "\n", " ", -1))
+		if m == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(c, "%s\n", m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Serve the plumber and return a chan to receive messages posted.
+	The caller may close the chan to stop the service (it's noticed
+	when the next message arrives).
+	Messages without a dir get the one of the caller.
+*/
+func ServePlumb() (chan Msg, error) {
+	addr := PlumbAddr()
+	os.Remove(addr)
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	dot := cmd.Dot()
+	mc := make(chan Msg)
+	go func() {
+		defer os.Remove(addr)
+		for {
+			fd, err := l.Accept()
+			if err != nil {
+				dprintf("plumb: %s\n", err)
+				close(mc, err)
+				return
+			}
+			go plumbs(fd, dot, mc, l)
+		}
+	}()
+	return mc, nil
+}
+
+func plumbs(fd net.Conn, dir string, mc chan Msg, l net.Listener) {
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		ln := strings.TrimSpace(scn.Text())
+		if ln == "" {
+			continue
+		}
+		if strings.HasPrefix(ln, "cd ") {
+			dir = strings.TrimSpace(ln[3:])
+			continue
+		}
+		m := Msg{Dir: dir, Data: ln}
+		dprintf("plumb: %s\n", m)
+		if ok := mc <- m; !ok {
+			// the caller is done
+			l.Close()
+			return
+		}
+	}
+}
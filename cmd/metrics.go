@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	Counters kept for the process, exported by the debug server
+	(see $clivedebug) at /debug/vars (expvar) and at /metrics
+	(in the Prometheus text format):
+		clive_cmds_total{cmd}	contexts made by New that exited
+		clive_cmd_errors_total{cmd}	those that exited with an error
+		clive_io_bytes_total{dir}	bytes read and written on UNIX fds
+		clive_zx_seconds{op}	latency histogram for Stat, GetAll, Put, and Dirs
+	They are kept even if not exported, because they are cheap.
+*/
+
+struct histogram {
+	counts []int64 // per bucket in latBuckets, and +Inf
+	sum    float64
+	n      int64
+}
+
+struct metricSet {
+	sync.Mutex
+	cmds map[string]int64
+	errs map[string]int64
+	zx   map[string]*histogram
+	inb  int64 // atomic
+	outb int64 // atomic
+}
+
+struct countReader {
+	r io.Reader
+	n *int64
+}
+
+struct countWriter {
+	w io.Writer
+	n *int64
+}
+
+// Upper bounds (in seconds) for the latency histogram buckets.
+var latBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5}
+
+var mx = &metricSet{
+	cmds: map[string]int64{},
+	errs: map[string]int64{},
+	zx:   map[string]*histogram{},
+}
+
+func init() {
+	expvar.Publish("clive", expvar.Func(func() face{} {
+		return mx.snapshot()
+	}))
+}
+
+func (r countReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+func (w countWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// Record that a context running cmd exited with the given status.
+func (m *metricSet) exited(cmd string, sts string) {
+	m.Lock()
+	defer m.Unlock()
+	m.cmds[cmd]++
+	if sts != "" {
+		m.errs[cmd]++
+	}
+}
+
+// Record the latency for a zx request started at t0.
+func (m *metricSet) zxDone(op string, t0 time.Time) {
+	secs := time.Since(t0).Seconds()
+	m.Lock()
+	defer m.Unlock()
+	h := m.zx[op]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(latBuckets)+1)}
+		m.zx[op] = h
+	}
+	i := sort.SearchFloat64s(latBuckets, secs)
+	h.counts[i]++
+	h.sum += secs
+	h.n++
+}
+
+func (m *metricSet) snapshot() map[string]face{} {
+	m.Lock()
+	defer m.Unlock()
+	cmds := map[string]int64{}
+	for k, v := range m.cmds {
+		cmds[k] = v
+	}
+	errs := map[string]int64{}
+	for k, v := range m.errs {
+		errs[k] = v
+	}
+	zx := map[string]face{}{}
+	for op, h := range m.zx {
+		zx[op] = map[string]face{}{"count": h.n, "sum": h.sum}
+	}
+	return map[string]face{}{
+		"cmds":     cmds,
+		"errors":   errs,
+		"inbytes":  atomic.LoadInt64(&m.inb),
+		"outbytes": atomic.LoadInt64(&m.outb),
+		"zx":       zx,
+	}
+}
+
+func sortedKeys(m map[string]int64) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// Write the metrics in the Prometheus text format.
+func (m *metricSet) writeTo(buf *bytes.Buffer) {
+	m.Lock()
+	defer m.Unlock()
+	buf.WriteString("# TYPE clive_cmds_total counter\n")
+	for _, k := range sortedKeys(m.cmds) {
+		fmt.Fprintf(buf, "clive_cmds_total{cmd=%q} %d\n", k, m.cmds[k])
+	}
+	buf.WriteString("# TYPE clive_cmd_errors_total counter\n")
+	for _, k := range sortedKeys(m.errs) {
+		fmt.Fprintf(buf, "clive_cmd_errors_total{cmd=%q} %d\n", k, m.errs[k])
+	}
+	buf.WriteString("# TYPE clive_io_bytes_total counter\n")
+	fmt.Fprintf(buf, "clive_io_bytes_total{dir=\"in\"} %d\n", atomic.LoadInt64(&m.inb))
+	fmt.Fprintf(buf, "clive_io_bytes_total{dir=\"out\"} %d\n", atomic.LoadInt64(&m.outb))
+	buf.WriteString("# TYPE clive_zx_seconds histogram\n")
+	var ops []string
+	for op := range m.zx {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := m.zx[op]
+		n := int64(0)
+		for i, b := range latBuckets {
+			n += h.counts[i]
+			fmt.Fprintf(buf, "clive_zx_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, b, n)
+		}
+		fmt.Fprintf(buf, "clive_zx_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.n)
+		fmt.Fprintf(buf, "clive_zx_seconds_sum{op=%q} %g\n", op, h.sum)
+		fmt.Fprintf(buf, "clive_zx_seconds_count{op=%q} %d\n", op, h.n)
+	}
+}
+
+func debugMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	mx.writeTo(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
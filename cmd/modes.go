@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"clive/zx"
+	"strconv"
+)
+
+/*
+	A context may have default modes and a group for the files
+	and directories it creates with Put, PutAll, and MkDir,
+	set with SetModes and inherited by the contexts it creates.
+	They are used only when the caller does not give them
+	(ie., when there's no mode or gid attribute), and only
+	when creating files (ie., when there's a type attribute).
+
+	If $umask is set (in octal, eg. "022"), its bits are cleared
+	from the default modes, as the UNIX umask does.
+*/
+
+// Default attributes for created files and directories.
+struct Modes {
+	File  uint64 // mode for files
+	Dir   uint64 // mode for directories
+	Group string // gid, if not empty
+}
+
+// Modes used when there's no policy set.
+var DefModes = Modes{File: 0644, Dir: 0755}
+
+// Set the default modes for files created by c and the contexts it creates later.
+// A nil value means DefModes.
+func (c *Ctx) SetModes(m *Modes) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.modes = m
+}
+
+// Set the default modes for files created by the current command.
+func SetModes(m *Modes) {
+	ctx().SetModes(m)
+}
+
+// Return the default modes for files created by c, after applying
+// $umask to them.
+func (c *Ctx) Modes() Modes {
+	c.lk.Lock()
+	m := DefModes
+	if c.modes != nil {
+		m = *c.modes
+	}
+	c.lk.Unlock()
+	if s := c.GetEnv("umask"); s != "" {
+		mask, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			Dprintf("umask: %s\n", err)
+		} else {
+			m.File &^= mask
+			m.Dir &^= mask
+		}
+	}
+	return m
+}
+
+// Return ud with the default mode and group for c if it creates a file
+// and does not say which ones to use.
+// ud is not changed, a copy is made if needed.
+func (c *Ctx) withModes(ud zx.Dir) zx.Dir {
+	if ud == nil || ud["type"] == "" {
+		return ud
+	}
+	setmode, setgid := ud["mode"] == "", ud["gid"] == ""
+	if !setmode && !setgid {
+		return ud
+	}
+	m := c.Modes()
+	if !setmode && m.Group == "" {
+		return ud
+	}
+	ud = ud.Dup()
+	if setmode {
+		switch ud["type"] {
+		case "d", "D":
+			ud.SetMode(m.Dir)
+		default:
+			ud.SetMode(m.File)
+		}
+	}
+	if setgid && m.Group != "" {
+		ud["gid"] = m.Group
+	}
+	return ud
+}
@@ -0,0 +1,161 @@
+package opt
+
+import (
+	"clive/cmd"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+	Instead of defining flags one by one, a command may declare
+	a struct with its options and Bind it:
+
+		struct lsFlags {
+			Long  bool     `opt:"l" help:"long listing"`
+			Depth int      `opt:"d" help:"n: descend at most n levels" valid:"0,"`
+			Sort  string   `opt:"s" help:"key: sort by key" valid:"name|size|mtime"`
+			Excl  []string `opt:"x" help:"pred: exclude files matching pred"`
+		}
+		var flags lsFlags
+		opts := opt.New("{file}")
+		opts.Bind(&flags)
+		args := opts.Parse()
+
+	The opt tag gives the flag name and the help tag its help string,
+	as given to NewFlag, so single-rune bool and Counter flags
+	may be combined as usual (eg. -ld) and the usage is generated
+	from the tags.
+	Fields without an opt tag are ignored.
+
+	The valid tag, if any, is checked after parsing:
+	for strings, it's a list of the values permitted, separated by "|"
+	(the empty string is always permitted, to mean the flag was not given);
+	for numbers, it's a range "min,max" where either one may be missing.
+	If the struct has a Validate() error method, it's called after
+	the checks.
+	A value that's not valid is reported and Usage is called, as it
+	happens for other errors while parsing.
+*/
+
+interface validator {
+	Validate() error
+}
+
+// Define the flags for the fields with an opt tag in the struct
+// pointed to by vp, using their help and valid tags (see above).
+// It calls Fatal if vp is not a pointer to a struct, a field has a type
+// not understood by NewFlag, or a valid tag is malformed.
+func (f *Flags) Bind(vp face{}) {
+	v := reflect.ValueOf(vp)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		cmd.Fatal("bind: %T is not a pointer to a struct", vp)
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fld := t.Field(i)
+		name := fld.Tag.Get("opt")
+		if name == "" {
+			continue
+		}
+		if fld.PkgPath != "" {
+			cmd.Fatal("bind: field %s is not exported", fld.Name)
+		}
+		fvp := v.Field(i).Addr().Interface()
+		f.NewFlag(name, fld.Tag.Get("help"), fvp)
+		if vt := fld.Tag.Get("valid"); vt != "" {
+			chk, err := validFn(name, vt, fvp)
+			if err != nil {
+				cmd.Fatal("bind: field %s: %s", fld.Name, err)
+			}
+			f.checks = append(f.checks, chk)
+		}
+	}
+	if vf, ok := vp.(validator); ok {
+		f.checks = append(f.checks, vf.Validate)
+	}
+}
+
+// Return a func checking the value at vp for the valid tag vt.
+func validFn(name, vt string, vp face{}) (func() error, error) {
+	switch vp := vp.(type) {
+	case *string:
+		ok := strings.Split(vt, "|")
+		return func() error {
+			return oneOf(name, *vp, ok)
+		}, nil
+	case *[]string:
+		ok := strings.Split(vt, "|")
+		return func() error {
+			for _, s := range *vp {
+				if err := oneOf(name, s, ok); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
+	toks := strings.SplitN(vt, ",", 2)
+	if len(toks) != 2 {
+		return nil, errors.New("valid tag is not a range")
+	}
+	var min, max *float64
+	for i, tok := range toks {
+		if tok == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("valid tag: %s", err)
+		}
+		if i == 0 {
+			min = &x
+		} else {
+			max = &x
+		}
+	}
+	v := reflect.ValueOf(vp).Elem()
+	return func() error {
+		var x float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int64:
+			x = float64(v.Int())
+		case reflect.Uint64:
+			x = float64(v.Uint())
+		case reflect.Float64:
+			x = v.Float()
+		default:
+			return fmt.Errorf("option '%s': can't check %s", name, v.Type())
+		}
+		if (min != nil && x < *min) || (max != nil && x > *max) {
+			return fmt.Errorf("option '%s': value out of range %s", name, vt)
+		}
+		return nil
+	}, nil
+}
+
+func oneOf(name, s string, ok []string) error {
+	if s == "" {
+		return nil
+	}
+	for _, o := range ok {
+		if s == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("option '%s': value '%s' not one of %s",
+		name, s, strings.Join(ok, ", "))
+}
+
+// Run the checks for the bound values, if any.
+func (f *Flags) validate() error {
+	for _, chk := range f.checks {
+		if err := chk(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -35,8 +35,9 @@ struct Flags {
 	Argv0       string // program name from the last call to Parse
 	usage       string // usage string w/o program name
 	defs        map[string]*def
-	plus, minus *def   // defs for +int -int
-	xtra        string // extra usage info
+	plus, minus *def           // defs for +int -int
+	xtra        string         // extra usage info
+	checks      []func() error // for values defined by Bind
 }
 
 // Use Counter as the value for counting flags, which are bool flags
@@ -310,7 +311,17 @@ func (f *Flags) NewFlag(name, help string, vp face{}) {
 // A "-?" argument fails with a "usage" error
 // If argv is nil, it is taken from the current cmd context.
 // An error in parsing calls Usage() and terminates execution.
+// Values for flags defined with Bind are checked after parsing.
 func (f *Flags) Parse(argv ...string) []string {
+	args := f.parse(argv...)
+	if err := f.validate(); err != nil {
+		cmd.Warn("%s", err)
+		f.Usage()
+	}
+	return args
+}
+
+func (f *Flags) parse(argv ...string) []string {
 	var err error
 	if len(argv) == 0 {
 		c := cmd.AppCtx()
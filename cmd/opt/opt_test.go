@@ -102,3 +102,45 @@ func TestFlags(t *testing.T) {
 		t.Fatal("bad arg")
 	}
 }
+
+struct bopts {
+	Long  bool     `opt:"l" help:"long listing"`
+	Verb  Counter  `opt:"v" help:"verbose"`
+	Depth int      `opt:"d" help:"n: descend at most n levels" valid:"0,5"`
+	Sort  string   `opt:"s" help:"key: sort by key" valid:"name|size"`
+	Excl  []string `opt:"x" help:"pred: exclude files matching pred"`
+	Other int
+}
+
+func TestBind(t *testing.T) {
+	var o bopts
+	opts := New("{file}")
+	opts.Bind(&o)
+	args := opts.parse("lf", "-lvv", "-d3", "-s", "size", "-x", "a", "-x", "b", "/tmp")
+	res := fmt.Sprintf("%v %d %d %s %v", o.Long, o.Verb, o.Depth, o.Sort, o.Excl)
+	if testing.Verbose() {
+		fmt.Printf("opts %s args %v\n", res, args)
+	}
+	if res != "true 2 3 size [a b]" || strings.Join(args, " ") != "/tmp" {
+		t.Fatalf("bad result %s %v", res, args)
+	}
+	if err := opts.validate(); err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+	o.Depth = 6
+	if err := opts.validate(); err == nil {
+		t.Fatalf("range not checked")
+	} else if testing.Verbose() {
+		fmt.Printf("err %s\n", err)
+	}
+	o.Depth = 0
+	o.Sort = "mtime"
+	if err := opts.validate(); err == nil {
+		t.Fatalf("values not checked")
+	} else if testing.Verbose() {
+		fmt.Printf("err %s\n", err)
+	}
+	if _, ok := opts.defs["Other"]; ok || len(opts.defs) != 5 {
+		t.Fatalf("bad defs")
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+/*
+	Pipe runs a sequence of functions as a pipeline, each one in a
+	new Ctx (as with New), the out chan of one feeding the in chan
+	of the next one, as ql does for cmd | cmd | ...
+	The first one gets the in chan of the caller, and the last
+	one the out chan. All share the err chan.
+	Like in ql, all but the last one fork their env, ns, and dot.
+
+	The exit status of a stage closes the in chan of the next one,
+	so it may check cerror(In("in")), and a stage that stops reading
+	its input makes the previous one fail when it sends more.
+
+	External commands may be used as stages with run.Stage.
+*/
+
+// Run fns as a pipeline and return a chan closed when all of them
+// have exited, with the first failed exit status, if any.
+func Pipe(fns ...func()) chan error {
+	rc := make(chan error)
+	if len(fns) == 0 {
+		close(rc)
+		return rc
+	}
+	var cs []*Ctx
+	var inc <-chan face{} // for the next stage, nil for the first
+	for i, fn := range fns {
+		fn := fn
+		sin := inc
+		last := i == len(fns)-1
+		var outc chan face{}
+		if !last {
+			outc = make(chan face{})
+		}
+		c := New(func() {
+			if !last {
+				ForkEnv()
+				ForkNS()
+				ForkDot()
+				SetOut("out", outc)
+			}
+			if sin != nil {
+				SetIn("in", sin)
+			}
+			fn()
+		})
+		cs = append(cs, c)
+		if !last {
+			nc := make(chan face{})
+			go link(c, outc, nc)
+			inc = nc
+		}
+	}
+	go func() {
+		var err error
+		for _, c := range cs {
+			if sts := c.Wait(); sts != nil && err == nil {
+				err = sts
+			}
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Copy the output of stage c to the input of the next one and
+// close it with the status of c.
+func link(c *Ctx, outc <-chan face{}, inc chan face{}) {
+	for m := range outc {
+		if ok := inc <- m; !ok {
+			close(outc, cerror(inc))
+			break
+		}
+	}
+	err := c.Wait()
+	if err == nil {
+		err = cerror(outc)
+	}
+	close(inc, err)
+}
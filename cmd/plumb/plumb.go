@@ -0,0 +1,47 @@
+/*
+	Post looks to the plumber, eg. to make ix open a file at an address.
+
+	Each argument is a message; without arguments, each input line is
+	a message, so the output of compilers and the like may be plumbed.
+*/
+package main
+
+import (
+	"clive/cmd"
+	"clive/cmd/look"
+	"clive/cmd/opt"
+	"strings"
+)
+
+var (
+	opts = opt.New("{msg}")
+	dir  string
+)
+
+// Run plumb in the current app context.
+func main() {
+	c := cmd.AppCtx()
+	opts.NewFlag("D", "debug", &c.Debug)
+	opts.NewFlag("d", "dir: dir for relative names (dot by default)", &dir)
+	cmd.UnixIO("err")
+	args := opts.Parse()
+	if dir == "" {
+		dir = cmd.Dot()
+	} else {
+		dir = cmd.AbsPath(dir)
+	}
+	if len(args) == 0 {
+		in := cmd.Lines(cmd.In("in"))
+		for m := range in {
+			if b, ok := m.([]byte); ok {
+				args = append(args, strings.TrimSpace(string(b)))
+			}
+		}
+		if err := cerror(in); err != nil {
+			cmd.Fatal(err)
+		}
+	}
+	if err := look.Plumb(dir, args...); err != nil {
+		cmd.Fatal(err)
+	}
+}
@@ -42,6 +42,17 @@ func init() {
 	builtins["exit"] = bexit
 	builtins["break"] = bbreak
 	builtins["shift"] = bshift
+	builtins["saveenv"] = bsaveenv
+}
+
+func bsaveenv(x *xEnv, args ...string) error {
+	if err := cmd.SaveEnv(args[1:]...); err != nil {
+		x.Eprintf("saveenv: %s\n", err)
+		cmd.SetEnv("sts", err.Error())
+	} else {
+		cmd.SetEnv("sts", "")
+	}
+	return nil
 }
 
 func bshift(x *xEnv, args ...string) error {
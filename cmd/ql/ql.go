@@ -149,6 +149,11 @@ func main() {
 	} else {
 		iflag = tty.IsTTY(os.Stdin)
 	}
+	if iflag {
+		if err := cmd.LoadEnv(); err != nil {
+			cmd.Warn("env: %s", err)
+		}
+	}
 	c.Debug = c.Debug || ldebug || ydebug || nddebug
 	nddebug = nddebug || ydebug
 	cmd.SetEnv("argv0", c.Args[0])
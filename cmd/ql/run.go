@@ -368,7 +368,12 @@ func (nd *Nd) runPipe(x *xEnv) error {
 		if bg != "" {
 			cx.isbg = true
 		}
-		cx.xctx = cmd.New(func() {
+		newf := cmd.New
+		if bg != "" {
+			// background jobs outlive the script
+			newf = cmd.NewDetached
+		}
+		cx.xctx = newf(func() {
 			defer cx.Close()
 			if bg != "" || i < len(nd.Child)-1 {
 				cmd.ForkEnv()
@@ -546,7 +551,7 @@ func (nd *Nd) pipeFrom(x *xEnv, cname string) (*xFd, error) {
 		fd.Close()
 	}
 	cx.fds[cname] = &xFd{fd: w, path: cname, ref: 1, isIn: false}
-	cx.xctx = cmd.New(func() {
+	cx.xctx = cmd.NewDetached(func() {
 		defer cx.Close()
 		if err := nd.runBlock(cx); err != nil {
 			cmd.Exit(err)
@@ -568,7 +573,7 @@ func (nd *Nd) pipeTo(x *xEnv, cname string) (*xFd, error) {
 		fd.Close()
 	}
 	cx.fds[cname] = &xFd{fd: r, path: cname, ref: 1, isIn: true}
-	cx.xctx = cmd.New(func() {
+	cx.xctx = cmd.NewDetached(func() {
 		defer cx.Close()
 		if err := nd.runBlock(cx); err != nil {
 			cmd.Exit(err)
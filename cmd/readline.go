@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"clive/cmd/tty"
+	"io"
+	"strings"
+)
+
+/*
+	ReadLine reads lines from an input chan, keeping the data after
+	the line for further calls, and Prompt asks for a line to the user.
+	PromptSecret does not echo the line typed when the in chan is a
+	terminal.
+	Line editing is that of the terminal (erase, kill), if any.
+*/
+
+// Read a line from the named input chan of c and return it without
+// the final newline (or \r\n).
+// Data after the line is kept for further calls.
+// Messages other than []byte and string are ignored.
+// At the end of the input, io.EOF or the chan error is returned.
+func (c *Ctx) ReadLine(name string) (string, error) {
+	c.lk.Lock()
+	set := c.io
+	c.lk.Unlock()
+	cr := set.get(name)
+	if cr == nil || !cr.isIn {
+		return "", ErrIO
+	}
+	cr.rdlk.Lock()
+	defer cr.rdlk.Unlock()
+	for {
+		if n := bytes.IndexByte(cr.rdbuf, '\n'); n >= 0 {
+			ln := string(cr.rdbuf[:n])
+			cr.rdbuf = cr.rdbuf[n+1:]
+			return strings.TrimSuffix(ln, "\r"), nil
+		}
+		m, ok := <-cr.inc
+		if !ok {
+			if len(cr.rdbuf) > 0 {
+				ln := string(cr.rdbuf)
+				cr.rdbuf = nil
+				return ln, nil
+			}
+			err := cerror(cr.inc)
+			if err == nil {
+				err = io.EOF
+			}
+			return "", err
+		}
+		switch m := m.(type) {
+		case []byte:
+			cr.rdbuf = append(cr.rdbuf, m...)
+		case string:
+			cr.rdbuf = append(cr.rdbuf, m...)
+		default:
+			Dprintf("readline: ignored %T\n", m)
+		}
+	}
+}
+
+// Read a line from the in chan, see Ctx.ReadLine.
+func ReadLine() (string, error) {
+	return ctx().ReadLine("in")
+}
+
+func (c *Ctx) prompt(msg string, echo bool) (string, error) {
+	if msg != "" {
+		c.cprintf("err", "%s", msg)
+	}
+	c.lk.Lock()
+	set := c.io
+	c.lk.Unlock()
+	if cr := set.get("in"); !echo && cr != nil && cr.tty {
+		cr.Lock()
+		fd := cr.file()
+		cr.Unlock()
+		if err := tty.SetEcho(fd, false); err == nil {
+			defer func() {
+				tty.SetEcho(fd, true)
+				c.cprintf("err", "\n")
+			}()
+		}
+	}
+	return c.ReadLine("in")
+}
+
+// Print msg to the err chan and read a line from the in chan.
+func (c *Ctx) Prompt(msg string) (string, error) {
+	return c.prompt(msg, true)
+}
+
+// Like Ctx.Prompt, but the line is not echoed if the in chan is a
+// terminal, eg. to read passwords.
+func (c *Ctx) PromptSecret(msg string) (string, error) {
+	return c.prompt(msg, false)
+}
+
+// Print msg to the err chan and read a line from the in chan.
+func Prompt(msg string) (string, error) {
+	return ctx().Prompt(msg)
+}
+
+// Like Prompt, but the line is not echoed if the in chan is a
+// terminal, eg. to read passwords.
+func PromptSecret(msg string) (string, error) {
+	return ctx().PromptSecret(msg)
+}
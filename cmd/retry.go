@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"clive/ns"
+	"clive/zx"
+	"time"
+)
+
+/*
+	A context may have a retry policy for the requests made by
+	Stat, Get, GetAll, Put, and Dirs, set with SetRetry and
+	inherited by the contexts it creates.
+
+	When a request fails with an I/O error (see zx.IsIOError), the
+	file systems serving the path are redialed and the request is
+	tried again after a backoff, doubled on each try, as long as
+	the policy permits.
+	Get resumes after the data already received.
+	Put keeps the data sent, up to MaxRetryPut bytes, and sends it
+	again; appends and puts sending more data are not retried.
+	When the entire file is put, the checksums of the blocks
+	already in the file (see zx.Sums) are compared with those for
+	the data kept, and only the data after the last block that
+	matches is sent again.
+	Dirs retries only if no entry was sent yet for the name.
+*/
+
+// A retry policy for zx requests.
+struct Retry {
+	Tries   int           // max number of tries, counting the first one
+	Backoff time.Duration // wait before the first retry
+	Timeout time.Duration // don't retry after this time, if not zero
+}
+
+interface redialer {
+	Redial() error
+}
+
+// Set the retry policy for c and the contexts it creates later.
+// A nil policy means no retries.
+func (c *Ctx) SetRetry(r *Retry) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.retry = r
+}
+
+// Set the retry policy for the current command.
+func SetRetry(r *Retry) {
+	ctx().SetRetry(r)
+}
+
+// Return the retry policy for c, or nil.
+func (c *Ctx) Retry() *Retry {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.retry
+}
+
+// Try number try of a request for path made at t0 failed with err:
+// if it can be retried, wait, redial the servers for path,
+// and return true.
+func (r *Retry) again(n *ns.NS, path string, try int, t0 time.Time, err error) bool {
+	if r == nil || try >= r.Tries || !zx.IsIOError(err) {
+		return false
+	}
+	wait := r.Backoff << uint(try-1)
+	if r.Timeout > 0 && time.Since(t0)+wait > r.Timeout {
+		return false
+	}
+	time.Sleep(wait)
+	redial(n, path)
+	return true
+}
+
+// Redial the file systems serving path.
+func redial(n *ns.NS, path string) {
+	_, ds, err := n.Resolve(path)
+	if err != nil {
+		return
+	}
+	for _, d := range ds {
+		if d["addr"] == "" {
+			continue
+		}
+		fs, err := ns.DirFs(d)
+		if err != nil {
+			continue
+		}
+		if rfs, ok := fs.(redialer); ok {
+			if err := rfs.Redial(); err != nil {
+				Dprintf("redial %s: %s\n", d["addr"], err)
+			}
+		}
+	}
+}
+
+// Call fn for a request on path until it succeeds or the
+// retry policy of c says it's enough, and return its error.
+func (c *Ctx) retrying(path string, fn func() error) error {
+	r := c.Retry()
+	t0 := time.Now()
+	for try := 1; ; try++ {
+		err := fn()
+		if err == nil || !r.again(c.NS(), path, try, t0, err) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package run
+
+import (
+	"clive/cmd"
+	"clive/ns"
+	"clive/zx"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Restrictions for the processes started by this package.
+//
+// Env variables in EnvDeny are never given to the process and, if
+// EnvAllow is not empty, only those listed are given to it.
+// The variables used by clive to run commands (dot, cliveio#..., NS,
+// traceparent, and the policy itself) are always kept.
+//
+// If Paths is not empty, the process must start at one of them and the
+// name space given to it ($NS) has only those paths.
+// Note that Unix commands may still reach other files through the
+// underlying OS, this restricts only the clive name space.
+//
+// If Sandbox is set, the process gets a new tmp directory, removed once
+// it terminates, and, where the OS supports it, no network.
+//
+// The policy is kept in the "clivepolicy" environment variable,
+// so it applies also to processes started by the process.
+struct Policy {
+	EnvAllow []string
+	EnvDeny  []string
+	Paths    []string
+	Sandbox  bool
+}
+
+const policyVar = "clivepolicy"
+
+// Return the policy in the format understood by ParsePolicy().
+func (p *Policy) String() string {
+	if p == nil {
+		return ""
+	}
+	var flds []string
+	if len(p.EnvAllow) > 0 {
+		flds = append(flds, "allow="+strings.Join(p.EnvAllow, ","))
+	}
+	if len(p.EnvDeny) > 0 {
+		flds = append(flds, "deny="+strings.Join(p.EnvDeny, ","))
+	}
+	if len(p.Paths) > 0 {
+		flds = append(flds, "paths="+strings.Join(p.Paths, ","))
+	}
+	if p.Sandbox {
+		flds = append(flds, "sandbox")
+	}
+	return strings.Join(flds, " ")
+}
+
+// Parse a policy as printed by Policy.String(), eg.
+//	allow=PATH,HOME deny=AWS_SECRET paths=/tmp,/zx/src sandbox
+// An empty string is a nil policy.
+func ParsePolicy(s string) (*Policy, error) {
+	toks := strings.Fields(s)
+	if len(toks) == 0 {
+		return nil, nil
+	}
+	p := &Policy{}
+	for _, t := range toks {
+		if t == "sandbox" {
+			p.Sandbox = true
+			continue
+		}
+		kv := strings.SplitN(t, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("policy: bad field '%s'", t)
+		}
+		vals := strings.Split(kv[1], ",")
+		switch kv[0] {
+		case "allow":
+			p.EnvAllow = append(p.EnvAllow, vals...)
+		case "deny":
+			p.EnvDeny = append(p.EnvDeny, vals...)
+		case "paths":
+			for _, v := range vals {
+				v, err := zx.UseAbsPath(v)
+				if err != nil {
+					return nil, fmt.Errorf("policy: %s", err)
+				}
+				p.Paths = append(p.Paths, v)
+			}
+		default:
+			return nil, fmt.Errorf("policy: unknown field '%s'", kv[0])
+		}
+	}
+	return p, nil
+}
+
+// Set the policy for processes started from the given context
+// (and their children).
+// A nil policy removes the restrictions, but note that processes started
+// from a restricted one remain restricted.
+func SetPolicy(c *cmd.Ctx, p *Policy) {
+	c.SetEnv(policyVar, p.String())
+}
+
+// Return the policy for processes started from the current context.
+func CurrentPolicy() (*Policy, error) {
+	return ParsePolicy(cmd.GetEnv(policyVar))
+}
+
+func (p *Policy) keepsVar(name string) bool {
+	switch {
+	case name == "dot", name == "NS", name == "traceparent", name == policyVar,
+		strings.HasPrefix(name, "cliveio#"), strings.HasPrefix(name, "clivebg"):
+		return true
+	}
+	for _, n := range p.EnvDeny {
+		if n == name {
+			return false
+		}
+	}
+	if len(p.EnvAllow) == 0 {
+		return true
+	}
+	for _, n := range p.EnvAllow {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the subset of the environment permitted by the policy.
+func (p *Policy) env(env []string) []string {
+	var nenv []string
+	for _, v := range env {
+		toks := strings.SplitN(v, "=", 2)
+		if p.keepsVar(toks[0]) {
+			nenv = append(nenv, v)
+		}
+	}
+	return nenv
+}
+
+// Return the name space permitted by the policy.
+func (p *Policy) ns(old *ns.NS) (*ns.NS, error) {
+	nns := ns.New()
+	ents := old.Entries()
+	for _, path := range p.Paths {
+		_, mnts, err := old.Resolve(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range mnts {
+			d["path"] = path
+			if err := nns.Mount(d, ns.After); err != nil {
+				return nil, err
+			}
+		}
+		for _, d := range ents {
+			if d["path"] != path && zx.HasPrefix(d["path"], path) && d["type"] != "p" {
+				if err := nns.Mount(d, ns.After); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nns, nil
+}
+
+// Adjust x to follow the policy.
+// The returned function must be called once the process is done.
+func (p *Policy) apply(x *exec.Cmd) (func(), error) {
+	done := func() {}
+	if x.Env == nil {
+		x.Env = os.Environ()
+	}
+	x.Env = p.env(x.Env)
+	if len(p.Paths) > 0 {
+		ok := false
+		dir := x.Dir
+		if dir == "" {
+			dir = cmd.Dot()
+		}
+		for _, path := range p.Paths {
+			ok = ok || zx.HasPrefix(dir, path)
+		}
+		if !ok {
+			return done, fmt.Errorf("%s: %s", dir, zx.ErrPerm)
+		}
+		nns, err := p.ns(cmd.NS())
+		if err != nil {
+			return done, err
+		}
+		x.Env = setVar(x.Env, "NS", nns.String())
+	}
+	if p.Sandbox {
+		tmp, err := ioutil.TempDir("", "clivesbx")
+		if err != nil {
+			return done, err
+		}
+		for _, v := range []string{"TMPDIR", "TMP", "TEMP"} {
+			x.Env = setVar(x.Env, v, tmp)
+		}
+		sandbox(x)
+		done = func() {
+			os.RemoveAll(tmp)
+		}
+	}
+	return done, nil
+}
+
+func setVar(env []string, name, val string) []string {
+	for i := range env {
+		if strings.HasPrefix(env[i], name+"=") {
+			env[i] = name + "=" + val
+			return env
+		}
+	}
+	return append(env, name+"="+val)
+}
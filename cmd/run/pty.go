@@ -0,0 +1,106 @@
+package run
+
+import (
+	"clive/cmd"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Run args as a unix command with a context adjusted by the caller,
+// using a new pseudo-terminal as its standard input, output, and error,
+// for programs that need a tty (ssh, interactive interpreters, ...).
+// The command runs in a new clive cmd context with:
+//	"in" set to a new Proc.In chan, written to the terminal
+//	"out" set to a new Proc.Out chan, read from the terminal
+//	"err" set to a new Proc.Err chan, closed with the exit status
+// Both input and output are raw bytes (echo and control
+// characters are handled by the terminal as usual).
+func PtyCmd(adjust func(*cmd.Ctx), args ...string) (*Proc, error) {
+	if len(args) == 0 || len(args[0]) == 0 {
+		return nil, errors.New("no command name")
+	}
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, fmt.Errorf("run %s: pty: %s", args[0], err)
+	}
+	in := make(chan face{})
+	out := make(chan face{})
+	ec := make(chan face{})
+	p := &Proc{
+		Args:  args,
+		In:    in,
+		Out:   out,
+		Err:   ec,
+		in:    in,
+		unix:  true,
+		donec: make(chan bool),
+	}
+	p.x = exec.Command(args[0], args[1:]...)
+	startc := make(chan bool)
+	p.ctx = cmd.New(func() {
+		p.x.Dir = cmd.Dot()
+		p.x.Env = append(cleanenv(cmd.OSEnv()), "TERM=dumb")
+		p.x.Stdin = slave
+		p.x.Stdout = slave
+		p.x.Stderr = slave
+		fail := func(err error) {
+			close(in, err)
+			master.Close()
+			slave.Close()
+			cmd.Exit(fmt.Errorf("run %s: %s", args[0], err))
+		}
+		pol, err := CurrentPolicy()
+		if err != nil {
+			fail(err)
+		}
+		poldone := func() {}
+		if pol != nil {
+			if poldone, err = pol.apply(p.x); err != nil {
+				fail(fmt.Errorf("policy: %s", err))
+			}
+		}
+		if p.x.SysProcAttr == nil {
+			p.x.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		p.x.SysProcAttr.Setsid = true
+		p.x.SysProcAttr.Setctty = true
+		p.x.SysProcAttr.Ctty = 0
+		if err := p.x.Start(); err != nil {
+			poldone()
+			fail(fmt.Errorf("start: %s", err))
+		}
+		p.started()
+		slave.Close()
+		go p.input(in, master)
+		go p.ptyOutput(master, out)
+		err = p.x.Wait()
+		poldone()
+		close(p.donec, err)
+	}, startc)
+	adjust(p.ctx)
+	close(startc)
+	return p, nil
+}
+
+// Read the terminal output; reads fail with EIO once the
+// command and its children have closed the terminal.
+func (p *Proc) ptyOutput(master *os.File, c chan<- face{}) {
+	buf := make([]byte, 8*1024)
+	for {
+		n, err := master.Read(buf)
+		if n > 0 {
+			dat := make([]byte, n)
+			copy(dat, buf[:n])
+			if ok := c <- dat; !ok {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(c)
+}
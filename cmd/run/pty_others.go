@@ -0,0 +1,13 @@
+// +build !linux
+
+package run
+
+import (
+	"errors"
+	"os"
+)
+
+// Pseudo-terminals are only supported on linux by now.
+func openPty() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("no pty support on this system")
+}
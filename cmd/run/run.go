@@ -4,6 +4,7 @@
 package run
 
 import (
+	"bytes"
 	"clive/ch"
 	"clive/cmd"
 	"errors"
@@ -116,6 +117,88 @@ func CtxCmd(adjust func(*cmd.Ctx), args ...string) (*Proc, error) {
 	return runCmd(adjust, false, nil, args...)
 }
 
+// Return a function running args as a clive command in the current
+// context, using its in, out, and err chans, to use it as a stage in
+// cmd.Pipe. It exits with the status of the command.
+func Stage(args ...string) func() {
+	return func() {
+		p, err := PipeToCtx(func(*cmd.Ctx) {}, args...)
+		if err != nil {
+			cmd.Exit(err)
+		}
+		go func() {
+			in := cmd.In("in")
+			for m := range in {
+				if ok := p.In <- m; !ok {
+					break
+				}
+			}
+			close(p.In, cerror(in))
+		}()
+		donec := make(chan bool)
+		go func() {
+			errc := cmd.Out("err")
+			for m := range p.Err {
+				errc <- m
+			}
+			close(donec)
+		}()
+		out := cmd.Out("out")
+		for m := range p.Out {
+			if ok := out <- m; !ok {
+				p.Kill()
+				break
+			}
+		}
+		<-donec
+		cmd.Exit(p.Wait())
+	}
+}
+
+// Run args as a Unix command, wait for it, and return its output,
+// its error output, and its exit status.
+// The command runs as in UnixCmd.
+func Run(args ...string) ([]byte, []byte, error) {
+	p, err := UnixCmd(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.collect()
+}
+
+// Run the given ql command line (eg. "lf,~*.go | gr foo") as in Run.
+// The command runs as in Cmd, with its env, ns, and dot forked.
+func RunStr(cmdline string) ([]byte, []byte, error) {
+	p, err := Cmd("ql", "-c", cmdline)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.collect()
+}
+
+// Wait for p and return its output and error output.
+// Messages other than []byte are ignored.
+func (p *Proc) collect() ([]byte, []byte, error) {
+	var out, errs bytes.Buffer
+	donec := make(chan bool)
+	go func() {
+		for m := range p.Err {
+			if b, ok := m.([]byte); ok {
+				errs.Write(b)
+			}
+		}
+		close(donec)
+	}()
+	for m := range p.Out {
+		if b, ok := m.([]byte); ok {
+			out.Write(b)
+		}
+	}
+	<-donec
+	err := p.Wait()
+	return out.Bytes(), errs.Bytes(), err
+}
+
 func (p *Proc) input(c <-chan face{}, w io.WriteCloser) {
 	if p.unix {
 		ch.WriteBytes(w, c)
@@ -125,6 +208,28 @@ func (p *Proc) input(c <-chan face{}, w io.WriteCloser) {
 	w.Close()
 }
 
+// Return the job number for the command (see cmd.Jobs).
+func (p *Proc) Job() int {
+	return p.ctx.JobId()
+}
+
+// Called in the context of p once its process has started.
+func (p *Proc) started() {
+	p.Id = p.x.Process.Pid
+	cmd.AppCtx().SetProc(p.x.Process)
+	select {
+	case sig := <-cmd.Sigs():
+		// signaled before it started
+		p.x.Process.Signal(sig)
+	default:
+	}
+}
+
+// Kill the command and those it started.
+func (p *Proc) Kill() error {
+	return p.ctx.Kill()
+}
+
 // Wait for the command to terminate and return its status.
 func (p *Proc) Wait() error {
 	<-p.donec
@@ -146,7 +251,7 @@ func (p *Proc) output(r io.Reader, c chan<- face{}, iserr bool) {
 func cleanenv(env []string) []string {
 	for i := 0; i < len(env); {
 		if strings.HasPrefix(env[i], "dot=") || strings.HasPrefix(env[i], "cliveio#") ||
-			strings.HasPrefix(env[i], "clivebg") {
+			strings.HasPrefix(env[i], "clivebg") || strings.HasPrefix(env[i], "traceparent=") {
 			copy(env[i:], env[i+1:])
 			env = env[:len(env)-1]
 		} else {
@@ -257,6 +362,9 @@ func runCmd(adjust func(*cmd.Ctx), unix bool, in <-chan face{}, args ...string)
 		if !unix {
 			ev := fmt.Sprintf("dot=%s", cmd.Dot())
 			p.x.Env = append(p.x.Env, ev)
+			if t := cmd.Trace(); !t.IsZero() {
+				p.x.Env = append(p.x.Env, "traceparent="+t.String())
+			}
 			i, o := cmd.Chans()
 			for _, cn := range i {
 				if cn == "in" || cn == "null" {
@@ -279,16 +387,33 @@ func runCmd(adjust func(*cmd.Ctx), unix bool, in <-chan face{}, args ...string)
 				}
 			}
 		}
+		pol, err := CurrentPolicy()
+		if err != nil {
+			close(in, err)
+			closeAll(closes)
+			cmd.Exit(fmt.Errorf("run %s: %s", args[0], err))
+		}
+		poldone := func() {}
+		if pol != nil {
+			if poldone, err = pol.apply(p.x); err != nil {
+				close(in, err)
+				closeAll(closes)
+				cmd.Exit(fmt.Errorf("run %s: policy: %s", args[0], err))
+			}
+		}
 		if err := p.x.Start(); err != nil {
 			close(in, err)
 			closeAll(closes)
+			poldone()
 			cmd.Exit(fmt.Errorf("run %s: start: %s", args[0], err))
 		}
-		p.Id = p.x.Process.Pid
+		p.started()
 		closeAll(iocloses)
 		go p.output(rfd, out, false)
 		go p.output(erfd, ec, true)
-		close(p.donec, p.x.Wait())
+		err = p.x.Wait()
+		poldone()
+		close(p.donec, err)
 	}, startc)
 	adjust(p.ctx)
 	close(startc)
@@ -273,3 +273,45 @@ func TestCliveInChan(t *testing.T) {
 		t.Fatalf("bad output")
 	}
 }
+
+func TestPolicy(t *testing.T) {
+	debug = testing.Verbose()
+
+	s := "allow=PATH,HOME deny=HOME paths=/tmp sandbox"
+	p, err := ParsePolicy(s)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	printf("policy %s\n", p)
+	if p.String() != s {
+		t.Fatalf("bad policy string")
+	}
+	env := p.env([]string{"PATH=/bin", "HOME=/x", "USER=nemo", "dot=/tmp"})
+	printf("env %v\n", env)
+	if strings.Join(env, " ") != "PATH=/bin dot=/tmp" {
+		t.Fatalf("bad env")
+	}
+	if _, err := ParsePolicy("paths=tmp"); err == nil {
+		t.Fatalf("relative path didn't fail")
+	}
+	if p, err := ParsePolicy(""); p != nil || err != nil {
+		t.Fatalf("empty policy is not nil")
+	}
+}
+
+func TestRun(t *testing.T) {
+	debug = testing.Verbose()
+
+	out, errs, err := Run("sh", "-c", "echo out; echo err >&2; exit 3")
+	printf("out %q err %q sts %v\n", out, errs, err)
+	if string(out) != "out\n" || string(errs) != "err\n" {
+		t.Fatalf("bad output")
+	}
+	if err == nil {
+		t.Fatalf("didn't fail")
+	}
+	out, _, err = Run("echo", "hi")
+	if err != nil || string(out) != "hi\n" {
+		t.Fatalf("bad run")
+	}
+}
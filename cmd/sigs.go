@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+/*
+	A command may handle OS signals by calling HandleSigs, which
+	delivers them as messages on an input chan ("sig" by default)
+	instead of taking their default action (usually exiting).
+	Each message is a string with the signal name, as given by
+	SigName (eg., "intr", "term", "hup", "winch"), so that
+	handlers may be written also by commands reading the chan
+	from another process, like ql does.
+
+	Signals are sent to all contexts handling them, and they are
+	no longer handled once all of them exit; messages are dropped
+	if the handler is not reading its chan.
+*/
+
+struct sigRoute {
+	c    *Ctx
+	sigs map[os.Signal]bool
+	sc   chan face{}
+}
+
+var (
+	// Signals handled by HandleSigs when none are given.
+	DefaultSigs = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+	siglk     sync.Mutex
+	sigroutes []*sigRoute
+	sigcs     = map[os.Signal]chan os.Signal{}
+
+	signames = map[os.Signal]string{
+		os.Interrupt:    "intr",
+		os.Kill:         "kill",
+		syscall.SIGTERM: "term",
+		syscall.SIGHUP:  "hup",
+	}
+)
+
+func init() {
+	if sigWinch != nil {
+		DefaultSigs = append(DefaultSigs, sigWinch)
+		signames[sigWinch] = "winch"
+	}
+}
+
+// Return the name used for a signal in the messages sent by HandleSigs.
+func SigName(sig os.Signal) string {
+	if n, ok := signames[sig]; ok {
+		return n
+	}
+	return sig.String()
+}
+
+// Send the signals received from sigc to their handlers.
+func sigLoop(sigc chan os.Signal) {
+	for sig := range sigc {
+		nm := SigName(sig)
+		siglk.Lock()
+		for _, r := range sigroutes {
+			if r.sigs[sig] {
+				select {
+				case r.sc <- nm:
+				default:
+					// handler is busy; drop it
+				}
+			}
+		}
+		siglk.Unlock()
+	}
+}
+
+// Deliver the given signals (DefaultSigs if none) to c as messages
+// in the input chan with the given name, which is returned.
+// It should be called by the command running in c.
+func (c *Ctx) HandleSigs(name string, sigs ...os.Signal) <-chan face{} {
+	if len(sigs) == 0 {
+		sigs = DefaultSigs
+	}
+	r := &sigRoute{c: c, sigs: map[os.Signal]bool{}, sc: make(chan face{}, 16)}
+	c.SetIn(name, r.sc)
+	siglk.Lock()
+	defer siglk.Unlock()
+	for _, sig := range sigs {
+		r.sigs[sig] = true
+		if sigcs[sig] == nil {
+			sigc := make(chan os.Signal, 16)
+			sigcs[sig] = sigc
+			signal.Notify(sigc, sig)
+			go sigLoop(sigc)
+		}
+	}
+	sigroutes = append(sigroutes, r)
+	return r.sc
+}
+
+// Deliver the given signals (DefaultSigs if none) to the current
+// command as messages in the "sig" input chan, which is returned.
+func HandleSigs(sigs ...os.Signal) <-chan face{} {
+	return ctx().HandleSigs("sig", sigs...)
+}
+
+// Stop handling signals for c; called when it exits.
+// Signals no longer handled get their default action back.
+func (c *Ctx) unhandleSigs() {
+	siglk.Lock()
+	defer siglk.Unlock()
+	rs := sigroutes[:0]
+	for _, r := range sigroutes {
+		if r.c != c {
+			rs = append(rs, r)
+		}
+	}
+	sigroutes = rs
+	for sig, sigc := range sigcs {
+		used := false
+		for _, r := range sigroutes {
+			used = used || r.sigs[sig]
+		}
+		if !used {
+			signal.Stop(sigc)
+			close(sigc)
+			delete(sigcs, sig)
+		}
+	}
+}
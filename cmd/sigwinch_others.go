@@ -0,0 +1,10 @@
+// +build !linux,!bsd,!darwin,!freebsd,!openbsd
+
+package cmd
+
+import (
+	"os"
+)
+
+// no window size changes here
+var sigWinch os.Signal
@@ -0,0 +1,10 @@
+// +build linux bsd darwin freebsd openbsd
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+var sigWinch os.Signal = syscall.SIGWINCH
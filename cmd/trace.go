@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"clive/dbg"
+	"clive/trace"
+	"os"
+)
+
+// Each context has a trace context (see clive/trace) used as the parent
+// for spans recorded on its behalf, and sent along with zx requests.
+// The main context continues the trace in $traceparent, if any,
+// and contexts made by New inherit the trace of their parent.
+// If $clivetrace is set, it describes the exporters for spans
+// (see trace.Setup), and a new trace is started unless there's
+// one in $traceparent.
+// Without either one, there's no trace and zx requests do not
+// carry one, so they can be sent to servers that don't know about
+// traces.
+
+func initTrace() {
+	t, err := trace.Parse(os.Getenv("traceparent"))
+	if err != nil && os.Getenv("clivetrace") != "" {
+		t = trace.New()
+	}
+	mainctx.trace = t
+	trace.Current = func() trace.Ctx {
+		if c := AppCtx(); c != nil {
+			return c.Trace()
+		}
+		return trace.Ctx{}
+	}
+	if spec := os.Getenv("clivetrace"); spec != "" {
+		name := "clive"
+		if len(mainctx.Args) > 0 {
+			name = mainctx.Args[0]
+		}
+		if err := trace.Setup(spec, name); err != nil {
+			dbg.Warn("%s", err)
+		}
+	}
+}
+
+// Return the trace context for c.
+func (c *Ctx) Trace() trace.Ctx {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.trace
+}
+
+// Set the trace context for c.
+func (c *Ctx) SetTrace(t trace.Ctx) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.trace = t
+}
+
+// Return the trace context for the current context.
+func Trace() trace.Ctx {
+	return ctx().Trace()
+}
+
+// Start a span for the current context, using its trace as the parent.
+// The context is not changed, and the span's Ctx is the one to use
+// as the parent for spans nested within it.
+// Requests made meanwhile are still children of the context trace,
+// because other spans may be in progress for the same context.
+func StartSpan(name string) *trace.Span {
+	return trace.Start(ctx().Trace(), name)
+}
@@ -0,0 +1,35 @@
+// +build linux bsd darwin freebsd openbsd
+
+package tty
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Enable or disable echo for the tty f.
+// Line editing (erase, kill) is still done by the tty.
+func SetEcho(f *os.File, on bool) error {
+	var termios syscall.Termios
+	_, _, err := syscall.Syscall6(syscall.SYS_IOCTL, f.Fd(),
+		uintptr(ioctlReadTermios),
+		uintptr(unsafe.Pointer(&termios)),
+		0, 0, 0)
+	if err != 0 {
+		return err
+	}
+	if on {
+		termios.Lflag |= syscall.ECHO
+	} else {
+		termios.Lflag &^= syscall.ECHO
+	}
+	_, _, err = syscall.Syscall6(syscall.SYS_IOCTL, f.Fd(),
+		uintptr(ioctlWriteTermios),
+		uintptr(unsafe.Pointer(&termios)),
+		0, 0, 0)
+	if err != 0 {
+		return err
+	}
+	return nil
+}
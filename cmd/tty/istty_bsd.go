@@ -8,7 +8,10 @@ import (
 	"unsafe"
 )
 
-const ioctlReadTermios = syscall.TIOCGETA
+const (
+	ioctlReadTermios  = syscall.TIOCGETA
+	ioctlWriteTermios = syscall.TIOCSETA
+)
 
 // Return true if f refers to a tty
 func IsTTY(f *os.File) bool {
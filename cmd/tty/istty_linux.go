@@ -8,7 +8,10 @@ import (
 	"unsafe"
 )
 
-const ioctlReadTermios = syscall.TCGETS
+const (
+	ioctlReadTermios  = syscall.TCGETS
+	ioctlWriteTermios = syscall.TCSETS
+)
 
 // Return true if f refers to a tty
 func IsTTY(f *os.File) bool {
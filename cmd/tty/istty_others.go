@@ -3,6 +3,7 @@
 package tty
 
 import (
+	"errors"
 	"os"
 )
 
@@ -10,3 +11,8 @@ import (
 func IsTTY(f *os.File) bool {
 	return false
 }
+
+// Enable or disable echo for the tty f.
+func SetEcho(f *os.File, on bool) error {
+	return errors.New("no tty echo control")
+}
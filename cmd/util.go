@@ -7,34 +7,126 @@ import (
 	"errors"
 	"fmt"
 	fpath "path"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
 func Stat(path string) (zx.Dir, error) {
 	upath := path
 	path = AbsPath(path)
-	rc := NS().Stat(path)
-	d := <-rc
+	defer mx.zxDone("stat", time.Now())
+	c := ctx()
+	var d zx.Dir
+	err := c.retrying(path, func() error {
+		rc := c.NS().Stat(path)
+		d = <-rc
+		return cerror(rc)
+	})
 	if d != nil {
 		d["Upath"] = upath
 		d["Rpath"] = "/"
 	}
-	return d, cerror(rc)
+	return d, err
+}
+
+// Stat the given paths and return their dir entries and errors,
+// in the same order of paths.
+// Paths in the same tree are stated in a single request if the tree
+// can do that (eg. rzx trees), and the others are stated concurrently.
+// If there's a retry policy (see SetRetry), those that fail are
+// stated again one by one.
+func Stats(paths ...string) ([]zx.Dir, []error) {
+	defer mx.zxDone("stats", time.Now())
+	c := ctx()
+	apaths := make([]string, len(paths))
+	for i, p := range paths {
+		apaths[i] = AbsPath(p)
+	}
+	ds := make([]zx.Dir, len(paths))
+	errs := make([]error, len(paths))
+	dc := c.NS().Stats(apaths...)
+	i := 0
+	for d := range dc {
+		if i < len(ds) {
+			ds[i] = d
+		}
+		i++
+	}
+	for i := range paths {
+		d := ds[i]
+		switch {
+		case d == nil:
+			errs[i] = cerror(dc)
+			if errs[i] == nil {
+				errs[i] = zx.ErrBug
+			}
+		case d["err"] != "":
+			errs[i] = errors.New(d["err"])
+			ds[i] = nil
+		default:
+			d["Upath"] = paths[i]
+			d["Rpath"] = "/"
+			continue
+		}
+		if c.Retry() != nil {
+			ds[i], errs[i] = Stat(paths[i])
+		}
+	}
+	return ds, errs
 }
 
+// If there's a retry policy (see SetRetry) and the get fails,
+// it's resumed after the data already sent.
 func Get(path string, off, count int64) <-chan []byte {
 	path = AbsPath(path)
-	return NS().Get(path, off, count)
+	c := ctx()
+	r := c.Retry()
+	if r == nil {
+		return c.NS().Get(path, off, count)
+	}
+	rc := make(chan []byte)
+	go func() {
+		t0 := time.Now()
+		for try := 1; ; try++ {
+			dc := c.NS().Get(path, off, count)
+			for b := range dc {
+				if ok := rc <- b; !ok {
+					close(dc, cerror(rc))
+					return
+				}
+				off += int64(len(b))
+				if count > 0 {
+					count -= int64(len(b))
+				}
+			}
+			err := cerror(dc)
+			if err == nil || count == 0 || !r.again(c.NS(), path, try, t0, err) {
+				close(rc, err)
+				return
+			}
+		}
+	}()
+	return rc
 }
 
 func GetAll(path string) ([]byte, error) {
 	path = AbsPath(path)
-	return zx.GetAll(NS(), path)
+	defer mx.zxDone("getall", time.Now())
+	c := ctx()
+	var data []byte
+	err := c.retrying(path, func() error {
+		var err error
+		data, err = zx.GetAll(c.NS(), path)
+		return err
+	})
+	return data, err
 }
 
 // Unlike zx.GetDir(), this updates the paths in dirs to reflect user paths,
 // like Dirs() and Files() do.
+// Entries are sorted using DirOrder().
 func GetDir(path string) ([]zx.Dir, error) {
 	apath := AbsPath(path)
 	ds, err := zx.GetDir(NS(), apath)
@@ -46,29 +138,168 @@ func GetDir(path string) ([]zx.Dir, error) {
 		d["Upath"] = fpath.Join(d["path"], d["name"])
 		d["path"] = fpath.Join(apath, d["name"])
 	}
+	zx.SortDirsBy(ds, DirOrder())
 	return ds, nil
 }
 
+// Return the order used to sort dir listings, as set by $dirsort
+// (eg. "natural" or "locale,natural", see zx.ParseCollation).
+func DirOrder() zx.Collation {
+	c, err := zx.ParseCollation(GetEnv("dirsort"))
+	if err != nil {
+		Dprintf("dirsort: %s\n", err)
+	}
+	return c
+}
+
+// If there's a retry policy (see SetRetry) and off is not < 0, the
+// data sent is kept to send it again if the put has to be retried,
+// as long as it's not more than MaxRetryPut bytes.
+// If ud creates the file and has no mode or gid, the defaults for the
+// context are used (see SetModes).
 func Put(path string, ud zx.Dir, off int64, dc <-chan []byte) <-chan zx.Dir {
 	upath := path
 	apath := AbsPath(path)
+	c := ctx()
+	r := c.Retry()
+	ud = c.withModes(ud)
 	rc := make(chan zx.Dir)
 	go func() {
-		pc := NS().Put(apath, ud, off, dc)
-		d := <-pc
+		defer mx.zxDone("put", time.Now())
+		var d zx.Dir
+		var err error
+		if r == nil || off < 0 {
+			pc := c.NS().Put(apath, ud, off, dc)
+			d = <-pc
+			err = cerror(pc)
+		} else {
+			d, err = retryPut(c, r, apath, ud, off, dc)
+		}
 		if d != nil {
 			d["Rpath"] = "/"
 			d["Upath"] = upath
 			rc <- d
 		}
-		close(rc, cerror(pc))
+		close(rc, err)
 	}()
 	return rc
 }
 
+// Max number of bytes kept by a put to send them again.
+// Puts sending more data are not retried.
+var MaxRetryPut int64 = 16 * 1024 * 1024
+
+func retryPut(c *Ctx, r *Retry, path string, ud zx.Dir, off int64, dc <-chan []byte) (zx.Dir, error) {
+	var sent [][]byte
+	var nsent int64
+	toobig := false
+	t0 := time.Now()
+	for try := 1; ; try++ {
+		pd, poff, skip := ud, off, int64(0)
+		if try > 1 {
+			pd, poff, skip = resumePut(c, path, ud, off, sent)
+		}
+		nc := make(chan []byte)
+		donec := make(chan bool)
+		go func() {
+			defer close(donec)
+			n := skip
+			for _, b := range sent {
+				if n >= int64(len(b)) {
+					n -= int64(len(b))
+					continue
+				}
+				if ok := nc <- b[n:]; !ok {
+					return
+				}
+				n = 0
+			}
+			for b := range dc {
+				if !toobig {
+					sent = append(sent, b)
+					nsent += int64(len(b))
+					if nsent > MaxRetryPut {
+						toobig, sent = true, nil
+					}
+				}
+				if ok := nc <- b; !ok {
+					return
+				}
+			}
+			close(nc, cerror(dc))
+		}()
+		pc := c.NS().Put(path, pd, poff, nc)
+		d := <-pc
+		err := cerror(pc)
+		close(nc, err)
+		<-donec
+		if err == nil || toobig || !r.again(c.NS(), path, try, t0, err) {
+			if err != nil {
+				close(dc, err)
+			}
+			return d, err
+		}
+	}
+}
+
+// When a put of the entire file is retried, the data already
+// in the file is checked using checksums (see zx.Summer)
+// and only the data after the last block verified is sent again.
+// Return the dir and offset for the put and the number of bytes
+// in sent to skip.
+func resumePut(c *Ctx, path string, ud zx.Dir, off int64, sent [][]byte) (zx.Dir, int64, int64) {
+	if off != 0 || ud["size"] != "" || len(sent) == 0 {
+		return ud, off, 0
+	}
+	var dsums []string
+	sc := zx.Sums(c.NS(), path, zx.SumBlkSz)
+	for s := range sc {
+		dsums = append(dsums, s)
+	}
+	if err := cerror(sc); err != nil {
+		Dprintf("put %s: sums: %s\n", path, err)
+		return ud, off, 0
+	}
+	n := zx.SumsOff(zx.DataSums(zx.SumBlkSz, sent...), dsums, zx.SumBlkSz)
+	if n == 0 {
+		return ud, off, 0
+	}
+	Dprintf("put %s: resuming at %d\n", path, n)
+	nd := ud.Dup()
+	if nd["type"] == "-" || nd["type"] == "F" {
+		nd["size"] = strconv.FormatInt(n, 10)
+	}
+	return nd, n, n
+}
+
+// Put all contents for a file, creating it.
+// If no mode is given, the default for the context is used (see SetModes).
 func PutAll(path string, data []byte, mode ...string) error {
-	path = AbsPath(path)
-	return zx.PutAll(NS(), path, data, mode...)
+	ud := zx.Dir{"type": "-"}
+	if len(mode) > 0 {
+		ud["mode"] = mode[0]
+	}
+	dc := make(chan []byte, 1)
+	dc <- data
+	close(dc)
+	rc := Put(path, ud, 0, dc)
+	<-rc
+	return cerror(rc)
+}
+
+// Create a directory, and its parents if all is set.
+// If no mode is given, the default for the context is used (see SetModes).
+func MkDir(path string, all bool, mode ...string) error {
+	ud := zx.Dir{"type": "d"}
+	if all {
+		ud["type"] = "D"
+	}
+	if len(mode) > 0 {
+		ud["mode"] = mode[0]
+	}
+	rc := Put(path, ud, 0, nil)
+	<-rc
+	return cerror(rc)
 }
 
 func Wstat(path string, ud zx.Dir) (zx.Dir, error) {
@@ -134,8 +365,10 @@ func CleanName(name string) (string, string) {
 // With the path (and Upath/Rpath) set to the name
 func Dirs(names ...string) chan face{} {
 	ns := NS()
+	r := ctx().Retry()
 	rc := make(chan face{})
 	go func() {
+		defer mx.zxDone("dirs", time.Now())
 		var err error
 		for _, name := range names {
 			name = strings.TrimSpace(name)
@@ -147,37 +380,46 @@ func Dirs(names ...string) chan face{} {
 			}
 			tok0, tok1 := CleanName(name)
 			name = AbsPath(tok0)
-			Dprintf("getdirs: find %s %s\n", name, tok1)
-			dc := ns.Find(name, tok1, "/", "/", 0)
-			for d := range dc {
-				if d == nil {
-					break
-				}
-				d["Upath"] = d["path"]
-				if tok0 != name && zx.HasPrefix(d["path"], name) {
-					u := fpath.Join(tok0, zx.Suffix(d["path"], name))
-					d["Upath"] = u
-				}
-				d["Rpath"] = zx.Suffix(d["path"], name)
-				if d["err"] != "" {
-					if d["err"] != "pruned" {
-						err = errors.New(d["err"])
-						rc <- err
+			t0 := time.Now()
+			for try := 1; ; try++ {
+				Dprintf("getdirs: find %s %s\n", name, tok1)
+				dc := ns.Find(name, tok1, "/", "/", 0)
+				sent := false
+				for d := range dc {
+					if d == nil {
+						break
+					}
+					d["Upath"] = d["path"]
+					if tok0 != name && zx.HasPrefix(d["path"], name) {
+						u := fpath.Join(tok0, zx.Suffix(d["path"], name))
+						d["Upath"] = u
+					}
+					d["Rpath"] = zx.Suffix(d["path"], name)
+					if d["err"] != "" {
+						if d["err"] != "pruned" {
+							err = errors.New(d["err"])
+							rc <- err
+						}
+						continue
+					}
+					sent = true
+					if ok := rc <- d; !ok {
+						close(dc, cerror(rc))
+						return
 					}
-					continue
-				}
-				if ok := rc <- d; !ok {
-					close(dc, cerror(rc))
-					return
 				}
-			}
-			if derr := cerror(dc); derr != nil {
-				err = derr
-				if ok := rc <- err; !ok {
-					return
+				if derr := cerror(dc); derr != nil {
+					if !sent && r.again(ns, name, try, t0, derr) {
+						continue
+					}
+					err = derr
+					if ok := rc <- err; !ok {
+						return
+					}
+				} else {
+					close(dc) // in case a null was sent but no error
 				}
-			} else {
-				close(dc) // in case a null was sent but no error
+				break
 			}
 		}
 		close(rc, err)
@@ -185,6 +427,40 @@ func Dirs(names ...string) chan face{} {
 	return rc
 }
 
+// Like Dirs(), but the entries must also satisfy pred (see clive/zx/pred)
+// and be at most depth levels below the names given, if depth >= 0.
+// Names without a predicate are walked as in "name,".
+// eg. to find the .go files under src modified this week:
+//	FindDirs("name~*.go&"+ModifiedSince(time.Now().AddDate(0, 0, -7)), -1, "src")
+func FindDirs(pred string, depth int, names ...string) chan face{} {
+	nnames := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 && name[0] == '|' {
+			nnames = append(nnames, name)
+			continue
+		}
+		var preds []string
+		if toks := strings.SplitN(name, ",", 2); len(toks) == 2 && toks[1] != "" {
+			preds = append(preds, "("+toks[1]+")")
+		}
+		if pred != "" {
+			preds = append(preds, "("+pred+")")
+		}
+		if depth >= 0 {
+			preds = append(preds, "depth<="+strconv.Itoa(depth))
+		}
+		tok0, _ := CleanName(name)
+		nnames = append(nnames, tok0+","+strings.Join(preds, "&"))
+	}
+	return Dirs(nnames...)
+}
+
+// Return a predicate for files modified at t or later, for FindDirs.
+func ModifiedSince(t time.Time) string {
+	return fmt.Sprintf("mtime>=%d", t.UnixNano())
+}
+
 // Like Dirs(), but return a single dir.
 func Dir(name string) (zx.Dir, error) {
 	tok0, tok1 := CleanName(name)
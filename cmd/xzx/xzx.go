@@ -14,13 +14,18 @@ import (
 	"clive/zx/rzx"
 	"clive/zx/zux"
 	"clive/zx/zxc"
+	"io"
+	"log/syslog"
+	"os"
 	fpath "path"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 var (
 	noauth, wsync bool
+	nozip         bool
 	Zdebug        bool
 	dprintf       = cmd.Dprintf
 	vprintf       = cmd.VWarn
@@ -44,7 +49,29 @@ func main() {
 	opts.NewFlag("v", "report users logged in/out (verbose)", &c.Verb)
 	opts.NewFlag("Z", "verbose debug", &Zdebug)
 	opts.NewFlag("n", "no auth", &noauth)
+	opts.NewFlag("c", "don't compress, eg. for local links", &nozip)
+	var acmes, quotas, audit, afilter, exports, maddr, rates string
+	opts.NewFlag("r", "rates: limits for users or addresses (eg. 'ops=100 bytes=1m; nemo ops=1000')", &rates)
+	opts.NewFlag("m", "addr: serve metrics and health over http at addr (eg. ':9100')", &maddr)
+	opts.NewFlag("e", "exports: subtrees for users or groups (eg. 'main!nemo!/usr/nemo main!sys!/')", &exports)
+	opts.NewFlag("L", "audit: log changes to syslog, to tree!path, or to a local file", &audit)
+	opts.NewFlag("F", "filter: predicate for changes audited (eg. 'op=remove|op=move')", &afilter)
+	opts.NewFlag("q", "quota: limits for each rw tree (eg. 'bytes=10g files=10000 ubytes=1g ufiles=1000')", &quotas)
+	opts.NewFlag("C", "acme: get certificates from an ACME CA (eg. 'domains=a.org dns=hook')", &acmes)
 	args := opts.Parse()
+	if acmes != "" {
+		a, err := auth.ParseACME(acmes)
+		if err == nil {
+			err = a.Enable()
+		}
+		if err != nil {
+			cmd.Fatal("acme: %s", err)
+		}
+	}
+	q, err := rzx.ParseQuota(quotas)
+	if err != nil {
+		cmd.Fatal("%s", err)
+	}
 	if len(args) == 0 {
 		cmd.Warn("missing arguments")
 		opts.Usage()
@@ -108,8 +135,10 @@ func main() {
 	if len(trs) == 0 {
 		cmd.Fatal("no trees to serve")
 	}
+	mainalias := false
 	if _, ok := trs["main"]; !ok {
 		trs["main"] = mainfs
+		mainalias = true
 	}
 	vprintf("serve %s...", addr)
 	srv, err := rzx.NewServer(addr, auth.TLSserver)
@@ -119,6 +148,9 @@ func main() {
 	if noauth {
 		srv.NoAuth()
 	}
+	if nozip {
+		srv.NoCompress()
+	}
 	if c.Debug {
 		srv.Debug = true
 	}
@@ -137,7 +169,69 @@ func main() {
 		if err := srv.Serve(nm, fs); err != nil {
 			cmd.Fatal("serve: %s: %s", nm, err)
 		}
+		for _, x := range strings.Fields(exports) {
+			xl := strings.SplitN(x, "!", 3)
+			if len(xl) != 3 {
+				cmd.Fatal("bad export '%s'", x)
+			}
+			if xl[0] != nm {
+				continue
+			}
+			if err := srv.Export(nm, xl[1], xl[2]); err != nil {
+				cmd.Fatal("export: %s", err)
+			}
+		}
+		if quotas != "" && !rotrs[nm] && (nm != "main" || !mainalias) {
+			if err := srv.SetQuota(nm, q); err != nil {
+				cmd.Fatal("serve: %s", err)
+			}
+		}
 	}
+	for _, rs := range strings.Split(rates, ";") {
+		if strings.TrimSpace(rs) == "" {
+			continue
+		}
+		who, r, err := rzx.ParseRate(rs)
+		if err != nil {
+			cmd.Fatal("%s", err)
+		}
+		srv.RateLimit(who, r)
+	}
+	if maddr != "" {
+		if err := srv.ServeMetrics(maddr); err != nil {
+			cmd.Fatal("metrics: %s", err)
+		}
+	}
+	if audit != "" {
+		var w io.Writer
+		if audit == "syslog" {
+			w, err = syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "xzx")
+		} else if al := strings.SplitN(audit, "!", 2); len(al) == 2 {
+			pfs, ok := trs[al[0]].(zx.Putter)
+			if !ok {
+				cmd.Fatal("audit: no rw tree '%s'", al[0])
+			}
+			w = rzx.AuditFile(pfs, al[1])
+		} else {
+			w, err = os.OpenFile(audit, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		}
+		if err == nil {
+			err = srv.Audit(w, afilter)
+		}
+		if err != nil {
+			cmd.Fatal("audit: %s", err)
+		}
+	}
+	// certificates are reloaded when their files change, or on a hup.
+	sc := cmd.HandleSigs(syscall.SIGHUP)
+	go func() {
+		for range sc {
+			vprintf("reloading certificates")
+			if err := auth.ReloadCerts(); err != nil {
+				cmd.Warn("reload: %s", err)
+			}
+		}
+	}()
 	if err := srv.Wait(); err != nil {
 		cmd.Fatal("srv: %s", err)
 	}
@@ -0,0 +1,94 @@
+/*
+	WebDAV server for zx trees.
+
+	Serve zx trees (local dirs, remote trees, or the name space)
+	by WebDAV through the ink HTTPS server, so they can be used from
+	Finder, Explorer, and other WebDAV clients.
+	Each tree is served at /name, where name is the last element in
+	its path or address, and the name space is served at /ns.
+*/
+package main
+
+import (
+	"clive/cmd"
+	"clive/cmd/opt"
+	"clive/net/auth"
+	"clive/net/ink"
+	"clive/zx"
+	"clive/zx/rzx"
+	"clive/zx/zux"
+	fpath "path"
+	"strings"
+	"syscall"
+)
+
+var (
+	port = "8181"
+
+	rflag, nsflag bool
+
+	opts = opt.New("{dir|addr}")
+)
+
+// Return the tree for a dir or address and the name to serve it as.
+func tree(arg string) (zx.Fs, string, error) {
+	if strings.ContainsRune(arg, '!') {
+		toks := strings.Split(arg, "!")
+		fs, err := rzx.Dial(strings.TrimPrefix(arg, "zx!"), auth.TLSclient)
+		return fs, toks[len(toks)-1], err
+	}
+	fs, err := zux.NewZX(arg)
+	return fs, fpath.Base(arg), err
+}
+
+func main() {
+	cmd.UnixIO()
+	opts.NewFlag("p", "port: HTTPS port (8181 by default)", &port)
+	c := cmd.AppCtx()
+	opts.NewFlag("D", "debug", &c.Debug)
+	opts.NewFlag("r", "read only", &rflag)
+	opts.NewFlag("N", "serve the name space at /ns", &nsflag)
+	args := opts.Parse()
+	if !nsflag && len(args) == 0 {
+		cmd.Warn("no trees to serve")
+		opts.Usage()
+	}
+	served := map[string]bool{}
+	serve := func(name string, fs zx.Fs) {
+		if served[name] {
+			cmd.Fatal("%s: already served", name)
+		}
+		served[name] = true
+		if rflag {
+			fs = zx.MakeRO(fs)
+		}
+		h := ink.ServeDAV("/"+name, fs)
+		h.Debug = c.Debug
+		cmd.Warn("serving %s at %s", fs, h.Prefix())
+	}
+	if nsflag {
+		serve("ns", cmd.NS())
+	}
+	for _, arg := range args {
+		fs, name, err := tree(arg)
+		if err != nil {
+			cmd.Fatal("%s: %s", arg, err)
+		}
+		if name == "" || name == "/" || name == "." {
+			cmd.Fatal("%s: no name to serve it as", arg)
+		}
+		serve(name, fs)
+	}
+	ink.UsePort(port)
+	sc := cmd.HandleSigs(syscall.SIGHUP)
+	go func() {
+		for range sc {
+			if err := auth.ReloadCerts(); err != nil {
+				cmd.Warn("reload: %s", err)
+			}
+		}
+	}()
+	if err := ink.Serve(); err != nil {
+		cmd.Fatal("serve: %s", err)
+	}
+}
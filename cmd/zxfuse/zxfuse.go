@@ -1,5 +1,8 @@
 /*
 	Mount a zx tree through the native OS FUSE driver.
+	With -N, the name space of the command is mounted instead,
+	including any remote trees mounted in it, so that other
+	programs may use it as a native file system.
 */
 package main
 
@@ -27,7 +30,10 @@ var (
 	verb, xdebug bool
 
 	nocache bool
+	nozip   bool
+	nsflag  bool
 	xaddr   string
+	jdir    string
 	opts    = opt.New("addr|dir [mntdir] &")
 )
 
@@ -41,6 +47,9 @@ func main() {
 	opts.NewFlag("v", "verbose cache", &verb)
 	opts.NewFlag("r", "read only", &rflag)
 	opts.NewFlag("n", "no caching", &nocache)
+	opts.NewFlag("j", "dir: journal changes not yet written back in dir", &jdir)
+	opts.NewFlag("c", "don't compress, eg. for local links", &nozip)
+	opts.NewFlag("N", "mount the name space (and use args as [mntdir])", &nsflag)
 	opts.NewFlag("x", "addr: re-export locally the mounted tree to this address", &xaddr)
 	args := opts.Parse()
 	fuse.Debug = func(m face{}) {
@@ -48,11 +57,17 @@ func main() {
 			cmd.Eprintf("fuse: %v\n", m)
 		}
 	}
-	switch len(args) {
-	case 2:
+	switch {
+	case nsflag && len(args) == 1:
+		mntdir = args[0]
+	case nsflag && len(args) == 0:
+	case nsflag:
+		cmd.Warn("wrong number of arguments")
+		opts.Usage()
+	case len(args) == 2:
 		addr = args[0]
 		mntdir = args[1]
-	case 1:
+	case len(args) == 1:
 		addr = args[0]
 	default:
 		cmd.Warn("wrong number of arguments")
@@ -61,10 +76,15 @@ func main() {
 	var rfs zx.Getter
 	var err error
 	method := "lfs"
-	if strings.ContainsRune(addr, '!') {
+	if nsflag {
+		// the ns dials its own trees; they are not cached here.
+		rfs, addr, method = cmd.NS(), "ns", "ns"
+		nocache = true
+	} else if strings.ContainsRune(addr, '!') {
 		if strings.HasPrefix(addr, "zx!") {
 			addr = addr[3:]
 		}
+		rzx.Compress = !nozip
 		rfs, err = rzx.Dial(addr, auth.TLSclient)
 		method = "rfs"
 	} else if rflag {
@@ -84,6 +104,11 @@ func main() {
 		if verb {
 			xfs.(*zxc.Fs).Flags.Set("verb", true)
 		}
+		if jdir != "" {
+			if err := xfs.(*zxc.Fs).Journal(jdir); err != nil {
+				cmd.Fatal("journal: %s", err)
+			}
+		}
 	}
 	if rflag {
 		xfs = zx.MakeRO(xfs)
@@ -94,6 +119,9 @@ func main() {
 			cmd.Fatal("serve: %s", err)
 		}
 		srv.Debug = xdebug
+		if nozip {
+			srv.NoCompress()
+		}
 		if err := srv.Serve("main", xfs); err != nil {
 			cmd.Warn("serve: %s: %s", xaddr, err)
 		}
@@ -107,7 +135,7 @@ func main() {
 	} else {
 		cmd.Warn("%s %s: unmounted: exiting", mntdir, addr)
 	}
-	if cfs := xfs.(io.Closer); cfs != nil {
+	if cfs, ok := xfs.(io.Closer); ok {
 		wc := make(chan bool)
 		go func() {
 			cmd.Warn("%s %s: closing", mntdir, addr)
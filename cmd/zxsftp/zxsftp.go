@@ -0,0 +1,84 @@
+/*
+	SFTP gateway for a zx tree.
+
+	Serve a zx tree (a local dir, a remote tree, or the name space)
+	to sftp clients, authenticating users with their clive secrets.
+*/
+package main
+
+import (
+	"clive/cmd"
+	"clive/cmd/opt"
+	"clive/net/auth"
+	"clive/x/code.google.com/p/go.crypto/ssh"
+	"clive/zx"
+	"clive/zx/rzx"
+	"clive/zx/sftp"
+	"clive/zx/zux"
+	"io/ioutil"
+	fpath "path"
+	"strings"
+)
+
+var (
+	addr    = "*!*!sftp"
+	keyfile = fpath.Join(auth.KeyDir(), "sftp_host_key")
+
+	noauth, rflag, nsflag bool
+
+	opts = opt.New("dir|addr")
+)
+
+func main() {
+	cmd.UnixIO()
+	opts.AddUsage("\tthe host key can be made with ssh-keygen -f file -N ''\n")
+	opts.NewFlag("a", "addr: service address (*!*!sftp by default)", &addr)
+	opts.NewFlag("k", "file: host key file (~/.ssh/sftp_host_key by default)", &keyfile)
+	c := cmd.AppCtx()
+	opts.NewFlag("D", "debug", &c.Debug)
+	opts.NewFlag("n", "no auth", &noauth)
+	opts.NewFlag("r", "read only", &rflag)
+	opts.NewFlag("N", "serve the name space (and use no args)", &nsflag)
+	args := opts.Parse()
+	if nsflag != (len(args) == 0) || len(args) > 1 {
+		cmd.Warn("wrong number of arguments")
+		opts.Usage()
+	}
+	dat, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		cmd.Fatal("host key: %s", err)
+	}
+	key, err := ssh.ParsePrivateKey(dat)
+	if err != nil {
+		cmd.Fatal("host key: %s: %s", keyfile, err)
+	}
+	var fs zx.Fs
+	switch {
+	case nsflag:
+		fs = cmd.NS()
+	case strings.ContainsRune(args[0], '!'):
+		fs, err = rzx.Dial(strings.TrimPrefix(args[0], "zx!"), auth.TLSclient)
+	default:
+		fs, err = zux.NewZX(args[0])
+	}
+	if err != nil {
+		cmd.Fatal("%s: %s", args[0], err)
+	}
+	if rflag {
+		fs = zx.MakeRO(fs)
+	}
+	srv, err := sftp.NewServer(addr, key)
+	if err != nil {
+		cmd.Fatal("serve: %s", err)
+	}
+	srv.Debug = c.Debug
+	if noauth {
+		srv.NoAuth()
+	}
+	if err := srv.Serve(fs); err != nil {
+		cmd.Fatal("serve: %s", err)
+	}
+	if err := srv.Wait(); err != nil {
+		cmd.Fatal("srv: %s", err)
+	}
+}
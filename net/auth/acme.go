@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"clive/dbg"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+/*
+	ACME settings, to get server certificates from an ACME CA
+	(Let's Encrypt by default) instead of using the server key files.
+
+	With the HTTP-01 challenge, an HTTP server is started at Http
+	(":80" by default) to answer the CA.
+	With the DNS-01 challenge, the Hook command is run as
+		hook set _acme-challenge.domain value
+		hook clear _acme-challenge.domain value
+	to add and remove the TXT records for the CA. In this case the
+	certificate is kept at Dir/domain.pem and Dir/domain.key and
+	reloaded when it changes, like the usual server key files.
+
+	In both cases certificates are renewed without restarting servers.
+*/
+struct ACME {
+	Domains []string
+	Email   string
+	URL     string // CA directory URL
+	Dir     string // where to keep keys and certificates
+	Http    string // address for HTTP-01
+	Hook    string // command for DNS-01; HTTP-01 if not set
+}
+
+// Renew ACME certificates when they expire within this time.
+var ACMERenew = 30 * 24 * time.Hour
+
+/*
+	Parse an ACME spec of the form
+		domains=a.org,b.org email=me@a.org url=... dir=... http=:80 dns=hook
+	Only domains is mandatory.
+*/
+func ParseACME(s string) (*ACME, error) {
+	a := &ACME{
+		URL:  acme.LetsEncryptURL,
+		Dir:  path.Join(KeyDir(), "acme"),
+		Http: ":80",
+	}
+	for _, f := range strings.Fields(s) {
+		toks := strings.SplitN(f, "=", 2)
+		if len(toks) != 2 || toks[1] == "" {
+			return nil, fmt.Errorf("acme: bad field '%s'", f)
+		}
+		switch toks[0] {
+		case "domains":
+			a.Domains = strings.Split(toks[1], ",")
+		case "email":
+			a.Email = toks[1]
+		case "url":
+			a.URL = toks[1]
+		case "dir":
+			a.Dir = toks[1]
+		case "http":
+			a.Http = toks[1]
+		case "dns":
+			a.Hook = toks[1]
+		default:
+			return nil, fmt.Errorf("acme: unknown field '%s'", toks[0])
+		}
+	}
+	if len(a.Domains) == 0 {
+		return nil, errors.New("acme: no domains")
+	}
+	return a, nil
+}
+
+func (a *ACME) String() string {
+	s := fmt.Sprintf("domains=%s", strings.Join(a.Domains, ","))
+	if a.Email != "" {
+		s += " email=" + a.Email
+	}
+	if a.URL != acme.LetsEncryptURL {
+		s += " url=" + a.URL
+	}
+	if a.Hook != "" {
+		s += " dns=" + a.Hook
+	} else {
+		s += " http=" + a.Http
+	}
+	return s
+}
+
+/*
+	Get server certificates from the ACME CA and make TLSserver
+	use them, renewing them as needed.
+	Must be called before starting servers.
+*/
+func (a *ACME) Enable() error {
+	if err := os.MkdirAll(a.Dir, 0700); err != nil {
+		return err
+	}
+	var cfg *tls.Config
+	var err error
+	if a.Hook != "" {
+		cfg, err = a.dnsCfg()
+	} else {
+		cfg, err = a.httpCfg()
+	}
+	if err != nil {
+		return err
+	}
+	TLSserver, xTLSserver = cfg, cfg
+	return nil
+}
+
+func (a *ACME) httpCfg() (*tls.Config, error) {
+	m := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		HostPolicy:  autocert.HostWhitelist(a.Domains...),
+		Cache:       autocert.DirCache(a.Dir),
+		Email:       a.Email,
+		RenewBefore: ACMERenew,
+		Client:      &acme.Client{DirectoryURL: a.URL},
+	}
+	go func() {
+		err := http.ListenAndServe(a.Http, m.HTTPHandler(nil))
+		dbg.Warn("auth: acme http: %s", err)
+	}()
+	return &tls.Config{
+		GetCertificate:     m.GetCertificate,
+		InsecureSkipVerify: true,
+		Rand:               crand.Reader,
+	}, nil
+}
+
+func (a *ACME) dnsCfg() (*tls.Config, error) {
+	name := path.Join(a.Dir, a.Domains[0])
+	pemf, keyf := name+".pem", name+".key"
+	if a.mustRenew(pemf) {
+		if err := a.getCert(pemf, keyf); err != nil {
+			return nil, err
+		}
+	}
+	cf, err := NewCertFile(pemf, keyf)
+	if err != nil {
+		return nil, err
+	}
+	go a.renewer(pemf, keyf)
+	return &tls.Config{
+		GetCertificate:     cf.GetCertificate,
+		InsecureSkipVerify: true,
+		Rand:               crand.Reader,
+	}, nil
+}
+
+func (a *ACME) mustRenew(fn string) bool {
+	dat, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return true
+	}
+	b, _ := pem.Decode(dat)
+	if b == nil {
+		return true
+	}
+	c, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Now().Add(ACMERenew).After(c.NotAfter)
+}
+
+// Renew the certificate when it's about to expire.
+// The CertFile used by the TLS config picks up the new one.
+func (a *ACME) renewer(pemf, keyf string) {
+	for {
+		time.Sleep(12 * time.Hour)
+		if !a.mustRenew(pemf) {
+			continue
+		}
+		if err := a.getCert(pemf, keyf); err != nil {
+			dbg.Warn("auth: acme renew: %s", err)
+		}
+	}
+}
+
+func (a *ACME) hook(op, domain, val string) error {
+	out, err := exec.Command(a.Hook, op, "_acme-challenge."+domain, val).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("acme: %s %s: %s: %s", a.Hook, op, err, out)
+	}
+	return nil
+}
+
+func loadOrMakeKey(fn string) (*ecdsa.PrivateKey, error) {
+	if dat, err := ioutil.ReadFile(fn); err == nil {
+		b, _ := pem.Decode(dat)
+		if b == nil {
+			return nil, fmt.Errorf("%s: no pem data", fn)
+		}
+		return x509.ParseECPrivateKey(b.Bytes)
+	}
+	k, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return k, ioutil.WriteFile(fn, b, 0600)
+}
+
+// Get a certificate using DNS-01 and write it to the pem and key files.
+func (a *ACME) getCert(pemf, keyf string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	akey, err := loadOrMakeKey(path.Join(a.Dir, "account.key"))
+	if err != nil {
+		return err
+	}
+	cli := &acme.Client{Key: akey, DirectoryURL: a.URL}
+	acct := &acme.Account{}
+	if a.Email != "" {
+		acct.Contact = []string{"mailto:" + a.Email}
+	}
+	_, err = cli.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return err
+	}
+	o, err := cli.AuthorizeOrder(ctx, acme.DomainIDs(a.Domains...))
+	if err != nil {
+		return err
+	}
+	for _, u := range o.AuthzURLs {
+		if err := a.authorize(ctx, cli, u); err != nil {
+			return err
+		}
+	}
+	o, err = cli.WaitOrder(ctx, o.URI)
+	if err != nil {
+		return err
+	}
+	ckey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return err
+	}
+	req := &x509.CertificateRequest{DNSNames: a.Domains}
+	csr, err := x509.CreateCertificateRequest(crand.Reader, req, ckey)
+	if err != nil {
+		return err
+	}
+	ders, _, err := cli.CreateOrderCert(ctx, o.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+	var certs []byte
+	for _, der := range ders {
+		certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	kder, err := x509.MarshalECPrivateKey(ckey)
+	if err != nil {
+		return err
+	}
+	kpem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	// If the CertFile looks while we write, it fails to load
+	// a mismatched pair and keeps the old cert until next time.
+	if err := ioutil.WriteFile(keyf, kpem, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pemf, certs, 0644)
+}
+
+func (a *ACME) authorize(ctx context.Context, cli *acme.Client, u string) error {
+	z, err := cli.GetAuthorization(ctx, u)
+	if err != nil {
+		return err
+	}
+	if z.Status != acme.StatusPending {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: %s: no dns-01 challenge", z.Identifier.Value)
+	}
+	val, err := cli.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	domain := z.Identifier.Value
+	if err := a.hook("set", domain, val); err != nil {
+		return err
+	}
+	defer a.hook("clear", domain, val)
+	if _, err := cli.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = cli.WaitAuthorization(ctx, z.URI)
+	return err
+}
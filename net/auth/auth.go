@@ -28,6 +28,7 @@ import (
 	"crypto/cipher"
 	crand "crypto/rand"
 	"crypto/sha1"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/hex"
@@ -110,8 +111,9 @@ var (
 	TLSclient, TLSserver   *tls.Config
 	xTLSclient, xTLSserver *tls.Config
 
-	// Paths to pem and key files used by servers.
-	ServerPem, ServerKey string
+	// Paths to pem and key files used by servers, and to the
+	// CA bundle sent along with the certificate, if there's one.
+	ServerPem, ServerKey, ServerCA string
 
 	// Enable authentication. TLS can still be enabled with auth disabled.
 	Enabled = true
@@ -407,6 +409,48 @@ func ChallengeResponseOk(name, ch, resp string) (user string, ok bool) {
 	return usr, fmt.Sprintf("%x", chresp) == resp
 }
 
+/*
+	Check that secret is the one for user in the named auth domain
+	(as saved by SaveKey) and return the auth info for the user.
+	This is for servers whose clients can't speak the clive protocol and
+	send a user name and secret instead (eg. sftp), and it should be used only
+	over secured connections.
+	Returns ErrDisabled when auth is not enabled.
+*/
+func SecretOk(name, user, secret string, proto ...string) (*Info, error) {
+	if !Enabled {
+		return nil, ErrDisabled
+	}
+	ks := keys
+	if name != "" && name != "default" {
+		var err error
+		if ks, err = LoadKey(KeyDir(), name); err != nil {
+			return nil, err
+		}
+	}
+	key := pbkdf2.Key([]byte(secret), []byte("ltsa"), 1000, 32, sha1.New)
+	for _, k := range ks {
+		if k.Uid != user || subtle.ConstantTimeCompare(k.Key, key) != 1 {
+			continue
+		}
+		info := &Info{
+			Uid:       user,
+			SpeaksFor: user,
+			Proto:     make(map[string]bool),
+			Gids:      make(map[string]bool),
+			Ok:        true,
+		}
+		for _, g := range k.Gids {
+			info.Gids[g] = true
+		}
+		for _, p := range proto {
+			info.Proto[p] = true
+		}
+		return info, nil
+	}
+	return nil, ErrFailed
+}
+
 /*
 	Run by a client to authenticate a connection to a server (as provided by clive/nchan).
 
@@ -738,11 +782,14 @@ func init() {
 	}
 	ServerPem = srv + ".pem"
 	ServerKey = srv + ".key"
-	TLSserver, err = TLScfg(srv+".pem", srv+".key")
+	var cas []string
+	if _, err := os.Stat(srv + ".ca.pem"); err == nil {
+		ServerCA = srv + ".ca.pem"
+		cas = append(cas, ServerCA)
+	}
+	TLSserver, err = ReloadingTLScfg(ServerPem, ServerKey, cas...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ds/auth: %s: %s\n", srv, err)
-	} else {
-		TLSserver.Rand = crand.Reader
 	}
 	xTLSclient = TLSclient
 	xTLSserver = TLSserver
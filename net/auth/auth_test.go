@@ -4,8 +4,18 @@ import (
 	"clive/ch"
 	"clive/dbg"
 	"clive/net"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
 	"testing"
+	"time"
 )
 
 var debug = testing.Verbose()
@@ -204,3 +214,142 @@ func TestMuxDisabledAuth(t *testing.T) {
 	close(ec)
 	<-donec
 }
+
+func writeCert(t *testing.T, name, cn string) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	kpem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	if err := ioutil.WriteFile(name+".pem", cpem, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name+".key", kpem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certName(t *testing.T, cf *CertFile) string {
+	c, err := cf.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xc, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xc.Subject.CommonName
+}
+
+func TestCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := dir + "/srv"
+	writeCert(t, name, "one")
+	old := CertPoll
+	CertPoll = 0
+	defer func() { CertPoll = old }()
+	cf, err := NewCertFile(name+".pem", name+".key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := certName(t, cf); n != "one" {
+		t.Fatalf("cert is %s", n)
+	}
+	writeCert(t, name, "two")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(name+".pem", later, later)
+	os.Chtimes(name+".key", later, later)
+	if n := certName(t, cf); n != "two" {
+		t.Fatalf("cert not reloaded: %s", n)
+	}
+	// a bad pair keeps the old one.
+	if err := ioutil.WriteFile(name+".key", []byte("bad"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(name+".key", later, later)
+	if err := cf.Reload(); err == nil {
+		t.Fatal("bad key file didn't fail")
+	}
+	if n := certName(t, cf); n != "two" {
+		t.Fatalf("bad reload changed cert: %s", n)
+	}
+}
+
+func TestCertForcedReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := dir + "/srv"
+	writeCert(t, name, "one")
+	writeCert(t, dir+"/ca", "ca")
+	cf, err := NewCertFile(name+".pem", name+".key", dir+"/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := cf.GetCertificate(nil)
+	if len(c.Certificate) != 2 {
+		t.Fatalf("chain has %d certs", len(c.Certificate))
+	}
+	// files changed but keeping their times are not noticed...
+	then := time.Now().Add(-time.Hour)
+	os.Chtimes(name+".pem", then, then)
+	os.Chtimes(name+".key", then, then)
+	cf.Reload()
+	writeCert(t, name, "two")
+	os.Chtimes(name+".pem", then, then)
+	os.Chtimes(name+".key", then, then)
+	if err := cf.Reload(); err != nil || certName(t, cf) != "one" {
+		t.Fatalf("reloaded: %v", err)
+	}
+	// ...unless reloads are forced.
+	// (files for certs made by other tests may be gone by now)
+	ReloadCerts()
+	if n := certName(t, cf); n != "two" {
+		t.Fatalf("cert not reloaded: %s", n)
+	}
+	if c, _ := cf.GetCertificate(nil); len(c.Certificate) != 2 {
+		t.Fatalf("chain has %d certs", len(c.Certificate))
+	}
+}
+
+func TestParseACME(t *testing.T) {
+	a, err := ParseACME("domains=a.org,b.org email=me@a.org dns=/bin/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	printf("acme %s\n", a)
+	if len(a.Domains) != 2 || a.Email != "me@a.org" || a.Hook != "/bin/hook" {
+		t.Fatalf("bad acme %#v", a)
+	}
+	if s := a.String(); s != "domains=a.org,b.org email=me@a.org dns=/bin/hook" {
+		t.Fatalf("bad acme string %s", s)
+	}
+	if _, err := ParseACME("email=me@a.org"); err == nil {
+		t.Fatal("no domains didn't fail")
+	}
+	if _, err := ParseACME("domains=a.org bad=x"); err == nil {
+		t.Fatal("bad field didn't fail")
+	}
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificate files are checked for changes at most once per CertPoll.
+var CertPoll = 10 * time.Second
+
+// A certificate kept in pem and key files, reloaded when the files change.
+// Use its GetCertificate method in a tls.Config, so servers pick up
+// renewed certificates without being restarted.
+// If CA is set, it's a bundle with the intermediate CA certificates
+// sent along with the certificate, reloaded as well.
+struct CertFile {
+	sync.Mutex
+	Pem, Key, CA  string
+	cert          *tls.Certificate
+	pmt, kmt, cmt time.Time
+	checked       time.Time
+}
+
+var (
+	certslk sync.Mutex
+	certs   []*CertFile // to reload them all
+)
+
+func mtime(fn string) time.Time {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// Load the certificate in the given pem and key files, and
+// the CA bundle, if given.
+func NewCertFile(pem, key string, ca ...string) (*CertFile, error) {
+	cf := &CertFile{Pem: pem, Key: key}
+	if len(ca) > 0 {
+		cf.CA = ca[0]
+	}
+	if err := cf.load(); err != nil {
+		return nil, err
+	}
+	certslk.Lock()
+	certs = append(certs, cf)
+	certslk.Unlock()
+	return cf, nil
+}
+
+// Return the DER certificates in the pem file fn.
+func loadCAs(fn string) ([][]byte, error) {
+	dat, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var ders [][]byte
+	for {
+		var b *pem.Block
+		b, dat = pem.Decode(dat)
+		if b == nil {
+			break
+		}
+		if b.Type == "CERTIFICATE" {
+			ders = append(ders, b.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		return nil, errors.New(fn + ": no certificates")
+	}
+	return ders, nil
+}
+
+func (cf *CertFile) load() error {
+	pmt, kmt, cmt := mtime(cf.Pem), mtime(cf.Key), time.Time{}
+	cert, err := tls.LoadX509KeyPair(cf.Pem, cf.Key)
+	if err != nil {
+		return err
+	}
+	if cf.CA != "" {
+		cmt = mtime(cf.CA)
+		cas, err := loadCAs(cf.CA)
+		if err != nil {
+			return err
+		}
+		cert.Certificate = append(cert.Certificate, cas...)
+	}
+	cf.cert = &cert
+	cf.pmt, cf.kmt, cf.cmt = pmt, kmt, cmt
+	cf.checked = time.Now()
+	return nil
+}
+
+// Reload the certificate if its files changed.
+// If the new files can't be loaded (eg., they are being written),
+// the old certificate is kept and the error returned.
+func (cf *CertFile) Reload() error {
+	cf.Lock()
+	defer cf.Unlock()
+	return cf.reload()
+}
+
+func (cf *CertFile) reload() error {
+	cf.checked = time.Now()
+	if mtime(cf.Pem).Equal(cf.pmt) && mtime(cf.Key).Equal(cf.kmt) &&
+		(cf.CA == "" || mtime(cf.CA).Equal(cf.cmt)) {
+		return nil
+	}
+	if err := cf.load(); err != nil {
+		dprintf("auth: reload %s: %s\n", cf.Pem, err)
+		return err
+	}
+	dprintf("auth: %s reloaded\n", cf.Pem)
+	return nil
+}
+
+/*
+	Reload all the certificates in use, even if their files seem
+	to be unchanged, eg. when the server gets a SIGHUP.
+	Those that can't be loaded are kept as they were, and the
+	first error is returned.
+	Connections already made are not affected.
+*/
+func ReloadCerts() error {
+	certslk.Lock()
+	cfs := append([]*CertFile{}, certs...)
+	certslk.Unlock()
+	var err error
+	for _, cf := range cfs {
+		cf.Lock()
+		lerr := cf.load()
+		cf.Unlock()
+		if lerr != nil {
+			dprintf("auth: reload %s: %s\n", cf.Pem, lerr)
+			if err == nil {
+				err = lerr
+			}
+		}
+	}
+	return err
+}
+
+// Return the current certificate, for use in tls.Config.
+func (cf *CertFile) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cf.Lock()
+	defer cf.Unlock()
+	if time.Since(cf.checked) >= CertPoll {
+		cf.reload()
+	}
+	return cf.cert, nil
+}
+
+/*
+	Build a TLS config for servers using the given pem and key files,
+	and the CA bundle file, if given.
+	Unlike TLScfg, the files are reloaded when they change.
+*/
+func ReloadingTLScfg(pem, key string, ca ...string) (*tls.Config, error) {
+	cf, err := NewCertFile(pem, key, ca...)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate:     cf.GetCertificate,
+		InsecureSkipVerify: true,
+		Rand:               crand.Reader,
+	}, nil
+}
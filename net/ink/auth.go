@@ -3,8 +3,10 @@ package ink
 import (
 	"clive/cmd"
 	"clive/net/auth"
+	"clive/u"
 	"fmt"
 	"golang.org/x/net/websocket"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -38,6 +40,29 @@ func checkOrigin(config *websocket.Config, req *http.Request) (err error) {
 	return err
 }
 
+// Return who is behind a request, as user@host.
+// The user is the one authenticated by the clive cookie, or the local
+// user when auth is disabled.
+func reqUser(r *http.Request) string {
+	usr := u.Uid
+	if clive, err := r.Cookie("clive"); err == nil {
+		toks := strings.SplitN(string(clive.Value), ":", 2)
+		if len(toks) == 2 {
+			if cu, ok := auth.ChallengeResponseOk("wax", toks[0], toks[1]); ok {
+				usr = cu
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" {
+		return usr
+	}
+	return usr + "@" + host
+}
+
 // Authenticate a websocket before servicing it.
 func AuthWebSocketHandler(h websocket.Handler) http.HandlerFunc {
 	hndler := func(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +127,37 @@ func AuthHandler(fn http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Authenticate before serving a request for h, as AuthHandler does,
+// but also accepting HTTP basic auth with the clive secret for the user,
+// because WebDAV clients and other programs can't use the login page.
+func BasicAuthHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.TLSserver == nil || !auth.Enabled {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if clive, err := r.Cookie("clive"); err == nil {
+			toks := strings.SplitN(string(clive.Value), ":", 2)
+			if len(toks) == 2 {
+				if _, ok := auth.ChallengeResponseOk("wax", toks[0], toks[1]); ok {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		if usr, secret, ok := r.BasicAuth(); ok {
+			_, err := auth.SecretOk("", usr, secret, "dav")
+			if err == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cmd.Warn("wax/auth: failed for %s: %s", usr, err)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="clive"`)
+		http.Error(w, "auth failed", http.StatusUnauthorized)
+	})
+}
+
 // Serve the /login and /logout pages, proceeding to the indicated page
 // after each login.
 func serveLoginFor(proceedto string) {
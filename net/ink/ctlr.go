@@ -29,6 +29,7 @@ struct Ev {
 
 struct view {
 	Id  string   // set by the eid event
+	Who string   // user@host looking at this view
 	out chan *Ev // events from/to this view
 }
 
@@ -204,6 +205,18 @@ func (c *Ctlr) Views() []string {
 	return vs
 }
 
+// Return who is looking at the view with the given id, as user@host.
+func (c *Ctlr) Viewer(id string) string {
+	c.Lock()
+	defer c.Unlock()
+	for v := range c.views {
+		if v.Id == id {
+			return v.Who
+		}
+	}
+	return ""
+}
+
 func (c *Ctlr) viewOut(id string) chan<- *Ev {
 	c.Lock()
 	defer c.Unlock()
@@ -260,10 +273,11 @@ func (c *Ctlr) reflector() {
 	c.Unlock()
 }
 
-func (c *Ctlr) newView() *view {
+func (c *Ctlr) newView(who string) *view {
 	c.Lock()
 	defer c.Unlock()
 	v := &view{
+		Who: who,
 		out: make(chan *Ev),
 	}
 	c.views[v] = true
@@ -279,7 +293,7 @@ func (c *Ctlr) delView(v *view) {
 
 func (c *Ctlr) server(ws *websocket.Conn) {
 	dprintf("%s: ws started\n", c.Id)
-	v := c.newView()
+	v := c.newView(reqUser(ws.Request()))
 	defer func() {
 		dprintf("%s: ws reader done\n", c.Id)
 		ws.Close()
@@ -322,7 +336,7 @@ func (c *Ctlr) server(ws *websocket.Conn) {
 		dprintf("%s: ev %v\n", c.Id, ev)
 		if len(ev.Args) == 1 && ev.Args[0] == "id" && v.Id == "" {
 			v.Id = ev.Src
-			c.in <- &Ev{Id: c.Id, Src: v.Id, Args: []string{"start"}}
+			c.in <- &Ev{Id: c.Id, Src: v.Id, Args: []string{"start", v.Who}}
 			continue
 		}
 		if ok := c.in <- ev; !ok {
@@ -158,313 +158,308 @@ var Files = map[string][]byte{
 		101, 116, 117, 114, 110, 59, 10, 9, 125, 10, 9, 36, 40, 99, 41, 46,
 		102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41,
 		46, 102, 105, 114, 115, 116, 40, 41, 46, 98, 101, 102, 111, 114, 101, 40,
-		112, 41, 59, 10, 125, 10, 10, 10, 36, 40, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 41, 123, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46,
-		115, 101, 116, 100, 105, 114, 116, 121, 32, 61, 32, 115, 101, 116, 100, 105,
-		114, 116, 121, 59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 115,
-		101, 116, 102, 111, 99, 117, 115, 32, 61, 32, 115, 101, 116, 102, 111, 99,
-		117, 115, 59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 115, 101,
-		116, 99, 108, 101, 97, 110, 32, 61, 32, 115, 101, 116, 99, 108, 101, 97,
-		110, 59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 115, 101, 116,
-		116, 97, 103, 32, 61, 32, 115, 101, 116, 116, 97, 103, 59, 10, 9, 100,
-		111, 99, 117, 109, 101, 110, 116, 46, 115, 104, 111, 119, 99, 111, 110, 116,
-		114, 111, 108, 32, 61, 32, 115, 104, 111, 119, 99, 111, 110, 116, 114, 111,
-		108, 59, 10, 125, 41, 59, 10, 10, 47, 47, 32, 101, 108, 32, 105, 115,
-		32, 97, 32, 112, 111, 114, 116, 108, 101, 116, 10, 47, 47, 32, 114, 101,
-		109, 111, 118, 101, 40, 41, 32, 105, 115, 32, 110, 111, 116, 32, 101, 110,
-		111, 117, 103, 104, 44, 32, 119, 101, 32, 109, 117, 115, 116, 32, 99, 108,
-		111, 115, 101, 32, 116, 104, 101, 32, 119, 115, 40, 115, 41, 10, 102, 117,
-		110, 99, 116, 105, 111, 110, 32, 114, 101, 109, 111, 118, 101, 99, 111, 110,
-		116, 114, 111, 108, 40, 101, 108, 44, 32, 110, 101, 101, 100, 112, 111, 115,
-		116, 41, 32, 123, 10, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103,
-		41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 114, 101,
-		109, 111, 118, 101, 99, 111, 110, 116, 114, 111, 108, 58, 32, 34, 44, 32,
-		101, 108, 41, 59, 10, 9, 105, 102, 40, 33, 101, 108, 41, 32, 123, 10,
-		9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 125, 10, 9, 118, 97,
-		114, 32, 99, 116, 108, 115, 32, 61, 32, 36, 40, 101, 108, 41, 46, 102,
-		105, 110, 100, 40, 34, 46, 99, 108, 105, 118, 101, 99, 116, 108, 34, 41,
-		59, 10, 9, 118, 97, 114, 32, 102, 111, 117, 110, 100, 32, 61, 32, 102,
-		97, 108, 115, 101, 59, 10, 9, 99, 116, 108, 115, 46, 101, 97, 99, 104,
-		40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9,
-		102, 111, 117, 110, 100, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9,
-		105, 102, 40, 33, 116, 104, 105, 115, 46, 119, 115, 41, 32, 123, 10, 9,
-		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 66,
-		85, 71, 58, 32, 99, 108, 105, 118, 101, 99, 116, 108, 32, 119, 47, 111,
-		32, 119, 115, 34, 41, 59, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108,
-		101, 46, 108, 111, 103, 40, 34, 100, 105, 100, 110, 39, 116, 32, 115, 101,
-		116, 32, 100, 46, 103, 101, 116, 40, 48, 41, 46, 119, 115, 63, 34, 41,
-		59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9,
-		105, 102, 40, 110, 101, 101, 100, 112, 111, 115, 116, 32, 38, 38, 32, 116,
-		104, 105, 115, 46, 112, 111, 115, 116, 41, 32, 123, 10, 9, 9, 9, 9,
-		116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 113, 117, 105, 116,
-		34, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114,
-		32, 112, 103, 105, 100, 32, 61, 32, 36, 40, 101, 108, 41, 46, 97, 116,
-		116, 114, 40, 39, 112, 103, 105, 100, 39, 41, 10, 9, 9, 9, 105, 102,
-		40, 110, 101, 101, 100, 112, 111, 115, 116, 32, 38, 38, 32, 112, 103, 105,
-		100, 41, 32, 123, 10, 9, 9, 9, 9, 100, 111, 99, 117, 109, 101, 110,
-		116, 46, 112, 111, 115, 116, 40, 91, 34, 113, 117, 105, 116, 34, 44, 32,
-		112, 103, 105, 100, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 119, 115, 46, 99, 108, 111, 115, 101, 40, 41, 59,
-		10, 9, 9, 125, 10, 9, 125, 41, 59, 10, 9, 105, 102, 40, 33, 102,
-		111, 117, 110, 100, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 105, 100,
-		32, 61, 32, 36, 40, 101, 108, 41, 46, 97, 116, 116, 114, 40, 39, 112,
-		103, 105, 100, 39, 41, 59, 10, 9, 9, 105, 102, 40, 112, 103, 100, 101,
-		98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
-		34, 109, 111, 114, 101, 32, 110, 111, 110, 45, 99, 108, 105, 118, 101, 99,
-		116, 108, 34, 44, 32, 101, 108, 44, 32, 105, 100, 41, 10, 9, 9, 105,
-		102, 40, 105, 100, 41, 32, 123, 10, 9, 9, 9, 100, 111, 99, 117, 109,
-		101, 110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 113, 117, 105, 116, 34,
-		44, 32, 105, 100, 93, 41, 59, 10, 9, 9, 125, 10, 9, 125, 10, 9,
-		101, 108, 46, 114, 101, 109, 111, 118, 101, 40, 41, 59, 10, 125, 10, 10,
-		102, 117, 110, 99, 116, 105, 111, 110, 32, 109, 97, 120, 112, 108, 40, 112,
-		108, 41, 32, 123, 10, 9, 118, 97, 114, 32, 105, 115, 109, 105, 110, 32,
-		61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 118, 97, 114, 32, 105, 99,
-		111, 110, 32, 61, 32, 36, 40, 112, 108, 41, 46, 102, 105, 110, 100, 40,
-		34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101,
-		34, 41, 46, 102, 105, 114, 115, 116, 40, 41, 59, 10, 9, 105, 102, 40,
-		33, 105, 99, 111, 110, 46, 104, 97, 115, 67, 108, 97, 115, 115, 40, 34,
-		117, 105, 45, 105, 99, 111, 110, 45, 112, 108, 117, 115, 34, 41, 41, 123,
-		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59,
-		10, 9, 125, 10, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 109, 97, 120,
-		112, 108, 32, 34, 44, 32, 105, 99, 111, 110, 41, 59, 10, 9, 36, 40,
-		112, 108, 41, 46, 102, 105, 110, 100, 40, 39, 46, 112, 111, 114, 116, 108,
-		101, 116, 45, 99, 111, 110, 116, 101, 110, 116, 39, 41, 46, 116, 111, 103,
-		103, 108, 101, 40, 41, 59, 10, 9, 105, 99, 111, 110, 46, 116, 111, 103,
-		103, 108, 101, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 105, 99, 111,
-		110, 45, 109, 105, 110, 117, 115, 32, 117, 105, 45, 105, 99, 111, 110, 45,
-		112, 108, 117, 115, 34, 41, 59, 10, 9, 112, 108, 46, 102, 105, 110, 100,
-		40, 34, 46, 99, 108, 105, 118, 101, 99, 116, 108, 34, 41, 46, 101, 97,
-		99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
-		9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105, 122,
-		101, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 97, 100, 100,
-		115, 105, 122, 101, 40, 48, 41, 59, 10, 9, 9, 125, 10, 9, 125, 41,
-		59, 10, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10,
-		125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 117, 112, 100, 112,
-		111, 114, 116, 108, 101, 116, 115, 40, 41, 32, 123, 10, 9, 118, 97, 114,
-		32, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116, 108, 101,
-		116, 34, 41, 10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61,
-		32, 48, 59, 32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110, 103, 116,
-		104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32,
-		112, 32, 61, 32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105, 102, 40,
-		33, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41, 32, 123,
-		10, 9, 9, 9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100,
-		32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101, 108, 115,
-		101, 32, 123, 10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59,
-		10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 104, 100, 114, 32, 61,
-		32, 36, 40, 112, 41, 46, 97, 100, 100, 67, 108, 97, 115, 115, 40, 34,
-		117, 105, 45, 119, 105, 100, 103, 101, 116, 32, 117, 105, 45, 119, 105, 100,
-		103, 101, 116, 45, 99, 111, 110, 116, 101, 110, 116, 32, 117, 105, 45, 104,
-		101, 108, 112, 101, 114, 45, 99, 108, 101, 97, 114, 102, 105, 120, 32, 117,
-		105, 45, 99, 111, 114, 110, 101, 114, 45, 97, 108, 108, 34, 41, 10, 9,
-		9, 9, 46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101,
-		116, 45, 104, 101, 97, 100, 101, 114, 34, 41, 59, 10, 9, 9, 36, 40,
-		104, 100, 114, 41, 46, 111, 110, 40, 39, 99, 108, 105, 99, 107, 39, 44,
-		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9,
-		9, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110,
-		115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 116, 97, 103, 32, 99, 108,
-		105, 99, 107, 34, 41, 59, 10, 9, 9, 9, 115, 99, 114, 111, 108, 108,
-		99, 111, 108, 46, 99, 97, 108, 108, 40, 36, 40, 116, 104, 105, 115, 41,
-		46, 99, 108, 111, 115, 101, 115, 116, 40, 34, 46, 99, 111, 108, 117, 109,
-		110, 34, 41, 44, 32, 101, 41, 59, 10, 9, 9, 125, 41, 59, 10, 9,
-		9, 104, 100, 114, 46, 97, 100, 100, 67, 108, 97, 115, 115, 40, 34, 117,
-		105, 45, 119, 105, 100, 103, 101, 116, 45, 104, 101, 97, 100, 101, 114, 32,
-		117, 105, 45, 99, 111, 114, 110, 101, 114, 45, 97, 108, 108, 34, 41, 10,
-		9, 9, 46, 112, 114, 101, 112, 101, 110, 100, 40, 34, 60, 115, 112, 97,
-		110, 32, 99, 108, 97, 115, 115, 61, 39, 117, 105, 45, 105, 99, 111, 110,
-		32, 105, 110, 108, 105, 110, 101, 32, 117, 105, 45, 105, 99, 111, 110, 45,
-		109, 105, 110, 117, 115, 32, 112, 111, 114, 116, 108, 101, 116, 45, 116, 111,
-		103, 103, 108, 101, 39, 62, 60, 47, 115, 112, 97, 110, 62, 34, 41, 10,
-		9, 9, 46, 112, 114, 101, 112, 101, 110, 100, 40, 34, 60, 115, 112, 97,
-		110, 32, 99, 108, 97, 115, 115, 61, 39, 117, 105, 45, 105, 99, 111, 110,
-		32, 105, 110, 108, 105, 110, 101, 32, 117, 105, 45, 105, 99, 111, 110, 45,
-		116, 114, 105, 97, 110, 103, 108, 101, 45, 50, 45, 110, 45, 115, 32, 112,
-		111, 114, 116, 108, 101, 116, 45, 105, 110, 99, 114, 50, 39, 62, 60, 47,
+		112, 41, 59, 10, 125, 10, 10, 10, 47, 47, 32, 65, 115, 107, 32, 98,
+		101, 102, 111, 114, 101, 32, 99, 108, 111, 115, 105, 110, 103, 32, 116, 104,
+		101, 32, 112, 97, 103, 101, 32, 119, 104, 105, 108, 101, 32, 116, 104, 101,
+		114, 101, 32, 97, 114, 101, 32, 100, 105, 114, 116, 121, 32, 99, 111, 110,
+		116, 114, 111, 108, 115, 44, 10, 47, 47, 32, 115, 111, 32, 101, 100, 105,
+		116, 115, 32, 97, 114, 101, 32, 110, 111, 116, 32, 108, 111, 115, 116, 46,
+		10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 98, 101, 102, 111, 114, 101,
+		117, 110, 108, 111, 97, 100, 40, 101, 41, 32, 123, 10, 9, 105, 102, 40,
+		36, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 100, 105, 114, 116,
+		121, 34, 41, 46, 108, 101, 110, 103, 116, 104, 32, 61, 61, 32, 48, 41,
+		32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 117, 110, 100, 101,
+		102, 105, 110, 101, 100, 59, 10, 9, 125, 10, 9, 118, 97, 114, 32, 109,
+		115, 103, 32, 61, 32, 34, 116, 104, 101, 114, 101, 32, 97, 114, 101, 32,
+		117, 110, 115, 97, 118, 101, 100, 32, 99, 104, 97, 110, 103, 101, 115, 34,
+		59, 10, 9, 101, 46, 114, 101, 116, 117, 114, 110, 86, 97, 108, 117, 101,
+		32, 61, 32, 109, 115, 103, 59, 10, 9, 114, 101, 116, 117, 114, 110, 32,
+		109, 115, 103, 59, 10, 125, 10, 10, 36, 40, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 123, 10, 9, 119, 105, 110, 100, 111, 119, 46, 97, 100,
+		100, 69, 118, 101, 110, 116, 76, 105, 115, 116, 101, 110, 101, 114, 40, 34,
+		98, 101, 102, 111, 114, 101, 117, 110, 108, 111, 97, 100, 34, 44, 32, 98,
+		101, 102, 111, 114, 101, 117, 110, 108, 111, 97, 100, 41, 59, 10, 9, 100,
+		111, 99, 117, 109, 101, 110, 116, 46, 115, 101, 116, 100, 105, 114, 116, 121,
+		32, 61, 32, 115, 101, 116, 100, 105, 114, 116, 121, 59, 10, 9, 100, 111,
+		99, 117, 109, 101, 110, 116, 46, 115, 101, 116, 102, 111, 99, 117, 115, 32,
+		61, 32, 115, 101, 116, 102, 111, 99, 117, 115, 59, 10, 9, 100, 111, 99,
+		117, 109, 101, 110, 116, 46, 115, 101, 116, 99, 108, 101, 97, 110, 32, 61,
+		32, 115, 101, 116, 99, 108, 101, 97, 110, 59, 10, 9, 100, 111, 99, 117,
+		109, 101, 110, 116, 46, 115, 101, 116, 116, 97, 103, 32, 61, 32, 115, 101,
+		116, 116, 97, 103, 59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46,
+		115, 104, 111, 119, 99, 111, 110, 116, 114, 111, 108, 32, 61, 32, 115, 104,
+		111, 119, 99, 111, 110, 116, 114, 111, 108, 59, 10, 125, 41, 59, 10, 10,
+		47, 47, 32, 101, 108, 32, 105, 115, 32, 97, 32, 112, 111, 114, 116, 108,
+		101, 116, 10, 47, 47, 32, 114, 101, 109, 111, 118, 101, 40, 41, 32, 105,
+		115, 32, 110, 111, 116, 32, 101, 110, 111, 117, 103, 104, 44, 32, 119, 101,
+		32, 109, 117, 115, 116, 32, 99, 108, 111, 115, 101, 32, 116, 104, 101, 32,
+		119, 115, 40, 115, 41, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 114,
+		101, 109, 111, 118, 101, 99, 111, 110, 116, 114, 111, 108, 40, 101, 108, 44,
+		32, 110, 101, 101, 100, 112, 111, 115, 116, 41, 32, 123, 10, 9, 105, 102,
+		40, 112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101,
+		46, 108, 111, 103, 40, 34, 114, 101, 109, 111, 118, 101, 99, 111, 110, 116,
+		114, 111, 108, 58, 32, 34, 44, 32, 101, 108, 41, 59, 10, 9, 105, 102,
+		40, 33, 101, 108, 41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110,
+		59, 10, 9, 125, 10, 9, 118, 97, 114, 32, 99, 116, 108, 115, 32, 61,
+		32, 36, 40, 101, 108, 41, 46, 102, 105, 110, 100, 40, 34, 46, 99, 108,
+		105, 118, 101, 99, 116, 108, 34, 41, 59, 10, 9, 118, 97, 114, 32, 102,
+		111, 117, 110, 100, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 99,
+		116, 108, 115, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 41, 32, 123, 10, 9, 9, 102, 111, 117, 110, 100, 32, 61, 32,
+		116, 114, 117, 101, 59, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115,
+		46, 119, 115, 41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108,
+		101, 46, 108, 111, 103, 40, 34, 66, 85, 71, 58, 32, 99, 108, 105, 118,
+		101, 99, 116, 108, 32, 119, 47, 111, 32, 119, 115, 34, 41, 59, 10, 9,
+		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 100,
+		105, 100, 110, 39, 116, 32, 115, 101, 116, 32, 100, 46, 103, 101, 116, 40,
+		48, 41, 46, 119, 115, 63, 34, 41, 59, 10, 9, 9, 125, 32, 101, 108,
+		115, 101, 32, 123, 10, 9, 9, 9, 105, 102, 40, 110, 101, 101, 100, 112,
+		111, 115, 116, 32, 38, 38, 32, 116, 104, 105, 115, 46, 112, 111, 115, 116,
+		41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115,
+		116, 40, 91, 34, 113, 117, 105, 116, 34, 93, 41, 59, 10, 9, 9, 9,
+		125, 10, 9, 9, 9, 118, 97, 114, 32, 112, 103, 105, 100, 32, 61, 32,
+		36, 40, 101, 108, 41, 46, 97, 116, 116, 114, 40, 39, 112, 103, 105, 100,
+		39, 41, 10, 9, 9, 9, 105, 102, 40, 110, 101, 101, 100, 112, 111, 115,
+		116, 32, 38, 38, 32, 112, 103, 105, 100, 41, 32, 123, 10, 9, 9, 9,
+		9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91,
+		34, 113, 117, 105, 116, 34, 44, 32, 112, 103, 105, 100, 93, 41, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 119, 115, 46,
+		99, 108, 111, 115, 101, 40, 41, 59, 10, 9, 9, 125, 10, 9, 125, 41,
+		59, 10, 9, 105, 102, 40, 33, 102, 111, 117, 110, 100, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 105, 100, 32, 61, 32, 36, 40, 101, 108, 41,
+		46, 97, 116, 116, 114, 40, 39, 112, 103, 105, 100, 39, 41, 59, 10, 9,
+		9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115,
+		111, 108, 101, 46, 108, 111, 103, 40, 34, 109, 111, 114, 101, 32, 110, 111,
+		110, 45, 99, 108, 105, 118, 101, 99, 116, 108, 34, 44, 32, 101, 108, 44,
+		32, 105, 100, 41, 10, 9, 9, 105, 102, 40, 105, 100, 41, 32, 123, 10,
+		9, 9, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116,
+		40, 91, 34, 113, 117, 105, 116, 34, 44, 32, 105, 100, 93, 41, 59, 10,
+		9, 9, 125, 10, 9, 125, 10, 9, 101, 108, 46, 114, 101, 109, 111, 118,
+		101, 40, 41, 59, 10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110,
+		32, 109, 97, 120, 112, 108, 40, 112, 108, 41, 32, 123, 10, 9, 118, 97,
+		114, 32, 105, 115, 109, 105, 110, 32, 61, 32, 102, 97, 108, 115, 101, 59,
+		10, 9, 118, 97, 114, 32, 105, 99, 111, 110, 32, 61, 32, 36, 40, 112,
+		108, 41, 46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101,
+		116, 45, 116, 111, 103, 103, 108, 101, 34, 41, 46, 102, 105, 114, 115, 116,
+		40, 41, 59, 10, 9, 105, 102, 40, 33, 105, 99, 111, 110, 46, 104, 97,
+		115, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 105, 99, 111, 110, 45,
+		112, 108, 117, 115, 34, 41, 41, 123, 10, 9, 9, 114, 101, 116, 117, 114,
+		110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125, 10, 9, 105, 102, 40,
+		112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46,
+		108, 111, 103, 40, 34, 109, 97, 120, 112, 108, 32, 34, 44, 32, 105, 99,
+		111, 110, 41, 59, 10, 9, 36, 40, 112, 108, 41, 46, 102, 105, 110, 100,
+		40, 39, 46, 112, 111, 114, 116, 108, 101, 116, 45, 99, 111, 110, 116, 101,
+		110, 116, 39, 41, 46, 116, 111, 103, 103, 108, 101, 40, 41, 59, 10, 9,
+		105, 99, 111, 110, 46, 116, 111, 103, 103, 108, 101, 67, 108, 97, 115, 115,
+		40, 34, 117, 105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117, 115, 32,
+		117, 105, 45, 105, 99, 111, 110, 45, 112, 108, 117, 115, 34, 41, 59, 10,
+		9, 112, 108, 46, 102, 105, 110, 100, 40, 34, 46, 99, 108, 105, 118, 101,
+		99, 116, 108, 34, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105,
+		115, 46, 97, 100, 100, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9, 9,
+		116, 104, 105, 115, 46, 97, 100, 100, 115, 105, 122, 101, 40, 48, 41, 59,
+		10, 9, 9, 125, 10, 9, 125, 41, 59, 10, 9, 114, 101, 116, 117, 114,
+		110, 32, 116, 114, 117, 101, 59, 10, 125, 10, 10, 102, 117, 110, 99, 116,
+		105, 111, 110, 32, 117, 112, 100, 112, 111, 114, 116, 108, 101, 116, 115, 40,
+		41, 32, 123, 10, 9, 118, 97, 114, 32, 112, 115, 32, 61, 32, 36, 40,
+		34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41, 10, 9, 102, 111, 114,
+		40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32,
+		112, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 32,
+		123, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32, 112, 115, 91, 105,
+		93, 59, 10, 9, 9, 105, 102, 40, 33, 112, 46, 99, 111, 110, 102, 105,
+		103, 117, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 112, 46, 99, 111,
+		110, 102, 105, 103, 117, 114, 101, 100, 32, 61, 32, 116, 114, 117, 101, 59,
+		10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 99,
+		111, 110, 116, 105, 110, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9, 118,
+		97, 114, 32, 104, 100, 114, 32, 61, 32, 36, 40, 112, 41, 46, 97, 100,
+		100, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 119, 105, 100, 103, 101,
+		116, 32, 117, 105, 45, 119, 105, 100, 103, 101, 116, 45, 99, 111, 110, 116,
+		101, 110, 116, 32, 117, 105, 45, 104, 101, 108, 112, 101, 114, 45, 99, 108,
+		101, 97, 114, 102, 105, 120, 32, 117, 105, 45, 99, 111, 114, 110, 101, 114,
+		45, 97, 108, 108, 34, 41, 10, 9, 9, 9, 46, 102, 105, 110, 100, 40,
+		34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 104, 101, 97, 100, 101, 114,
+		34, 41, 59, 10, 9, 9, 36, 40, 104, 100, 114, 41, 46, 111, 110, 40,
+		39, 99, 108, 105, 99, 107, 39, 44, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 101, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 112, 103, 100,
+		101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
+		40, 34, 116, 97, 103, 32, 99, 108, 105, 99, 107, 34, 41, 59, 10, 9,
+		9, 9, 115, 99, 114, 111, 108, 108, 99, 111, 108, 46, 99, 97, 108, 108,
+		40, 36, 40, 116, 104, 105, 115, 41, 46, 99, 108, 111, 115, 101, 115, 116,
+		40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 44, 32, 101, 41, 59,
+		10, 9, 9, 125, 41, 59, 10, 9, 9, 104, 100, 114, 46, 97, 100, 100,
+		67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 119, 105, 100, 103, 101, 116,
+		45, 104, 101, 97, 100, 101, 114, 32, 117, 105, 45, 99, 111, 114, 110, 101,
+		114, 45, 97, 108, 108, 34, 41, 10, 9, 9, 46, 112, 114, 101, 112, 101,
+		110, 100, 40, 34, 60, 115, 112, 97, 110, 32, 99, 108, 97, 115, 115, 61,
+		39, 117, 105, 45, 105, 99, 111, 110, 32, 105, 110, 108, 105, 110, 101, 32,
+		117, 105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117, 115, 32, 112, 111,
+		114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 39, 62, 60, 47,
 		115, 112, 97, 110, 62, 34, 41, 10, 9, 9, 46, 112, 114, 101, 112, 101,
 		110, 100, 40, 34, 60, 115, 112, 97, 110, 32, 99, 108, 97, 115, 115, 61,
 		39, 117, 105, 45, 105, 99, 111, 110, 32, 105, 110, 108, 105, 110, 101, 32,
 		117, 105, 45, 105, 99, 111, 110, 45, 116, 114, 105, 97, 110, 103, 108, 101,
-		45, 49, 45, 110, 32, 112, 111, 114, 116, 108, 101, 116, 45, 100, 101, 99,
-		114, 39, 62, 60, 47, 115, 112, 97, 110, 62, 34, 41, 10, 9, 9, 46,
-		112, 114, 101, 112, 101, 110, 100, 40, 34, 60, 115, 112, 97, 110, 32, 99,
-		108, 97, 115, 115, 61, 39, 117, 105, 45, 105, 99, 111, 110, 32, 105, 110,
-		108, 105, 110, 101, 32, 117, 105, 45, 105, 99, 111, 110, 45, 116, 114, 105,
-		97, 110, 103, 108, 101, 45, 49, 45, 115, 32, 112, 111, 114, 116, 108, 101,
-		116, 45, 105, 110, 99, 114, 39, 62, 60, 47, 115, 112, 97, 110, 62, 34,
-		41, 10, 9, 9, 46, 112, 114, 101, 112, 101, 110, 100, 40, 34, 60, 115,
-		112, 97, 110, 32, 99, 108, 97, 115, 115, 61, 39, 117, 105, 45, 105, 99,
-		111, 110, 32, 105, 110, 108, 105, 110, 101, 32, 117, 105, 45, 105, 99, 111,
-		110, 45, 116, 114, 105, 97, 110, 103, 108, 101, 45, 49, 45, 101, 32, 112,
-		111, 114, 116, 108, 101, 116, 45, 109, 97, 120, 39, 62, 60, 47, 115, 112,
-		97, 110, 62, 34, 41, 10, 9, 9, 46, 112, 114, 101, 112, 101, 110, 100,
-		40, 34, 60, 115, 112, 97, 110, 32, 99, 108, 97, 115, 115, 61, 39, 117,
-		105, 45, 105, 99, 111, 110, 32, 105, 110, 108, 105, 110, 101, 32, 117, 105,
-		45, 105, 99, 111, 110, 45, 99, 108, 111, 115, 101, 32, 112, 111, 114, 116,
-		108, 101, 116, 45, 99, 108, 111, 115, 101, 39, 62, 60, 47, 115, 112, 97,
-		110, 62, 34, 41, 59, 10, 9, 9, 104, 100, 114, 46, 111, 110, 40, 39,
-		99, 111, 110, 116, 101, 120, 116, 109, 101, 110, 117, 39, 44, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 41, 123, 114, 101, 116, 117, 114, 110, 32,
-		102, 97, 108, 115, 101, 59, 125, 41, 59, 10, 9, 125, 10, 9, 112, 115,
-		32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 109,
-		97, 120, 34, 41, 59, 10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105,
-		32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110,
-		103, 116, 104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97,
-		114, 32, 112, 32, 61, 32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105,
-		102, 40, 33, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41,
-		32, 123, 10, 9, 9, 9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114,
-		101, 100, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101,
-		108, 115, 101, 32, 123, 10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117,
-		101, 59, 10, 9, 9, 125, 10, 9, 9, 36, 40, 112, 41, 46, 99, 108,
-		105, 99, 107, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123,
-		10, 9, 9, 9, 101, 46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103,
-		97, 116, 105, 111, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32,
-		112, 108, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 46, 99, 108, 111,
-		115, 101, 115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41,
-		59, 10, 9, 9, 9, 105, 102, 40, 109, 97, 120, 112, 108, 40, 112, 108,
-		41, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 112, 48, 32,
-		61, 32, 112, 108, 46, 103, 101, 116, 40, 48, 41, 59, 10, 9, 9, 9,
-		118, 97, 114, 32, 99, 111, 108, 32, 61, 32, 36, 40, 116, 104, 105, 115,
-		41, 46, 99, 108, 111, 115, 101, 115, 116, 40, 34, 46, 99, 111, 108, 117,
-		109, 110, 34, 41, 59, 10, 9, 9, 9, 36, 40, 99, 111, 108, 41, 46,
-		102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41,
+		45, 50, 45, 110, 45, 115, 32, 112, 111, 114, 116, 108, 101, 116, 45, 105,
+		110, 99, 114, 50, 39, 62, 60, 47, 115, 112, 97, 110, 62, 34, 41, 10,
+		9, 9, 46, 112, 114, 101, 112, 101, 110, 100, 40, 34, 60, 115, 112, 97,
+		110, 32, 99, 108, 97, 115, 115, 61, 39, 117, 105, 45, 105, 99, 111, 110,
+		32, 105, 110, 108, 105, 110, 101, 32, 117, 105, 45, 105, 99, 111, 110, 45,
+		116, 114, 105, 97, 110, 103, 108, 101, 45, 49, 45, 110, 32, 112, 111, 114,
+		116, 108, 101, 116, 45, 100, 101, 99, 114, 39, 62, 60, 47, 115, 112, 97,
+		110, 62, 34, 41, 10, 9, 9, 46, 112, 114, 101, 112, 101, 110, 100, 40,
+		34, 60, 115, 112, 97, 110, 32, 99, 108, 97, 115, 115, 61, 39, 117, 105,
+		45, 105, 99, 111, 110, 32, 105, 110, 108, 105, 110, 101, 32, 117, 105, 45,
+		105, 99, 111, 110, 45, 116, 114, 105, 97, 110, 103, 108, 101, 45, 49, 45,
+		115, 32, 112, 111, 114, 116, 108, 101, 116, 45, 105, 110, 99, 114, 39, 62,
+		60, 47, 115, 112, 97, 110, 62, 34, 41, 10, 9, 9, 46, 112, 114, 101,
+		112, 101, 110, 100, 40, 34, 60, 115, 112, 97, 110, 32, 99, 108, 97, 115,
+		115, 61, 39, 117, 105, 45, 105, 99, 111, 110, 32, 105, 110, 108, 105, 110,
+		101, 32, 117, 105, 45, 105, 99, 111, 110, 45, 116, 114, 105, 97, 110, 103,
+		108, 101, 45, 49, 45, 101, 32, 112, 111, 114, 116, 108, 101, 116, 45, 109,
+		97, 120, 39, 62, 60, 47, 115, 112, 97, 110, 62, 34, 41, 10, 9, 9,
+		46, 112, 114, 101, 112, 101, 110, 100, 40, 34, 60, 115, 112, 97, 110, 32,
+		99, 108, 97, 115, 115, 61, 39, 117, 105, 45, 105, 99, 111, 110, 32, 105,
+		110, 108, 105, 110, 101, 32, 117, 105, 45, 105, 99, 111, 110, 45, 99, 108,
+		111, 115, 101, 32, 112, 111, 114, 116, 108, 101, 116, 45, 99, 108, 111, 115,
+		101, 39, 62, 60, 47, 115, 112, 97, 110, 62, 34, 41, 59, 10, 9, 9,
+		104, 100, 114, 46, 111, 110, 40, 39, 99, 111, 110, 116, 101, 120, 116, 109,
+		101, 110, 117, 39, 44, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		123, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 125, 41,
+		59, 10, 9, 125, 10, 9, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112,
+		111, 114, 116, 108, 101, 116, 45, 109, 97, 120, 34, 41, 59, 10, 9, 102,
+		111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32,
+		60, 32, 112, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43,
+		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32, 112, 115,
+		91, 105, 93, 59, 10, 9, 9, 105, 102, 40, 33, 112, 46, 99, 111, 110,
+		102, 105, 103, 117, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 112, 46,
+		99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 32, 61, 32, 116, 114, 117,
+		101, 59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9,
+		9, 99, 111, 110, 116, 105, 110, 117, 101, 59, 10, 9, 9, 125, 10, 9,
+		9, 36, 40, 112, 41, 46, 99, 108, 105, 99, 107, 40, 102, 117, 110, 99,
+		116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 101, 46, 115, 116,
+		111, 112, 80, 114, 111, 112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59,
+		10, 9, 9, 9, 118, 97, 114, 32, 112, 108, 32, 61, 32, 36, 40, 116,
+		104, 105, 115, 41, 46, 99, 108, 111, 115, 101, 115, 116, 40, 34, 46, 112,
+		111, 114, 116, 108, 101, 116, 34, 41, 59, 10, 9, 9, 9, 105, 102, 40,
+		109, 97, 120, 112, 108, 40, 112, 108, 41, 41, 32, 123, 10, 9, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 112, 108, 46, 103, 101, 116,
+		40, 48, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 99, 111, 108, 32,
+		61, 32, 36, 40, 116, 104, 105, 115, 41, 46, 99, 108, 111, 115, 101, 115,
+		116, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 59, 10, 9, 9,
+		9, 36, 40, 99, 111, 108, 41, 46, 102, 105, 110, 100, 40, 34, 46, 112,
+		111, 114, 116, 108, 101, 116, 34, 41, 46, 101, 97, 99, 104, 40, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 41, 123, 10, 9, 9, 9, 9, 118, 97,
+		114, 32, 112, 105, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 46, 103,
+		101, 116, 40, 48, 41, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 115,
+		101, 108, 102, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 59, 10, 9,
+		9, 9, 9, 47, 47, 32, 108, 101, 116, 39, 115, 32, 109, 105, 110, 105,
+		109, 105, 122, 101, 32, 101, 118, 101, 114, 121, 116, 104, 105, 110, 103, 46,
+		10, 9, 9, 9, 9, 105, 102, 40, 102, 97, 108, 115, 101, 32, 38, 38,
+		32, 112, 48, 32, 61, 61, 32, 112, 105, 41, 32, 123, 10, 9, 9, 9,
+		9, 9, 36, 40, 116, 104, 105, 115, 41, 46, 102, 105, 110, 100, 40, 34,
+		46, 112, 111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 34,
+		41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		41, 123, 10, 9, 9, 9, 9, 9, 9, 105, 102, 40, 36, 40, 116, 104,
+		105, 115, 41, 46, 104, 97, 115, 67, 108, 97, 115, 115, 40, 34, 117, 105,
+		45, 105, 99, 111, 110, 45, 112, 108, 117, 115, 34, 41, 41, 32, 123, 10,
+		9, 9, 9, 9, 9, 9, 9, 36, 40, 116, 104, 105, 115, 41, 46, 116,
+		111, 103, 103, 108, 101, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 105,
+		99, 111, 110, 45, 109, 105, 110, 117, 115, 32, 117, 105, 45, 105, 99, 111,
+		110, 45, 112, 108, 117, 115, 34, 41, 59, 10, 9, 9, 9, 9, 9, 9,
+		9, 115, 101, 108, 102, 46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114,
+		116, 108, 101, 116, 45, 99, 111, 110, 116, 101, 110, 116, 34, 41, 46, 116,
+		111, 103, 103, 108, 101, 40, 41, 59, 10, 9, 9, 9, 9, 9, 9, 125,
+		10, 9, 9, 9, 9, 9, 125, 41, 59, 10, 9, 9, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9,
+		9, 36, 40, 116, 104, 105, 115, 41, 46, 102, 105, 110, 100, 40, 34, 46,
+		112, 111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 34, 41,
 		46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
-		123, 10, 9, 9, 9, 9, 118, 97, 114, 32, 112, 105, 32, 61, 32, 36,
-		40, 116, 104, 105, 115, 41, 46, 103, 101, 116, 40, 48, 41, 59, 10, 9,
-		9, 9, 9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 36, 40,
-		116, 104, 105, 115, 41, 59, 10, 9, 9, 9, 9, 47, 47, 32, 108, 101,
-		116, 39, 115, 32, 109, 105, 110, 105, 109, 105, 122, 101, 32, 101, 118, 101,
-		114, 121, 116, 104, 105, 110, 103, 46, 10, 9, 9, 9, 9, 105, 102, 40,
-		102, 97, 108, 115, 101, 32, 38, 38, 32, 112, 48, 32, 61, 61, 32, 112,
-		105, 41, 32, 123, 10, 9, 9, 9, 9, 9, 36, 40, 116, 104, 105, 115,
-		41, 46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116,
-		45, 116, 111, 103, 103, 108, 101, 34, 41, 46, 101, 97, 99, 104, 40, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 10, 9, 9, 9, 9, 9,
-		9, 105, 102, 40, 36, 40, 116, 104, 105, 115, 41, 46, 104, 97, 115, 67,
-		108, 97, 115, 115, 40, 34, 117, 105, 45, 105, 99, 111, 110, 45, 112, 108,
-		117, 115, 34, 41, 41, 32, 123, 10, 9, 9, 9, 9, 9, 9, 9, 36,
-		40, 116, 104, 105, 115, 41, 46, 116, 111, 103, 103, 108, 101, 67, 108, 97,
-		115, 115, 40, 34, 117, 105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117,
-		115, 32, 117, 105, 45, 105, 99, 111, 110, 45, 112, 108, 117, 115, 34, 41,
-		59, 10, 9, 9, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 102, 105,
-		110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 99, 111, 110,
-		116, 101, 110, 116, 34, 41, 46, 116, 111, 103, 103, 108, 101, 40, 41, 59,
-		10, 9, 9, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 9, 125, 41,
-		59, 10, 9, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
-		9, 9, 9, 125, 10, 9, 9, 9, 9, 36, 40, 116, 104, 105, 115, 41,
-		46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45,
-		116, 111, 103, 103, 108, 101, 34, 41, 46, 101, 97, 99, 104, 40, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 41, 123, 10, 9, 9, 9, 9, 9, 105,
-		102, 40, 36, 40, 116, 104, 105, 115, 41, 46, 104, 97, 115, 67, 108, 97,
-		115, 115, 40, 34, 117, 105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117,
-		115, 34, 41, 41, 32, 123, 10, 9, 9, 9, 9, 9, 9, 36, 40, 116,
-		104, 105, 115, 41, 46, 116, 111, 103, 103, 108, 101, 67, 108, 97, 115, 115,
-		40, 34, 117, 105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117, 115, 32,
-		117, 105, 45, 105, 99, 111, 110, 45, 112, 108, 117, 115, 34, 41, 59, 10,
-		9, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 102, 105, 110, 100, 40,
-		34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 99, 111, 110, 116, 101, 110,
-		116, 34, 41, 46, 116, 111, 103, 103, 108, 101, 40, 41, 59, 10, 9, 9,
-		9, 9, 9, 125, 10, 9, 9, 9, 9, 125, 41, 59, 10, 9, 9, 9,
-		125, 41, 59, 10, 9, 9, 125, 41, 59, 10, 9, 125, 10, 9, 112, 115,
-		32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 116,
-		111, 103, 103, 108, 101, 34, 41, 59, 10, 9, 102, 111, 114, 40, 118, 97,
-		114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 112, 115, 46,
-		108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9,
-		9, 118, 97, 114, 32, 112, 32, 61, 32, 112, 115, 91, 105, 93, 59, 10,
-		9, 9, 105, 102, 40, 33, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114,
-		101, 100, 41, 32, 123, 10, 9, 9, 9, 112, 46, 99, 111, 110, 102, 105,
-		103, 117, 114, 101, 100, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9,
-		125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 99, 111, 110, 116,
-		105, 110, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9, 36, 40, 112, 41,
-		46, 99, 108, 105, 99, 107, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40,
-		101, 41, 123, 10, 9, 9, 9, 101, 46, 115, 116, 111, 112, 80, 114, 111,
-		112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59, 10, 9, 9, 9, 118,
-		97, 114, 32, 105, 99, 111, 110, 32, 61, 32, 36, 40, 116, 104, 105, 115,
-		41, 59, 10, 9, 9, 9, 105, 99, 111, 110, 46, 116, 111, 103, 103, 108,
-		101, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 105, 99, 111, 110, 45,
-		109, 105, 110, 117, 115, 32, 117, 105, 45, 105, 99, 111, 110, 45, 112, 108,
-		117, 115, 34, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 108, 32,
-		61, 32, 105, 99, 111, 110, 46, 99, 108, 111, 115, 101, 115, 116, 40, 34,
-		46, 112, 111, 114, 116, 108, 101, 116, 34, 41, 59, 10, 9, 9, 9, 112,
-		108, 46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116,
-		45, 99, 111, 110, 116, 101, 110, 116, 34, 41, 46, 116, 111, 103, 103, 108,
-		101, 40, 41, 59, 10, 9, 9, 9, 112, 108, 46, 102, 105, 110, 100, 40,
-		34, 46, 99, 108, 105, 118, 101, 99, 116, 108, 34, 41, 46, 101, 97, 99,
-		104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
-		9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105,
-		122, 101, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		97, 100, 100, 115, 105, 122, 101, 40, 48, 41, 59, 10, 9, 9, 9, 9,
-		125, 10, 9, 9, 9, 125, 41, 59, 10, 9, 9, 125, 41, 59, 10, 9,
-		125, 10, 9, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116,
-		108, 101, 116, 45, 99, 108, 111, 115, 101, 34, 41, 59, 10, 9, 102, 111,
-		114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60,
-		32, 112, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41,
-		32, 123, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32, 112, 115, 91,
-		105, 93, 59, 10, 9, 9, 105, 102, 40, 33, 112, 46, 99, 111, 110, 102,
-		105, 103, 117, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 112, 46, 99,
-		111, 110, 102, 105, 103, 117, 114, 101, 100, 32, 61, 32, 116, 114, 117, 101,
-		59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9,
-		99, 111, 110, 116, 105, 110, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9,
-		36, 40, 112, 41, 46, 99, 108, 105, 99, 107, 40, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 101, 46, 115, 116, 111,
-		112, 80, 114, 111, 112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59, 10,
-		9, 9, 9, 118, 97, 114, 32, 105, 99, 111, 110, 32, 61, 32, 36, 40,
-		116, 104, 105, 115, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 101, 108,
-		32, 61, 32, 105, 99, 111, 110, 46, 99, 108, 111, 115, 101, 115, 116, 40,
-		34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41, 59, 10, 9, 9, 9,
-		114, 101, 109, 111, 118, 101, 99, 111, 110, 116, 114, 111, 108, 40, 101, 108,
-		44, 32, 116, 114, 117, 101, 41, 10, 9, 9, 125, 41, 59, 10, 9, 125,
-		10, 9, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116, 108,
-		101, 116, 45, 105, 110, 99, 114, 34, 41, 59, 10, 9, 102, 111, 114, 40,
-		118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 112,
-		115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 32, 123,
-		10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32, 112, 115, 91, 105, 93,
-		59, 10, 9, 9, 105, 102, 40, 33, 112, 46, 99, 111, 110, 102, 105, 103,
-		117, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 112, 46, 99, 111, 110,
-		102, 105, 103, 117, 114, 101, 100, 32, 61, 32, 116, 114, 117, 101, 59, 10,
-		9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 99, 111,
-		110, 116, 105, 110, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9, 36, 40,
-		112, 41, 46, 99, 108, 105, 99, 107, 40, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 101, 41, 123, 10, 9, 9, 9, 101, 46, 115, 116, 111, 112, 80,
-		114, 111, 112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59, 10, 9, 9,
-		9, 118, 97, 114, 32, 105, 99, 111, 110, 32, 61, 32, 36, 40, 116, 104,
-		105, 115, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 101, 108, 32, 61,
-		32, 105, 99, 111, 110, 46, 99, 108, 111, 115, 101, 115, 116, 40, 34, 46,
-		112, 111, 114, 116, 108, 101, 116, 34, 41, 59, 10, 9, 9, 9, 109, 97,
-		120, 112, 108, 40, 101, 108, 41, 59, 10, 9, 9, 9, 36, 40, 101, 108,
-		41, 46, 102, 105, 110, 100, 40, 34, 46, 99, 108, 105, 118, 101, 99, 116,
-		108, 34, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104, 105,
-		115, 46, 97, 100, 100, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9, 9,
-		9, 9, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105, 122, 101, 40, 49,
-		41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 41, 59, 10,
-		9, 9, 125, 41, 59, 10, 9, 125, 10, 9, 112, 115, 32, 61, 32, 36,
-		40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 105, 110, 99, 114, 50,
-		34, 41, 59, 10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61,
-		32, 48, 59, 32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110, 103, 116,
-		104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32,
-		112, 32, 61, 32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105, 102, 40,
-		33, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41, 32, 123,
-		10, 9, 9, 9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100,
-		32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101, 108, 115,
-		101, 32, 123, 10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59,
-		10, 9, 9, 125, 10, 9, 9, 36, 40, 112, 41, 46, 99, 108, 105, 99,
-		107, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9,
-		9, 9, 101, 46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103, 97, 116,
-		105, 111, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 105, 99,
-		111, 110, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 59, 10, 9, 9,
-		9, 118, 97, 114, 32, 101, 108, 32, 61, 32, 105, 99, 111, 110, 46, 99,
+		123, 10, 9, 9, 9, 9, 9, 105, 102, 40, 36, 40, 116, 104, 105, 115,
+		41, 46, 104, 97, 115, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 105,
+		99, 111, 110, 45, 109, 105, 110, 117, 115, 34, 41, 41, 32, 123, 10, 9,
+		9, 9, 9, 9, 9, 36, 40, 116, 104, 105, 115, 41, 46, 116, 111, 103,
+		103, 108, 101, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45, 105, 99, 111,
+		110, 45, 109, 105, 110, 117, 115, 32, 117, 105, 45, 105, 99, 111, 110, 45,
+		112, 108, 117, 115, 34, 41, 59, 10, 9, 9, 9, 9, 9, 9, 115, 101,
+		108, 102, 46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101,
+		116, 45, 99, 111, 110, 116, 101, 110, 116, 34, 41, 46, 116, 111, 103, 103,
+		108, 101, 40, 41, 59, 10, 9, 9, 9, 9, 9, 125, 10, 9, 9, 9,
+		9, 125, 41, 59, 10, 9, 9, 9, 125, 41, 59, 10, 9, 9, 125, 41,
+		59, 10, 9, 125, 10, 9, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112,
+		111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 34, 41, 59,
+		10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59,
+		32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32,
+		105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61,
+		32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105, 102, 40, 33, 112, 46,
+		99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41, 32, 123, 10, 9, 9,
+		9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 32, 61, 32,
+		116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123,
+		10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59, 10, 9, 9,
+		125, 10, 9, 9, 36, 40, 112, 41, 46, 99, 108, 105, 99, 107, 40, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 101,
+		46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103, 97, 116, 105, 111, 110,
+		40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 105, 99, 111, 110, 32,
+		61, 32, 36, 40, 116, 104, 105, 115, 41, 59, 10, 9, 9, 9, 105, 99,
+		111, 110, 46, 116, 111, 103, 103, 108, 101, 67, 108, 97, 115, 115, 40, 34,
+		117, 105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117, 115, 32, 117, 105,
+		45, 105, 99, 111, 110, 45, 112, 108, 117, 115, 34, 41, 59, 10, 9, 9,
+		9, 118, 97, 114, 32, 112, 108, 32, 61, 32, 105, 99, 111, 110, 46, 99,
 		108, 111, 115, 101, 115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116,
-		34, 41, 59, 10, 9, 9, 9, 109, 97, 120, 112, 108, 40, 101, 108, 41,
-		59, 10, 9, 9, 9, 36, 40, 101, 108, 41, 46, 102, 105, 110, 100, 40,
-		34, 46, 99, 108, 105, 118, 101, 99, 116, 108, 34, 41, 46, 101, 97, 99,
-		104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
-		9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105,
-		122, 101, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		97, 100, 100, 115, 105, 122, 101, 40, 50, 41, 59, 10, 9, 9, 9, 9,
-		125, 10, 9, 9, 9, 125, 41, 59, 10, 9, 9, 125, 41, 59, 10, 9,
-		125, 10, 9, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116,
-		108, 101, 116, 45, 100, 101, 99, 114, 34, 41, 59, 10, 9, 102, 111, 114,
+		34, 41, 59, 10, 9, 9, 9, 112, 108, 46, 102, 105, 110, 100, 40, 34,
+		46, 112, 111, 114, 116, 108, 101, 116, 45, 99, 111, 110, 116, 101, 110, 116,
+		34, 41, 46, 116, 111, 103, 103, 108, 101, 40, 41, 59, 10, 9, 9, 9,
+		112, 108, 46, 102, 105, 110, 100, 40, 34, 46, 99, 108, 105, 118, 101, 99,
+		116, 108, 34, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104,
+		105, 115, 46, 97, 100, 100, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9,
+		9, 9, 9, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105, 122, 101, 40,
+		48, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 41, 59,
+		10, 9, 9, 125, 41, 59, 10, 9, 125, 10, 9, 112, 115, 32, 61, 32,
+		36, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 99, 108, 111, 115,
+		101, 34, 41, 59, 10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32,
+		61, 32, 48, 59, 32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110, 103,
+		116, 104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97, 114,
+		32, 112, 32, 61, 32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105, 102,
+		40, 33, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41, 32,
+		123, 10, 9, 9, 9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101,
+		100, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101, 108,
+		115, 101, 32, 123, 10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101,
+		59, 10, 9, 9, 125, 10, 9, 9, 36, 40, 112, 41, 46, 99, 108, 105,
+		99, 107, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10,
+		9, 9, 9, 101, 46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103, 97,
+		116, 105, 111, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 105,
+		99, 111, 110, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 59, 10, 9,
+		9, 9, 118, 97, 114, 32, 101, 108, 32, 61, 32, 105, 99, 111, 110, 46,
+		99, 108, 111, 115, 101, 115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101,
+		116, 34, 41, 59, 10, 9, 9, 9, 114, 101, 109, 111, 118, 101, 99, 111,
+		110, 116, 114, 111, 108, 40, 101, 108, 44, 32, 116, 114, 117, 101, 41, 10,
+		9, 9, 125, 41, 59, 10, 9, 125, 10, 9, 112, 115, 32, 61, 32, 36,
+		40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 105, 110, 99, 114, 34,
+		41, 59, 10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32,
+		48, 59, 32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110, 103, 116, 104,
+		59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 112,
+		32, 61, 32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105, 102, 40, 33,
+		112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41, 32, 123, 10,
+		9, 9, 9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 32,
+		61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101, 108, 115, 101,
+		32, 123, 10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59, 10,
+		9, 9, 125, 10, 9, 9, 36, 40, 112, 41, 46, 99, 108, 105, 99, 107,
+		40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9,
+		9, 101, 46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103, 97, 116, 105,
+		111, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 105, 99, 111,
+		110, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 59, 10, 9, 9, 9,
+		118, 97, 114, 32, 101, 108, 32, 61, 32, 105, 99, 111, 110, 46, 99, 108,
+		111, 115, 101, 115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34,
+		41, 59, 10, 9, 9, 9, 109, 97, 120, 112, 108, 40, 101, 108, 41, 59,
+		10, 9, 9, 9, 36, 40, 101, 108, 41, 46, 102, 105, 110, 100, 40, 34,
+		46, 99, 108, 105, 118, 101, 99, 116, 108, 34, 41, 46, 101, 97, 99, 104,
+		40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9,
+		9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105, 122,
+		101, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 97,
+		100, 100, 115, 105, 122, 101, 40, 49, 41, 59, 10, 9, 9, 9, 9, 125,
+		10, 9, 9, 9, 125, 41, 59, 10, 9, 9, 125, 41, 59, 10, 9, 125,
+		10, 9, 112, 115, 32, 61, 32, 36, 40, 34, 46, 112, 111, 114, 116, 108,
+		101, 116, 45, 105, 110, 99, 114, 50, 34, 41, 59, 10, 9, 102, 111, 114,
 		40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32,
 		112, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 32,
 		123, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32, 112, 115, 91, 105,
@@ -486,289 +481,508 @@ var Files = map[string][]byte{
 		111, 110, 40, 41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104,
 		105, 115, 46, 97, 100, 100, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9,
 		9, 9, 9, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105, 122, 101, 40,
-		45, 49, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 41,
-		59, 10, 9, 9, 125, 41, 59, 10, 9, 125, 10, 125, 10, 10, 102, 117,
-		110, 99, 116, 105, 111, 110, 32, 112, 103, 100, 114, 111, 112, 40, 99, 111,
-		108, 44, 32, 101, 41, 32, 123, 10, 9, 118, 97, 114, 32, 100, 97, 116,
+		50, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 41, 59,
+		10, 9, 9, 125, 41, 59, 10, 9, 125, 10, 9, 112, 115, 32, 61, 32,
+		36, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 100, 101, 99, 114,
+		34, 41, 59, 10, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61,
+		32, 48, 59, 32, 105, 32, 60, 32, 112, 115, 46, 108, 101, 110, 103, 116,
+		104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32,
+		112, 32, 61, 32, 112, 115, 91, 105, 93, 59, 10, 9, 9, 105, 102, 40,
+		33, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100, 41, 32, 123,
+		10, 9, 9, 9, 112, 46, 99, 111, 110, 102, 105, 103, 117, 114, 101, 100,
+		32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 32, 101, 108, 115,
+		101, 32, 123, 10, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59,
+		10, 9, 9, 125, 10, 9, 9, 36, 40, 112, 41, 46, 99, 108, 105, 99,
+		107, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9,
+		9, 9, 101, 46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103, 97, 116,
+		105, 111, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 105, 99,
+		111, 110, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 59, 10, 9, 9,
+		9, 118, 97, 114, 32, 101, 108, 32, 61, 32, 105, 99, 111, 110, 46, 99,
+		108, 111, 115, 101, 115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116,
+		34, 41, 59, 10, 9, 9, 9, 109, 97, 120, 112, 108, 40, 101, 108, 41,
+		59, 10, 9, 9, 9, 36, 40, 101, 108, 41, 46, 102, 105, 110, 100, 40,
+		34, 46, 99, 108, 105, 118, 101, 99, 116, 108, 34, 41, 46, 101, 97, 99,
+		104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
+		9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 97, 100, 100, 115, 105,
+		122, 101, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46,
+		97, 100, 100, 115, 105, 122, 101, 40, 45, 49, 41, 59, 10, 9, 9, 9,
+		9, 125, 10, 9, 9, 9, 125, 41, 59, 10, 9, 9, 125, 41, 59, 10,
+		9, 125, 10, 125, 10, 10, 47, 47, 32, 77, 97, 120, 32, 115, 105, 122,
+		101, 32, 102, 111, 114, 32, 102, 105, 108, 101, 115, 32, 100, 114, 111, 112,
+		112, 101, 100, 32, 111, 110, 32, 116, 104, 101, 32, 112, 97, 103, 101, 32,
+		97, 110, 100, 32, 117, 112, 108, 111, 97, 100, 101, 100, 46, 10, 118, 97,
+		114, 32, 109, 97, 120, 117, 112, 108, 111, 97, 100, 32, 61, 32, 49, 54,
+		42, 49, 48, 50, 52, 42, 49, 48, 50, 52, 59, 10, 10, 47, 47, 32,
+		70, 105, 108, 101, 115, 32, 100, 114, 111, 112, 112, 101, 100, 32, 97, 114,
+		101, 32, 112, 111, 115, 116, 101, 100, 32, 97, 115, 32, 117, 112, 108, 111,
+		97, 100, 32, 110, 97, 109, 101, 32, 100, 97, 116, 97, 32, 99, 111, 108,
+		110, 97, 109, 101, 32, 40, 100, 97, 116, 97, 32, 105, 110, 32, 98, 97,
+		115, 101, 54, 52, 41, 44, 10, 47, 47, 32, 97, 110, 100, 32, 116, 101,
+		120, 116, 32, 40, 101, 103, 46, 32, 112, 97, 116, 104, 115, 41, 32, 97,
+		115, 32, 99, 108, 105, 99, 107, 52, 32, 116, 101, 120, 116, 32, 99, 111,
+		108, 110, 97, 109, 101, 46, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32,
+		112, 103, 100, 114, 111, 112, 40, 99, 111, 108, 44, 32, 101, 41, 32, 123,
+		10, 9, 118, 97, 114, 32, 105, 100, 32, 61, 32, 36, 40, 99, 111, 108,
+		41, 46, 97, 116, 116, 114, 40, 39, 105, 100, 39, 41, 59, 10, 9, 118,
+		97, 114, 32, 102, 105, 108, 101, 115, 32, 61, 32, 101, 46, 100, 97, 116,
+		97, 84, 114, 97, 110, 115, 102, 101, 114, 46, 102, 105, 108, 101, 115, 59,
+		10, 9, 105, 102, 40, 102, 105, 108, 101, 115, 32, 38, 38, 32, 102, 105,
+		108, 101, 115, 46, 108, 101, 110, 103, 116, 104, 32, 62, 32, 48, 41, 32,
+		123, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32,
+		48, 59, 32, 105, 32, 60, 32, 102, 105, 108, 101, 115, 46, 108, 101, 110,
+		103, 116, 104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 9, 112,
+		103, 117, 112, 108, 111, 97, 100, 40, 102, 105, 108, 101, 115, 91, 105, 93,
+		44, 32, 105, 100, 41, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116,
+		117, 114, 110, 59, 10, 9, 125, 10, 9, 118, 97, 114, 32, 100, 97, 116,
 		97, 32, 61, 32, 101, 46, 100, 97, 116, 97, 84, 114, 97, 110, 115, 102,
 		101, 114, 46, 103, 101, 116, 68, 97, 116, 97, 40, 34, 84, 101, 120, 116,
-		34, 41, 59, 10, 9, 118, 97, 114, 32, 105, 100, 32, 61, 32, 36, 40,
-		99, 111, 108, 41, 46, 97, 116, 116, 114, 40, 39, 105, 100, 39, 41, 59,
-		10, 9, 105, 102, 40, 100, 97, 116, 97, 41, 10, 9, 9, 105, 102, 40,
-		112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 34, 100, 114, 111, 112, 34, 44, 32, 100, 97, 116, 97,
-		44, 32, 34, 111, 110, 34, 44, 32, 105, 100, 41, 59, 10, 9, 100, 111,
-		99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 99, 108,
-		105, 99, 107, 52, 34, 44, 32, 100, 97, 116, 97, 44, 32, 105, 100, 93,
-		41, 59, 10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 112,
-		103, 117, 112, 100, 97, 116, 101, 40, 41, 32, 123, 10, 9, 105, 102, 40,
-		112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 34, 108, 97, 121, 111, 117, 116, 32, 117, 112, 100, 97,
-		116, 101, 100, 34, 41, 59, 10, 9, 118, 97, 114, 32, 108, 97, 121, 111,
-		117, 116, 61, 91, 34, 108, 97, 121, 111, 117, 116, 34, 93, 59, 10, 9,
-		36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 46, 101, 97, 99,
-		104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 10, 9, 9,
-		118, 97, 114, 32, 99, 111, 108, 32, 61, 32, 36, 40, 116, 104, 105, 115,
-		41, 46, 97, 116, 116, 114, 40, 39, 105, 100, 39, 41, 59, 10, 9, 9,
-		36, 40, 116, 104, 105, 115, 41, 46, 102, 105, 110, 100, 40, 34, 46, 117,
-		105, 45, 119, 105, 100, 103, 101, 116, 45, 99, 111, 110, 116, 101, 110, 116,
-		34, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 101, 108, 32, 61, 32,
-		36, 40, 116, 104, 105, 115, 41, 46, 97, 116, 116, 114, 40, 39, 105, 100,
-		39, 41, 59, 10, 9, 9, 9, 105, 102, 40, 101, 108, 41, 32, 123, 10,
-		9, 9, 9, 9, 108, 97, 121, 111, 117, 116, 46, 112, 117, 115, 104, 40,
-		99, 111, 108, 43, 34, 33, 34, 43, 101, 108, 41, 59, 10, 9, 9, 9,
-		125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 9, 108, 97, 121,
-		111, 117, 116, 46, 112, 117, 115, 104, 40, 99, 111, 108, 43, 34, 33, 110,
-		111, 110, 101, 34, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 41,
-		59, 10, 9, 125, 41, 59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116,
-		46, 112, 111, 115, 116, 40, 108, 97, 121, 111, 117, 116, 41, 59, 10, 9,
+		34, 41, 59, 10, 9, 105, 102, 40, 100, 97, 116, 97, 41, 10, 9, 9,
 		105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111,
-		108, 101, 46, 108, 111, 103, 40, 108, 97, 121, 111, 117, 116, 41, 59, 10,
-		125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 112, 103, 97, 112,
-		112, 108, 121, 40, 101, 118, 41, 32, 123, 10, 9, 105, 102, 40, 33, 101,
-		118, 32, 124, 124, 32, 33, 101, 118, 46, 65, 114, 103, 115, 32, 124, 124,
-		32, 33, 101, 118, 46, 65, 114, 103, 115, 91, 48, 93, 41, 123, 10, 9,
-		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 97, 112,
-		112, 108, 121, 58, 32, 110, 105, 108, 32, 101, 118, 34, 41, 59, 10, 9,
-		9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 125, 10, 9, 118, 97, 114,
-		32, 97, 114, 103, 32, 61, 32, 101, 118, 46, 65, 114, 103, 115, 10, 9,
-		115, 119, 105, 116, 99, 104, 40, 97, 114, 103, 91, 48, 93, 41, 32, 123,
-		10, 9, 99, 97, 115, 101, 32, 34, 108, 111, 97, 100, 34, 58, 10, 9,
+		108, 101, 46, 108, 111, 103, 40, 34, 100, 114, 111, 112, 34, 44, 32, 100,
+		97, 116, 97, 44, 32, 34, 111, 110, 34, 44, 32, 105, 100, 41, 59, 10,
+		9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91,
+		34, 99, 108, 105, 99, 107, 52, 34, 44, 32, 100, 97, 116, 97, 44, 32,
+		105, 100, 93, 41, 59, 10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111,
+		110, 32, 112, 103, 117, 112, 108, 111, 97, 100, 40, 102, 44, 32, 105, 100,
+		41, 32, 123, 10, 9, 105, 102, 40, 102, 46, 115, 105, 122, 101, 32, 62,
+		32, 109, 97, 120, 117, 112, 108, 111, 97, 100, 41, 32, 123, 10, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117, 112, 108,
+		111, 97, 100, 58, 32, 34, 32, 43, 32, 102, 46, 110, 97, 109, 101, 32,
+		43, 32, 34, 58, 32, 116, 111, 111, 32, 108, 97, 114, 103, 101, 34, 41,
+		59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 125, 10, 9,
+		118, 97, 114, 32, 114, 32, 61, 32, 110, 101, 119, 32, 70, 105, 108, 101,
+		82, 101, 97, 100, 101, 114, 40, 41, 59, 10, 9, 114, 46, 111, 110, 108,
+		111, 97, 100, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		32, 123, 10, 9, 9, 47, 47, 32, 100, 97, 116, 97, 58, 116, 121, 112,
+		101, 59, 98, 97, 115, 101, 54, 52, 44, 120, 120, 120, 120, 10, 9, 9,
+		118, 97, 114, 32, 100, 97, 116, 97, 32, 61, 32, 114, 46, 114, 101, 115,
+		117, 108, 116, 59, 10, 9, 9, 118, 97, 114, 32, 105, 32, 61, 32, 100,
+		97, 116, 97, 46, 105, 110, 100, 101, 120, 79, 102, 40, 34, 44, 34, 41,
+		59, 10, 9, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117, 112, 108, 111,
+		97, 100, 34, 44, 32, 102, 46, 110, 97, 109, 101, 44, 32, 34, 111, 110,
+		34, 44, 32, 105, 100, 41, 59, 10, 9, 9, 100, 111, 99, 117, 109, 101,
+		110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 117, 112, 108, 111, 97, 100,
+		34, 44, 32, 102, 46, 110, 97, 109, 101, 44, 32, 100, 97, 116, 97, 46,
+		115, 108, 105, 99, 101, 40, 105, 43, 49, 41, 44, 32, 105, 100, 93, 41,
+		59, 10, 9, 125, 59, 10, 9, 114, 46, 111, 110, 101, 114, 114, 111, 114,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
+		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117,
+		112, 108, 111, 97, 100, 58, 32, 34, 32, 43, 32, 102, 46, 110, 97, 109,
+		101, 32, 43, 32, 34, 58, 32, 34, 32, 43, 32, 114, 46, 101, 114, 114,
+		111, 114, 41, 59, 10, 9, 125, 59, 10, 9, 114, 46, 114, 101, 97, 100,
+		65, 115, 68, 97, 116, 97, 85, 82, 76, 40, 102, 41, 59, 10, 125, 10,
+		10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 112, 103, 117, 112, 100, 97,
+		116, 101, 40, 41, 32, 123, 10, 9, 105, 102, 40, 112, 103, 100, 101, 98,
+		117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		108, 97, 121, 111, 117, 116, 32, 117, 112, 100, 97, 116, 101, 100, 34, 41,
+		59, 10, 9, 118, 97, 114, 32, 108, 97, 121, 111, 117, 116, 61, 91, 34,
+		108, 97, 121, 111, 117, 116, 34, 93, 59, 10, 9, 36, 40, 34, 46, 99,
+		111, 108, 117, 109, 110, 34, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 41, 123, 10, 9, 9, 118, 97, 114, 32, 99,
+		111, 108, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 46, 97, 116, 116,
+		114, 40, 39, 105, 100, 39, 41, 59, 10, 9, 9, 36, 40, 116, 104, 105,
+		115, 41, 46, 102, 105, 110, 100, 40, 34, 46, 117, 105, 45, 119, 105, 100,
+		103, 101, 116, 45, 99, 111, 110, 116, 101, 110, 116, 34, 41, 46, 101, 97,
+		99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 10, 9,
+		9, 9, 118, 97, 114, 32, 101, 108, 32, 61, 32, 36, 40, 116, 104, 105,
+		115, 41, 46, 97, 116, 116, 114, 40, 39, 105, 100, 39, 41, 59, 10, 9,
+		9, 9, 105, 102, 40, 101, 108, 41, 32, 123, 10, 9, 9, 9, 9, 108,
+		97, 121, 111, 117, 116, 46, 112, 117, 115, 104, 40, 99, 111, 108, 43, 34,
+		33, 34, 43, 101, 108, 41, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115,
+		101, 32, 123, 10, 9, 9, 9, 9, 108, 97, 121, 111, 117, 116, 46, 112,
+		117, 115, 104, 40, 99, 111, 108, 43, 34, 33, 110, 111, 110, 101, 34, 41,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 41, 59, 10, 9, 125, 41,
+		59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116,
+		40, 108, 97, 121, 111, 117, 116, 41, 59, 10, 9, 105, 102, 40, 112, 103,
+		100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 108, 97, 121, 111, 117, 116, 41, 59, 10, 125, 10, 10, 47, 47,
+		32, 83, 104, 111, 119, 32, 97, 32, 98, 114, 111, 119, 115, 101, 114, 32,
+		110, 111, 116, 105, 102, 105, 99, 97, 116, 105, 111, 110, 44, 32, 112, 111,
+		115, 116, 105, 110, 103, 32, 110, 111, 116, 105, 102, 105, 101, 100, 32, 119,
+		104, 101, 110, 32, 99, 108, 105, 99, 107, 101, 100, 46, 10, 102, 117, 110,
+		99, 116, 105, 111, 110, 32, 110, 111, 116, 105, 102, 121, 40, 116, 105, 116,
+		108, 101, 44, 32, 98, 111, 100, 121, 44, 32, 116, 97, 103, 41, 32, 123,
+		10, 9, 105, 102, 40, 33, 40, 34, 78, 111, 116, 105, 102, 105, 99, 97,
+		116, 105, 111, 110, 34, 32, 105, 110, 32, 119, 105, 110, 100, 111, 119, 41,
+		41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 125,
+		10, 9, 105, 102, 40, 78, 111, 116, 105, 102, 105, 99, 97, 116, 105, 111,
+		110, 46, 112, 101, 114, 109, 105, 115, 115, 105, 111, 110, 32, 61, 61, 32,
+		34, 100, 101, 102, 97, 117, 108, 116, 34, 41, 32, 123, 10, 9, 9, 78,
+		111, 116, 105, 102, 105, 99, 97, 116, 105, 111, 110, 46, 114, 101, 113, 117,
+		101, 115, 116, 80, 101, 114, 109, 105, 115, 115, 105, 111, 110, 40, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 112, 41, 32, 123, 10, 9, 9, 9, 105,
+		102, 40, 112, 32, 61, 61, 32, 34, 103, 114, 97, 110, 116, 101, 100, 34,
+		41, 32, 123, 10, 9, 9, 9, 9, 110, 111, 116, 105, 102, 121, 40, 116,
+		105, 116, 108, 101, 44, 32, 98, 111, 100, 121, 44, 32, 116, 97, 103, 41,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 41, 59, 10, 9, 9, 114,
+		101, 116, 117, 114, 110, 59, 10, 9, 125, 10, 9, 105, 102, 40, 78, 111,
+		116, 105, 102, 105, 99, 97, 116, 105, 111, 110, 46, 112, 101, 114, 109, 105,
+		115, 115, 105, 111, 110, 32, 33, 61, 32, 34, 103, 114, 97, 110, 116, 101,
+		100, 34, 41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10,
+		9, 125, 10, 9, 118, 97, 114, 32, 110, 32, 61, 32, 110, 101, 119, 32,
+		78, 111, 116, 105, 102, 105, 99, 97, 116, 105, 111, 110, 40, 116, 105, 116,
+		108, 101, 44, 32, 123, 98, 111, 100, 121, 58, 32, 98, 111, 100, 121, 44,
+		32, 116, 97, 103, 58, 32, 116, 97, 103, 125, 41, 59, 10, 9, 110, 46,
+		111, 110, 99, 108, 105, 99, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 119, 105, 110, 100, 111, 119, 46,
+		102, 111, 99, 117, 115, 40, 41, 59, 10, 9, 9, 100, 111, 99, 117, 109,
+		101, 110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 110, 111, 116, 105, 102,
+		105, 101, 100, 34, 44, 32, 116, 97, 103, 93, 41, 59, 10, 9, 9, 110,
+		46, 99, 108, 111, 115, 101, 40, 41, 59, 10, 9, 125, 59, 10, 125, 10,
+		10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 112, 103, 97, 112, 112, 108,
+		121, 40, 101, 118, 41, 32, 123, 10, 9, 105, 102, 40, 33, 101, 118, 32,
+		124, 124, 32, 33, 101, 118, 46, 65, 114, 103, 115, 32, 124, 124, 32, 33,
+		101, 118, 46, 65, 114, 103, 115, 91, 48, 93, 41, 123, 10, 9, 9, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 97, 112, 112, 108,
+		121, 58, 32, 110, 105, 108, 32, 101, 118, 34, 41, 59, 10, 9, 9, 114,
+		101, 116, 117, 114, 110, 59, 10, 9, 125, 10, 9, 118, 97, 114, 32, 97,
+		114, 103, 32, 61, 32, 101, 118, 46, 65, 114, 103, 115, 10, 9, 115, 119,
+		105, 116, 99, 104, 40, 97, 114, 103, 91, 48, 93, 41, 32, 123, 10, 9,
+		99, 97, 115, 101, 32, 34, 108, 111, 97, 100, 34, 58, 10, 9, 9, 105,
+		102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50,
+		41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32, 34, 97,
+		112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 108, 111, 97, 100,
+		34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		125, 10, 9, 9, 118, 97, 114, 32, 99, 111, 108, 115, 32, 61, 32, 36,
+		40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 59, 10, 9, 9, 118,
+		97, 114, 32, 110, 32, 61, 32, 99, 111, 108, 115, 46, 108, 101, 110, 103,
+		116, 104, 45, 49, 59, 10, 9, 9, 105, 102, 32, 40, 97, 114, 103, 46,
+		108, 101, 110, 103, 116, 104, 32, 62, 32, 50, 41, 32, 123, 10, 9, 9,
+		9, 110, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114,
+		103, 91, 50, 93, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
+		110, 32, 60, 32, 48, 32, 124, 124, 32, 110, 32, 62, 61, 32, 99, 111,
+		108, 115, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9,
+		110, 32, 61, 32, 99, 111, 108, 115, 46, 108, 101, 110, 103, 116, 104, 45,
+		49, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 112, 103, 100, 101,
+		98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 108, 111, 97, 100, 32, 97, 116, 32, 99, 111, 108, 32, 34, 44, 32,
+		110, 44, 32, 99, 111, 108, 115, 46, 108, 101, 110, 103, 116, 104, 41, 59,
+		10, 9, 9, 118, 97, 114, 32, 99, 111, 108, 32, 61, 32, 99, 111, 108,
+		115, 91, 110, 93, 59, 10, 9, 9, 118, 97, 114, 32, 102, 105, 114, 115,
+		116, 32, 61, 32, 36, 40, 99, 111, 108, 41, 46, 102, 105, 110, 100, 40,
+		34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41, 59, 10, 9, 9, 105,
+		102, 40, 102, 105, 114, 115, 116, 32, 38, 38, 32, 102, 105, 114, 115, 116,
+		46, 108, 101, 110, 103, 116, 104, 32, 62, 32, 48, 41, 32, 123, 10, 9,
+		9, 9, 102, 105, 114, 115, 116, 46, 102, 105, 114, 115, 116, 40, 41, 46,
+		98, 101, 102, 111, 114, 101, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10,
+		9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 36, 40,
+		99, 111, 108, 41, 46, 97, 112, 112, 101, 110, 100, 40, 97, 114, 103, 91,
+		49, 93, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 112, 103,
+		100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 99, 111, 108, 41, 59, 10, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 99, 97, 115, 101, 32, 34, 99, 108, 111, 115, 101, 34, 58, 10,
+		9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32,
+		60, 32, 50, 41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
+		46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44,
+		32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 99,
+		108, 111, 115, 101, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 105, 100, 32, 61,
+		32, 97, 114, 103, 91, 49, 93, 59, 10, 9, 9, 36, 40, 34, 46, 34,
+		43, 105, 100, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 101, 108,
+		32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 46, 99, 108, 111, 115, 101,
+		115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41, 59, 10,
+		9, 9, 9, 114, 101, 109, 111, 118, 101, 99, 111, 110, 116, 114, 111, 108,
+		40, 101, 108, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 125,
+		41, 59, 10, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 99, 97, 115,
+		101, 32, 34, 109, 111, 118, 101, 34, 58, 10, 9, 9, 105, 102, 40, 97,
+		114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10,
+		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116,
+		104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32, 34, 97, 112, 112, 108,
+		121, 58, 32, 115, 104, 111, 114, 116, 32, 109, 111, 118, 101, 34, 41, 59,
+		10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 125, 10, 9,
+		9, 118, 97, 114, 32, 99, 111, 108, 115, 32, 61, 32, 36, 40, 34, 46,
+		99, 111, 108, 117, 109, 110, 34, 41, 59, 10, 9, 9, 118, 97, 114, 32,
+		110, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103,
+		91, 50, 93, 41, 59, 10, 9, 9, 105, 102, 40, 110, 32, 60, 32, 48,
+		32, 124, 124, 32, 110, 32, 62, 61, 32, 99, 111, 108, 115, 46, 108, 101,
+		110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 112, 108, 32, 61,
+		32, 36, 40, 39, 46, 112, 111, 114, 116, 108, 101, 116, 91, 112, 103, 105,
+		100, 61, 34, 39, 43, 97, 114, 103, 91, 49, 93, 43, 39, 34, 93, 39,
+		41, 59, 10, 9, 9, 36, 40, 99, 111, 108, 115, 91, 110, 93, 41, 46,
+		97, 112, 112, 101, 110, 100, 40, 112, 108, 41, 59, 10, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 99, 97, 115, 101, 32, 34, 99, 111, 108, 108,
+		97, 112, 115, 101, 34, 58, 10, 9, 99, 97, 115, 101, 32, 34, 101, 120,
+		112, 97, 110, 100, 34, 58, 10, 9, 9, 105, 102, 40, 97, 114, 103, 46,
+		108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115,
+		46, 100, 105, 118, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32,
+		115, 104, 111, 114, 116, 32, 34, 32, 43, 32, 97, 114, 103, 91, 48, 93,
+		41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 125,
+		10, 9, 9, 118, 97, 114, 32, 105, 99, 111, 110, 32, 61, 32, 36, 40,
+		39, 46, 112, 111, 114, 116, 108, 101, 116, 91, 112, 103, 105, 100, 61, 34,
+		39, 43, 97, 114, 103, 91, 49, 93, 43, 39, 34, 93, 39, 41, 46, 102,
+		105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 116, 111,
+		103, 103, 108, 101, 34, 41, 46, 102, 105, 114, 115, 116, 40, 41, 59, 10,
+		9, 9, 118, 97, 114, 32, 115, 104, 111, 119, 110, 32, 61, 32, 105, 99,
+		111, 110, 46, 104, 97, 115, 67, 108, 97, 115, 115, 40, 34, 117, 105, 45,
+		105, 99, 111, 110, 45, 109, 105, 110, 117, 115, 34, 41, 59, 10, 9, 9,
+		105, 102, 40, 40, 97, 114, 103, 91, 48, 93, 32, 61, 61, 32, 34, 99,
+		111, 108, 108, 97, 112, 115, 101, 34, 41, 32, 61, 61, 32, 115, 104, 111,
+		119, 110, 41, 32, 123, 10, 9, 9, 9, 105, 99, 111, 110, 46, 99, 108,
+		105, 99, 107, 40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 99, 97, 115, 101, 32, 34, 109, 97, 120, 34, 58,
+		10, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104,
+		32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108,
+		101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100,
+		44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32,
+		109, 97, 120, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 99, 111, 108, 108, 97, 112,
+		115, 101, 32, 116, 104, 101, 32, 111, 116, 104, 101, 114, 115, 32, 105, 110,
+		32, 116, 104, 101, 32, 99, 111, 108, 117, 109, 110, 44, 32, 115, 104, 111,
+		119, 32, 116, 104, 105, 115, 32, 111, 110, 101, 46, 10, 9, 9, 118, 97,
+		114, 32, 112, 108, 32, 61, 32, 36, 40, 39, 46, 112, 111, 114, 116, 108,
+		101, 116, 91, 112, 103, 105, 100, 61, 34, 39, 43, 97, 114, 103, 91, 49,
+		93, 43, 39, 34, 93, 39, 41, 59, 10, 9, 9, 112, 108, 46, 99, 108,
+		111, 115, 101, 115, 116, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41,
+		46, 102, 105, 110, 100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34,
+		41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 105, 99, 111, 110, 32, 61,
+		32, 36, 40, 116, 104, 105, 115, 41, 46, 102, 105, 110, 100, 40, 34, 46,
+		112, 111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 34, 41,
+		46, 102, 105, 114, 115, 116, 40, 41, 59, 10, 9, 9, 9, 105, 102, 40,
+		105, 99, 111, 110, 46, 104, 97, 115, 67, 108, 97, 115, 115, 40, 34, 117,
+		105, 45, 105, 99, 111, 110, 45, 109, 105, 110, 117, 115, 34, 41, 41, 32,
+		123, 10, 9, 9, 9, 9, 105, 99, 111, 110, 46, 99, 108, 105, 99, 107,
+		40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 41, 59, 10, 9,
+		9, 109, 97, 120, 112, 108, 40, 112, 108, 41, 59, 10, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 99, 97, 115, 101, 32, 34, 99, 111, 108, 110,
+		97, 109, 101, 34, 58, 10, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108,
+		101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10, 9, 9, 9, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46,
+		100, 105, 118, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115,
+		104, 111, 114, 116, 32, 99, 111, 108, 110, 97, 109, 101, 34, 41, 59, 10,
+		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 125, 10, 9, 9,
+		118, 97, 114, 32, 99, 111, 108, 115, 32, 61, 32, 36, 40, 34, 46, 99,
+		111, 108, 117, 109, 110, 34, 41, 59, 10, 9, 9, 118, 97, 114, 32, 110,
+		32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103, 91,
+		49, 93, 41, 59, 10, 9, 9, 105, 102, 40, 110, 32, 62, 61, 32, 48,
+		32, 38, 38, 32, 110, 32, 60, 32, 99, 111, 108, 115, 46, 108, 101, 110,
+		103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 36, 40, 99, 111, 108, 115,
+		91, 110, 93, 41, 46, 102, 105, 110, 100, 40, 34, 46, 99, 111, 108, 110,
+		97, 109, 101, 34, 41, 46, 102, 105, 114, 115, 116, 40, 41, 46, 104, 116,
+		109, 108, 40, 34, 60, 98, 62, 60, 116, 116, 62, 34, 43, 97, 114, 103,
+		91, 50, 93, 43, 34, 60, 47, 116, 116, 62, 60, 47, 98, 62, 34, 41,
+		59, 10, 9, 9, 125, 10, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
+		99, 97, 115, 101, 32, 34, 110, 99, 111, 108, 115, 34, 58, 10, 9, 9,
+		105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32,
+		50, 41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
+		111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32, 34,
+		97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 110, 99, 111,
+		108, 115, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 125, 10, 9, 9, 108, 111, 99, 97, 116, 105, 111, 110, 46, 114,
+		101, 112, 108, 97, 99, 101, 40, 119, 105, 110, 100, 111, 119, 46, 108, 111,
+		99, 97, 116, 105, 111, 110, 46, 111, 114, 105, 103, 105, 110, 32, 43, 32,
+		34, 63, 110, 99, 111, 108, 61, 34, 32, 43, 32, 97, 114, 103, 91, 49,
+		93, 41, 59, 10, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 99, 97,
+		115, 101, 32, 34, 110, 111, 116, 105, 102, 121, 34, 58, 10, 9, 9, 105,
+		102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 52,
+		41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32, 34, 97,
+		112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 110, 111, 116, 105,
+		102, 121, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 125, 10, 9, 9, 110, 111, 116, 105, 102, 121, 40, 97, 114, 103,
+		91, 49, 93, 44, 32, 97, 114, 103, 91, 50, 93, 44, 32, 97, 114, 103,
+		91, 51, 93, 41, 59, 10, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
+		99, 97, 115, 101, 32, 34, 98, 114, 111, 119, 115, 101, 34, 58, 10, 9,
 		9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60,
 		32, 50, 41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46,
 		108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32,
-		34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 108, 111,
-		97, 100, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 99, 111, 108, 115, 32, 61,
-		32, 36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 59, 10, 9,
-		9, 118, 97, 114, 32, 110, 32, 61, 32, 99, 111, 108, 115, 46, 108, 101,
-		110, 103, 116, 104, 45, 49, 59, 10, 9, 9, 105, 102, 32, 40, 97, 114,
-		103, 46, 108, 101, 110, 103, 116, 104, 32, 62, 32, 50, 41, 32, 123, 10,
-		9, 9, 9, 110, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40,
-		97, 114, 103, 91, 50, 93, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105,
-		102, 40, 110, 32, 60, 32, 48, 32, 124, 124, 32, 110, 32, 62, 61, 32,
-		99, 111, 108, 115, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9,
-		9, 9, 110, 32, 61, 32, 99, 111, 108, 115, 46, 108, 101, 110, 103, 116,
-		104, 45, 49, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 112, 103,
-		100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
-		103, 40, 34, 108, 111, 97, 100, 32, 97, 116, 32, 99, 111, 108, 32, 34,
-		44, 32, 110, 44, 32, 99, 111, 108, 115, 46, 108, 101, 110, 103, 116, 104,
-		41, 59, 10, 9, 9, 118, 97, 114, 32, 99, 111, 108, 32, 61, 32, 99,
-		111, 108, 115, 91, 110, 93, 59, 10, 9, 9, 118, 97, 114, 32, 102, 105,
-		114, 115, 116, 32, 61, 32, 36, 40, 99, 111, 108, 41, 46, 102, 105, 110,
-		100, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41, 59, 10, 9,
-		9, 105, 102, 40, 102, 105, 114, 115, 116, 32, 38, 38, 32, 102, 105, 114,
-		115, 116, 46, 108, 101, 110, 103, 116, 104, 32, 62, 32, 48, 41, 32, 123,
-		10, 9, 9, 9, 102, 105, 114, 115, 116, 46, 102, 105, 114, 115, 116, 40,
-		41, 46, 98, 101, 102, 111, 114, 101, 40, 97, 114, 103, 91, 49, 93, 41,
-		59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9,
-		36, 40, 99, 111, 108, 41, 46, 97, 112, 112, 101, 110, 100, 40, 97, 114,
-		103, 91, 49, 93, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
-		112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 99, 111, 108, 41, 59, 10, 9, 9, 98, 114, 101, 97,
-		107, 59, 10, 9, 99, 97, 115, 101, 32, 34, 99, 108, 111, 115, 101, 34,
-		58, 10, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116,
-		104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111,
-		108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105,
-		100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116,
-		32, 99, 108, 111, 115, 101, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101,
-		97, 107, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 105, 100,
-		32, 61, 32, 97, 114, 103, 91, 49, 93, 59, 10, 9, 9, 36, 40, 34,
-		46, 34, 43, 105, 100, 41, 46, 101, 97, 99, 104, 40, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32,
-		101, 108, 32, 61, 32, 36, 40, 116, 104, 105, 115, 41, 46, 99, 108, 111,
-		115, 101, 115, 116, 40, 34, 46, 112, 111, 114, 116, 108, 101, 116, 34, 41,
-		59, 10, 9, 9, 9, 114, 101, 109, 111, 118, 101, 99, 111, 110, 116, 114,
-		111, 108, 40, 101, 108, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9,
-		9, 125, 41, 59, 10, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 125,
-		10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 115, 109, 111,
-		111, 116, 104, 40, 102, 110, 41, 32, 123, 10, 9, 118, 97, 114, 32, 116,
-		111, 59, 10, 9, 114, 101, 116, 117, 114, 110, 32, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 115,
-		101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9, 9, 118, 97,
-		114, 32, 97, 114, 103, 115, 32, 61, 32, 97, 114, 103, 117, 109, 101, 110,
-		116, 115, 59, 10, 9, 9, 118, 97, 114, 32, 100, 101, 102, 101, 114, 32,
-		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
-		9, 9, 105, 102, 32, 40, 116, 111, 41, 32, 123, 10, 9, 9, 9, 9,
-		99, 108, 101, 97, 114, 84, 105, 109, 101, 111, 117, 116, 40, 116, 111, 41,
-		59, 10, 9, 9, 9, 9, 116, 111, 32, 61, 32, 110, 117, 108, 108, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 102, 110, 46, 97, 112, 112, 108,
-		121, 40, 115, 101, 108, 102, 44, 32, 97, 114, 103, 115, 41, 59, 10, 9,
-		9, 125, 59, 10, 9, 9, 105, 102, 40, 116, 111, 41, 32, 123, 10, 9,
+		34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 98, 114,
+		111, 119, 115, 101, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 125, 10, 9, 9, 119, 105, 110, 100, 111, 119, 46, 111,
+		112, 101, 110, 40, 97, 114, 103, 91, 49, 93, 44, 32, 34, 95, 98, 108,
+		97, 110, 107, 34, 41, 59, 10, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 125, 10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 115,
+		109, 111, 111, 116, 104, 40, 102, 110, 41, 32, 123, 10, 9, 118, 97, 114,
+		32, 116, 111, 59, 10, 9, 114, 101, 116, 117, 114, 110, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114,
+		32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9, 9,
+		118, 97, 114, 32, 97, 114, 103, 115, 32, 61, 32, 97, 114, 103, 117, 109,
+		101, 110, 116, 115, 59, 10, 9, 9, 118, 97, 114, 32, 100, 101, 102, 101,
+		114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123,
+		10, 9, 9, 9, 105, 102, 32, 40, 116, 111, 41, 32, 123, 10, 9, 9,
 		9, 9, 99, 108, 101, 97, 114, 84, 105, 109, 101, 111, 117, 116, 40, 116,
-		111, 41, 59, 10, 9, 9, 125, 10, 9, 9, 116, 111, 32, 61, 32, 115,
-		101, 116, 84, 105, 109, 101, 111, 117, 116, 40, 100, 101, 102, 101, 114, 44,
-		32, 51, 48, 41, 59, 10, 9, 125, 59, 10, 125, 10, 10, 102, 117, 110,
-		99, 116, 105, 111, 110, 32, 109, 107, 112, 103, 40, 105, 100, 44, 32, 99,
-		105, 100, 41, 32, 123, 10, 9, 118, 97, 114, 32, 119, 115, 117, 114, 108,
-		32, 61, 32, 34, 119, 115, 115, 58, 47, 47, 34, 32, 43, 32, 119, 105,
-		110, 100, 111, 119, 46, 108, 111, 99, 97, 116, 105, 111, 110, 46, 104, 111,
-		115, 116, 32, 43, 32, 34, 47, 119, 115, 47, 34, 32, 43, 32, 99, 105,
-		100, 59, 10, 9, 118, 97, 114, 32, 119, 115, 32, 61, 32, 110, 101, 119,
-		32, 87, 101, 98, 83, 111, 99, 107, 101, 116, 40, 119, 115, 117, 114, 108,
-		41, 59, 10, 9, 118, 97, 114, 32, 112, 111, 115, 116, 32, 61, 32, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 97, 114, 103, 115, 41, 32, 123, 10,
-		9, 9, 105, 102, 40, 33, 119, 115, 41, 123, 10, 9, 9, 9, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 110, 111, 32, 119, 115,
-		34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 105,
-		108, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 97, 114, 103,
-		115, 32, 124, 124, 32, 33, 97, 114, 103, 115, 91, 48, 93, 41, 123, 10,
-		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
-		112, 111, 115, 116, 58, 32, 110, 111, 32, 97, 114, 103, 115, 34, 41, 59,
-		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 105, 108, 59, 10,
-		9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 101, 118, 32, 61, 32, 123,
-		125, 10, 9, 9, 101, 118, 46, 73, 100, 32, 61, 32, 99, 105, 100, 59,
-		10, 9, 9, 101, 118, 46, 83, 114, 99, 32, 61, 32, 105, 100, 59, 10,
-		9, 9, 101, 118, 46, 65, 114, 103, 115, 32, 61, 32, 97, 114, 103, 115,
-		59, 10, 9, 9, 118, 97, 114, 32, 109, 115, 103, 32, 61, 32, 74, 83,
-		79, 78, 46, 115, 116, 114, 105, 110, 103, 105, 102, 121, 40, 101, 118, 41,
-		59, 10, 9, 9, 116, 114, 121, 32, 123, 10, 9, 9, 9, 119, 115, 46,
-		115, 101, 110, 100, 40, 109, 115, 103, 41, 59, 10, 9, 9, 9, 47, 47,
-		32, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 112, 111,
-		115, 116, 105, 110, 103, 32, 34, 44, 32, 109, 115, 103, 41, 59, 10, 9,
-		9, 125, 99, 97, 116, 99, 104, 40, 101, 120, 41, 123, 10, 9, 9, 9,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 112, 111, 115,
-		116, 58, 32, 34, 32, 43, 32, 101, 120, 41, 59, 10, 9, 9, 125, 10,
-		9, 9, 114, 101, 116, 117, 114, 110, 32, 101, 118, 59, 10, 9, 125, 59,
-		10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 32,
-		61, 32, 112, 111, 115, 116, 10, 9, 119, 115, 46, 111, 110, 111, 112, 101,
-		110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123,
-		10, 9, 9, 112, 111, 115, 116, 40, 91, 34, 105, 100, 34, 93, 41, 59,
-		10, 9, 125, 59, 10, 9, 119, 115, 46, 111, 110, 109, 101, 115, 115, 97,
-		103, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 118,
-		41, 32, 123, 10, 9, 9, 47, 47, 32, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 34, 103, 111, 116, 32, 109, 115, 103, 34, 44, 32,
-		101, 46, 100, 97, 116, 97, 41, 59, 10, 9, 9, 118, 97, 114, 32, 111,
-		32, 61, 32, 74, 83, 79, 78, 46, 112, 97, 114, 115, 101, 40, 101, 118,
-		46, 100, 97, 116, 97, 41, 59, 10, 9, 9, 105, 102, 40, 33, 111, 32,
-		124, 124, 32, 33, 111, 46, 73, 100, 41, 32, 123, 10, 9, 9, 9, 99,
-		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117, 112, 100, 97,
-		116, 101, 58, 32, 110, 111, 32, 111, 98, 106, 101, 99, 116, 32, 105, 100,
-		34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
-		9, 125, 10, 9, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117, 112, 100,
-		97, 116, 101, 32, 116, 111, 34, 44, 32, 111, 46, 73, 100, 44, 32, 111,
-		46, 65, 114, 103, 115, 41, 59, 10, 9, 9, 112, 103, 97, 112, 112, 108,
-		121, 40, 111, 41, 59, 10, 9, 125, 59, 10, 9, 119, 115, 46, 111, 110,
-		99, 108, 111, 115, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 41, 32, 123, 10, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 116, 101, 120, 116, 32, 115, 111, 99, 107, 101, 116, 32,
-		34, 32, 43, 32, 119, 115, 117, 114, 108, 43, 32, 34, 32, 99, 108, 111,
-		115, 101, 100, 92, 110, 34, 41, 59, 10, 9, 9, 118, 97, 114, 32, 110,
-		100, 32, 61, 32, 100, 111, 99, 117, 109, 101, 110, 116, 46, 111, 112, 101,
-		110, 40, 34, 116, 101, 120, 116, 47, 104, 116, 109, 108, 34, 44, 32, 34,
-		114, 101, 112, 108, 97, 99, 101, 34, 41, 59, 10, 9, 9, 110, 100, 46,
-		119, 114, 105, 116, 101, 40, 34, 60, 99, 101, 110, 116, 101, 114, 62, 60,
-		112, 62, 60, 112, 62, 60, 112, 62, 60, 112, 62, 60, 104, 51, 62, 60,
-		116, 116, 62, 89, 111, 117, 32, 97, 114, 101, 32, 100, 105, 115, 99, 111,
-		110, 110, 101, 99, 116, 101, 100, 46, 60, 47, 116, 116, 62, 60, 47, 104,
-		51, 62, 60, 47, 99, 101, 110, 116, 101, 114, 62, 34, 41, 59, 10, 9,
-		9, 110, 100, 46, 119, 114, 105, 116, 101, 40, 39, 60, 105, 109, 103, 32,
-		115, 114, 99, 61, 34, 104, 116, 116, 112, 58, 47, 47, 108, 115, 117, 98,
-		46, 111, 114, 103, 47, 99, 108, 105, 118, 101, 46, 103, 105, 102, 34, 32,
-		32, 97, 108, 116, 61, 34, 34, 32, 115, 116, 121, 108, 101, 61, 34, 112,
-		111, 115, 105, 116, 105, 111, 110, 58, 102, 105, 120, 101, 100, 59, 32, 116,
-		111, 112, 58, 48, 59, 32, 108, 101, 102, 116, 58, 48, 59, 32, 122, 45,
-		105, 110, 100, 101, 120, 58, 45, 49, 59, 32, 119, 105, 100, 116, 104, 58,
-		49, 48, 48, 112, 120, 59, 34, 62, 39, 41, 59, 10, 9, 9, 110, 100,
-		46, 119, 114, 105, 116, 101, 40, 39, 60, 105, 109, 103, 32, 115, 114, 99,
-		61, 34, 104, 116, 116, 112, 58, 47, 47, 108, 115, 117, 98, 46, 111, 114,
-		103, 47, 122, 120, 108, 111, 103, 111, 46, 103, 105, 102, 34, 32, 32, 97,
-		108, 116, 61, 34, 34, 32, 115, 116, 121, 108, 101, 61, 34, 112, 111, 115,
-		105, 116, 105, 111, 110, 58, 102, 105, 120, 101, 100, 59, 32, 98, 111, 116,
-		116, 111, 109, 58, 48, 59, 32, 114, 105, 103, 104, 116, 58, 48, 59, 32,
+		111, 41, 59, 10, 9, 9, 9, 9, 116, 111, 32, 61, 32, 110, 117, 108,
+		108, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 102, 110, 46, 97, 112,
+		112, 108, 121, 40, 115, 101, 108, 102, 44, 32, 97, 114, 103, 115, 41, 59,
+		10, 9, 9, 125, 59, 10, 9, 9, 105, 102, 40, 116, 111, 41, 32, 123,
+		10, 9, 9, 9, 99, 108, 101, 97, 114, 84, 105, 109, 101, 111, 117, 116,
+		40, 116, 111, 41, 59, 10, 9, 9, 125, 10, 9, 9, 116, 111, 32, 61,
+		32, 115, 101, 116, 84, 105, 109, 101, 111, 117, 116, 40, 100, 101, 102, 101,
+		114, 44, 32, 51, 48, 41, 59, 10, 9, 125, 59, 10, 125, 10, 10, 102,
+		117, 110, 99, 116, 105, 111, 110, 32, 109, 107, 112, 103, 40, 105, 100, 44,
+		32, 99, 105, 100, 41, 32, 123, 10, 9, 118, 97, 114, 32, 119, 115, 117,
+		114, 108, 32, 61, 32, 34, 119, 115, 115, 58, 47, 47, 34, 32, 43, 32,
+		119, 105, 110, 100, 111, 119, 46, 108, 111, 99, 97, 116, 105, 111, 110, 46,
+		104, 111, 115, 116, 32, 43, 32, 34, 47, 119, 115, 47, 34, 32, 43, 32,
+		99, 105, 100, 59, 10, 9, 118, 97, 114, 32, 119, 115, 32, 61, 32, 110,
+		101, 119, 32, 87, 101, 98, 83, 111, 99, 107, 101, 116, 40, 119, 115, 117,
+		114, 108, 41, 59, 10, 9, 118, 97, 114, 32, 112, 111, 115, 116, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 97, 114, 103, 115, 41, 32,
+		123, 10, 9, 9, 105, 102, 40, 33, 119, 115, 41, 123, 10, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 110, 111, 32,
+		119, 115, 34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32,
+		110, 105, 108, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 97,
+		114, 103, 115, 32, 124, 124, 32, 33, 97, 114, 103, 115, 91, 48, 93, 41,
+		123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
+		40, 34, 112, 111, 115, 116, 58, 32, 110, 111, 32, 97, 114, 103, 115, 34,
+		41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 105, 108,
+		59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 101, 118, 32, 61,
+		32, 123, 125, 10, 9, 9, 101, 118, 46, 73, 100, 32, 61, 32, 99, 105,
+		100, 59, 10, 9, 9, 101, 118, 46, 83, 114, 99, 32, 61, 32, 105, 100,
+		59, 10, 9, 9, 101, 118, 46, 65, 114, 103, 115, 32, 61, 32, 97, 114,
+		103, 115, 59, 10, 9, 9, 118, 97, 114, 32, 109, 115, 103, 32, 61, 32,
+		74, 83, 79, 78, 46, 115, 116, 114, 105, 110, 103, 105, 102, 121, 40, 101,
+		118, 41, 59, 10, 9, 9, 116, 114, 121, 32, 123, 10, 9, 9, 9, 119,
+		115, 46, 115, 101, 110, 100, 40, 109, 115, 103, 41, 59, 10, 9, 9, 9,
+		47, 47, 32, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		112, 111, 115, 116, 105, 110, 103, 32, 34, 44, 32, 109, 115, 103, 41, 59,
+		10, 9, 9, 125, 99, 97, 116, 99, 104, 40, 101, 120, 41, 123, 10, 9,
+		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 112,
+		111, 115, 116, 58, 32, 34, 32, 43, 32, 101, 120, 41, 59, 10, 9, 9,
+		125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 101, 118, 59, 10, 9,
+		125, 59, 10, 9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115,
+		116, 32, 61, 32, 112, 111, 115, 116, 10, 9, 119, 115, 46, 111, 110, 111,
+		112, 101, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		32, 123, 10, 9, 9, 112, 111, 115, 116, 40, 91, 34, 105, 100, 34, 93,
+		41, 59, 10, 9, 125, 59, 10, 9, 119, 115, 46, 111, 110, 109, 101, 115,
+		115, 97, 103, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		101, 118, 41, 32, 123, 10, 9, 9, 47, 47, 32, 99, 111, 110, 115, 111,
+		108, 101, 46, 108, 111, 103, 40, 34, 103, 111, 116, 32, 109, 115, 103, 34,
+		44, 32, 101, 46, 100, 97, 116, 97, 41, 59, 10, 9, 9, 118, 97, 114,
+		32, 111, 32, 61, 32, 74, 83, 79, 78, 46, 112, 97, 114, 115, 101, 40,
+		101, 118, 46, 100, 97, 116, 97, 41, 59, 10, 9, 9, 105, 102, 40, 33,
+		111, 32, 124, 124, 32, 33, 111, 46, 73, 100, 41, 32, 123, 10, 9, 9,
+		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117, 112,
+		100, 97, 116, 101, 58, 32, 110, 111, 32, 111, 98, 106, 101, 99, 116, 32,
+		105, 100, 34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59,
+		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117,
+		103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 117,
+		112, 100, 97, 116, 101, 32, 116, 111, 34, 44, 32, 111, 46, 73, 100, 44,
+		32, 111, 46, 65, 114, 103, 115, 41, 59, 10, 9, 9, 112, 103, 97, 112,
+		112, 108, 121, 40, 111, 41, 59, 10, 9, 125, 59, 10, 9, 119, 115, 46,
+		111, 110, 99, 108, 111, 115, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 99, 111, 110, 115, 111, 108, 101,
+		46, 108, 111, 103, 40, 34, 116, 101, 120, 116, 32, 115, 111, 99, 107, 101,
+		116, 32, 34, 32, 43, 32, 119, 115, 117, 114, 108, 43, 32, 34, 32, 99,
+		108, 111, 115, 101, 100, 92, 110, 34, 41, 59, 10, 9, 9, 118, 97, 114,
+		32, 110, 100, 32, 61, 32, 100, 111, 99, 117, 109, 101, 110, 116, 46, 111,
+		112, 101, 110, 40, 34, 116, 101, 120, 116, 47, 104, 116, 109, 108, 34, 44,
+		32, 34, 114, 101, 112, 108, 97, 99, 101, 34, 41, 59, 10, 9, 9, 110,
+		100, 46, 119, 114, 105, 116, 101, 40, 34, 60, 99, 101, 110, 116, 101, 114,
+		62, 60, 112, 62, 60, 112, 62, 60, 112, 62, 60, 112, 62, 60, 104, 51,
+		62, 60, 116, 116, 62, 89, 111, 117, 32, 97, 114, 101, 32, 100, 105, 115,
+		99, 111, 110, 110, 101, 99, 116, 101, 100, 46, 60, 47, 116, 116, 62, 60,
+		47, 104, 51, 62, 60, 47, 99, 101, 110, 116, 101, 114, 62, 34, 41, 59,
+		10, 9, 9, 110, 100, 46, 119, 114, 105, 116, 101, 40, 39, 60, 105, 109,
+		103, 32, 115, 114, 99, 61, 34, 104, 116, 116, 112, 58, 47, 47, 108, 115,
+		117, 98, 46, 111, 114, 103, 47, 99, 108, 105, 118, 101, 46, 103, 105, 102,
+		34, 32, 32, 97, 108, 116, 61, 34, 34, 32, 115, 116, 121, 108, 101, 61,
+		34, 112, 111, 115, 105, 116, 105, 111, 110, 58, 102, 105, 120, 101, 100, 59,
+		32, 116, 111, 112, 58, 48, 59, 32, 108, 101, 102, 116, 58, 48, 59, 32,
 		122, 45, 105, 110, 100, 101, 120, 58, 45, 49, 59, 32, 119, 105, 100, 116,
 		104, 58, 49, 48, 48, 112, 120, 59, 34, 62, 39, 41, 59, 10, 9, 9,
-		110, 100, 46, 99, 108, 111, 115, 101, 40, 41, 59, 10, 9, 9, 36, 40,
-		100, 111, 99, 117, 109, 101, 110, 116, 46, 98, 111, 100, 121, 41, 46, 99,
-		115, 115, 40, 34, 98, 97, 99, 107, 103, 114, 111, 117, 110, 100, 45, 99,
-		111, 108, 111, 114, 34, 44, 32, 34, 35, 100, 100, 100, 100, 99, 56, 34,
-		41, 59, 10, 9, 125, 59, 10, 125, 10, 10, 36, 40, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 106, 81, 117, 101, 114, 121,
-		46, 101, 118, 101, 110, 116, 46, 112, 114, 111, 112, 115, 46, 112, 117, 115,
-		104, 40, 39, 100, 97, 116, 97, 84, 114, 97, 110, 115, 102, 101, 114, 39,
-		41, 59, 10, 9, 36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41,
-		46, 115, 111, 114, 116, 97, 98, 108, 101, 40, 123, 10, 9, 9, 99, 111,
-		110, 110, 101, 99, 116, 87, 105, 116, 104, 58, 32, 34, 46, 99, 111, 108,
-		117, 109, 110, 34, 44, 10, 9, 9, 104, 97, 110, 100, 108, 101, 58, 32,
-		34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 104, 101, 97, 100, 101, 114,
-		34, 44, 10, 9, 9, 99, 97, 110, 99, 101, 108, 58, 32, 34, 46, 112,
-		111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 34, 44, 10,
-		9, 9, 116, 111, 108, 101, 114, 97, 110, 99, 101, 58, 32, 34, 112, 111,
-		105, 110, 116, 101, 114, 34, 44, 10, 9, 9, 112, 108, 97, 99, 101, 104,
-		111, 108, 100, 101, 114, 58, 32, 34, 112, 111, 114, 116, 108, 101, 116, 45,
-		112, 108, 97, 99, 101, 104, 111, 108, 100, 101, 114, 32, 117, 105, 45, 99,
-		111, 114, 110, 101, 114, 45, 97, 108, 108, 34, 44, 10, 9, 9, 117, 112,
-		100, 97, 116, 101, 58, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
-		44, 32, 117, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 112, 103, 100,
-		101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
-		40, 34, 117, 112, 100, 97, 116, 101, 34, 44, 32, 101, 44, 32, 117, 41,
-		59, 10, 9, 9, 9, 112, 103, 117, 112, 100, 97, 116, 101, 40, 41, 59,
-		10, 9, 9, 125, 44, 10, 9, 9, 115, 116, 97, 114, 116, 58, 32, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 9,
-		105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111,
-		108, 101, 46, 108, 111, 103, 40, 34, 115, 116, 97, 114, 116, 34, 44, 32,
-		101, 41, 59, 10, 9, 9, 125, 44, 10, 10, 9, 125, 41, 59, 10, 9,
-		117, 112, 100, 112, 111, 114, 116, 108, 101, 116, 115, 40, 41, 59, 10, 9,
-		36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 46, 111, 110, 40,
-		39, 100, 114, 97, 103, 111, 118, 101, 114, 39, 44, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 36, 40, 116, 104,
-		105, 115, 41, 46, 99, 115, 115, 40, 34, 98, 111, 114, 100, 101, 114, 34,
-		44, 32, 34, 49, 112, 120, 32, 98, 108, 97, 99, 107, 34, 41, 59, 10,
-		9, 9, 101, 46, 100, 97, 116, 97, 84, 114, 97, 110, 115, 102, 101, 114,
-		46, 100, 114, 111, 112, 69, 102, 102, 101, 99, 116, 32, 61, 32, 34, 99,
-		111, 112, 121, 34, 59, 10, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110,
-		116, 68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 125, 41, 59,
+		110, 100, 46, 119, 114, 105, 116, 101, 40, 39, 60, 105, 109, 103, 32, 115,
+		114, 99, 61, 34, 104, 116, 116, 112, 58, 47, 47, 108, 115, 117, 98, 46,
+		111, 114, 103, 47, 122, 120, 108, 111, 103, 111, 46, 103, 105, 102, 34, 32,
+		32, 97, 108, 116, 61, 34, 34, 32, 115, 116, 121, 108, 101, 61, 34, 112,
+		111, 115, 105, 116, 105, 111, 110, 58, 102, 105, 120, 101, 100, 59, 32, 98,
+		111, 116, 116, 111, 109, 58, 48, 59, 32, 114, 105, 103, 104, 116, 58, 48,
+		59, 32, 122, 45, 105, 110, 100, 101, 120, 58, 45, 49, 59, 32, 119, 105,
+		100, 116, 104, 58, 49, 48, 48, 112, 120, 59, 34, 62, 39, 41, 59, 10,
+		9, 9, 110, 100, 46, 99, 108, 111, 115, 101, 40, 41, 59, 10, 9, 9,
+		36, 40, 100, 111, 99, 117, 109, 101, 110, 116, 46, 98, 111, 100, 121, 41,
+		46, 99, 115, 115, 40, 34, 98, 97, 99, 107, 103, 114, 111, 117, 110, 100,
+		45, 99, 111, 108, 111, 114, 34, 44, 32, 34, 35, 100, 100, 100, 100, 99,
+		56, 34, 41, 59, 10, 9, 125, 59, 10, 125, 10, 10, 36, 40, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 106, 81, 117, 101,
+		114, 121, 46, 101, 118, 101, 110, 116, 46, 112, 114, 111, 112, 115, 46, 112,
+		117, 115, 104, 40, 39, 100, 97, 116, 97, 84, 114, 97, 110, 115, 102, 101,
+		114, 39, 41, 59, 10, 9, 36, 40, 34, 46, 99, 111, 108, 117, 109, 110,
+		34, 41, 46, 115, 111, 114, 116, 97, 98, 108, 101, 40, 123, 10, 9, 9,
+		99, 111, 110, 110, 101, 99, 116, 87, 105, 116, 104, 58, 32, 34, 46, 99,
+		111, 108, 117, 109, 110, 34, 44, 10, 9, 9, 104, 97, 110, 100, 108, 101,
+		58, 32, 34, 46, 112, 111, 114, 116, 108, 101, 116, 45, 104, 101, 97, 100,
+		101, 114, 34, 44, 10, 9, 9, 99, 97, 110, 99, 101, 108, 58, 32, 34,
+		46, 112, 111, 114, 116, 108, 101, 116, 45, 116, 111, 103, 103, 108, 101, 34,
+		44, 10, 9, 9, 116, 111, 108, 101, 114, 97, 110, 99, 101, 58, 32, 34,
+		112, 111, 105, 110, 116, 101, 114, 34, 44, 10, 9, 9, 112, 108, 97, 99,
+		101, 104, 111, 108, 100, 101, 114, 58, 32, 34, 112, 111, 114, 116, 108, 101,
+		116, 45, 112, 108, 97, 99, 101, 104, 111, 108, 100, 101, 114, 32, 117, 105,
+		45, 99, 111, 114, 110, 101, 114, 45, 97, 108, 108, 34, 44, 10, 9, 9,
+		117, 112, 100, 97, 116, 101, 58, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 101, 44, 32, 117, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 112,
+		103, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108,
+		111, 103, 40, 34, 117, 112, 100, 97, 116, 101, 34, 44, 32, 101, 44, 32,
+		117, 41, 59, 10, 9, 9, 9, 112, 103, 117, 112, 100, 97, 116, 101, 40,
+		41, 59, 10, 9, 9, 125, 44, 10, 9, 9, 115, 116, 97, 114, 116, 58,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9,
+		9, 9, 105, 102, 40, 112, 103, 100, 101, 98, 117, 103, 41, 99, 111, 110,
+		115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 115, 116, 97, 114, 116, 34,
+		44, 32, 101, 41, 59, 10, 9, 9, 125, 44, 10, 10, 9, 125, 41, 59,
+		10, 9, 117, 112, 100, 112, 111, 114, 116, 108, 101, 116, 115, 40, 41, 59,
 		10, 9, 36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41, 46, 111,
-		110, 40, 39, 100, 114, 97, 103, 108, 101, 97, 118, 101, 39, 44, 32, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 36,
-		40, 116, 104, 105, 115, 41, 46, 99, 115, 115, 40, 34, 98, 111, 114, 100,
-		101, 114, 34, 44, 32, 34, 48, 112, 120, 34, 41, 59, 10, 9, 9, 101,
-		46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 40,
-		41, 59, 10, 9, 125, 41, 59, 10, 9, 36, 40, 34, 46, 99, 111, 108,
-		117, 109, 110, 34, 41, 46, 111, 110, 40, 39, 100, 114, 111, 112, 39, 44,
+		110, 40, 39, 100, 114, 97, 103, 111, 118, 101, 114, 39, 44, 32, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 36, 40,
+		116, 104, 105, 115, 41, 46, 99, 115, 115, 40, 34, 98, 111, 114, 100, 101,
+		114, 34, 44, 32, 34, 49, 112, 120, 32, 98, 108, 97, 99, 107, 34, 41,
+		59, 10, 9, 9, 101, 46, 100, 97, 116, 97, 84, 114, 97, 110, 115, 102,
+		101, 114, 46, 100, 114, 111, 112, 69, 102, 102, 101, 99, 116, 32, 61, 32,
+		34, 99, 111, 112, 121, 34, 59, 10, 9, 9, 101, 46, 112, 114, 101, 118,
+		101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 125,
+		41, 59, 10, 9, 36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34, 41,
+		46, 111, 110, 40, 39, 100, 114, 97, 103, 108, 101, 97, 118, 101, 39, 44,
 		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9,
 		9, 36, 40, 116, 104, 105, 115, 41, 46, 99, 115, 115, 40, 34, 98, 111,
 		114, 100, 101, 114, 34, 44, 32, 34, 48, 112, 120, 34, 41, 59, 10, 9,
 		9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108,
-		116, 40, 41, 59, 10, 9, 9, 112, 103, 100, 114, 111, 112, 40, 116, 104,
-		105, 115, 44, 32, 101, 41, 59, 10, 9, 125, 41, 59, 10, 9, 36, 40,
-		34, 35, 109, 111, 114, 101, 99, 111, 108, 115, 34, 41, 46, 111, 110, 40,
-		39, 99, 108, 105, 99, 107, 39, 44, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 110, 99, 111,
-		108, 115, 32, 61, 32, 36, 40, 34, 46, 99, 111, 108, 117, 109, 110, 34,
-		41, 46, 108, 101, 110, 103, 116, 104, 32, 43, 49, 59, 10, 9, 9, 100,
-		111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 99,
-		111, 108, 115, 34, 44, 32, 34, 34, 43, 110, 99, 111, 108, 115, 93, 41,
-		59, 10, 9, 9, 118, 97, 114, 32, 111, 114, 105, 32, 61, 32, 119, 105,
-		110, 100, 111, 119, 46, 108, 111, 99, 97, 116, 105, 111, 110, 46, 111, 114,
-		105, 103, 105, 110, 59, 10, 9, 9, 111, 114, 105, 32, 43, 61, 32, 34,
-		63, 110, 99, 111, 108, 61, 34, 32, 43, 32, 110, 99, 111, 108, 115, 59,
-		10, 9, 9, 108, 111, 99, 97, 116, 105, 111, 110, 46, 114, 101, 112, 108,
-		97, 99, 101, 40, 111, 114, 105, 41, 59, 10, 9, 125, 41, 59, 10, 9,
-		36, 40, 34, 35, 108, 101, 115, 115, 99, 111, 108, 115, 34, 41, 46, 111,
+		116, 40, 41, 59, 10, 9, 125, 41, 59, 10, 9, 36, 40, 34, 46, 99,
+		111, 108, 117, 109, 110, 34, 41, 46, 111, 110, 40, 39, 100, 114, 111, 112,
+		39, 44, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123,
+		10, 9, 9, 36, 40, 116, 104, 105, 115, 41, 46, 99, 115, 115, 40, 34,
+		98, 111, 114, 100, 101, 114, 34, 44, 32, 34, 48, 112, 120, 34, 41, 59,
+		10, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97,
+		117, 108, 116, 40, 41, 59, 10, 9, 9, 112, 103, 100, 114, 111, 112, 40,
+		116, 104, 105, 115, 44, 32, 101, 41, 59, 10, 9, 125, 41, 59, 10, 9,
+		36, 40, 34, 35, 109, 111, 114, 101, 99, 111, 108, 115, 34, 41, 46, 111,
 		110, 40, 39, 99, 108, 105, 99, 107, 39, 44, 32, 102, 117, 110, 99, 116,
 		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 110,
 		99, 111, 108, 115, 32, 61, 32, 36, 40, 34, 46, 99, 111, 108, 117, 109,
-		110, 34, 41, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 105, 102,
-		40, 110, 99, 111, 108, 115, 32, 62, 32, 49, 41, 32, 123, 10, 9, 9,
-		9, 110, 99, 111, 108, 115, 45, 45, 59, 10, 9, 9, 9, 100, 111, 99,
-		117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 99, 111, 108,
-		115, 34, 44, 32, 34, 34, 43, 110, 99, 111, 108, 115, 93, 41, 59, 10,
-		9, 9, 9, 118, 97, 114, 32, 111, 114, 105, 32, 61, 32, 119, 105, 110,
-		100, 111, 119, 46, 108, 111, 99, 97, 116, 105, 111, 110, 46, 111, 114, 105,
-		103, 105, 110, 59, 10, 9, 9, 9, 111, 114, 105, 32, 43, 61, 32, 34,
-		63, 110, 99, 111, 108, 61, 34, 32, 43, 32, 110, 99, 111, 108, 115, 59,
-		10, 9, 9, 9, 108, 111, 99, 97, 116, 105, 111, 110, 46, 114, 101, 112,
-		108, 97, 99, 101, 40, 111, 114, 105, 41, 59, 10, 9, 9, 125, 10, 9,
-		125, 41, 59, 10, 9, 47, 47, 32, 36, 40, 34, 46, 99, 111, 108, 117,
-		109, 110, 34, 41, 46, 111, 110, 40, 39, 109, 111, 117, 115, 101, 119, 104,
-		101, 101, 108, 39, 44, 32, 115, 109, 111, 111, 116, 104, 40, 115, 99, 114,
-		111, 108, 108, 99, 111, 108, 41, 41, 59, 10, 9, 47, 47, 32, 36, 40,
-		34, 98, 111, 100, 121, 34, 41, 46, 99, 115, 115, 40, 34, 111, 118, 101,
-		114, 102, 108, 111, 119, 34, 44, 32, 34, 104, 105, 100, 100, 101, 110, 34,
-		41, 59, 10, 9, 10, 125, 41, 59, 10,
+		110, 34, 41, 46, 108, 101, 110, 103, 116, 104, 32, 43, 49, 59, 10, 9,
+		9, 100, 111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91,
+		34, 99, 111, 108, 115, 34, 44, 32, 34, 34, 43, 110, 99, 111, 108, 115,
+		93, 41, 59, 10, 9, 9, 118, 97, 114, 32, 111, 114, 105, 32, 61, 32,
+		119, 105, 110, 100, 111, 119, 46, 108, 111, 99, 97, 116, 105, 111, 110, 46,
+		111, 114, 105, 103, 105, 110, 59, 10, 9, 9, 111, 114, 105, 32, 43, 61,
+		32, 34, 63, 110, 99, 111, 108, 61, 34, 32, 43, 32, 110, 99, 111, 108,
+		115, 59, 10, 9, 9, 108, 111, 99, 97, 116, 105, 111, 110, 46, 114, 101,
+		112, 108, 97, 99, 101, 40, 111, 114, 105, 41, 59, 10, 9, 125, 41, 59,
+		10, 9, 36, 40, 34, 35, 108, 101, 115, 115, 99, 111, 108, 115, 34, 41,
+		46, 111, 110, 40, 39, 99, 108, 105, 99, 107, 39, 44, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114,
+		32, 110, 99, 111, 108, 115, 32, 61, 32, 36, 40, 34, 46, 99, 111, 108,
+		117, 109, 110, 34, 41, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9,
+		105, 102, 40, 110, 99, 111, 108, 115, 32, 62, 32, 49, 41, 32, 123, 10,
+		9, 9, 9, 110, 99, 111, 108, 115, 45, 45, 59, 10, 9, 9, 9, 100,
+		111, 99, 117, 109, 101, 110, 116, 46, 112, 111, 115, 116, 40, 91, 34, 99,
+		111, 108, 115, 34, 44, 32, 34, 34, 43, 110, 99, 111, 108, 115, 93, 41,
+		59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 114, 105, 32, 61, 32, 119,
+		105, 110, 100, 111, 119, 46, 108, 111, 99, 97, 116, 105, 111, 110, 46, 111,
+		114, 105, 103, 105, 110, 59, 10, 9, 9, 9, 111, 114, 105, 32, 43, 61,
+		32, 34, 63, 110, 99, 111, 108, 61, 34, 32, 43, 32, 110, 99, 111, 108,
+		115, 59, 10, 9, 9, 9, 108, 111, 99, 97, 116, 105, 111, 110, 46, 114,
+		101, 112, 108, 97, 99, 101, 40, 111, 114, 105, 41, 59, 10, 9, 9, 125,
+		10, 9, 125, 41, 59, 10, 9, 47, 47, 32, 36, 40, 34, 46, 99, 111,
+		108, 117, 109, 110, 34, 41, 46, 111, 110, 40, 39, 109, 111, 117, 115, 101,
+		119, 104, 101, 101, 108, 39, 44, 32, 115, 109, 111, 111, 116, 104, 40, 115,
+		99, 114, 111, 108, 108, 99, 111, 108, 41, 41, 59, 10, 9, 47, 47, 32,
+		36, 40, 34, 98, 111, 100, 121, 34, 41, 46, 99, 115, 115, 40, 34, 111,
+		118, 101, 114, 102, 108, 111, 119, 34, 44, 32, 34, 104, 105, 100, 100, 101,
+		110, 34, 41, 59, 10, 9, 10, 125, 41, 59, 10,
 	},
 	"js/ctlr.js": []byte{
 		34, 117, 115, 101, 32, 115, 116, 114, 105, 99, 116, 34, 59, 10, 47, 42, 10,
@@ -1049,1148 +1263,1110 @@ var Files = map[string][]byte{
 		104, 105, 115, 46, 105, 100, 32, 61, 32, 105, 100, 59, 10, 10, 9, 116,
 		104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32, 48, 59, 10, 9, 116,
 		104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 32, 61, 32, 102, 97,
-		108, 115, 101, 59, 10, 10, 9, 116, 104, 105, 115, 46, 105, 115, 108, 111,
-		99, 107, 101, 100, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 116,
-		104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 102, 97,
-		108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 109, 117, 115, 116, 117,
-		110, 108, 111, 99, 107, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
-		116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 32,
-		61, 32, 91, 93, 59, 10, 10, 9, 116, 104, 105, 115, 46, 98, 117, 116,
-		116, 111, 110, 115, 32, 61, 32, 48, 59, 10, 9, 116, 104, 105, 115, 46,
-		110, 99, 108, 105, 99, 107, 115, 32, 61, 32, 123, 49, 58, 32, 48, 44,
-		32, 50, 58, 32, 48, 44, 32, 52, 58, 32, 48, 125, 59, 10, 9, 116,
-		104, 105, 115, 46, 108, 97, 115, 116, 120, 32, 61, 32, 48, 59, 10, 9,
-		116, 104, 105, 115, 46, 108, 97, 115, 116, 121, 32, 61, 32, 48, 59, 10,
-		9, 116, 104, 105, 115, 46, 100, 98, 108, 99, 108, 105, 99, 107, 32, 61,
-		32, 48, 59, 32, 47, 47, 32, 49, 32, 102, 111, 114, 32, 100, 111, 117,
-		98, 108, 101, 44, 32, 50, 32, 102, 111, 114, 32, 116, 114, 105, 112, 108,
-		101, 44, 32, 46, 46, 46, 10, 9, 116, 104, 105, 115, 46, 115, 101, 99,
-		111, 110, 100, 97, 114, 121, 32, 61, 32, 48, 59, 9, 47, 47, 32, 98,
-		117, 116, 116, 111, 110, 32, 102, 111, 114, 32, 115, 101, 108, 101, 99, 116,
-		105, 111, 110, 32, 40, 97, 108, 115, 111, 32, 100, 101, 102, 105, 110, 101,
-		100, 32, 98, 121, 32, 68, 114, 97, 119, 76, 105, 110, 101, 115, 41, 10,
-		9, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 97,
-		98, 111, 114, 116, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 116,
-		104, 105, 115, 46, 109, 97, 108, 116, 32, 61, 32, 102, 97, 108, 115, 101,
-		59, 10, 9, 116, 104, 105, 115, 46, 117, 115, 101, 114, 114, 101, 115, 105,
-		122, 101, 100, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 116, 104,
-		105, 115, 46, 115, 101, 108, 101, 99, 116, 105, 110, 103, 32, 61, 32, 102,
-		97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112,
-		48, 32, 61, 32, 45, 49, 59, 10, 9, 116, 104, 105, 115, 46, 111, 108,
-		100, 112, 49, 32, 61, 32, 45, 49, 59, 10, 9, 116, 104, 105, 115, 46,
-		99, 108, 105, 99, 107, 116, 105, 109, 101, 32, 61, 32, 110, 101, 119, 32,
-		68, 97, 116, 101, 40, 41, 46, 103, 101, 116, 84, 105, 109, 101, 40, 41,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105, 110, 115,
-		100, 97, 116, 97, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100,
-		59, 9, 47, 47, 32, 119, 105, 108, 108, 32, 98, 101, 32, 100, 101, 102,
-		105, 110, 101, 100, 32, 100, 117, 114, 105, 110, 103, 32, 109, 97, 114, 107,
-		105, 110, 115, 10, 9, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100, 97,
-		116, 97, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100, 59, 9,
-		47, 47, 32, 119, 105, 108, 108, 32, 98, 101, 32, 100, 101, 102, 105, 110,
-		101, 100, 32, 100, 117, 114, 105, 110, 103, 32, 101, 105, 110, 115, 10, 9,
-		116, 104, 105, 115, 46, 114, 101, 108, 111, 97, 100, 108, 110, 48, 32, 61,
-		32, 48, 59, 10, 10, 9, 116, 104, 105, 115, 46, 99, 111, 109, 112, 111,
-		115, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 116,
-		104, 105, 115, 46, 108, 97, 116, 105, 110, 32, 61, 32, 34, 34, 59, 10,
-		10, 9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105,
-		115, 59, 9, 47, 47, 32, 119, 101, 32, 114, 101, 119, 114, 105, 116, 101,
-		32, 104, 97, 110, 100, 108, 101, 114, 115, 32, 108, 97, 116, 101, 114, 44,
-		32, 97, 110, 100, 32, 117, 115, 101, 32, 115, 101, 108, 102, 46, 10, 10,
-		9, 116, 104, 105, 115, 46, 109, 114, 108, 115, 101, 32, 61, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97,
-		114, 32, 98, 32, 61, 32, 49, 60, 60, 40, 101, 46, 119, 104, 105, 99,
-		104, 45, 49, 41, 59, 10, 9, 9, 105, 102, 40, 98, 32, 61, 61, 32,
-		49, 32, 38, 38, 32, 116, 104, 105, 115, 46, 109, 97, 108, 116, 41, 123,
-		10, 9, 9, 9, 98, 32, 61, 32, 50, 59, 10, 9, 9, 9, 116, 104,
-		105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 32, 38, 61, 32, 126, 49,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 97, 108, 116, 32, 61,
-		32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104,
-		105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 32, 38, 61, 32, 126, 98,
-		59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 98, 59, 10, 9, 125,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 112, 114, 101, 115, 115, 32,
-		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10,
-		9, 9, 118, 97, 114, 32, 98, 32, 61, 32, 49, 60, 60, 40, 101, 46,
-		119, 104, 105, 99, 104, 45, 49, 41, 59, 10, 9, 9, 105, 102, 40, 98,
-		32, 61, 61, 32, 49, 32, 38, 38, 32, 101, 46, 97, 108, 116, 75, 101,
-		121, 41, 123, 10, 9, 9, 9, 98, 32, 61, 32, 50, 59, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 109, 97, 108, 116, 32, 61, 32, 116, 114, 117,
-		101, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 98, 117,
-		116, 116, 111, 110, 115, 32, 124, 61, 32, 98, 59, 10, 9, 9, 114, 101,
-		116, 117, 114, 110, 32, 98, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47,
-		32, 115, 101, 116, 32, 108, 97, 115, 116, 120, 44, 32, 108, 97, 115, 116,
-		121, 32, 116, 111, 32, 101, 118, 32, 99, 111, 111, 114, 100, 115, 32, 114,
-		101, 108, 97, 116, 105, 118, 101, 32, 116, 111, 32, 99, 97, 110, 118, 97,
-		115, 10, 9, 116, 104, 105, 115, 46, 101, 118, 120, 121, 32, 61, 32, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118,
-		97, 114, 32, 120, 32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32,
-		121, 32, 61, 32, 48, 59, 10, 9, 9, 105, 102, 40, 101, 46, 102, 97,
-		107, 101, 120, 32, 33, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100,
-		41, 32, 123, 10, 9, 9, 9, 120, 32, 61, 32, 101, 46, 102, 97, 107,
-		101, 120, 59, 10, 9, 9, 9, 121, 32, 61, 32, 101, 46, 102, 97, 107,
-		101, 121, 59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9,
-		9, 9, 118, 97, 114, 32, 112, 111, 102, 102, 32, 61, 32, 36, 40, 116,
-		104, 105, 115, 46, 99, 41, 46, 111, 102, 102, 115, 101, 116, 40, 41, 59,
-		10, 9, 9, 9, 120, 32, 61, 32, 101, 46, 112, 97, 103, 101, 88, 32,
-		45, 32, 112, 111, 102, 102, 46, 108, 101, 102, 116, 59, 10, 9, 9, 9,
-		121, 32, 61, 32, 101, 46, 112, 97, 103, 101, 89, 32, 45, 32, 112, 111,
-		102, 102, 46, 116, 111, 112, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104,
-		105, 115, 46, 108, 97, 115, 116, 120, 32, 61, 32, 120, 59, 10, 9, 9,
-		116, 104, 105, 115, 46, 108, 97, 115, 116, 121, 32, 61, 32, 121, 59, 10,
-		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121, 114, 101,
-		115, 105, 122, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
-		117, 115, 101, 114, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 32,
-		61, 32, 36, 40, 116, 104, 105, 115, 46, 99, 41, 59, 10, 9, 9, 118,
-		97, 114, 32, 112, 32, 61, 32, 99, 46, 112, 97, 114, 101, 110, 116, 40,
-		41, 59, 10, 9, 9, 118, 97, 114, 32, 100, 120, 32, 61, 32, 112, 46,
-		119, 105, 100, 116, 104, 40, 41, 59, 10, 9, 9, 118, 97, 114, 32, 100,
-		121, 32, 61, 32, 112, 46, 104, 101, 105, 103, 104, 116, 40, 41, 32, 45,
-		32, 53, 59, 9, 47, 47, 32, 45, 53, 58, 32, 108, 101, 97, 118, 101,
-		32, 97, 32, 98, 105, 116, 32, 111, 102, 32, 114, 111, 111, 109, 10, 9,
-		9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111,
-		108, 101, 46, 108, 111, 103, 40, 39, 109, 97, 121, 114, 101, 115, 105, 122,
-		101, 58, 32, 116, 101, 120, 116, 32, 114, 101, 115, 105, 122, 101, 100, 32,
-		100, 120, 32, 39, 32, 43, 32, 100, 120, 32, 43, 32, 34, 32, 100, 121,
-		32, 34, 32, 43, 32, 100, 121, 32, 43, 32, 34, 32, 34, 32, 43, 32,
-		117, 115, 101, 114, 63, 34, 117, 115, 101, 114, 34, 58, 34, 119, 105, 110,
-		34, 41, 59, 10, 9, 9, 47, 47, 32, 84, 79, 68, 79, 58, 32, 117,
-		115, 101, 32, 104, 101, 108, 112, 101, 114, 32, 119, 104, 101, 110, 32, 119,
-		101, 32, 114, 101, 119, 114, 105, 116, 101, 32, 105, 110, 107, 32, 106, 115,
-		46, 10, 9, 9, 118, 97, 114, 32, 116, 97, 103, 32, 61, 32, 36, 40,
-		34, 35, 34, 43, 116, 104, 105, 115, 46, 105, 100, 43, 34, 116, 34, 41,
-		10, 9, 9, 105, 102, 40, 116, 97, 103, 41, 32, 123, 10, 9, 9, 9,
-		100, 121, 32, 45, 61, 32, 116, 97, 103, 46, 104, 101, 105, 103, 104, 116,
-		40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 85, 115, 105,
-		110, 103, 32, 97, 32, 119, 105, 100, 116, 104, 32, 115, 99, 97, 108, 101,
-		100, 32, 97, 110, 100, 32, 109, 97, 107, 105, 110, 103, 32, 116, 104, 101,
-		32, 115, 116, 121, 108, 101, 32, 117, 115, 101, 32, 116, 104, 101, 32, 119,
-		105, 100, 116, 104, 10, 9, 9, 47, 47, 32, 109, 97, 107, 101, 115, 32,
-		116, 104, 101, 32, 116, 101, 120, 116, 32, 98, 101, 116, 116, 101, 114, 46,
-		10, 9, 9, 99, 46, 119, 105, 100, 116, 104, 40, 100, 120, 41, 59, 10,
-		9, 9, 99, 46, 104, 101, 105, 103, 104, 116, 40, 100, 121, 41, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 32, 61,
-		32, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 42, 100, 120, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116,
-		32, 61, 32, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 42, 100,
-		121, 59, 10, 9, 9, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115,
-		32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 116, 104,
-		105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 47, 116, 104, 105, 115,
-		46, 102, 111, 110, 116, 104, 116, 41, 59, 10, 9, 9, 116, 104, 105, 115,
-		46, 115, 97, 118, 101, 100, 32, 61, 32, 110, 117, 108, 108, 59, 10, 9,
-		9, 116, 104, 105, 115, 46, 114, 101, 102, 111, 114, 109, 97, 116, 40, 116,
-		104, 105, 115, 46, 108, 110, 115, 41, 59, 10, 9, 9, 116, 104, 105, 115,
-		46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9,
-		9, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 116, 104, 105, 115, 32,
-		105, 115, 32, 106, 117, 115, 116, 32, 97, 32, 98, 117, 110, 99, 104, 32,
-		111, 102, 32, 104, 101, 117, 114, 105, 115, 116, 105, 99, 115, 32, 116, 111,
-		32, 109, 97, 107, 101, 32, 105, 116, 32, 102, 101, 101, 108, 32, 111, 107,
-		46, 10, 9, 116, 104, 105, 115, 46, 97, 117, 116, 111, 114, 101, 115, 105,
-		122, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 97, 100,
-		100, 115, 105, 122, 101, 44, 32, 109, 111, 114, 101, 108, 101, 115, 115, 41,
-		32, 123, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32, 36, 40, 116,
-		104, 105, 115, 46, 99, 41, 59, 10, 9, 9, 118, 97, 114, 32, 111, 108,
-		100, 104, 116, 32, 61, 32, 112, 46, 104, 101, 105, 103, 104, 116, 40, 41,
-		59, 10, 9, 9, 118, 97, 114, 32, 104, 116, 32, 61, 32, 111, 108, 100,
-		104, 116, 59, 10, 9, 9, 118, 97, 114, 32, 102, 111, 110, 116, 104, 116,
-		32, 61, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 47, 116,
-		104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10, 9, 9, 105, 102,
-		40, 97, 100, 100, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 117, 115, 101, 114, 114, 101, 115, 105, 122, 101, 100, 32,
-		61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 105, 102, 40, 109, 111,
-		114, 101, 108, 101, 115, 115, 32, 62, 32, 49, 41, 123, 10, 9, 9, 9,
-		9, 118, 97, 114, 32, 119, 116, 111, 112, 32, 61, 32, 36, 40, 119, 105,
-		110, 100, 111, 119, 41, 46, 115, 99, 114, 111, 108, 108, 84, 111, 112, 40,
-		41, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 101, 116, 111, 112, 32,
-		61, 32, 112, 46, 111, 102, 102, 115, 101, 116, 40, 41, 46, 116, 111, 112,
-		59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 101, 111, 102, 102, 32, 61,
-		32, 101, 116, 111, 112, 45, 119, 116, 111, 112, 59, 10, 9, 9, 9, 9,
+		108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 99, 111, 109, 112, 108,
+		101, 116, 101, 115, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 10, 9,
+		116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100, 32, 61, 32,
+		102, 97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 108, 111, 99,
+		107, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 116,
+		104, 105, 115, 46, 109, 117, 115, 116, 117, 110, 108, 111, 99, 107, 32, 61,
+		32, 102, 97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 119, 104,
+		101, 110, 108, 111, 99, 107, 101, 100, 32, 61, 32, 91, 93, 59, 10, 10,
+		9, 116, 104, 105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 32,
+		48, 59, 10, 9, 116, 104, 105, 115, 46, 110, 99, 108, 105, 99, 107, 115,
+		32, 61, 32, 123, 49, 58, 32, 48, 44, 32, 50, 58, 32, 48, 44, 32,
+		52, 58, 32, 48, 125, 59, 10, 9, 116, 104, 105, 115, 46, 108, 97, 115,
+		116, 120, 32, 61, 32, 48, 59, 10, 9, 116, 104, 105, 115, 46, 108, 97,
+		115, 116, 121, 32, 61, 32, 48, 59, 10, 9, 116, 104, 105, 115, 46, 100,
+		98, 108, 99, 108, 105, 99, 107, 32, 61, 32, 48, 59, 32, 47, 47, 32,
+		49, 32, 102, 111, 114, 32, 100, 111, 117, 98, 108, 101, 44, 32, 50, 32,
+		102, 111, 114, 32, 116, 114, 105, 112, 108, 101, 44, 32, 46, 46, 46, 10,
+		9, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32,
+		61, 32, 48, 59, 9, 47, 47, 32, 98, 117, 116, 116, 111, 110, 32, 102,
+		111, 114, 32, 115, 101, 108, 101, 99, 116, 105, 111, 110, 32, 40, 97, 108,
+		115, 111, 32, 100, 101, 102, 105, 110, 101, 100, 32, 98, 121, 32, 68, 114,
+		97, 119, 76, 105, 110, 101, 115, 41, 10, 9, 116, 104, 105, 115, 46, 115,
+		101, 99, 111, 110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 32, 61, 32,
+		102, 97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 109, 97, 108,
+		116, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115,
+		46, 117, 115, 101, 114, 114, 101, 115, 105, 122, 101, 100, 32, 61, 32, 102,
+		97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101,
+		99, 116, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
+		116, 104, 105, 115, 46, 111, 108, 100, 112, 48, 32, 61, 32, 45, 49, 59,
+		10, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112, 49, 32, 61, 32, 45,
+		49, 59, 10, 9, 116, 104, 105, 115, 46, 99, 108, 105, 99, 107, 116, 105,
+		109, 101, 32, 61, 32, 110, 101, 119, 32, 68, 97, 116, 101, 40, 41, 46,
+		103, 101, 116, 84, 105, 109, 101, 40, 41, 59, 10, 10, 9, 116, 104, 105,
+		115, 46, 109, 97, 114, 107, 105, 110, 115, 100, 97, 116, 97, 32, 61, 32,
+		117, 110, 100, 101, 102, 105, 110, 101, 100, 59, 9, 47, 47, 32, 119, 105,
+		108, 108, 32, 98, 101, 32, 100, 101, 102, 105, 110, 101, 100, 32, 100, 117,
+		114, 105, 110, 103, 32, 109, 97, 114, 107, 105, 110, 115, 10, 9, 116, 104,
+		105, 115, 46, 101, 105, 110, 115, 100, 97, 116, 97, 32, 61, 32, 117, 110,
+		100, 101, 102, 105, 110, 101, 100, 59, 9, 47, 47, 32, 119, 105, 108, 108,
+		32, 98, 101, 32, 100, 101, 102, 105, 110, 101, 100, 32, 100, 117, 114, 105,
+		110, 103, 32, 101, 105, 110, 115, 10, 9, 116, 104, 105, 115, 46, 114, 101,
+		108, 111, 97, 100, 108, 110, 48, 32, 61, 32, 48, 59, 10, 10, 9, 116,
+		104, 105, 115, 46, 99, 111, 109, 112, 111, 115, 105, 110, 103, 32, 61, 32,
+		102, 97, 108, 115, 101, 59, 10, 9, 116, 104, 105, 115, 46, 108, 97, 116,
+		105, 110, 32, 61, 32, 34, 34, 59, 10, 10, 9, 118, 97, 114, 32, 115,
+		101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 9, 47, 47, 32, 119,
+		101, 32, 114, 101, 119, 114, 105, 116, 101, 32, 104, 97, 110, 100, 108, 101,
+		114, 115, 32, 108, 97, 116, 101, 114, 44, 32, 97, 110, 100, 32, 117, 115,
+		101, 32, 115, 101, 108, 102, 46, 10, 10, 9, 116, 104, 105, 115, 46, 109,
+		114, 108, 115, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		101, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 98, 32, 61, 32, 49,
+		60, 60, 40, 101, 46, 119, 104, 105, 99, 104, 45, 49, 41, 59, 10, 9,
+		9, 105, 102, 40, 98, 32, 61, 61, 32, 49, 32, 38, 38, 32, 116, 104,
+		105, 115, 46, 109, 97, 108, 116, 41, 123, 10, 9, 9, 9, 98, 32, 61,
+		32, 50, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 98, 117, 116, 116,
+		111, 110, 115, 32, 38, 61, 32, 126, 49, 59, 10, 9, 9, 9, 116, 104,
+		105, 115, 46, 109, 97, 108, 116, 32, 61, 32, 102, 97, 108, 115, 101, 59,
+		10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 98, 117, 116, 116,
+		111, 110, 115, 32, 38, 61, 32, 126, 98, 59, 10, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 98, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
+		115, 46, 109, 112, 114, 101, 115, 115, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 98,
+		32, 61, 32, 49, 60, 60, 40, 101, 46, 119, 104, 105, 99, 104, 45, 49,
+		41, 59, 10, 9, 9, 105, 102, 40, 98, 32, 61, 61, 32, 49, 32, 38,
+		38, 32, 101, 46, 97, 108, 116, 75, 101, 121, 41, 123, 10, 9, 9, 9,
+		98, 32, 61, 32, 50, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109,
+		97, 108, 116, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 10,
+		9, 9, 116, 104, 105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 32, 124,
+		61, 32, 98, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 98, 59,
+		10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 115, 101, 116, 32, 108, 97,
+		115, 116, 120, 44, 32, 108, 97, 115, 116, 121, 32, 116, 111, 32, 101, 118,
+		32, 99, 111, 111, 114, 100, 115, 32, 114, 101, 108, 97, 116, 105, 118, 101,
+		32, 116, 111, 32, 99, 97, 110, 118, 97, 115, 10, 9, 116, 104, 105, 115,
+		46, 101, 118, 120, 121, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 101, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32,
+		48, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32, 48, 59, 10,
+		9, 9, 105, 102, 40, 101, 46, 102, 97, 107, 101, 120, 32, 33, 61, 32,
+		117, 110, 100, 101, 102, 105, 110, 101, 100, 41, 32, 123, 10, 9, 9, 9,
+		120, 32, 61, 32, 101, 46, 102, 97, 107, 101, 120, 59, 10, 9, 9, 9,
+		121, 32, 61, 32, 101, 46, 102, 97, 107, 101, 121, 59, 10, 9, 9, 125,
+		32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 112,
+		111, 102, 102, 32, 61, 32, 36, 40, 116, 104, 105, 115, 46, 99, 41, 46,
+		111, 102, 102, 115, 101, 116, 40, 41, 59, 10, 9, 9, 9, 120, 32, 61,
+		32, 101, 46, 112, 97, 103, 101, 88, 32, 45, 32, 112, 111, 102, 102, 46,
+		108, 101, 102, 116, 59, 10, 9, 9, 9, 121, 32, 61, 32, 101, 46, 112,
+		97, 103, 101, 89, 32, 45, 32, 112, 111, 102, 102, 46, 116, 111, 112, 59,
+		10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 108, 97, 115, 116,
+		120, 32, 61, 32, 120, 59, 10, 9, 9, 116, 104, 105, 115, 46, 108, 97,
+		115, 116, 121, 32, 61, 32, 121, 59, 10, 9, 125, 59, 10, 10, 9, 116,
+		104, 105, 115, 46, 109, 97, 121, 114, 101, 115, 105, 122, 101, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 117, 115, 101, 114, 41, 32, 123,
+		10, 9, 9, 118, 97, 114, 32, 99, 32, 61, 32, 36, 40, 116, 104, 105,
+		115, 46, 99, 41, 59, 10, 9, 9, 118, 97, 114, 32, 112, 32, 61, 32,
+		99, 46, 112, 97, 114, 101, 110, 116, 40, 41, 59, 10, 9, 9, 118, 97,
+		114, 32, 100, 120, 32, 61, 32, 112, 46, 119, 105, 100, 116, 104, 40, 41,
+		59, 10, 9, 9, 118, 97, 114, 32, 100, 121, 32, 61, 32, 112, 46, 104,
+		101, 105, 103, 104, 116, 40, 41, 32, 45, 32, 53, 59, 9, 47, 47, 32,
+		45, 53, 58, 32, 108, 101, 97, 118, 101, 32, 97, 32, 98, 105, 116, 32,
+		111, 102, 32, 114, 111, 111, 109, 10, 9, 9, 105, 102, 40, 116, 100, 101,
+		98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		39, 109, 97, 121, 114, 101, 115, 105, 122, 101, 58, 32, 116, 101, 120, 116,
+		32, 114, 101, 115, 105, 122, 101, 100, 32, 100, 120, 32, 39, 32, 43, 32,
+		100, 120, 32, 43, 32, 34, 32, 100, 121, 32, 34, 32, 43, 32, 100, 121,
+		32, 43, 32, 34, 32, 34, 32, 43, 32, 117, 115, 101, 114, 63, 34, 117,
+		115, 101, 114, 34, 58, 34, 119, 105, 110, 34, 41, 59, 10, 9, 9, 47,
+		47, 32, 84, 79, 68, 79, 58, 32, 117, 115, 101, 32, 104, 101, 108, 112,
+		101, 114, 32, 119, 104, 101, 110, 32, 119, 101, 32, 114, 101, 119, 114, 105,
+		116, 101, 32, 105, 110, 107, 32, 106, 115, 46, 10, 9, 9, 118, 97, 114,
+		32, 116, 97, 103, 32, 61, 32, 36, 40, 34, 35, 34, 43, 116, 104, 105,
+		115, 46, 105, 100, 43, 34, 116, 34, 41, 10, 9, 9, 105, 102, 40, 116,
+		97, 103, 41, 32, 123, 10, 9, 9, 9, 100, 121, 32, 45, 61, 32, 116,
+		97, 103, 46, 104, 101, 105, 103, 104, 116, 40, 41, 59, 10, 9, 9, 125,
+		10, 9, 9, 47, 47, 32, 85, 115, 105, 110, 103, 32, 97, 32, 119, 105,
+		100, 116, 104, 32, 115, 99, 97, 108, 101, 100, 32, 97, 110, 100, 32, 109,
+		97, 107, 105, 110, 103, 32, 116, 104, 101, 32, 115, 116, 121, 108, 101, 32,
+		117, 115, 101, 32, 116, 104, 101, 32, 119, 105, 100, 116, 104, 10, 9, 9,
+		47, 47, 32, 109, 97, 107, 101, 115, 32, 116, 104, 101, 32, 116, 101, 120,
+		116, 32, 98, 101, 116, 116, 101, 114, 46, 10, 9, 9, 99, 46, 119, 105,
+		100, 116, 104, 40, 100, 120, 41, 59, 10, 9, 9, 99, 46, 104, 101, 105,
+		103, 104, 116, 40, 100, 121, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46,
+		99, 46, 119, 105, 100, 116, 104, 32, 61, 32, 116, 104, 105, 115, 46, 116,
+		115, 99, 97, 108, 101, 42, 100, 120, 59, 10, 9, 9, 116, 104, 105, 115,
+		46, 99, 46, 104, 101, 105, 103, 104, 116, 32, 61, 32, 116, 104, 105, 115,
+		46, 116, 115, 99, 97, 108, 101, 42, 100, 121, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 110, 108, 105, 110, 101, 115, 32, 61, 32, 77, 97, 116, 104,
+		46, 102, 108, 111, 111, 114, 40, 116, 104, 105, 115, 46, 99, 46, 104, 101,
+		105, 103, 104, 116, 47, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116,
+		41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115, 97, 118, 101, 100, 32,
+		61, 32, 110, 117, 108, 108, 59, 10, 9, 9, 116, 104, 105, 115, 46, 114,
+		101, 102, 111, 114, 109, 97, 116, 40, 116, 104, 105, 115, 46, 108, 110, 115,
+		41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119,
+		116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 10, 9, 125, 59, 10, 10,
+		9, 47, 47, 32, 116, 104, 105, 115, 32, 105, 115, 32, 106, 117, 115, 116,
+		32, 97, 32, 98, 117, 110, 99, 104, 32, 111, 102, 32, 104, 101, 117, 114,
+		105, 115, 116, 105, 99, 115, 32, 116, 111, 32, 109, 97, 107, 101, 32, 105,
+		116, 32, 102, 101, 101, 108, 32, 111, 107, 46, 10, 9, 116, 104, 105, 115,
+		46, 97, 117, 116, 111, 114, 101, 115, 105, 122, 101, 32, 61, 32, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 97, 100, 100, 115, 105, 122, 101, 44, 32,
+		109, 111, 114, 101, 108, 101, 115, 115, 41, 32, 123, 10, 9, 9, 118, 97,
+		114, 32, 112, 32, 61, 32, 36, 40, 116, 104, 105, 115, 46, 99, 41, 59,
+		10, 9, 9, 118, 97, 114, 32, 111, 108, 100, 104, 116, 32, 61, 32, 112,
+		46, 104, 101, 105, 103, 104, 116, 40, 41, 59, 10, 9, 9, 118, 97, 114,
+		32, 104, 116, 32, 61, 32, 111, 108, 100, 104, 116, 59, 10, 9, 9, 118,
+		97, 114, 32, 102, 111, 110, 116, 104, 116, 32, 61, 32, 116, 104, 105, 115,
+		46, 102, 111, 110, 116, 104, 116, 47, 116, 104, 105, 115, 46, 116, 115, 99,
+		97, 108, 101, 59, 10, 9, 9, 105, 102, 40, 97, 100, 100, 115, 105, 122,
+		101, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 117, 115, 101,
+		114, 114, 101, 115, 105, 122, 101, 100, 32, 61, 32, 116, 114, 117, 101, 59,
+		10, 9, 9, 9, 105, 102, 40, 109, 111, 114, 101, 108, 101, 115, 115, 32,
+		62, 32, 49, 41, 123, 10, 9, 9, 9, 9, 118, 97, 114, 32, 119, 116,
+		111, 112, 32, 61, 32, 36, 40, 119, 105, 110, 100, 111, 119, 41, 46, 115,
+		99, 114, 111, 108, 108, 84, 111, 112, 40, 41, 59, 10, 9, 9, 9, 9,
+		118, 97, 114, 32, 101, 116, 111, 112, 32, 61, 32, 112, 46, 111, 102, 102,
+		115, 101, 116, 40, 41, 46, 116, 111, 112, 59, 10, 9, 9, 9, 9, 118,
+		97, 114, 32, 101, 111, 102, 102, 32, 61, 32, 101, 116, 111, 112, 45, 119,
+		116, 111, 112, 59, 10, 9, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98,
+		117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		114, 101, 115, 105, 122, 101, 32, 34, 44, 32, 119, 116, 111, 112, 44, 32,
+		101, 116, 111, 112, 44, 32, 101, 111, 102, 102, 41, 59, 10, 9, 9, 9,
+		9, 104, 116, 32, 61, 32, 119, 105, 110, 100, 111, 119, 46, 105, 110, 110,
+		101, 114, 72, 101, 105, 103, 104, 116, 32, 45, 32, 49, 48, 32, 45, 32,
+		101, 111, 102, 102, 59, 32, 47, 47, 32, 45, 49, 48, 58, 32, 108, 101,
+		97, 118, 101, 32, 115, 111, 109, 101, 32, 114, 111, 111, 109, 10, 9, 9,
+		9, 125, 32, 101, 108, 115, 101, 32, 105, 102, 40, 109, 111, 114, 101, 108,
+		101, 115, 115, 32, 62, 61, 32, 48, 41, 32, 123, 10, 9, 9, 9, 9,
+		104, 116, 32, 43, 61, 32, 102, 111, 110, 116, 104, 116, 42, 54, 59, 10,
+		9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 9,
+		104, 116, 32, 45, 61, 32, 102, 111, 110, 116, 104, 116, 42, 54, 59, 10,
+		9, 9, 9, 9, 105, 102, 40, 104, 116, 32, 60, 32, 53, 42, 102, 111,
+		110, 116, 104, 116, 41, 32, 123, 10, 9, 9, 9, 9, 9, 104, 116, 32,
+		61, 32, 53, 42, 102, 111, 110, 116, 104, 116, 59, 10, 9, 9, 9, 9,
+		125, 10, 9, 9, 9, 125, 10, 9, 9, 125, 101, 108, 115, 101, 123, 10,
+		9, 9, 9, 118, 97, 114, 32, 110, 108, 110, 32, 61, 32, 116, 104, 105,
+		115, 46, 102, 114, 108, 105, 110, 101, 115, 59, 10, 9, 9, 9, 105, 102,
+		40, 110, 108, 110, 32, 60, 32, 51, 41, 32, 123, 10, 9, 9, 9, 9,
+		110, 108, 110, 32, 61, 32, 51, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 104, 116, 32, 61, 32, 40, 110, 108, 110, 43, 50, 41, 32, 42, 32,
+		102, 111, 110, 116, 104, 116, 59, 10, 9, 9, 9, 105, 102, 32, 40, 104,
+		116, 32, 62, 61, 32, 52, 48, 48, 41, 32, 123, 9, 47, 47, 32, 115,
+		111, 109, 101, 32, 105, 110, 105, 116, 105, 97, 108, 32, 97, 114, 98, 105,
+		116, 114, 97, 114, 121, 32, 115, 112, 97, 99, 101, 46, 10, 9, 9, 9,
+		9, 104, 116, 32, 61, 32, 52, 48, 48, 59, 10, 9, 9, 9, 125, 10,
+		9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 97, 117, 116,
+		111, 32, 114, 115, 122, 34, 44, 32, 110, 108, 110, 44, 32, 104, 116, 44,
+		32, 111, 108, 100, 104, 116, 41, 59, 10, 9, 9, 105, 102, 32, 40, 111,
+		108, 100, 104, 116, 32, 60, 32, 104, 116, 32, 45, 32, 102, 111, 110, 116,
+		104, 116, 32, 124, 124, 32, 111, 108, 100, 104, 116, 32, 62, 32, 104, 116,
+		32, 43, 32, 102, 111, 110, 116, 104, 116, 41, 32, 123, 10, 9, 9, 9,
 		105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108,
-		101, 46, 108, 111, 103, 40, 34, 114, 101, 115, 105, 122, 101, 32, 34, 44,
-		32, 119, 116, 111, 112, 44, 32, 101, 116, 111, 112, 44, 32, 101, 111, 102,
-		102, 41, 59, 10, 9, 9, 9, 9, 104, 116, 32, 61, 32, 119, 105, 110,
-		100, 111, 119, 46, 105, 110, 110, 101, 114, 72, 101, 105, 103, 104, 116, 32,
-		45, 32, 49, 48, 32, 45, 32, 101, 111, 102, 102, 59, 32, 47, 47, 32,
-		45, 49, 48, 58, 32, 108, 101, 97, 118, 101, 32, 115, 111, 109, 101, 32,
-		114, 111, 111, 109, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 105,
-		102, 40, 109, 111, 114, 101, 108, 101, 115, 115, 32, 62, 61, 32, 48, 41,
-		32, 123, 10, 9, 9, 9, 9, 104, 116, 32, 43, 61, 32, 102, 111, 110,
-		116, 104, 116, 42, 54, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101,
-		32, 123, 10, 9, 9, 9, 9, 104, 116, 32, 45, 61, 32, 102, 111, 110,
-		116, 104, 116, 42, 54, 59, 10, 9, 9, 9, 9, 105, 102, 40, 104, 116,
-		32, 60, 32, 53, 42, 102, 111, 110, 116, 104, 116, 41, 32, 123, 10, 9,
-		9, 9, 9, 9, 104, 116, 32, 61, 32, 53, 42, 102, 111, 110, 116, 104,
-		116, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 10, 9, 9,
-		125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 118, 97, 114, 32, 110, 108,
-		110, 32, 61, 32, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115,
-		59, 10, 9, 9, 9, 105, 102, 40, 110, 108, 110, 32, 60, 32, 51, 41,
-		32, 123, 10, 9, 9, 9, 9, 110, 108, 110, 32, 61, 32, 51, 59, 10,
-		9, 9, 9, 125, 10, 9, 9, 9, 104, 116, 32, 61, 32, 40, 110, 108,
-		110, 43, 50, 41, 32, 42, 32, 102, 111, 110, 116, 104, 116, 59, 10, 9,
-		9, 9, 105, 102, 32, 40, 104, 116, 32, 62, 61, 32, 52, 48, 48, 41,
-		32, 123, 9, 47, 47, 32, 115, 111, 109, 101, 32, 105, 110, 105, 116, 105,
-		97, 108, 32, 97, 114, 98, 105, 116, 114, 97, 114, 121, 32, 115, 112, 97,
-		99, 101, 46, 10, 9, 9, 9, 9, 104, 116, 32, 61, 32, 52, 48, 48,
-		59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
+		101, 46, 108, 111, 103, 40, 34, 97, 117, 116, 111, 32, 114, 101, 115, 105,
+		122, 105, 110, 103, 34, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 100,
+		101, 108, 116, 97, 32, 61, 32, 104, 116, 32, 45, 32, 111, 108, 100, 104,
+		116, 59, 10, 9, 9, 9, 112, 32, 61, 32, 112, 46, 112, 97, 114, 101,
+		110, 116, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 110, 104, 116,
+		32, 61, 32, 112, 46, 104, 101, 105, 103, 104, 116, 40, 41, 32, 43, 32,
+		100, 101, 108, 116, 97, 59, 10, 9, 9, 9, 112, 46, 104, 101, 105, 103,
+		104, 116, 40, 110, 104, 116, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 109, 97, 121, 114, 101, 115, 105, 122, 101, 40, 102, 97, 108, 115, 101,
+		41, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
+		115, 46, 115, 101, 108, 101, 99, 116, 115, 116, 97, 114, 116, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105,
+		102, 40, 33, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116, 105, 110,
+		103, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117,
+		103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 115,
+		101, 108, 101, 99, 116, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9,
+		9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116,
+		105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 115, 101,
+		108, 101, 99, 116, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10,
+		9, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112, 48, 32, 61, 32, 116,
+		104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 111,
+		108, 100, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 59, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99,
+		116, 101, 110, 100, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 109, 117,
+		115, 116, 117, 110, 108, 111, 99, 107, 41, 32, 123, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 117, 110, 108, 111, 99, 107, 101, 100, 40, 41, 59, 10,
+		9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 115,
+		101, 108, 101, 99, 116, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
 		116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 97, 117, 116, 111, 32, 114, 115, 122, 34, 44, 32, 110,
-		108, 110, 44, 32, 104, 116, 44, 32, 111, 108, 100, 104, 116, 41, 59, 10,
-		9, 9, 105, 102, 32, 40, 111, 108, 100, 104, 116, 32, 60, 32, 104, 116,
-		32, 45, 32, 102, 111, 110, 116, 104, 116, 32, 124, 124, 32, 111, 108, 100,
-		104, 116, 32, 62, 32, 104, 116, 32, 43, 32, 102, 111, 110, 116, 104, 116,
-		41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103,
-		41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 97, 117,
-		116, 111, 32, 114, 101, 115, 105, 122, 105, 110, 103, 34, 41, 59, 10, 9,
-		9, 9, 118, 97, 114, 32, 100, 101, 108, 116, 97, 32, 61, 32, 104, 116,
-		32, 45, 32, 111, 108, 100, 104, 116, 59, 10, 9, 9, 9, 112, 32, 61,
-		32, 112, 46, 112, 97, 114, 101, 110, 116, 40, 41, 59, 10, 9, 9, 9,
-		118, 97, 114, 32, 110, 104, 116, 32, 61, 32, 112, 46, 104, 101, 105, 103,
-		104, 116, 40, 41, 32, 43, 32, 100, 101, 108, 116, 97, 59, 10, 9, 9,
-		9, 112, 46, 104, 101, 105, 103, 104, 116, 40, 110, 104, 116, 41, 59, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 109, 97, 121, 114, 101, 115, 105, 122,
-		101, 40, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 125, 10, 9, 125,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116, 115,
-		116, 97, 114, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
-		41, 32, 123, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 115,
-		101, 108, 101, 99, 116, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 105,
-		102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 34, 115, 101, 108, 101, 99, 116, 105, 110, 103, 46,
-		46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115,
-		46, 115, 101, 108, 101, 99, 116, 105, 110, 103, 32, 61, 32, 116, 114, 117,
-		101, 59, 10, 9, 9, 115, 101, 108, 101, 99, 116, 105, 110, 103, 32, 61,
-		32, 116, 114, 117, 101, 59, 10, 9, 9, 116, 104, 105, 115, 46, 111, 108,
-		100, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9,
-		9, 116, 104, 105, 115, 46, 111, 108, 100, 112, 49, 32, 61, 32, 116, 104,
-		105, 115, 46, 112, 49, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
-		115, 46, 115, 101, 108, 101, 99, 116, 101, 110, 100, 32, 61, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40,
-		116, 104, 105, 115, 46, 109, 117, 115, 116, 117, 110, 108, 111, 99, 107, 41,
-		32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 108, 111, 99,
-		107, 101, 100, 40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
-		33, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116, 105, 110, 103, 41,
-		32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9,
-		125, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 115, 101, 108, 101, 99,
-		116, 32, 101, 110, 100, 34, 41, 59, 10, 9, 9, 105, 102, 40, 116, 104,
-		105, 115, 46, 111, 108, 100, 112, 48, 32, 33, 61, 32, 116, 104, 105, 115,
-		46, 112, 48, 32, 124, 124, 32, 116, 104, 105, 115, 46, 111, 108, 100, 112,
-		49, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 32, 123, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 116,
-		105, 99, 107, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48,
-		44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112, 48, 32, 61, 32,
-		116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 9, 116, 104, 105, 115,
-		46, 111, 108, 100, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49,
-		59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 115, 101, 108,
-		101, 99, 116, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10,
-		9, 9, 115, 101, 108, 101, 99, 116, 105, 110, 103, 32, 61, 32, 102, 97,
-		108, 115, 101, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
-		97, 100, 106, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 112, 111, 115, 44, 32, 100, 101, 108, 112, 48, 44, 32, 100, 101,
-		108, 112, 49, 41, 32, 123, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32,
-		60, 61, 32, 100, 101, 108, 112, 48, 41, 10, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 112, 111, 115, 59, 10, 9, 9, 105, 102, 40, 112, 111,
-		115, 32, 60, 61, 32, 100, 101, 108, 112, 49, 41, 10, 9, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 100, 101, 108, 112, 48, 59, 10, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 112, 111, 115, 32, 45, 32, 40, 100, 101, 108,
-		112, 49, 32, 45, 32, 100, 101, 108, 112, 48, 41, 59, 10, 9, 125, 59,
-		10, 9, 10, 9, 116, 104, 105, 115, 46, 97, 112, 112, 108, 121, 32, 61,
-		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 118, 44, 32, 102, 114,
-		111, 109, 115, 101, 114, 118, 101, 114, 41, 32, 123, 10, 9, 9, 105, 102,
-		40, 33, 101, 118, 32, 124, 124, 32, 33, 101, 118, 46, 65, 114, 103, 115,
-		32, 124, 124, 32, 33, 101, 118, 46, 65, 114, 103, 115, 91, 48, 93, 41,
-		123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
-		40, 34, 97, 112, 112, 108, 121, 58, 32, 110, 105, 108, 32, 101, 118, 34,
-		41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9,
-		125, 10, 9, 9, 118, 97, 114, 32, 97, 114, 103, 32, 61, 32, 101, 118,
-		46, 65, 114, 103, 115, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117,
-		103, 32, 38, 38, 32, 97, 114, 103, 91, 48, 93, 32, 33, 61, 32, 34,
-		114, 101, 108, 111, 97, 100, 105, 110, 103, 34, 41, 32, 123, 10, 9, 9,
-		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105,
-		115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 34, 44, 32, 101,
-		118, 46, 65, 114, 103, 115, 44, 32, 34, 118, 34, 32, 43, 32, 101, 118,
-		46, 86, 101, 114, 115, 32, 43, 32, 34, 32, 34, 32, 43, 32, 116, 104,
-		105, 115, 46, 118, 101, 114, 115, 41, 59, 10, 9, 9, 125, 10, 9, 9,
-		115, 119, 105, 116, 99, 104, 40, 97, 114, 103, 91, 48, 93, 41, 123, 10,
-		9, 9, 99, 97, 115, 101, 32, 34, 104, 101, 108, 100, 34, 58, 10, 9,
-		9, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 101, 100, 40, 41, 59,
-		10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115,
-		101, 32, 34, 114, 108, 115, 101, 34, 58, 10, 9, 9, 9, 105, 102, 40,
-		116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116, 105, 110, 103, 41, 32,
-		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 109, 117, 115, 116, 117,
-		110, 108, 111, 99, 107, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 117, 110, 108, 111, 99, 107, 101, 100, 40, 41,
+		111, 103, 40, 34, 115, 101, 108, 101, 99, 116, 32, 101, 110, 100, 34, 41,
+		59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 111, 108, 100, 112,
+		48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 48, 32, 124, 124, 32,
+		116, 104, 105, 115, 46, 111, 108, 100, 112, 49, 32, 33, 61, 32, 116, 104,
+		105, 115, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 112, 111, 115, 116, 40, 91, 34, 116, 105, 99, 107, 34, 44, 32, 34,
+		34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116, 104,
+		105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 111, 108, 100, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48,
+		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112, 49, 32,
+		61, 32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9, 125, 10, 9,
+		9, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116, 105, 110, 103, 32,
+		61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 115, 101, 108, 101, 99,
+		116, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125,
+		59, 10, 10, 9, 47, 47, 32, 80, 97, 115, 116, 101, 32, 116, 104, 101,
+		32, 98, 114, 111, 119, 115, 101, 114, 32, 99, 108, 105, 112, 98, 111, 97,
+		114, 100, 44, 32, 105, 102, 32, 119, 101, 32, 109, 97, 121, 32, 114, 101,
+		97, 100, 32, 105, 116, 44, 10, 9, 47, 47, 32, 111, 114, 32, 116, 104,
+		101, 32, 115, 110, 97, 114, 102, 32, 98, 117, 102, 102, 101, 114, 32, 107,
+		101, 112, 116, 32, 98, 121, 32, 116, 104, 101, 32, 115, 101, 114, 118, 101,
+		114, 46, 10, 9, 116, 104, 105, 115, 46, 112, 97, 115, 116, 101, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9,
+		118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59,
+		10, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 116, 104, 105, 115,
+		46, 112, 48, 44, 32, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112,
+		49, 59, 10, 9, 9, 105, 102, 40, 33, 110, 97, 118, 105, 103, 97, 116,
+		111, 114, 46, 99, 108, 105, 112, 98, 111, 97, 114, 100, 32, 124, 124, 32,
+		33, 110, 97, 118, 105, 103, 97, 116, 111, 114, 46, 99, 108, 105, 112, 98,
+		111, 97, 114, 100, 46, 114, 101, 97, 100, 84, 101, 120, 116, 41, 123, 10,
+		9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 101,
+		112, 97, 115, 116, 101, 34, 44, 32, 34, 34, 43, 112, 48, 44, 32, 34,
+		34, 43, 112, 49, 93, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114,
+		110, 59, 10, 9, 9, 125, 10, 9, 9, 110, 97, 118, 105, 103, 97, 116,
+		111, 114, 46, 99, 108, 105, 112, 98, 111, 97, 114, 100, 46, 114, 101, 97,
+		100, 84, 101, 120, 116, 40, 41, 46, 116, 104, 101, 110, 40, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 115, 41, 123, 10, 9, 9, 9, 115, 101, 108,
+		102, 46, 112, 111, 115, 116, 40, 91, 34, 101, 115, 110, 97, 114, 102, 34,
+		44, 32, 115, 93, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 112,
+		111, 115, 116, 40, 91, 34, 101, 112, 97, 115, 116, 101, 34, 44, 32, 34,
+		34, 43, 112, 48, 44, 32, 34, 34, 43, 112, 49, 93, 41, 59, 10, 9,
+		9, 125, 44, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 10,
+		9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116, 40, 91, 34, 101,
+		112, 97, 115, 116, 101, 34, 44, 32, 34, 34, 43, 112, 48, 44, 32, 34,
+		34, 43, 112, 49, 93, 41, 59, 10, 9, 9, 125, 41, 59, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 97, 100, 106, 100, 101, 108, 32,
+		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 111, 115, 44, 32,
+		100, 101, 108, 112, 48, 44, 32, 100, 101, 108, 112, 49, 41, 32, 123, 10,
+		9, 9, 105, 102, 40, 112, 111, 115, 32, 60, 61, 32, 100, 101, 108, 112,
+		48, 41, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 112, 111, 115,
+		59, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32, 60, 61, 32, 100, 101,
+		108, 112, 49, 41, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 100,
+		101, 108, 112, 48, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 112,
+		111, 115, 32, 45, 32, 40, 100, 101, 108, 112, 49, 32, 45, 32, 100, 101,
+		108, 112, 48, 41, 59, 10, 9, 125, 59, 10, 9, 10, 9, 116, 104, 105,
+		115, 46, 97, 112, 112, 108, 121, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 101, 118, 44, 32, 102, 114, 111, 109, 115, 101, 114, 118, 101,
+		114, 41, 32, 123, 10, 9, 9, 105, 102, 40, 33, 101, 118, 32, 124, 124,
+		32, 33, 101, 118, 46, 65, 114, 103, 115, 32, 124, 124, 32, 33, 101, 118,
+		46, 65, 114, 103, 115, 91, 48, 93, 41, 123, 10, 9, 9, 9, 99, 111,
+		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 97, 112, 112, 108, 121,
+		58, 32, 110, 105, 108, 32, 101, 118, 34, 41, 59, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114,
+		32, 97, 114, 103, 32, 61, 32, 101, 118, 46, 65, 114, 103, 115, 10, 9,
+		9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 32, 38, 38, 32, 97, 114,
+		103, 91, 48, 93, 32, 33, 61, 32, 34, 114, 101, 108, 111, 97, 100, 105,
+		110, 103, 34, 41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108,
+		101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34,
+		97, 112, 112, 108, 121, 34, 44, 32, 101, 118, 46, 65, 114, 103, 115, 44,
+		32, 34, 118, 34, 32, 43, 32, 101, 118, 46, 86, 101, 114, 115, 32, 43,
+		32, 34, 32, 34, 32, 43, 32, 116, 104, 105, 115, 46, 118, 101, 114, 115,
+		41, 59, 10, 9, 9, 125, 10, 9, 9, 115, 119, 105, 116, 99, 104, 40,
+		97, 114, 103, 91, 48, 93, 41, 123, 10, 9, 9, 99, 97, 115, 101, 32,
+		34, 104, 101, 108, 100, 34, 58, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		108, 111, 99, 107, 101, 100, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 114, 108, 115, 101,
+		34, 58, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 115, 101,
+		108, 101, 99, 116, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 9, 116,
+		104, 105, 115, 46, 109, 117, 115, 116, 117, 110, 108, 111, 99, 107, 32, 61,
+		32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 117,
+		110, 108, 111, 99, 107, 101, 100, 40, 41, 59, 10, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 110, 111, 101,
+		100, 105, 116, 115, 34, 58, 10, 9, 9, 9, 116, 104, 105, 115, 46, 110,
+		111, 101, 100, 105, 116, 115, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9,
+		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32,
+		34, 101, 100, 105, 116, 115, 34, 58, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 110, 111, 101, 100, 105, 116, 115, 32, 61, 32, 102, 97, 108, 115, 101,
 		59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97,
-		115, 101, 32, 34, 110, 111, 101, 100, 105, 116, 115, 34, 58, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 32, 61, 32,
-		116, 114, 117, 101, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 99, 97, 115, 101, 32, 34, 101, 100, 105, 116, 115, 34, 58, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 32,
-		61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 98, 114, 101, 97,
-		107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 99, 108, 101, 97, 110,
-		34, 58, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 99, 108,
-		101, 97, 110, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 99, 97, 115, 101, 32, 34, 100, 105, 114, 116, 121, 34, 58,
-		10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 100, 105, 114, 116,
-		121, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
-		9, 99, 97, 115, 101, 32, 34, 115, 104, 111, 119, 34, 58, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 115, 104, 111, 119, 99, 111, 110, 116, 114, 111,
-		108, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
-		9, 99, 97, 115, 101, 32, 34, 116, 97, 103, 34, 58, 10, 9, 9, 9,
-		105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32,
-		50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112,
-		112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 116, 97, 103, 34, 41,
-		59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9,
-		125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 116, 97, 103,
-		40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 98, 114, 101,
-		97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 102, 111, 110, 116,
-		34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110,
-		103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105,
-		100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116,
-		32, 102, 111, 110, 116, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101,
-		97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 99, 111, 110, 115,
-		111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44,
-		32, 34, 102, 111, 110, 116, 34, 44, 32, 97, 114, 103, 91, 49, 93, 41,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 102, 111, 110, 116, 115, 116,
-		121, 108, 101, 32, 61, 32, 97, 114, 103, 91, 49, 93, 59, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111, 110, 116, 40, 41, 59,
-		10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 102, 111, 114, 109, 97,
-		116, 40, 116, 104, 105, 115, 46, 108, 110, 115, 41, 59, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40,
-		41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99,
-		97, 115, 101, 32, 34, 109, 97, 114, 107, 105, 110, 115, 105, 110, 103, 34,
+		115, 101, 32, 34, 99, 111, 109, 112, 108, 101, 116, 101, 115, 34, 58, 10,
+		9, 9, 9, 116, 104, 105, 115, 46, 99, 111, 109, 112, 108, 101, 116, 101,
+		115, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 99, 108, 101, 97,
+		110, 34, 58, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 99,
+		108, 101, 97, 110, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 100, 105, 114, 116, 121, 34,
+		58, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 100, 105, 114,
+		116, 121, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 99, 97, 115, 101, 32, 34, 115, 104, 111, 119, 34, 58, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 115, 104, 111, 119, 99, 111, 110, 116, 114,
+		111, 108, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 99, 97, 115, 101, 32, 34, 116, 97, 103, 34, 58, 10, 9, 9,
+		9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60,
+		32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
+		46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97,
+		112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 116, 97, 103, 34,
+		41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 116, 97,
+		103, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 102, 111, 110,
+		116, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101,
+		110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46,
+		105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114,
+		116, 32, 102, 111, 110, 116, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 99, 111, 110,
+		115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100,
+		44, 32, 34, 102, 111, 110, 116, 34, 44, 32, 97, 114, 103, 91, 49, 93,
+		41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 102, 111, 110, 116, 115,
+		116, 121, 108, 101, 32, 61, 32, 97, 114, 103, 91, 49, 93, 59, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111, 110, 116, 40, 41,
+		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115,
+		32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 116, 104,
+		105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 47, 116, 104, 105, 115,
+		46, 102, 111, 110, 116, 104, 116, 41, 59, 10, 9, 9, 9, 116, 104, 105,
+		115, 46, 114, 101, 102, 111, 114, 109, 97, 116, 40, 116, 104, 105, 115, 46,
+		108, 110, 115, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101,
+		100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 108, 105,
+		110, 101, 110, 98, 115, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114,
+		103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9,
+		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116,
+		104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32,
+		115, 104, 111, 114, 116, 32, 108, 105, 110, 101, 110, 98, 115, 34, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 105, 110, 101, 110, 98, 115,
+		32, 61, 32, 97, 114, 103, 91, 49, 93, 32, 61, 61, 32, 34, 111, 110,
+		34, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111,
+		110, 116, 40, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101,
+		102, 111, 114, 109, 97, 116, 40, 116, 104, 105, 115, 46, 108, 110, 115, 41,
+		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119,
+		116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 115, 110, 97, 114, 102, 34,
 		58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103,
-		116, 104, 32, 60, 32, 51, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110,
+		116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110,
 		115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100,
 		44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32,
-		109, 97, 114, 107, 105, 110, 115, 105, 110, 103, 34, 41, 59, 10, 9, 9,
+		115, 110, 97, 114, 102, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 47, 47, 32, 111,
+		110, 108, 121, 32, 116, 104, 101, 32, 112, 97, 103, 101, 32, 119, 105, 116,
+		104, 32, 116, 104, 101, 32, 102, 111, 99, 117, 115, 32, 109, 97, 121, 32,
+		119, 114, 105, 116, 101, 32, 116, 104, 101, 32, 99, 108, 105, 112, 98, 111,
+		97, 114, 100, 46, 10, 9, 9, 9, 105, 102, 40, 110, 97, 118, 105, 103,
+		97, 116, 111, 114, 46, 99, 108, 105, 112, 98, 111, 97, 114, 100, 32, 38,
+		38, 32, 100, 111, 99, 117, 109, 101, 110, 116, 46, 104, 97, 115, 70, 111,
+		99, 117, 115, 40, 41, 41, 123, 10, 9, 9, 9, 9, 110, 97, 118, 105,
+		103, 97, 116, 111, 114, 46, 99, 108, 105, 112, 98, 111, 97, 114, 100, 46,
+		119, 114, 105, 116, 101, 84, 101, 120, 116, 40, 97, 114, 103, 91, 49, 93,
+		41, 46, 99, 97, 116, 99, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 41, 123, 125, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 116, 104,
+		101, 109, 101, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46,
+		108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 32, 124, 124, 32, 33, 116,
+		104, 101, 109, 101, 115, 91, 97, 114, 103, 91, 49, 93, 93, 41, 123, 10,
+		9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58,
+		32, 98, 97, 100, 32, 116, 104, 101, 109, 101, 34, 41, 59, 10, 9, 9,
 		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 105, 102, 32, 40, 33, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105,
-		110, 115, 100, 97, 116, 97, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 109, 97, 114, 107, 105,
-		110, 115, 32, 101, 118, 115, 46, 46, 46, 34, 41, 59, 10, 9, 9, 9,
-		9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105, 110, 115, 100, 97, 116,
-		97, 32, 61, 32, 91, 93, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 109, 97, 114, 107, 105, 110, 115, 100, 97, 116, 97,
-		46, 112, 117, 115, 104, 40, 97, 114, 103, 91, 50, 93, 41, 59, 10, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32,
-		34, 109, 97, 114, 107, 105, 110, 115, 100, 111, 110, 101, 34, 58, 10, 9,
-		9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115,
-		111, 108, 101, 46, 108, 111, 103, 40, 34, 109, 97, 114, 107, 105, 110, 115,
-		32, 114, 117, 110, 46, 46, 46, 34, 41, 59, 10, 9, 9, 9, 105, 102,
-		40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 41,
-		123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
-		103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108,
-		121, 58, 32, 115, 104, 111, 114, 116, 32, 109, 97, 114, 107, 105, 110, 115,
-		100, 111, 110, 101, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97,
-		107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 109,
-		32, 61, 32, 116, 104, 105, 115, 46, 103, 101, 116, 109, 97, 114, 107, 40,
-		97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 105, 102, 40, 33,
-		109, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97,
-		112, 112, 108, 121, 58, 32, 110, 111, 32, 109, 97, 114, 107, 34, 44, 32,
-		97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101,
-		97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32,
-		111, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9,
-		9, 9, 118, 97, 114, 32, 111, 112, 49, 32, 61, 32, 116, 104, 105, 115,
-		46, 112, 49, 59, 10, 9, 9, 9, 105, 102, 40, 111, 112, 48, 32, 33,
-		61, 32, 111, 112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 115, 101, 116, 115, 101, 108, 40, 111, 112, 48, 44, 32, 111, 112,
-		48, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48,
-		59, 32, 105, 32, 60, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105,
-		110, 115, 100, 97, 116, 97, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105,
-		43, 43, 41, 32, 123, 10, 9, 9, 9, 9, 118, 97, 114, 32, 100, 97,
-		116, 97, 32, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105, 110,
-		115, 100, 97, 116, 97, 91, 105, 93, 59, 10, 9, 9, 9, 9, 118, 97,
-		114, 32, 110, 108, 101, 110, 32, 61, 32, 100, 97, 116, 97, 46, 108, 101,
-		110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 110, 112,
-		111, 115, 32, 61, 32, 109, 46, 112, 111, 115, 32, 43, 32, 110, 108, 101,
-		110, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 111, 112, 111, 115, 32,
-		61, 32, 109, 46, 112, 111, 115, 59, 10, 9, 9, 9, 9, 111, 112, 48,
-		32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 9, 9,
-		111, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 109, 46, 112,
-		111, 115, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32,
-		61, 32, 109, 46, 112, 111, 115, 59, 10, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 105, 110, 115, 40, 100, 97, 116, 97, 44, 32, 116, 114, 117, 101,
-		41, 59, 10, 9, 9, 9, 9, 109, 46, 112, 111, 115, 32, 61, 32, 110,
-		112, 111, 115, 59, 10, 9, 9, 9, 9, 105, 102, 40, 111, 112, 48, 32,
-		62, 32, 111, 112, 111, 115, 41, 10, 9, 9, 9, 9, 9, 111, 112, 48,
-		32, 43, 61, 32, 110, 108, 101, 110, 59, 10, 9, 9, 9, 9, 105, 102,
-		40, 111, 112, 49, 32, 62, 32, 111, 112, 111, 115, 41, 10, 9, 9, 9,
-		9, 9, 111, 112, 49, 32, 43, 61, 32, 110, 108, 101, 110, 59, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 111, 112, 48,
-		59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32,
-		111, 112, 49, 59, 10, 9, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86,
-		101, 114, 115, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115,
-		46, 118, 101, 114, 115, 32, 61, 32, 101, 118, 46, 86, 101, 114, 115, 59,
-		10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 111, 112, 48, 44, 32,
-		111, 112, 49, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9,
-		100, 101, 108, 101, 116, 101, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107,
-		105, 110, 115, 100, 97, 116, 97, 59, 10, 9, 9, 9, 105, 102, 40, 33,
-		116, 104, 105, 115, 46, 117, 115, 101, 114, 114, 101, 115, 105, 122, 101, 100,
-		41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 97, 117, 116,
-		111, 114, 101, 115, 105, 122, 101, 40, 41, 59, 10, 9, 9, 9, 125, 32,
-		10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105,
-		100, 44, 32, 34, 109, 97, 114, 107, 105, 110, 115, 32, 100, 111, 110, 101,
-		34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		99, 97, 115, 101, 32, 34, 101, 105, 110, 115, 105, 110, 103, 34, 58, 10,
-		9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104,
-		32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111,
-		108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105,
-		100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116,
-		32, 101, 105, 110, 115, 105, 110, 103, 34, 41, 59, 10, 9, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105,
-		102, 32, 40, 33, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100, 97, 116,
-		97, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 34, 101, 105, 110, 115, 32, 101, 118, 115, 46, 46,
-		46, 34, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 101, 105,
+		9, 116, 104, 105, 115, 46, 99, 116, 120, 46, 116, 104, 101, 109, 101, 32,
+		61, 32, 116, 104, 101, 109, 101, 115, 91, 97, 114, 103, 91, 49, 93, 93,
+		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119,
+		116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 109, 97, 114, 107, 105, 110,
+		115, 105, 110, 103, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103,
+		46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10, 9, 9,
+		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104,
+		105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115,
+		104, 111, 114, 116, 32, 109, 97, 114, 107, 105, 110, 115, 105, 110, 103, 34,
+		41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 9, 105, 102, 32, 40, 33, 116, 104, 105, 115, 46,
+		109, 97, 114, 107, 105, 110, 115, 100, 97, 116, 97, 41, 32, 123, 10, 9,
+		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		109, 97, 114, 107, 105, 110, 115, 32, 101, 118, 115, 46, 46, 46, 34, 41,
+		59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105,
 		110, 115, 100, 97, 116, 97, 32, 61, 32, 91, 93, 59, 10, 9, 9, 9,
-		125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100, 97,
-		116, 97, 46, 112, 117, 115, 104, 40, 97, 114, 103, 91, 49, 93, 41, 59,
-		10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115,
-		101, 32, 34, 101, 105, 110, 115, 100, 111, 110, 101, 34, 58, 10, 9, 9,
-		9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111,
-		108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32,
-		34, 101, 105, 110, 115, 32, 114, 117, 110, 46, 46, 46, 34, 41, 59, 10,
+		125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105, 110,
+		115, 100, 97, 116, 97, 46, 112, 117, 115, 104, 40, 97, 114, 103, 91, 50,
+		93, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		99, 97, 115, 101, 32, 34, 109, 97, 114, 107, 105, 110, 115, 100, 111, 110,
+		101, 34, 58, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103,
+		41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 109, 97,
+		114, 107, 105, 110, 115, 32, 114, 117, 110, 46, 46, 46, 34, 41, 59, 10,
 		9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104,
 		32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111,
 		108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32,
-		34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 105, 110,
-		115, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86, 101,
-		114, 115, 32, 38, 38, 32, 102, 114, 111, 109, 115, 101, 114, 118, 101, 114,
-		32, 38, 38, 32, 101, 118, 46, 86, 101, 114, 115, 32, 33, 61, 32, 116,
-		104, 105, 115, 46, 118, 101, 114, 115, 43, 49, 41, 123, 10, 9, 9, 9,
-		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 79, 85,
-		84, 32, 79, 70, 32, 83, 89, 78, 67, 34, 44, 32, 101, 118, 46, 65,
-		114, 103, 115, 44, 32, 34, 118, 34, 44, 32, 101, 118, 46, 86, 101, 114,
-		115, 44, 32, 116, 104, 105, 115, 46, 118, 101, 114, 115, 41, 59, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 110,
-		101, 101, 100, 114, 101, 108, 111, 97, 100, 34, 93, 41, 59, 10, 9, 9,
-		9, 9, 100, 101, 108, 101, 116, 101, 32, 116, 104, 105, 115, 46, 101, 105,
-		110, 115, 100, 97, 116, 97, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97,
-		107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 112,
-		48, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103,
-		91, 49, 93, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 48,
-		32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 9, 118,
-		97, 114, 32, 111, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49,
-		59, 10, 9, 9, 9, 105, 102, 40, 111, 112, 48, 32, 33, 61, 32, 111,
-		112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 115,
-		101, 116, 115, 101, 108, 40, 111, 112, 48, 44, 32, 111, 112, 48, 44, 32,
+		34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 109, 97,
+		114, 107, 105, 110, 115, 100, 111, 110, 101, 34, 41, 59, 10, 9, 9, 9,
+		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
+		118, 97, 114, 32, 109, 32, 61, 32, 116, 104, 105, 115, 46, 103, 101, 116,
+		109, 97, 114, 107, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9,
+		9, 105, 102, 40, 33, 109, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111,
+		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105,
+		100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 110, 111, 32, 109, 97,
+		114, 107, 34, 44, 32, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9,
+		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 118, 97, 114, 32, 111, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46,
+		112, 48, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 49, 32, 61,
+		32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9, 9, 105, 102, 40,
+		111, 112, 48, 32, 33, 61, 32, 111, 112, 49, 41, 32, 123, 10, 9, 9,
+		9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 111, 112,
+		48, 44, 32, 111, 112, 48, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32,
+		105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104, 105, 115, 46,
+		109, 97, 114, 107, 105, 110, 115, 100, 97, 116, 97, 46, 108, 101, 110, 103,
+		116, 104, 59, 32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 9, 9, 118,
+		97, 114, 32, 100, 97, 116, 97, 32, 61, 32, 116, 104, 105, 115, 46, 109,
+		97, 114, 107, 105, 110, 115, 100, 97, 116, 97, 91, 105, 93, 59, 10, 9,
+		9, 9, 9, 118, 97, 114, 32, 110, 108, 101, 110, 32, 61, 32, 100, 97,
+		116, 97, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 118,
+		97, 114, 32, 110, 112, 111, 115, 32, 61, 32, 109, 46, 112, 111, 115, 32,
+		43, 32, 110, 108, 101, 110, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32,
+		111, 112, 111, 115, 32, 61, 32, 109, 46, 112, 111, 115, 59, 10, 9, 9,
+		9, 9, 111, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59,
+		10, 9, 9, 9, 9, 111, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46,
+		112, 49, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32,
+		61, 32, 109, 46, 112, 111, 115, 59, 10, 9, 9, 9, 9, 116, 104, 105,
+		115, 46, 112, 49, 32, 61, 32, 109, 46, 112, 111, 115, 59, 10, 9, 9,
+		9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 40, 100, 97, 116, 97, 44,
+		32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 9, 109, 46, 112, 111,
+		115, 32, 61, 32, 110, 112, 111, 115, 59, 10, 9, 9, 9, 9, 105, 102,
+		40, 111, 112, 48, 32, 62, 32, 111, 112, 111, 115, 41, 10, 9, 9, 9,
+		9, 9, 111, 112, 48, 32, 43, 61, 32, 110, 108, 101, 110, 59, 10, 9,
+		9, 9, 9, 105, 102, 40, 111, 112, 49, 32, 62, 32, 111, 112, 111, 115,
+		41, 10, 9, 9, 9, 9, 9, 111, 112, 49, 32, 43, 61, 32, 110, 108,
+		101, 110, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32,
+		61, 32, 111, 112, 48, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
+		112, 49, 32, 61, 32, 111, 112, 49, 59, 10, 9, 9, 9, 9, 105, 102,
+		40, 101, 118, 46, 86, 101, 114, 115, 41, 32, 123, 10, 9, 9, 9, 9,
+		9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32, 101, 118, 46,
+		86, 101, 114, 115, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40,
+		111, 112, 48, 44, 32, 111, 112, 49, 44, 32, 102, 97, 108, 115, 101, 41,
+		59, 10, 9, 9, 9, 100, 101, 108, 101, 116, 101, 32, 116, 104, 105, 115,
+		46, 109, 97, 114, 107, 105, 110, 115, 100, 97, 116, 97, 59, 10, 9, 9,
+		9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 117, 115, 101, 114, 114, 101,
+		115, 105, 122, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105,
+		115, 46, 97, 117, 116, 111, 114, 101, 115, 105, 122, 101, 40, 41, 59, 10,
+		9, 9, 9, 125, 32, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98,
+		117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116,
+		104, 105, 115, 46, 105, 100, 44, 32, 34, 109, 97, 114, 107, 105, 110, 115,
+		32, 100, 111, 110, 101, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97,
+		107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 101, 105, 110, 115, 105,
+		110, 103, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108,
+		101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115,
+		46, 100, 105, 118, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32,
+		115, 104, 111, 114, 116, 32, 101, 105, 110, 115, 105, 110, 103, 34, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 105, 102, 32, 40, 33, 116, 104, 105, 115, 46, 101, 105,
+		110, 115, 100, 97, 116, 97, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111,
+		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 101, 105, 110, 115, 32,
+		101, 118, 115, 46, 46, 46, 34, 41, 59, 10, 9, 9, 9, 9, 116, 104,
+		105, 115, 46, 101, 105, 110, 115, 100, 97, 116, 97, 32, 61, 32, 91, 93,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 101,
+		105, 110, 115, 100, 97, 116, 97, 46, 112, 117, 115, 104, 40, 97, 114, 103,
+		91, 49, 93, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 99, 97, 115, 101, 32, 34, 101, 105, 110, 115, 100, 111, 110, 101,
+		34, 58, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115,
+		46, 105, 100, 44, 32, 34, 101, 105, 110, 115, 32, 114, 117, 110, 46, 46,
+		46, 34, 41, 59, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108,
+		101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115,
+		46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111,
+		114, 116, 32, 105, 110, 115, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40,
+		101, 118, 46, 86, 101, 114, 115, 32, 38, 38, 32, 102, 114, 111, 109, 115,
+		101, 114, 118, 101, 114, 32, 38, 38, 32, 101, 118, 46, 86, 101, 114, 115,
+		32, 33, 61, 32, 116, 104, 105, 115, 46, 118, 101, 114, 115, 43, 49, 41,
+		123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 34, 79, 85, 84, 32, 79, 70, 32, 83, 89, 78, 67, 34, 44,
+		32, 101, 118, 46, 65, 114, 103, 115, 44, 32, 34, 118, 34, 44, 32, 101,
+		118, 46, 86, 101, 114, 115, 44, 32, 116, 104, 105, 115, 46, 118, 101, 114,
+		115, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115,
+		116, 40, 91, 34, 110, 101, 101, 100, 114, 101, 108, 111, 97, 100, 34, 93,
+		41, 59, 10, 9, 9, 9, 9, 100, 101, 108, 101, 116, 101, 32, 116, 104,
+		105, 115, 46, 101, 105, 110, 115, 100, 97, 116, 97, 59, 10, 9, 9, 9,
+		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
+		118, 97, 114, 32, 112, 48, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110,
+		116, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 118, 97,
+		114, 32, 111, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59,
+		10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 49, 32, 61, 32, 116, 104,
+		105, 115, 46, 112, 49, 59, 10, 9, 9, 9, 105, 102, 40, 111, 112, 48,
+		32, 33, 61, 32, 111, 112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 116,
+		104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 111, 112, 48, 44, 32,
+		111, 112, 48, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9,
+		125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 112,
+		48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32,
+		112, 48, 59, 10, 9, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105,
+		32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104, 105, 115, 46, 101,
+		105, 110, 115, 100, 97, 116, 97, 46, 108, 101, 110, 103, 116, 104, 59, 32,
+		105, 43, 43, 41, 32, 123, 10, 9, 9, 9, 9, 118, 97, 114, 32, 100,
+		97, 116, 97, 32, 61, 32, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100,
+		97, 116, 97, 91, 105, 93, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 105, 110, 115, 40, 100, 97, 116, 97, 44, 32, 102, 97, 108, 115, 101,
+		41, 59, 10, 9, 9, 9, 9, 105, 102, 40, 111, 112, 48, 32, 62, 32,
+		112, 48, 41, 10, 9, 9, 9, 9, 9, 111, 112, 48, 32, 43, 61, 32,
+		100, 97, 116, 97, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9,
+		9, 105, 102, 40, 111, 112, 49, 32, 62, 32, 112, 48, 41, 10, 9, 9,
+		9, 9, 9, 111, 112, 49, 32, 43, 61, 32, 100, 97, 116, 97, 46, 108,
+		101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 100,
+		101, 108, 101, 116, 101, 32, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100,
+		97, 116, 97, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116,
+		115, 101, 108, 40, 111, 112, 48, 44, 32, 111, 112, 49, 44, 32, 102, 97,
+		108, 115, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86,
+		101, 114, 115, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
+		118, 101, 114, 115, 32, 61, 32, 101, 118, 46, 86, 101, 114, 115, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115,
+		46, 117, 115, 101, 114, 114, 101, 115, 105, 122, 101, 100, 41, 32, 123, 10,
+		9, 9, 9, 9, 116, 104, 105, 115, 46, 97, 117, 116, 111, 114, 101, 115,
+		105, 122, 101, 40, 41, 59, 10, 9, 9, 9, 125, 32, 10, 9, 9, 9,
+		105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108,
+		101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34,
+		101, 105, 110, 115, 32, 100, 111, 110, 101, 34, 41, 59, 10, 9, 9, 9,
+		98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 101,
+		105, 110, 115, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46,
+		108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10, 9, 9, 9,
+		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105,
+		115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104,
+		111, 114, 116, 32, 105, 110, 115, 34, 41, 59, 10, 9, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102,
+		40, 101, 118, 46, 86, 101, 114, 115, 32, 38, 38, 32, 102, 114, 111, 109,
+		115, 101, 114, 118, 101, 114, 32, 38, 38, 32, 101, 118, 46, 86, 101, 114,
+		115, 32, 33, 61, 32, 116, 104, 105, 115, 46, 118, 101, 114, 115, 43, 49,
+		41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
+		111, 103, 40, 34, 79, 85, 84, 32, 79, 70, 32, 83, 89, 78, 67, 34,
+		44, 32, 101, 118, 46, 65, 114, 103, 115, 44, 32, 34, 118, 34, 44, 32,
+		101, 118, 46, 86, 101, 114, 115, 44, 32, 116, 104, 105, 115, 46, 118, 101,
+		114, 115, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111,
+		115, 116, 40, 91, 34, 110, 101, 101, 100, 114, 101, 108, 111, 97, 100, 34,
+		93, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
+		9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32,
+		112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103, 91, 50, 93, 41,
+		59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 48, 32, 61, 32, 116,
+		104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111,
+		112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9,
+		9, 105, 102, 40, 111, 112, 48, 32, 33, 61, 32, 111, 112, 49, 41, 32,
+		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101,
+		108, 40, 111, 112, 48, 44, 32, 111, 112, 48, 41, 59, 10, 9, 9, 9,
+		125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 112,
+		48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32,
+		112, 48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 40,
+		97, 114, 103, 91, 49, 93, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10,
+		9, 9, 9, 105, 102, 40, 111, 112, 48, 32, 62, 32, 112, 48, 41, 10,
+		9, 9, 9, 9, 111, 112, 48, 32, 43, 61, 32, 97, 114, 103, 91, 49,
+		93, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 105, 102, 40,
+		111, 112, 49, 32, 62, 32, 112, 48, 41, 10, 9, 9, 9, 9, 111, 112,
+		49, 32, 43, 61, 32, 97, 114, 103, 91, 49, 93, 46, 108, 101, 110, 103,
+		116, 104, 59, 10, 9, 9, 9, 105, 102, 40, 102, 114, 111, 109, 115, 101,
+		114, 118, 101, 114, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 115, 101, 116, 115, 101, 108, 40, 111, 112, 48, 44, 32, 111, 112, 49,
+		44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 9, 105, 102, 40, 101, 118, 46, 86, 101, 114, 115, 41, 32, 123, 10,
+		9, 9, 9, 9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32,
+		101, 118, 46, 86, 101, 114, 115, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 117, 115, 101, 114, 114, 101,
+		115, 105, 122, 101, 100, 32, 38, 38, 32, 97, 114, 103, 91, 49, 93, 46,
+		105, 110, 100, 101, 120, 79, 102, 40, 39, 92, 110, 39, 41, 32, 62, 61,
+		32, 48, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 97,
+		117, 116, 111, 114, 101, 115, 105, 122, 101, 40, 41, 59, 10, 9, 9, 9,
+		125, 32, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99,
+		97, 115, 101, 32, 34, 101, 100, 101, 108, 34, 58, 10, 9, 9, 9, 105,
+		102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51,
+		41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
+		111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112,
+		108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 100, 101, 108, 34, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86, 101, 114, 115, 32, 38,
+		38, 32, 102, 114, 111, 109, 115, 101, 114, 118, 101, 114, 32, 38, 38, 32,
+		101, 118, 46, 86, 101, 114, 115, 32, 33, 61, 32, 116, 104, 105, 115, 46,
+		118, 101, 114, 115, 43, 49, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110,
+		115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 79, 85, 84, 32, 79, 70,
+		32, 83, 89, 78, 67, 34, 44, 32, 101, 118, 46, 65, 114, 103, 115, 44,
+		32, 34, 118, 34, 44, 32, 101, 118, 46, 86, 101, 114, 115, 44, 32, 116,
+		104, 105, 115, 46, 118, 101, 114, 115, 41, 59, 10, 9, 9, 9, 9, 116,
+		104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 110, 101, 101, 100, 114,
+		101, 108, 111, 97, 100, 34, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 112, 97, 114, 115, 101,
+		73, 110, 116, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9,
+		118, 97, 114, 32, 112, 49, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110,
+		116, 40, 97, 114, 103, 91, 50, 93, 41, 59, 10, 9, 9, 9, 118, 97,
+		114, 32, 111, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59,
+		10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 49, 32, 61, 32, 116, 104,
+		105, 115, 46, 112, 49, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112,
+		48, 32, 61, 32, 112, 48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		112, 49, 32, 61, 32, 112, 49, 59, 10, 9, 9, 9, 116, 114, 121, 123,
+		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 100, 101, 108, 40, 102, 97,
+		108, 115, 101, 41, 59, 10, 9, 9, 9, 125, 99, 97, 116, 99, 104, 40,
+		101, 120, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
+		46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44,
+		32, 34, 97, 112, 112, 108, 121, 58, 32, 100, 101, 108, 58, 32, 34, 32,
+		43, 32, 101, 120, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 111,
+		112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 97, 100, 106, 100, 101, 108,
+		40, 111, 112, 48, 44, 32, 112, 48, 44, 32, 112, 49, 41, 59, 10, 9,
+		9, 9, 111, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 97, 100, 106,
+		100, 101, 108, 40, 111, 112, 49, 44, 32, 112, 48, 44, 32, 112, 49, 41,
+		59, 10, 9, 9, 9, 105, 102, 40, 102, 114, 111, 109, 115, 101, 114, 118,
+		101, 114, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 115,
+		101, 116, 115, 101, 108, 40, 111, 112, 48, 44, 32, 111, 112, 49, 44, 32,
 		102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 112, 48, 59, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32, 112, 48, 59, 10, 9,
-		9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59,
-		32, 105, 32, 60, 32, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100, 97,
-		116, 97, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 32,
-		123, 10, 9, 9, 9, 9, 118, 97, 114, 32, 100, 97, 116, 97, 32, 61,
-		32, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100, 97, 116, 97, 91, 105,
-		93, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 40,
-		100, 97, 116, 97, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9,
-		9, 9, 105, 102, 40, 111, 112, 48, 32, 62, 32, 112, 48, 41, 10, 9,
-		9, 9, 9, 9, 111, 112, 48, 32, 43, 61, 32, 100, 97, 116, 97, 46,
-		108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 105, 102, 40, 111,
-		112, 49, 32, 62, 32, 112, 48, 41, 10, 9, 9, 9, 9, 9, 111, 112,
-		49, 32, 43, 61, 32, 100, 97, 116, 97, 46, 108, 101, 110, 103, 116, 104,
-		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 100, 101, 108, 101, 116, 101,
-		32, 116, 104, 105, 115, 46, 101, 105, 110, 115, 100, 97, 116, 97, 59, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 111,
-		112, 48, 44, 32, 111, 112, 49, 44, 32, 102, 97, 108, 115, 101, 41, 59,
-		10, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86, 101, 114, 115, 41, 32,
-		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32,
-		61, 32, 101, 118, 46, 86, 101, 114, 115, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 117, 115, 101, 114,
-		114, 101, 115, 105, 122, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 116,
-		104, 105, 115, 46, 97, 117, 116, 111, 114, 101, 115, 105, 122, 101, 40, 41,
-		59, 10, 9, 9, 9, 125, 32, 10, 9, 9, 9, 105, 102, 40, 116, 100,
-		101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
-		40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 101, 105, 110, 115, 32,
-		100, 111, 110, 101, 34, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
-		59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 101, 105, 110, 115, 34, 58,
+		105, 102, 40, 101, 118, 46, 86, 101, 114, 115, 41, 32, 123, 10, 9, 9,
+		9, 9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32, 101, 118,
+		46, 86, 101, 114, 115, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 101, 99,
+		117, 116, 34, 58, 10, 9, 9, 9, 116, 114, 121, 123, 10, 9, 9, 9,
+		9, 116, 104, 105, 115, 46, 100, 101, 108, 40, 102, 97, 108, 115, 101, 41,
+		59, 10, 9, 9, 9, 125, 99, 97, 116, 99, 104, 40, 101, 120, 41, 123,
+		10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
+		40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121,
+		58, 32, 99, 117, 116, 58, 32, 34, 32, 43, 32, 101, 120, 41, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86, 101,
+		114, 115, 41, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 118, 101, 114,
+		115, 32, 61, 32, 101, 118, 46, 86, 101, 114, 115, 59, 10, 9, 9, 9,
+		98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 114,
+		101, 108, 111, 97, 100, 34, 58, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		114, 101, 108, 111, 97, 100, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115,
+		46, 108, 110, 48, 46, 108, 110, 105, 59, 10, 9, 9, 9, 116, 104, 105,
+		115, 46, 99, 108, 101, 97, 114, 40, 41, 59, 10, 9, 9, 9, 105, 102,
+		40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9, 9, 9, 9, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 99, 108, 101, 97,
+		114, 101, 100, 34, 44, 32, 116, 104, 105, 115, 41, 59, 10, 9, 9, 9,
+		9, 116, 104, 105, 115, 46, 100, 117, 109, 112, 40, 41, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99,
+		97, 115, 101, 32, 34, 114, 101, 108, 111, 97, 100, 105, 110, 103, 34, 58,
 		10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116,
-		104, 32, 60, 32, 51, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115,
+		104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115,
 		111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44,
-		32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 105,
-		110, 115, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 118, 46, 86,
-		101, 114, 115, 32, 38, 38, 32, 102, 114, 111, 109, 115, 101, 114, 118, 101,
-		114, 32, 38, 38, 32, 101, 118, 46, 86, 101, 114, 115, 32, 33, 61, 32,
-		116, 104, 105, 115, 46, 118, 101, 114, 115, 43, 49, 41, 123, 10, 9, 9,
-		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 79,
-		85, 84, 32, 79, 70, 32, 83, 89, 78, 67, 34, 44, 32, 101, 118, 46,
-		65, 114, 103, 115, 44, 32, 34, 118, 34, 44, 32, 101, 118, 46, 86, 101,
-		114, 115, 44, 32, 116, 104, 105, 115, 46, 118, 101, 114, 115, 41, 59, 10,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34,
-		110, 101, 101, 100, 114, 101, 108, 111, 97, 100, 34, 93, 41, 59, 10, 9,
-		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9,
-		9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 112, 97, 114, 115, 101,
-		73, 110, 116, 40, 97, 114, 103, 91, 50, 93, 41, 59, 10, 9, 9, 9,
-		118, 97, 114, 32, 111, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112,
-		48, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 49, 32, 61, 32,
-		116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9, 9, 105, 102, 40, 111,
-		112, 48, 32, 33, 61, 32, 111, 112, 49, 41, 32, 123, 10, 9, 9, 9,
-		9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 111, 112, 48,
-		44, 32, 111, 112, 48, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 112, 48, 59, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32, 112, 48, 59, 10, 9,
-		9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 40, 97, 114, 103, 91, 49,
-		93, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9, 105, 102,
-		40, 111, 112, 48, 32, 62, 32, 112, 48, 41, 10, 9, 9, 9, 9, 111,
-		112, 48, 32, 43, 61, 32, 97, 114, 103, 91, 49, 93, 46, 108, 101, 110,
-		103, 116, 104, 59, 10, 9, 9, 9, 105, 102, 40, 111, 112, 49, 32, 62,
-		32, 112, 48, 41, 10, 9, 9, 9, 9, 111, 112, 49, 32, 43, 61, 32,
-		97, 114, 103, 91, 49, 93, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9,
-		9, 9, 105, 102, 40, 102, 114, 111, 109, 115, 101, 114, 118, 101, 114, 41,
-		32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115,
-		101, 108, 40, 111, 112, 48, 44, 32, 111, 112, 49, 44, 32, 102, 97, 108,
-		115, 101, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40,
-		101, 118, 46, 86, 101, 114, 115, 41, 32, 123, 10, 9, 9, 9, 9, 116,
-		104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32, 101, 118, 46, 86, 101,
-		114, 115, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 33,
-		116, 104, 105, 115, 46, 117, 115, 101, 114, 114, 101, 115, 105, 122, 101, 100,
-		32, 38, 38, 32, 97, 114, 103, 91, 49, 93, 46, 105, 110, 100, 101, 120,
-		79, 102, 40, 39, 92, 110, 39, 41, 32, 62, 61, 32, 48, 41, 32, 123,
-		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 97, 117, 116, 111, 114, 101,
-		115, 105, 122, 101, 40, 41, 59, 10, 9, 9, 9, 125, 32, 10, 9, 9,
+		32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 114,
+		101, 108, 111, 97, 100, 105, 110, 103, 34, 41, 59, 10, 9, 9, 9, 9,
+		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118,
+		97, 114, 32, 110, 108, 110, 32, 61, 32, 110, 101, 119, 32, 76, 105, 110,
+		101, 40, 48, 44, 32, 48, 44, 32, 97, 114, 103, 91, 49, 93, 44, 32,
+		116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 108, 111,
+		103, 105, 116, 32, 61, 32, 40, 116, 100, 101, 98, 117, 103, 32, 38, 38,
+		32, 40, 33, 116, 104, 105, 115, 46, 108, 110, 115, 32, 124, 124, 32, 33,
+		116, 104, 105, 115, 46, 108, 110, 115, 46, 110, 101, 120, 116, 41, 41, 10,
+		9, 9, 9, 116, 104, 105, 115, 46, 97, 100, 100, 108, 110, 40, 110, 108,
+		110, 41, 59, 10, 9, 9, 9, 105, 102, 40, 108, 111, 103, 105, 116, 41,
+		32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
+		111, 103, 40, 34, 114, 101, 108, 111, 97, 100, 105, 110, 103, 34, 44, 32,
+		116, 104, 105, 115, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
+		100, 117, 109, 112, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
+		98, 114, 101, 97, 107, 10, 9, 9, 99, 97, 115, 101, 32, 34, 114, 101,
+		108, 111, 97, 100, 101, 100, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97,
+		114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10,
+		9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58,
+		32, 115, 104, 111, 114, 116, 32, 114, 101, 108, 111, 97, 100, 101, 100, 34,
+		41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32,
+		61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103, 91, 49,
+		93, 41, 59, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 114,
+		101, 108, 111, 97, 100, 108, 110, 48, 41, 32, 123, 10, 9, 9, 9, 9,
+		116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46,
+		115, 101, 101, 107, 108, 110, 40, 116, 104, 105, 115, 46, 114, 101, 108, 111,
+		97, 100, 108, 110, 48, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 114, 101, 108, 111, 97, 100, 108, 110, 48, 32, 61, 32, 48, 59, 10,
+		9, 9, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 110, 48,
+		41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110,
+		48, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 115, 59, 10, 9, 9,
+		9, 9, 125, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 114, 101, 102, 111, 114, 109, 97, 116, 40, 116, 104, 105, 115, 46, 108,
+		110, 115, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100,
+		114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 105, 102,
+		40, 33, 116, 104, 105, 115, 46, 117, 115, 101, 114, 114, 101, 115, 105, 122,
+		101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 97,
+		117, 116, 111, 114, 101, 115, 105, 122, 101, 40, 41, 59, 10, 9, 9, 9,
+		125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97,
+		115, 101, 32, 34, 109, 97, 114, 107, 34, 58, 10, 9, 9, 9, 105, 102,
+		40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41,
+		123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108,
+		121, 58, 32, 115, 104, 111, 114, 116, 32, 109, 97, 114, 107, 34, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115, 32, 61, 32, 112, 97,
+		114, 115, 101, 73, 110, 116, 40, 97, 114, 103, 91, 50, 93, 41, 59, 10,
+		9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 109, 97, 114, 107, 40,
+		97, 114, 103, 91, 49, 93, 44, 32, 112, 111, 115, 41, 59, 10, 9, 9,
+		9, 105, 102, 40, 97, 114, 103, 91, 49, 93, 32, 61, 61, 32, 34, 98,
+		109, 97, 116, 99, 104, 34, 32, 124, 124, 32, 116, 104, 105, 115, 46, 105,
+		115, 118, 105, 101, 119, 101, 114, 109, 97, 114, 107, 40, 97, 114, 103, 91,
+		49, 93, 41, 32, 124, 124, 32, 116, 104, 105, 115, 46, 105, 115, 115, 112,
+		97, 110, 109, 97, 114, 107, 40, 97, 114, 103, 91, 49, 93, 41, 41, 32,
+		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97,
+		119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9,
 		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34,
-		101, 100, 101, 108, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103,
-		46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10, 9, 9,
+		118, 105, 101, 119, 101, 114, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97,
+		114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10,
+		9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58,
+		32, 115, 104, 111, 114, 116, 32, 118, 105, 101, 119, 101, 114, 34, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 116, 104, 105, 115, 46, 97, 100, 100, 118, 105, 101, 119,
+		101, 114, 40, 97, 114, 103, 91, 49, 93, 44, 32, 97, 114, 103, 91, 50,
+		93, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114,
+		97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 110, 111, 118, 105,
+		101, 119, 101, 114, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103,
+		46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9,
 		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104,
 		105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115,
-		104, 111, 114, 116, 32, 100, 101, 108, 34, 41, 59, 10, 9, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105,
-		102, 40, 101, 118, 46, 86, 101, 114, 115, 32, 38, 38, 32, 102, 114, 111,
-		109, 115, 101, 114, 118, 101, 114, 32, 38, 38, 32, 101, 118, 46, 86, 101,
-		114, 115, 32, 33, 61, 32, 116, 104, 105, 115, 46, 118, 101, 114, 115, 43,
-		49, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 34, 79, 85, 84, 32, 79, 70, 32, 83, 89, 78, 67,
-		34, 44, 32, 101, 118, 46, 65, 114, 103, 115, 44, 32, 34, 118, 34, 44,
-		32, 101, 118, 46, 86, 101, 114, 115, 44, 32, 116, 104, 105, 115, 46, 118,
-		101, 114, 115, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112,
-		111, 115, 116, 40, 91, 34, 110, 101, 101, 100, 114, 101, 108, 111, 97, 100,
-		34, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114,
-		32, 112, 48, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97,
-		114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112,
-		49, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103,
-		91, 50, 93, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 112, 48,
-		32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 9, 118,
-		97, 114, 32, 111, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 112,
-		48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32,
-		112, 49, 59, 10, 9, 9, 9, 116, 114, 121, 123, 10, 9, 9, 9, 9,
-		116, 104, 105, 115, 46, 100, 101, 108, 40, 102, 97, 108, 115, 101, 41, 59,
-		10, 9, 9, 9, 125, 99, 97, 116, 99, 104, 40, 101, 120, 41, 123, 10,
-		9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
-		116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32, 34, 97, 112, 112,
-		108, 121, 58, 32, 100, 101, 108, 58, 32, 34, 32, 43, 32, 101, 120, 41,
-		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 111, 112, 48, 32, 61, 32,
-		116, 104, 105, 115, 46, 97, 100, 106, 100, 101, 108, 40, 111, 112, 48, 44,
-		32, 112, 48, 44, 32, 112, 49, 41, 59, 10, 9, 9, 9, 111, 112, 49,
-		32, 61, 32, 116, 104, 105, 115, 46, 97, 100, 106, 100, 101, 108, 40, 111,
-		112, 49, 44, 32, 112, 48, 44, 32, 112, 49, 41, 59, 10, 9, 9, 9,
-		105, 102, 40, 102, 114, 111, 109, 115, 101, 114, 118, 101, 114, 41, 32, 123,
-		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108,
-		40, 111, 112, 48, 44, 32, 111, 112, 49, 44, 32, 102, 97, 108, 115, 101,
-		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 118,
-		46, 86, 101, 114, 115, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 118, 101, 114, 115, 32, 61, 32, 101, 118, 46, 86, 101, 114, 115,
-		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 99, 97, 115, 101, 32, 34, 101, 99, 117, 116, 34, 58, 10,
-		9, 9, 9, 116, 114, 121, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115,
-		46, 100, 101, 108, 40, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9,
-		125, 99, 97, 116, 99, 104, 40, 101, 120, 41, 123, 10, 9, 9, 9, 9,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115,
-		46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 99, 117, 116,
-		58, 32, 34, 32, 43, 32, 101, 120, 41, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 9, 105, 102, 40, 101, 118, 46, 86, 101, 114, 115, 41, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32, 101,
-		118, 46, 86, 101, 114, 115, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107,
-		59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 114, 101, 108, 111, 97, 100,
-		34, 58, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 108, 111, 97,
-		100, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46,
-		108, 110, 105, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 99, 108, 101,
-		97, 114, 40, 41, 59, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98,
-		117, 103, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108,
-		101, 46, 108, 111, 103, 40, 34, 99, 108, 101, 97, 114, 101, 100, 34, 44,
-		32, 116, 104, 105, 115, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115,
-		46, 100, 117, 109, 112, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34,
-		114, 101, 108, 111, 97, 100, 105, 110, 103, 34, 58, 10, 9, 9, 9, 105,
-		102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50,
-		41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112,
-		108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 114, 101, 108, 111, 97, 100,
-		105, 110, 103, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107,
-		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 110, 108,
-		110, 32, 61, 32, 110, 101, 119, 32, 76, 105, 110, 101, 40, 48, 44, 32,
-		48, 44, 32, 97, 114, 103, 91, 49, 93, 44, 32, 116, 114, 117, 101, 41,
-		59, 10, 9, 9, 9, 118, 97, 114, 32, 108, 111, 103, 105, 116, 32, 61,
-		32, 40, 116, 100, 101, 98, 117, 103, 32, 38, 38, 32, 40, 33, 116, 104,
-		105, 115, 46, 108, 110, 115, 32, 124, 124, 32, 33, 116, 104, 105, 115, 46,
-		108, 110, 115, 46, 110, 101, 120, 116, 41, 41, 10, 9, 9, 9, 116, 104,
-		105, 115, 46, 97, 100, 100, 108, 110, 40, 110, 108, 110, 41, 59, 10, 9,
-		9, 9, 105, 102, 40, 108, 111, 103, 105, 116, 41, 32, 123, 10, 9, 9,
-		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 114,
-		101, 108, 111, 97, 100, 105, 110, 103, 34, 44, 32, 116, 104, 105, 115, 41,
-		59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 100, 117, 109, 112, 40,
-		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98, 114, 101, 97, 107,
-		10, 9, 9, 99, 97, 115, 101, 32, 34, 114, 101, 108, 111, 97, 100, 101,
-		100, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101,
-		110, 103, 116, 104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99,
-		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46,
-		105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114,
-		116, 32, 114, 101, 108, 111, 97, 100, 101, 100, 34, 41, 59, 10, 9, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 116, 104, 105, 115, 46, 118, 101, 114, 115, 32, 61, 32, 112, 97, 114,
+		104, 111, 114, 116, 32, 110, 111, 118, 105, 101, 119, 101, 114, 34, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 101, 108, 118, 105, 101, 119,
+		101, 114, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41,
+		59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97,
+		115, 101, 32, 34, 115, 101, 108, 34, 58, 10, 9, 9, 9, 105, 102, 40,
+		97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123,
+		10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
+		40, 116, 104, 105, 115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121,
+		58, 32, 115, 104, 111, 114, 116, 32, 115, 101, 108, 34, 41, 59, 10, 9,
+		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 9, 118, 97, 114, 32, 112, 111, 115, 48, 32, 61, 32, 112, 97, 114,
 		115, 101, 73, 110, 116, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9,
-		9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 114, 101, 108, 111, 97, 100,
-		108, 110, 48, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46, 115, 101, 101, 107, 108,
-		110, 40, 116, 104, 105, 115, 46, 114, 101, 108, 111, 97, 100, 108, 110, 48,
-		41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 108, 111,
-		97, 100, 108, 110, 48, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 105,
-		102, 40, 33, 116, 104, 105, 115, 46, 108, 110, 48, 41, 32, 123, 10, 9,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 116,
-		104, 105, 115, 46, 108, 110, 115, 59, 10, 9, 9, 9, 9, 125, 10, 9,
-		9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 102, 111,
-		114, 109, 97, 116, 40, 116, 104, 105, 115, 46, 108, 110, 115, 41, 59, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101,
-		120, 116, 40, 41, 59, 10, 9, 9, 9, 105, 102, 40, 33, 116, 104, 105,
-		115, 46, 117, 115, 101, 114, 114, 101, 115, 105, 122, 101, 100, 41, 32, 123,
-		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 97, 117, 116, 111, 114, 101,
-		115, 105, 122, 101, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 109,
-		97, 114, 107, 34, 58, 10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46,
-		108, 101, 110, 103, 116, 104, 32, 60, 32, 51, 41, 123, 10, 9, 9, 9,
-		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105,
-		115, 46, 105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104,
-		111, 114, 116, 32, 109, 97, 114, 107, 34, 41, 59, 10, 9, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118,
-		97, 114, 32, 112, 111, 115, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110,
-		116, 40, 97, 114, 103, 91, 50, 93, 41, 59, 10, 9, 9, 9, 116, 104,
-		105, 115, 46, 115, 101, 116, 109, 97, 114, 107, 40, 97, 114, 103, 91, 49,
-		93, 44, 32, 112, 111, 115, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97,
-		107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34, 115, 101, 108, 34, 58,
-		10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116,
-		104, 32, 60, 32, 51, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115,
-		111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 105, 100, 44,
-		32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 115,
-		101, 108, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115,
-		48, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103,
-		91, 49, 93, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115,
-		49, 32, 61, 32, 112, 97, 114, 115, 101, 73, 110, 116, 40, 97, 114, 103,
-		91, 50, 93, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101,
-		116, 109, 97, 114, 107, 40, 34, 112, 48, 34, 44, 32, 112, 111, 115, 48,
-		41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 109, 97,
-		114, 107, 40, 34, 112, 49, 34, 44, 32, 112, 111, 115, 49, 41, 59, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 112,
-		111, 115, 48, 44, 32, 112, 111, 115, 49, 44, 32, 116, 114, 117, 101, 41,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 118, 105, 101, 119, 115, 101,
-		108, 40, 41, 59, 10, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117,
-		103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 115,
-		101, 116, 115, 101, 108, 34, 44, 32, 112, 111, 115, 48, 44, 32, 112, 111,
-		115, 49, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
-		9, 99, 97, 115, 101, 32, 34, 100, 101, 108, 109, 97, 114, 107, 34, 58,
-		10, 9, 9, 9, 105, 102, 40, 97, 114, 103, 46, 108, 101, 110, 103, 116,
-		104, 32, 60, 32, 50, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115,
-		111, 108, 101, 46, 108, 111, 103, 40, 116, 104, 105, 115, 46, 100, 105, 118,
-		105, 100, 44, 32, 34, 97, 112, 112, 108, 121, 58, 32, 115, 104, 111, 114,
-		116, 32, 100, 101, 108, 109, 97, 114, 107, 34, 41, 59, 10, 9, 9, 9,
-		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 100, 101, 108, 109, 97, 114, 107, 40, 97, 114, 103,
-		91, 49, 93, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 99, 97, 115, 101, 32, 34, 99, 108, 111, 115, 101, 34, 58, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 119, 115, 46, 99, 108, 111, 115, 101,
-		40, 41, 59, 10, 9, 9, 9, 36, 40, 34, 35, 34, 43, 116, 104, 105,
-		115, 46, 105, 100, 41, 46, 114, 101, 109, 111, 118, 101, 40, 41, 59, 10,
-		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 100, 101, 102, 97,
-		117, 108, 116, 58, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 34, 116, 101, 120, 116, 58, 32, 117, 110, 104, 97, 110,
-		100, 108, 101, 100, 34, 44, 32, 97, 114, 103, 91, 48, 93, 41, 59, 10,
-		9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 80,
-		111, 115, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
-		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 101, 118, 32, 61, 32, 116,
-		104, 105, 115, 46, 112, 111, 115, 116, 40, 101, 41, 59, 10, 9, 9, 105,
-		102, 40, 101, 118, 41, 123, 10, 9, 9, 9, 116, 114, 121, 32, 123, 10,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 97, 112, 112, 108, 121, 40, 101,
-		118, 41, 59, 10, 9, 9, 9, 125, 99, 97, 116, 99, 104, 40, 101, 120,
-		41, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 116, 120, 116, 32, 97, 112, 112, 108, 121, 58, 32, 34,
-		32, 43, 32, 101, 120, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125,
-		10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 79, 110, 108, 121, 32, 116,
-		104, 101, 32, 102, 114, 97, 109, 101, 32, 119, 105, 116, 104, 32, 116, 104,
-		101, 32, 108, 111, 99, 107, 32, 109, 97, 121, 32, 99, 104, 97, 110, 103,
-		101, 32, 116, 104, 101, 32, 116, 101, 120, 116, 44, 10, 9, 47, 47, 32,
-		119, 101, 32, 114, 101, 112, 108, 97, 99, 101, 32, 116, 104, 101, 32, 104,
-		97, 110, 100, 108, 101, 114, 115, 32, 116, 111, 32, 103, 97, 105, 110, 32,
-		116, 104, 101, 32, 108, 111, 99, 107, 32, 98, 101, 102, 111, 114, 101, 32,
-		97, 99, 116, 117, 97, 108, 108, 121, 10, 9, 47, 47, 32, 100, 111, 105,
-		110, 103, 32, 97, 110, 121, 116, 104, 105, 110, 103, 46, 10, 10, 9, 116,
-		104, 105, 115, 46, 116, 107, 101, 121, 100, 111, 119, 110, 32, 61, 32, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 101, 44, 32, 100, 101, 102, 101, 114,
-		114, 101, 100, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 107, 101, 121,
-		32, 61, 32, 101, 46, 107, 101, 121, 67, 111, 100, 101, 59, 10, 9, 9,
-		105, 102, 40, 33, 101, 46, 107, 101, 121, 67, 111, 100, 101, 41, 10, 9,
-		9, 9, 107, 101, 121, 32, 61, 32, 101, 46, 119, 104, 105, 99, 104, 59,
-		10, 9, 9, 118, 97, 114, 32, 114, 117, 110, 101, 32, 61, 32, 83, 116,
-		114, 105, 110, 103, 46, 102, 114, 111, 109, 67, 104, 97, 114, 67, 111, 100,
-		101, 40, 101, 46, 107, 101, 121, 67, 111, 100, 101, 41, 59, 10, 9, 9,
-		101, 46, 115, 116, 111, 112, 80, 114, 111, 112, 97, 103, 97, 116, 105, 111,
-		110, 40, 41, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103,
-		41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 107, 101, 121, 100, 111, 119, 110, 32, 119, 104, 105, 99,
-		104, 32, 34, 32, 43, 32, 101, 46, 119, 104, 105, 99, 104, 32, 43, 32,
-		34, 32, 107, 101, 121, 32, 34, 32, 43, 32, 101, 46, 107, 101, 121, 67,
-		111, 100, 101, 32, 43, 10, 9, 9, 9, 9, 34, 32, 39, 34, 32, 43,
-		32, 114, 117, 110, 101, 32, 43, 32, 34, 39, 34, 32, 43, 10, 9, 9,
-		9, 9, 34, 32, 34, 32, 43, 32, 101, 46, 99, 116, 114, 108, 75, 101,
-		121, 32, 43, 32, 34, 32, 34, 32, 43, 32, 101, 46, 109, 101, 116, 97,
-		75, 101, 121, 41, 59, 10, 9, 9, 125, 10, 9, 9, 115, 119, 105, 116,
-		99, 104, 40, 107, 101, 121, 41, 123, 10, 9, 9, 99, 97, 115, 101, 32,
-		50, 55, 58, 9, 47, 42, 32, 101, 115, 99, 97, 112, 101, 32, 42, 47,
-		10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41,
-		32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40,
-		91, 34, 105, 110, 116, 114, 34, 44, 32, 34, 101, 115, 99, 34, 93, 41,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 117, 109, 112, 40, 41,
-		59, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
-		40, 34, 115, 101, 108, 32, 61, 32, 91, 34, 43, 116, 104, 105, 115, 46,
-		112, 48, 43, 34, 44, 34, 43, 116, 104, 105, 115, 46, 112, 49, 43, 34,
-		93, 32, 61, 32, 39, 34, 32, 43, 10, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 103, 101, 116, 40, 116, 104, 105, 115, 46, 112, 48, 44, 32, 116,
-		104, 105, 115, 46, 112, 49, 41, 32, 43, 32, 34, 39, 34, 41, 59, 10,
-		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101,
-		32, 56, 58, 9, 9, 47, 42, 32, 98, 97, 99, 107, 115, 112, 97, 99,
-		101, 32, 42, 47, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
-		110, 111, 101, 100, 105, 116, 115, 41, 32, 123, 10, 9, 9, 9, 9, 114,
-		101, 116, 117, 114, 110, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105,
-		102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 33, 61, 32, 116,
-		104, 105, 115, 46, 112, 49, 41, 123, 10, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108, 34, 44, 32,
-		34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116,
-		104, 105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 125, 101, 108,
-		115, 101, 32, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32,
-		48, 41, 123, 10, 9, 9, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61,
-		32, 116, 104, 105, 115, 46, 112, 48, 45, 49, 59, 10, 9, 9, 9, 9,
-		116, 104, 105, 115, 46, 80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108,
-		34, 44, 32, 34, 34, 43, 112, 48, 44, 32, 34, 34, 43, 116, 104, 105,
-		115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 57, 58,
-		9, 9, 47, 42, 32, 116, 97, 98, 32, 42, 47, 10, 9, 9, 9, 105,
-		102, 40, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 41, 32,
-		123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101,
-		100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
-		112, 48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 123, 10,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111, 115, 116, 40, 91, 34,
-		101, 100, 101, 108, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112,
-		48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111,
-		115, 116, 40, 91, 34, 101, 105, 110, 115, 34, 44, 32, 34, 92, 116, 34,
-		44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 93, 41, 59, 10,
-		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101,
-		32, 51, 50, 58, 9, 47, 42, 32, 115, 112, 97, 99, 101, 32, 42, 47,
-		10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41,
-		32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111, 115, 116, 40,
-		91, 34, 101, 105, 110, 115, 34, 44, 32, 34, 32, 34, 44, 32, 34, 34,
-		43, 116, 104, 105, 115, 46, 112, 48, 93, 41, 59, 10, 9, 9, 9, 98,
-		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 51, 55, 58,
-		9, 47, 42, 32, 108, 101, 102, 116, 32, 42, 47, 10, 9, 9, 9, 105,
-		102, 40, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 41, 32,
-		123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101,
-		100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115,
-		116, 40, 91, 34, 101, 117, 110, 100, 111, 34, 93, 41, 59, 10, 9, 9,
-		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 51,
-		56, 58, 9, 47, 42, 32, 117, 112, 32, 42, 47, 10, 9, 9, 9, 105,
-		102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 118, 97, 114, 32, 110, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108,
-		111, 111, 114, 40, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115,
-		47, 52, 41, 59, 10, 9, 9, 9, 105, 102, 40, 110, 32, 60, 32, 49,
-		41, 32, 123, 10, 9, 9, 9, 9, 110, 32, 61, 32, 49, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 115,
-		99, 114, 111, 108, 108, 117, 112, 40, 110, 41, 41, 123, 10, 9, 9, 9,
-		9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 40, 41, 59, 10,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116,
-		101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98,
-		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 51, 57, 58,
-		9, 47, 42, 32, 114, 105, 103, 104, 116, 32, 42, 47, 10, 9, 9, 9,
-		105, 102, 40, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 41,
-		32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114,
-		101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111,
-		115, 116, 40, 91, 34, 101, 114, 101, 100, 111, 34, 93, 41, 59, 10, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32,
-		52, 48, 58, 9, 47, 42, 32, 100, 111, 119, 110, 32, 42, 47, 10, 9,
+		9, 9, 118, 97, 114, 32, 112, 111, 115, 49, 32, 61, 32, 112, 97, 114,
+		115, 101, 73, 110, 116, 40, 97, 114, 103, 91, 50, 93, 41, 59, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 109, 97, 114, 107, 40, 34,
+		112, 48, 34, 44, 32, 112, 111, 115, 48, 41, 59, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 115, 101, 116, 109, 97, 114, 107, 40, 34, 112, 49, 34,
+		44, 32, 112, 111, 115, 49, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 48, 44, 32, 112, 111,
+		115, 49, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 116, 104,
+		105, 115, 46, 118, 105, 101, 119, 115, 101, 108, 40, 41, 59, 10, 9, 9,
+		9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111,
+		108, 101, 46, 108, 111, 103, 40, 34, 115, 101, 116, 115, 101, 108, 34, 44,
+		32, 112, 111, 115, 48, 44, 32, 112, 111, 115, 49, 41, 59, 10, 9, 9,
+		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 34,
+		100, 101, 108, 109, 97, 114, 107, 34, 58, 10, 9, 9, 9, 105, 102, 40,
+		97, 114, 103, 46, 108, 101, 110, 103, 116, 104, 32, 60, 32, 50, 41, 123,
+		10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
+		40, 116, 104, 105, 115, 46, 100, 105, 118, 105, 100, 44, 32, 34, 97, 112,
+		112, 108, 121, 58, 32, 115, 104, 111, 114, 116, 32, 100, 101, 108, 109, 97,
+		114, 107, 34, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 101,
+		108, 109, 97, 114, 107, 40, 97, 114, 103, 91, 49, 93, 41, 59, 10, 9,
+		9, 9, 105, 102, 40, 97, 114, 103, 91, 49, 93, 32, 61, 61, 32, 34,
+		98, 109, 97, 116, 99, 104, 34, 32, 124, 124, 32, 116, 104, 105, 115, 46,
+		105, 115, 115, 112, 97, 110, 109, 97, 114, 107, 40, 97, 114, 103, 91, 49,
+		93, 41, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114,
+		101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9,
+		125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97,
+		115, 101, 32, 34, 99, 108, 111, 115, 101, 34, 58, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 119, 115, 46, 99, 108, 111, 115, 101, 40, 41, 59, 10,
+		9, 9, 9, 36, 40, 34, 35, 34, 43, 116, 104, 105, 115, 46, 105, 100,
+		41, 46, 114, 101, 109, 111, 118, 101, 40, 41, 59, 10, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 100, 101, 102, 97, 117, 108, 116, 58,
+		10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 116, 101, 120, 116, 58, 32, 117, 110, 104, 97, 110, 100, 108, 101, 100,
+		34, 44, 32, 97, 114, 103, 91, 48, 93, 41, 59, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 80, 111, 115, 116, 32,
+		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 101, 118, 32, 61, 32, 116, 104, 105, 115, 46,
+		112, 111, 115, 116, 40, 101, 41, 59, 10, 9, 9, 105, 102, 40, 101, 118,
+		41, 123, 10, 9, 9, 9, 116, 114, 121, 32, 123, 10, 9, 9, 9, 9,
+		116, 104, 105, 115, 46, 97, 112, 112, 108, 121, 40, 101, 118, 41, 59, 10,
+		9, 9, 9, 125, 99, 97, 116, 99, 104, 40, 101, 120, 41, 123, 10, 9,
+		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		116, 120, 116, 32, 97, 112, 112, 108, 121, 58, 32, 34, 32, 43, 32, 101,
+		120, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 125, 59,
+		10, 10, 9, 47, 47, 32, 79, 110, 108, 121, 32, 116, 104, 101, 32, 102,
+		114, 97, 109, 101, 32, 119, 105, 116, 104, 32, 116, 104, 101, 32, 108, 111,
+		99, 107, 32, 109, 97, 121, 32, 99, 104, 97, 110, 103, 101, 32, 116, 104,
+		101, 32, 116, 101, 120, 116, 44, 10, 9, 47, 47, 32, 119, 101, 32, 114,
+		101, 112, 108, 97, 99, 101, 32, 116, 104, 101, 32, 104, 97, 110, 100, 108,
+		101, 114, 115, 32, 116, 111, 32, 103, 97, 105, 110, 32, 116, 104, 101, 32,
+		108, 111, 99, 107, 32, 98, 101, 102, 111, 114, 101, 32, 97, 99, 116, 117,
+		97, 108, 108, 121, 10, 9, 47, 47, 32, 100, 111, 105, 110, 103, 32, 97,
+		110, 121, 116, 104, 105, 110, 103, 46, 10, 10, 9, 116, 104, 105, 115, 46,
+		116, 107, 101, 121, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 101, 44, 32, 100, 101, 102, 101, 114, 114, 101, 100, 41,
+		32, 123, 10, 9, 9, 118, 97, 114, 32, 107, 101, 121, 32, 61, 32, 101,
+		46, 107, 101, 121, 67, 111, 100, 101, 59, 10, 9, 9, 105, 102, 40, 33,
+		101, 46, 107, 101, 121, 67, 111, 100, 101, 41, 10, 9, 9, 9, 107, 101,
+		121, 32, 61, 32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 118,
+		97, 114, 32, 114, 117, 110, 101, 32, 61, 32, 83, 116, 114, 105, 110, 103,
+		46, 102, 114, 111, 109, 67, 104, 97, 114, 67, 111, 100, 101, 40, 101, 46,
+		107, 101, 121, 67, 111, 100, 101, 41, 59, 10, 9, 9, 101, 46, 115, 116,
+		111, 112, 80, 114, 111, 112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59,
+		10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10,
+		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		107, 101, 121, 100, 111, 119, 110, 32, 119, 104, 105, 99, 104, 32, 34, 32,
+		43, 32, 101, 46, 119, 104, 105, 99, 104, 32, 43, 32, 34, 32, 107, 101,
+		121, 32, 34, 32, 43, 32, 101, 46, 107, 101, 121, 67, 111, 100, 101, 32,
+		43, 10, 9, 9, 9, 9, 34, 32, 39, 34, 32, 43, 32, 114, 117, 110,
+		101, 32, 43, 32, 34, 39, 34, 32, 43, 10, 9, 9, 9, 9, 34, 32,
+		34, 32, 43, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 43, 32,
+		34, 32, 34, 32, 43, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 41,
+		59, 10, 9, 9, 125, 10, 9, 9, 115, 119, 105, 116, 99, 104, 40, 107,
+		101, 121, 41, 123, 10, 9, 9, 99, 97, 115, 101, 32, 50, 55, 58, 9,
+		47, 42, 32, 101, 115, 99, 97, 112, 101, 32, 42, 47, 10, 9, 9, 9,
+		105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9,
+		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 105, 110,
+		116, 114, 34, 44, 32, 34, 101, 115, 99, 34, 93, 41, 59, 10, 9, 9,
+		9, 116, 104, 105, 115, 46, 100, 117, 109, 112, 40, 41, 59, 10, 9, 9,
+		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 115, 101,
+		108, 32, 61, 32, 91, 34, 43, 116, 104, 105, 115, 46, 112, 48, 43, 34,
+		44, 34, 43, 116, 104, 105, 115, 46, 112, 49, 43, 34, 93, 32, 61, 32,
+		39, 34, 32, 43, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 103, 101,
+		116, 40, 116, 104, 105, 115, 46, 112, 48, 44, 32, 116, 104, 105, 115, 46,
+		112, 49, 41, 32, 43, 32, 34, 39, 34, 41, 59, 10, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 56, 58, 9,
+		9, 47, 42, 32, 98, 97, 99, 107, 115, 112, 97, 99, 101, 32, 42, 47,
+		10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 110, 111, 101, 100,
+		105, 116, 115, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114,
+		110, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 100, 101,
+		102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40,
+		116, 104, 105, 115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105, 115, 46,
+		112, 49, 41, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111,
+		115, 116, 40, 91, 34, 101, 100, 101, 108, 34, 44, 32, 34, 34, 43, 116,
+		104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46,
+		112, 49, 93, 41, 59, 10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 105,
+		102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32, 48, 41, 123, 10,
+		9, 9, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 116, 104, 105,
+		115, 46, 112, 48, 45, 49, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108, 34, 44, 32, 34,
+		34, 43, 112, 48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49,
+		93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98, 114, 101, 97,
+		107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 57, 58, 9, 9, 47, 42,
+		32, 116, 97, 98, 32, 42, 47, 10, 9, 9, 9, 105, 102, 40, 116, 104,
+		105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 41, 32, 123, 10, 9, 9,
+		9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 9, 125, 10, 9,
 		9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123,
 		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
-		10, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 40,
-		41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 110, 32, 61, 32, 77, 97,
-		116, 104, 46, 102, 108, 111, 111, 114, 40, 116, 104, 105, 115, 46, 102, 114,
-		108, 105, 110, 101, 115, 47, 52, 41, 59, 10, 9, 9, 9, 105, 102, 40,
-		110, 32, 60, 32, 49, 41, 32, 123, 10, 9, 9, 9, 9, 110, 32, 61,
-		32, 49, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 116,
-		104, 105, 115, 46, 115, 99, 114, 111, 108, 108, 100, 111, 119, 110, 40, 110,
-		41, 41, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116,
-		105, 99, 107, 40, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99,
-		97, 115, 101, 32, 52, 54, 58, 9, 47, 42, 32, 100, 101, 108, 101, 116,
-		101, 32, 42, 47, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114,
+		10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 99, 111, 109, 112,
+		108, 101, 116, 101, 115, 32, 38, 38, 32, 33, 101, 46, 115, 104, 105, 102,
+		116, 75, 101, 121, 32, 38, 38, 32, 116, 104, 105, 115, 46, 112, 48, 32,
+		61, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 32, 123, 10, 9, 9,
+		9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 99, 111,
+		109, 112, 108, 101, 116, 101, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115,
+		46, 112, 48, 93, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105,
+		115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41,
+		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111, 115, 116, 40,
+		91, 34, 101, 100, 101, 108, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115,
+		46, 112, 48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93,
+		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		80, 111, 115, 116, 40, 91, 34, 101, 105, 110, 115, 34, 44, 32, 34, 92,
+		116, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 93, 41,
+		59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97,
+		115, 101, 32, 51, 50, 58, 9, 47, 42, 32, 115, 112, 97, 99, 101, 32,
+		42, 47, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101,
+		100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111, 115,
+		116, 40, 91, 34, 101, 105, 110, 115, 34, 44, 32, 34, 32, 34, 44, 32,
+		34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 93, 41, 59, 10, 9, 9,
+		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 51,
+		55, 58, 9, 47, 42, 32, 108, 101, 102, 116, 32, 42, 47, 10, 9, 9,
+		9, 105, 102, 40, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115,
+		41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114,
 		114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107,
 		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112,
-		111, 115, 116, 40, 91, 34, 105, 110, 116, 114, 34, 44, 32, 34, 100, 101,
-		108, 34, 93, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 99, 97, 115, 101, 32, 49, 49, 50, 58, 9, 47, 42, 32, 70,
-		49, 32, 42, 47, 10, 9, 9, 99, 97, 115, 101, 32, 49, 49, 51, 58,
-		9, 47, 42, 32, 70, 50, 32, 42, 47, 10, 9, 9, 99, 97, 115, 101,
-		32, 49, 49, 52, 58, 9, 47, 42, 32, 70, 51, 32, 42, 47, 10, 9,
-		9, 99, 97, 115, 101, 32, 49, 49, 53, 58, 9, 47, 42, 32, 70, 52,
-		32, 42, 47, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114,
-		101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 109, 101, 118,
-		32, 61, 32, 123, 10, 9, 9, 9, 9, 102, 97, 107, 101, 120, 58, 32,
-		116, 104, 105, 115, 46, 108, 97, 115, 116, 120, 44, 10, 9, 9, 9, 9,
-		102, 97, 107, 101, 121, 58, 32, 116, 104, 105, 115, 46, 108, 97, 115, 116,
-		121, 44, 10, 9, 9, 9, 9, 119, 104, 105, 99, 104, 58, 32, 107, 101,
-		121, 45, 49, 49, 50, 43, 49, 44, 10, 9, 9, 9, 125, 59, 10, 9,
-		9, 9, 109, 101, 118, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102,
-		97, 117, 108, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
-		41, 123, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110,
-		109, 111, 117, 115, 101, 100, 111, 119, 110, 40, 109, 101, 118, 41, 59, 10,
+		111, 115, 116, 40, 91, 34, 101, 117, 110, 100, 111, 34, 93, 41, 59, 10,
 		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101,
-		32, 49, 50, 51, 58, 9, 47, 42, 32, 70, 49, 50, 32, 42, 47, 10,
-		9, 9, 9, 116, 100, 101, 98, 117, 103, 32, 61, 32, 33, 116, 100, 101,
-		98, 117, 103, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
-		9, 100, 101, 102, 97, 117, 108, 116, 58, 10, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 107,
-		101, 121, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 101, 41, 32, 123, 10, 9, 9, 100, 111, 110, 116, 98, 117, 98,
-		98, 108, 101, 40, 101, 41, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105,
-		115, 46, 105, 115, 108, 111, 99, 107, 101, 100, 41, 32, 123, 10, 9, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 107, 101,
-		121, 100, 111, 119, 110, 40, 101, 41, 59, 10, 9, 9, 125, 10, 9, 9,
-		105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103,
-		41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107,
-		105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 104, 111, 108, 100, 34,
-		93, 41, 59, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 104, 111, 108, 100, 105, 110, 103, 46, 46, 46, 34, 41,
-		59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 118, 97, 114, 32, 115, 101,
-		108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9, 9, 118, 97, 114,
-		32, 120, 101, 32, 61, 32, 106, 81, 117, 101, 114, 121, 46, 69, 118, 101,
-		110, 116, 40, 34, 107, 101, 121, 100, 111, 119, 110, 34, 41, 59, 10, 9,
-		9, 120, 101, 46, 119, 104, 105, 99, 104, 32, 61, 32, 101, 46, 119, 104,
-		105, 99, 104, 59, 10, 9, 9, 120, 101, 46, 107, 101, 121, 67, 111, 100,
-		101, 32, 61, 32, 101, 46, 107, 101, 121, 67, 111, 100, 101, 59, 10, 9,
-		9, 120, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 61, 32, 101, 46,
-		99, 116, 114, 108, 75, 101, 121, 59, 10, 9, 9, 120, 101, 46, 109, 101,
-		116, 97, 75, 101, 121, 32, 61, 32, 101, 46, 109, 101, 116, 97, 75, 101,
-		121, 59, 10, 9, 9, 120, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68,
-		101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 41, 123, 125, 59, 10, 9, 9, 116, 104, 105, 115, 46, 119, 104,
-		101, 110, 108, 111, 99, 107, 101, 100, 46, 112, 117, 115, 104, 40, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 9, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 101, 108, 100, 32,
-		107, 101, 121, 100, 111, 119, 110, 34, 41, 59, 10, 9, 9, 9, 36, 40,
-		115, 101, 108, 102, 46, 99, 41, 46, 116, 114, 105, 103, 103, 101, 114, 40,
-		120, 101, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102,
-		97, 108, 115, 101, 59, 10, 9, 9, 125, 41, 59, 10, 9, 9, 114, 101,
-		116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 107, 101, 121, 100, 111,
-		119, 110, 40, 101, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 125, 59,
-		10, 10, 9, 116, 104, 105, 115, 46, 116, 107, 101, 121, 112, 114, 101, 115,
-		115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 44, 32,
-		100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9, 118, 97,
-		114, 32, 107, 101, 121, 32, 61, 32, 101, 46, 107, 101, 121, 67, 111, 100,
-		101, 59, 10, 9, 9, 105, 102, 40, 33, 101, 46, 107, 101, 121, 67, 111,
-		100, 101, 41, 10, 9, 9, 9, 107, 101, 121, 32, 61, 32, 101, 46, 119,
-		104, 105, 99, 104, 59, 10, 9, 9, 118, 97, 114, 32, 114, 117, 110, 101,
-		32, 61, 32, 83, 116, 114, 105, 110, 103, 46, 102, 114, 111, 109, 67, 104,
-		97, 114, 67, 111, 100, 101, 40, 101, 46, 107, 101, 121, 67, 111, 100, 101,
-		41, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32,
-		123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
-		40, 34, 107, 101, 121, 58, 32, 119, 104, 105, 99, 104, 32, 34, 32, 43,
-		32, 101, 46, 119, 104, 105, 99, 104, 32, 43, 32, 34, 32, 107, 101, 121,
-		32, 34, 32, 43, 32, 101, 46, 107, 101, 121, 67, 111, 100, 101, 32, 43,
-		10, 9, 9, 9, 9, 34, 32, 39, 34, 32, 43, 32, 114, 117, 110, 101,
-		32, 43, 32, 34, 39, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 115,
-		119, 105, 116, 99, 104, 40, 107, 101, 121, 41, 32, 123, 10, 9, 9, 99,
-		97, 115, 101, 32, 57, 58, 10, 9, 9, 9, 114, 117, 110, 101, 32, 61,
-		32, 34, 92, 116, 34, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 99, 97, 115, 101, 32, 49, 51, 58, 10, 9, 9, 9, 114,
-		117, 110, 101, 32, 61, 32, 34, 92, 110, 34, 59, 10, 9, 9, 9, 98,
-		114, 101, 97, 107, 59, 10, 9, 9, 125, 10, 9, 9, 115, 119, 105, 116,
-		99, 104, 40, 114, 117, 110, 101, 41, 32, 123, 10, 9, 9, 99, 97, 115,
-		101, 32, 39, 99, 39, 58, 10, 9, 9, 99, 97, 115, 101, 32, 39, 67,
-		39, 58, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101,
-		100, 41, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		9, 105, 102, 40, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 124, 124,
-		32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 41, 32, 123, 10, 9, 9,
-		9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117,
-		108, 116, 40, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112,
-		111, 115, 116, 40, 91, 34, 101, 99, 111, 112, 121, 34, 44, 32, 34, 34,
-		43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116, 104, 105,
-		115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 114, 101, 116, 117,
-		114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 125, 10, 9,
+		32, 51, 56, 58, 9, 47, 42, 32, 117, 112, 32, 42, 47, 10, 9, 9,
+		9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10,
+		9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10,
+		9, 9, 9, 105, 102, 40, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32,
+		124, 124, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 41, 32, 123, 10,
+		9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34,
+		104, 105, 115, 116, 34, 44, 32, 34, 112, 114, 101, 118, 34, 93, 41, 59,
+		10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 118, 97, 114, 32, 110, 32, 61, 32, 77, 97, 116, 104,
+		46, 102, 108, 111, 111, 114, 40, 116, 104, 105, 115, 46, 102, 114, 108, 105,
+		110, 101, 115, 47, 52, 41, 59, 10, 9, 9, 9, 105, 102, 40, 110, 32,
+		60, 32, 49, 41, 32, 123, 10, 9, 9, 9, 9, 110, 32, 61, 32, 49,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105,
+		115, 46, 115, 99, 114, 111, 108, 108, 117, 112, 40, 110, 41, 41, 123, 10,
+		9, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 40,
+		41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114,
+		97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9,
 		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32,
-		39, 118, 39, 58, 10, 9, 9, 99, 97, 115, 101, 32, 39, 86, 39, 58,
-		10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 32,
-		124, 124, 32, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 41,
-		32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 46, 99, 116, 114, 108, 75,
-		101, 121, 32, 124, 124, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 41,
-		32, 123, 10, 9, 9, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116,
-		68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 9, 9, 105,
-		102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105,
-		115, 46, 112, 49, 41, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115,
-		46, 80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108, 34, 44, 32, 34,
-		34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116, 104,
-		105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 101,
-		112, 97, 115, 116, 101, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46,
-		112, 48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41,
-		59, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108,
-		115, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98, 114, 101, 97,
-		107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 39, 120, 39, 58, 10, 9,
-		9, 99, 97, 115, 101, 32, 39, 88, 39, 58, 10, 9, 9, 9, 105, 102,
-		40, 100, 101, 102, 101, 114, 114, 101, 100, 32, 124, 124, 32, 116, 104, 105,
-		115, 46, 110, 111, 101, 100, 105, 116, 115, 41, 32, 123, 10, 9, 9, 9,
-		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		105, 102, 40, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 124, 124, 32,
-		101, 46, 109, 101, 116, 97, 75, 101, 121, 41, 32, 123, 10, 9, 9, 9,
-		9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108,
-		116, 40, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111,
-		115, 116, 40, 91, 34, 101, 99, 117, 116, 34, 44, 32, 34, 34, 43, 116,
-		104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46,
-		112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
-		100, 101, 102, 101, 114, 114, 101, 100, 32, 124, 124, 32, 101, 46, 109, 101,
-		116, 97, 75, 101, 121, 32, 124, 124, 32, 101, 46, 99, 116, 114, 108, 75,
-		101, 121, 32, 124, 124, 32, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105,
-		116, 115, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59,
-		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112,
-		48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 123, 10, 9,
-		9, 9, 116, 104, 105, 115, 46, 80, 111, 115, 116, 40, 91, 34, 101, 100,
-		101, 108, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 44,
-		32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59, 10, 9,
-		9, 125, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 99, 111, 109,
-		112, 111, 115, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40,
-		33, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 41, 32, 123, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 32, 61, 32,
-		34, 34, 32, 43, 32, 114, 117, 110, 101, 59, 10, 9, 9, 9, 125, 32,
-		101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		108, 97, 116, 105, 110, 32, 43, 61, 32, 114, 117, 110, 101, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 33, 107, 109, 97, 112, 46,
-		105, 115, 108, 97, 116, 105, 110, 40, 116, 104, 105, 115, 46, 108, 97, 116,
-		105, 110, 41, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		99, 111, 109, 112, 111, 115, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115,
-		101, 59, 10, 9, 9, 9, 9, 114, 117, 110, 101, 32, 61, 32, 116, 104,
-		105, 115, 46, 108, 97, 116, 105, 110, 59, 10, 9, 9, 9, 9, 116, 104,
-		105, 115, 46, 108, 97, 116, 105, 110, 32, 61, 32, 34, 34, 59, 10, 9,
-		9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 9, 118,
-		97, 114, 32, 114, 32, 61, 32, 107, 109, 97, 112, 46, 108, 97, 116, 105,
-		110, 40, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 41, 59, 10, 9,
-		9, 9, 9, 105, 102, 32, 40, 33, 114, 41, 32, 123, 10, 9, 9, 9,
-		9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 9, 9, 125, 10,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 99, 111, 109, 112, 111, 115, 105,
-		110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 9,
-		114, 117, 110, 101, 32, 61, 32, 114, 59, 10, 9, 9, 9, 9, 116, 104,
-		105, 115, 46, 108, 97, 116, 105, 110, 32, 61, 32, 34, 34, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 80,
-		111, 115, 116, 40, 91, 34, 101, 105, 110, 115, 34, 44, 32, 114, 117, 110,
-		101, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 93, 41, 59,
-		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99,
-		107, 110, 107, 101, 121, 112, 114, 101, 115, 115, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 100, 111, 110, 116,
-		98, 117, 98, 98, 108, 101, 40, 101, 41, 59, 10, 9, 9, 105, 102, 40,
-		116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100, 41, 32, 123,
-		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46,
-		116, 107, 101, 121, 112, 114, 101, 115, 115, 40, 101, 41, 59, 10, 9, 9,
+		51, 57, 58, 9, 47, 42, 32, 114, 105, 103, 104, 116, 32, 42, 47, 10,
+		9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 110, 111, 101, 100, 105,
+		116, 115, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102,
+		101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 112, 111, 115, 116, 40, 91, 34, 101, 114, 101, 100, 111, 34, 93, 41,
+		59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99, 97,
+		115, 101, 32, 52, 48, 58, 9, 47, 42, 32, 100, 111, 119, 110, 32, 42,
+		47, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100,
+		41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
+		9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 46, 99, 116, 114, 108,
+		75, 101, 121, 32, 124, 124, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121,
+		41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115,
+		116, 40, 91, 34, 104, 105, 115, 116, 34, 44, 32, 34, 110, 101, 120, 116,
+		34, 93, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116,
+		105, 99, 107, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 110, 32,
+		61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 116, 104, 105,
+		115, 46, 102, 114, 108, 105, 110, 101, 115, 47, 52, 41, 59, 10, 9, 9,
+		9, 105, 102, 40, 110, 32, 60, 32, 49, 41, 32, 123, 10, 9, 9, 9,
+		9, 110, 32, 61, 32, 49, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
+		105, 102, 40, 116, 104, 105, 115, 46, 115, 99, 114, 111, 108, 108, 100, 111,
+		119, 110, 40, 110, 41, 41, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 117, 110, 116, 105, 99, 107, 40, 41, 59, 10, 9, 9, 9, 9, 116,
+		104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 9, 99, 97, 115, 101, 32, 52, 54, 58, 9, 47, 42, 32, 100,
+		101, 108, 101, 116, 101, 32, 42, 47, 10, 9, 9, 9, 105, 102, 40, 100,
+		101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104,
+		105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 105, 110, 116, 114, 34, 44,
+		32, 34, 100, 101, 108, 34, 93, 41, 59, 10, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 49, 49, 50, 58, 9,
+		47, 42, 32, 70, 49, 32, 42, 47, 10, 9, 9, 99, 97, 115, 101, 32,
+		49, 49, 51, 58, 9, 47, 42, 32, 70, 50, 32, 42, 47, 10, 9, 9,
+		99, 97, 115, 101, 32, 49, 49, 52, 58, 9, 47, 42, 32, 70, 51, 32,
+		42, 47, 10, 9, 9, 99, 97, 115, 101, 32, 49, 49, 53, 58, 9, 47,
+		42, 32, 70, 52, 32, 42, 47, 10, 9, 9, 9, 105, 102, 40, 100, 101,
+		102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114,
+		32, 109, 101, 118, 32, 61, 32, 123, 10, 9, 9, 9, 9, 102, 97, 107,
+		101, 120, 58, 32, 116, 104, 105, 115, 46, 108, 97, 115, 116, 120, 44, 10,
+		9, 9, 9, 9, 102, 97, 107, 101, 121, 58, 32, 116, 104, 105, 115, 46,
+		108, 97, 115, 116, 121, 44, 10, 9, 9, 9, 9, 119, 104, 105, 99, 104,
+		58, 32, 107, 101, 121, 45, 49, 49, 50, 43, 49, 44, 10, 9, 9, 9,
+		125, 59, 10, 9, 9, 9, 109, 101, 118, 46, 112, 114, 101, 118, 101, 110,
+		116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 41, 123, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		99, 46, 111, 110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 40, 109, 101,
+		118, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		99, 97, 115, 101, 32, 49, 50, 51, 58, 9, 47, 42, 32, 70, 49, 50,
+		32, 42, 47, 10, 9, 9, 9, 116, 100, 101, 98, 117, 103, 32, 61, 32,
+		33, 116, 100, 101, 98, 117, 103, 59, 10, 9, 9, 9, 98, 114, 101, 97,
+		107, 59, 10, 9, 9, 100, 101, 102, 97, 117, 108, 116, 58, 10, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 9,
+		125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101,
+		59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111,
+		99, 107, 110, 107, 101, 121, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 100, 111, 110,
+		116, 98, 117, 98, 98, 108, 101, 40, 101, 41, 59, 10, 9, 9, 105, 102,
+		40, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100, 41, 32,
+		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115,
+		46, 116, 107, 101, 121, 100, 111, 119, 110, 40, 101, 41, 59, 10, 9, 9,
 		125, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 111, 99,
 		107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
 		108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10,
 		9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 104,
 		111, 108, 100, 34, 93, 41, 59, 10, 9, 9, 9, 99, 111, 110, 115, 111,
 		108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108, 100, 105, 110, 103, 46,
-		46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9, 9, 118,
-		97, 114, 32, 120, 101, 32, 61, 32, 106, 81, 117, 101, 114, 121, 46, 69,
-		118, 101, 110, 116, 40, 34, 107, 101, 121, 112, 114, 101, 115, 115, 34, 41,
-		59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104, 32, 61, 32, 101,
-		46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120, 101, 46, 107, 101, 121,
-		67, 111, 100, 101, 32, 61, 32, 101, 46, 107, 101, 121, 67, 111, 100, 101,
-		59, 10, 9, 9, 120, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 61,
-		32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 59, 10, 9, 9, 120, 101,
-		46, 109, 101, 116, 97, 75, 101, 121, 32, 61, 32, 101, 46, 109, 101, 116,
-		97, 75, 101, 121, 59, 10, 9, 9, 120, 101, 46, 112, 114, 101, 118, 101,
-		110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 41, 123, 125, 59, 10, 9, 9, 116, 104, 105, 115,
-		46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 46, 112, 117, 115, 104,
-		40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9,
-		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 101,
-		108, 100, 32, 107, 101, 121, 112, 114, 101, 115, 115, 34, 41, 59, 10, 9,
+		46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 118, 97,
+		114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9,
+		9, 118, 97, 114, 32, 120, 101, 32, 61, 32, 106, 81, 117, 101, 114, 121,
+		46, 69, 118, 101, 110, 116, 40, 34, 107, 101, 121, 100, 111, 119, 110, 34,
+		41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104, 32, 61, 32,
+		101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120, 101, 46, 107, 101,
+		121, 67, 111, 100, 101, 32, 61, 32, 101, 46, 107, 101, 121, 67, 111, 100,
+		101, 59, 10, 9, 9, 120, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32,
+		61, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 59, 10, 9, 9, 120,
+		101, 46, 109, 101, 116, 97, 75, 101, 121, 32, 61, 32, 101, 46, 109, 101,
+		116, 97, 75, 101, 121, 59, 10, 9, 9, 120, 101, 46, 112, 114, 101, 118,
+		101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 41, 123, 125, 59, 10, 9, 9, 116, 104, 105,
+		115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 46, 112, 117, 115,
+		104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
+		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104,
+		101, 108, 100, 32, 107, 101, 121, 100, 111, 119, 110, 34, 41, 59, 10, 9,
 		9, 9, 36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116, 114, 105, 103,
 		103, 101, 114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117,
 		114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 41, 59, 10,
 		9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 107,
-		101, 121, 112, 114, 101, 115, 115, 40, 101, 44, 32, 116, 114, 117, 101, 41,
-		59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 107, 101,
-		121, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
-		44, 32, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9,
-		118, 97, 114, 32, 107, 101, 121, 32, 61, 32, 101, 46, 107, 101, 121, 67,
-		111, 100, 101, 59, 10, 9, 9, 105, 102, 40, 33, 101, 46, 107, 101, 121,
-		67, 111, 100, 101, 41, 10, 9, 9, 9, 107, 101, 121, 32, 61, 32, 101,
-		46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 118, 97, 114, 32, 114, 117,
-		110, 101, 32, 61, 32, 83, 116, 114, 105, 110, 103, 46, 102, 114, 111, 109,
-		67, 104, 97, 114, 67, 111, 100, 101, 40, 101, 46, 107, 101, 121, 67, 111,
-		100, 101, 41, 59, 10, 9, 9, 118, 97, 114, 32, 105, 115, 100, 101, 97,
-		100, 107, 101, 121, 32, 61, 32, 101, 32, 38, 38, 32, 101, 46, 111, 114,
-		105, 103, 105, 110, 97, 108, 69, 118, 101, 110, 116, 32, 38, 38, 10, 9,
-		9, 9, 9, 101, 46, 111, 114, 105, 103, 105, 110, 97, 108, 69, 118, 101,
-		110, 116, 46, 107, 101, 121, 73, 100, 101, 110, 116, 105, 102, 105, 101, 114,
-		32, 61, 61, 32, 34, 85, 110, 105, 100, 101, 110, 116, 105, 102, 105, 101,
-		100, 34, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41,
-		32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 100, 115, 32, 61, 32, 40,
-		105, 115, 100, 101, 97, 100, 107, 101, 121, 32, 63, 32, 34, 32, 100, 101,
-		97, 100, 34, 32, 58, 32, 34, 34, 41, 59, 10, 9, 9, 9, 99, 111,
-		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 107, 101, 121, 117, 112,
-		32, 119, 104, 105, 99, 104, 32, 34, 32, 43, 32, 101, 46, 119, 104, 105,
-		99, 104, 32, 43, 32, 34, 32, 107, 101, 121, 32, 34, 32, 43, 32, 101,
-		46, 107, 101, 121, 67, 111, 100, 101, 32, 43, 10, 9, 9, 9, 9, 34,
-		32, 39, 34, 32, 43, 32, 114, 117, 110, 101, 32, 43, 32, 34, 39, 34,
-		32, 43, 32, 100, 115, 32, 43, 10, 9, 9, 9, 9, 34, 32, 34, 32,
-		43, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 43, 32, 34, 32,
-		34, 32, 43, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 44, 32, 101,
-		41, 59, 10, 9, 9, 125, 10, 9, 9, 115, 119, 105, 116, 99, 104, 40,
-		107, 101, 121, 41, 123, 10, 9, 9, 99, 97, 115, 101, 32, 49, 49, 50,
-		58, 9, 47, 42, 32, 70, 49, 32, 42, 47, 10, 9, 9, 99, 97, 115,
-		101, 32, 49, 49, 51, 58, 9, 47, 42, 32, 70, 50, 32, 42, 47, 10,
-		9, 9, 99, 97, 115, 101, 32, 49, 49, 52, 58, 9, 47, 42, 32, 70,
-		51, 32, 42, 47, 10, 9, 9, 99, 97, 115, 101, 32, 49, 49, 53, 58,
-		9, 47, 42, 32, 70, 52, 32, 42, 47, 10, 9, 9, 9, 105, 102, 40,
-		100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118,
-		97, 114, 32, 109, 101, 118, 32, 61, 32, 123, 10, 9, 9, 9, 9, 102,
-		97, 107, 101, 120, 58, 32, 116, 104, 105, 115, 46, 108, 97, 115, 116, 120,
-		44, 10, 9, 9, 9, 9, 102, 97, 107, 101, 121, 58, 32, 116, 104, 105,
-		115, 46, 108, 97, 115, 116, 121, 44, 10, 9, 9, 9, 9, 119, 104, 105,
-		99, 104, 58, 32, 107, 101, 121, 45, 49, 49, 50, 43, 49, 44, 10, 9,
-		9, 9, 125, 59, 10, 9, 9, 9, 109, 101, 118, 46, 112, 114, 101, 118,
-		101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 41, 123, 125, 10, 9, 9, 9, 116, 104, 105,
-		115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 117, 112, 40, 109, 101,
-		118, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		99, 97, 115, 101, 32, 49, 56, 58, 32, 47, 42, 32, 65, 108, 116, 32,
-		42, 47, 10, 9, 9, 9, 116, 104, 105, 115, 46, 99, 111, 109, 112, 111,
-		115, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 9, 100,
-		101, 102, 97, 117, 108, 116, 58, 10, 9, 9, 9, 114, 101, 116, 117, 114,
-		110, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101,
-		116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125, 59, 10,
-		10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121,
-		117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32,
-		123, 10, 9, 9, 100, 111, 110, 116, 98, 117, 98, 98, 108, 101, 40, 101,
-		41, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108,
-		111, 99, 107, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117,
-		114, 110, 32, 116, 104, 105, 115, 46, 116, 107, 101, 121, 117, 112, 40, 101,
-		41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105,
-		115, 46, 108, 111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116,
-		114, 117, 101, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115,
-		116, 40, 91, 34, 104, 111, 108, 100, 34, 93, 41, 59, 10, 9, 9, 9,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108,
-		100, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9,
-		9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115,
-		59, 10, 9, 9, 118, 97, 114, 32, 120, 101, 32, 61, 32, 106, 81, 117,
-		101, 114, 121, 46, 69, 118, 101, 110, 116, 40, 34, 107, 101, 121, 117, 112,
-		34, 41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104, 32, 61,
-		32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120, 101, 46, 107,
-		101, 121, 67, 111, 100, 101, 32, 61, 32, 101, 46, 107, 101, 121, 67, 111,
-		100, 101, 59, 10, 9, 9, 120, 101, 46, 99, 116, 114, 108, 75, 101, 121,
-		32, 61, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 59, 10, 9, 9,
-		120, 101, 46, 109, 101, 116, 97, 75, 101, 121, 32, 61, 32, 101, 46, 109,
-		101, 116, 97, 75, 101, 121, 59, 10, 9, 9, 120, 101, 46, 112, 114, 101,
-		118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 59, 10, 9, 9, 116, 104,
-		105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 46, 112, 117,
-		115, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
-		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
-		104, 101, 108, 100, 32, 107, 101, 121, 117, 112, 34, 41, 59, 10, 9, 9,
-		9, 36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116, 114, 105, 103, 103,
-		101, 114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114,
-		110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 41, 59, 10, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 107, 101,
-		121, 117, 112, 40, 101, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 125,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 109, 100, 111, 119, 110, 32,
-		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10,
-		9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99, 111, 110, 115,
-		111, 108, 101, 46, 108, 111, 103, 40, 34, 116, 109, 100, 111, 119, 110, 32,
-		34, 44, 32, 116, 104, 105, 115, 46, 105, 100, 44, 32, 101, 41, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116, 115, 116, 97,
-		114, 116, 40, 41, 59, 10, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110,
-		116, 68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 116, 104,
-		105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32, 61, 32, 48,
-		59, 9, 9, 47, 42, 32, 112, 97, 114, 97, 110, 111, 105, 97, 58, 32,
-		115, 101, 101, 32, 116, 109, 50, 51, 52, 32, 42, 47, 10, 9, 9, 116,
-		104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 97, 98, 111,
-		114, 116, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 116, 104,
-		105, 115, 46, 109, 112, 114, 101, 115, 115, 40, 101, 41, 59, 10, 9, 9,
-		116, 104, 105, 115, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9,
-		118, 97, 114, 32, 98, 32, 61, 32, 116, 104, 105, 115, 46, 98, 117, 116,
-		116, 111, 110, 115, 59, 10, 9, 9, 115, 119, 105, 116, 99, 104, 40, 98,
-		41, 123, 10, 9, 9, 99, 97, 115, 101, 32, 49, 58, 10, 9, 9, 9,
-		118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102, 44, 32, 112,
-		97, 115, 116, 59, 10, 9, 9, 9, 91, 108, 110, 44, 32, 108, 110, 111,
-		102, 102, 44, 32, 112, 97, 115, 116, 93, 32, 61, 32, 116, 104, 105, 115,
-		46, 112, 116, 114, 50, 115, 101, 101, 107, 40, 116, 104, 105, 115, 46, 108,
-		97, 115, 116, 120, 44, 32, 116, 104, 105, 115, 46, 108, 97, 115, 116, 121,
-		41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115, 32, 61, 32,
-		116, 104, 105, 115, 46, 115, 101, 101, 107, 112, 111, 115, 40, 108, 110, 44,
-		32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115,
-		46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 44, 32, 112, 111, 115,
-		41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 49, 40, 112, 111,
-		115, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
-		99, 97, 115, 101, 32, 50, 58, 10, 9, 9, 99, 97, 115, 101, 32, 52,
-		58, 10, 9, 9, 99, 97, 115, 101, 32, 56, 58, 10, 9, 9, 9, 118,
-		97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102, 44, 32, 112, 97,
-		115, 116, 59, 10, 9, 9, 9, 91, 108, 110, 44, 32, 108, 110, 111, 102,
-		102, 44, 32, 112, 97, 115, 116, 93, 32, 61, 32, 116, 104, 105, 115, 46,
-		112, 116, 114, 50, 115, 101, 101, 107, 40, 116, 104, 105, 115, 46, 108, 97,
-		115, 116, 120, 44, 32, 116, 104, 105, 115, 46, 108, 97, 115, 116, 121, 41,
-		59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115, 32, 61, 32, 116,
-		104, 105, 115, 46, 115, 101, 101, 107, 112, 111, 115, 40, 108, 110, 44, 32,
-		108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		111, 108, 100, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59,
-		10, 9, 9, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112, 49, 32, 61,
-		32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9, 9, 116, 104, 105,
-		115, 46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 44, 32, 112, 111,
-		115, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 50, 51, 52,
-		40, 112, 111, 115, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 100, 101, 102, 97, 117, 108, 116, 58, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 109, 119, 97, 105, 116, 40, 41, 59, 10, 9, 9, 125,
-		10, 9, 9, 101, 46, 114, 101, 116, 117, 114, 110, 86, 97, 108, 117, 101,
-		32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125, 59, 10, 10, 9,
-		116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 100, 111, 119, 110,
-		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123,
-		10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99,
-		107, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 116, 104, 105, 115, 46, 116, 109, 100, 111, 119, 110, 40, 101, 41, 59,
+		101, 121, 100, 111, 119, 110, 40, 101, 44, 32, 116, 114, 117, 101, 41, 59,
+		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 107, 101, 121,
+		112, 114, 101, 115, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 101, 44, 32, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 107, 101, 121, 32, 61, 32, 101, 46, 107, 101,
+		121, 67, 111, 100, 101, 59, 10, 9, 9, 105, 102, 40, 33, 101, 46, 107,
+		101, 121, 67, 111, 100, 101, 41, 10, 9, 9, 9, 107, 101, 121, 32, 61,
+		32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 118, 97, 114, 32,
+		114, 117, 110, 101, 32, 61, 32, 83, 116, 114, 105, 110, 103, 46, 102, 114,
+		111, 109, 67, 104, 97, 114, 67, 111, 100, 101, 40, 101, 46, 107, 101, 121,
+		67, 111, 100, 101, 41, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98,
+		117, 103, 41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
+		46, 108, 111, 103, 40, 34, 107, 101, 121, 58, 32, 119, 104, 105, 99, 104,
+		32, 34, 32, 43, 32, 101, 46, 119, 104, 105, 99, 104, 32, 43, 32, 34,
+		32, 107, 101, 121, 32, 34, 32, 43, 32, 101, 46, 107, 101, 121, 67, 111,
+		100, 101, 32, 43, 10, 9, 9, 9, 9, 34, 32, 39, 34, 32, 43, 32,
+		114, 117, 110, 101, 32, 43, 32, 34, 39, 34, 41, 59, 10, 9, 9, 125,
+		10, 9, 9, 115, 119, 105, 116, 99, 104, 40, 107, 101, 121, 41, 32, 123,
+		10, 9, 9, 99, 97, 115, 101, 32, 57, 58, 10, 9, 9, 9, 114, 117,
+		110, 101, 32, 61, 32, 34, 92, 116, 34, 59, 10, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 49, 51, 58, 10,
+		9, 9, 9, 114, 117, 110, 101, 32, 61, 32, 34, 92, 110, 34, 59, 10,
+		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 125, 10, 9, 9,
+		115, 119, 105, 116, 99, 104, 40, 114, 117, 110, 101, 41, 32, 123, 10, 9,
+		9, 99, 97, 115, 101, 32, 39, 99, 39, 58, 10, 9, 9, 99, 97, 115,
+		101, 32, 39, 67, 39, 58, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102,
+		101, 114, 114, 101, 100, 41, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 9, 105, 102, 40, 101, 46, 99, 116, 114, 108, 75, 101,
+		121, 32, 124, 124, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 41, 32,
+		123, 10, 9, 9, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68,
+		101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 9, 9, 116, 104,
+		105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 101, 99, 111, 112, 121, 34,
+		44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34, 34,
+		43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9,
+		114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 99,
+		97, 115, 101, 32, 39, 118, 39, 58, 10, 9, 9, 99, 97, 115, 101, 32,
+		39, 86, 39, 58, 10, 9, 9, 9, 105, 102, 40, 100, 101, 102, 101, 114,
+		114, 101, 100, 32, 124, 124, 32, 116, 104, 105, 115, 46, 110, 111, 101, 100,
+		105, 116, 115, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 101, 46, 99,
+		116, 114, 108, 75, 101, 121, 32, 124, 124, 32, 101, 46, 109, 101, 116, 97,
+		75, 101, 121, 41, 32, 123, 10, 9, 9, 9, 9, 101, 46, 112, 114, 101,
+		118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9,
+		9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 33, 61,
+		32, 116, 104, 105, 115, 46, 112, 49, 41, 123, 10, 9, 9, 9, 9, 9,
+		116, 104, 105, 115, 46, 80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108,
+		34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34,
+		34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9,
+		9, 125, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 97, 115, 116,
+		101, 40, 41, 59, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32,
+		102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 99, 97, 115, 101, 32, 39, 120, 39,
+		58, 10, 9, 9, 99, 97, 115, 101, 32, 39, 88, 39, 58, 10, 9, 9,
+		9, 105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 32, 124, 124, 32,
+		116, 104, 105, 115, 46, 110, 111, 101, 100, 105, 116, 115, 41, 32, 123, 10,
+		9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10,
+		9, 9, 9, 105, 102, 40, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32,
+		124, 124, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121, 41, 32, 123, 10,
+		9, 9, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102,
+		97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 80, 111, 115, 116, 40, 91, 34, 101, 99, 117, 116, 34, 44, 32, 34,
+		34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32, 34, 34, 43, 116, 104,
+		105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 125, 10,
+		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 125, 10, 9, 9,
+		105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 32, 124, 124, 32, 101,
+		46, 109, 101, 116, 97, 75, 101, 121, 32, 124, 124, 32, 101, 46, 99, 116,
+		114, 108, 75, 101, 121, 32, 124, 124, 32, 116, 104, 105, 115, 46, 110, 111,
+		101, 100, 105, 116, 115, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117,
+		114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 104, 105,
+		115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41,
+		123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 80, 111, 115, 116, 40, 91,
+		34, 101, 100, 101, 108, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46,
+		112, 48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41,
+		59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
+		99, 111, 109, 112, 111, 115, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9,
+		105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 41, 32,
+		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110,
+		32, 61, 32, 34, 34, 32, 43, 32, 114, 117, 110, 101, 59, 10, 9, 9,
+		9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 9, 116, 104,
+		105, 115, 46, 108, 97, 116, 105, 110, 32, 43, 61, 32, 114, 117, 110, 101,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 33, 107, 109,
+		97, 112, 46, 105, 115, 108, 97, 116, 105, 110, 40, 116, 104, 105, 115, 46,
+		108, 97, 116, 105, 110, 41, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104,
+		105, 115, 46, 99, 111, 109, 112, 111, 115, 105, 110, 103, 32, 61, 32, 102,
+		97, 108, 115, 101, 59, 10, 9, 9, 9, 9, 114, 117, 110, 101, 32, 61,
+		32, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 59, 10, 9, 9, 9,
+		9, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 32, 61, 32, 34, 34,
+		59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9,
+		9, 9, 118, 97, 114, 32, 114, 32, 61, 32, 107, 109, 97, 112, 46, 108,
+		97, 116, 105, 110, 40, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 41,
+		59, 10, 9, 9, 9, 9, 105, 102, 32, 40, 33, 114, 41, 32, 123, 10,
+		9, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 9,
+		9, 125, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 99, 111, 109, 112,
+		111, 115, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
+		9, 9, 9, 114, 117, 110, 101, 32, 61, 32, 114, 59, 10, 9, 9, 9,
+		9, 116, 104, 105, 115, 46, 108, 97, 116, 105, 110, 32, 61, 32, 34, 34,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105,
+		115, 46, 80, 111, 115, 116, 40, 91, 34, 101, 105, 110, 115, 34, 44, 32,
+		114, 117, 110, 101, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48,
+		93, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116,
+		108, 111, 99, 107, 110, 107, 101, 121, 112, 114, 101, 115, 115, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 100,
+		111, 110, 116, 98, 117, 98, 98, 108, 101, 40, 101, 41, 59, 10, 9, 9,
+		105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100,
+		41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104,
+		105, 115, 46, 116, 107, 101, 121, 112, 114, 101, 115, 115, 40, 101, 41, 59,
 		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46,
 		108, 111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104,
 		105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116, 114, 117,
@@ -2200,8 +2376,216 @@ var Files = map[string][]byte{
 		110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 118,
 		97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10,
 		9, 9, 118, 97, 114, 32, 120, 101, 32, 61, 32, 106, 81, 117, 101, 114,
-		121, 46, 69, 118, 101, 110, 116, 40, 34, 109, 111, 117, 115, 101, 100, 111,
-		119, 110, 34, 41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104,
+		121, 46, 69, 118, 101, 110, 116, 40, 34, 107, 101, 121, 112, 114, 101, 115,
+		115, 34, 41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104, 32,
+		61, 32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120, 101, 46,
+		107, 101, 121, 67, 111, 100, 101, 32, 61, 32, 101, 46, 107, 101, 121, 67,
+		111, 100, 101, 59, 10, 9, 9, 120, 101, 46, 99, 116, 114, 108, 75, 101,
+		121, 32, 61, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 59, 10, 9,
+		9, 120, 101, 46, 109, 101, 116, 97, 75, 101, 121, 32, 61, 32, 101, 46,
+		109, 101, 116, 97, 75, 101, 121, 59, 10, 9, 9, 120, 101, 46, 112, 114,
+		101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 59, 10, 9, 9, 116,
+		104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 46, 112,
+		117, 115, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123,
+		10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 104, 101, 108, 100, 32, 107, 101, 121, 112, 114, 101, 115, 115, 34, 41,
+		59, 10, 9, 9, 9, 36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116,
+		114, 105, 103, 103, 101, 114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125,
+		41, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115,
+		46, 116, 107, 101, 121, 112, 114, 101, 115, 115, 40, 101, 44, 32, 116, 114,
+		117, 101, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
+		116, 107, 101, 121, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 101, 44, 32, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123,
+		10, 9, 9, 118, 97, 114, 32, 107, 101, 121, 32, 61, 32, 101, 46, 107,
+		101, 121, 67, 111, 100, 101, 59, 10, 9, 9, 105, 102, 40, 33, 101, 46,
+		107, 101, 121, 67, 111, 100, 101, 41, 10, 9, 9, 9, 107, 101, 121, 32,
+		61, 32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 118, 97, 114,
+		32, 114, 117, 110, 101, 32, 61, 32, 83, 116, 114, 105, 110, 103, 46, 102,
+		114, 111, 109, 67, 104, 97, 114, 67, 111, 100, 101, 40, 101, 46, 107, 101,
+		121, 67, 111, 100, 101, 41, 59, 10, 9, 9, 118, 97, 114, 32, 105, 115,
+		100, 101, 97, 100, 107, 101, 121, 32, 61, 32, 101, 32, 38, 38, 32, 101,
+		46, 111, 114, 105, 103, 105, 110, 97, 108, 69, 118, 101, 110, 116, 32, 38,
+		38, 10, 9, 9, 9, 9, 101, 46, 111, 114, 105, 103, 105, 110, 97, 108,
+		69, 118, 101, 110, 116, 46, 107, 101, 121, 73, 100, 101, 110, 116, 105, 102,
+		105, 101, 114, 32, 61, 61, 32, 34, 85, 110, 105, 100, 101, 110, 116, 105,
+		102, 105, 101, 100, 34, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98,
+		117, 103, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 100, 115, 32,
+		61, 32, 40, 105, 115, 100, 101, 97, 100, 107, 101, 121, 32, 63, 32, 34,
+		32, 100, 101, 97, 100, 34, 32, 58, 32, 34, 34, 41, 59, 10, 9, 9,
+		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 107, 101,
+		121, 117, 112, 32, 119, 104, 105, 99, 104, 32, 34, 32, 43, 32, 101, 46,
+		119, 104, 105, 99, 104, 32, 43, 32, 34, 32, 107, 101, 121, 32, 34, 32,
+		43, 32, 101, 46, 107, 101, 121, 67, 111, 100, 101, 32, 43, 10, 9, 9,
+		9, 9, 34, 32, 39, 34, 32, 43, 32, 114, 117, 110, 101, 32, 43, 32,
+		34, 39, 34, 32, 43, 32, 100, 115, 32, 43, 10, 9, 9, 9, 9, 34,
+		32, 34, 32, 43, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 32, 43,
+		32, 34, 32, 34, 32, 43, 32, 101, 46, 109, 101, 116, 97, 75, 101, 121,
+		44, 32, 101, 41, 59, 10, 9, 9, 125, 10, 9, 9, 115, 119, 105, 116,
+		99, 104, 40, 107, 101, 121, 41, 123, 10, 9, 9, 99, 97, 115, 101, 32,
+		49, 49, 50, 58, 9, 47, 42, 32, 70, 49, 32, 42, 47, 10, 9, 9,
+		99, 97, 115, 101, 32, 49, 49, 51, 58, 9, 47, 42, 32, 70, 50, 32,
+		42, 47, 10, 9, 9, 99, 97, 115, 101, 32, 49, 49, 52, 58, 9, 47,
+		42, 32, 70, 51, 32, 42, 47, 10, 9, 9, 99, 97, 115, 101, 32, 49,
+		49, 53, 58, 9, 47, 42, 32, 70, 52, 32, 42, 47, 10, 9, 9, 9,
+		105, 102, 40, 100, 101, 102, 101, 114, 114, 101, 100, 41, 32, 123, 10, 9,
+		9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 9, 118, 97, 114, 32, 109, 101, 118, 32, 61, 32, 123, 10, 9, 9,
+		9, 9, 102, 97, 107, 101, 120, 58, 32, 116, 104, 105, 115, 46, 108, 97,
+		115, 116, 120, 44, 10, 9, 9, 9, 9, 102, 97, 107, 101, 121, 58, 32,
+		116, 104, 105, 115, 46, 108, 97, 115, 116, 121, 44, 10, 9, 9, 9, 9,
+		119, 104, 105, 99, 104, 58, 32, 107, 101, 121, 45, 49, 49, 50, 43, 49,
+		44, 10, 9, 9, 9, 125, 59, 10, 9, 9, 9, 109, 101, 118, 46, 112,
+		114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 10, 9, 9, 9,
+		116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 117, 112,
+		40, 109, 101, 118, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 9, 99, 97, 115, 101, 32, 49, 56, 58, 32, 47, 42, 32, 65,
+		108, 116, 32, 42, 47, 10, 9, 9, 9, 116, 104, 105, 115, 46, 99, 111,
+		109, 112, 111, 115, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10,
+		9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10,
+		9, 9, 100, 101, 102, 97, 117, 108, 116, 58, 10, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 9, 125, 10, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9,
+		125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110,
+		107, 101, 121, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 41, 32, 123, 10, 9, 9, 100, 111, 110, 116, 98, 117, 98, 98, 108,
+		101, 40, 101, 41, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
+		105, 115, 108, 111, 99, 107, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 107, 101, 121, 117,
+		112, 40, 101, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33,
+		116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 41, 32, 123, 10,
+		9, 9, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32,
+		61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		112, 111, 115, 116, 40, 91, 34, 104, 111, 108, 100, 34, 93, 41, 59, 10,
+		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		104, 111, 108, 100, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9,
+		125, 10, 9, 9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116,
+		104, 105, 115, 59, 10, 9, 9, 118, 97, 114, 32, 120, 101, 32, 61, 32,
+		106, 81, 117, 101, 114, 121, 46, 69, 118, 101, 110, 116, 40, 34, 107, 101,
+		121, 117, 112, 34, 41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99,
+		104, 32, 61, 32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120,
+		101, 46, 107, 101, 121, 67, 111, 100, 101, 32, 61, 32, 101, 46, 107, 101,
+		121, 67, 111, 100, 101, 59, 10, 9, 9, 120, 101, 46, 99, 116, 114, 108,
+		75, 101, 121, 32, 61, 32, 101, 46, 99, 116, 114, 108, 75, 101, 121, 59,
+		10, 9, 9, 120, 101, 46, 109, 101, 116, 97, 75, 101, 121, 32, 61, 32,
+		101, 46, 109, 101, 116, 97, 75, 101, 121, 59, 10, 9, 9, 120, 101, 46,
+		112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100,
+		46, 112, 117, 115, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 34, 104, 101, 108, 100, 32, 107, 101, 121, 117, 112, 34, 41, 59,
+		10, 9, 9, 9, 36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116, 114,
+		105, 103, 103, 101, 114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 41,
+		59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46,
+		116, 107, 101, 121, 117, 112, 40, 101, 44, 32, 116, 114, 117, 101, 41, 59,
+		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 109, 100, 111,
+		119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41,
+		32, 123, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 116, 109, 100, 111,
+		119, 110, 32, 34, 44, 32, 116, 104, 105, 115, 46, 105, 100, 44, 32, 101,
+		41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101, 99, 116,
+		115, 116, 97, 114, 116, 40, 41, 59, 10, 9, 9, 101, 46, 112, 114, 101,
+		118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32,
+		61, 32, 48, 59, 9, 9, 47, 42, 32, 112, 97, 114, 97, 110, 111, 105,
+		97, 58, 32, 115, 101, 101, 32, 116, 109, 50, 51, 52, 32, 42, 47, 10,
+		9, 9, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121,
+		97, 98, 111, 114, 116, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 109, 112, 114, 101, 115, 115, 40, 101, 41, 59,
+		10, 9, 9, 116, 104, 105, 115, 46, 101, 118, 120, 121, 40, 101, 41, 59,
+		10, 9, 9, 118, 97, 114, 32, 98, 32, 61, 32, 116, 104, 105, 115, 46,
+		98, 117, 116, 116, 111, 110, 115, 59, 10, 9, 9, 115, 119, 105, 116, 99,
+		104, 40, 98, 41, 123, 10, 9, 9, 99, 97, 115, 101, 32, 49, 58, 10,
+		9, 9, 9, 118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102,
+		44, 32, 112, 97, 115, 116, 59, 10, 9, 9, 9, 91, 108, 110, 44, 32,
+		108, 110, 111, 102, 102, 44, 32, 112, 97, 115, 116, 93, 32, 61, 32, 116,
+		104, 105, 115, 46, 112, 116, 114, 50, 115, 101, 101, 107, 40, 116, 104, 105,
+		115, 46, 108, 97, 115, 116, 120, 44, 32, 116, 104, 105, 115, 46, 108, 97,
+		115, 116, 121, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115,
+		32, 61, 32, 116, 104, 105, 115, 46, 115, 101, 101, 107, 112, 111, 115, 40,
+		108, 110, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 44, 32,
+		112, 111, 115, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 49,
+		40, 112, 111, 115, 41, 59, 10, 9, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 9, 99, 97, 115, 101, 32, 50, 58, 10, 9, 9, 99, 97, 115,
+		101, 32, 52, 58, 10, 9, 9, 99, 97, 115, 101, 32, 56, 58, 10, 9,
+		9, 9, 118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102, 44,
+		32, 112, 97, 115, 116, 59, 10, 9, 9, 9, 91, 108, 110, 44, 32, 108,
+		110, 111, 102, 102, 44, 32, 112, 97, 115, 116, 93, 32, 61, 32, 116, 104,
+		105, 115, 46, 112, 116, 114, 50, 115, 101, 101, 107, 40, 116, 104, 105, 115,
+		46, 108, 97, 115, 116, 120, 44, 32, 116, 104, 105, 115, 46, 108, 97, 115,
+		116, 121, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 111, 115, 32,
+		61, 32, 116, 104, 105, 115, 46, 115, 101, 101, 107, 112, 111, 115, 40, 108,
+		110, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 9, 116, 104,
+		105, 115, 46, 111, 108, 100, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46,
+		112, 48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 111, 108, 100, 112,
+		49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9, 9,
+		116, 104, 105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 44,
+		32, 112, 111, 115, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109,
+		50, 51, 52, 40, 112, 111, 115, 41, 59, 10, 9, 9, 9, 98, 114, 101,
+		97, 107, 59, 10, 9, 9, 100, 101, 102, 97, 117, 108, 116, 58, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 109, 119, 97, 105, 116, 40, 41, 59, 10,
+		9, 9, 125, 10, 9, 9, 101, 46, 114, 101, 116, 117, 114, 110, 86, 97,
+		108, 117, 101, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125, 59,
+		10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 100,
+		111, 119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
+		41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115,
+		108, 111, 99, 107, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 109, 100, 111, 119, 110, 40,
+		101, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 116, 104,
+		105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9,
+		9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32,
+		116, 114, 117, 101, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111,
+		115, 116, 40, 91, 34, 104, 111, 108, 100, 34, 93, 41, 59, 10, 9, 9,
+		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 111,
+		108, 100, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9, 125, 10,
+		9, 9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105,
+		115, 59, 10, 9, 9, 118, 97, 114, 32, 120, 101, 32, 61, 32, 106, 81,
+		117, 101, 114, 121, 46, 69, 118, 101, 110, 116, 40, 34, 109, 111, 117, 115,
+		101, 100, 111, 119, 110, 34, 41, 59, 10, 9, 9, 120, 101, 46, 119, 104,
+		105, 99, 104, 32, 61, 32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9,
+		9, 120, 101, 46, 112, 97, 103, 101, 88, 32, 61, 32, 101, 46, 112, 97,
+		103, 101, 88, 59, 10, 9, 9, 120, 101, 46, 112, 97, 103, 101, 89, 32,
+		61, 32, 101, 46, 112, 97, 103, 101, 89, 59, 10, 9, 9, 120, 101, 46,
+		112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117, 108, 116, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100,
+		46, 112, 117, 115, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 34, 104, 101, 108, 100, 32, 109, 111, 117, 115, 101, 100, 111, 119,
+		110, 34, 41, 59, 10, 9, 9, 9, 36, 40, 115, 101, 108, 102, 46, 99,
+		41, 46, 116, 114, 105, 103, 103, 101, 114, 40, 120, 101, 41, 59, 10, 9,
+		9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10,
+		9, 9, 125, 41, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102,
+		97, 108, 115, 101, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115,
+		46, 116, 109, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 101, 41, 32, 123, 10, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110,
+		116, 68, 101, 102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 109, 114, 108, 115, 101, 40, 101, 41, 59, 10, 9, 9, 116,
+		104, 105, 115, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61,
+		61, 32, 48, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115,
+		101, 108, 101, 99, 116, 101, 110, 100, 40, 41, 59, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107,
+		110, 109, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		101, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105,
+		115, 108, 111, 99, 107, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 109, 117, 112, 40, 101,
+		41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105,
+		115, 46, 108, 111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9,
+		116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116,
+		114, 117, 101, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115,
+		116, 40, 91, 34, 104, 111, 108, 100, 34, 93, 41, 59, 10, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108,
+		100, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9,
+		9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115,
+		59, 10, 9, 9, 118, 97, 114, 32, 120, 101, 32, 61, 32, 106, 81, 117,
+		101, 114, 121, 46, 69, 118, 101, 110, 116, 40, 34, 109, 111, 117, 115, 101,
+		117, 112, 34, 41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104,
 		32, 61, 32, 101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120, 101,
 		46, 112, 97, 103, 101, 88, 32, 61, 32, 101, 46, 112, 97, 103, 101, 88,
 		59, 10, 9, 9, 120, 101, 46, 112, 97, 103, 101, 89, 32, 61, 32, 101,
@@ -2211,535 +2595,494 @@ var Files = map[string][]byte{
 		105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 46, 112, 117,
 		115, 104, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
 		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
-		104, 101, 108, 100, 32, 109, 111, 117, 115, 101, 100, 111, 119, 110, 34, 41,
-		59, 10, 9, 9, 9, 36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116,
-		114, 105, 103, 103, 101, 114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125,
-		41, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115,
-		101, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 109,
-		117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41,
-		32, 123, 10, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101,
-		102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46,
-		109, 114, 108, 115, 101, 40, 101, 41, 59, 10, 9, 9, 116, 104, 105, 115,
-		46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 105, 102, 40, 116,
-		104, 105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 48,
-		41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 108, 101,
-		99, 116, 101, 110, 100, 40, 41, 59, 10, 9, 9, 125, 10, 9, 125, 59,
-		10, 10, 9, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 117,
-		112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32,
+		104, 101, 108, 100, 32, 109, 111, 117, 115, 101, 117, 112, 34, 41, 59, 10,
+		9, 9, 9, 36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116, 114, 105,
+		103, 103, 101, 114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 41, 59,
+		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59,
+		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107,
+		101, 100, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32,
 		123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108, 111,
-		99, 107, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114,
-		110, 32, 116, 104, 105, 115, 46, 116, 109, 117, 112, 40, 101, 41, 59, 10,
-		9, 9, 125, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 108,
-		111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105,
-		115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91,
-		34, 104, 111, 108, 100, 34, 93, 41, 59, 10, 9, 9, 9, 99, 111, 110,
-		115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108, 100, 105, 110,
-		103, 46, 46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97,
-		114, 32, 115, 101, 108, 102, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9,
-		9, 118, 97, 114, 32, 120, 101, 32, 61, 32, 106, 81, 117, 101, 114, 121,
-		46, 69, 118, 101, 110, 116, 40, 34, 109, 111, 117, 115, 101, 117, 112, 34,
-		41, 59, 10, 9, 9, 120, 101, 46, 119, 104, 105, 99, 104, 32, 61, 32,
-		101, 46, 119, 104, 105, 99, 104, 59, 10, 9, 9, 120, 101, 46, 112, 97,
-		103, 101, 88, 32, 61, 32, 101, 46, 112, 97, 103, 101, 88, 59, 10, 9,
-		9, 120, 101, 46, 112, 97, 103, 101, 89, 32, 61, 32, 101, 46, 112, 97,
-		103, 101, 89, 59, 10, 9, 9, 120, 101, 46, 112, 114, 101, 118, 101, 110,
-		116, 68, 101, 102, 97, 117, 108, 116, 32, 61, 32, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 41, 123, 125, 59, 10, 9, 9, 116, 104, 105, 115, 46,
-		119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 46, 112, 117, 115, 104, 40,
-		102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 9,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 101, 108,
-		100, 32, 109, 111, 117, 115, 101, 117, 112, 34, 41, 59, 10, 9, 9, 9,
-		36, 40, 115, 101, 108, 102, 46, 99, 41, 46, 116, 114, 105, 103, 103, 101,
-		114, 40, 120, 101, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 41, 59, 10, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 101, 100, 32,
-		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
-		9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101,
-		100, 41, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9,
-		105, 102, 40, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 41,
-		32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105,
-		110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100, 32, 61, 32, 116,
-		114, 117, 101, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 107, 101, 121,
-		100, 111, 119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 107, 101, 121,
-		100, 111, 119, 110, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 107, 101,
-		121, 112, 114, 101, 115, 115, 32, 61, 32, 116, 104, 105, 115, 46, 116, 107,
-		101, 121, 112, 114, 101, 115, 115, 59, 10, 9, 9, 9, 116, 104, 105, 115,
-		46, 107, 101, 121, 117, 112, 32, 61, 32, 116, 104, 105, 115, 46, 116, 107,
-		101, 121, 117, 112, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 100,
-		111, 119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 109, 100, 111, 119,
-		110, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 117, 112, 32, 61,
-		32, 116, 104, 105, 115, 46, 116, 109, 117, 112, 59, 10, 9, 9, 9, 102,
-		111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32,
-		60, 32, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101,
-		100, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 32, 123,
-		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111,
-		99, 107, 101, 100, 91, 105, 93, 40, 41, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 9, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107,
-		101, 100, 32, 61, 32, 91, 93, 59, 10, 9, 9, 125, 10, 9, 125, 59,
-		10, 10, 9, 116, 104, 105, 115, 46, 117, 110, 108, 111, 99, 107, 101, 100,
-		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
+		99, 107, 101, 100, 41, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59,
+		10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105,
+		110, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 111,
+		99, 107, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
 		9, 9, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100, 32,
-		61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 116, 104, 105, 115, 46,
-		108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 102, 97, 108, 115, 101, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 109, 117, 115, 116, 117, 110, 108, 111,
-		99, 107, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 116, 104,
-		105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100, 32, 61, 32,
-		91, 93, 59, 10, 9, 9, 116, 104, 105, 115, 46, 107, 101, 121, 100, 111,
-		119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110,
-		107, 101, 121, 100, 111, 119, 110, 59, 10, 9, 9, 116, 104, 105, 115, 46,
-		107, 101, 121, 112, 114, 101, 115, 115, 32, 61, 32, 116, 104, 105, 115, 46,
-		116, 108, 111, 99, 107, 110, 107, 101, 121, 112, 114, 101, 115, 115, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 107, 101, 121, 117, 112, 32, 61, 32, 116,
-		104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121, 117, 112, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 109, 100, 111, 119, 110, 32, 61, 32,
+		61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		107, 101, 121, 100, 111, 119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116,
+		107, 101, 121, 100, 111, 119, 110, 59, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 107, 101, 121, 112, 114, 101, 115, 115, 32, 61, 32, 116, 104, 105, 115,
+		46, 116, 107, 101, 121, 112, 114, 101, 115, 115, 59, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 107, 101, 121, 117, 112, 32, 61, 32, 116, 104, 105, 115,
+		46, 116, 107, 101, 121, 117, 112, 59, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 109, 100, 111, 119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 109,
+		100, 111, 119, 110, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 117,
+		112, 32, 61, 32, 116, 104, 105, 115, 46, 116, 109, 117, 112, 59, 10, 9,
+		9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59,
+		32, 105, 32, 60, 32, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111,
+		99, 107, 101, 100, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43,
+		41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 119, 104, 101,
+		110, 108, 111, 99, 107, 101, 100, 91, 105, 93, 40, 41, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 9, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108,
+		111, 99, 107, 101, 100, 32, 61, 32, 91, 93, 59, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 117, 110, 108, 111, 99,
+		107, 101, 100, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		32, 123, 10, 9, 9, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107,
+		101, 100, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 102, 97, 108,
+		115, 101, 59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 117, 115, 116, 117,
+		110, 108, 111, 99, 107, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 119, 104, 101, 110, 108, 111, 99, 107, 101, 100,
+		32, 61, 32, 91, 93, 59, 10, 9, 9, 116, 104, 105, 115, 46, 107, 101,
+		121, 100, 111, 119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 108, 111,
+		99, 107, 110, 107, 101, 121, 100, 111, 119, 110, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 107, 101, 121, 112, 114, 101, 115, 115, 32, 61, 32, 116, 104,
+		105, 115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121, 112, 114, 101, 115,
+		115, 59, 10, 9, 9, 116, 104, 105, 115, 46, 107, 101, 121, 117, 112, 32,
+		61, 32, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121,
+		117, 112, 59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 100, 111, 119, 110,
+		32, 61, 32, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 100,
+		111, 119, 110, 59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 117, 112, 32,
+		61, 32, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 117, 112,
+		59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34,
+		116, 105, 99, 107, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112,
+		48, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59,
+		10, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 114,
+		108, 115, 101, 100, 34, 93, 41, 59, 10, 9, 9, 47, 47, 32, 99, 111,
+		108, 108, 97, 112, 115, 101, 32, 116, 104, 101, 32, 115, 101, 108, 101, 99,
+		116, 105, 111, 110, 32, 111, 114, 32, 111, 116, 104, 101, 114, 39, 115, 32,
+		109, 105, 103, 104, 116, 32, 105, 110, 115, 101, 114, 116, 32, 105, 110, 32,
+		116, 104, 101, 32, 109, 105, 100, 100, 108, 101, 46, 10, 9, 9, 105, 102,
+		40, 116, 104, 105, 115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105, 115,
+		46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115,
+		101, 116, 115, 101, 108, 40, 116, 104, 105, 115, 46, 112, 48, 44, 32, 116,
+		104, 105, 115, 46, 112, 49, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9,
+		9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 107, 101,
+		121, 100, 111, 119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 108, 111,
+		99, 107, 110, 107, 101, 121, 100, 111, 119, 110, 59, 10, 9, 116, 104, 105,
+		115, 46, 107, 101, 121, 112, 114, 101, 115, 115, 32, 61, 32, 116, 104, 105,
+		115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121, 112, 114, 101, 115, 115,
+		59, 10, 9, 116, 104, 105, 115, 46, 107, 101, 121, 117, 112, 32, 61, 32,
+		116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121, 117, 112,
+		59, 10, 9, 116, 104, 105, 115, 46, 109, 100, 111, 119, 110, 32, 61, 32,
 		116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 100, 111, 119, 110,
-		59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 117, 112, 32, 61, 32, 116,
-		104, 105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 117, 112, 59, 10, 9,
-		9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 116, 105, 99,
-		107, 34, 44, 32, 34, 34, 43, 116, 104, 105, 115, 46, 112, 48, 44, 32,
-		34, 34, 43, 116, 104, 105, 115, 46, 112, 49, 93, 41, 59, 10, 9, 9,
-		116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 114, 108, 115, 101,
-		100, 34, 93, 41, 59, 10, 9, 9, 47, 47, 32, 99, 111, 108, 108, 97,
-		112, 115, 101, 32, 116, 104, 101, 32, 115, 101, 108, 101, 99, 116, 105, 111,
-		110, 32, 111, 114, 32, 111, 116, 104, 101, 114, 39, 115, 32, 109, 105, 103,
-		104, 116, 32, 105, 110, 115, 101, 114, 116, 32, 105, 110, 32, 116, 104, 101,
-		32, 109, 105, 100, 100, 108, 101, 46, 10, 9, 9, 105, 102, 40, 116, 104,
-		105, 115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49,
-		41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115,
-		101, 108, 40, 116, 104, 105, 115, 46, 112, 48, 44, 32, 116, 104, 105, 115,
-		46, 112, 49, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 125, 10,
-		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 107, 101, 121, 100, 111,
-		119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 108, 111, 99, 107, 110,
-		107, 101, 121, 100, 111, 119, 110, 59, 10, 9, 116, 104, 105, 115, 46, 107,
-		101, 121, 112, 114, 101, 115, 115, 32, 61, 32, 116, 104, 105, 115, 46, 116,
-		108, 111, 99, 107, 110, 107, 101, 121, 112, 114, 101, 115, 115, 59, 10, 9,
-		116, 104, 105, 115, 46, 107, 101, 121, 117, 112, 32, 61, 32, 116, 104, 105,
-		115, 46, 116, 108, 111, 99, 107, 110, 107, 101, 121, 117, 112, 59, 10, 9,
-		116, 104, 105, 115, 46, 109, 100, 111, 119, 110, 32, 61, 32, 116, 104, 105,
-		115, 46, 116, 108, 111, 99, 107, 110, 109, 100, 111, 119, 110, 59, 10, 9,
-		116, 104, 105, 115, 46, 109, 117, 112, 32, 61, 32, 116, 104, 105, 115, 46,
-		116, 108, 111, 99, 107, 110, 109, 117, 112, 59, 10, 10, 9, 116, 104, 105,
-		115, 46, 109, 101, 110, 116, 101, 114, 32, 61, 32, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 105, 102, 40, 115, 101,
-		108, 101, 99, 116, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 114, 101,
-		116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		120, 32, 61, 32, 119, 105, 110, 100, 111, 119, 46, 115, 99, 114, 111, 108,
-		108, 88, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32, 119, 105,
-		110, 100, 111, 119, 46, 115, 99, 114, 111, 108, 108, 89, 59, 10, 9, 9,
-		36, 40, 34, 35, 34, 32, 43, 32, 116, 104, 105, 115, 46, 105, 100, 32,
-		41, 46, 102, 111, 99, 117, 115, 40, 41, 59, 10, 9, 9, 119, 105, 110,
-		100, 111, 119, 46, 115, 99, 114, 111, 108, 108, 84, 111, 40, 120, 44, 32,
-		121, 41, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115,
-		108, 111, 99, 107, 101, 100, 32, 124, 124, 32, 116, 104, 105, 115, 46, 108,
-		111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46,
+		59, 10, 9, 116, 104, 105, 115, 46, 109, 117, 112, 32, 61, 32, 116, 104,
+		105, 115, 46, 116, 108, 111, 99, 107, 110, 109, 117, 112, 59, 10, 10, 9,
+		116, 104, 105, 115, 46, 109, 101, 110, 116, 101, 114, 32, 61, 32, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 105, 102,
+		40, 115, 101, 108, 101, 99, 116, 105, 110, 103, 41, 32, 123, 10, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118,
+		97, 114, 32, 120, 32, 61, 32, 119, 105, 110, 100, 111, 119, 46, 115, 99,
+		114, 111, 108, 108, 88, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61,
+		32, 119, 105, 110, 100, 111, 119, 46, 115, 99, 114, 111, 108, 108, 89, 59,
+		10, 9, 9, 36, 40, 34, 35, 34, 32, 43, 32, 116, 104, 105, 115, 46,
+		105, 100, 32, 41, 46, 102, 111, 99, 117, 115, 40, 41, 59, 10, 9, 9,
+		119, 105, 110, 100, 111, 119, 46, 115, 99, 114, 111, 108, 108, 84, 111, 40,
+		120, 44, 32, 121, 41, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115,
+		46, 105, 115, 108, 111, 99, 107, 101, 100, 32, 124, 124, 32, 116, 104, 105,
+		115, 46, 108, 111, 99, 107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9,
+		114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104,
+		105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116, 114, 117,
+		101, 59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91,
+		34, 104, 111, 108, 100, 34, 93, 41, 59, 10, 9, 9, 99, 111, 110, 115,
+		111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108, 100, 105, 110, 103,
+		46, 46, 46, 34, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
+		115, 46, 109, 119, 104, 101, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 101, 46, 115, 116, 111,
+		112, 80, 114, 111, 112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59, 10,
+		9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99,
+		107, 101, 100, 32, 38, 38, 32, 33, 116, 104, 105, 115, 46, 108, 111, 99,
+		107, 105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
 		108, 111, 99, 107, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 104, 111,
-		108, 100, 34, 93, 41, 59, 10, 9, 9, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 34, 104, 111, 108, 100, 105, 110, 103, 46, 46, 46,
-		34, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109,
-		119, 104, 101, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 101, 41, 32, 123, 10, 9, 9, 101, 46, 115, 116, 111, 112, 80, 114,
-		111, 112, 97, 103, 97, 116, 105, 111, 110, 40, 41, 59, 10, 9, 9, 105,
-		102, 40, 33, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100,
-		32, 38, 38, 32, 33, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110,
-		103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 111, 99,
-		107, 105, 110, 103, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 104, 111, 108, 100,
-		34, 93, 41, 59, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 34, 104, 111, 108, 100, 105, 110, 103, 46, 46, 46, 34,
-		41, 59, 10, 9, 9, 125, 10, 9, 9, 116, 114, 121, 32, 123, 10, 9,
-		9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101, 102, 97, 117,
-		108, 116, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 100, 32, 61,
-		32, 101, 46, 119, 104, 101, 101, 108, 68, 101, 108, 116, 97, 32, 42, 32,
-		45, 49, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 32, 61, 32, 49,
-		59, 10, 9, 9, 9, 47, 47, 32, 73, 116, 32, 115, 101, 101, 109, 115,
-		32, 119, 104, 101, 101, 108, 32, 101, 118, 101, 110, 116, 115, 32, 115, 116,
-		105, 108, 108, 32, 103, 101, 116, 32, 115, 101, 110, 116, 10, 9, 9, 9,
-		47, 47, 32, 116, 111, 32, 111, 108, 100, 32, 119, 105, 110, 100, 111, 119,
-		115, 32, 97, 102, 116, 101, 114, 32, 101, 110, 116, 101, 114, 105, 110, 103,
-		32, 97, 32, 100, 105, 102, 102, 101, 114, 101, 110, 116, 10, 9, 9, 9,
-		47, 47, 32, 119, 105, 110, 100, 111, 119, 46, 10, 9, 9, 9, 47, 47,
-		32, 84, 104, 101, 32, 110, 101, 120, 116, 32, 99, 104, 101, 99, 107, 32,
-		105, 115, 32, 97, 32, 119, 111, 114, 107, 97, 114, 111, 117, 110, 100, 32,
-		102, 111, 114, 32, 116, 104, 97, 116, 46, 10, 9, 9, 9, 105, 102, 40,
-		100, 32, 60, 32, 48, 41, 123, 10, 9, 9, 9, 9, 100, 32, 61, 32,
-		45, 100, 59, 10, 9, 9, 9, 9, 100, 32, 61, 32, 49, 32, 43, 32,
-		77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 100, 47, 49, 48, 41,
-		59, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 115, 99,
-		114, 111, 108, 108, 100, 111, 119, 110, 40, 100, 41, 41, 123, 10, 9, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 40, 41,
-		59, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114,
-		97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 9, 125, 10,
-		9, 9, 9, 125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 9, 100, 32,
-		61, 32, 49, 32, 43, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114,
-		40, 100, 47, 49, 48, 41, 59, 10, 9, 9, 9, 9, 105, 102, 40, 116,
-		104, 105, 115, 46, 115, 99, 114, 111, 108, 108, 117, 112, 40, 100, 41, 41,
-		123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105,
-		99, 107, 40, 41, 59, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9,
-		9, 9, 125, 10, 9, 9, 9, 125, 10, 9, 9, 125, 99, 97, 116, 99,
-		104, 40, 101, 120, 41, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108,
-		101, 46, 108, 111, 103, 40, 34, 116, 109, 119, 104, 101, 101, 108, 58, 32,
-		34, 32, 43, 32, 101, 120, 41, 59, 10, 9, 9, 125, 10, 9, 125, 59,
-		10, 10, 9, 116, 104, 105, 115, 46, 109, 109, 111, 118, 101, 32, 61, 32,
-		102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9,
-		105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99, 107, 101, 100,
-		32, 124, 124, 32, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103,
-		41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104,
-		105, 115, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 125, 10,
-		9, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110, 103, 32, 61,
-		32, 116, 114, 117, 101, 59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 111,
-		115, 116, 40, 91, 34, 104, 111, 108, 100, 34, 93, 41, 59, 10, 9, 9,
-		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108,
-		100, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125, 59, 10, 10,
-		9, 47, 47, 32, 104, 111, 108, 100, 105, 110, 103, 32, 100, 111, 119, 110,
-		32, 98, 117, 116, 116, 111, 110, 45, 49, 44, 32, 99, 104, 97, 110, 103,
-		101, 32, 104, 97, 110, 100, 108, 101, 114, 115, 32, 116, 111, 32, 115, 112,
-		101, 97, 107, 10, 9, 47, 47, 32, 97, 32, 100, 105, 102, 102, 101, 114,
-		101, 110, 116, 32, 109, 111, 117, 115, 101, 32, 108, 97, 110, 103, 117, 97,
-		103, 101, 46, 10, 9, 116, 104, 105, 115, 46, 109, 49, 32, 61, 32, 102,
-		117, 110, 99, 116, 105, 111, 110, 40, 112, 111, 115, 41, 32, 123, 10, 9,
-		9, 118, 97, 114, 32, 110, 111, 119, 32, 61, 32, 110, 101, 119, 32, 68,
-		97, 116, 101, 40, 41, 46, 103, 101, 116, 84, 105, 109, 101, 40, 41, 59,
-		10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 99, 108, 105, 99,
-		107, 116, 105, 109, 101, 32, 124, 124, 32, 110, 111, 119, 45, 116, 104, 105,
-		115, 46, 99, 108, 105, 99, 107, 116, 105, 109, 101, 62, 53, 48, 48, 41,
-		32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 98, 108, 99, 108,
-		105, 99, 107, 32, 61, 32, 48, 59, 10, 9, 9, 9, 116, 104, 105, 115,
-		46, 99, 108, 105, 99, 107, 116, 105, 109, 101, 32, 61, 32, 110, 111, 119,
-		59, 10, 9, 9, 125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 116, 104,
-		105, 115, 46, 100, 98, 108, 99, 108, 105, 99, 107, 43, 43, 59, 10, 9,
-		9, 9, 116, 104, 105, 115, 46, 99, 108, 105, 99, 107, 116, 105, 109, 101,
-		32, 61, 32, 110, 111, 119, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97,
-		114, 32, 119, 97, 115, 115, 101, 108, 32, 61, 32, 116, 114, 117, 101, 59,
-		10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 100, 98, 108, 99, 108,
-		105, 99, 107, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 120, 32,
-		61, 32, 116, 104, 105, 115, 46, 103, 101, 116, 119, 111, 114, 100, 40, 112,
-		111, 115, 44, 32, 116, 104, 105, 115, 46, 100, 98, 108, 99, 108, 105, 99,
-		107, 62, 49, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 111,
-		115, 116, 40, 91, 34, 99, 108, 105, 99, 107, 49, 34, 44, 32, 120, 91,
-		48, 93, 44, 32, 34, 34, 43, 120, 91, 49, 93, 44, 32, 34, 34, 43,
-		120, 91, 50, 93, 93, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		115, 101, 116, 115, 101, 108, 40, 120, 91, 49, 93, 44, 32, 120, 91, 50,
-		93, 41, 59, 10, 9, 9, 9, 119, 97, 115, 115, 101, 108, 32, 61, 32,
-		102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 10, 10, 9, 9, 116, 104,
-		105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101,
+		9, 9, 9, 116, 104, 105, 115, 46, 112, 111, 115, 116, 40, 91, 34, 104,
+		111, 108, 100, 34, 93, 41, 59, 10, 9, 9, 9, 99, 111, 110, 115, 111,
+		108, 101, 46, 108, 111, 103, 40, 34, 104, 111, 108, 100, 105, 110, 103, 46,
+		46, 46, 34, 41, 59, 10, 9, 9, 125, 10, 9, 9, 116, 114, 121, 32,
+		123, 10, 9, 9, 9, 101, 46, 112, 114, 101, 118, 101, 110, 116, 68, 101,
+		102, 97, 117, 108, 116, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32,
+		100, 32, 61, 32, 101, 46, 119, 104, 101, 101, 108, 68, 101, 108, 116, 97,
+		32, 42, 32, 45, 49, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 32,
+		61, 32, 49, 59, 10, 9, 9, 9, 47, 47, 32, 73, 116, 32, 115, 101,
+		101, 109, 115, 32, 119, 104, 101, 101, 108, 32, 101, 118, 101, 110, 116, 115,
+		32, 115, 116, 105, 108, 108, 32, 103, 101, 116, 32, 115, 101, 110, 116, 10,
+		9, 9, 9, 47, 47, 32, 116, 111, 32, 111, 108, 100, 32, 119, 105, 110,
+		100, 111, 119, 115, 32, 97, 102, 116, 101, 114, 32, 101, 110, 116, 101, 114,
+		105, 110, 103, 32, 97, 32, 100, 105, 102, 102, 101, 114, 101, 110, 116, 10,
+		9, 9, 9, 47, 47, 32, 119, 105, 110, 100, 111, 119, 46, 10, 9, 9,
+		9, 47, 47, 32, 84, 104, 101, 32, 110, 101, 120, 116, 32, 99, 104, 101,
+		99, 107, 32, 105, 115, 32, 97, 32, 119, 111, 114, 107, 97, 114, 111, 117,
+		110, 100, 32, 102, 111, 114, 32, 116, 104, 97, 116, 46, 10, 9, 9, 9,
+		105, 102, 40, 100, 32, 60, 32, 48, 41, 123, 10, 9, 9, 9, 9, 100,
+		32, 61, 32, 45, 100, 59, 10, 9, 9, 9, 9, 100, 32, 61, 32, 49,
+		32, 43, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 100, 47,
+		49, 48, 41, 59, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115,
+		46, 115, 99, 114, 111, 108, 108, 100, 111, 119, 110, 40, 100, 41, 41, 123,
+		10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99,
+		107, 40, 41, 59, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114,
+		101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9,
+		9, 125, 10, 9, 9, 9, 125, 101, 108, 115, 101, 123, 10, 9, 9, 9,
+		9, 100, 32, 61, 32, 49, 32, 43, 32, 77, 97, 116, 104, 46, 102, 108,
+		111, 111, 114, 40, 100, 47, 49, 48, 41, 59, 10, 9, 9, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 115, 99, 114, 111, 108, 108, 117, 112, 40,
+		100, 41, 41, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 117,
+		110, 116, 105, 99, 107, 40, 41, 59, 10, 9, 9, 9, 9, 9, 116, 104,
+		105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59,
+		10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 10, 9, 9, 125, 99,
+		97, 116, 99, 104, 40, 101, 120, 41, 123, 10, 9, 9, 9, 99, 111, 110,
+		115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 116, 109, 119, 104, 101, 101,
+		108, 58, 32, 34, 32, 43, 32, 101, 120, 41, 59, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 109, 111, 118, 101,
 		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123,
-		10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120, 121, 40, 101, 41,
-		59, 10, 9, 9, 9, 105, 102, 40, 33, 115, 101, 108, 102, 46, 98, 117,
-		116, 116, 111, 110, 115, 41, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114,
-		110, 59, 10, 9, 9, 9, 118, 97, 114, 32, 108, 110, 44, 32, 108, 110,
-		111, 102, 102, 44, 32, 112, 97, 115, 116, 59, 10, 9, 9, 9, 91, 108,
-		110, 44, 32, 108, 110, 111, 102, 102, 44, 32, 112, 97, 115, 116, 93, 32,
-		61, 32, 115, 101, 108, 102, 46, 112, 116, 114, 50, 115, 101, 101, 107, 40,
-		115, 101, 108, 102, 46, 108, 97, 115, 116, 120, 44, 32, 115, 101, 108, 102,
-		46, 108, 97, 115, 116, 121, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32,
-		110, 112, 111, 115, 32, 61, 32, 115, 101, 108, 102, 46, 115, 101, 101, 107,
-		112, 111, 115, 40, 108, 110, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10,
-		9, 9, 9, 105, 102, 40, 110, 112, 111, 115, 32, 62, 32, 112, 111, 115,
-		41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46,
-		112, 48, 32, 33, 61, 32, 112, 111, 115, 32, 124, 124, 32, 115, 101, 108,
-		102, 46, 112, 49, 32, 33, 61, 32, 110, 112, 111, 115, 41, 10, 9, 9,
-		9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 116, 115, 101, 108, 40, 112,
-		111, 115, 44, 32, 110, 112, 111, 115, 44, 32, 116, 114, 117, 101, 41, 59,
-		10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 9,
-		105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32, 110, 112,
-		111, 115, 32, 124, 124, 32, 115, 101, 108, 102, 46, 112, 49, 32, 33, 61,
-		32, 112, 111, 115, 41, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46,
-		115, 101, 116, 115, 101, 108, 40, 110, 112, 111, 115, 44, 32, 112, 111, 115,
-		44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9,
-		9, 125, 59, 10, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110,
-		109, 111, 117, 115, 101, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 115, 101, 108, 102,
-		46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108,
-		102, 46, 109, 112, 114, 101, 115, 115, 40, 101, 41, 59, 10, 9, 9, 9,
-		105, 102, 40, 115, 101, 108, 102, 46, 110, 111, 101, 100, 105, 116, 115, 41,
-		32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46, 98,
-		117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 49, 43, 50, 41, 123, 10,
-		9, 9, 9, 9, 119, 97, 115, 115, 101, 108, 32, 61, 32, 102, 97, 108,
-		115, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 80, 111, 115,
-		116, 40, 91, 34, 101, 99, 117, 116, 34, 44, 32, 34, 34, 43, 115, 101,
-		108, 102, 46, 112, 48, 44, 32, 34, 34, 43, 115, 101, 108, 102, 46, 112,
-		49, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40,
-		115, 101, 108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32,
-		49, 43, 52, 41, 123, 10, 9, 9, 9, 9, 119, 97, 115, 115, 101, 108,
-		32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 9, 105, 102,
-		40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32, 115, 101, 108, 102,
-		46, 112, 49, 41, 123, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46,
-		80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108, 34, 44, 32, 34, 34,
-		43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34, 43, 115, 101, 108,
-		102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9,
-		9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116, 40, 91, 34, 101, 112,
-		97, 115, 116, 101, 34, 44, 32, 34, 34, 43, 115, 101, 108, 102, 46, 112,
-		48, 44, 32, 34, 34, 43, 115, 101, 108, 102, 46, 112, 49, 93, 41, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 115, 101, 108, 102,
-		46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 49, 43, 56, 41,
-		123, 10, 9, 9, 9, 9, 119, 97, 115, 115, 101, 108, 32, 61, 32, 102,
-		97, 108, 115, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112,
-		111, 115, 116, 40, 91, 34, 101, 99, 111, 112, 121, 34, 44, 32, 34, 34,
-		43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34, 43, 115, 101, 108,
-		102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125,
-		59, 10, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111,
-		117, 115, 101, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 101, 41, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120,
-		121, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 109, 114,
-		108, 115, 101, 40, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40, 115, 101,
-		108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 48, 41,
-		123, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111, 110, 109,
-		111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 115, 101, 108, 102, 46,
-		99, 46, 109, 109, 111, 118, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108,
-		102, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 32,
-		61, 32, 115, 101, 108, 102, 46, 99, 46, 109, 100, 111, 119, 110, 59, 10,
-		9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111, 110, 109, 111, 117,
-		115, 101, 117, 112, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46, 109, 117,
-		112, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116,
-		40, 91, 34, 102, 111, 99, 117, 115, 34, 93, 41, 59, 10, 9, 9, 9,
-		9, 115, 101, 108, 102, 46, 115, 101, 108, 101, 99, 116, 101, 110, 100, 40,
-		41, 59, 10, 9, 9, 9, 9, 105, 102, 40, 119, 97, 115, 115, 101, 108,
-		32, 38, 38, 32, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32, 115,
-		101, 108, 102, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 9, 118,
-		97, 114, 32, 120, 32, 61, 32, 115, 101, 108, 102, 46, 103, 101, 116, 40,
-		115, 101, 108, 102, 46, 112, 48, 44, 32, 115, 101, 108, 102, 46, 112, 49,
-		41, 59, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115,
-		116, 40, 91, 34, 99, 108, 105, 99, 107, 49, 34, 44, 32, 120, 44, 32,
-		34, 34, 43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34, 43, 115,
-		101, 108, 102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 125, 10,
-		9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 116, 102, 111, 99, 117,
-		115, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 59, 10, 9,
-		125, 59, 10, 10, 9, 47, 47, 32, 104, 111, 108, 100, 105, 110, 103, 32,
-		100, 111, 119, 110, 32, 98, 117, 116, 116, 111, 110, 45, 91, 50, 51, 52,
-		93, 44, 32, 99, 104, 97, 110, 103, 101, 32, 104, 97, 110, 100, 108, 101,
-		114, 115, 32, 116, 111, 32, 115, 112, 101, 97, 107, 10, 9, 47, 47, 32,
-		97, 32, 100, 105, 102, 102, 101, 114, 101, 110, 116, 32, 109, 111, 117, 115,
-		101, 32, 108, 97, 110, 103, 117, 97, 103, 101, 46, 10, 9, 116, 104, 105,
-		115, 46, 109, 50, 51, 52, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 112, 111, 115, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 98,
-		32, 61, 32, 116, 104, 105, 115, 46, 98, 117, 116, 116, 111, 110, 115, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114,
-		121, 32, 61, 32, 98, 59, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46,
-		111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 115, 101,
-		108, 102, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 9, 105,
-		102, 40, 33, 115, 101, 108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 41,
-		10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 9,
-		118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102, 44, 32, 112,
-		97, 115, 116, 59, 10, 9, 9, 9, 91, 108, 110, 44, 32, 108, 110, 111,
-		102, 102, 44, 32, 112, 97, 115, 116, 93, 32, 61, 32, 115, 101, 108, 102,
-		46, 112, 116, 114, 50, 115, 101, 101, 107, 40, 115, 101, 108, 102, 46, 108,
-		97, 115, 116, 120, 44, 32, 115, 101, 108, 102, 46, 108, 97, 115, 116, 121,
-		41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 110, 112, 111, 115, 32, 61,
-		32, 115, 101, 108, 102, 46, 115, 101, 101, 107, 112, 111, 115, 40, 108, 110,
-		44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 9, 105, 102, 40,
-		110, 112, 111, 115, 32, 62, 32, 112, 111, 115, 41, 123, 10, 9, 9, 9,
-		9, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32, 112,
-		111, 115, 32, 124, 124, 32, 115, 101, 108, 102, 46, 112, 49, 32, 33, 61,
-		32, 110, 112, 111, 115, 41, 32, 123, 10, 9, 9, 9, 9, 9, 115, 101,
-		108, 102, 46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 44, 32, 110,
-		112, 111, 115, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 9,
-		125, 10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9,
-		9, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32, 110,
-		112, 111, 115, 32, 124, 124, 32, 115, 101, 108, 102, 46, 112, 49, 32, 33,
-		61, 32, 112, 111, 115, 41, 32, 123, 10, 9, 9, 9, 9, 9, 115, 101,
+		10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 105, 115, 108, 111, 99,
+		107, 101, 100, 32, 124, 124, 32, 116, 104, 105, 115, 46, 108, 111, 99, 107,
+		105, 110, 103, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		32, 116, 104, 105, 115, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9,
+		9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 108, 111, 99, 107, 105, 110,
+		103, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9, 116, 104, 105, 115,
+		46, 112, 111, 115, 116, 40, 91, 34, 104, 111, 108, 100, 34, 93, 41, 59,
+		10, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
+		104, 111, 108, 100, 105, 110, 103, 46, 46, 46, 34, 41, 59, 10, 9, 9,
+		114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 125,
+		59, 10, 10, 9, 47, 47, 32, 104, 111, 108, 100, 105, 110, 103, 32, 100,
+		111, 119, 110, 32, 98, 117, 116, 116, 111, 110, 45, 49, 44, 32, 99, 104,
+		97, 110, 103, 101, 32, 104, 97, 110, 100, 108, 101, 114, 115, 32, 116, 111,
+		32, 115, 112, 101, 97, 107, 10, 9, 47, 47, 32, 97, 32, 100, 105, 102,
+		102, 101, 114, 101, 110, 116, 32, 109, 111, 117, 115, 101, 32, 108, 97, 110,
+		103, 117, 97, 103, 101, 46, 10, 9, 116, 104, 105, 115, 46, 109, 49, 32,
+		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 111, 115, 41, 32,
+		123, 10, 9, 9, 118, 97, 114, 32, 110, 111, 119, 32, 61, 32, 110, 101,
+		119, 32, 68, 97, 116, 101, 40, 41, 46, 103, 101, 116, 84, 105, 109, 101,
+		40, 41, 59, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 99,
+		108, 105, 99, 107, 116, 105, 109, 101, 32, 124, 124, 32, 110, 111, 119, 45,
+		116, 104, 105, 115, 46, 99, 108, 105, 99, 107, 116, 105, 109, 101, 62, 53,
+		48, 48, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 98,
+		108, 99, 108, 105, 99, 107, 32, 61, 32, 48, 59, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 99, 108, 105, 99, 107, 116, 105, 109, 101, 32, 61, 32,
+		110, 111, 119, 59, 10, 9, 9, 125, 101, 108, 115, 101, 123, 10, 9, 9,
+		9, 116, 104, 105, 115, 46, 100, 98, 108, 99, 108, 105, 99, 107, 43, 43,
+		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 99, 108, 105, 99, 107, 116,
+		105, 109, 101, 32, 61, 32, 110, 111, 119, 59, 10, 9, 9, 125, 10, 9,
+		9, 118, 97, 114, 32, 119, 97, 115, 115, 101, 108, 32, 61, 32, 116, 114,
+		117, 101, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 100, 98,
+		108, 99, 108, 105, 99, 107, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114,
+		32, 120, 32, 61, 32, 116, 104, 105, 115, 46, 103, 101, 116, 119, 111, 114,
+		100, 40, 112, 111, 115, 44, 32, 116, 104, 105, 115, 46, 100, 98, 108, 99,
+		108, 105, 99, 107, 62, 49, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 112, 111, 115, 116, 40, 91, 34, 99, 108, 105, 99, 107, 49, 34, 44,
+		32, 120, 91, 48, 93, 44, 32, 34, 34, 43, 120, 91, 49, 93, 44, 32,
+		34, 34, 43, 120, 91, 50, 93, 93, 41, 59, 10, 9, 9, 9, 116, 104,
+		105, 115, 46, 115, 101, 116, 115, 101, 108, 40, 120, 91, 49, 93, 44, 32,
+		120, 91, 50, 93, 41, 59, 10, 9, 9, 9, 119, 97, 115, 115, 101, 108,
+		32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 10, 10, 9,
+		9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 109,
+		111, 118, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
+		41, 32, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120, 121,
+		40, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40, 33, 115, 101, 108, 102,
+		46, 98, 117, 116, 116, 111, 110, 115, 41, 10, 9, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 59, 10, 9, 9, 9, 118, 97, 114, 32, 108, 110, 44,
+		32, 108, 110, 111, 102, 102, 44, 32, 112, 97, 115, 116, 59, 10, 9, 9,
+		9, 91, 108, 110, 44, 32, 108, 110, 111, 102, 102, 44, 32, 112, 97, 115,
+		116, 93, 32, 61, 32, 115, 101, 108, 102, 46, 112, 116, 114, 50, 115, 101,
+		101, 107, 40, 115, 101, 108, 102, 46, 108, 97, 115, 116, 120, 44, 32, 115,
+		101, 108, 102, 46, 108, 97, 115, 116, 121, 41, 59, 10, 9, 9, 9, 118,
+		97, 114, 32, 110, 112, 111, 115, 32, 61, 32, 115, 101, 108, 102, 46, 115,
+		101, 101, 107, 112, 111, 115, 40, 108, 110, 44, 32, 108, 110, 111, 102, 102,
+		41, 59, 10, 9, 9, 9, 105, 102, 40, 110, 112, 111, 115, 32, 62, 32,
+		112, 111, 115, 41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 115, 101,
+		108, 102, 46, 112, 48, 32, 33, 61, 32, 112, 111, 115, 32, 124, 124, 32,
+		115, 101, 108, 102, 46, 112, 49, 32, 33, 61, 32, 110, 112, 111, 115, 41,
+		10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 116, 115, 101,
+		108, 40, 112, 111, 115, 44, 32, 110, 112, 111, 115, 44, 32, 116, 114, 117,
+		101, 41, 59, 10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 123, 10, 9,
+		9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61,
+		32, 110, 112, 111, 115, 32, 124, 124, 32, 115, 101, 108, 102, 46, 112, 49,
+		32, 33, 61, 32, 112, 111, 115, 41, 10, 9, 9, 9, 9, 9, 115, 101,
 		108, 102, 46, 115, 101, 116, 115, 101, 108, 40, 110, 112, 111, 115, 44, 32,
-		112, 111, 115, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 9,
-		125, 10, 9, 9, 9, 125, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 59, 10, 10, 9, 9,
-		116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 100, 111,
-		119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41,
-		32, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120, 121, 40,
-		101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 109, 112, 114, 101,
-		115, 115, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 115,
-		101, 99, 111, 110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 32, 61, 32,
-		40, 115, 101, 108, 102, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 97,
-		98, 111, 114, 116, 32, 124, 124, 32, 115, 101, 108, 102, 46, 98, 117, 116,
-		116, 111, 110, 115, 32, 33, 61, 32, 115, 101, 108, 102, 46, 115, 101, 99,
-		111, 110, 100, 97, 114, 121, 41, 59, 10, 9, 9, 125, 59, 10, 10, 9,
-		9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 117,
-		112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32,
-		123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120, 121, 40, 101,
-		41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 109, 114, 108, 115, 101,
-		40, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46,
-		98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 48, 41, 123, 10, 9,
-		9, 9, 9, 118, 97, 114, 32, 115, 112, 48, 32, 61, 32, 115, 101, 108,
-		102, 46, 112, 48, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 115, 112,
-		49, 32, 61, 32, 115, 101, 108, 102, 46, 112, 49, 59, 10, 9, 9, 9,
-		9, 118, 97, 114, 32, 108, 110, 32, 61, 32, 115, 101, 108, 102, 46, 108,
-		110, 101, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 116, 115, 105, 122,
-		101, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 105, 102, 40, 108, 110,
-		41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 115, 105, 122, 101, 32, 61,
-		32, 108, 110, 46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 116, 120, 116,
-		46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 125, 10, 9,
-		9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 99, 111, 110, 100, 97, 114,
-		121, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46,
-		115, 101, 116, 115, 101, 108, 40, 115, 101, 108, 102, 46, 111, 108, 100, 112,
-		48, 44, 32, 115, 101, 108, 102, 46, 111, 108, 100, 112, 49, 41, 59, 10,
-		9, 9, 9, 9, 105, 102, 40, 33, 115, 101, 108, 102, 46, 115, 101, 99,
-		111, 110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 41, 10, 9, 9, 9,
-		9, 105, 102, 40, 115, 112, 48, 32, 33, 61, 32, 115, 112, 49, 41, 32,
-		123, 10, 9, 9, 9, 9, 9, 118, 97, 114, 32, 116, 120, 116, 32, 61,
-		32, 115, 101, 108, 102, 46, 103, 101, 116, 40, 115, 112, 48, 44, 32, 115,
-		112, 49, 41, 59, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112,
-		111, 115, 116, 40, 91, 34, 99, 108, 105, 99, 107, 34, 43, 98, 44, 32,
-		116, 120, 116, 44, 32, 34, 34, 43, 115, 112, 48, 44, 32, 34, 34, 43,
-		115, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 125, 32, 101, 108, 115,
-		101, 32, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32,
-		115, 101, 108, 102, 46, 112, 49, 32, 38, 38, 10, 9, 9, 9, 9, 9,
-		9, 32, 115, 112, 48, 32, 62, 61, 32, 115, 101, 108, 102, 46, 112, 48,
-		32, 38, 38, 32, 115, 112, 48, 32, 60, 61, 32, 115, 101, 108, 102, 46,
-		112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 9, 118, 97, 114, 32, 116,
-		120, 116, 32, 61, 32, 115, 101, 108, 102, 46, 103, 101, 116, 40, 115, 101,
-		108, 102, 46, 112, 48, 44, 32, 115, 101, 108, 102, 46, 112, 49, 41, 59,
-		10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116, 40,
-		91, 34, 99, 108, 105, 99, 107, 34, 43, 98, 44, 32, 116, 120, 116, 44,
+		112, 111, 115, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101,
+		59, 10, 9, 9, 125, 59, 10, 10, 9, 9, 116, 104, 105, 115, 46, 99,
+		46, 111, 110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 32, 61, 32, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 115,
+		101, 108, 102, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 9,
+		115, 101, 108, 102, 46, 109, 112, 114, 101, 115, 115, 40, 101, 41, 59, 10,
+		9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46, 110, 111, 101, 100, 105,
+		116, 115, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 115, 101, 108,
+		102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 49, 43, 50,
+		41, 123, 10, 9, 9, 9, 9, 119, 97, 115, 115, 101, 108, 32, 61, 32,
+		102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46,
+		80, 111, 115, 116, 40, 91, 34, 101, 99, 117, 116, 34, 44, 32, 34, 34,
+		43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34, 43, 115, 101, 108,
+		102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
+		105, 102, 40, 115, 101, 108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 32,
+		61, 61, 32, 49, 43, 52, 41, 123, 10, 9, 9, 9, 9, 119, 97, 115,
+		115, 101, 108, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9,
+		9, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61, 32, 115,
+		101, 108, 102, 46, 112, 49, 41, 123, 10, 9, 9, 9, 9, 9, 115, 101,
+		108, 102, 46, 80, 111, 115, 116, 40, 91, 34, 101, 100, 101, 108, 34, 44,
 		32, 34, 34, 43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34, 43,
 		115, 101, 108, 102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 125,
-		32, 101, 108, 115, 101, 32, 105, 102, 40, 98, 32, 33, 61, 32, 49, 32,
-		38, 38, 32, 115, 112, 48, 32, 61, 61, 32, 115, 112, 49, 32, 38, 38,
-		32, 116, 115, 105, 122, 101, 32, 38, 38, 10, 9, 9, 9, 9, 9, 115,
-		112, 48, 32, 62, 61, 32, 116, 115, 105, 122, 101, 32, 38, 38, 32, 115,
-		112, 48, 62, 48, 41, 32, 123, 10, 9, 9, 9, 9, 9, 47, 47, 32,
-		97, 32, 99, 108, 105, 99, 107, 32, 97, 116, 32, 97, 32, 102, 105, 110,
-		97, 108, 32, 101, 109, 112, 116, 121, 32, 108, 105, 110, 101, 32, 115, 101,
-		108, 101, 99, 116, 115, 32, 116, 104, 101, 32, 112, 114, 101, 118, 105, 111,
-		117, 115, 10, 9, 9, 9, 9, 9, 47, 47, 32, 108, 105, 110, 101, 32,
-		40, 119, 104, 105, 99, 104, 32, 105, 115, 32, 116, 104, 101, 32, 108, 97,
-		115, 116, 32, 111, 110, 101, 32, 115, 104, 111, 119, 110, 41, 46, 10, 9,
-		9, 9, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32, 115, 101, 108, 102,
-		46, 103, 101, 116, 119, 111, 114, 100, 40, 116, 115, 105, 122, 101, 45, 49,
-		44, 32, 98, 32, 33, 61, 32, 56, 32, 124, 124, 32, 115, 101, 108, 102,
-		46, 100, 98, 108, 99, 108, 105, 99, 107, 62, 49, 41, 59, 10, 9, 9,
-		9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116, 40, 91, 34, 99,
-		108, 105, 99, 107, 34, 43, 98, 44, 32, 120, 91, 48, 93, 44, 32, 34,
-		34, 43, 120, 91, 49, 93, 44, 32, 34, 34, 43, 120, 91, 50, 93, 93,
-		41, 59, 10, 9, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10,
-		9, 9, 9, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32, 115, 101, 108,
-		102, 46, 103, 101, 116, 119, 111, 114, 100, 40, 115, 112, 48, 44, 32, 98,
-		32, 33, 61, 32, 56, 32, 124, 124, 32, 115, 101, 108, 102, 46, 100, 98,
-		108, 99, 108, 105, 99, 107, 62, 49, 41, 59, 10, 9, 9, 9, 9, 9,
-		115, 101, 108, 102, 46, 112, 111, 115, 116, 40, 91, 34, 99, 108, 105, 99,
-		107, 34, 43, 98, 44, 32, 120, 91, 48, 93, 44, 32, 34, 34, 43, 120,
-		91, 49, 93, 44, 32, 34, 34, 43, 120, 91, 50, 93, 93, 41, 59, 10,
-		9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99,
-		46, 111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 115,
-		101, 108, 102, 46, 99, 46, 109, 109, 111, 118, 101, 59, 10, 9, 9, 9,
-		9, 115, 101, 108, 102, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 100,
-		111, 119, 110, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46, 109, 100, 111,
-		119, 110, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111,
-		110, 109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 115, 101, 108, 102, 46,
-		99, 46, 109, 117, 112, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46,
-		112, 48, 32, 61, 32, 115, 101, 108, 102, 46, 111, 108, 100, 112, 48, 59,
-		10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 49, 32, 61, 32, 115,
-		101, 108, 102, 46, 111, 108, 100, 112, 49, 59, 10, 9, 9, 9, 9, 115,
-		101, 108, 102, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32, 61, 32,
-		48, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 99, 111,
-		110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 32, 61, 32, 102, 97, 108,
-		115, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 108,
-		101, 99, 116, 101, 110, 100, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9,
-		9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 119,
-		97, 105, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
-		41, 32, 123, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109,
-		111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117,
-		114, 110, 32, 115, 101, 108, 102, 46, 101, 118, 120, 121, 40, 101, 41, 59,
-		10, 9, 9, 125, 59, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111,
-		110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 9, 115, 101,
-		108, 102, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 9, 115,
-		101, 108, 102, 46, 109, 112, 114, 101, 115, 115, 40, 101, 41, 59, 10, 9,
-		9, 125, 59, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109,
-		111, 117, 115, 101, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 101, 41, 32, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101,
+		10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 97, 115, 116, 101, 40,
+		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 115, 101,
+		108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 49, 43,
+		56, 41, 123, 10, 9, 9, 9, 9, 119, 97, 115, 115, 101, 108, 32, 61,
+		32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102,
+		46, 112, 111, 115, 116, 40, 91, 34, 101, 99, 111, 112, 121, 34, 44, 32,
+		34, 34, 43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34, 43, 115,
+		101, 108, 102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 125, 59, 10, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110,
+		109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 101, 41, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101,
 		118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46,
 		109, 114, 108, 115, 101, 40, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40,
 		115, 101, 108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32,
-		48, 41, 32, 123, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46,
-		111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 115, 101,
-		108, 102, 46, 99, 46, 109, 109, 111, 118, 101, 59, 10, 9, 9, 9, 9,
-		115, 101, 108, 102, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 100, 111,
-		119, 110, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46, 109, 100, 111, 119,
-		110, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111, 110,
-		109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 115, 101, 108, 102, 46, 99,
-		46, 109, 117, 112, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 59, 10,
-		9, 125, 59, 10, 10, 9, 118, 97, 114, 32, 115, 101, 108, 102, 32, 61,
-		32, 116, 104, 105, 115, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 111,
-		110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 115, 101, 108, 102, 46, 109, 100, 111, 119, 110, 40, 101,
-		41, 59, 10, 9, 125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 111,
+		48, 41, 123, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111,
+		110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 115, 101, 108,
+		102, 46, 99, 46, 109, 109, 111, 118, 101, 59, 10, 9, 9, 9, 9, 115,
+		101, 108, 102, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 100, 111, 119,
+		110, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46, 109, 100, 111, 119, 110,
+		59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111, 110, 109,
+		111, 117, 115, 101, 117, 112, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46,
+		109, 117, 112, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 111,
+		115, 116, 40, 91, 34, 102, 111, 99, 117, 115, 34, 93, 41, 59, 10, 9,
+		9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 108, 101, 99, 116, 101, 110,
+		100, 40, 41, 59, 10, 9, 9, 9, 9, 105, 102, 40, 119, 97, 115, 115,
+		101, 108, 32, 38, 38, 32, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61,
+		32, 115, 101, 108, 102, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 9,
+		9, 118, 97, 114, 32, 120, 32, 61, 32, 115, 101, 108, 102, 46, 103, 101,
+		116, 40, 115, 101, 108, 102, 46, 112, 48, 44, 32, 115, 101, 108, 102, 46,
+		112, 49, 41, 59, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112,
+		111, 115, 116, 40, 91, 34, 99, 108, 105, 99, 107, 49, 34, 44, 32, 120,
+		44, 32, 34, 34, 43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34, 34,
+		43, 115, 101, 108, 102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9,
+		125, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 116, 102, 111,
+		99, 117, 115, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 59,
+		10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 104, 111, 108, 100, 105, 110,
+		103, 32, 100, 111, 119, 110, 32, 98, 117, 116, 116, 111, 110, 45, 91, 50,
+		51, 52, 93, 44, 32, 99, 104, 97, 110, 103, 101, 32, 104, 97, 110, 100,
+		108, 101, 114, 115, 32, 116, 111, 32, 115, 112, 101, 97, 107, 10, 9, 47,
+		47, 32, 97, 32, 100, 105, 102, 102, 101, 114, 101, 110, 116, 32, 109, 111,
+		117, 115, 101, 32, 108, 97, 110, 103, 117, 97, 103, 101, 46, 10, 9, 116,
+		104, 105, 115, 46, 109, 50, 51, 52, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 112, 111, 115, 41, 32, 123, 10, 9, 9, 118, 97, 114,
+		32, 98, 32, 61, 32, 116, 104, 105, 115, 46, 98, 117, 116, 116, 111, 110,
+		115, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100,
+		97, 114, 121, 32, 61, 32, 98, 59, 10, 9, 9, 116, 104, 105, 115, 46,
+		99, 46, 111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 9,
+		115, 101, 108, 102, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9,
+		9, 105, 102, 40, 33, 115, 101, 108, 102, 46, 98, 117, 116, 116, 111, 110,
+		115, 41, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
+		9, 9, 118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102, 44,
+		32, 112, 97, 115, 116, 59, 10, 9, 9, 9, 91, 108, 110, 44, 32, 108,
+		110, 111, 102, 102, 44, 32, 112, 97, 115, 116, 93, 32, 61, 32, 115, 101,
+		108, 102, 46, 112, 116, 114, 50, 115, 101, 101, 107, 40, 115, 101, 108, 102,
+		46, 108, 97, 115, 116, 120, 44, 32, 115, 101, 108, 102, 46, 108, 97, 115,
+		116, 121, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 110, 112, 111, 115,
+		32, 61, 32, 115, 101, 108, 102, 46, 115, 101, 101, 107, 112, 111, 115, 40,
+		108, 110, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 9, 105,
+		102, 40, 110, 112, 111, 115, 32, 62, 32, 112, 111, 115, 41, 123, 10, 9,
+		9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61,
+		32, 112, 111, 115, 32, 124, 124, 32, 115, 101, 108, 102, 46, 112, 49, 32,
+		33, 61, 32, 110, 112, 111, 115, 41, 32, 123, 10, 9, 9, 9, 9, 9,
+		115, 101, 108, 102, 46, 115, 101, 116, 115, 101, 108, 40, 112, 111, 115, 44,
+		32, 110, 112, 111, 115, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9,
+		9, 9, 125, 10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 123, 10, 9,
+		9, 9, 9, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33, 61,
+		32, 110, 112, 111, 115, 32, 124, 124, 32, 115, 101, 108, 102, 46, 112, 49,
+		32, 33, 61, 32, 112, 111, 115, 41, 32, 123, 10, 9, 9, 9, 9, 9,
+		115, 101, 108, 102, 46, 115, 101, 116, 115, 101, 108, 40, 110, 112, 111, 115,
+		44, 32, 112, 111, 115, 44, 32, 116, 114, 117, 101, 41, 59, 10, 9, 9,
+		9, 9, 125, 10, 9, 9, 9, 125, 10, 9, 9, 9, 114, 101, 116, 117,
+		114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125, 59, 10, 10,
+		9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101,
+		100, 111, 119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		101, 41, 32, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120,
+		121, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 109, 112,
+		114, 101, 115, 115, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102,
+		46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 32,
+		61, 32, 40, 115, 101, 108, 102, 46, 115, 101, 99, 111, 110, 100, 97, 114,
+		121, 97, 98, 111, 114, 116, 32, 124, 124, 32, 115, 101, 108, 102, 46, 98,
+		117, 116, 116, 111, 110, 115, 32, 33, 61, 32, 115, 101, 108, 102, 46, 115,
+		101, 99, 111, 110, 100, 97, 114, 121, 41, 59, 10, 9, 9, 125, 59, 10,
+		10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115,
+		101, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
+		41, 32, 123, 10, 9, 9, 9, 115, 101, 108, 102, 46, 101, 118, 120, 121,
+		40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108, 102, 46, 109, 114, 108,
+		115, 101, 40, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40, 115, 101, 108,
+		102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61, 61, 32, 48, 41, 123,
+		10, 9, 9, 9, 9, 118, 97, 114, 32, 115, 112, 48, 32, 61, 32, 115,
+		101, 108, 102, 46, 112, 48, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32,
+		115, 112, 49, 32, 61, 32, 115, 101, 108, 102, 46, 112, 49, 59, 10, 9,
+		9, 9, 9, 118, 97, 114, 32, 108, 110, 32, 61, 32, 115, 101, 108, 102,
+		46, 108, 110, 101, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 116, 115,
+		105, 122, 101, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 105, 102, 40,
+		108, 110, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 115, 105, 122, 101,
+		32, 61, 32, 108, 110, 46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 116,
+		120, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 125,
+		10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101, 99, 111, 110, 100,
+		97, 114, 121, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 115, 101, 108,
+		102, 46, 115, 101, 116, 115, 101, 108, 40, 115, 101, 108, 102, 46, 111, 108,
+		100, 112, 48, 44, 32, 115, 101, 108, 102, 46, 111, 108, 100, 112, 49, 41,
+		59, 10, 9, 9, 9, 9, 105, 102, 40, 33, 115, 101, 108, 102, 46, 115,
+		101, 99, 111, 110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 41, 10, 9,
+		9, 9, 9, 105, 102, 40, 115, 112, 48, 32, 33, 61, 32, 115, 112, 49,
+		41, 32, 123, 10, 9, 9, 9, 9, 9, 118, 97, 114, 32, 116, 120, 116,
+		32, 61, 32, 115, 101, 108, 102, 46, 103, 101, 116, 40, 115, 112, 48, 44,
+		32, 115, 112, 49, 41, 59, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102,
+		46, 112, 111, 115, 116, 40, 91, 34, 99, 108, 105, 99, 107, 34, 43, 98,
+		44, 32, 116, 120, 116, 44, 32, 34, 34, 43, 115, 112, 48, 44, 32, 34,
+		34, 43, 115, 112, 49, 93, 41, 59, 10, 9, 9, 9, 9, 125, 32, 101,
+		108, 115, 101, 32, 105, 102, 40, 115, 101, 108, 102, 46, 112, 48, 32, 33,
+		61, 32, 115, 101, 108, 102, 46, 112, 49, 32, 38, 38, 10, 9, 9, 9,
+		9, 9, 9, 32, 115, 112, 48, 32, 62, 61, 32, 115, 101, 108, 102, 46,
+		112, 48, 32, 38, 38, 32, 115, 112, 48, 32, 60, 61, 32, 115, 101, 108,
+		102, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 9, 118, 97, 114,
+		32, 116, 120, 116, 32, 61, 32, 115, 101, 108, 102, 46, 103, 101, 116, 40,
+		115, 101, 108, 102, 46, 112, 48, 44, 32, 115, 101, 108, 102, 46, 112, 49,
+		41, 59, 10, 9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115,
+		116, 40, 91, 34, 99, 108, 105, 99, 107, 34, 43, 98, 44, 32, 116, 120,
+		116, 44, 32, 34, 34, 43, 115, 101, 108, 102, 46, 112, 48, 44, 32, 34,
+		34, 43, 115, 101, 108, 102, 46, 112, 49, 93, 41, 59, 10, 9, 9, 9,
+		9, 125, 32, 101, 108, 115, 101, 32, 105, 102, 40, 98, 32, 33, 61, 32,
+		49, 32, 38, 38, 32, 115, 112, 48, 32, 61, 61, 32, 115, 112, 49, 32,
+		38, 38, 32, 116, 115, 105, 122, 101, 32, 38, 38, 10, 9, 9, 9, 9,
+		9, 115, 112, 48, 32, 62, 61, 32, 116, 115, 105, 122, 101, 32, 38, 38,
+		32, 115, 112, 48, 62, 48, 41, 32, 123, 10, 9, 9, 9, 9, 9, 47,
+		47, 32, 97, 32, 99, 108, 105, 99, 107, 32, 97, 116, 32, 97, 32, 102,
+		105, 110, 97, 108, 32, 101, 109, 112, 116, 121, 32, 108, 105, 110, 101, 32,
+		115, 101, 108, 101, 99, 116, 115, 32, 116, 104, 101, 32, 112, 114, 101, 118,
+		105, 111, 117, 115, 10, 9, 9, 9, 9, 9, 47, 47, 32, 108, 105, 110,
+		101, 32, 40, 119, 104, 105, 99, 104, 32, 105, 115, 32, 116, 104, 101, 32,
+		108, 97, 115, 116, 32, 111, 110, 101, 32, 115, 104, 111, 119, 110, 41, 46,
+		10, 9, 9, 9, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32, 115, 101,
+		108, 102, 46, 103, 101, 116, 119, 111, 114, 100, 40, 116, 115, 105, 122, 101,
+		45, 49, 44, 32, 98, 32, 33, 61, 32, 56, 32, 124, 124, 32, 115, 101,
+		108, 102, 46, 100, 98, 108, 99, 108, 105, 99, 107, 62, 49, 41, 59, 10,
+		9, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116, 40, 91,
+		34, 99, 108, 105, 99, 107, 34, 43, 98, 44, 32, 120, 91, 48, 93, 44,
+		32, 34, 34, 43, 120, 91, 49, 93, 44, 32, 34, 34, 43, 120, 91, 50,
+		93, 93, 41, 59, 10, 9, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32,
+		123, 10, 9, 9, 9, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32, 115,
+		101, 108, 102, 46, 103, 101, 116, 119, 111, 114, 100, 40, 115, 112, 48, 44,
+		32, 98, 32, 33, 61, 32, 56, 32, 124, 124, 32, 115, 101, 108, 102, 46,
+		100, 98, 108, 99, 108, 105, 99, 107, 62, 49, 41, 59, 10, 9, 9, 9,
+		9, 9, 115, 101, 108, 102, 46, 112, 111, 115, 116, 40, 91, 34, 99, 108,
+		105, 99, 107, 34, 43, 98, 44, 32, 120, 91, 48, 93, 44, 32, 34, 34,
+		43, 120, 91, 49, 93, 44, 32, 34, 34, 43, 120, 91, 50, 93, 93, 41,
+		59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 115, 101, 108, 102,
+		46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61,
+		32, 115, 101, 108, 102, 46, 99, 46, 109, 109, 111, 118, 101, 59, 10, 9,
+		9, 9, 9, 115, 101, 108, 102, 46, 99, 46, 111, 110, 109, 111, 117, 115,
+		101, 100, 111, 119, 110, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46, 109,
+		100, 111, 119, 110, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99,
+		46, 111, 110, 109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 115, 101, 108,
+		102, 46, 99, 46, 109, 117, 112, 59, 10, 9, 9, 9, 9, 115, 101, 108,
+		102, 46, 112, 48, 32, 61, 32, 115, 101, 108, 102, 46, 111, 108, 100, 112,
+		48, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 112, 49, 32, 61,
+		32, 115, 101, 108, 102, 46, 111, 108, 100, 112, 49, 59, 10, 9, 9, 9,
+		9, 115, 101, 108, 102, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32,
+		61, 32, 48, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115, 101,
+		99, 111, 110, 100, 97, 114, 121, 97, 98, 111, 114, 116, 32, 61, 32, 102,
+		97, 108, 115, 101, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 115,
+		101, 108, 101, 99, 116, 101, 110, 100, 40, 41, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
+		109, 119, 97, 105, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 101, 41, 32, 123, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111,
+		110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 115, 101, 108, 102, 46, 101, 118, 120, 121, 40, 101,
+		41, 59, 10, 9, 9, 125, 59, 10, 9, 9, 116, 104, 105, 115, 46, 99,
+		46, 111, 110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 32, 61, 32, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 9,
+		115, 101, 108, 102, 46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9,
+		9, 115, 101, 108, 102, 46, 109, 112, 114, 101, 115, 115, 40, 101, 41, 59,
+		10, 9, 9, 125, 59, 10, 9, 9, 116, 104, 105, 115, 46, 99, 46, 111,
 		110, 109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 9, 115, 101, 108, 102,
+		46, 101, 118, 120, 121, 40, 101, 41, 59, 10, 9, 9, 9, 115, 101, 108,
+		102, 46, 109, 114, 108, 115, 101, 40, 101, 41, 59, 10, 9, 9, 9, 105,
+		102, 40, 115, 101, 108, 102, 46, 98, 117, 116, 116, 111, 110, 115, 32, 61,
+		61, 32, 48, 41, 32, 123, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46,
+		99, 46, 111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32,
+		115, 101, 108, 102, 46, 99, 46, 109, 109, 111, 118, 101, 59, 10, 9, 9,
+		9, 9, 115, 101, 108, 102, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101,
+		100, 111, 119, 110, 32, 61, 32, 115, 101, 108, 102, 46, 99, 46, 109, 100,
+		111, 119, 110, 59, 10, 9, 9, 9, 9, 115, 101, 108, 102, 46, 99, 46,
+		111, 110, 109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 115, 101, 108, 102,
+		46, 99, 46, 109, 117, 112, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125,
+		59, 10, 9, 125, 59, 10, 10, 9, 118, 97, 114, 32, 115, 101, 108, 102,
+		32, 61, 32, 116, 104, 105, 115, 59, 10, 9, 116, 104, 105, 115, 46, 99,
+		46, 111, 110, 109, 111, 117, 115, 101, 100, 111, 119, 110, 32, 61, 32, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 115, 101, 108, 102, 46, 109, 100, 111, 119, 110,
+		40, 101, 41, 59, 10, 9, 125, 59, 10, 9, 116, 104, 105, 115, 46, 99,
+		46, 111, 110, 109, 111, 117, 115, 101, 117, 112, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 115, 101, 108, 102, 46, 109, 117, 112, 40, 101, 41, 59,
+		10, 9, 125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109,
+		111, 117, 115, 101, 109, 111, 118, 101, 32, 61, 32, 102, 117, 110, 99, 116,
 		105, 111, 110, 40, 101, 41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114,
-		110, 32, 115, 101, 108, 102, 46, 109, 117, 112, 40, 101, 41, 59, 10, 9,
-		125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117,
-		115, 101, 109, 111, 118, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 101, 41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32,
-		115, 101, 108, 102, 46, 109, 109, 111, 118, 101, 40, 101, 41, 59, 10, 9,
-		125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 109, 100, 111, 119, 110,
-		32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115,
-		101, 100, 111, 119, 110, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 109,
-		117, 112, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111,
-		117, 115, 101, 117, 112, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 109,
-		109, 111, 118, 101, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 111, 110,
-		109, 111, 117, 115, 101, 109, 111, 118, 101, 59, 10, 10, 9, 116, 104, 105,
-		115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 119, 104, 101, 101, 108,
-		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 32, 123,
-		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 115, 101, 108, 102, 46, 109,
-		119, 104, 101, 101, 108, 40, 101, 41, 59, 10, 9, 125, 59, 10, 9, 116,
-		104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 101, 110, 116,
-		101, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41,
+		110, 32, 115, 101, 108, 102, 46, 109, 109, 111, 118, 101, 40, 101, 41, 59,
+		10, 9, 125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 109, 100, 111,
+		119, 110, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111,
+		117, 115, 101, 100, 111, 119, 110, 59, 10, 9, 116, 104, 105, 115, 46, 99,
+		46, 109, 117, 112, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 111, 110,
+		109, 111, 117, 115, 101, 117, 112, 59, 10, 9, 116, 104, 105, 115, 46, 99,
+		46, 109, 109, 111, 118, 101, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46,
+		111, 110, 109, 111, 117, 115, 101, 109, 111, 118, 101, 59, 10, 10, 9, 116,
+		104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 119, 104, 101,
+		101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41,
 		32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 115, 101, 108, 102,
-		46, 109, 101, 110, 116, 101, 114, 40, 101, 41, 59, 10, 9, 125, 59, 10,
-		10, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 112, 97, 115, 116, 101,
-		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 114, 101,
-		116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 125, 59, 10, 9, 116,
-		104, 105, 115, 46, 99, 46, 111, 110, 99, 111, 110, 116, 101, 120, 116, 109,
-		101, 110, 117, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
-		123, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 125, 59,
-		10, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 99, 108, 105, 99, 107,
-		32, 61, 32, 110, 117, 108, 108, 59, 10, 9, 116, 104, 105, 115, 46, 99,
-		46, 111, 110, 100, 98, 108, 99, 108, 105, 99, 107, 32, 61, 32, 110, 117,
-		108, 108, 59, 10, 10, 9, 116, 104, 105, 115, 46, 100, 46, 107, 101, 121,
-		112, 114, 101, 115, 115, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101,
-		41, 123, 10, 9, 9, 100, 111, 110, 116, 98, 117, 98, 98, 108, 101, 40,
-		101, 41, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 115, 101, 108,
-		102, 46, 116, 107, 101, 121, 112, 114, 101, 115, 115, 40, 101, 41, 59, 10,
-		9, 125, 41, 10, 9, 46, 107, 101, 121, 117, 112, 40, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 100, 111, 110, 116, 98,
-		117, 98, 98, 108, 101, 40, 101, 41, 59, 10, 9, 9, 114, 101, 116, 117,
-		114, 110, 32, 115, 101, 108, 102, 46, 116, 107, 101, 121, 117, 112, 40, 101,
-		41, 59, 10, 9, 125, 41, 10, 9, 46, 107, 101, 121, 100, 111, 119, 110,
-		40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9,
-		100, 111, 110, 116, 98, 117, 98, 98, 108, 101, 40, 101, 41, 59, 10, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 115, 101, 108, 102, 46, 116, 107, 101,
-		121, 100, 111, 119, 110, 40, 101, 41, 59, 10, 9, 125, 41, 59, 10, 10,
-		9, 116, 104, 105, 115, 46, 109, 97, 121, 114, 101, 115, 105, 122, 101, 40,
-		102, 97, 108, 115, 101, 41, 59, 10, 9, 116, 104, 105, 115, 46, 114, 101,
-		100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 10, 9, 47, 47,
-		32, 78, 111, 119, 32, 116, 104, 97, 116, 32, 119, 101, 32, 104, 97, 118,
-		101, 32, 101, 118, 101, 114, 121, 116, 104, 105, 110, 103, 32, 100, 101, 102,
-		105, 110, 101, 100, 44, 32, 109, 97, 107, 101, 32, 105, 116, 32, 97, 32,
-		99, 108, 105, 118, 101, 32, 99, 116, 108, 114, 10, 9, 47, 47, 32, 119,
-		105, 116, 104, 32, 112, 111, 115, 116, 32, 97, 110, 100, 32, 101, 118, 101,
-		114, 121, 116, 104, 105, 110, 103, 46, 10, 9, 67, 108, 105, 118, 101, 67,
-		116, 108, 114, 46, 99, 97, 108, 108, 40, 116, 104, 105, 115, 41, 59, 10,
-		10, 125, 10, 10, 100, 111, 99, 117, 109, 101, 110, 116, 46, 109, 107, 116,
-		120, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 100, 44,
-		32, 101, 44, 32, 99, 105, 100, 44, 32, 105, 100, 44, 32, 102, 111, 110,
-		116, 41, 32, 123, 10, 9, 118, 97, 114, 32, 99, 32, 61, 32, 110, 101,
-		119, 32, 67, 108, 105, 118, 101, 84, 101, 120, 116, 40, 100, 44, 32, 101,
-		44, 32, 99, 105, 100, 44, 32, 105, 100, 41, 59, 10, 9, 105, 102, 40,
-		33, 102, 111, 110, 116, 41, 32, 123, 10, 9, 9, 102, 111, 110, 116, 32,
-		61, 32, 34, 114, 34, 59, 10, 9, 125, 10, 9, 99, 46, 102, 111, 110,
-		116, 115, 116, 121, 108, 101, 32, 61, 32, 102, 111, 110, 116, 59, 10, 9,
-		99, 46, 102, 105, 120, 102, 111, 110, 116, 40, 41, 59, 10, 9, 114, 101,
-		116, 117, 114, 110, 32, 99, 59, 10, 125, 59, 10, 10,
+		46, 109, 119, 104, 101, 101, 108, 40, 101, 41, 59, 10, 9, 125, 59, 10,
+		9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 109, 111, 117, 115, 101, 101,
+		110, 116, 101, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		101, 41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 115, 101,
+		108, 102, 46, 109, 101, 110, 116, 101, 114, 40, 101, 41, 59, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 112, 97, 115,
+		116, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123,
+		114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 125, 59, 10,
+		9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 99, 111, 110, 116, 101, 120,
+		116, 109, 101, 110, 117, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 41, 123, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59,
+		125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 46, 111, 110, 99, 108, 105,
+		99, 107, 32, 61, 32, 110, 117, 108, 108, 59, 10, 9, 116, 104, 105, 115,
+		46, 99, 46, 111, 110, 100, 98, 108, 99, 108, 105, 99, 107, 32, 61, 32,
+		110, 117, 108, 108, 59, 10, 10, 9, 116, 104, 105, 115, 46, 100, 46, 107,
+		101, 121, 112, 114, 101, 115, 115, 40, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 101, 41, 123, 10, 9, 9, 100, 111, 110, 116, 98, 117, 98, 98, 108,
+		101, 40, 101, 41, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 115,
+		101, 108, 102, 46, 116, 107, 101, 121, 112, 114, 101, 115, 115, 40, 101, 41,
+		59, 10, 9, 125, 41, 10, 9, 46, 107, 101, 121, 117, 112, 40, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10, 9, 9, 100, 111, 110,
+		116, 98, 117, 98, 98, 108, 101, 40, 101, 41, 59, 10, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 115, 101, 108, 102, 46, 116, 107, 101, 121, 117, 112,
+		40, 101, 41, 59, 10, 9, 125, 41, 10, 9, 46, 107, 101, 121, 100, 111,
+		119, 110, 40, 102, 117, 110, 99, 116, 105, 111, 110, 40, 101, 41, 123, 10,
+		9, 9, 100, 111, 110, 116, 98, 117, 98, 98, 108, 101, 40, 101, 41, 59,
+		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 115, 101, 108, 102, 46, 116,
+		107, 101, 121, 100, 111, 119, 110, 40, 101, 41, 59, 10, 9, 125, 41, 59,
+		10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121, 114, 101, 115, 105, 122,
+		101, 40, 102, 97, 108, 115, 101, 41, 59, 10, 9, 116, 104, 105, 115, 46,
+		114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 10, 9,
+		47, 47, 32, 78, 111, 119, 32, 116, 104, 97, 116, 32, 119, 101, 32, 104,
+		97, 118, 101, 32, 101, 118, 101, 114, 121, 116, 104, 105, 110, 103, 32, 100,
+		101, 102, 105, 110, 101, 100, 44, 32, 109, 97, 107, 101, 32, 105, 116, 32,
+		97, 32, 99, 108, 105, 118, 101, 32, 99, 116, 108, 114, 10, 9, 47, 47,
+		32, 119, 105, 116, 104, 32, 112, 111, 115, 116, 32, 97, 110, 100, 32, 101,
+		118, 101, 114, 121, 116, 104, 105, 110, 103, 46, 10, 9, 67, 108, 105, 118,
+		101, 67, 116, 108, 114, 46, 99, 97, 108, 108, 40, 116, 104, 105, 115, 41,
+		59, 10, 10, 125, 10, 10, 100, 111, 99, 117, 109, 101, 110, 116, 46, 109,
+		107, 116, 120, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		100, 44, 32, 101, 44, 32, 99, 105, 100, 44, 32, 105, 100, 44, 32, 102,
+		111, 110, 116, 41, 32, 123, 10, 9, 118, 97, 114, 32, 99, 32, 61, 32,
+		110, 101, 119, 32, 67, 108, 105, 118, 101, 84, 101, 120, 116, 40, 100, 44,
+		32, 101, 44, 32, 99, 105, 100, 44, 32, 105, 100, 41, 59, 10, 9, 105,
+		102, 40, 33, 102, 111, 110, 116, 41, 32, 123, 10, 9, 9, 102, 111, 110,
+		116, 32, 61, 32, 34, 114, 34, 59, 10, 9, 125, 10, 9, 99, 46, 102,
+		111, 110, 116, 115, 116, 121, 108, 101, 32, 61, 32, 102, 111, 110, 116, 59,
+		10, 9, 99, 46, 102, 105, 120, 102, 111, 110, 116, 40, 41, 59, 10, 9,
+		114, 101, 116, 117, 114, 110, 32, 99, 59, 10, 125, 59, 10, 10,
 	},
 	"js/button.js": []byte{
 		34, 117, 115, 101, 32, 115, 116, 114, 105, 99, 116, 34, 59, 10, 47, 42, 10,
@@ -2947,1460 +3290,1788 @@ var Files = map[string][]byte{
 		99, 41, 59, 10, 9, 105, 102, 40, 105, 32, 60, 32, 48, 41, 10, 9,
 		9, 114, 101, 116, 117, 114, 110, 32, 99, 59, 10, 9, 114, 101, 116, 117,
 		114, 110, 32, 34, 40, 91, 123, 60, 34, 46, 99, 104, 97, 114, 65, 116,
-		40, 105, 41, 59, 10, 125, 10, 10, 47, 47, 32, 85, 115, 105, 110, 103,
-		32, 99, 116, 120, 46, 99, 108, 101, 97, 114, 82, 101, 99, 116, 40, 120,
-		44, 32, 121, 44, 32, 119, 44, 32, 104, 41, 32, 104, 97, 115, 32, 112,
-		114, 111, 98, 108, 101, 109, 115, 32, 105, 110, 32, 67, 104, 114, 111, 109,
-		101, 46, 10, 47, 47, 32, 84, 104, 105, 115, 32, 115, 101, 101, 109, 115,
-		32, 116, 111, 32, 119, 111, 114, 107, 46, 10, 102, 117, 110, 99, 116, 105,
-		111, 110, 32, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40,
-		99, 116, 120, 44, 32, 120, 44, 32, 121, 44, 32, 119, 105, 100, 44, 32,
-		104, 116, 41, 32, 123, 10, 9, 118, 97, 114, 32, 111, 102, 115, 32, 61,
-		32, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 59, 10,
-		9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61,
-		32, 34, 35, 68, 68, 68, 68, 67, 56, 34, 59, 10, 9, 99, 116, 120,
-		46, 102, 105, 108, 108, 82, 101, 99, 116, 40, 120, 44, 32, 121, 44, 32,
-		119, 105, 100, 44, 32, 104, 116, 41, 10, 9, 99, 116, 120, 46, 102, 105,
-		108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 111, 102, 115, 59, 10, 125,
-		10, 10, 47, 47, 32, 85, 115, 105, 110, 103, 32, 99, 116, 120, 46, 102,
+		40, 105, 41, 59, 10, 125, 10, 10, 47, 47, 32, 67, 111, 108, 111, 114,
+		32, 116, 104, 101, 109, 101, 115, 32, 102, 111, 114, 32, 116, 101, 120, 116,
+		58, 32, 98, 97, 99, 107, 103, 114, 111, 117, 110, 100, 44, 32, 116, 101,
+		120, 116, 44, 32, 97, 110, 100, 32, 115, 101, 108, 101, 99, 116, 105, 111,
+		110, 32, 99, 111, 108, 111, 114, 115, 46, 10, 47, 47, 32, 84, 104, 101,
+		32, 116, 104, 101, 109, 101, 32, 117, 115, 101, 100, 32, 105, 115, 32, 107,
+		101, 112, 116, 32, 105, 110, 32, 99, 116, 120, 46, 116, 104, 101, 109, 101,
+		46, 10, 118, 97, 114, 32, 116, 104, 101, 109, 101, 115, 32, 61, 32, 123,
+		10, 9, 108, 105, 103, 104, 116, 58, 32, 123, 98, 103, 58, 32, 34, 35,
+		68, 68, 68, 68, 67, 56, 34, 44, 32, 102, 103, 58, 32, 34, 98, 108,
+		97, 99, 107, 34, 44, 32, 115, 101, 108, 58, 32, 34, 35, 68, 49, 65,
+		48, 65, 48, 34, 44, 32, 108, 110, 102, 103, 58, 32, 34, 35, 56, 65,
+		56, 65, 55, 56, 34, 125, 44, 10, 9, 100, 97, 114, 107, 58, 32, 123,
+		98, 103, 58, 32, 34, 35, 49, 69, 49, 69, 49, 69, 34, 44, 32, 102,
+		103, 58, 32, 34, 35, 68, 52, 68, 52, 68, 52, 34, 44, 32, 115, 101,
+		108, 58, 32, 34, 35, 53, 65, 52, 65, 54, 65, 34, 44, 32, 108, 110,
+		102, 103, 58, 32, 34, 35, 54, 69, 54, 69, 54, 69, 34, 125, 44, 10,
+		9, 112, 97, 112, 101, 114, 58, 32, 123, 98, 103, 58, 32, 34, 35, 70,
+		70, 70, 70, 69, 65, 34, 44, 32, 102, 103, 58, 32, 34, 98, 108, 97,
+		99, 107, 34, 44, 32, 115, 101, 108, 58, 32, 34, 35, 69, 69, 69, 69,
+		57, 69, 34, 44, 32, 108, 110, 102, 103, 58, 32, 34, 35, 65, 48, 65,
+		48, 56, 65, 34, 125, 44, 10, 125, 59, 10, 10, 102, 117, 110, 99, 116,
+		105, 111, 110, 32, 99, 116, 120, 84, 104, 101, 109, 101, 40, 99, 116, 120,
+		41, 32, 123, 10, 9, 105, 102, 40, 99, 116, 120, 46, 116, 104, 101, 109,
+		101, 41, 32, 123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 99, 116,
+		120, 46, 116, 104, 101, 109, 101, 59, 10, 9, 125, 10, 9, 114, 101, 116,
+		117, 114, 110, 32, 116, 104, 101, 109, 101, 115, 46, 108, 105, 103, 104, 116,
+		59, 10, 125, 10, 10, 47, 47, 32, 85, 115, 105, 110, 103, 32, 99, 116,
+		120, 46, 99, 108, 101, 97, 114, 82, 101, 99, 116, 40, 120, 44, 32, 121,
+		44, 32, 119, 44, 32, 104, 41, 32, 104, 97, 115, 32, 112, 114, 111, 98,
+		108, 101, 109, 115, 32, 105, 110, 32, 67, 104, 114, 111, 109, 101, 46, 10,
+		47, 47, 32, 84, 104, 105, 115, 32, 115, 101, 101, 109, 115, 32, 116, 111,
+		32, 119, 111, 114, 107, 46, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32,
+		99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99, 116, 120,
+		44, 32, 120, 44, 32, 121, 44, 32, 119, 105, 100, 44, 32, 104, 116, 41,
+		32, 123, 10, 9, 118, 97, 114, 32, 111, 102, 115, 32, 61, 32, 99, 116,
+		120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 59, 10, 9, 99, 116,
+		120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 99, 116,
+		120, 84, 104, 101, 109, 101, 40, 99, 116, 120, 41, 46, 98, 103, 59, 10,
+		9, 99, 116, 120, 46, 102, 105, 108, 108, 82, 101, 99, 116, 40, 120, 44,
+		32, 121, 44, 32, 119, 105, 100, 44, 32, 104, 116, 41, 10, 9, 99, 116,
+		120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 111, 102,
+		115, 59, 10, 125, 10, 10, 47, 47, 32, 85, 115, 105, 110, 103, 32, 99,
+		116, 120, 46, 102, 105, 108, 108, 84, 101, 120, 116, 40, 116, 120, 116, 44,
+		32, 120, 44, 32, 121, 41, 32, 104, 97, 115, 32, 112, 114, 111, 98, 108,
+		101, 109, 115, 32, 105, 110, 32, 67, 104, 114, 111, 109, 101, 46, 10, 47,
+		47, 32, 84, 104, 105, 115, 32, 115, 101, 101, 109, 115, 32, 116, 111, 32,
+		119, 111, 114, 107, 46, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 99,
+		116, 120, 70, 105, 108, 108, 84, 101, 120, 116, 40, 99, 116, 120, 44, 32,
+		116, 120, 116, 44, 32, 120, 44, 32, 121, 41, 32, 123, 10, 9, 118, 97,
+		114, 32, 111, 102, 115, 32, 61, 32, 99, 116, 120, 46, 102, 105, 108, 108,
+		83, 116, 121, 108, 101, 59, 10, 9, 99, 116, 120, 46, 102, 105, 108, 108,
+		83, 116, 121, 108, 101, 32, 61, 32, 99, 116, 120, 84, 104, 101, 109, 101,
+		40, 99, 116, 120, 41, 46, 102, 103, 59, 10, 9, 99, 116, 120, 46, 102,
 		105, 108, 108, 84, 101, 120, 116, 40, 116, 120, 116, 44, 32, 120, 44, 32,
-		121, 41, 32, 104, 97, 115, 32, 112, 114, 111, 98, 108, 101, 109, 115, 32,
-		105, 110, 32, 67, 104, 114, 111, 109, 101, 46, 10, 47, 47, 32, 84, 104,
-		105, 115, 32, 115, 101, 101, 109, 115, 32, 116, 111, 32, 119, 111, 114, 107,
-		46, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 99, 116, 120, 70, 105,
-		108, 108, 84, 101, 120, 116, 40, 99, 116, 120, 44, 32, 116, 120, 116, 44,
-		32, 120, 44, 32, 121, 41, 32, 123, 10, 9, 118, 97, 114, 32, 111, 102,
-		115, 32, 61, 32, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108,
-		101, 59, 10, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108,
-		101, 32, 61, 32, 34, 98, 108, 97, 99, 107, 34, 59, 10, 9, 99, 116,
-		120, 46, 102, 105, 108, 108, 84, 101, 120, 116, 40, 116, 120, 116, 44, 32,
-		120, 44, 32, 121, 41, 59, 10, 9, 99, 116, 120, 46, 102, 105, 108, 108,
-		83, 116, 121, 108, 101, 32, 61, 32, 111, 102, 115, 59, 10, 125, 10, 10,
-		102, 117, 110, 99, 116, 105, 111, 110, 32, 76, 105, 110, 101, 40, 108, 110,
-		105, 44, 32, 111, 102, 102, 44, 32, 116, 120, 116, 44, 32, 101, 111, 108,
-		41, 32, 123, 10, 9, 116, 104, 105, 115, 46, 108, 110, 105, 32, 61, 32,
-		108, 110, 105, 59, 10, 9, 116, 104, 105, 115, 46, 111, 102, 102, 32, 61,
-		32, 111, 102, 102, 59, 10, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32,
-		61, 32, 116, 120, 116, 59, 10, 9, 116, 104, 105, 115, 46, 101, 111, 108,
-		32, 61, 32, 101, 111, 108, 59, 10, 9, 116, 104, 105, 115, 46, 110, 101,
-		120, 116, 32, 61, 32, 110, 117, 108, 108, 59, 10, 9, 116, 104, 105, 115,
-		46, 112, 114, 101, 118, 32, 61, 32, 110, 117, 108, 108, 59, 10, 10, 9,
-		47, 47, 32, 110, 111, 116, 32, 116, 111, 83, 116, 114, 105, 110, 103, 40,
-		41, 44, 32, 98, 121, 32, 105, 110, 116, 101, 110, 116, 105, 111, 110, 46,
-		10, 9, 116, 104, 105, 115, 46, 115, 116, 114, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116,
-		104, 105, 115, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 114, 101,
-		116, 117, 114, 110, 32, 34, 34, 43, 116, 104, 105, 115, 46, 111, 102, 102,
-		43, 34, 91, 34, 43, 116, 104, 105, 115, 46, 108, 110, 105, 43, 34, 93,
-		34, 43, 34, 32, 61, 92, 116, 91, 34, 32, 43, 32, 116, 104, 105, 115,
-		46, 116, 120, 116, 32, 43, 32, 34, 92, 92, 110, 93, 34, 59, 10, 9,
-		9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 34, 34, 43, 116, 104, 105, 115, 46, 111, 102, 102, 43,
-		34, 91, 34, 43, 116, 104, 105, 115, 46, 108, 110, 105, 43, 34, 93, 34,
-		43, 34, 32, 61, 92, 116, 91, 34, 32, 43, 32, 116, 104, 105, 115, 46,
-		116, 120, 116, 32, 43, 32, 34, 93, 34, 59, 10, 9, 9, 125, 10, 9,
-		125, 59, 10, 10, 9, 47, 47, 32, 108, 101, 110, 32, 99, 111, 117, 110,
-		116, 115, 32, 116, 104, 101, 32, 92, 110, 44, 32, 116, 104, 105, 115, 46,
-		116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 32, 100, 111, 101, 115, 32,
-		110, 111, 116, 46, 10, 9, 116, 104, 105, 115, 46, 108, 101, 110, 32, 61,
-		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9,
-		105, 102, 40, 116, 104, 105, 115, 46, 101, 111, 108, 41, 32, 123, 10, 9,
-		9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 120,
-		116, 46, 108, 101, 110, 103, 116, 104, 43, 49, 59, 10, 9, 9, 125, 10,
-		9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 120,
-		116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 125, 59, 10, 10, 9,
-		116, 104, 105, 115, 46, 115, 112, 108, 105, 116, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 108, 110, 111, 102, 102, 44, 32, 97, 100, 100,
-		110, 108, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 110, 108, 110, 32,
-		61, 32, 110, 101, 119, 32, 76, 105, 110, 101, 40, 116, 104, 105, 115, 46,
-		108, 110, 105, 43, 49, 44, 32, 116, 104, 105, 115, 46, 111, 102, 102, 43,
-		108, 110, 111, 102, 102, 43, 49, 44, 32, 34, 34, 44, 32, 116, 104, 105,
-		115, 46, 101, 111, 108, 41, 59, 10, 9, 9, 118, 97, 114, 32, 108, 110,
-		108, 101, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108,
-		101, 110, 103, 116, 104, 59, 10, 9, 9, 105, 102, 40, 108, 110, 111, 102,
-		102, 32, 60, 32, 108, 110, 108, 101, 110, 41, 32, 123, 10, 9, 9, 9,
-		110, 108, 110, 46, 116, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116,
-		120, 116, 46, 115, 108, 105, 99, 101, 40, 108, 110, 111, 102, 102, 44, 32,
-		108, 110, 108, 101, 110, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		116, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 115,
-		108, 105, 99, 101, 40, 48, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10,
-		9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 101, 111, 108, 32, 61,
-		32, 97, 100, 100, 110, 108, 59, 10, 9, 9, 110, 108, 110, 46, 110, 101,
-		120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 110, 101, 120, 116, 59, 10,
-		9, 9, 105, 102, 40, 110, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123,
-		10, 9, 9, 9, 110, 108, 110, 46, 110, 101, 120, 116, 46, 112, 114, 101,
-		118, 32, 61, 32, 110, 108, 110, 59, 10, 9, 9, 125, 10, 9, 9, 110,
-		108, 110, 46, 112, 114, 101, 118, 32, 61, 32, 116, 104, 105, 115, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 110, 101, 120, 116, 32, 61, 32, 110, 108,
-		110, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 106, 111,
-		105, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32,
-		123, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 110, 101, 120,
-		116, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10,
-		9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 43,
-		61, 32, 116, 104, 105, 115, 46, 110, 101, 120, 116, 46, 116, 120, 116, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 101, 111, 108, 32, 61, 32, 116, 104,
-		105, 115, 46, 110, 101, 120, 116, 46, 101, 111, 108, 59, 10, 9, 9, 116,
-		104, 105, 115, 46, 110, 101, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46,
-		110, 101, 120, 116, 46, 110, 101, 120, 116, 59, 10, 9, 9, 105, 102, 40,
-		116, 104, 105, 115, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 110, 101, 120, 116, 46, 112, 114, 101, 118, 32, 61,
-		32, 116, 104, 105, 115, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10,
-		9, 116, 104, 105, 115, 46, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 116, 44, 32, 108, 110, 111, 102, 102, 41, 32, 123,
-		10, 9, 9, 105, 102, 40, 108, 110, 111, 102, 102, 32, 61, 61, 32, 116,
-		104, 105, 115, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32,
-		123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 43, 61,
-		32, 116, 59, 10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9,
-		9, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 61, 32, 116, 104, 105,
-		115, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 48, 44, 32, 108,
-		110, 111, 102, 102, 41, 32, 43, 10, 9, 9, 9, 9, 116, 32, 43, 32,
-		116, 104, 105, 115, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 108,
-		110, 111, 102, 102, 44, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108,
-		101, 110, 103, 116, 104, 41, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10,
-		10, 9, 47, 47, 32, 100, 111, 101, 115, 32, 110, 111, 116, 32, 100, 101,
-		108, 32, 101, 111, 108, 10, 9, 116, 104, 105, 115, 46, 100, 101, 108, 32,
-		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 111, 102, 102,
-		44, 32, 110, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 108, 110, 108,
-		101, 110, 32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108, 101,
-		110, 103, 116, 104, 59, 10, 9, 9, 105, 102, 40, 108, 110, 111, 102, 102,
-		43, 110, 32, 62, 32, 108, 110, 108, 101, 110, 41, 32, 123, 10, 9, 9,
-		9, 110, 32, 61, 32, 108, 110, 108, 101, 110, 32, 45, 32, 108, 110, 111,
-		102, 102, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 110, 32, 62,
-		32, 48, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 116, 120,
-		116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 115, 108, 105,
-		99, 101, 40, 48, 44, 108, 110, 111, 102, 102, 41, 32, 43, 32, 116, 104,
-		105, 115, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 108, 110, 111,
-		102, 102, 43, 110, 44, 32, 108, 110, 108, 101, 110, 41, 59, 10, 9, 9,
-		125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 59, 10, 9, 125,
-		59, 10, 10, 9, 116, 104, 105, 115, 46, 100, 101, 108, 108, 105, 110, 101,
-		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
-		9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 114, 101, 118, 41, 32,
-		123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 112, 114, 101, 118, 46, 110,
-		101, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 110, 101, 120, 116, 59,
-		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 110,
-		101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 110,
-		101, 120, 116, 46, 112, 114, 101, 118, 32, 61, 32, 116, 104, 105, 115, 46,
-		112, 114, 101, 118, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9,
-		116, 104, 105, 115, 46, 114, 101, 110, 117, 109, 98, 101, 114, 32, 61, 32,
-		102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 102,
-		111, 114, 40, 118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104, 105, 115,
-		59, 32, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 108, 110,
-		32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9,
-		9, 105, 102, 40, 108, 110, 46, 112, 114, 101, 118, 32, 61, 61, 32, 110,
-		117, 108, 108, 41, 32, 123, 10, 9, 9, 9, 9, 108, 110, 46, 111, 102,
-		102, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 108, 110, 46, 108, 110,
-		105, 32, 61, 32, 48, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101,
-		32, 123, 10, 9, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32, 61, 32,
-		108, 110, 46, 112, 114, 101, 118, 46, 111, 102, 102, 32, 43, 32, 108, 110,
-		46, 112, 114, 101, 118, 46, 108, 101, 110, 40, 41, 59, 10, 9, 9, 9,
-		9, 108, 110, 46, 108, 110, 105, 32, 61, 32, 108, 110, 46, 112, 114, 101,
-		118, 46, 108, 110, 105, 43, 49, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		125, 10, 9, 125, 59, 10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111,
-		110, 32, 76, 105, 110, 101, 115, 40, 101, 108, 115, 41, 32, 123, 10, 9,
-		116, 104, 105, 115, 46, 99, 108, 101, 97, 114, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 116, 104, 105, 115,
-		46, 108, 110, 115, 32, 61, 32, 110, 101, 119, 32, 76, 105, 110, 101, 40,
-		48, 44, 32, 48, 44, 32, 34, 34, 44, 32, 102, 97, 108, 115, 101, 41,
-		59, 10, 9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 116,
-		104, 105, 115, 46, 108, 110, 115, 59, 9, 47, 47, 32, 102, 105, 114, 115,
-		116, 32, 108, 105, 110, 101, 32, 115, 104, 111, 119, 110, 10, 9, 9, 116,
-		104, 105, 115, 46, 108, 110, 101, 32, 61, 32, 116, 104, 105, 115, 46, 108,
-		110, 115, 59, 9, 47, 47, 32, 108, 97, 115, 116, 32, 108, 105, 110, 101,
-		10, 9, 9, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 61,
-		32, 48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32,
-		48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32, 48,
-		59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 32, 61,
-		32, 91, 93, 59, 9, 47, 47, 32, 111, 102, 32, 123, 110, 97, 109, 101,
-		58, 32, 109, 97, 114, 107, 44, 32, 112, 111, 115, 58, 32, 112, 125, 10,
-		9, 125, 59, 10, 9, 116, 104, 105, 115, 46, 99, 108, 101, 97, 114, 40,
-		41, 59, 10, 9, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112,
-		32, 61, 32, 52, 59, 10, 10, 9, 47, 47, 32, 116, 104, 101, 115, 101,
-		32, 109, 117, 115, 116, 32, 98, 101, 32, 114, 101, 100, 101, 102, 105, 110,
-		101, 100, 32, 116, 111, 32, 100, 114, 97, 119, 32, 116, 104, 101, 32, 108,
-		105, 110, 101, 115, 46, 10, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105,
-		99, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123,
-		125, 59, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121, 115, 99, 114, 111,
-		108, 108, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 108, 110, 41, 123, 125, 59, 10, 9, 116, 104, 105, 115, 46, 109, 97,
-		121, 115, 99, 114, 111, 108, 108, 100, 101, 108, 32, 61, 32, 102, 117, 110,
-		99, 116, 105, 111, 110, 40, 108, 110, 41, 123, 125, 59, 10, 9, 116, 104,
-		105, 115, 46, 115, 99, 114, 111, 108, 108, 100, 111, 119, 110, 32, 61, 32,
-		102, 117, 110, 99, 116, 105, 111, 110, 40, 110, 41, 123, 32, 114, 101, 116,
-		117, 114, 110, 32, 48, 59, 125, 59, 10, 9, 116, 104, 105, 115, 46, 115,
-		99, 114, 111, 108, 108, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 110, 41, 123, 32, 114, 101, 116, 117, 114, 110, 32, 48, 59,
-		125, 59, 10, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116,
-		101, 120, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
-		123, 125, 59, 10, 9, 116, 104, 105, 115, 46, 119, 114, 97, 112, 111, 102,
-		102, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 116, 41, 123,
-		32, 114, 101, 116, 117, 114, 110, 32, 116, 46, 108, 101, 110, 103, 116, 104,
-		59, 32, 125, 59, 10, 9, 116, 104, 105, 115, 46, 102, 114, 108, 110, 105,
-		110, 115, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 108, 110, 44, 32, 110, 105, 110, 115, 100, 101, 108, 41, 123, 125, 59,
-		10, 10, 9, 47, 47, 32, 112, 111, 115, 48, 32, 105, 115, 32, 111, 112,
-		116, 105, 111, 110, 97, 108, 32, 40, 48, 32, 98, 121, 32, 100, 101, 102,
-		97, 117, 108, 116, 41, 46, 10, 9, 116, 104, 105, 115, 46, 116, 97, 98,
-		116, 120, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 116,
-		44, 32, 112, 111, 115, 48, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116,
-		46, 105, 110, 100, 101, 120, 79, 102, 40, 39, 92, 116, 39, 41, 32, 60,
-		32, 48, 41, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 59,
-		10, 9, 9, 118, 97, 114, 32, 115, 32, 61, 32, 34, 34, 59, 10, 9,
-		9, 118, 97, 114, 32, 112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9,
-		105, 102, 40, 112, 111, 115, 48, 41, 32, 123, 10, 9, 9, 9, 112, 111,
-		115, 32, 61, 32, 112, 111, 115, 48, 59, 10, 9, 9, 125, 10, 9, 9,
-		102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105,
-		32, 60, 32, 116, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43,
-		41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 114, 32, 61, 32, 116, 46,
-		99, 104, 97, 114, 65, 116, 40, 105, 41, 59, 10, 9, 9, 9, 105, 102,
-		40, 114, 32, 61, 61, 32, 39, 92, 116, 39, 41, 32, 123, 10, 9, 9,
-		9, 9, 100, 111, 32, 123, 10, 9, 9, 9, 9, 9, 115, 32, 43, 61,
-		32, 34, 32, 34, 59, 10, 9, 9, 9, 9, 9, 112, 111, 115, 43, 43,
-		59, 10, 9, 9, 9, 9, 125, 119, 104, 105, 108, 101, 40, 112, 111, 115,
-		37, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 41, 59, 10,
-		9, 9, 9, 125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 9, 112, 111,
-		115, 43, 43, 59, 10, 9, 9, 9, 9, 115, 32, 43, 61, 32, 114, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117,
-		114, 110, 32, 115, 59, 9, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
-		115, 46, 109, 97, 114, 107, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 112, 48, 44, 32, 110, 41, 32, 123, 10, 9, 9,
-		102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105,
-		32, 60, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 46, 108, 101,
-		110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118,
-		97, 114, 32, 109, 32, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107,
-		115, 91, 105, 93, 59, 10, 9, 9, 9, 105, 102, 40, 109, 46, 112, 111,
-		115, 32, 62, 32, 112, 48, 41, 32, 123, 10, 9, 9, 9, 9, 109, 46,
-		112, 111, 115, 32, 43, 61, 32, 110, 59, 10, 9, 9, 9, 125, 10, 9,
-		9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97,
-		114, 107, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 112, 48, 44, 32, 112, 49, 41, 32, 123, 10, 9, 9, 102, 111, 114,
+		121, 41, 59, 10, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121,
+		108, 101, 32, 61, 32, 111, 102, 115, 59, 10, 125, 10, 10, 102, 117, 110,
+		99, 116, 105, 111, 110, 32, 76, 105, 110, 101, 40, 108, 110, 105, 44, 32,
+		111, 102, 102, 44, 32, 116, 120, 116, 44, 32, 101, 111, 108, 41, 32, 123,
+		10, 9, 116, 104, 105, 115, 46, 108, 110, 105, 32, 61, 32, 108, 110, 105,
+		59, 10, 9, 116, 104, 105, 115, 46, 111, 102, 102, 32, 61, 32, 111, 102,
+		102, 59, 10, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 61, 32, 116,
+		120, 116, 59, 10, 9, 116, 104, 105, 115, 46, 101, 111, 108, 32, 61, 32,
+		101, 111, 108, 59, 10, 9, 116, 104, 105, 115, 46, 110, 101, 120, 116, 32,
+		61, 32, 110, 117, 108, 108, 59, 10, 9, 116, 104, 105, 115, 46, 112, 114,
+		101, 118, 32, 61, 32, 110, 117, 108, 108, 59, 10, 10, 9, 47, 47, 32,
+		110, 111, 116, 32, 116, 111, 83, 116, 114, 105, 110, 103, 40, 41, 44, 32,
+		98, 121, 32, 105, 110, 116, 101, 110, 116, 105, 111, 110, 46, 10, 9, 116,
+		104, 105, 115, 46, 115, 116, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115,
+		46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114,
+		110, 32, 34, 34, 43, 116, 104, 105, 115, 46, 111, 102, 102, 43, 34, 91,
+		34, 43, 116, 104, 105, 115, 46, 108, 110, 105, 43, 34, 93, 34, 43, 34,
+		32, 61, 92, 116, 91, 34, 32, 43, 32, 116, 104, 105, 115, 46, 116, 120,
+		116, 32, 43, 32, 34, 92, 92, 110, 93, 34, 59, 10, 9, 9, 125, 32,
+		101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		32, 34, 34, 43, 116, 104, 105, 115, 46, 111, 102, 102, 43, 34, 91, 34,
+		43, 116, 104, 105, 115, 46, 108, 110, 105, 43, 34, 93, 34, 43, 34, 32,
+		61, 92, 116, 91, 34, 32, 43, 32, 116, 104, 105, 115, 46, 116, 120, 116,
+		32, 43, 32, 34, 93, 34, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10,
+		10, 9, 47, 47, 32, 108, 101, 110, 32, 99, 111, 117, 110, 116, 115, 32,
+		116, 104, 101, 32, 92, 110, 44, 32, 116, 104, 105, 115, 46, 116, 120, 116,
+		46, 108, 101, 110, 103, 116, 104, 32, 100, 111, 101, 115, 32, 110, 111, 116,
+		46, 10, 9, 116, 104, 105, 115, 46, 108, 101, 110, 32, 61, 32, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40,
+		116, 104, 105, 115, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108,
+		101, 110, 103, 116, 104, 43, 49, 59, 10, 9, 9, 125, 10, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108,
+		101, 110, 103, 116, 104, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
+		115, 46, 115, 112, 108, 105, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 108, 110, 111, 102, 102, 44, 32, 97, 100, 100, 110, 108, 41,
+		32, 123, 10, 9, 9, 118, 97, 114, 32, 110, 108, 110, 32, 61, 32, 110,
+		101, 119, 32, 76, 105, 110, 101, 40, 116, 104, 105, 115, 46, 108, 110, 105,
+		43, 49, 44, 32, 116, 104, 105, 115, 46, 111, 102, 102, 43, 108, 110, 111,
+		102, 102, 43, 49, 44, 32, 34, 34, 44, 32, 116, 104, 105, 115, 46, 101,
+		111, 108, 41, 59, 10, 9, 9, 118, 97, 114, 32, 108, 110, 108, 101, 110,
+		32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108, 101, 110, 103,
+		116, 104, 59, 10, 9, 9, 105, 102, 40, 108, 110, 111, 102, 102, 32, 60,
+		32, 108, 110, 108, 101, 110, 41, 32, 123, 10, 9, 9, 9, 110, 108, 110,
+		46, 116, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46,
+		115, 108, 105, 99, 101, 40, 108, 110, 111, 102, 102, 44, 32, 108, 110, 108,
+		101, 110, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 116, 120, 116,
+		32, 61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 115, 108, 105, 99,
+		101, 40, 48, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 125,
+		10, 9, 9, 116, 104, 105, 115, 46, 101, 111, 108, 32, 61, 32, 97, 100,
+		100, 110, 108, 59, 10, 9, 9, 110, 108, 110, 46, 110, 101, 120, 116, 32,
+		61, 32, 116, 104, 105, 115, 46, 110, 101, 120, 116, 59, 10, 9, 9, 105,
+		102, 40, 110, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9,
+		9, 110, 108, 110, 46, 110, 101, 120, 116, 46, 112, 114, 101, 118, 32, 61,
+		32, 110, 108, 110, 59, 10, 9, 9, 125, 10, 9, 9, 110, 108, 110, 46,
+		112, 114, 101, 118, 32, 61, 32, 116, 104, 105, 115, 59, 10, 9, 9, 116,
+		104, 105, 115, 46, 110, 101, 120, 116, 32, 61, 32, 110, 108, 110, 59, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 106, 111, 105, 110, 32,
+		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9,
+		9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 110, 101, 120, 116, 41, 32,
+		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125,
+		10, 9, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 43, 61, 32, 116,
+		104, 105, 115, 46, 110, 101, 120, 116, 46, 116, 120, 116, 59, 10, 9, 9,
+		116, 104, 105, 115, 46, 101, 111, 108, 32, 61, 32, 116, 104, 105, 115, 46,
+		110, 101, 120, 116, 46, 101, 111, 108, 59, 10, 9, 9, 116, 104, 105, 115,
+		46, 110, 101, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 110, 101, 120,
+		116, 46, 110, 101, 120, 116, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105,
+		115, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105,
+		115, 46, 110, 101, 120, 116, 46, 112, 114, 101, 118, 32, 61, 32, 116, 104,
+		105, 115, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104,
+		105, 115, 46, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 116, 44, 32, 108, 110, 111, 102, 102, 41, 32, 123, 10, 9, 9,
+		105, 102, 40, 108, 110, 111, 102, 102, 32, 61, 61, 32, 116, 104, 105, 115,
+		46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 43, 61, 32, 116, 59,
+		10, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 116,
+		104, 105, 115, 46, 116, 120, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116,
+		120, 116, 46, 115, 108, 105, 99, 101, 40, 48, 44, 32, 108, 110, 111, 102,
+		102, 41, 32, 43, 10, 9, 9, 9, 9, 116, 32, 43, 32, 116, 104, 105,
+		115, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 108, 110, 111, 102,
+		102, 44, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108, 101, 110, 103,
+		116, 104, 41, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 47,
+		47, 32, 100, 111, 101, 115, 32, 110, 111, 116, 32, 100, 101, 108, 32, 101,
+		111, 108, 10, 9, 116, 104, 105, 115, 46, 100, 101, 108, 32, 61, 32, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 111, 102, 102, 44, 32, 110,
+		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 108, 110, 108, 101, 110, 32,
+		61, 32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116,
+		104, 59, 10, 9, 9, 105, 102, 40, 108, 110, 111, 102, 102, 43, 110, 32,
+		62, 32, 108, 110, 108, 101, 110, 41, 32, 123, 10, 9, 9, 9, 110, 32,
+		61, 32, 108, 110, 108, 101, 110, 32, 45, 32, 108, 110, 111, 102, 102, 59,
+		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 110, 32, 62, 32, 48, 41,
+		32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 116, 120, 116, 32, 61,
+		32, 116, 104, 105, 115, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40,
+		48, 44, 108, 110, 111, 102, 102, 41, 32, 43, 32, 116, 104, 105, 115, 46,
+		116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 108, 110, 111, 102, 102, 43,
+		110, 44, 32, 108, 110, 108, 101, 110, 41, 59, 10, 9, 9, 125, 10, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 110, 59, 10, 9, 125, 59, 10, 10,
+		9, 116, 104, 105, 115, 46, 100, 101, 108, 108, 105, 110, 101, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 112, 114, 101, 118, 46, 110, 101, 120, 116,
+		32, 61, 32, 116, 104, 105, 115, 46, 110, 101, 120, 116, 59, 10, 9, 9,
+		125, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 110, 101, 120, 116,
+		41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 110, 101, 120, 116,
+		46, 112, 114, 101, 118, 32, 61, 32, 116, 104, 105, 115, 46, 112, 114, 101,
+		118, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
+		115, 46, 114, 101, 110, 117, 109, 98, 101, 114, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40,
+		118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104, 105, 115, 59, 32, 108,
+		110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 108, 110, 32, 61, 32,
+		108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 105, 102,
+		40, 108, 110, 46, 112, 114, 101, 118, 32, 61, 61, 32, 110, 117, 108, 108,
+		41, 32, 123, 10, 9, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32, 61,
+		32, 48, 59, 10, 9, 9, 9, 9, 108, 110, 46, 108, 110, 105, 32, 61,
+		32, 48, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10,
+		9, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32, 61, 32, 108, 110, 46,
+		112, 114, 101, 118, 46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 112, 114,
+		101, 118, 46, 108, 101, 110, 40, 41, 59, 10, 9, 9, 9, 9, 108, 110,
+		46, 108, 110, 105, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 46, 108,
+		110, 105, 43, 49, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9,
+		125, 59, 10, 125, 10, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 76,
+		105, 110, 101, 115, 40, 101, 108, 115, 41, 32, 123, 10, 9, 116, 104, 105,
+		115, 46, 99, 108, 101, 97, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 116, 104, 105, 115, 46, 108, 110,
+		115, 32, 61, 32, 110, 101, 119, 32, 76, 105, 110, 101, 40, 48, 44, 32,
+		48, 44, 32, 34, 34, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115,
+		46, 108, 110, 115, 59, 9, 47, 47, 32, 102, 105, 114, 115, 116, 32, 108,
+		105, 110, 101, 32, 115, 104, 111, 119, 110, 10, 9, 9, 116, 104, 105, 115,
+		46, 108, 110, 101, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 115, 59,
+		9, 47, 47, 32, 108, 97, 115, 116, 32, 108, 105, 110, 101, 10, 9, 9,
+		116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 61, 32, 48, 59,
+		10, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32, 48, 59, 10,
+		9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32, 48, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 32, 61, 32, 91, 93,
+		59, 9, 47, 47, 32, 111, 102, 32, 123, 110, 97, 109, 101, 58, 32, 109,
+		97, 114, 107, 44, 32, 112, 111, 115, 58, 32, 112, 125, 10, 9, 125, 59,
+		10, 9, 116, 104, 105, 115, 46, 99, 108, 101, 97, 114, 40, 41, 59, 10,
+		9, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 32, 61, 32,
+		52, 59, 10, 10, 9, 47, 47, 32, 116, 104, 101, 115, 101, 32, 109, 117,
+		115, 116, 32, 98, 101, 32, 114, 101, 100, 101, 102, 105, 110, 101, 100, 32,
+		116, 111, 32, 100, 114, 97, 119, 32, 116, 104, 101, 32, 108, 105, 110, 101,
+		115, 46, 10, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 32,
+		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 59, 10,
+		9, 116, 104, 105, 115, 46, 109, 97, 121, 115, 99, 114, 111, 108, 108, 105,
+		110, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110,
+		41, 123, 125, 59, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121, 115, 99,
+		114, 111, 108, 108, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 108, 110, 41, 123, 125, 59, 10, 9, 116, 104, 105, 115, 46,
+		115, 99, 114, 111, 108, 108, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 110, 41, 123, 32, 114, 101, 116, 117, 114, 110,
+		32, 48, 59, 125, 59, 10, 9, 116, 104, 105, 115, 46, 115, 99, 114, 111,
+		108, 108, 117, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		110, 41, 123, 32, 114, 101, 116, 117, 114, 110, 32, 48, 59, 125, 59, 10,
+		9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 123, 125, 59,
+		10, 9, 116, 104, 105, 115, 46, 119, 114, 97, 112, 111, 102, 102, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 116, 41, 123, 32, 114, 101,
+		116, 117, 114, 110, 32, 116, 46, 108, 101, 110, 103, 116, 104, 59, 32, 125,
+		59, 10, 9, 116, 104, 105, 115, 46, 102, 114, 108, 110, 105, 110, 115, 100,
+		101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110,
+		44, 32, 110, 105, 110, 115, 100, 101, 108, 41, 123, 125, 59, 10, 10, 9,
+		47, 47, 32, 112, 111, 115, 48, 32, 105, 115, 32, 111, 112, 116, 105, 111,
+		110, 97, 108, 32, 40, 48, 32, 98, 121, 32, 100, 101, 102, 97, 117, 108,
+		116, 41, 46, 10, 9, 116, 104, 105, 115, 46, 116, 97, 98, 116, 120, 116,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 116, 44, 32, 112,
+		111, 115, 48, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 46, 105, 110,
+		100, 101, 120, 79, 102, 40, 39, 92, 116, 39, 41, 32, 60, 32, 48, 41,
+		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 59, 10, 9, 9,
+		118, 97, 114, 32, 115, 32, 61, 32, 34, 34, 59, 10, 9, 9, 118, 97,
+		114, 32, 112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9, 105, 102, 40,
+		112, 111, 115, 48, 41, 32, 123, 10, 9, 9, 9, 112, 111, 115, 32, 61,
+		32, 112, 111, 115, 48, 59, 10, 9, 9, 125, 10, 9, 9, 102, 111, 114,
+		40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32,
+		116, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 123, 10,
+		9, 9, 9, 118, 97, 114, 32, 114, 32, 61, 32, 116, 46, 99, 104, 97,
+		114, 65, 116, 40, 105, 41, 59, 10, 9, 9, 9, 105, 102, 40, 114, 32,
+		61, 61, 32, 39, 92, 116, 39, 41, 32, 123, 10, 9, 9, 9, 9, 100,
+		111, 32, 123, 10, 9, 9, 9, 9, 9, 115, 32, 43, 61, 32, 34, 32,
+		34, 59, 10, 9, 9, 9, 9, 9, 112, 111, 115, 43, 43, 59, 10, 9,
+		9, 9, 9, 125, 119, 104, 105, 108, 101, 40, 112, 111, 115, 37, 116, 104,
+		105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 41, 59, 10, 9, 9, 9,
+		125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 9, 112, 111, 115, 43, 43,
+		59, 10, 9, 9, 9, 9, 115, 32, 43, 61, 32, 114, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32,
+		115, 59, 9, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109,
+		97, 114, 107, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 112, 48, 44, 32, 110, 41, 32, 123, 10, 9, 9, 102, 111, 114,
 		40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32,
 		116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116,
 		104, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32,
 		109, 32, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105,
-		93, 59, 10, 9, 9, 9, 105, 102, 40, 109, 46, 112, 111, 115, 32, 60,
-		61, 32, 112, 48, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 116,
-		105, 110, 117, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97,
-		114, 32, 109, 112, 49, 32, 61, 32, 112, 49, 59, 10, 9, 9, 9, 105,
-		102, 40, 109, 112, 49, 32, 62, 32, 109, 46, 112, 111, 115, 41, 32, 123,
-		10, 9, 9, 9, 9, 109, 112, 49, 32, 61, 32, 109, 46, 112, 111, 115,
-		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 109, 46, 112, 111, 115, 32,
-		45, 61, 32, 40, 109, 112, 49, 45, 112, 48, 41, 59, 10, 9, 9, 125,
-		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 115, 101, 116, 109,
-		97, 114, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 109,
-		97, 114, 107, 44, 32, 112, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40,
+		93, 59, 10, 9, 9, 9, 105, 102, 40, 109, 46, 112, 111, 115, 32, 62,
+		32, 112, 48, 41, 32, 123, 10, 9, 9, 9, 9, 109, 46, 112, 111, 115,
+		32, 43, 61, 32, 110, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 100,
+		101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 48,
+		44, 32, 112, 49, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40, 118, 97,
+		114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104, 105,
+		115, 46, 109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32,
+		105, 43, 43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 109, 32, 61,
+		32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105, 93, 59, 10,
+		9, 9, 9, 105, 102, 40, 109, 46, 112, 111, 115, 32, 60, 61, 32, 112,
+		48, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117,
+		101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 109,
+		112, 49, 32, 61, 32, 112, 49, 59, 10, 9, 9, 9, 105, 102, 40, 109,
+		112, 49, 32, 62, 32, 109, 46, 112, 111, 115, 41, 32, 123, 10, 9, 9,
+		9, 9, 109, 112, 49, 32, 61, 32, 109, 46, 112, 111, 115, 59, 10, 9,
+		9, 9, 125, 10, 9, 9, 9, 109, 46, 112, 111, 115, 32, 45, 61, 32,
+		40, 109, 112, 49, 45, 112, 48, 41, 59, 10, 9, 9, 125, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 115, 101, 116, 109, 97, 114, 107,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 109, 97, 114, 107,
+		44, 32, 112, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114,
+		32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104, 105, 115,
+		46, 109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105,
+		43, 43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 109, 32, 61, 32,
+		116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105, 93, 59, 10, 9,
+		9, 9, 105, 102, 40, 109, 46, 110, 97, 109, 101, 32, 61, 61, 32, 109,
+		97, 114, 107, 41, 32, 123, 10, 9, 9, 9, 9, 109, 46, 112, 111, 115,
+		32, 61, 32, 112, 59, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105,
+		115, 46, 109, 97, 114, 107, 115, 46, 112, 117, 115, 104, 40, 123, 110, 97,
+		109, 101, 58, 32, 109, 97, 114, 107, 44, 32, 112, 111, 115, 58, 32, 112,
+		125, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 103,
+		101, 116, 109, 97, 114, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40,
 		118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116,
 		104, 105, 115, 46, 109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116, 104,
 		59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 109,
 		32, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105, 93,
 		59, 10, 9, 9, 9, 105, 102, 40, 109, 46, 110, 97, 109, 101, 32, 61,
-		61, 32, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 9, 9, 109, 46,
-		112, 111, 115, 32, 61, 32, 112, 59, 10, 9, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9,
-		116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 46, 112, 117, 115, 104, 40,
-		123, 110, 97, 109, 101, 58, 32, 109, 97, 114, 107, 44, 32, 112, 111, 115,
-		58, 32, 112, 125, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
-		115, 46, 103, 101, 116, 109, 97, 114, 107, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 102,
-		111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32,
-		60, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 46, 108, 101, 110,
-		103, 116, 104, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118, 97,
-		114, 32, 109, 32, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115,
-		91, 105, 93, 59, 10, 9, 9, 9, 105, 102, 40, 109, 46, 110, 97, 109,
-		101, 32, 61, 61, 32, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 109, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 117, 108,
-		108, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 100, 101,
-		108, 109, 97, 114, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40, 118,
-		97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104,
-		105, 115, 46, 109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116, 104, 59,
-		32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 109, 32,
-		61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105, 93, 59,
-		10, 9, 9, 9, 105, 102, 40, 109, 46, 110, 97, 109, 101, 32, 61, 61,
-		32, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 109, 97, 114, 107, 115, 46, 115, 112, 108, 105, 99, 101, 40, 105,
-		44, 32, 49, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 125, 10, 10, 9, 116,
-		104, 105, 115, 46, 97, 100, 100, 108, 110, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 108, 110, 41, 32, 123, 10, 9, 9, 108, 110, 46,
-		112, 114, 101, 118, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 101, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 32, 108, 110,
-		59, 10, 9, 9, 105, 102, 40, 108, 110, 46, 112, 114, 101, 118, 41, 32,
-		123, 10, 9, 9, 9, 108, 110, 46, 108, 110, 105, 32, 61, 32, 108, 110,
-		46, 112, 114, 101, 118, 46, 108, 110, 105, 43, 49, 59, 10, 9, 9, 9,
-		108, 110, 46, 111, 102, 102, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118,
-		46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 112, 114, 101, 118, 46, 108,
-		101, 110, 40, 41, 59, 10, 9, 9, 9, 108, 110, 46, 112, 114, 101, 118,
-		46, 110, 101, 120, 116, 32, 61, 32, 108, 110, 59, 10, 9, 9, 125, 32,
-		101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 108, 110, 46, 108, 110, 105,
-		32, 61, 32, 48, 59, 10, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32,
-		61, 32, 48, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 115,
-		32, 61, 32, 108, 110, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108,
-		110, 48, 32, 61, 32, 108, 110, 59, 10, 9, 9, 125, 10, 9, 9, 116,
-		104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 43, 61, 32, 108, 110,
-		46, 108, 101, 110, 40, 41, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47,
-		32, 115, 101, 101, 107, 32, 97, 32, 108, 105, 110, 101, 32, 40, 102, 105,
-		114, 115, 116, 32, 105, 115, 32, 48, 41, 46, 10, 9, 116, 104, 105, 115,
-		46, 115, 101, 101, 107, 108, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 112, 111, 115, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32,
-		108, 110, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 115, 59, 10, 9,
-		9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104,
-		105, 115, 46, 108, 110, 115, 59, 32, 108, 110, 59, 32, 108, 110, 32, 61,
-		32, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 105,
-		102, 40, 112, 111, 115, 45, 45, 32, 60, 61, 32, 48, 41, 32, 123, 10,
-		9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 108, 110, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 116, 104, 105, 115, 46, 108, 110, 115, 59, 10, 9, 125, 59, 10, 10,
-		9, 47, 47, 32, 114, 101, 116, 117, 114, 110, 32, 91, 108, 105, 110, 101,
-		44, 32, 111, 102, 102, 32, 97, 116, 32, 108, 105, 110, 101, 93, 32, 111,
-		114, 32, 91, 110, 117, 108, 108, 44, 32, 48, 93, 10, 9, 47, 47, 32,
-		105, 102, 32, 112, 111, 115, 32, 105, 115, 32, 97, 116, 32, 116, 104, 101,
-		32, 101, 110, 100, 32, 111, 102, 32, 97, 32, 108, 105, 110, 101, 44, 32,
-		116, 104, 97, 116, 32, 108, 105, 110, 101, 32, 105, 115, 32, 114, 101, 116,
-		117, 114, 110, 101, 100, 44, 10, 9, 47, 47, 32, 97, 110, 100, 32, 110,
-		111, 116, 32, 116, 104, 101, 32, 110, 101, 120, 116, 32, 108, 105, 110, 101,
-		32, 97, 116, 32, 48, 46, 10, 9, 116, 104, 105, 115, 46, 115, 101, 101,
-		107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 111, 115,
-		41, 32, 123, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110,
-		32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 115, 59, 32, 108, 110, 59,
-		32, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123,
-		10, 9, 9, 9, 105, 102, 40, 112, 111, 115, 32, 62, 61, 32, 108, 110,
-		46, 111, 102, 102, 32, 38, 38, 32, 112, 111, 115, 32, 60, 61, 32, 108,
-		110, 46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 116, 120, 116, 46, 108,
-		101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 91, 108, 110, 44, 32, 112, 111, 115, 45, 108, 110, 46,
-		111, 102, 102, 93, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 91, 110, 117, 108, 108, 44, 32, 48,
-		93, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 114, 101, 116, 117,
-		114, 110, 32, 116, 104, 101, 32, 112, 111, 115, 32, 102, 111, 114, 32, 97,
-		32, 115, 101, 101, 107, 10, 9, 116, 104, 105, 115, 46, 115, 101, 101, 107,
-		112, 111, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108,
-		110, 44, 32, 108, 110, 111, 102, 102, 41, 32, 123, 10, 9, 9, 105, 102,
-		40, 108, 110, 32, 61, 61, 32, 110, 117, 108, 108, 41, 32, 123, 10, 9,
-		9, 9, 114, 101, 116, 117, 114, 110, 32, 48, 59, 10, 9, 9, 125, 10,
-		9, 9, 105, 102, 40, 108, 110, 111, 102, 102, 32, 62, 32, 108, 110, 46,
-		116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 108, 110, 46, 111, 102, 102, 32, 43,
-		32, 108, 110, 46, 108, 101, 110, 40, 41, 59, 10, 9, 9, 125, 10, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 108, 110, 46, 111, 102, 102, 32, 43,
-		32, 108, 110, 111, 102, 102, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104,
-		105, 115, 46, 114, 101, 102, 111, 114, 109, 97, 116, 32, 61, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 108, 110, 48, 41, 32, 123, 10, 9, 9,
-		118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115, 46, 99,
-		116, 120, 59, 10, 9, 9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111,
-		110, 116, 40, 41, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117,
-		103, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 97, 118, 97, 105,
-		108, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104,
-		32, 45, 32, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122,
-		59, 10, 9, 9, 9, 118, 97, 114, 32, 108, 110, 48, 105, 32, 61, 32,
-		108, 110, 48, 63, 108, 110, 48, 46, 108, 110, 105, 58, 45, 49, 59, 10,
-		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
-		114, 101, 102, 111, 114, 109, 97, 116, 32, 108, 110, 32, 34, 32, 43, 32,
-		108, 110, 48, 105, 32, 43, 32, 34, 32, 119, 105, 100, 32, 34, 32, 43,
-		32, 97, 118, 97, 105, 108, 32, 43, 32, 34, 58, 34, 32, 41, 59, 10,
-		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 116, 114, 97, 99, 101,
-		40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 84, 79, 68,
-		79, 58, 32, 115, 104, 111, 117, 108, 100, 32, 103, 101, 116, 32, 97, 110,
-		32, 105, 110, 100, 105, 99, 97, 116, 105, 111, 110, 32, 114, 101, 103, 97,
-		114, 100, 105, 110, 103, 32, 97, 116, 32, 119, 104, 105, 99, 104, 10, 9,
-		9, 47, 47, 32, 112, 111, 105, 110, 116, 32, 105, 116, 39, 115, 32, 115,
-		97, 102, 101, 32, 116, 111, 32, 97, 115, 115, 117, 109, 101, 32, 116, 104,
-		97, 116, 32, 110, 111, 32, 102, 117, 114, 116, 104, 101, 114, 32, 114, 101,
-		102, 111, 114, 109, 97, 116, 10, 9, 9, 47, 47, 32, 119, 111, 114, 107,
-		32, 105, 115, 32, 110, 101, 101, 100, 101, 100, 32, 97, 110, 100, 32, 115,
-		116, 111, 112, 32, 116, 104, 101, 114, 101, 46, 10, 9, 9, 102, 111, 114,
-		40, 118, 97, 114, 32, 108, 110, 32, 61, 32, 108, 110, 48, 59, 32, 108,
-		110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 41, 32, 123, 10, 9,
-		9, 9, 47, 47, 32, 109, 101, 114, 103, 101, 32, 116, 101, 120, 116, 32,
-		111, 110, 32, 116, 104, 101, 32, 115, 97, 109, 101, 32, 108, 105, 110, 101,
-		10, 9, 9, 9, 119, 104, 105, 108, 101, 40, 33, 108, 110, 46, 101, 111,
-		108, 32, 38, 38, 32, 108, 110, 46, 110, 101, 120, 116, 32, 33, 61, 32,
-		110, 117, 108, 108, 41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 108,
-		110, 46, 110, 101, 120, 116, 32, 61, 61, 32, 116, 104, 105, 115, 46, 108,
-		110, 101, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46,
-		108, 110, 101, 32, 61, 32, 108, 110, 59, 10, 9, 9, 9, 9, 125, 10,
-		9, 9, 9, 9, 105, 102, 40, 108, 110, 46, 110, 101, 120, 116, 32, 61,
-		61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 41, 32, 123, 10, 9, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 108, 110,
-		59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 108, 110, 46, 106,
-		111, 105, 110, 40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 47,
-		47, 32, 114, 101, 109, 111, 118, 101, 32, 101, 109, 112, 116, 121, 32, 108,
-		105, 110, 101, 115, 32, 98, 117, 116, 32, 107, 101, 101, 112, 32, 97, 110,
-		32, 101, 109, 112, 116, 121, 32, 108, 105, 110, 101, 32, 97, 116, 32, 116,
-		104, 101, 32, 101, 110, 100, 46, 10, 9, 9, 9, 118, 97, 114, 32, 110,
-		101, 120, 116, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59, 10, 9,
-		9, 9, 105, 102, 40, 108, 110, 46, 108, 101, 110, 40, 41, 32, 61, 61,
-		32, 48, 32, 38, 38, 32, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9,
-		9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 61,
-		32, 108, 110, 41, 32, 123, 10, 9, 9, 9, 9, 9, 99, 111, 110, 115,
-		111, 108, 101, 46, 108, 111, 103, 40, 34, 108, 105, 110, 101, 115, 58, 32,
-		114, 101, 102, 111, 114, 109, 97, 116, 32, 106, 111, 105, 110, 32, 98, 117,
-		103, 63, 34, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9,
-		105, 102, 40, 108, 110, 48, 32, 61, 61, 32, 108, 110, 41, 32, 123, 10,
-		9, 9, 9, 9, 9, 108, 110, 48, 32, 61, 32, 110, 101, 120, 116, 59,
-		10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104,
-		105, 115, 46, 108, 110, 48, 32, 61, 61, 32, 108, 110, 41, 32, 123, 10,
-		9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32,
-		110, 101, 120, 116, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9,
-		105, 102, 40, 116, 104, 105, 115, 46, 108, 110, 115, 32, 61, 61, 32, 108,
-		110, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108,
-		110, 115, 32, 61, 32, 110, 101, 120, 116, 59, 10, 9, 9, 9, 9, 125,
-		10, 9, 9, 9, 9, 108, 110, 46, 100, 101, 108, 108, 105, 110, 101, 40,
-		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 108, 110, 32, 61, 32,
-		110, 101, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 114,
-		101, 99, 111, 109, 112, 117, 116, 101, 32, 119, 114, 97, 112, 115, 44, 32,
-		111, 102, 102, 115, 101, 116, 115, 44, 32, 97, 110, 100, 32, 110, 117, 109,
-		98, 101, 114, 115, 46, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32,
-		108, 110, 32, 61, 32, 108, 110, 48, 59, 32, 108, 110, 32, 33, 61, 32,
-		110, 117, 108, 108, 59, 32, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101,
-		120, 116, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 33, 108, 110, 46,
-		112, 114, 101, 118, 41, 32, 123, 10, 9, 9, 9, 9, 108, 110, 46, 111,
-		102, 102, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 108, 110, 46, 108,
-		110, 105, 32, 61, 32, 48, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115,
-		101, 32, 123, 10, 9, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32, 61,
-		32, 108, 110, 46, 112, 114, 101, 118, 46, 111, 102, 102, 32, 43, 32, 108,
-		110, 46, 112, 114, 101, 118, 46, 108, 101, 110, 40, 41, 59, 10, 9, 9,
+		61, 32, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 109, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125,
+		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 117, 108, 108, 59, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 100, 101, 108, 109, 97,
+		114, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 109, 97,
+		114, 107, 41, 32, 123, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32,
+		105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104, 105, 115, 46,
+		109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43,
+		43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 109, 32, 61, 32, 116,
+		104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105, 93, 59, 10, 9, 9,
+		9, 105, 102, 40, 109, 46, 110, 97, 109, 101, 32, 61, 61, 32, 109, 97,
+		114, 107, 41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 109,
+		97, 114, 107, 115, 46, 115, 112, 108, 105, 99, 101, 40, 105, 44, 32, 49,
+		41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		9, 125, 10, 9, 9, 125, 10, 9, 125, 10, 10, 9, 116, 104, 105, 115,
+		46, 97, 100, 100, 108, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 108, 110, 41, 32, 123, 10, 9, 9, 108, 110, 46, 112, 114, 101,
+		118, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 101, 59, 10, 9, 9,
+		116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 32, 108, 110, 59, 10, 9,
+		9, 105, 102, 40, 108, 110, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9,
 		9, 9, 108, 110, 46, 108, 110, 105, 32, 61, 32, 108, 110, 46, 112, 114,
-		101, 118, 46, 108, 110, 105, 32, 43, 32, 49, 59, 10, 9, 9, 9, 125,
-		10, 9, 9, 9, 118, 97, 114, 32, 119, 111, 102, 102, 32, 61, 32, 116,
-		104, 105, 115, 46, 119, 114, 97, 112, 111, 102, 102, 40, 108, 110, 46, 116,
-		120, 116, 41, 59, 10, 9, 9, 9, 105, 102, 40, 119, 111, 102, 102, 32,
-		60, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41,
-		32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103,
+		101, 118, 46, 108, 110, 105, 43, 49, 59, 10, 9, 9, 9, 108, 110, 46,
+		111, 102, 102, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 46, 111, 102,
+		102, 32, 43, 32, 108, 110, 46, 112, 114, 101, 118, 46, 108, 101, 110, 40,
+		41, 59, 10, 9, 9, 9, 108, 110, 46, 112, 114, 101, 118, 46, 110, 101,
+		120, 116, 32, 61, 32, 108, 110, 59, 10, 9, 9, 125, 32, 101, 108, 115,
+		101, 32, 123, 10, 9, 9, 9, 108, 110, 46, 108, 110, 105, 32, 61, 32,
+		48, 59, 10, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32, 61, 32, 48,
+		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 115, 32, 61, 32,
+		108, 110, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32,
+		61, 32, 108, 110, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115,
+		46, 110, 114, 117, 110, 101, 115, 32, 43, 61, 32, 108, 110, 46, 108, 101,
+		110, 40, 41, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 115, 101,
+		101, 107, 32, 97, 32, 108, 105, 110, 101, 32, 40, 102, 105, 114, 115, 116,
+		32, 105, 115, 32, 48, 41, 46, 10, 9, 116, 104, 105, 115, 46, 115, 101,
+		101, 107, 108, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		112, 111, 115, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 108, 110, 32,
+		61, 32, 116, 104, 105, 115, 46, 108, 110, 115, 59, 10, 9, 9, 102, 111,
+		114, 40, 118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104, 105, 115, 46,
+		108, 110, 115, 59, 32, 108, 110, 59, 32, 108, 110, 32, 61, 32, 108, 110,
+		46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 112,
+		111, 115, 45, 45, 32, 60, 61, 32, 48, 41, 32, 123, 10, 9, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 108, 110, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104,
+		105, 115, 46, 108, 110, 115, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47,
+		32, 114, 101, 116, 117, 114, 110, 32, 91, 108, 105, 110, 101, 44, 32, 111,
+		102, 102, 32, 97, 116, 32, 108, 105, 110, 101, 93, 32, 111, 114, 32, 91,
+		110, 117, 108, 108, 44, 32, 48, 93, 10, 9, 47, 47, 32, 105, 102, 32,
+		112, 111, 115, 32, 105, 115, 32, 97, 116, 32, 116, 104, 101, 32, 101, 110,
+		100, 32, 111, 102, 32, 97, 32, 108, 105, 110, 101, 44, 32, 116, 104, 97,
+		116, 32, 108, 105, 110, 101, 32, 105, 115, 32, 114, 101, 116, 117, 114, 110,
+		101, 100, 44, 10, 9, 47, 47, 32, 97, 110, 100, 32, 110, 111, 116, 32,
+		116, 104, 101, 32, 110, 101, 120, 116, 32, 108, 105, 110, 101, 32, 97, 116,
+		32, 48, 46, 10, 9, 116, 104, 105, 115, 46, 115, 101, 101, 107, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 111, 115, 41, 32, 123,
+		10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 32, 61, 32,
+		116, 104, 105, 115, 46, 108, 110, 115, 59, 32, 108, 110, 59, 32, 108, 110,
+		32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9,
+		9, 105, 102, 40, 112, 111, 115, 32, 62, 61, 32, 108, 110, 46, 111, 102,
+		102, 32, 38, 38, 32, 112, 111, 115, 32, 60, 61, 32, 108, 110, 46, 111,
+		102, 102, 32, 43, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103,
+		116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		32, 91, 108, 110, 44, 32, 112, 111, 115, 45, 108, 110, 46, 111, 102, 102,
+		93, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 91, 110, 117, 108, 108, 44, 32, 48, 93, 59, 10,
+		9, 125, 59, 10, 10, 9, 47, 47, 32, 114, 101, 116, 117, 114, 110, 32,
+		116, 104, 101, 32, 112, 111, 115, 32, 102, 111, 114, 32, 97, 32, 115, 101,
+		101, 107, 10, 9, 116, 104, 105, 115, 46, 115, 101, 101, 107, 112, 111, 115,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 44, 32,
+		108, 110, 111, 102, 102, 41, 32, 123, 10, 9, 9, 105, 102, 40, 108, 110,
+		32, 61, 61, 32, 110, 117, 108, 108, 41, 32, 123, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 48, 59, 10, 9, 9, 125, 10, 9, 9, 105,
+		102, 40, 108, 110, 111, 102, 102, 32, 62, 32, 108, 110, 46, 116, 120, 116,
+		46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 108, 110, 46, 111, 102, 102, 32, 43, 32, 108, 110,
+		46, 108, 101, 110, 40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 108, 110, 46, 111, 102, 102, 32, 43, 32, 108, 110,
+		111, 102, 102, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
+		114, 101, 102, 111, 114, 109, 97, 116, 32, 61, 32, 102, 117, 110, 99, 116,
+		105, 111, 110, 40, 108, 110, 48, 41, 32, 123, 10, 9, 9, 118, 97, 114,
+		32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115, 46, 99, 116, 120, 59,
+		10, 9, 9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111, 110, 116, 40,
+		41, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32,
+		123, 10, 9, 9, 9, 118, 97, 114, 32, 97, 118, 97, 105, 108, 32, 61,
+		32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 32, 45, 32,
+		116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 59, 10, 9,
+		9, 9, 118, 97, 114, 32, 108, 110, 48, 105, 32, 61, 32, 108, 110, 48,
+		63, 108, 110, 48, 46, 108, 110, 105, 58, 45, 49, 59, 10, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 114, 101, 102,
+		111, 114, 109, 97, 116, 32, 108, 110, 32, 34, 32, 43, 32, 108, 110, 48,
+		105, 32, 43, 32, 34, 32, 119, 105, 100, 32, 34, 32, 43, 32, 97, 118,
+		97, 105, 108, 32, 43, 32, 34, 58, 34, 32, 41, 59, 10, 9, 9, 9,
+		99, 111, 110, 115, 111, 108, 101, 46, 116, 114, 97, 99, 101, 40, 41, 59,
+		10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 84, 79, 68, 79, 58, 32,
+		115, 104, 111, 117, 108, 100, 32, 103, 101, 116, 32, 97, 110, 32, 105, 110,
+		100, 105, 99, 97, 116, 105, 111, 110, 32, 114, 101, 103, 97, 114, 100, 105,
+		110, 103, 32, 97, 116, 32, 119, 104, 105, 99, 104, 10, 9, 9, 47, 47,
+		32, 112, 111, 105, 110, 116, 32, 105, 116, 39, 115, 32, 115, 97, 102, 101,
+		32, 116, 111, 32, 97, 115, 115, 117, 109, 101, 32, 116, 104, 97, 116, 32,
+		110, 111, 32, 102, 117, 114, 116, 104, 101, 114, 32, 114, 101, 102, 111, 114,
+		109, 97, 116, 10, 9, 9, 47, 47, 32, 119, 111, 114, 107, 32, 105, 115,
+		32, 110, 101, 101, 100, 101, 100, 32, 97, 110, 100, 32, 115, 116, 111, 112,
+		32, 116, 104, 101, 114, 101, 46, 10, 9, 9, 102, 111, 114, 40, 118, 97,
+		114, 32, 108, 110, 32, 61, 32, 108, 110, 48, 59, 32, 108, 110, 32, 33,
+		61, 32, 110, 117, 108, 108, 59, 32, 41, 32, 123, 10, 9, 9, 9, 47,
+		47, 32, 109, 101, 114, 103, 101, 32, 116, 101, 120, 116, 32, 111, 110, 32,
+		116, 104, 101, 32, 115, 97, 109, 101, 32, 108, 105, 110, 101, 10, 9, 9,
+		9, 119, 104, 105, 108, 101, 40, 33, 108, 110, 46, 101, 111, 108, 32, 38,
+		38, 32, 108, 110, 46, 110, 101, 120, 116, 32, 33, 61, 32, 110, 117, 108,
+		108, 41, 32, 123, 10, 9, 9, 9, 9, 105, 102, 40, 108, 110, 46, 110,
+		101, 120, 116, 32, 61, 61, 32, 116, 104, 105, 115, 46, 108, 110, 101, 41,
+		32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 101,
+		32, 61, 32, 108, 110, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9,
+		9, 105, 102, 40, 108, 110, 46, 110, 101, 120, 116, 32, 61, 61, 32, 116,
+		104, 105, 115, 46, 108, 110, 48, 41, 32, 123, 10, 9, 9, 9, 9, 9,
+		116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 108, 110, 59, 10, 9,
+		9, 9, 9, 125, 10, 9, 9, 9, 9, 108, 110, 46, 106, 111, 105, 110,
+		40, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 47, 47, 32, 114,
+		101, 109, 111, 118, 101, 32, 101, 109, 112, 116, 121, 32, 108, 105, 110, 101,
+		115, 32, 98, 117, 116, 32, 107, 101, 101, 112, 32, 97, 110, 32, 101, 109,
+		112, 116, 121, 32, 108, 105, 110, 101, 32, 97, 116, 32, 116, 104, 101, 32,
+		101, 110, 100, 46, 10, 9, 9, 9, 118, 97, 114, 32, 110, 101, 120, 116,
+		32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59, 10, 9, 9, 9, 105,
+		102, 40, 108, 110, 46, 108, 101, 110, 40, 41, 32, 61, 61, 32, 48, 32,
+		38, 38, 32, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 61, 32, 108, 110,
 		41, 32, 123, 10, 9, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 34, 119, 114, 97, 112, 32, 32, 111, 102, 102, 32,
-		34, 32, 43, 32, 119, 111, 102, 102, 32, 43, 32, 34, 32, 108, 110, 34,
-		32, 43, 32, 108, 110, 46, 115, 116, 114, 40, 41, 41, 59, 10, 9, 9,
-		9, 9, 125, 10, 9, 9, 9, 9, 108, 110, 46, 115, 112, 108, 105, 116,
-		40, 119, 111, 102, 102, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9,
-		9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 108, 110, 101, 32, 61,
-		61, 32, 108, 110, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105,
-		115, 46, 108, 110, 101, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59,
-		10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101,
-		32, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9, 9,
-		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 110,
-		111, 32, 119, 114, 97, 112, 32, 108, 110, 32, 34, 32, 43, 32, 108, 110,
-		46, 115, 116, 114, 40, 41, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		125, 10, 9, 9, 47, 47, 32, 107, 101, 101, 112, 32, 116, 104, 101, 32,
-		101, 109, 112, 116, 121, 32, 108, 105, 110, 101, 32, 97, 116, 32, 116, 104,
-		101, 32, 101, 110, 100, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
-		108, 110, 101, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 116, 104,
-		105, 115, 46, 97, 100, 100, 108, 110, 40, 110, 101, 119, 32, 76, 105, 110,
-		101, 40, 48, 44, 32, 48, 44, 32, 34, 34, 44, 32, 102, 97, 108, 115,
-		101, 41, 41, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 105, 102,
-		32, 108, 110, 48, 32, 109, 111, 118, 101, 100, 32, 116, 111, 32, 116, 104,
-		101, 32, 101, 110, 100, 32, 109, 97, 114, 107, 101, 114, 44, 32, 98, 97,
-		99, 107, 117, 112, 32, 105, 102, 32, 119, 101, 32, 99, 97, 110, 46, 10,
-		9, 9, 105, 102, 40, 33, 108, 110, 48, 46, 110, 101, 120, 116, 32, 38,
-		38, 32, 108, 110, 48, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9,
-		9, 108, 110, 48, 32, 61, 32, 108, 110, 48, 46, 112, 114, 101, 118, 59,
-		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103,
-		41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 97, 102, 116, 101, 114, 32, 114, 101, 102, 111, 114, 109,
-		97, 116, 58, 34, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100,
-		117, 109, 112, 40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 108, 110, 48, 59, 10, 9, 125, 59, 10, 10, 9, 47,
-		47, 32, 97, 100, 100, 32, 97, 32, 115, 105, 110, 103, 108, 101, 32, 108,
-		105, 110, 101, 32, 111, 114, 32, 97, 32, 92, 110, 46, 10, 9, 116, 104,
-		105, 115, 46, 105, 110, 115, 49, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 116, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111, 108, 108,
-		41, 32, 123, 10, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99,
-		107, 40, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107,
-		105, 110, 115, 40, 116, 104, 105, 115, 46, 112, 48, 44, 32, 116, 46, 108,
-		101, 110, 103, 116, 104, 41, 59, 10, 9, 9, 118, 97, 114, 32, 120, 108,
-		110, 44, 32, 108, 110, 111, 102, 102, 59, 10, 9, 9, 91, 120, 108, 110,
-		44, 32, 108, 110, 111, 102, 102, 93, 32, 61, 32, 116, 104, 105, 115, 46,
-		115, 101, 101, 107, 40, 116, 104, 105, 115, 46, 112, 48, 41, 59, 10, 9,
-		9, 105, 102, 40, 33, 120, 108, 110, 41, 32, 123, 10, 9, 9, 9, 99,
-		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 76, 105, 110, 101,
-		115, 46, 105, 110, 115, 58, 32, 110, 111, 32, 108, 105, 110, 101, 32, 102,
-		111, 114, 32, 112, 48, 34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117,
-		114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 32, 61,
-		61, 32, 39, 92, 110, 39, 41, 32, 123, 10, 9, 9, 9, 120, 108, 110,
-		46, 115, 112, 108, 105, 116, 40, 108, 110, 111, 102, 102, 44, 32, 116, 114,
-		117, 101, 41, 59, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
-		108, 110, 101, 32, 61, 61, 61, 32, 120, 108, 110, 41, 32, 123, 10, 9,
-		9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 32, 120, 108,
-		110, 46, 110, 101, 120, 116, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125,
-		32, 101, 108, 115, 101, 32, 123, 10, 9, 9, 9, 120, 108, 110, 46, 105,
-		110, 115, 40, 116, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9,
-		125, 10, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 43, 61, 32, 116,
-		46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 116, 104, 105, 115, 46,
-		112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9,
-		116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 43, 61, 32, 116,
-		46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 105, 102, 40, 116, 32,
-		33, 61, 32, 39, 92, 110, 39, 41, 32, 123, 10, 9, 9, 9, 118, 97,
-		114, 32, 119, 111, 102, 102, 32, 61, 32, 116, 104, 105, 115, 46, 119, 114,
-		97, 112, 111, 102, 102, 40, 120, 108, 110, 46, 116, 120, 116, 41, 59, 10,
-		9, 9, 9, 105, 102, 40, 119, 111, 102, 102, 32, 61, 61, 32, 120, 108,
+		46, 108, 111, 103, 40, 34, 108, 105, 110, 101, 115, 58, 32, 114, 101, 102,
+		111, 114, 109, 97, 116, 32, 106, 111, 105, 110, 32, 98, 117, 103, 63, 34,
+		41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 105, 102, 40,
+		108, 110, 48, 32, 61, 61, 32, 108, 110, 41, 32, 123, 10, 9, 9, 9,
+		9, 9, 108, 110, 48, 32, 61, 32, 110, 101, 120, 116, 59, 10, 9, 9,
+		9, 9, 125, 10, 9, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
+		108, 110, 48, 32, 61, 61, 32, 108, 110, 41, 32, 123, 10, 9, 9, 9,
+		9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 110, 101, 120,
+		116, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 105, 102, 40,
+		116, 104, 105, 115, 46, 108, 110, 115, 32, 61, 61, 32, 108, 110, 41, 32,
+		123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 115, 32,
+		61, 32, 110, 101, 120, 116, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9,
+		9, 9, 108, 110, 46, 100, 101, 108, 108, 105, 110, 101, 40, 41, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 108, 110, 32, 61, 32, 110, 101, 120,
+		116, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 114, 101, 99, 111,
+		109, 112, 117, 116, 101, 32, 119, 114, 97, 112, 115, 44, 32, 111, 102, 102,
+		115, 101, 116, 115, 44, 32, 97, 110, 100, 32, 110, 117, 109, 98, 101, 114,
+		115, 46, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 32,
+		61, 32, 108, 110, 48, 59, 32, 108, 110, 32, 33, 61, 32, 110, 117, 108,
+		108, 59, 32, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 41,
+		32, 123, 10, 9, 9, 9, 105, 102, 40, 33, 108, 110, 46, 112, 114, 101,
+		118, 41, 32, 123, 10, 9, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32,
+		61, 32, 48, 59, 10, 9, 9, 9, 9, 108, 110, 46, 108, 110, 105, 32,
+		61, 32, 48, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123,
+		10, 9, 9, 9, 9, 108, 110, 46, 111, 102, 102, 32, 61, 32, 108, 110,
+		46, 112, 114, 101, 118, 46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 112,
+		114, 101, 118, 46, 108, 101, 110, 40, 41, 59, 10, 9, 9, 9, 9, 108,
+		110, 46, 108, 110, 105, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 46,
+		108, 110, 105, 32, 43, 32, 49, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 118, 97, 114, 32, 119, 111, 102, 102, 32, 61, 32, 116, 104, 105, 115,
+		46, 119, 114, 97, 112, 111, 102, 102, 40, 108, 110, 46, 116, 120, 116, 41,
+		59, 10, 9, 9, 9, 105, 102, 40, 119, 111, 102, 102, 32, 60, 32, 108,
 		110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10,
-		9, 9, 9, 9, 47, 47, 32, 105, 110, 115, 32, 119, 105, 116, 104, 105,
-		110, 32, 97, 32, 108, 105, 110, 101, 44, 32, 100, 111, 110, 39, 116, 32,
-		114, 101, 102, 111, 114, 109, 97, 116, 59, 32, 106, 117, 115, 116, 32, 114,
-		101, 100, 114, 97, 119, 32, 105, 116, 46, 10, 9, 9, 9, 9, 120, 108,
-		110, 46, 114, 101, 110, 117, 109, 98, 101, 114, 40, 41, 59, 10, 9, 9,
-		9, 9, 116, 104, 105, 115, 46, 102, 114, 108, 110, 105, 110, 115, 100, 101,
-		108, 40, 120, 108, 110, 44, 32, 43, 116, 46, 108, 101, 110, 103, 116, 104,
-		41, 59, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 120, 108, 110, 32, 61, 32,
-		116, 104, 105, 115, 46, 114, 101, 102, 111, 114, 109, 97, 116, 40, 120, 108,
-		110, 41, 59, 10, 9, 9, 105, 102, 40, 33, 100, 111, 110, 116, 115, 99,
-		114, 111, 108, 108, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		109, 97, 121, 115, 99, 114, 111, 108, 108, 105, 110, 115, 40, 120, 108, 110,
-		41, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 114, 101,
-		100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 125, 59, 10,
-		10, 9, 47, 47, 32, 97, 100, 100, 32, 97, 114, 98, 105, 116, 114, 97,
-		114, 121, 32, 116, 101, 120, 116, 32, 97, 116, 32, 112, 48, 10, 9, 116,
-		104, 105, 115, 46, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 115, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111, 108, 108,
-		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 108, 110, 115, 32, 61, 32,
-		115, 46, 115, 112, 108, 105, 116, 40, 39, 92, 110, 39, 41, 59, 10, 9,
-		9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32,
-		105, 32, 60, 32, 108, 110, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32,
-		105, 43, 43, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 108, 110, 115,
-		91, 105, 93, 46, 108, 101, 110, 103, 116, 104, 32, 62, 32, 48, 41, 32,
-		123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 49, 40,
-		108, 110, 115, 91, 105, 93, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111,
-		108, 108, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40,
-		105, 32, 60, 32, 108, 110, 115, 46, 108, 101, 110, 103, 116, 104, 45, 49,
-		41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 105, 110, 115,
-		49, 40, 39, 92, 110, 39, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111,
-		108, 108, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 125,
-		59, 10, 10, 9, 47, 47, 32, 100, 101, 108, 32, 112, 48, 58, 112, 49,
-		32, 111, 114, 32, 108, 97, 115, 116, 32, 99, 104, 97, 114, 32, 105, 102,
-		32, 112, 48, 32, 61, 61, 32, 112, 49, 10, 9, 116, 104, 105, 115, 46,
-		100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 100,
-		111, 110, 116, 115, 99, 114, 111, 108, 108, 41, 32, 123, 10, 9, 9, 105,
-		102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 61, 32, 116, 104, 105,
-		115, 46, 110, 114, 117, 110, 101, 115, 32, 124, 124, 32, 116, 104, 105, 115,
-		46, 112, 49, 32, 60, 61, 32, 116, 104, 105, 115, 46, 112, 48, 41, 32,
-		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125,
+		9, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123,
+		10, 9, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 34, 119, 114, 97, 112, 32, 32, 111, 102, 102, 32, 34, 32, 43,
+		32, 119, 111, 102, 102, 32, 43, 32, 34, 32, 108, 110, 34, 32, 43, 32,
+		108, 110, 46, 115, 116, 114, 40, 41, 41, 59, 10, 9, 9, 9, 9, 125,
+		10, 9, 9, 9, 9, 108, 110, 46, 115, 112, 108, 105, 116, 40, 119, 111,
+		102, 102, 44, 32, 102, 97, 108, 115, 101, 41, 59, 10, 9, 9, 9, 9,
+		105, 102, 40, 116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 61, 32, 108,
+		110, 41, 32, 123, 10, 9, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108,
+		110, 101, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59, 10, 9, 9,
+		9, 9, 125, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 105, 102,
+		40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9, 9, 9, 9, 99,
+		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 110, 111, 32, 119,
+		114, 97, 112, 32, 108, 110, 32, 34, 32, 43, 32, 108, 110, 46, 115, 116,
+		114, 40, 41, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9,
+		9, 47, 47, 32, 107, 101, 101, 112, 32, 116, 104, 101, 32, 101, 109, 112,
+		116, 121, 32, 108, 105, 110, 101, 32, 97, 116, 32, 116, 104, 101, 32, 101,
+		110, 100, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 108, 110, 101,
+		46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		97, 100, 100, 108, 110, 40, 110, 101, 119, 32, 76, 105, 110, 101, 40, 48,
+		44, 32, 48, 44, 32, 34, 34, 44, 32, 102, 97, 108, 115, 101, 41, 41,
+		59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 105, 102, 32, 108, 110,
+		48, 32, 109, 111, 118, 101, 100, 32, 116, 111, 32, 116, 104, 101, 32, 101,
+		110, 100, 32, 109, 97, 114, 107, 101, 114, 44, 32, 98, 97, 99, 107, 117,
+		112, 32, 105, 102, 32, 119, 101, 32, 99, 97, 110, 46, 10, 9, 9, 105,
+		102, 40, 33, 108, 110, 48, 46, 110, 101, 120, 116, 32, 38, 38, 32, 108,
+		110, 48, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9, 9, 108, 110,
+		48, 32, 61, 32, 108, 110, 48, 46, 112, 114, 101, 118, 59, 10, 9, 9,
+		125, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123,
+		10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 97, 102, 116, 101, 114, 32, 114, 101, 102, 111, 114, 109, 97, 116, 58,
+		34, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 117, 109, 112,
+		40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110,
+		32, 108, 110, 48, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 97,
+		100, 100, 32, 97, 32, 115, 105, 110, 103, 108, 101, 32, 108, 105, 110, 101,
+		32, 111, 114, 32, 97, 32, 92, 110, 46, 10, 9, 116, 104, 105, 115, 46,
+		105, 110, 115, 49, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		116, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111, 108, 108, 41, 32, 123,
 		10, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 40, 41,
-		59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62,
-		32, 48, 32, 38, 38, 32, 116, 104, 105, 115, 46, 112, 48, 32, 61, 61,
-		32, 116, 104, 105, 115, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 112, 48, 45, 45, 59, 10, 9, 9, 125, 10, 9, 9,
-		116, 104, 105, 115, 46, 109, 97, 114, 107, 100, 101, 108, 40, 116, 104, 105,
-		115, 46, 112, 48, 44, 32, 116, 104, 105, 115, 46, 112, 49, 41, 59, 10,
-		9, 9, 118, 97, 114, 32, 120, 108, 110, 44, 32, 108, 110, 111, 102, 102,
-		59, 10, 9, 9, 91, 120, 108, 110, 44, 32, 108, 110, 111, 102, 102, 93,
-		32, 61, 32, 116, 104, 105, 115, 46, 115, 101, 101, 107, 40, 116, 104, 105,
-		115, 46, 112, 48, 41, 59, 10, 9, 9, 105, 102, 40, 33, 120, 108, 110,
-		41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 108, 105, 110, 101, 115, 58, 32, 100, 101, 108, 58, 32,
-		110, 111, 32, 108, 105, 110, 101, 34, 41, 59, 10, 9, 9, 9, 114, 101,
-		116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		110, 100, 101, 108, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 32, 45,
-		32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 118, 97, 114, 32,
-		116, 111, 116, 32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 120,
-		108, 110, 48, 32, 61, 32, 120, 108, 110, 59, 10, 9, 9, 102, 111, 114,
-		40, 59, 32, 116, 111, 116, 32, 60, 32, 110, 100, 101, 108, 32, 38, 38,
-		32, 120, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 120, 108,
-		110, 32, 61, 32, 120, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10,
-		9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 32, 38, 38, 32,
-		48, 41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101,
-		46, 108, 111, 103, 40, 34, 108, 105, 110, 101, 115, 32, 100, 101, 108, 32,
-		34, 32, 43, 32, 110, 100, 101, 108, 32, 43, 32, 34, 32, 108, 111, 102,
-		102, 32, 34, 32, 43, 32, 108, 110, 111, 102, 102, 32, 43, 32, 34, 32,
-		34, 32, 43, 32, 120, 108, 110, 46, 115, 116, 114, 40, 41, 41, 59, 10,
-		9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 110, 100, 32, 61,
-		32, 120, 108, 110, 46, 100, 101, 108, 40, 108, 110, 111, 102, 102, 44, 32,
-		110, 100, 101, 108, 45, 116, 111, 116, 41, 59, 10, 9, 9, 9, 105, 102,
-		40, 116, 111, 116, 43, 110, 100, 32, 60, 32, 110, 100, 101, 108, 32, 38,
-		38, 32, 120, 108, 110, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9,
-		9, 120, 108, 110, 46, 101, 111, 108, 32, 61, 32, 102, 97, 108, 115, 101,
-		59, 10, 9, 9, 9, 9, 110, 100, 43, 43, 59, 10, 9, 9, 9, 125,
-		10, 9, 9, 9, 105, 102, 40, 116, 111, 116, 32, 61, 61, 32, 48, 32,
-		38, 38, 32, 110, 100, 32, 61, 61, 32, 110, 100, 101, 108, 32, 38, 38,
-		32, 120, 108, 110, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 9,
-		47, 47, 32, 100, 101, 108, 32, 119, 105, 116, 104, 105, 110, 32, 97, 32,
-		108, 105, 110, 101, 59, 32, 100, 111, 110, 39, 116, 32, 114, 101, 102, 111,
-		114, 109, 97, 116, 59, 32, 114, 101, 100, 114, 97, 119, 32, 105, 116, 46,
-		10, 9, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32,
-		123, 10, 9, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108,
-		111, 103, 40, 34, 115, 105, 110, 103, 108, 101, 32, 108, 105, 110, 101, 32,
-		100, 101, 108, 34, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9,
-		9, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 45, 61, 32,
-		110, 100, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32,
-		45, 61, 32, 110, 100, 59, 10, 9, 9, 9, 9, 120, 108, 110, 46, 114,
+		59, 10, 9, 9, 116, 104, 105, 115, 46, 109, 97, 114, 107, 105, 110, 115,
+		40, 116, 104, 105, 115, 46, 112, 48, 44, 32, 116, 46, 108, 101, 110, 103,
+		116, 104, 41, 59, 10, 9, 9, 118, 97, 114, 32, 120, 108, 110, 44, 32,
+		108, 110, 111, 102, 102, 59, 10, 9, 9, 91, 120, 108, 110, 44, 32, 108,
+		110, 111, 102, 102, 93, 32, 61, 32, 116, 104, 105, 115, 46, 115, 101, 101,
+		107, 40, 116, 104, 105, 115, 46, 112, 48, 41, 59, 10, 9, 9, 105, 102,
+		40, 33, 120, 108, 110, 41, 32, 123, 10, 9, 9, 9, 99, 111, 110, 115,
+		111, 108, 101, 46, 108, 111, 103, 40, 34, 76, 105, 110, 101, 115, 46, 105,
+		110, 115, 58, 32, 110, 111, 32, 108, 105, 110, 101, 32, 102, 111, 114, 32,
+		112, 48, 34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59,
+		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 32, 61, 61, 32, 39,
+		92, 110, 39, 41, 32, 123, 10, 9, 9, 9, 120, 108, 110, 46, 115, 112,
+		108, 105, 116, 40, 108, 110, 111, 102, 102, 44, 32, 116, 114, 117, 101, 41,
+		59, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 108, 110, 101,
+		32, 61, 61, 61, 32, 120, 108, 110, 41, 32, 123, 10, 9, 9, 9, 9,
+		116, 104, 105, 115, 46, 108, 110, 101, 32, 61, 32, 120, 108, 110, 46, 110,
+		101, 120, 116, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 32, 101, 108,
+		115, 101, 32, 123, 10, 9, 9, 9, 120, 108, 110, 46, 105, 110, 115, 40,
+		116, 44, 32, 108, 110, 111, 102, 102, 41, 59, 10, 9, 9, 125, 10, 9,
+		9, 116, 104, 105, 115, 46, 112, 48, 32, 43, 61, 32, 116, 46, 108, 101,
+		110, 103, 116, 104, 59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32,
+		61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 116, 104, 105,
+		115, 46, 110, 114, 117, 110, 101, 115, 32, 43, 61, 32, 116, 46, 108, 101,
+		110, 103, 116, 104, 59, 10, 9, 9, 105, 102, 40, 116, 32, 33, 61, 32,
+		39, 92, 110, 39, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 119,
+		111, 102, 102, 32, 61, 32, 116, 104, 105, 115, 46, 119, 114, 97, 112, 111,
+		102, 102, 40, 120, 108, 110, 46, 116, 120, 116, 41, 59, 10, 9, 9, 9,
+		105, 102, 40, 119, 111, 102, 102, 32, 61, 61, 32, 120, 108, 110, 46, 116,
+		120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9,
+		9, 47, 47, 32, 105, 110, 115, 32, 119, 105, 116, 104, 105, 110, 32, 97,
+		32, 108, 105, 110, 101, 44, 32, 100, 111, 110, 39, 116, 32, 114, 101, 102,
+		111, 114, 109, 97, 116, 59, 32, 106, 117, 115, 116, 32, 114, 101, 100, 114,
+		97, 119, 32, 105, 116, 46, 10, 9, 9, 9, 9, 120, 108, 110, 46, 114,
 		101, 110, 117, 109, 98, 101, 114, 40, 41, 59, 10, 9, 9, 9, 9, 116,
 		104, 105, 115, 46, 102, 114, 108, 110, 105, 110, 115, 100, 101, 108, 40, 120,
-		108, 110, 44, 32, 45, 110, 100, 41, 59, 10, 9, 9, 9, 9, 114, 101,
-		116, 117, 114, 110, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 111,
-		116, 32, 43, 61, 32, 110, 100, 59, 10, 9, 9, 9, 108, 110, 111, 102,
-		102, 32, 61, 32, 48, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114,
-		32, 109, 105, 103, 104, 116, 115, 99, 114, 111, 108, 108, 32, 61, 32, 40,
-		116, 104, 105, 115, 46, 112, 49, 32, 62, 61, 32, 120, 108, 110, 48, 46,
-		111, 102, 102, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 110, 114, 117,
-		110, 101, 115, 32, 45, 61, 32, 116, 111, 116, 59, 10, 9, 9, 116, 104,
-		105, 115, 46, 112, 49, 32, 45, 61, 32, 116, 111, 116, 59, 10, 9, 9,
-		105, 102, 40, 120, 108, 110, 48, 46, 112, 114, 101, 118, 41, 32, 123, 10,
-		9, 9, 9, 120, 108, 110, 48, 32, 61, 32, 120, 108, 110, 48, 46, 112,
-		114, 101, 118, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46,
-		114, 101, 102, 111, 114, 109, 97, 116, 40, 120, 108, 110, 48, 41, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120,
-		116, 40, 41, 59, 10, 9, 9, 105, 102, 40, 33, 100, 111, 110, 116, 115,
-		99, 114, 111, 108, 108, 32, 38, 38, 32, 109, 105, 103, 104, 116, 115, 99,
-		114, 111, 108, 108, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		109, 97, 121, 115, 99, 114, 111, 108, 108, 100, 101, 108, 40, 120, 108, 110,
-		48, 41, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104,
-		105, 115, 46, 103, 101, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 112, 48, 44, 32, 112, 49, 41, 32, 123, 10, 9, 9, 105, 102,
-		40, 112, 48, 32, 61, 61, 32, 112, 49, 32, 124, 124, 32, 112, 48, 32,
-		62, 61, 32, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 124,
-		124, 32, 112, 49, 32, 60, 32, 112, 48, 32, 124, 124, 32, 112, 49, 32,
-		60, 61, 32, 48, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114,
-		110, 32, 34, 34, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		108, 110, 48, 44, 32, 108, 110, 111, 102, 102, 59, 10, 9, 9, 91, 108,
-		110, 48, 44, 32, 108, 110, 111, 102, 102, 93, 32, 61, 32, 116, 104, 105,
-		115, 46, 115, 101, 101, 107, 40, 112, 48, 41, 59, 10, 9, 9, 105, 102,
-		40, 108, 110, 48, 32, 61, 61, 32, 110, 117, 108, 108, 41, 32, 123, 10,
-		9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 34, 34, 59, 10, 9, 9,
-		125, 10, 9, 9, 118, 97, 114, 32, 108, 110, 32, 61, 32, 108, 110, 48,
-		59, 10, 9, 9, 118, 97, 114, 32, 110, 103, 101, 116, 32, 61, 32, 112,
-		49, 32, 45, 32, 112, 48, 59, 10, 9, 9, 118, 97, 114, 32, 111, 102,
-		102, 32, 61, 32, 112, 48, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59,
-		10, 9, 9, 118, 97, 114, 32, 116, 111, 116, 32, 61, 32, 48, 59, 10,
+		108, 110, 44, 32, 43, 116, 46, 108, 101, 110, 103, 116, 104, 41, 59, 10,
+		9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 125, 10, 9, 9, 120, 108, 110, 32, 61, 32, 116, 104, 105,
+		115, 46, 114, 101, 102, 111, 114, 109, 97, 116, 40, 120, 108, 110, 41, 59,
+		10, 9, 9, 105, 102, 40, 33, 100, 111, 110, 116, 115, 99, 114, 111, 108,
+		108, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 97, 121,
+		115, 99, 114, 111, 108, 108, 105, 110, 115, 40, 120, 108, 110, 41, 59, 10,
+		9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97,
+		119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 125, 59, 10, 10, 9, 47,
+		47, 32, 97, 100, 100, 32, 97, 114, 98, 105, 116, 114, 97, 114, 121, 32,
+		116, 101, 120, 116, 32, 97, 116, 32, 112, 48, 10, 9, 116, 104, 105, 115,
+		46, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		115, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111, 108, 108, 41, 32, 123,
+		10, 9, 9, 118, 97, 114, 32, 108, 110, 115, 32, 61, 32, 115, 46, 115,
+		112, 108, 105, 116, 40, 39, 92, 110, 39, 41, 59, 10, 9, 9, 102, 111,
+		114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60,
+		32, 108, 110, 115, 46, 108, 101, 110, 103, 116, 104, 59, 32, 105, 43, 43,
+		41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 108, 110, 115, 91, 105, 93,
+		46, 108, 101, 110, 103, 116, 104, 32, 62, 32, 48, 41, 32, 123, 10, 9,
+		9, 9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 49, 40, 108, 110, 115,
+		91, 105, 93, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111, 108, 108, 41,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 105, 32, 60,
+		32, 108, 110, 115, 46, 108, 101, 110, 103, 116, 104, 45, 49, 41, 32, 123,
+		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 105, 110, 115, 49, 40, 39,
+		92, 110, 39, 44, 32, 100, 111, 110, 116, 115, 99, 114, 111, 108, 108, 41,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10,
+		9, 47, 47, 32, 100, 101, 108, 32, 112, 48, 58, 112, 49, 32, 111, 114,
+		32, 108, 97, 115, 116, 32, 99, 104, 97, 114, 32, 105, 102, 32, 112, 48,
+		32, 61, 61, 32, 112, 49, 10, 9, 116, 104, 105, 115, 46, 100, 101, 108,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 100, 111, 110, 116,
+		115, 99, 114, 111, 108, 108, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116,
+		104, 105, 115, 46, 112, 48, 32, 62, 61, 32, 116, 104, 105, 115, 46, 110,
+		114, 117, 110, 101, 115, 32, 124, 124, 32, 116, 104, 105, 115, 46, 112, 49,
+		32, 60, 61, 32, 116, 104, 105, 115, 46, 112, 48, 41, 32, 123, 10, 9,
+		9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9,
+		116, 104, 105, 115, 46, 117, 110, 116, 105, 99, 107, 40, 41, 59, 10, 9,
+		9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32, 48, 32,
+		38, 38, 32, 116, 104, 105, 115, 46, 112, 48, 32, 61, 61, 32, 116, 104,
+		105, 115, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 112, 48, 45, 45, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105,
+		115, 46, 109, 97, 114, 107, 100, 101, 108, 40, 116, 104, 105, 115, 46, 112,
+		48, 44, 32, 116, 104, 105, 115, 46, 112, 49, 41, 59, 10, 9, 9, 118,
+		97, 114, 32, 120, 108, 110, 44, 32, 108, 110, 111, 102, 102, 59, 10, 9,
+		9, 91, 120, 108, 110, 44, 32, 108, 110, 111, 102, 102, 93, 32, 61, 32,
+		116, 104, 105, 115, 46, 115, 101, 101, 107, 40, 116, 104, 105, 115, 46, 112,
+		48, 41, 59, 10, 9, 9, 105, 102, 40, 33, 120, 108, 110, 41, 32, 123,
+		10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 108, 105, 110, 101, 115, 58, 32, 100, 101, 108, 58, 32, 110, 111, 32,
+		108, 105, 110, 101, 34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114,
+		110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 110, 100, 101,
+		108, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 32, 45, 32, 116, 104,
+		105, 115, 46, 112, 48, 59, 10, 9, 9, 118, 97, 114, 32, 116, 111, 116,
+		32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 120, 108, 110, 48,
+		32, 61, 32, 120, 108, 110, 59, 10, 9, 9, 102, 111, 114, 40, 59, 32,
+		116, 111, 116, 32, 60, 32, 110, 100, 101, 108, 32, 38, 38, 32, 120, 108,
+		110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 120, 108, 110, 32, 61,
+		32, 120, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9,
+		105, 102, 40, 116, 100, 101, 98, 117, 103, 32, 38, 38, 32, 48, 41, 32,
+		123, 10, 9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
+		103, 40, 34, 108, 105, 110, 101, 115, 32, 100, 101, 108, 32, 34, 32, 43,
+		32, 110, 100, 101, 108, 32, 43, 32, 34, 32, 108, 111, 102, 102, 32, 34,
+		32, 43, 32, 108, 110, 111, 102, 102, 32, 43, 32, 34, 32, 34, 32, 43,
+		32, 120, 108, 110, 46, 115, 116, 114, 40, 41, 41, 59, 10, 9, 9, 9,
+		125, 10, 9, 9, 9, 118, 97, 114, 32, 110, 100, 32, 61, 32, 120, 108,
+		110, 46, 100, 101, 108, 40, 108, 110, 111, 102, 102, 44, 32, 110, 100, 101,
+		108, 45, 116, 111, 116, 41, 59, 10, 9, 9, 9, 105, 102, 40, 116, 111,
+		116, 43, 110, 100, 32, 60, 32, 110, 100, 101, 108, 32, 38, 38, 32, 120,
+		108, 110, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 9, 120, 108,
+		110, 46, 101, 111, 108, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9,
+		9, 9, 9, 110, 100, 43, 43, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 105, 102, 40, 116, 111, 116, 32, 61, 61, 32, 48, 32, 38, 38, 32,
+		110, 100, 32, 61, 61, 32, 110, 100, 101, 108, 32, 38, 38, 32, 120, 108,
+		110, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9, 9, 47, 47, 32,
+		100, 101, 108, 32, 119, 105, 116, 104, 105, 110, 32, 97, 32, 108, 105, 110,
+		101, 59, 32, 100, 111, 110, 39, 116, 32, 114, 101, 102, 111, 114, 109, 97,
+		116, 59, 32, 114, 101, 100, 114, 97, 119, 32, 105, 116, 46, 10, 9, 9,
+		9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9,
+		9, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 115, 105, 110, 103, 108, 101, 32, 108, 105, 110, 101, 32, 100, 101, 108,
+		34, 41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 116, 104,
+		105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 45, 61, 32, 110, 100, 59,
+		10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 45, 61, 32,
+		110, 100, 59, 10, 9, 9, 9, 9, 120, 108, 110, 46, 114, 101, 110, 117,
+		109, 98, 101, 114, 40, 41, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115,
+		46, 102, 114, 108, 110, 105, 110, 115, 100, 101, 108, 40, 120, 108, 110, 44,
+		32, 45, 110, 100, 41, 59, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114,
+		110, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 111, 116, 32, 43,
+		61, 32, 110, 100, 59, 10, 9, 9, 9, 108, 110, 111, 102, 102, 32, 61,
+		32, 48, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 109, 105,
+		103, 104, 116, 115, 99, 114, 111, 108, 108, 32, 61, 32, 40, 116, 104, 105,
+		115, 46, 112, 49, 32, 62, 61, 32, 120, 108, 110, 48, 46, 111, 102, 102,
+		41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115,
+		32, 45, 61, 32, 116, 111, 116, 59, 10, 9, 9, 116, 104, 105, 115, 46,
+		112, 49, 32, 45, 61, 32, 116, 111, 116, 59, 10, 9, 9, 105, 102, 40,
+		120, 108, 110, 48, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9, 9,
+		120, 108, 110, 48, 32, 61, 32, 120, 108, 110, 48, 46, 112, 114, 101, 118,
+		59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 114, 101, 102,
+		111, 114, 109, 97, 116, 40, 120, 108, 110, 48, 41, 59, 10, 9, 9, 116,
+		104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41,
+		59, 10, 9, 9, 105, 102, 40, 33, 100, 111, 110, 116, 115, 99, 114, 111,
+		108, 108, 32, 38, 38, 32, 109, 105, 103, 104, 116, 115, 99, 114, 111, 108,
+		108, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 97, 121,
+		115, 99, 114, 111, 108, 108, 100, 101, 108, 40, 120, 108, 110, 48, 41, 59,
+		10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
+		103, 101, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112,
+		48, 44, 32, 112, 49, 41, 32, 123, 10, 9, 9, 105, 102, 40, 112, 48,
+		32, 61, 61, 32, 112, 49, 32, 124, 124, 32, 112, 48, 32, 62, 61, 32,
+		116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 32, 124, 124, 32, 112,
+		49, 32, 60, 32, 112, 48, 32, 124, 124, 32, 112, 49, 32, 60, 61, 32,
+		48, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 34,
+		34, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 108, 110, 48,
+		44, 32, 108, 110, 111, 102, 102, 59, 10, 9, 9, 91, 108, 110, 48, 44,
+		32, 108, 110, 111, 102, 102, 93, 32, 61, 32, 116, 104, 105, 115, 46, 115,
+		101, 101, 107, 40, 112, 48, 41, 59, 10, 9, 9, 105, 102, 40, 108, 110,
+		48, 32, 61, 61, 32, 110, 117, 108, 108, 41, 32, 123, 10, 9, 9, 9,
+		114, 101, 116, 117, 114, 110, 32, 34, 34, 59, 10, 9, 9, 125, 10, 9,
+		9, 118, 97, 114, 32, 108, 110, 32, 61, 32, 108, 110, 48, 59, 10, 9,
+		9, 118, 97, 114, 32, 110, 103, 101, 116, 32, 61, 32, 112, 49, 32, 45,
+		32, 112, 48, 59, 10, 9, 9, 118, 97, 114, 32, 111, 102, 102, 32, 61,
+		32, 112, 48, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9,
+		118, 97, 114, 32, 116, 111, 116, 32, 61, 32, 48, 59, 10, 9, 9, 118,
+		97, 114, 32, 116, 120, 116, 32, 61, 32, 34, 34, 59, 10, 9, 9, 100,
+		111, 123, 10, 9, 9, 9, 118, 97, 114, 32, 110, 103, 32, 61, 32, 110,
+		103, 101, 116, 45, 116, 111, 116, 59, 10, 9, 9, 9, 105, 102, 40, 111,
+		102, 102, 43, 110, 103, 32, 62, 32, 108, 110, 46, 116, 120, 116, 46, 108,
+		101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 110, 103, 32,
+		61, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 32,
+		45, 32, 111, 102, 102, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116,
+		120, 116, 32, 43, 61, 32, 108, 110, 46, 116, 120, 116, 46, 115, 108, 105,
+		99, 101, 40, 111, 102, 102, 44, 32, 111, 102, 102, 43, 110, 103, 41, 59,
+		10, 9, 9, 9, 116, 111, 116, 32, 43, 61, 32, 110, 103, 59, 10, 9,
+		9, 9, 105, 102, 40, 116, 111, 116, 32, 60, 32, 110, 103, 101, 116, 32,
+		38, 38, 32, 108, 110, 46, 101, 111, 108, 41, 123, 10, 9, 9, 9, 9,
+		116, 120, 116, 32, 43, 61, 32, 34, 92, 110, 34, 59, 10, 9, 9, 9,
+		9, 116, 111, 116, 43, 43, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
+		108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59, 10, 9, 9,
+		9, 111, 102, 102, 32, 61, 32, 48, 59, 10, 9, 9, 125, 119, 104, 105,
+		108, 101, 40, 116, 111, 116, 32, 60, 32, 110, 103, 101, 116, 32, 38, 38,
+		32, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 41, 59, 10, 9, 9,
+		114, 101, 116, 117, 114, 110, 32, 116, 120, 116, 59, 10, 9, 125, 59, 10,
+		10, 9, 47, 47, 32, 114, 101, 116, 117, 114, 110, 115, 32, 91, 119, 111,
+		114, 100, 44, 32, 119, 112, 48, 44, 32, 119, 112, 49, 93, 10, 9, 116,
+		104, 105, 115, 46, 103, 101, 116, 119, 111, 114, 100, 32, 61, 32, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 112, 111, 115, 44, 32, 108, 111, 110, 103,
+		41, 32, 123, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32, 60, 32, 48,
+		41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 91, 34,
+		34, 44, 32, 48, 44, 32, 48, 93, 59, 10, 9, 9, 125, 10, 9, 9,
+		105, 102, 40, 112, 111, 115, 32, 62, 61, 32, 116, 104, 105, 115, 46, 110,
+		114, 117, 110, 101, 115, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117,
+		114, 110, 32, 91, 34, 34, 44, 32, 116, 104, 105, 115, 46, 110, 114, 117,
+		110, 101, 115, 44, 32, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115,
+		93, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 105, 115, 99,
+		104, 97, 114, 32, 61, 32, 105, 115, 119, 111, 114, 100, 99, 104, 97, 114,
+		59, 10, 9, 9, 105, 102, 40, 108, 111, 110, 103, 41, 32, 123, 10, 9,
+		9, 9, 105, 115, 99, 104, 97, 114, 32, 61, 32, 105, 115, 108, 111, 110,
+		103, 119, 111, 114, 100, 99, 104, 97, 114, 59, 10, 9, 9, 125, 10, 9,
+		9, 118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102, 102, 59, 10,
+		9, 9, 91, 108, 110, 44, 32, 108, 110, 111, 102, 102, 93, 32, 61, 32,
+		116, 104, 105, 115, 46, 115, 101, 101, 107, 40, 112, 111, 115, 41, 59, 10,
+		9, 9, 105, 102, 40, 108, 110, 32, 61, 61, 32, 110, 117, 108, 108, 41,
+		32, 123, 10, 9, 9, 9, 108, 110, 32, 61, 32, 116, 104, 105, 115, 46,
+		108, 110, 101, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 108, 110,
+		32, 61, 61, 32, 116, 104, 105, 115, 46, 108, 110, 101, 32, 38, 38, 32,
+		108, 110, 46, 112, 114, 101, 118, 32, 33, 61, 32, 110, 117, 108, 108, 32,
+		38, 38, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104,
+		32, 61, 61, 32, 48, 41, 32, 123, 10, 9, 9, 9, 108, 110, 32, 61,
+		32, 108, 110, 46, 112, 114, 101, 118, 59, 10, 9, 9, 9, 112, 111, 115,
+		32, 61, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9, 125, 10, 9,
+		9, 118, 97, 114, 32, 101, 112, 111, 115, 32, 61, 32, 112, 111, 115, 59,
+		10, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 112, 111, 115, 32,
+		45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9, 105, 102, 40, 112,
+		48, 32, 61, 61, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103,
+		116, 104, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 116, 120, 116, 32,
+		61, 32, 108, 110, 46, 116, 120, 116, 59, 10, 9, 9, 9, 118, 97, 114,
+		32, 111, 102, 102, 32, 61, 32, 108, 110, 46, 111, 102, 102, 10, 9, 9,
+		9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 112, 32, 61, 32, 108,
+		110, 46, 112, 114, 101, 118, 59, 32, 108, 110, 112, 32, 38, 38, 32, 33,
+		108, 110, 112, 46, 101, 111, 108, 59, 32, 108, 110, 112, 32, 61, 32, 108,
+		110, 112, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9, 9, 9, 116,
+		120, 116, 32, 61, 32, 108, 110, 112, 46, 116, 120, 116, 32, 43, 32, 116,
+		120, 116, 59, 10, 9, 9, 9, 9, 111, 102, 102, 32, 61, 32, 108, 110,
+		112, 46, 111, 102, 102, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105,
+		102, 40, 33, 108, 110, 46, 101, 111, 108, 41, 32, 123, 10, 9, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 44, 32, 111, 102,
+		102, 44, 32, 111, 102, 102, 43, 116, 120, 116, 46, 108, 101, 110, 103, 116,
+		104, 93, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 114, 101, 116, 117,
+		114, 110, 32, 91, 116, 120, 116, 43, 34, 92, 110, 34, 44, 32, 111, 102,
+		102, 44, 32, 111, 102, 102, 43, 116, 120, 116, 46, 108, 101, 110, 103, 116,
+		104, 43, 49, 93, 59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 104,
+		101, 117, 114, 105, 115, 116, 105, 99, 58, 32, 105, 102, 32, 99, 108, 105,
+		99, 107, 32, 97, 116, 32, 116, 104, 101, 32, 114, 105, 103, 104, 116, 32,
+		111, 102, 32, 108, 112, 97, 114, 101, 110, 32, 97, 110, 100, 32, 110, 111,
+		116, 10, 9, 9, 47, 47, 32, 97, 116, 32, 114, 112, 97, 114, 101, 110,
+		44, 32, 117, 115, 101, 32, 116, 104, 101, 32, 108, 112, 97, 114, 101, 110,
+		46, 10, 9, 9, 105, 102, 40, 112, 48, 32, 62, 32, 48, 32, 38, 38,
+		32, 33, 105, 115, 114, 112, 97, 114, 101, 110, 40, 108, 110, 46, 116, 120,
+		116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 48, 41, 41, 32, 38, 38,
+		10, 9, 9, 32, 32, 32, 105, 115, 108, 112, 97, 114, 101, 110, 40, 108,
+		110, 46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 48, 45,
+		49, 41, 41, 41, 123, 10, 9, 9, 9, 112, 111, 115, 45, 45, 59, 10,
+		9, 9, 9, 112, 48, 45, 45, 59, 10, 9, 9, 125, 10, 9, 9, 118,
+		97, 114, 32, 112, 49, 32, 61, 32, 112, 48, 59, 10, 9, 9, 118, 97,
+		114, 32, 99, 32, 61, 32, 108, 110, 46, 116, 120, 116, 46, 99, 104, 97,
+		114, 65, 116, 40, 112, 48, 41, 59, 10, 9, 9, 105, 102, 40, 105, 115,
+		108, 112, 97, 114, 101, 110, 40, 99, 41, 41, 123, 10, 9, 9, 9, 112,
+		111, 115, 43, 43, 59, 10, 9, 9, 9, 118, 97, 114, 32, 114, 99, 32,
+		61, 32, 114, 112, 97, 114, 101, 110, 40, 99, 41, 59, 10, 9, 9, 9,
+		118, 97, 114, 32, 116, 120, 116, 32, 61, 32, 34, 34, 59, 10, 9, 9,
+		9, 118, 97, 114, 32, 110, 32, 61, 32, 49, 59, 10, 9, 9, 9, 112,
+		49, 43, 43, 59, 10, 9, 9, 9, 101, 112, 111, 115, 43, 43, 59, 10,
+		9, 9, 9, 100, 111, 32, 123, 10, 9, 9, 9, 9, 118, 97, 114, 32,
+		120, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 102, 111, 114, 40, 59,
+		32, 112, 49, 32, 60, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110,
+		103, 116, 104, 59, 32, 112, 49, 43, 43, 44, 32, 101, 112, 111, 115, 43,
+		43, 41, 32, 123, 10, 9, 9, 9, 9, 9, 120, 32, 61, 32, 108, 110,
+		46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 49, 41, 59,
+		10, 9, 9, 9, 9, 9, 105, 102, 40, 120, 32, 61, 61, 32, 114, 99,
+		41, 10, 9, 9, 9, 9, 9, 9, 110, 45, 45, 59, 10, 9, 9, 9,
+		9, 9, 105, 102, 40, 120, 32, 61, 61, 32, 99, 41, 10, 9, 9, 9,
+		9, 9, 9, 110, 43, 43, 59, 10, 9, 9, 9, 9, 9, 105, 102, 40,
+		110, 32, 61, 61, 32, 48, 41, 10, 9, 9, 9, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 91, 116, 120, 116, 44, 32, 112, 111, 115, 44, 32,
+		101, 112, 111, 115, 93, 59, 10, 9, 9, 9, 9, 9, 116, 120, 116, 32,
+		43, 61, 32, 120, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9,
+		105, 102, 40, 108, 110, 46, 101, 111, 108, 41, 123, 10, 9, 9, 9, 9,
+		9, 101, 112, 111, 115, 43, 43, 59, 10, 9, 9, 9, 9, 9, 116, 120,
+		116, 32, 43, 61, 32, 34, 92, 110, 34, 59, 10, 9, 9, 9, 9, 125,
+		10, 9, 9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120,
+		116, 59, 10, 9, 9, 9, 9, 112, 49, 32, 61, 32, 48, 59, 10, 9,
+		9, 9, 125, 32, 119, 104, 105, 108, 101, 40, 108, 110, 32, 33, 61, 32,
+		110, 117, 108, 108, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		32, 91, 116, 120, 116, 44, 32, 112, 111, 115, 44, 32, 101, 112, 111, 115,
+		93, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 105, 115, 114, 112,
+		97, 114, 101, 110, 40, 99, 41, 41, 123, 10, 9, 9, 9, 118, 97, 114,
+		32, 110, 32, 61, 32, 49, 59, 10, 9, 9, 9, 118, 97, 114, 32, 108,
+		99, 32, 61, 32, 108, 112, 97, 114, 101, 110, 40, 99, 41, 59, 10, 9,
 		9, 9, 118, 97, 114, 32, 116, 120, 116, 32, 61, 32, 34, 34, 59, 10,
-		9, 9, 100, 111, 123, 10, 9, 9, 9, 118, 97, 114, 32, 110, 103, 32,
-		61, 32, 110, 103, 101, 116, 45, 116, 111, 116, 59, 10, 9, 9, 9, 105,
-		102, 40, 111, 102, 102, 43, 110, 103, 32, 62, 32, 108, 110, 46, 116, 120,
-		116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9,
-		110, 103, 32, 61, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103,
-		116, 104, 32, 45, 32, 111, 102, 102, 59, 10, 9, 9, 9, 125, 10, 9,
-		9, 9, 116, 120, 116, 32, 43, 61, 32, 108, 110, 46, 116, 120, 116, 46,
-		115, 108, 105, 99, 101, 40, 111, 102, 102, 44, 32, 111, 102, 102, 43, 110,
-		103, 41, 59, 10, 9, 9, 9, 116, 111, 116, 32, 43, 61, 32, 110, 103,
-		59, 10, 9, 9, 9, 105, 102, 40, 116, 111, 116, 32, 60, 32, 110, 103,
-		101, 116, 32, 38, 38, 32, 108, 110, 46, 101, 111, 108, 41, 123, 10, 9,
-		9, 9, 9, 116, 120, 116, 32, 43, 61, 32, 34, 92, 110, 34, 59, 10,
-		9, 9, 9, 9, 116, 111, 116, 43, 43, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59,
-		10, 9, 9, 9, 111, 102, 102, 32, 61, 32, 48, 59, 10, 9, 9, 125,
-		119, 104, 105, 108, 101, 40, 116, 111, 116, 32, 60, 32, 110, 103, 101, 116,
-		32, 38, 38, 32, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 41, 59,
-		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 120, 116, 59, 10, 9,
-		125, 59, 10, 10, 9, 47, 47, 32, 114, 101, 116, 117, 114, 110, 115, 32,
-		91, 119, 111, 114, 100, 44, 32, 119, 112, 48, 44, 32, 119, 112, 49, 93,
-		10, 9, 116, 104, 105, 115, 46, 103, 101, 116, 119, 111, 114, 100, 32, 61,
-		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 111, 115, 44, 32, 108,
-		111, 110, 103, 41, 32, 123, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32,
-		60, 32, 48, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 91, 34, 34, 44, 32, 48, 44, 32, 48, 93, 59, 10, 9, 9, 125,
-		10, 9, 9, 105, 102, 40, 112, 111, 115, 32, 62, 61, 32, 116, 104, 105,
-		115, 46, 110, 114, 117, 110, 101, 115, 41, 32, 123, 10, 9, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 91, 34, 34, 44, 32, 116, 104, 105, 115, 46,
-		110, 114, 117, 110, 101, 115, 44, 32, 116, 104, 105, 115, 46, 110, 114, 117,
-		110, 101, 115, 93, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		105, 115, 99, 104, 97, 114, 32, 61, 32, 105, 115, 119, 111, 114, 100, 99,
-		104, 97, 114, 59, 10, 9, 9, 105, 102, 40, 108, 111, 110, 103, 41, 32,
-		123, 10, 9, 9, 9, 105, 115, 99, 104, 97, 114, 32, 61, 32, 105, 115,
-		108, 111, 110, 103, 119, 111, 114, 100, 99, 104, 97, 114, 59, 10, 9, 9,
-		125, 10, 9, 9, 118, 97, 114, 32, 108, 110, 44, 32, 108, 110, 111, 102,
-		102, 59, 10, 9, 9, 91, 108, 110, 44, 32, 108, 110, 111, 102, 102, 93,
-		32, 61, 32, 116, 104, 105, 115, 46, 115, 101, 101, 107, 40, 112, 111, 115,
-		41, 59, 10, 9, 9, 105, 102, 40, 108, 110, 32, 61, 61, 32, 110, 117,
-		108, 108, 41, 32, 123, 10, 9, 9, 9, 108, 110, 32, 61, 32, 116, 104,
-		105, 115, 46, 108, 110, 101, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102,
-		40, 108, 110, 32, 61, 61, 32, 116, 104, 105, 115, 46, 108, 110, 101, 32,
-		38, 38, 32, 108, 110, 46, 112, 114, 101, 118, 32, 33, 61, 32, 110, 117,
-		108, 108, 32, 38, 38, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110,
-		103, 116, 104, 32, 61, 61, 32, 48, 41, 32, 123, 10, 9, 9, 9, 108,
-		110, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 59, 10, 9, 9, 9,
-		112, 111, 115, 32, 61, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9,
-		125, 10, 9, 9, 118, 97, 114, 32, 101, 112, 111, 115, 32, 61, 32, 112,
-		111, 115, 59, 10, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 112,
-		111, 115, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9, 105,
-		102, 40, 112, 48, 32, 61, 61, 32, 108, 110, 46, 116, 120, 116, 46, 108,
-		101, 110, 103, 116, 104, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 116,
-		120, 116, 32, 61, 32, 108, 110, 46, 116, 120, 116, 59, 10, 9, 9, 9,
-		118, 97, 114, 32, 111, 102, 102, 32, 61, 32, 108, 110, 46, 111, 102, 102,
-		10, 9, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 112, 32,
-		61, 32, 108, 110, 46, 112, 114, 101, 118, 59, 32, 108, 110, 112, 32, 38,
-		38, 32, 33, 108, 110, 112, 46, 101, 111, 108, 59, 32, 108, 110, 112, 32,
-		61, 32, 108, 110, 112, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9,
-		9, 9, 116, 120, 116, 32, 61, 32, 108, 110, 112, 46, 116, 120, 116, 32,
-		43, 32, 116, 120, 116, 59, 10, 9, 9, 9, 9, 111, 102, 102, 32, 61,
-		32, 108, 110, 112, 46, 111, 102, 102, 59, 10, 9, 9, 9, 125, 10, 9,
-		9, 9, 105, 102, 40, 33, 108, 110, 46, 101, 111, 108, 41, 32, 123, 10,
-		9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 44,
-		32, 111, 102, 102, 44, 32, 111, 102, 102, 43, 116, 120, 116, 46, 108, 101,
-		110, 103, 116, 104, 93, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 43, 34, 92, 110, 34, 44,
-		32, 111, 102, 102, 44, 32, 111, 102, 102, 43, 116, 120, 116, 46, 108, 101,
-		110, 103, 116, 104, 43, 49, 93, 59, 10, 9, 9, 125, 10, 9, 9, 47,
-		47, 32, 104, 101, 117, 114, 105, 115, 116, 105, 99, 58, 32, 105, 102, 32,
-		99, 108, 105, 99, 107, 32, 97, 116, 32, 116, 104, 101, 32, 114, 105, 103,
-		104, 116, 32, 111, 102, 32, 108, 112, 97, 114, 101, 110, 32, 97, 110, 100,
-		32, 110, 111, 116, 10, 9, 9, 47, 47, 32, 97, 116, 32, 114, 112, 97,
-		114, 101, 110, 44, 32, 117, 115, 101, 32, 116, 104, 101, 32, 108, 112, 97,
-		114, 101, 110, 46, 10, 9, 9, 105, 102, 40, 112, 48, 32, 62, 32, 48,
-		32, 38, 38, 32, 33, 105, 115, 114, 112, 97, 114, 101, 110, 40, 108, 110,
-		46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 48, 41, 41,
-		32, 38, 38, 10, 9, 9, 32, 32, 32, 105, 115, 108, 112, 97, 114, 101,
-		110, 40, 108, 110, 46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40,
-		112, 48, 45, 49, 41, 41, 41, 123, 10, 9, 9, 9, 112, 111, 115, 45,
-		45, 59, 10, 9, 9, 9, 112, 48, 45, 45, 59, 10, 9, 9, 125, 10,
-		9, 9, 118, 97, 114, 32, 112, 49, 32, 61, 32, 112, 48, 59, 10, 9,
-		9, 118, 97, 114, 32, 99, 32, 61, 32, 108, 110, 46, 116, 120, 116, 46,
-		99, 104, 97, 114, 65, 116, 40, 112, 48, 41, 59, 10, 9, 9, 105, 102,
-		40, 105, 115, 108, 112, 97, 114, 101, 110, 40, 99, 41, 41, 123, 10, 9,
-		9, 9, 112, 111, 115, 43, 43, 59, 10, 9, 9, 9, 118, 97, 114, 32,
-		114, 99, 32, 61, 32, 114, 112, 97, 114, 101, 110, 40, 99, 41, 59, 10,
-		9, 9, 9, 118, 97, 114, 32, 116, 120, 116, 32, 61, 32, 34, 34, 59,
-		10, 9, 9, 9, 118, 97, 114, 32, 110, 32, 61, 32, 49, 59, 10, 9,
-		9, 9, 112, 49, 43, 43, 59, 10, 9, 9, 9, 101, 112, 111, 115, 43,
-		43, 59, 10, 9, 9, 9, 100, 111, 32, 123, 10, 9, 9, 9, 9, 118,
-		97, 114, 32, 120, 32, 61, 32, 48, 59, 10, 9, 9, 9, 9, 102, 111,
-		114, 40, 59, 32, 112, 49, 32, 60, 32, 108, 110, 46, 116, 120, 116, 46,
-		108, 101, 110, 103, 116, 104, 59, 32, 112, 49, 43, 43, 44, 32, 101, 112,
-		111, 115, 43, 43, 41, 32, 123, 10, 9, 9, 9, 9, 9, 120, 32, 61,
-		32, 108, 110, 46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112,
-		49, 41, 59, 10, 9, 9, 9, 9, 9, 105, 102, 40, 120, 32, 61, 61,
-		32, 114, 99, 41, 10, 9, 9, 9, 9, 9, 9, 110, 45, 45, 59, 10,
-		9, 9, 9, 9, 9, 105, 102, 40, 120, 32, 61, 61, 32, 99, 41, 10,
-		9, 9, 9, 9, 9, 9, 110, 43, 43, 59, 10, 9, 9, 9, 9, 9,
-		105, 102, 40, 110, 32, 61, 61, 32, 48, 41, 10, 9, 9, 9, 9, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 44, 32, 112, 111,
-		115, 44, 32, 101, 112, 111, 115, 93, 59, 10, 9, 9, 9, 9, 9, 116,
-		120, 116, 32, 43, 61, 32, 120, 59, 10, 9, 9, 9, 9, 125, 10, 9,
-		9, 9, 9, 105, 102, 40, 108, 110, 46, 101, 111, 108, 41, 123, 10, 9,
-		9, 9, 9, 9, 101, 112, 111, 115, 43, 43, 59, 10, 9, 9, 9, 9,
-		9, 116, 120, 116, 32, 43, 61, 32, 34, 92, 110, 34, 59, 10, 9, 9,
-		9, 9, 125, 10, 9, 9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46,
-		110, 101, 120, 116, 59, 10, 9, 9, 9, 9, 112, 49, 32, 61, 32, 48,
-		59, 10, 9, 9, 9, 125, 32, 119, 104, 105, 108, 101, 40, 108, 110, 32,
-		33, 61, 32, 110, 117, 108, 108, 41, 59, 10, 9, 9, 9, 114, 101, 116,
+		9, 9, 9, 100, 111, 123, 10, 9, 9, 9, 9, 102, 111, 114, 40, 112,
+		48, 45, 45, 59, 32, 112, 48, 32, 62, 61, 32, 48, 59, 32, 112, 48,
+		45, 45, 41, 123, 10, 9, 9, 9, 9, 9, 120, 32, 61, 32, 108, 110,
+		46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 48, 41, 59,
+		10, 9, 9, 9, 9, 9, 105, 102, 40, 120, 32, 61, 61, 32, 108, 99,
+		41, 10, 9, 9, 9, 9, 9, 9, 110, 45, 45, 59, 10, 9, 9, 9,
+		9, 9, 101, 108, 115, 101, 32, 105, 102, 40, 120, 32, 61, 61, 32, 99,
+		41, 10, 9, 9, 9, 9, 9, 9, 110, 43, 43, 59, 10, 9, 9, 9,
+		9, 9, 105, 102, 40, 110, 32, 33, 61, 32, 48, 41, 123, 10, 9, 9,
+		9, 9, 9, 9, 112, 111, 115, 45, 45, 59, 10, 9, 9, 9, 9, 9,
+		9, 116, 120, 116, 32, 61, 32, 120, 32, 43, 32, 116, 120, 116, 59, 10,
+		9, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 9, 105, 102, 40, 110,
+		32, 61, 61, 32, 48, 41, 10, 9, 9, 9, 9, 9, 9, 114, 101, 116,
 		117, 114, 110, 32, 91, 116, 120, 116, 44, 32, 112, 111, 115, 44, 32, 101,
-		112, 111, 115, 93, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 105,
-		115, 114, 112, 97, 114, 101, 110, 40, 99, 41, 41, 123, 10, 9, 9, 9,
-		118, 97, 114, 32, 110, 32, 61, 32, 49, 59, 10, 9, 9, 9, 118, 97,
-		114, 32, 108, 99, 32, 61, 32, 108, 112, 97, 114, 101, 110, 40, 99, 41,
-		59, 10, 9, 9, 9, 118, 97, 114, 32, 116, 120, 116, 32, 61, 32, 34,
-		34, 59, 10, 9, 9, 9, 100, 111, 123, 10, 9, 9, 9, 9, 102, 111,
-		114, 40, 112, 48, 45, 45, 59, 32, 112, 48, 32, 62, 61, 32, 48, 59,
-		32, 112, 48, 45, 45, 41, 123, 10, 9, 9, 9, 9, 9, 120, 32, 61,
-		32, 108, 110, 46, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112,
-		48, 41, 59, 10, 9, 9, 9, 9, 9, 105, 102, 40, 120, 32, 61, 61,
-		32, 108, 99, 41, 10, 9, 9, 9, 9, 9, 9, 110, 45, 45, 59, 10,
-		9, 9, 9, 9, 9, 101, 108, 115, 101, 32, 105, 102, 40, 120, 32, 61,
-		61, 32, 99, 41, 10, 9, 9, 9, 9, 9, 9, 110, 43, 43, 59, 10,
-		9, 9, 9, 9, 9, 105, 102, 40, 110, 32, 33, 61, 32, 48, 41, 123,
-		10, 9, 9, 9, 9, 9, 9, 112, 111, 115, 45, 45, 59, 10, 9, 9,
-		9, 9, 9, 9, 116, 120, 116, 32, 61, 32, 120, 32, 43, 32, 116, 120,
-		116, 59, 10, 9, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 9, 105,
-		102, 40, 110, 32, 61, 61, 32, 48, 41, 10, 9, 9, 9, 9, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 44, 32, 112, 111, 115,
-		44, 32, 101, 112, 111, 115, 93, 59, 10, 9, 9, 9, 9, 125, 10, 9,
-		9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 59,
-		10, 9, 9, 9, 9, 105, 102, 40, 108, 110, 32, 33, 61, 32, 110, 117,
-		108, 108, 41, 123, 10, 9, 9, 9, 9, 9, 112, 48, 32, 61, 32, 108,
-		110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9,
-		9, 9, 9, 105, 102, 40, 108, 110, 46, 101, 111, 108, 41, 123, 10, 9,
-		9, 9, 9, 9, 9, 112, 111, 115, 45, 45, 59, 10, 9, 9, 9, 9,
-		9, 9, 116, 120, 116, 32, 61, 32, 34, 92, 110, 34, 32, 43, 32, 116,
-		120, 116, 59, 10, 9, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 125,
-		10, 9, 9, 9, 125, 119, 104, 105, 108, 101, 40, 110, 32, 62, 32, 48,
-		32, 38, 38, 32, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 41, 59,
-		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 44,
-		32, 112, 111, 115, 44, 32, 101, 112, 111, 115, 93, 59, 10, 9, 9, 125,
-		10, 9, 9, 105, 102, 40, 33, 105, 115, 108, 111, 110, 103, 119, 111, 114,
-		100, 99, 104, 97, 114, 40, 99, 41, 41, 10, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 91, 108, 110, 46, 116, 120, 116, 46, 115, 108, 105, 99,
-		101, 40, 112, 48, 44, 32, 112, 49, 41, 44, 32, 112, 111, 115, 44, 32,
-		101, 112, 111, 115, 93, 59, 10, 9, 9, 118, 97, 114, 32, 116, 120, 116,
-		32, 61, 32, 108, 110, 46, 116, 120, 116, 59, 10, 9, 9, 102, 111, 114,
-		40, 118, 97, 114, 32, 108, 110, 112, 32, 61, 32, 108, 110, 46, 112, 114,
-		101, 118, 59, 32, 108, 110, 112, 32, 38, 38, 32, 33, 108, 110, 112, 46,
-		101, 111, 108, 59, 32, 108, 110, 112, 32, 61, 32, 108, 110, 112, 46, 112,
-		114, 101, 118, 41, 32, 123, 10, 9, 9, 9, 116, 120, 116, 32, 61, 32,
-		108, 110, 112, 46, 116, 120, 116, 32, 43, 32, 116, 120, 116, 59, 10, 9,
-		9, 9, 112, 48, 32, 43, 61, 32, 108, 110, 112, 46, 116, 120, 116, 46,
-		108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 112, 49, 32, 43, 61,
-		32, 108, 110, 112, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 59,
-		10, 9, 9, 125, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108,
-		110, 110, 32, 61, 32, 108, 110, 59, 32, 108, 110, 110, 46, 110, 101, 120,
-		116, 32, 38, 38, 32, 33, 108, 110, 110, 46, 101, 111, 108, 59, 32, 108,
-		110, 110, 32, 61, 32, 108, 110, 110, 46, 110, 101, 120, 116, 41, 32, 123,
-		10, 9, 9, 9, 116, 120, 116, 32, 43, 61, 32, 108, 110, 110, 46, 110,
-		101, 120, 116, 46, 116, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9, 119,
-		104, 105, 108, 101, 40, 112, 48, 32, 62, 32, 48, 32, 38, 38, 32, 105,
-		115, 99, 104, 97, 114, 40, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116,
-		40, 112, 48, 45, 49, 41, 41, 41, 123, 10, 9, 9, 9, 112, 111, 115,
-		45, 45, 59, 10, 9, 9, 9, 112, 48, 45, 45, 59, 10, 9, 9, 125,
-		10, 10, 9, 9, 119, 104, 105, 108, 101, 40, 112, 49, 32, 60, 32, 116,
-		120, 116, 46, 108, 101, 110, 103, 116, 104, 32, 38, 38, 32, 105, 115, 99,
-		104, 97, 114, 40, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112,
-		49, 41, 41, 41, 123, 10, 9, 9, 9, 101, 112, 111, 115, 43, 43, 59,
-		10, 9, 9, 9, 112, 49, 43, 43, 59, 10, 9, 9, 125, 10, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 46, 115, 108, 105, 99,
-		101, 40, 112, 48, 44, 32, 112, 49, 41, 44, 32, 112, 111, 115, 44, 32,
-		101, 112, 111, 115, 93, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105,
-		115, 46, 100, 117, 109, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
-		110, 40, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 111, 102, 102, 32,
-		61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 105, 32, 61, 32, 48,
-		59, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 32, 61,
-		32, 116, 104, 105, 115, 46, 108, 110, 115, 59, 32, 108, 110, 59, 32, 108,
-		110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 41, 123, 10, 9, 9,
-		9, 118, 97, 114, 32, 110, 32, 61, 32, 108, 110, 46, 108, 101, 110, 40,
-		41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 32, 61, 32, 108, 110,
-		46, 111, 102, 102, 59, 10, 9, 9, 9, 105, 102, 40, 33, 111, 32, 38,
-		38, 32, 33, 40, 111, 32, 61, 61, 61, 32, 48, 41, 41, 123, 10, 9,
-		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
-		66, 65, 68, 32, 111, 102, 102, 32, 34, 32, 43, 32, 111, 32, 43, 32,
-		34, 32, 105, 110, 58, 34, 41, 59, 10, 9, 9, 9, 9, 111, 32, 61,
-		32, 111, 102, 102, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102,
-		40, 111, 32, 33, 61, 32, 111, 102, 102, 41, 123, 10, 9, 9, 9, 9,
+		112, 111, 115, 93, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9,
+		108, 110, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 59, 10, 9, 9,
+		9, 9, 105, 102, 40, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 41,
+		123, 10, 9, 9, 9, 9, 9, 112, 48, 32, 61, 32, 108, 110, 46, 116,
+		120, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 9,
+		105, 102, 40, 108, 110, 46, 101, 111, 108, 41, 123, 10, 9, 9, 9, 9,
+		9, 9, 112, 111, 115, 45, 45, 59, 10, 9, 9, 9, 9, 9, 9, 116,
+		120, 116, 32, 61, 32, 34, 92, 110, 34, 32, 43, 32, 116, 120, 116, 59,
+		10, 9, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 125, 10, 9, 9,
+		9, 125, 119, 104, 105, 108, 101, 40, 110, 32, 62, 32, 48, 32, 38, 38,
+		32, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 41, 59, 10, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 120, 116, 44, 32, 112, 111,
+		115, 44, 32, 101, 112, 111, 115, 93, 59, 10, 9, 9, 125, 10, 9, 9,
+		105, 102, 40, 33, 105, 115, 108, 111, 110, 103, 119, 111, 114, 100, 99, 104,
+		97, 114, 40, 99, 41, 41, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
+		32, 91, 108, 110, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 112,
+		48, 44, 32, 112, 49, 41, 44, 32, 112, 111, 115, 44, 32, 101, 112, 111,
+		115, 93, 59, 10, 9, 9, 118, 97, 114, 32, 116, 120, 116, 32, 61, 32,
+		108, 110, 46, 116, 120, 116, 59, 10, 9, 9, 102, 111, 114, 40, 118, 97,
+		114, 32, 108, 110, 112, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 59,
+		32, 108, 110, 112, 32, 38, 38, 32, 33, 108, 110, 112, 46, 101, 111, 108,
+		59, 32, 108, 110, 112, 32, 61, 32, 108, 110, 112, 46, 112, 114, 101, 118,
+		41, 32, 123, 10, 9, 9, 9, 116, 120, 116, 32, 61, 32, 108, 110, 112,
+		46, 116, 120, 116, 32, 43, 32, 116, 120, 116, 59, 10, 9, 9, 9, 112,
+		48, 32, 43, 61, 32, 108, 110, 112, 46, 116, 120, 116, 46, 108, 101, 110,
+		103, 116, 104, 59, 10, 9, 9, 9, 112, 49, 32, 43, 61, 32, 108, 110,
+		112, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9,
+		125, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 110, 32,
+		61, 32, 108, 110, 59, 32, 108, 110, 110, 46, 110, 101, 120, 116, 32, 38,
+		38, 32, 33, 108, 110, 110, 46, 101, 111, 108, 59, 32, 108, 110, 110, 32,
+		61, 32, 108, 110, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9,
+		9, 116, 120, 116, 32, 43, 61, 32, 108, 110, 110, 46, 110, 101, 120, 116,
+		46, 116, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9, 119, 104, 105, 108,
+		101, 40, 112, 48, 32, 62, 32, 48, 32, 38, 38, 32, 105, 115, 99, 104,
+		97, 114, 40, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 48,
+		45, 49, 41, 41, 41, 123, 10, 9, 9, 9, 112, 111, 115, 45, 45, 59,
+		10, 9, 9, 9, 112, 48, 45, 45, 59, 10, 9, 9, 125, 10, 10, 9,
+		9, 119, 104, 105, 108, 101, 40, 112, 49, 32, 60, 32, 116, 120, 116, 46,
+		108, 101, 110, 103, 116, 104, 32, 38, 38, 32, 105, 115, 99, 104, 97, 114,
+		40, 116, 120, 116, 46, 99, 104, 97, 114, 65, 116, 40, 112, 49, 41, 41,
+		41, 123, 10, 9, 9, 9, 101, 112, 111, 115, 43, 43, 59, 10, 9, 9,
+		9, 112, 49, 43, 43, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 91, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 112,
+		48, 44, 32, 112, 49, 41, 44, 32, 112, 111, 115, 44, 32, 101, 112, 111,
+		115, 93, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 100,
+		117, 109, 112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
+		32, 123, 10, 9, 9, 118, 97, 114, 32, 111, 102, 102, 32, 61, 32, 48,
+		59, 10, 9, 9, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 10, 9,
+		9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104,
+		105, 115, 46, 108, 110, 115, 59, 32, 108, 110, 59, 32, 108, 110, 32, 61,
+		32, 108, 110, 46, 110, 101, 120, 116, 41, 123, 10, 9, 9, 9, 118, 97,
+		114, 32, 110, 32, 61, 32, 108, 110, 46, 108, 101, 110, 40, 41, 59, 10,
+		9, 9, 9, 118, 97, 114, 32, 111, 32, 61, 32, 108, 110, 46, 111, 102,
+		102, 59, 10, 9, 9, 9, 105, 102, 40, 33, 111, 32, 38, 38, 32, 33,
+		40, 111, 32, 61, 61, 61, 32, 48, 41, 41, 123, 10, 9, 9, 9, 9,
 		99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 66, 65, 68,
-		32, 111, 102, 102, 32, 34, 32, 43, 32, 111, 32, 43, 32, 34, 32, 40,
-		33, 61, 34, 32, 43, 32, 111, 102, 102, 32, 43, 32, 34, 41, 32, 105,
-		110, 58, 34, 41, 59, 10, 9, 9, 9, 9, 111, 102, 102, 32, 61, 32,
-		111, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 111, 102, 102, 32, 43,
-		61, 32, 110, 59, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46,
-		108, 111, 103, 40, 34, 34, 43, 32, 108, 110, 46, 115, 116, 114, 40, 41,
-		41, 59, 10, 9, 9, 9, 105, 43, 43, 59, 10, 9, 9, 125, 10, 9,
-		125, 59, 10, 125, 10, 10, 47, 47, 32, 76, 105, 110, 101, 115, 32, 116,
-		104, 97, 116, 32, 107, 110, 111, 119, 32, 104, 111, 119, 32, 116, 111, 32,
-		100, 114, 97, 119, 32, 117, 115, 105, 110, 103, 32, 97, 32, 99, 97, 110,
-		118, 97, 115, 10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 68, 114, 97,
-		119, 76, 105, 110, 101, 115, 40, 99, 41, 32, 123, 10, 9, 76, 105, 110,
-		101, 115, 46, 97, 112, 112, 108, 121, 40, 116, 104, 105, 115, 44, 32, 97,
-		114, 103, 117, 109, 101, 110, 116, 115, 41, 59, 10, 9, 116, 104, 105, 115,
-		46, 110, 108, 105, 110, 101, 115, 32, 61, 32, 48, 59, 9, 47, 47, 32,
-		108, 105, 110, 101, 115, 32, 105, 110, 32, 119, 105, 110, 100, 111, 119, 10,
-		9, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 32, 61, 32,
-		48, 59, 9, 47, 47, 32, 108, 105, 110, 101, 115, 32, 119, 105, 116, 104,
-		32, 116, 101, 120, 116, 10, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105,
-		122, 101, 32, 61, 32, 48, 59, 9, 47, 47, 32, 110, 98, 46, 32, 111,
-		102, 32, 114, 117, 110, 101, 115, 32, 105, 110, 32, 102, 114, 97, 109, 101,
-		10, 9, 116, 104, 105, 115, 46, 99, 32, 61, 32, 99, 59, 9, 9, 9,
-		47, 47, 32, 99, 97, 110, 118, 97, 115, 44, 32, 112, 101, 114, 104, 97,
-		112, 115, 32, 105, 116, 39, 115, 32, 116, 104, 105, 115, 46, 10, 9, 116,
-		104, 105, 115, 46, 102, 111, 110, 116, 115, 116, 121, 108, 101, 32, 61, 32,
-		39, 114, 39, 59, 10, 9, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116,
-		111, 112, 32, 61, 32, 52, 59, 10, 9, 116, 104, 105, 115, 46, 109, 97,
-		114, 103, 105, 110, 115, 122, 32, 61, 32, 54, 59, 10, 9, 116, 104, 105,
-		115, 46, 116, 115, 99, 97, 108, 101, 32, 61, 32, 52, 59, 9, 47, 47,
-		32, 115, 99, 97, 108, 101, 32, 109, 117, 115, 116, 32, 98, 101, 32, 101,
-		118, 101, 110, 59, 32, 119, 101, 32, 47, 50, 32, 119, 105, 116, 104, 111,
-		117, 116, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 10, 9, 116,
-		104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32, 61, 32,
-		48, 59, 9, 47, 47, 32, 98, 117, 116, 116, 111, 110, 32, 102, 111, 114,
-		32, 115, 101, 108, 101, 99, 116, 105, 111, 110, 10, 10, 10, 9, 116, 104,
-		105, 115, 46, 116, 105, 99, 107, 105, 109, 103, 32, 61, 32, 117, 110, 100,
-		101, 102, 105, 110, 101, 100, 59, 9, 47, 47, 32, 116, 105, 99, 107, 32,
-		105, 109, 97, 103, 101, 10, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107,
-		120, 32, 61, 32, 48, 59, 10, 9, 116, 104, 105, 115, 46, 116, 105, 99,
-		107, 121, 32, 61, 32, 48, 59, 10, 9, 116, 104, 105, 115, 46, 115, 97,
-		118, 101, 100, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100, 59,
-		9, 47, 47, 32, 115, 97, 118, 101, 100, 32, 105, 109, 97, 103, 101, 32,
-		117, 110, 100, 101, 114, 32, 116, 105, 99, 107, 10, 10, 9, 118, 97, 114,
-		32, 99, 116, 120, 32, 61, 32, 99, 46, 103, 101, 116, 67, 111, 110, 116,
-		101, 120, 116, 40, 34, 50, 100, 34, 44, 32, 123, 97, 108, 112, 104, 97,
-		58, 32, 102, 97, 108, 115, 101, 125, 41, 59, 10, 9, 116, 104, 105, 115,
-		46, 99, 116, 120, 32, 61, 32, 99, 116, 120, 59, 10, 10, 9, 99, 104,
-		101, 99, 107, 111, 117, 116, 102, 111, 110, 116, 115, 40, 99, 116, 120, 41,
-		59, 10, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101,
-		32, 61, 32, 34, 35, 70, 70, 70, 70, 69, 65, 34, 59, 10, 9, 118,
-		97, 114, 32, 116, 97, 98, 116, 101, 120, 116, 32, 61, 32, 65, 114, 114,
-		97, 121, 40, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 43,
-		49, 41, 46, 106, 111, 105, 110, 40, 34, 88, 34, 41, 59, 10, 10, 9,
-		116, 104, 105, 115, 46, 116, 97, 98, 119, 105, 100, 32, 61, 32, 99, 116,
-		120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 116, 97,
-		98, 116, 101, 120, 116, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 47,
-		47, 32, 49, 52, 32, 112, 105, 120, 101, 108, 115, 32, 61, 32, 49, 50,
-		112, 116, 32, 102, 111, 110, 116, 32, 43, 32, 50, 112, 116, 115, 32, 111,
-		102, 32, 115, 101, 112, 97, 114, 97, 116, 105, 111, 110, 32, 97, 116, 32,
-		116, 104, 101, 32, 98, 111, 116, 116, 111, 109, 44, 10, 9, 47, 47, 32,
-		98, 117, 116, 32, 119, 101, 32, 115, 99, 97, 108, 101, 32, 116, 104, 101,
-		32, 99, 97, 110, 118, 97, 115, 32, 42, 116, 115, 99, 97, 108, 101, 46,
-		10, 9, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 32, 61, 32,
-		49, 52, 42, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10,
-		10, 9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111, 110, 116, 32, 61,
-		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9,
-		118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115, 46, 99,
-		116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 109, 111, 100, 32, 61, 32,
-		34, 34, 59, 10, 9, 9, 118, 97, 114, 32, 115, 116, 121, 108, 101, 32,
-		61, 32, 34, 34, 59, 10, 9, 9, 115, 116, 121, 108, 101, 32, 61, 32,
-		116, 102, 118, 97, 114, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115,
-		46, 102, 111, 110, 116, 115, 116, 121, 108, 101, 46, 105, 110, 100, 101, 120,
-		79, 102, 40, 39, 114, 39, 41, 32, 61, 61, 61, 32, 45, 49, 41, 32,
-		123, 10, 9, 9, 9, 115, 116, 121, 108, 101, 32, 61, 32, 116, 102, 102,
-		105, 120, 101, 100, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116,
-		104, 105, 115, 46, 102, 111, 110, 116, 115, 116, 121, 108, 101, 46, 105, 110,
-		100, 101, 120, 79, 102, 40, 39, 98, 39, 41, 32, 62, 32, 45, 49, 41,
-		32, 123, 10, 9, 9, 9, 109, 111, 100, 32, 61, 32, 34, 98, 111, 108,
-		100, 32, 34, 32, 43, 32, 109, 111, 100, 59, 10, 9, 9, 125, 10, 9,
-		9, 105, 102, 40, 116, 104, 105, 115, 46, 102, 111, 110, 116, 115, 116, 121,
-		108, 101, 46, 105, 110, 100, 101, 120, 79, 102, 40, 39, 105, 39, 41, 32,
-		62, 32, 45, 49, 41, 32, 123, 10, 9, 9, 9, 109, 111, 100, 32, 61,
-		32, 34, 105, 116, 97, 108, 105, 99, 32, 34, 32, 43, 32, 109, 111, 100,
-		59, 10, 9, 9, 125, 10, 9, 9, 47, 47, 32, 97, 116, 32, 115, 99,
-		97, 108, 101, 32, 49, 44, 32, 119, 101, 32, 107, 101, 101, 112, 32, 116,
-		119, 111, 32, 101, 109, 112, 116, 121, 32, 112, 116, 115, 32, 97, 116, 32,
-		116, 104, 101, 32, 98, 111, 116, 116, 111, 109, 46, 10, 9, 9, 118, 97,
-		114, 32, 104, 116, 32, 61, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116,
-		104, 116, 32, 45, 32, 50, 42, 116, 104, 105, 115, 46, 116, 115, 99, 97,
-		108, 101, 59, 10, 9, 9, 99, 116, 120, 46, 102, 111, 110, 116, 32, 61,
-		32, 109, 111, 100, 32, 43, 32, 34, 32, 34, 32, 32, 43, 32, 104, 116,
-		43, 34, 112, 120, 32, 34, 43, 32, 115, 116, 121, 108, 101, 59, 10, 9,
-		9, 99, 116, 120, 46, 116, 101, 120, 116, 66, 97, 115, 101, 108, 105, 110,
-		101, 61, 34, 116, 111, 112, 34, 59, 10, 9, 125, 59, 10, 10, 9, 118,
-		97, 114, 32, 111, 108, 100, 99, 108, 101, 97, 114, 32, 61, 32, 116, 104,
-		105, 115, 46, 99, 108, 101, 97, 114, 59, 10, 9, 116, 104, 105, 115, 46,
-		99, 108, 101, 97, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
-		40, 41, 32, 123, 10, 9, 9, 111, 108, 100, 99, 108, 101, 97, 114, 46,
-		99, 97, 108, 108, 40, 116, 104, 105, 115, 41, 59, 10, 9, 9, 116, 104,
-		105, 115, 46, 110, 108, 105, 110, 101, 115, 32, 61, 32, 48, 59, 10, 9,
-		9, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 32, 61, 32,
-		48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101,
-		32, 61, 32, 48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115, 97, 118,
-		101, 100, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 120, 32, 61, 32, 116,
+		32, 111, 102, 102, 32, 34, 32, 43, 32, 111, 32, 43, 32, 34, 32, 105,
+		110, 58, 34, 41, 59, 10, 9, 9, 9, 9, 111, 32, 61, 32, 111, 102,
+		102, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 111, 32,
+		33, 61, 32, 111, 102, 102, 41, 123, 10, 9, 9, 9, 9, 99, 111, 110,
+		115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 66, 65, 68, 32, 111, 102,
+		102, 32, 34, 32, 43, 32, 111, 32, 43, 32, 34, 32, 40, 33, 61, 34,
+		32, 43, 32, 111, 102, 102, 32, 43, 32, 34, 41, 32, 105, 110, 58, 34,
+		41, 59, 10, 9, 9, 9, 9, 111, 102, 102, 32, 61, 32, 111, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 111, 102, 102, 32, 43, 61, 32, 110,
+		59, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103,
+		40, 34, 34, 43, 32, 108, 110, 46, 115, 116, 114, 40, 41, 41, 59, 10,
+		9, 9, 9, 105, 43, 43, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10,
+		125, 10, 10, 47, 47, 32, 76, 105, 110, 101, 115, 32, 116, 104, 97, 116,
+		32, 107, 110, 111, 119, 32, 104, 111, 119, 32, 116, 111, 32, 100, 114, 97,
+		119, 32, 117, 115, 105, 110, 103, 32, 97, 32, 99, 97, 110, 118, 97, 115,
+		10, 102, 117, 110, 99, 116, 105, 111, 110, 32, 68, 114, 97, 119, 76, 105,
+		110, 101, 115, 40, 99, 41, 32, 123, 10, 9, 76, 105, 110, 101, 115, 46,
+		97, 112, 112, 108, 121, 40, 116, 104, 105, 115, 44, 32, 97, 114, 103, 117,
+		109, 101, 110, 116, 115, 41, 59, 10, 9, 116, 104, 105, 115, 46, 110, 108,
+		105, 110, 101, 115, 32, 61, 32, 48, 59, 9, 47, 47, 32, 108, 105, 110,
+		101, 115, 32, 105, 110, 32, 119, 105, 110, 100, 111, 119, 10, 9, 116, 104,
+		105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 32, 61, 32, 48, 59, 9,
+		47, 47, 32, 108, 105, 110, 101, 115, 32, 119, 105, 116, 104, 32, 116, 101,
+		120, 116, 10, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 32,
+		61, 32, 48, 59, 9, 47, 47, 32, 110, 98, 46, 32, 111, 102, 32, 114,
+		117, 110, 101, 115, 32, 105, 110, 32, 102, 114, 97, 109, 101, 10, 9, 116,
+		104, 105, 115, 46, 99, 32, 61, 32, 99, 59, 9, 9, 9, 47, 47, 32,
+		99, 97, 110, 118, 97, 115, 44, 32, 112, 101, 114, 104, 97, 112, 115, 32,
+		105, 116, 39, 115, 32, 116, 104, 105, 115, 46, 10, 9, 116, 104, 105, 115,
+		46, 102, 111, 110, 116, 115, 116, 121, 108, 101, 32, 61, 32, 39, 114, 39,
+		59, 10, 9, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 32,
+		61, 32, 52, 59, 10, 9, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105,
+		110, 115, 122, 32, 61, 32, 54, 59, 10, 9, 116, 104, 105, 115, 46, 108,
+		105, 110, 101, 110, 98, 115, 32, 61, 32, 102, 97, 108, 115, 101, 59, 9,
+		47, 47, 32, 115, 104, 111, 119, 32, 108, 105, 110, 101, 32, 110, 117, 109,
+		98, 101, 114, 115, 10, 9, 116, 104, 105, 115, 46, 103, 117, 116, 116, 101,
+		114, 32, 61, 32, 48, 59, 9, 47, 47, 32, 119, 105, 100, 116, 104, 32,
+		102, 111, 114, 32, 108, 105, 110, 101, 32, 110, 117, 109, 98, 101, 114, 115,
+		44, 32, 105, 102, 32, 115, 104, 111, 119, 110, 10, 9, 116, 104, 105, 115,
+		46, 116, 115, 99, 97, 108, 101, 32, 61, 32, 52, 59, 9, 47, 47, 32,
+		115, 99, 97, 108, 101, 32, 109, 117, 115, 116, 32, 98, 101, 32, 101, 118,
+		101, 110, 59, 32, 119, 101, 32, 47, 50, 32, 119, 105, 116, 104, 111, 117,
+		116, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 10, 9, 116, 104,
+		105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32, 61, 32, 48,
+		59, 9, 47, 47, 32, 98, 117, 116, 116, 111, 110, 32, 102, 111, 114, 32,
+		115, 101, 108, 101, 99, 116, 105, 111, 110, 10, 10, 10, 9, 116, 104, 105,
+		115, 46, 118, 105, 101, 119, 101, 114, 115, 32, 61, 32, 123, 125, 59, 9,
+		47, 47, 32, 111, 116, 104, 101, 114, 32, 118, 105, 101, 119, 115, 58, 32,
+		105, 100, 58, 32, 123, 119, 104, 111, 58, 32, 117, 115, 101, 114, 64, 104,
+		111, 115, 116, 44, 32, 99, 111, 108, 111, 114, 58, 32, 99, 125, 10, 9,
+		116, 104, 105, 115, 46, 110, 118, 105, 101, 119, 101, 114, 115, 32, 61, 32,
+		48, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 105, 109,
+		103, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100, 59, 9, 47,
+		47, 32, 116, 105, 99, 107, 32, 105, 109, 97, 103, 101, 10, 9, 116, 104,
+		105, 115, 46, 116, 105, 99, 107, 120, 32, 61, 32, 48, 59, 10, 9, 116,
 		104, 105, 115, 46, 116, 105, 99, 107, 121, 32, 61, 32, 48, 59, 10, 9,
-		125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 99, 108, 101, 97, 114, 108,
-		105, 110, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 105,
-		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32,
-		116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32,
-		112, 111, 115, 32, 61, 32, 105, 42, 116, 104, 105, 115, 46, 102, 111, 110,
-		116, 104, 116, 59, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32, 62, 61,
-		32, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 41, 32,
-		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115,
-		101, 59, 10, 9, 9, 125, 10, 9, 9, 99, 116, 120, 67, 108, 101, 97,
-		114, 82, 101, 99, 116, 40, 99, 116, 120, 44, 32, 49, 44, 32, 112, 111,
-		115, 44, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 45,
-		49, 44, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41, 59,
-		10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10,
-		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 107, 116, 105, 99,
-		107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123,
-		10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105,
-		115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 120, 32, 61,
-		32, 99, 116, 120, 46, 108, 105, 110, 101, 87, 105, 100, 116, 104, 59, 10,
-		9, 9, 99, 116, 120, 46, 108, 105, 110, 101, 87, 105, 100, 116, 104, 32,
-		61, 32, 49, 59, 10, 9, 9, 118, 97, 114, 32, 100, 32, 61, 32, 51,
+		116, 104, 105, 115, 46, 115, 97, 118, 101, 100, 32, 61, 32, 117, 110, 100,
+		101, 102, 105, 110, 101, 100, 59, 9, 47, 47, 32, 115, 97, 118, 101, 100,
+		32, 105, 109, 97, 103, 101, 32, 117, 110, 100, 101, 114, 32, 116, 105, 99,
+		107, 10, 10, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 99, 46,
+		103, 101, 116, 67, 111, 110, 116, 101, 120, 116, 40, 34, 50, 100, 34, 44,
+		32, 123, 97, 108, 112, 104, 97, 58, 32, 102, 97, 108, 115, 101, 125, 41,
+		59, 10, 9, 116, 104, 105, 115, 46, 99, 116, 120, 32, 61, 32, 99, 116,
+		120, 59, 10, 10, 9, 99, 104, 101, 99, 107, 111, 117, 116, 102, 111, 110,
+		116, 115, 40, 99, 116, 120, 41, 59, 10, 9, 99, 116, 120, 46, 102, 105,
+		108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 99, 116, 120, 84, 104, 101,
+		109, 101, 40, 99, 116, 120, 41, 46, 98, 103, 59, 10, 9, 118, 97, 114,
+		32, 116, 97, 98, 116, 101, 120, 116, 32, 61, 32, 65, 114, 114, 97, 121,
+		40, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 43, 49, 41,
+		46, 106, 111, 105, 110, 40, 34, 88, 34, 41, 59, 10, 10, 9, 116, 104,
+		105, 115, 46, 116, 97, 98, 119, 105, 100, 32, 61, 32, 99, 116, 120, 46,
+		109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 116, 97, 98, 116,
+		101, 120, 116, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 47, 47, 32,
+		49, 52, 32, 112, 105, 120, 101, 108, 115, 32, 61, 32, 49, 50, 112, 116,
+		32, 102, 111, 110, 116, 32, 43, 32, 50, 112, 116, 115, 32, 111, 102, 32,
+		115, 101, 112, 97, 114, 97, 116, 105, 111, 110, 32, 97, 116, 32, 116, 104,
+		101, 32, 98, 111, 116, 116, 111, 109, 44, 10, 9, 47, 47, 32, 98, 117,
+		116, 32, 119, 101, 32, 115, 99, 97, 108, 101, 32, 116, 104, 101, 32, 99,
+		97, 110, 118, 97, 115, 32, 42, 116, 115, 99, 97, 108, 101, 46, 10, 9,
+		116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 32, 61, 32, 49, 52,
+		42, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10, 10, 9,
+		47, 47, 32, 102, 111, 110, 116, 115, 116, 121, 108, 101, 32, 105, 115, 32,
+		108, 105, 107, 101, 32, 34, 116, 98, 49, 52, 58, 102, 97, 109, 105, 108,
+		121, 34, 44, 32, 115, 101, 101, 32, 105, 110, 107, 46, 84, 120, 116, 46,
+		83, 101, 116, 70, 111, 110, 116, 10, 9, 116, 104, 105, 115, 46, 102, 105,
+		120, 102, 111, 110, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61,
+		32, 116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114,
+		32, 109, 111, 100, 32, 61, 32, 34, 34, 59, 10, 9, 9, 118, 97, 114,
+		32, 115, 116, 121, 108, 101, 32, 61, 32, 34, 34, 59, 10, 9, 9, 118,
+		97, 114, 32, 102, 115, 32, 61, 32, 116, 104, 105, 115, 46, 102, 111, 110,
+		116, 115, 116, 121, 108, 101, 59, 10, 9, 9, 118, 97, 114, 32, 102, 97,
+		109, 105, 108, 121, 32, 61, 32, 34, 34, 59, 10, 9, 9, 118, 97, 114,
+		32, 105, 32, 61, 32, 102, 115, 46, 105, 110, 100, 101, 120, 79, 102, 40,
+		39, 58, 39, 41, 59, 10, 9, 9, 105, 102, 40, 105, 32, 62, 61, 32,
+		48, 41, 32, 123, 10, 9, 9, 9, 102, 97, 109, 105, 108, 121, 32, 61,
+		32, 102, 115, 46, 115, 108, 105, 99, 101, 40, 105, 43, 49, 41, 59, 10,
+		9, 9, 9, 102, 115, 32, 61, 32, 102, 115, 46, 115, 108, 105, 99, 101,
+		40, 48, 44, 32, 105, 41, 59, 10, 9, 9, 125, 10, 9, 9, 115, 116,
+		121, 108, 101, 32, 61, 32, 116, 102, 118, 97, 114, 59, 10, 9, 9, 105,
+		102, 40, 102, 115, 46, 105, 110, 100, 101, 120, 79, 102, 40, 39, 114, 39,
+		41, 32, 61, 61, 61, 32, 45, 49, 41, 32, 123, 10, 9, 9, 9, 115,
+		116, 121, 108, 101, 32, 61, 32, 116, 102, 102, 105, 120, 101, 100, 59, 10,
+		9, 9, 125, 10, 9, 9, 105, 102, 40, 102, 97, 109, 105, 108, 121, 32,
+		33, 61, 32, 34, 34, 41, 32, 123, 10, 9, 9, 9, 115, 116, 121, 108,
+		101, 32, 61, 32, 102, 97, 109, 105, 108, 121, 59, 10, 9, 9, 125, 10,
+		9, 9, 105, 102, 40, 102, 115, 46, 105, 110, 100, 101, 120, 79, 102, 40,
+		39, 98, 39, 41, 32, 62, 32, 45, 49, 41, 32, 123, 10, 9, 9, 9,
+		109, 111, 100, 32, 61, 32, 34, 98, 111, 108, 100, 32, 34, 32, 43, 32,
+		109, 111, 100, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 102, 115,
+		46, 105, 110, 100, 101, 120, 79, 102, 40, 39, 105, 39, 41, 32, 62, 32,
+		45, 49, 41, 32, 123, 10, 9, 9, 9, 109, 111, 100, 32, 61, 32, 34,
+		105, 116, 97, 108, 105, 99, 32, 34, 32, 43, 32, 109, 111, 100, 59, 10,
+		9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 112, 116, 32, 61, 32, 112,
+		97, 114, 115, 101, 73, 110, 116, 40, 102, 115, 46, 114, 101, 112, 108, 97,
+		99, 101, 40, 47, 91, 94, 48, 45, 57, 93, 47, 103, 44, 32, 34, 34,
+		41, 41, 59, 10, 9, 9, 105, 102, 40, 33, 112, 116, 32, 124, 124, 32,
+		112, 116, 32, 60, 32, 52, 41, 32, 123, 10, 9, 9, 9, 112, 116, 32,
+		61, 32, 49, 50, 59, 10, 9, 9, 125, 10, 9, 9, 116, 104, 105, 115,
+		46, 102, 111, 110, 116, 104, 116, 32, 61, 32, 40, 112, 116, 43, 50, 41,
 		42, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10, 9, 9,
-		99, 116, 120, 46, 102, 105, 108, 108, 82, 101, 99, 116, 40, 48, 44, 32,
-		48, 44, 32, 100, 44, 32, 100, 41, 59, 10, 9, 9, 99, 116, 120, 46,
-		102, 105, 108, 108, 82, 101, 99, 116, 40, 48, 44, 32, 116, 104, 105, 115,
-		46, 102, 111, 110, 116, 104, 116, 45, 100, 44, 32, 100, 44, 32, 100, 41,
-		59, 10, 9, 9, 99, 116, 120, 46, 109, 111, 118, 101, 84, 111, 40, 100,
-		47, 50, 44, 32, 48, 41, 59, 10, 9, 9, 99, 116, 120, 46, 108, 105,
-		110, 101, 84, 111, 40, 100, 47, 50, 44, 32, 116, 104, 105, 115, 46, 102,
-		111, 110, 116, 104, 116, 41, 59, 10, 9, 9, 99, 116, 120, 46, 115, 116,
-		114, 111, 107, 101, 40, 41, 59, 10, 9, 9, 99, 116, 120, 46, 108, 105,
-		110, 101, 87, 105, 100, 116, 104, 32, 61, 32, 120, 59, 10, 9, 9, 116,
-		104, 105, 115, 46, 116, 105, 99, 107, 105, 109, 103, 32, 61, 32, 99, 116,
-		120, 46, 103, 101, 116, 73, 109, 97, 103, 101, 68, 97, 116, 97, 40, 48,
-		44, 32, 48, 44, 32, 100, 44, 32, 116, 104, 105, 115, 46, 102, 111, 110,
-		116, 104, 116, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115,
-		46, 117, 110, 116, 105, 99, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105,
-		115, 46, 115, 97, 118, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101,
-		116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		99, 116, 120, 32, 61, 32, 116, 104, 105, 115, 46, 99, 116, 120, 59, 10,
-		9, 9, 99, 116, 120, 46, 112, 117, 116, 73, 109, 97, 103, 101, 68, 97,
-		116, 97, 40, 116, 104, 105, 115, 46, 115, 97, 118, 101, 100, 44, 32, 116,
-		104, 105, 115, 46, 116, 105, 99, 107, 120, 44, 32, 116, 104, 105, 115, 46,
-		116, 105, 99, 107, 121, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115,
-		97, 118, 101, 100, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100,
-		59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 105, 99,
-		107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 120, 44, 32,
-		121, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61,
-		32, 116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 105, 102, 40,
-		48, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 116,
-		105, 99, 107, 34, 44, 32, 120, 44, 32, 121, 41, 59, 10, 9, 9, 116,
-		104, 105, 115, 46, 115, 97, 118, 101, 100, 32, 61, 32, 99, 116, 120, 46,
-		103, 101, 116, 73, 109, 97, 103, 101, 68, 97, 116, 97, 40, 120, 44, 32,
-		121, 44, 32, 51, 42, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101,
+		47, 47, 32, 97, 116, 32, 115, 99, 97, 108, 101, 32, 49, 44, 32, 119,
+		101, 32, 107, 101, 101, 112, 32, 116, 119, 111, 32, 101, 109, 112, 116, 121,
+		32, 112, 116, 115, 32, 97, 116, 32, 116, 104, 101, 32, 98, 111, 116, 116,
+		111, 109, 46, 10, 9, 9, 118, 97, 114, 32, 104, 116, 32, 61, 32, 116,
+		104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 32, 45, 32, 50, 42, 116,
+		104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10, 9, 9, 99, 116,
+		120, 46, 102, 111, 110, 116, 32, 61, 32, 109, 111, 100, 32, 43, 32, 34,
+		32, 34, 32, 32, 43, 32, 104, 116, 43, 34, 112, 120, 32, 34, 43, 32,
+		115, 116, 121, 108, 101, 59, 10, 9, 9, 99, 116, 120, 46, 116, 101, 120,
+		116, 66, 97, 115, 101, 108, 105, 110, 101, 61, 34, 116, 111, 112, 34, 59,
+		10, 9, 9, 116, 104, 105, 115, 46, 103, 117, 116, 116, 101, 114, 32, 61,
+		32, 48, 59, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 108, 105,
+		110, 101, 110, 98, 115, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115,
+		46, 103, 117, 116, 116, 101, 114, 32, 61, 32, 116, 104, 105, 115, 46, 109,
+		97, 114, 103, 105, 110, 115, 122, 32, 43, 32, 99, 116, 120, 46, 109, 101,
+		97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 34, 48, 48, 48, 48, 48,
+		34, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 9, 125, 10, 9, 125,
+		59, 10, 10, 9, 47, 47, 32, 120, 32, 102, 111, 114, 32, 116, 104, 101,
+		32, 115, 116, 97, 114, 116, 32, 111, 102, 32, 116, 104, 101, 32, 116, 101,
+		120, 116, 44, 32, 112, 97, 115, 116, 32, 116, 104, 101, 32, 103, 117, 116,
+		116, 101, 114, 46, 10, 9, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103,
+		105, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32,
+		123, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46,
+		109, 97, 114, 103, 105, 110, 115, 122, 32, 43, 32, 116, 104, 105, 115, 46,
+		103, 117, 116, 116, 101, 114, 59, 10, 9, 125, 59, 10, 10, 9, 118, 97,
+		114, 32, 111, 108, 100, 99, 108, 101, 97, 114, 32, 61, 32, 116, 104, 105,
+		115, 46, 99, 108, 101, 97, 114, 59, 10, 9, 116, 104, 105, 115, 46, 99,
+		108, 101, 97, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
+		41, 32, 123, 10, 9, 9, 111, 108, 100, 99, 108, 101, 97, 114, 46, 99,
+		97, 108, 108, 40, 116, 104, 105, 115, 41, 59, 10, 9, 9, 116, 104, 105,
+		115, 46, 110, 108, 105, 110, 101, 115, 32, 61, 32, 48, 59, 10, 9, 9,
+		116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 32, 61, 32, 48,
+		59, 10, 9, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 32,
+		61, 32, 48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115, 97, 118, 101,
+		100, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 120, 32, 61, 32, 116, 104,
+		105, 115, 46, 116, 105, 99, 107, 121, 32, 61, 32, 48, 59, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 99, 108, 101, 97, 114, 108, 105,
+		110, 101, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 105, 41,
+		32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116,
+		104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 112,
+		111, 115, 32, 61, 32, 105, 42, 116, 104, 105, 115, 46, 102, 111, 110, 116,
+		104, 116, 59, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32, 62, 61, 32,
+		116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 41, 32, 123,
+		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101,
+		59, 10, 9, 9, 125, 10, 9, 9, 99, 116, 120, 67, 108, 101, 97, 114,
+		82, 101, 99, 116, 40, 99, 116, 120, 44, 32, 49, 44, 32, 112, 111, 115,
+		44, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 45, 49,
 		44, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41, 59, 10,
-		9, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 120, 32, 61, 32, 120,
-		59, 10, 9, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 121, 32, 61,
-		32, 121, 59, 10, 9, 9, 99, 116, 120, 46, 112, 117, 116, 73, 109, 97,
-		103, 101, 68, 97, 116, 97, 40, 116, 104, 105, 115, 46, 116, 105, 99, 107,
-		105, 109, 103, 44, 32, 120, 44, 32, 121, 41, 59, 10, 9, 125, 59, 10,
-		10, 9, 47, 47, 32, 100, 114, 97, 119, 32, 97, 32, 108, 105, 110, 101,
-		32, 97, 110, 100, 32, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115,
-		101, 32, 105, 102, 32, 105, 116, 39, 115, 32, 111, 117, 116, 32, 111, 102,
-		32, 116, 104, 101, 32, 100, 114, 97, 119, 32, 115, 112, 97, 99, 101, 46,
-		10, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 108, 105, 110, 101, 32,
-		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 41, 32, 123,
+		9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9,
+		125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 107, 116, 105, 99, 107,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115,
+		46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32,
+		99, 116, 120, 46, 108, 105, 110, 101, 87, 105, 100, 116, 104, 59, 10, 9,
+		9, 99, 116, 120, 46, 108, 105, 110, 101, 87, 105, 100, 116, 104, 32, 61,
+		32, 49, 59, 10, 9, 9, 118, 97, 114, 32, 100, 32, 61, 32, 51, 42,
+		116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10, 9, 9, 99,
+		116, 120, 46, 102, 105, 108, 108, 82, 101, 99, 116, 40, 48, 44, 32, 48,
+		44, 32, 100, 44, 32, 100, 41, 59, 10, 9, 9, 99, 116, 120, 46, 102,
+		105, 108, 108, 82, 101, 99, 116, 40, 48, 44, 32, 116, 104, 105, 115, 46,
+		102, 111, 110, 116, 104, 116, 45, 100, 44, 32, 100, 44, 32, 100, 41, 59,
+		10, 9, 9, 99, 116, 120, 46, 109, 111, 118, 101, 84, 111, 40, 100, 47,
+		50, 44, 32, 48, 41, 59, 10, 9, 9, 99, 116, 120, 46, 108, 105, 110,
+		101, 84, 111, 40, 100, 47, 50, 44, 32, 116, 104, 105, 115, 46, 102, 111,
+		110, 116, 104, 116, 41, 59, 10, 9, 9, 99, 116, 120, 46, 115, 116, 114,
+		111, 107, 101, 40, 41, 59, 10, 9, 9, 99, 116, 120, 46, 108, 105, 110,
+		101, 87, 105, 100, 116, 104, 32, 61, 32, 120, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 116, 105, 99, 107, 105, 109, 103, 32, 61, 32, 99, 116, 120,
+		46, 103, 101, 116, 73, 109, 97, 103, 101, 68, 97, 116, 97, 40, 48, 44,
+		32, 48, 44, 32, 100, 44, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116,
+		104, 116, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
+		117, 110, 116, 105, 99, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115,
+		46, 115, 97, 118, 101, 100, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116,
+		117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 99,
+		116, 120, 32, 61, 32, 116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9,
+		9, 99, 116, 120, 46, 112, 117, 116, 73, 109, 97, 103, 101, 68, 97, 116,
+		97, 40, 116, 104, 105, 115, 46, 115, 97, 118, 101, 100, 44, 32, 116, 104,
+		105, 115, 46, 116, 105, 99, 107, 120, 44, 32, 116, 104, 105, 115, 46, 116,
+		105, 99, 107, 121, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 115, 97,
+		118, 101, 100, 32, 61, 32, 117, 110, 100, 101, 102, 105, 110, 101, 100, 59,
+		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 120, 44, 32, 121,
+		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32,
+		116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 105, 102, 40, 48,
+		41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 116, 105,
+		99, 107, 34, 44, 32, 120, 44, 32, 121, 41, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 115, 97, 118, 101, 100, 32, 61, 32, 99, 116, 120, 46, 103,
+		101, 116, 73, 109, 97, 103, 101, 68, 97, 116, 97, 40, 120, 44, 32, 121,
+		44, 32, 51, 42, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101, 44,
+		32, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 120, 32, 61, 32, 120, 59,
+		10, 9, 9, 116, 104, 105, 115, 46, 116, 105, 99, 107, 121, 32, 61, 32,
+		121, 59, 10, 9, 9, 99, 116, 120, 46, 112, 117, 116, 73, 109, 97, 103,
+		101, 68, 97, 116, 97, 40, 116, 104, 105, 115, 46, 116, 105, 99, 107, 105,
+		109, 103, 44, 32, 120, 44, 32, 121, 41, 59, 10, 9, 125, 59, 10, 10,
+		9, 47, 47, 32, 100, 114, 97, 119, 32, 97, 32, 108, 105, 110, 101, 32,
+		97, 110, 100, 32, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101,
+		32, 105, 102, 32, 105, 116, 39, 115, 32, 111, 117, 116, 32, 111, 102, 32,
+		116, 104, 101, 32, 100, 114, 97, 119, 32, 115, 112, 97, 99, 101, 46, 10,
+		9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 108, 105, 110, 101, 32, 61,
+		32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 41, 32, 123, 10,
+		9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 100, 114, 97, 119, 108,
+		105, 110, 101, 49, 40, 108, 110, 41, 41, 32, 123, 10, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 125,
+		10, 9, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 118, 105, 101, 119,
+		101, 114, 115, 40, 108, 110, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46,
+		100, 114, 97, 119, 115, 112, 97, 110, 115, 40, 108, 110, 41, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 109, 97, 116, 99, 104, 40,
+		108, 110, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119,
+		108, 105, 110, 101, 110, 111, 40, 108, 110, 41, 59, 10, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 125, 59, 10, 10,
+		9, 47, 47, 32, 115, 104, 111, 119, 32, 116, 104, 101, 32, 110, 117, 109,
+		98, 101, 114, 32, 111, 102, 32, 116, 104, 101, 32, 108, 105, 110, 101, 32,
+		105, 110, 32, 116, 104, 101, 32, 103, 117, 116, 116, 101, 114, 44, 32, 102,
+		111, 114, 32, 108, 105, 110, 101, 115, 10, 9, 47, 47, 32, 115, 116, 97,
+		114, 116, 105, 110, 103, 32, 97, 32, 116, 101, 120, 116, 32, 108, 105, 110,
+		101, 32, 40, 110, 111, 116, 32, 102, 111, 114, 32, 119, 114, 97, 112, 112,
+		101, 100, 32, 111, 110, 101, 115, 41, 46, 10, 9, 116, 104, 105, 115, 46,
+		100, 114, 97, 119, 108, 105, 110, 101, 110, 111, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 108, 110, 41, 32, 123, 10, 9, 9, 105, 102,
+		40, 33, 116, 104, 105, 115, 46, 103, 117, 116, 116, 101, 114, 32, 124, 124,
+		32, 40, 108, 110, 46, 112, 114, 101, 118, 32, 38, 38, 32, 33, 108, 110,
+		46, 112, 114, 101, 118, 46, 101, 111, 108, 41, 41, 32, 123, 10, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118,
+		97, 114, 32, 110, 32, 61, 32, 49, 59, 10, 9, 9, 102, 111, 114, 40,
+		118, 97, 114, 32, 108, 32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 59,
+		32, 108, 59, 32, 108, 32, 61, 32, 108, 46, 112, 114, 101, 118, 41, 32,
+		123, 10, 9, 9, 9, 105, 102, 40, 108, 46, 101, 111, 108, 41, 32, 123,
+		10, 9, 9, 9, 9, 110, 43, 43, 59, 10, 9, 9, 9, 125, 10, 9,
+		9, 125, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116,
+		104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121,
+		32, 61, 32, 40, 108, 110, 46, 108, 110, 105, 45, 116, 104, 105, 115, 46,
+		108, 110, 48, 46, 108, 110, 105, 41, 42, 116, 104, 105, 115, 46, 102, 111,
+		110, 116, 104, 116, 59, 10, 9, 9, 118, 97, 114, 32, 115, 32, 61, 32,
+		34, 34, 32, 43, 32, 110, 59, 10, 9, 9, 118, 97, 114, 32, 111, 108,
+		100, 32, 61, 32, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108,
+		101, 59, 10, 9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121,
+		108, 101, 32, 61, 32, 99, 116, 120, 84, 104, 101, 109, 101, 40, 99, 116,
+		120, 41, 46, 108, 110, 102, 103, 59, 10, 9, 9, 99, 116, 120, 46, 102,
+		105, 108, 108, 84, 101, 120, 116, 40, 115, 44, 32, 116, 104, 105, 115, 46,
+		103, 117, 116, 116, 101, 114, 32, 45, 32, 99, 116, 120, 46, 109, 101, 97,
+		115, 117, 114, 101, 84, 101, 120, 116, 40, 115, 41, 46, 119, 105, 100, 116,
+		104, 44, 32, 121, 41, 59, 10, 9, 9, 99, 116, 120, 46, 102, 105, 108,
+		108, 83, 116, 121, 108, 101, 32, 61, 32, 111, 108, 100, 59, 10, 9, 125,
+		59, 10, 10, 9, 47, 47, 32, 98, 111, 120, 32, 116, 104, 101, 32, 98,
+		114, 97, 99, 107, 101, 116, 32, 97, 116, 32, 116, 104, 101, 32, 98, 109,
+		97, 116, 99, 104, 32, 109, 97, 114, 107, 44, 32, 105, 102, 32, 105, 116,
+		39, 115, 32, 105, 110, 32, 116, 104, 105, 115, 32, 108, 105, 110, 101, 46,
+		10, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 109, 97, 116, 99, 104,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 41, 32,
+		123, 10, 9, 9, 118, 97, 114, 32, 109, 32, 61, 32, 116, 104, 105, 115,
+		46, 103, 101, 116, 109, 97, 114, 107, 40, 34, 98, 109, 97, 116, 99, 104,
+		34, 41, 59, 10, 9, 9, 105, 102, 40, 33, 109, 32, 124, 124, 32, 109,
+		46, 112, 111, 115, 32, 60, 32, 108, 110, 46, 111, 102, 102, 32, 124, 124,
+		32, 109, 46, 112, 111, 115, 32, 62, 61, 32, 108, 110, 46, 111, 102, 102,
+		43, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32,
+		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125,
 		10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105,
-		115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 108, 110, 104,
-		116, 32, 61, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 59,
-		10, 9, 9, 118, 97, 114, 32, 97, 118, 97, 105, 108, 32, 61, 32, 116,
-		104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 32, 45, 32, 50, 42,
-		116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 32, 45, 32,
-		49, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32, 40, 108, 110,
-		46, 108, 110, 105, 45, 116, 104, 105, 115, 46, 108, 110, 48, 46, 108, 110,
-		105, 41, 42, 108, 110, 104, 116, 59, 10, 9, 9, 105, 102, 40, 121, 32,
-		62, 32, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 41,
-		32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97, 108,
-		115, 101, 59, 10, 9, 9, 125, 10, 10, 9, 9, 47, 47, 32, 110, 111,
-		110, 45, 101, 109, 112, 116, 121, 32, 115, 101, 108, 101, 99, 116, 105, 111,
-		110, 46, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32,
-		33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 32, 123, 10, 9, 9,
-		9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32, 108, 110,
-		46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103,
-		116, 104, 32, 124, 124, 32, 116, 104, 105, 115, 46, 112, 49, 32, 60, 32,
-		108, 110, 46, 111, 102, 102, 41, 123, 10, 9, 9, 9, 9, 47, 47, 32,
-		117, 110, 115, 101, 108, 101, 99, 116, 101, 100, 32, 108, 105, 110, 101, 10,
-		9, 9, 9, 9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116,
-		40, 99, 116, 120, 44, 32, 49, 44, 32, 121, 44, 32, 116, 104, 105, 115,
-		46, 99, 46, 119, 105, 100, 116, 104, 45, 116, 104, 105, 115, 46, 109, 97,
-		114, 103, 105, 110, 115, 122, 45, 49, 44, 32, 108, 110, 104, 116, 41, 59,
-		10, 9, 9, 9, 9, 118, 97, 114, 32, 116, 32, 61, 32, 116, 104, 105,
-		115, 46, 116, 97, 98, 116, 120, 116, 40, 108, 110, 46, 116, 120, 116, 41,
-		59, 10, 9, 9, 9, 9, 99, 116, 120, 70, 105, 108, 108, 84, 101, 120,
-		116, 40, 99, 116, 120, 44, 32, 116, 44, 32, 116, 104, 105, 115, 46, 109,
-		97, 114, 103, 105, 110, 115, 122, 44, 32, 121, 41, 59, 10, 9, 9, 9,
-		9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 9,
-		9, 125, 10, 9, 9, 9, 47, 47, 32, 117, 112, 32, 116, 111, 32, 112,
-		48, 32, 117, 110, 115, 101, 108, 101, 99, 116, 101, 100, 10, 9, 9, 9,
-		118, 97, 114, 32, 100, 120, 32, 61, 32, 116, 104, 105, 115, 46, 109, 97,
-		114, 103, 105, 110, 115, 122, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115,
-		48, 32, 61, 32, 48, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 48,
-		112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9, 9, 105, 102, 40, 116,
-		104, 105, 115, 46, 112, 48, 32, 62, 32, 108, 110, 46, 111, 102, 102, 41,
-		123, 10, 9, 9, 9, 9, 115, 48, 32, 61, 32, 116, 104, 105, 115, 46,
-		112, 48, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9, 9,
-		9, 118, 97, 114, 32, 115, 48, 116, 32, 61, 32, 116, 104, 105, 115, 46,
-		116, 97, 98, 116, 120, 116, 40, 108, 110, 46, 116, 120, 116, 46, 115, 108,
-		105, 99, 101, 40, 48, 44, 32, 115, 48, 41, 41, 59, 10, 9, 9, 9,
-		9, 115, 48, 112, 111, 115, 32, 61, 32, 115, 48, 116, 46, 108, 101, 110,
-		103, 116, 104, 59, 10, 9, 9, 9, 9, 100, 120, 32, 43, 61, 32, 99,
-		116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 115,
-		48, 116, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 9, 9, 9, 99,
-		116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99, 116, 120, 44,
-		32, 49, 44, 32, 121, 44, 32, 100, 120, 44, 32, 108, 110, 104, 116, 41,
-		59, 10, 9, 9, 9, 9, 99, 116, 120, 70, 105, 108, 108, 84, 101, 120,
-		116, 40, 99, 116, 120, 44, 32, 115, 48, 116, 44, 32, 116, 104, 105, 115,
-		46, 109, 97, 114, 103, 105, 110, 115, 122, 44, 32, 121, 41, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 9, 47, 47, 32, 102, 114, 111, 109, 32, 112,
-		48, 32, 116, 111, 32, 112, 49, 32, 115, 101, 108, 101, 99, 116, 101, 100,
-		10, 9, 9, 9, 118, 97, 114, 32, 115, 49, 32, 61, 32, 108, 110, 46,
-		116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 32, 45, 32, 115, 48, 59,
-		10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 49, 32, 60,
-		32, 108, 110, 46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116, 46, 108,
-		101, 110, 103, 116, 104, 41, 10, 9, 9, 9, 9, 115, 49, 32, 61, 32,
-		116, 104, 105, 115, 46, 112, 49, 32, 45, 32, 115, 48, 32, 45, 32, 108,
-		110, 46, 111, 102, 102, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 49,
-		116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 97, 98, 116, 120, 116, 40,
-		108, 110, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 115, 48, 44,
-		32, 115, 48, 43, 115, 49, 41, 44, 32, 115, 48, 112, 111, 115, 41, 59,
-		10, 9, 9, 9, 118, 97, 114, 32, 115, 49, 112, 111, 115, 32, 61, 32,
-		115, 48, 112, 111, 115, 32, 43, 32, 115, 49, 116, 46, 108, 101, 110, 103,
-		116, 104, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 120, 32, 61, 32,
-		99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40,
-		115, 49, 116, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 9, 9, 118,
-		97, 114, 32, 111, 108, 100, 32, 61, 32, 99, 116, 120, 46, 102, 105, 108,
-		108, 83, 116, 121, 108, 101, 59, 10, 9, 9, 9, 105, 102, 40, 116, 104,
-		105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 32, 62, 61, 32,
-		50, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102, 105, 108,
-		108, 83, 116, 121, 108, 101, 32, 61, 32, 34, 35, 70, 70, 55, 53, 55,
-		53, 34, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 105, 102,
-		40, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121, 41,
-		32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83,
-		116, 121, 108, 101, 32, 61, 32, 34, 35, 55, 51, 55, 51, 70, 70, 34,
-		59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32, 123, 10, 9, 9,
-		9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32,
-		61, 32, 34, 35, 68, 49, 65, 48, 65, 48, 34, 59, 10, 9, 9, 9,
-		125, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 49, 32,
-		62, 32, 108, 110, 46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116, 46,
-		108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116,
-		120, 46, 102, 105, 108, 108, 82, 101, 99, 116, 40, 100, 120, 44, 32, 121,
-		44, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 45, 100,
-		120, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 45,
-		49, 44, 32, 108, 110, 104, 116, 41, 59, 10, 9, 9, 9, 125, 32, 101,
-		108, 115, 101, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102, 105,
-		108, 108, 82, 101, 99, 116, 40, 100, 120, 44, 32, 121, 44, 32, 115, 120,
-		44, 32, 108, 110, 104, 116, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 99, 116, 120, 70, 105, 108, 108, 84, 101, 120, 116, 40, 99, 116, 120,
-		44, 32, 115, 49, 116, 44, 32, 100, 120, 44, 32, 121, 41, 59, 10, 9,
-		9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32,
-		61, 32, 111, 108, 100, 59, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105,
-		115, 46, 112, 49, 32, 62, 32, 108, 110, 46, 111, 102, 102, 43, 108, 110,
-		46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9,
-		9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10,
-		9, 9, 9, 125, 10, 9, 9, 9, 47, 47, 32, 102, 114, 111, 109, 32,
-		112, 49, 32, 117, 110, 115, 101, 108, 101, 99, 116, 101, 100, 10, 9, 9,
-		9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99, 116,
-		120, 44, 32, 100, 120, 43, 115, 120, 44, 32, 121, 44, 32, 116, 104, 105,
-		115, 46, 99, 46, 119, 105, 100, 116, 104, 45, 40, 100, 120, 43, 115, 120,
-		41, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 45,
-		49, 44, 32, 108, 110, 104, 116, 41, 59, 10, 9, 9, 9, 105, 102, 40,
-		115, 49, 32, 62, 61, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110,
-		103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114,
-		110, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		118, 97, 114, 32, 115, 50, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116,
-		97, 98, 116, 120, 116, 40, 108, 110, 46, 116, 120, 116, 46, 115, 108, 105,
-		99, 101, 40, 115, 48, 43, 115, 49, 44, 32, 108, 110, 46, 116, 120, 116,
-		46, 108, 101, 110, 103, 116, 104, 41, 44, 32, 115, 49, 112, 111, 115, 41,
-		59, 10, 9, 9, 9, 99, 116, 120, 70, 105, 108, 108, 84, 101, 120, 116,
-		40, 99, 116, 120, 44, 32, 115, 50, 116, 44, 32, 100, 120, 43, 115, 120,
-		44, 32, 121, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32,
-		116, 114, 117, 101, 59, 10, 9, 9, 125, 10, 10, 9, 9, 47, 47, 32,
-		117, 110, 115, 101, 108, 101, 99, 116, 101, 100, 32, 108, 105, 110, 101, 10,
-		9, 9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99,
-		116, 120, 44, 32, 49, 44, 32, 121, 44, 32, 116, 104, 105, 115, 46, 99,
-		46, 119, 105, 100, 116, 104, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103,
-		105, 110, 115, 122, 45, 49, 44, 32, 108, 110, 104, 116, 41, 59, 10, 9,
-		9, 118, 97, 114, 32, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 97,
-		98, 116, 120, 116, 40, 108, 110, 46, 116, 120, 116, 41, 59, 10, 9, 9,
-		99, 116, 120, 70, 105, 108, 108, 84, 101, 120, 116, 40, 99, 116, 120, 44,
-		32, 116, 44, 32, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115,
-		122, 44, 32, 121, 41, 59, 10, 10, 9, 9, 105, 102, 40, 116, 104, 105,
-		115, 46, 112, 48, 32, 60, 32, 108, 110, 46, 111, 102, 102, 32, 124, 124,
-		32, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32, 108, 110, 46, 111, 102,
-		102, 32, 43, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116,
-		104, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116,
-		114, 117, 101, 59, 10, 9, 9, 125, 10, 10, 9, 9, 47, 47, 32, 108,
-		105, 110, 101, 32, 119, 105, 116, 104, 32, 116, 105, 99, 107, 10, 9, 9,
-		118, 97, 114, 32, 120, 32, 61, 32, 116, 104, 105, 115, 46, 112, 111, 115,
-		100, 120, 40, 108, 110, 46, 116, 120, 116, 44, 32, 116, 104, 105, 115, 46,
-		112, 48, 32, 45, 32, 108, 110, 46, 111, 102, 102, 41, 59, 10, 9, 9,
-		120, 32, 43, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110,
-		115, 122, 32, 45, 32, 51, 42, 116, 104, 105, 115, 46, 116, 115, 99, 97,
-		108, 101, 47, 50, 59, 9, 47, 47, 32, 97, 32, 98, 105, 116, 32, 116,
-		111, 32, 116, 104, 101, 32, 108, 101, 102, 116, 10, 9, 9, 116, 104, 105,
-		115, 46, 116, 105, 99, 107, 40, 120, 44, 32, 121, 41, 59, 10, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 125, 59,
-		10, 10, 9, 116, 104, 105, 115, 46, 117, 112, 100, 97, 116, 101, 115, 99,
-		114, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32,
+		115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61,
+		32, 40, 108, 110, 46, 108, 110, 105, 45, 116, 104, 105, 115, 46, 108, 110,
+		48, 46, 108, 110, 105, 41, 42, 116, 104, 105, 115, 46, 102, 111, 110, 116,
+		104, 116, 59, 10, 9, 9, 118, 97, 114, 32, 120, 48, 32, 61, 32, 116,
+		104, 105, 115, 46, 112, 111, 115, 100, 120, 40, 108, 110, 46, 116, 120, 116,
+		44, 32, 109, 46, 112, 111, 115, 45, 108, 110, 46, 111, 102, 102, 41, 32,
+		43, 32, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110, 40, 41,
+		59, 10, 9, 9, 118, 97, 114, 32, 120, 49, 32, 61, 32, 116, 104, 105,
+		115, 46, 112, 111, 115, 100, 120, 40, 108, 110, 46, 116, 120, 116, 44, 32,
+		109, 46, 112, 111, 115, 45, 108, 110, 46, 111, 102, 102, 43, 49, 41, 32,
+		43, 32, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110, 40, 41,
+		59, 10, 9, 9, 118, 97, 114, 32, 111, 108, 100, 32, 61, 32, 99, 116,
+		120, 46, 115, 116, 114, 111, 107, 101, 83, 116, 121, 108, 101, 59, 10, 9,
+		9, 118, 97, 114, 32, 111, 108, 100, 119, 32, 61, 32, 99, 116, 120, 46,
+		108, 105, 110, 101, 87, 105, 100, 116, 104, 59, 10, 9, 9, 99, 116, 120,
+		46, 115, 116, 114, 111, 107, 101, 83, 116, 121, 108, 101, 32, 61, 32, 34,
+		35, 55, 51, 55, 51, 70, 70, 34, 59, 10, 9, 9, 99, 116, 120, 46,
+		108, 105, 110, 101, 87, 105, 100, 116, 104, 32, 61, 32, 116, 104, 105, 115,
+		46, 116, 115, 99, 97, 108, 101, 47, 50, 59, 10, 9, 9, 99, 116, 120,
+		46, 115, 116, 114, 111, 107, 101, 82, 101, 99, 116, 40, 120, 48, 44, 32,
+		121, 44, 32, 120, 49, 45, 120, 48, 44, 32, 116, 104, 105, 115, 46, 102,
+		111, 110, 116, 104, 116, 45, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108,
+		101, 41, 59, 10, 9, 9, 99, 116, 120, 46, 115, 116, 114, 111, 107, 101,
+		83, 116, 121, 108, 101, 32, 61, 32, 111, 108, 100, 59, 10, 9, 9, 99,
+		116, 120, 46, 108, 105, 110, 101, 87, 105, 100, 116, 104, 32, 61, 32, 111,
+		108, 100, 119, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46,
+		105, 115, 115, 112, 97, 110, 109, 97, 114, 107, 32, 61, 32, 102, 117, 110,
+		99, 116, 105, 111, 110, 40, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9,
+		114, 101, 116, 117, 114, 110, 32, 109, 97, 114, 107, 46, 105, 110, 100, 101,
+		120, 79, 102, 40, 34, 115, 112, 97, 110, 58, 34, 41, 32, 61, 61, 32,
+		48, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 117, 110, 100, 101,
+		114, 108, 105, 110, 101, 32, 116, 104, 101, 32, 115, 112, 97, 110, 115, 32,
+		40, 115, 112, 97, 110, 58, 110, 97, 109, 101, 46, 48, 32, 116, 111, 32,
+		115, 112, 97, 110, 58, 110, 97, 109, 101, 46, 49, 32, 109, 97, 114, 107,
+		115, 41, 32, 105, 110, 32, 116, 104, 105, 115, 32, 108, 105, 110, 101, 46,
+		10, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 115, 112, 97, 110, 115,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 41, 32,
 		123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104,
-		105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121, 48,
-		32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 108, 110, 105, 32,
-		47, 32, 116, 104, 105, 115, 46, 108, 110, 101, 46, 108, 110, 105, 32, 42,
-		32, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 59, 10,
-		9, 9, 118, 97, 114, 32, 100, 121, 32, 61, 32, 116, 104, 105, 115, 46,
-		102, 114, 108, 105, 110, 101, 115, 32, 47, 32, 116, 104, 105, 115, 46, 108,
-		110, 101, 46, 108, 110, 105, 32, 42, 32, 116, 104, 105, 115, 46, 99, 46,
-		104, 101, 105, 103, 104, 116, 59, 10, 9, 10, 9, 9, 99, 116, 120, 67,
-		108, 101, 97, 114, 82, 101, 99, 116, 40, 99, 116, 120, 44, 32, 116, 104,
-		105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 45, 116, 104, 105, 115, 46,
-		109, 97, 114, 103, 105, 110, 115, 122, 44, 32, 48, 44, 32, 116, 104, 105,
-		115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 44, 32, 121, 48, 41, 59,
-		10, 9, 9, 118, 97, 114, 32, 111, 108, 100, 32, 61, 32, 99, 116, 120,
-		46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 59, 10, 9, 9, 99, 116,
-		120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 34, 35,
-		55, 51, 55, 51, 70, 70, 34, 59, 10, 9, 9, 99, 116, 120, 46, 102,
-		105, 108, 108, 82, 101, 99, 116, 40, 116, 104, 105, 115, 46, 99, 46, 119,
-		105, 100, 116, 104, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110,
-		115, 122, 44, 32, 121, 48, 44, 32, 116, 104, 105, 115, 46, 109, 97, 114,
-		103, 105, 110, 115, 122, 44, 32, 100, 121, 41, 59, 10, 9, 9, 99, 116,
+		105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32,
+		61, 32, 40, 108, 110, 46, 108, 110, 105, 45, 116, 104, 105, 115, 46, 108,
+		110, 48, 46, 108, 110, 105, 41, 42, 116, 104, 105, 115, 46, 102, 111, 110,
+		116, 104, 116, 59, 10, 9, 9, 118, 97, 114, 32, 101, 110, 100, 32, 61,
+		32, 108, 110, 46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 116, 120, 116,
+		46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 102, 111, 114, 40, 118,
+		97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 104,
+		105, 115, 46, 109, 97, 114, 107, 115, 46, 108, 101, 110, 103, 116, 104, 59,
+		32, 105, 43, 43, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 109,
+		48, 32, 61, 32, 116, 104, 105, 115, 46, 109, 97, 114, 107, 115, 91, 105,
+		93, 59, 10, 9, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 105,
+		115, 115, 112, 97, 110, 109, 97, 114, 107, 40, 109, 48, 46, 110, 97, 109,
+		101, 41, 32, 124, 124, 32, 109, 48, 46, 110, 97, 109, 101, 46, 115, 108,
+		105, 99, 101, 40, 45, 50, 41, 32, 33, 61, 32, 34, 46, 48, 34, 41,
+		32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59,
+		10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 109, 49, 32,
+		61, 32, 116, 104, 105, 115, 46, 103, 101, 116, 109, 97, 114, 107, 40, 109,
+		48, 46, 110, 97, 109, 101, 46, 115, 108, 105, 99, 101, 40, 48, 44, 32,
+		45, 50, 41, 32, 43, 32, 34, 46, 49, 34, 41, 59, 10, 9, 9, 9,
+		105, 102, 40, 33, 109, 49, 32, 124, 124, 32, 109, 49, 46, 112, 111, 115,
+		32, 60, 61, 32, 109, 48, 46, 112, 111, 115, 32, 124, 124, 32, 109, 48,
+		46, 112, 111, 115, 32, 62, 32, 101, 110, 100, 32, 124, 124, 32, 109, 49,
+		46, 112, 111, 115, 32, 60, 32, 108, 110, 46, 111, 102, 102, 41, 32, 123,
+		10, 9, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101, 59, 10, 9,
+		9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 115, 48, 32, 61, 32,
+		77, 97, 116, 104, 46, 109, 97, 120, 40, 109, 48, 46, 112, 111, 115, 44,
+		32, 108, 110, 46, 111, 102, 102, 41, 32, 45, 32, 108, 110, 46, 111, 102,
+		102, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 49, 32, 61, 32, 77,
+		97, 116, 104, 46, 109, 105, 110, 40, 109, 49, 46, 112, 111, 115, 44, 32,
+		101, 110, 100, 41, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9,
+		9, 9, 118, 97, 114, 32, 120, 48, 32, 61, 32, 116, 104, 105, 115, 46,
+		112, 111, 115, 100, 120, 40, 108, 110, 46, 116, 120, 116, 44, 32, 115, 48,
+		41, 32, 43, 32, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110,
+		40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 120, 49, 32, 61, 32,
+		116, 104, 105, 115, 46, 112, 111, 115, 100, 120, 40, 108, 110, 46, 116, 120,
+		116, 44, 32, 115, 49, 41, 32, 43, 32, 116, 104, 105, 115, 46, 108, 109,
+		97, 114, 103, 105, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32,
+		111, 108, 100, 102, 115, 32, 61, 32, 99, 116, 120, 46, 102, 105, 108, 108,
+		83, 116, 121, 108, 101, 59, 10, 9, 9, 9, 99, 116, 120, 46, 102, 105,
+		108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 34, 35, 70, 70, 51, 48,
+		51, 48, 34, 59, 10, 9, 9, 9, 99, 116, 120, 46, 102, 105, 108, 108,
+		82, 101, 99, 116, 40, 120, 48, 44, 32, 121, 43, 116, 104, 105, 115, 46,
+		102, 111, 110, 116, 104, 116, 45, 116, 104, 105, 115, 46, 116, 115, 99, 97,
+		108, 101, 44, 32, 120, 49, 45, 120, 48, 44, 32, 116, 104, 105, 115, 46,
+		116, 115, 99, 97, 108, 101, 47, 50, 41, 59, 10, 9, 9, 9, 99, 116,
 		120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 111, 108,
-		100, 59, 10, 9, 9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99,
-		116, 40, 99, 116, 120, 44, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105,
-		100, 116, 104, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115,
-		122, 44, 32, 121, 48, 43, 100, 121, 44, 10, 9, 9, 9, 116, 104, 105,
-		115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 44, 32, 116, 104, 105, 115,
-		46, 99, 46, 104, 101, 105, 103, 104, 116, 45, 40, 121, 48, 43, 100, 121,
-		41, 41, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 114,
-		101, 100, 114, 97, 119, 116, 101, 120, 116, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 116, 104, 105, 115, 46,
-		102, 105, 120, 102, 111, 110, 116, 40, 41, 59, 10, 9, 9, 116, 104, 105,
-		115, 46, 110, 108, 105, 110, 101, 115, 32, 61, 32, 77, 97, 116, 104, 46,
-		102, 108, 111, 111, 114, 40, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105,
-		103, 104, 116, 47, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41,
-		59, 10, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 116, 105, 99,
-		107, 105, 109, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		109, 107, 116, 105, 99, 107, 40, 41, 59, 10, 9, 9, 125, 10, 9, 9,
-		105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 110, 48, 41, 32, 123, 10,
-		9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34,
-		114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 58, 32, 110, 111, 32, 108,
-		110, 48, 34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59,
-		10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 102, 114, 111, 102, 102,
-		32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 111, 102, 102, 59,
-		10, 9, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 32, 61,
-		32, 48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110,
-		101, 115, 32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 108, 110,
-		32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 59, 10, 9, 9, 102,
-		111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32,
-		60, 61, 32, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115, 59, 32,
-		105, 43, 43, 41, 123, 10, 9, 9, 9, 105, 102, 40, 108, 110, 32, 33,
-		61, 32, 110, 117, 108, 108, 41, 123, 10, 9, 9, 9, 9, 105, 102, 40,
-		33, 116, 104, 105, 115, 46, 100, 114, 97, 119, 108, 105, 110, 101, 40, 108,
-		110, 41, 41, 10, 9, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10,
-		9, 9, 9, 9, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115,
-		43, 43, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 102, 114, 115,
-		105, 122, 101, 32, 43, 61, 32, 108, 110, 46, 108, 101, 110, 40, 41, 59,
-		10, 9, 9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120,
-		116, 59, 10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 105, 102, 40, 33,
-		116, 104, 105, 115, 46, 99, 108, 101, 97, 114, 108, 105, 110, 101, 40, 105,
-		41, 41, 32, 123, 10, 9, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
-		10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116,
-		100, 101, 98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
-		103, 40, 34, 114, 101, 100, 114, 97, 119, 32, 34, 32, 43, 32, 105, 32,
-		43, 32, 34, 32, 34, 32, 43, 32, 116, 104, 105, 115, 46, 110, 108, 105,
-		110, 101, 115, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 117, 112, 100,
-		97, 116, 101, 115, 99, 114, 108, 40, 41, 59, 10, 9, 125, 59, 10, 10,
-		9, 47, 47, 32, 114, 101, 113, 117, 105, 114, 101, 115, 32, 97, 32, 114,
-		101, 100, 114, 97, 119, 32, 105, 102, 32, 114, 101, 116, 117, 114, 110, 115,
-		32, 116, 114, 117, 101, 46, 10, 9, 116, 104, 105, 115, 46, 115, 99, 114,
-		111, 108, 108, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 110, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 111, 108,
-		100, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 59, 10, 9, 9,
-		102, 111, 114, 40, 59, 32, 110, 32, 62, 32, 48, 59, 32, 110, 45, 45,
-		41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46,
-		108, 110, 48, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9, 9, 9,
-		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116,
-		104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46, 108,
-		110, 48, 46, 112, 114, 101, 118, 59, 10, 9, 9, 125, 10, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 116, 104, 105, 115, 46, 108, 110, 48, 32, 33,
-		61, 32, 111, 108, 100, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32,
-		114, 101, 113, 117, 105, 114, 101, 115, 32, 97, 32, 114, 101, 100, 114, 97,
-		119, 32, 105, 102, 32, 114, 101, 116, 117, 114, 110, 115, 32, 116, 114, 117,
-		101, 46, 10, 9, 116, 104, 105, 115, 46, 115, 99, 114, 111, 108, 108, 117,
-		112, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 110, 41, 32,
-		123, 10, 9, 9, 118, 97, 114, 32, 111, 108, 100, 32, 61, 32, 116, 104,
-		105, 115, 46, 108, 110, 48, 59, 10, 9, 9, 102, 111, 114, 40, 59, 32,
-		110, 32, 62, 32, 48, 59, 32, 110, 45, 45, 41, 32, 123, 10, 9, 9,
-		9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 110, 48, 46, 110, 101,
-		120, 116, 32, 124, 124, 32, 33, 116, 104, 105, 115, 46, 108, 110, 48, 46,
-		110, 101, 120, 116, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9,
-		9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		116, 104, 105, 115, 46, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46,
-		108, 110, 48, 46, 110, 101, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9,
-		114, 101, 116, 117, 114, 110, 32, 111, 108, 100, 32, 33, 61, 32, 116, 104,
-		105, 115, 46, 108, 110, 48, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104,
-		105, 115, 46, 110, 115, 99, 114, 108, 32, 61, 32, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 110, 115,
-		99, 114, 108, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114,
-		40, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115, 47, 52, 41, 59,
-		10, 9, 9, 105, 102, 40, 110, 115, 99, 114, 108, 32, 62, 32, 48, 41,
-		32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 115, 99,
-		114, 108, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 49, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109,
-		97, 121, 115, 99, 114, 111, 108, 108, 105, 110, 115, 32, 61, 32, 102, 117,
-		110, 99, 116, 105, 111, 110, 40, 108, 110, 41, 32, 123, 10, 9, 9, 105,
-		102, 40, 108, 110, 46, 108, 110, 105, 32, 62, 61, 32, 116, 104, 105, 115,
-		46, 108, 110, 48, 46, 108, 110, 105, 43, 116, 104, 105, 115, 46, 110, 108,
-		105, 110, 101, 115, 45, 49, 32, 38, 38, 10, 9, 9, 32, 32, 32, 108,
-		110, 46, 108, 110, 105, 32, 60, 61, 32, 116, 104, 105, 115, 46, 108, 110,
-		48, 46, 108, 110, 105, 43, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101,
-		115, 43, 49, 32, 38, 38, 32, 116, 104, 105, 115, 46, 110, 108, 105, 110,
-		101, 115, 32, 62, 32, 49, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105,
-		115, 46, 115, 99, 114, 111, 108, 108, 100, 111, 119, 110, 40, 116, 104, 105,
-		115, 46, 110, 115, 99, 114, 108, 40, 41, 41, 59, 10, 9, 9, 125, 10,
-		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121, 115, 99,
-		114, 111, 108, 108, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105,
-		111, 110, 40, 108, 110, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104,
-		105, 115, 46, 112, 48, 32, 60, 32, 116, 104, 105, 115, 46, 108, 110, 48,
-		46, 111, 102, 102, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		115, 99, 114, 111, 108, 108, 117, 112, 40, 116, 104, 105, 115, 46, 110, 115,
-		99, 114, 108, 40, 41, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46,
-		114, 101, 100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9,
-		125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 119, 114, 97,
-		112, 111, 102, 102, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
-		116, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61,
-		32, 116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114,
-		32, 97, 118, 97, 105, 108, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46,
-		119, 105, 100, 116, 104, 32, 45, 32, 116, 104, 105, 115, 46, 109, 97, 114,
-		103, 105, 110, 115, 122, 59, 10, 9, 9, 118, 97, 114, 32, 112, 111, 115,
-		32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 115, 32, 61, 32,
-		34, 34, 59, 10, 9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41,
-		32, 123, 10, 9, 9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111,
-		103, 40, 34, 119, 114, 97, 112, 111, 102, 102, 58, 32, 88, 32, 119, 105,
-		100, 58, 32, 34, 32, 43, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117,
-		114, 101, 84, 101, 120, 116, 40, 34, 88, 34, 41, 46, 119, 105, 100, 116,
-		104, 41, 59, 10, 9, 9, 125, 10, 9, 9, 102, 111, 114, 40, 118, 97,
-		114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 46, 108,
-		101, 110, 103, 116, 104, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9,
-		118, 97, 114, 32, 114, 32, 61, 32, 116, 46, 99, 104, 97, 114, 65, 116,
-		40, 105, 41, 59, 10, 9, 9, 9, 105, 102, 40, 114, 32, 61, 61, 32,
-		39, 92, 116, 39, 41, 32, 123, 10, 9, 9, 9, 9, 100, 111, 32, 123,
-		10, 9, 9, 9, 9, 9, 115, 32, 43, 61, 32, 34, 32, 34, 59, 10,
-		9, 9, 9, 9, 9, 112, 111, 115, 43, 43, 59, 10, 9, 9, 9, 9,
-		125, 119, 104, 105, 108, 101, 40, 112, 111, 115, 37, 116, 104, 105, 115, 46,
-		116, 97, 98, 115, 116, 111, 112, 41, 59, 10, 9, 9, 9, 125, 101, 108,
-		115, 101, 123, 10, 9, 9, 9, 9, 112, 111, 115, 43, 43, 59, 10, 9,
-		9, 9, 9, 115, 32, 43, 61, 32, 114, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 9, 105, 102, 40, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114,
-		101, 84, 101, 120, 116, 40, 115, 41, 46, 119, 105, 100, 116, 104, 32, 62,
-		32, 97, 118, 97, 105, 108, 41, 123, 10, 9, 9, 9, 9, 105, 102, 40,
-		116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9, 9, 9, 9, 9, 99,
-		111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 39, 119, 114, 97, 112,
-		111, 102, 102, 58, 32, 39, 32, 43, 32, 115, 32, 43, 32, 39, 58, 32,
-		119, 114, 97, 112, 58, 32, 39, 32, 43, 32, 99, 116, 120, 46, 109, 101,
-		97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 115, 41, 46, 119, 105, 100,
-		116, 104, 32, 43, 32, 34, 32, 34, 32, 43, 32, 97, 118, 97, 105, 108,
-		41, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 32, 105, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10,
-		9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9,
-		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 39, 119,
-		114, 97, 112, 111, 102, 102, 58, 32, 39, 32, 43, 32, 115, 32, 43, 32,
-		39, 58, 32, 110, 111, 32, 119, 114, 97, 112, 58, 32, 39, 32, 43, 32,
-		99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40,
-		115, 41, 46, 119, 105, 100, 116, 104, 32, 43, 32, 34, 32, 34, 32, 43,
-		32, 97, 118, 97, 105, 108, 41, 59, 10, 9, 9, 125, 10, 9, 9, 114,
-		101, 116, 117, 114, 110, 32, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10,
-		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 112, 111, 115, 100, 120,
-		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 116, 44, 32, 110,
+		100, 102, 115, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 118,
+		97, 114, 32, 118, 105, 101, 119, 101, 114, 99, 111, 108, 111, 114, 115, 32,
+		61, 32, 91, 34, 35, 50, 69, 56, 66, 53, 55, 34, 44, 32, 34, 35,
+		66, 56, 56, 54, 48, 66, 34, 44, 32, 34, 35, 56, 65, 50, 66, 69,
+		50, 34, 44, 32, 34, 35, 68, 50, 54, 57, 49, 69, 34, 44, 32, 34,
+		35, 49, 69, 57, 48, 70, 70, 34, 44, 32, 34, 35, 67, 55, 49, 53,
+		56, 53, 34, 93, 59, 10, 10, 9, 116, 104, 105, 115, 46, 97, 100, 100,
+		118, 105, 101, 119, 101, 114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 105, 100, 44, 32, 119, 104, 111, 41, 32, 123, 10, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 118, 105, 101, 119, 101, 114, 115, 91, 105,
+		100, 93, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 118, 105,
+		101, 119, 101, 114, 115, 91, 105, 100, 93, 46, 119, 104, 111, 32, 61, 32,
+		119, 104, 111, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10,
+		9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 99, 32, 61, 32, 118, 105,
+		101, 119, 101, 114, 99, 111, 108, 111, 114, 115, 91, 116, 104, 105, 115, 46,
+		110, 118, 105, 101, 119, 101, 114, 115, 37, 118, 105, 101, 119, 101, 114, 99,
+		111, 108, 111, 114, 115, 46, 108, 101, 110, 103, 116, 104, 93, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 110, 118, 105, 101, 119, 101, 114, 115, 43, 43,
+		59, 10, 9, 9, 116, 104, 105, 115, 46, 118, 105, 101, 119, 101, 114, 115,
+		91, 105, 100, 93, 32, 61, 32, 123, 119, 104, 111, 58, 32, 119, 104, 111,
+		44, 32, 99, 111, 108, 111, 114, 58, 32, 99, 125, 59, 10, 9, 125, 59,
+		10, 10, 9, 116, 104, 105, 115, 46, 100, 101, 108, 118, 105, 101, 119, 101,
+		114, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 105, 100, 41,
+		32, 123, 10, 9, 9, 100, 101, 108, 101, 116, 101, 32, 116, 104, 105, 115,
+		46, 118, 105, 101, 119, 101, 114, 115, 91, 105, 100, 93, 59, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 105, 115, 118, 105, 101, 119, 101,
+		114, 109, 97, 114, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 109, 97, 114, 107, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 105,
+		100, 32, 61, 32, 109, 97, 114, 107, 46, 115, 108, 105, 99, 101, 40, 48,
+		44, 32, 45, 50, 41, 59, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32,
+		33, 33, 116, 104, 105, 115, 46, 118, 105, 101, 119, 101, 114, 115, 91, 105,
+		100, 93, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 100, 114, 97,
+		119, 32, 116, 104, 101, 32, 115, 101, 108, 101, 99, 116, 105, 111, 110, 115,
+		32, 111, 102, 32, 111, 116, 104, 101, 114, 32, 118, 105, 101, 119, 115, 32,
+		105, 110, 32, 116, 104, 105, 115, 32, 108, 105, 110, 101, 44, 32, 105, 102,
+		32, 97, 110, 121, 46, 10, 9, 47, 47, 32, 112, 48, 32, 105, 115, 32,
+		115, 104, 111, 119, 110, 32, 97, 115, 32, 97, 32, 98, 97, 114, 32, 119,
+		105, 116, 104, 32, 116, 104, 101, 32, 110, 97, 109, 101, 32, 111, 102, 32,
+		116, 104, 101, 32, 118, 105, 101, 119, 101, 114, 32, 97, 110, 100, 10, 9,
+		47, 47, 32, 116, 104, 101, 32, 114, 101, 115, 116, 32, 111, 102, 32, 116,
+		104, 101, 32, 115, 101, 108, 101, 99, 116, 105, 111, 110, 32, 105, 115, 32,
+		117, 110, 100, 101, 114, 108, 105, 110, 101, 100, 46, 10, 9, 116, 104, 105,
+		115, 46, 100, 114, 97, 119, 118, 105, 101, 119, 101, 114, 115, 32, 61, 32,
+		102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 41, 32, 123, 10, 9,
+		9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115, 46,
+		99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32, 40,
+		108, 110, 46, 108, 110, 105, 45, 116, 104, 105, 115, 46, 108, 110, 48, 46,
+		108, 110, 105, 41, 42, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116,
+		59, 10, 9, 9, 118, 97, 114, 32, 101, 110, 100, 32, 61, 32, 108, 110,
+		46, 111, 102, 102, 32, 43, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101,
+		110, 103, 116, 104, 59, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32,
+		105, 100, 32, 105, 110, 32, 116, 104, 105, 115, 46, 118, 105, 101, 119, 101,
+		114, 115, 41, 32, 123, 10, 9, 9, 9, 118, 97, 114, 32, 118, 32, 61,
+		32, 116, 104, 105, 115, 46, 118, 105, 101, 119, 101, 114, 115, 91, 105, 100,
+		93, 59, 10, 9, 9, 9, 118, 97, 114, 32, 109, 48, 32, 61, 32, 116,
+		104, 105, 115, 46, 103, 101, 116, 109, 97, 114, 107, 40, 105, 100, 43, 34,
+		112, 48, 34, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 109, 49, 32,
+		61, 32, 116, 104, 105, 115, 46, 103, 101, 116, 109, 97, 114, 107, 40, 105,
+		100, 43, 34, 112, 49, 34, 41, 59, 10, 9, 9, 9, 105, 102, 40, 33,
+		109, 48, 32, 124, 124, 32, 33, 109, 49, 41, 32, 123, 10, 9, 9, 9,
+		9, 99, 111, 110, 116, 105, 110, 117, 101, 59, 10, 9, 9, 9, 125, 10,
+		9, 9, 9, 118, 97, 114, 32, 112, 48, 32, 61, 32, 109, 48, 46, 112,
+		111, 115, 59, 10, 9, 9, 9, 118, 97, 114, 32, 112, 49, 32, 61, 32,
+		109, 49, 46, 112, 111, 115, 59, 10, 9, 9, 9, 105, 102, 40, 112, 49,
+		32, 60, 32, 112, 48, 32, 124, 124, 32, 112, 48, 32, 62, 32, 101, 110,
+		100, 32, 124, 124, 32, 112, 49, 32, 60, 32, 108, 110, 46, 111, 102, 102,
+		41, 32, 123, 10, 9, 9, 9, 9, 99, 111, 110, 116, 105, 110, 117, 101,
+		59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32, 111, 108,
+		100, 102, 115, 32, 61, 32, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116,
+		121, 108, 101, 59, 10, 9, 9, 9, 118, 97, 114, 32, 111, 108, 100, 102,
+		32, 61, 32, 99, 116, 120, 46, 102, 111, 110, 116, 59, 10, 9, 9, 9,
+		99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32,
+		118, 46, 99, 111, 108, 111, 114, 59, 10, 9, 9, 9, 118, 97, 114, 32,
+		115, 48, 32, 61, 32, 77, 97, 116, 104, 46, 109, 97, 120, 40, 112, 48,
+		44, 32, 108, 110, 46, 111, 102, 102, 41, 32, 45, 32, 108, 110, 46, 111,
+		102, 102, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 49, 32, 61, 32,
+		77, 97, 116, 104, 46, 109, 105, 110, 40, 112, 49, 44, 32, 101, 110, 100,
+		41, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9, 9, 118,
+		97, 114, 32, 120, 48, 32, 61, 32, 116, 104, 105, 115, 46, 112, 111, 115,
+		100, 120, 40, 108, 110, 46, 116, 120, 116, 44, 32, 115, 48, 41, 32, 43,
+		32, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110, 40, 41, 59,
+		10, 9, 9, 9, 118, 97, 114, 32, 120, 49, 32, 61, 32, 116, 104, 105,
+		115, 46, 112, 111, 115, 100, 120, 40, 108, 110, 46, 116, 120, 116, 44, 32,
+		115, 49, 41, 32, 43, 32, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103,
+		105, 110, 40, 41, 59, 10, 9, 9, 9, 105, 102, 40, 120, 49, 32, 62,
+		32, 120, 48, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102,
+		105, 108, 108, 82, 101, 99, 116, 40, 120, 48, 44, 32, 121, 43, 116, 104,
+		105, 115, 46, 102, 111, 110, 116, 104, 116, 45, 116, 104, 105, 115, 46, 116,
+		115, 99, 97, 108, 101, 44, 32, 120, 49, 45, 120, 48, 44, 32, 116, 104,
+		105, 115, 46, 116, 115, 99, 97, 108, 101, 41, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 105, 102, 40, 112, 48, 32, 62, 61, 32, 108, 110, 46,
+		111, 102, 102, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102,
+		105, 108, 108, 82, 101, 99, 116, 40, 120, 48, 45, 116, 104, 105, 115, 46,
+		116, 115, 99, 97, 108, 101, 47, 50, 44, 32, 121, 44, 32, 116, 104, 105,
+		115, 46, 116, 115, 99, 97, 108, 101, 44, 32, 116, 104, 105, 115, 46, 102,
+		111, 110, 116, 104, 116, 41, 59, 10, 9, 9, 9, 9, 118, 97, 114, 32,
+		119, 104, 111, 32, 61, 32, 118, 46, 119, 104, 111, 46, 115, 112, 108, 105,
+		116, 40, 34, 64, 34, 41, 91, 48, 93, 59, 10, 9, 9, 9, 9, 99,
+		116, 120, 46, 102, 111, 110, 116, 32, 61, 32, 40, 116, 104, 105, 115, 46,
+		102, 111, 110, 116, 104, 116, 47, 50, 41, 32, 43, 32, 34, 112, 120, 32,
+		34, 32, 43, 32, 116, 102, 102, 105, 120, 101, 100, 59, 10, 9, 9, 9,
+		9, 99, 116, 120, 46, 102, 105, 108, 108, 84, 101, 120, 116, 40, 119, 104,
+		111, 44, 32, 120, 48, 43, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108,
+		101, 44, 32, 121, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 99,
+		116, 120, 46, 102, 111, 110, 116, 32, 61, 32, 111, 108, 100, 102, 59, 10,
+		9, 9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101,
+		32, 61, 32, 111, 108, 100, 102, 115, 59, 10, 9, 9, 125, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 108, 105, 110,
+		101, 49, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110,
 		41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32,
 		116, 104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32,
-		112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 100,
-		120, 32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 115, 112, 99,
-		119, 105, 100, 32, 61, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114,
-		101, 84, 101, 120, 116, 40, 34, 32, 34, 41, 46, 119, 105, 100, 116, 104,
-		59, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32,
-		48, 59, 32, 105, 32, 60, 32, 116, 46, 108, 101, 110, 103, 116, 104, 32,
-		38, 38, 32, 105, 32, 60, 32, 110, 59, 32, 105, 43, 43, 41, 123, 10,
-		9, 9, 9, 118, 97, 114, 32, 114, 32, 61, 32, 116, 46, 99, 104, 97,
-		114, 65, 116, 40, 105, 41, 59, 10, 9, 9, 9, 105, 102, 40, 114, 32,
-		61, 61, 32, 39, 92, 116, 39, 41, 32, 123, 10, 9, 9, 9, 9, 100,
-		111, 32, 123, 10, 9, 9, 9, 9, 9, 100, 120, 32, 43, 61, 32, 115,
-		112, 99, 119, 105, 100, 59, 10, 9, 9, 9, 9, 9, 112, 111, 115, 43,
-		43, 59, 10, 9, 9, 9, 9, 125, 119, 104, 105, 108, 101, 40, 112, 111,
-		115, 37, 116, 104, 105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 41, 59,
-		10, 9, 9, 9, 125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 9, 112,
-		111, 115, 43, 43, 59, 10, 9, 9, 9, 9, 100, 120, 32, 43, 61, 32,
-		99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40,
-		114, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 9, 9, 125, 10, 9,
-		9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 100, 120, 59, 10,
-		9, 125, 59, 10, 10, 9, 47, 47, 32, 114, 101, 116, 117, 114, 110, 115,
-		32, 91, 108, 105, 110, 101, 44, 32, 111, 102, 102, 32, 97, 116, 32, 108,
-		105, 110, 101, 44, 32, 99, 108, 105, 99, 107, 32, 112, 97, 115, 116, 32,
-		116, 101, 120, 116, 63, 93, 10, 9, 47, 47, 32, 108, 97, 116, 101, 114,
-		32, 121, 111, 117, 32, 99, 97, 110, 32, 117, 115, 101, 32, 115, 101, 101,
-		107, 112, 111, 115, 40, 108, 105, 110, 101, 44, 32, 108, 110, 111, 102, 102,
-		41, 32, 116, 111, 32, 103, 101, 116, 32, 97, 32, 118, 97, 108, 105, 100,
-		32, 112, 111, 115, 46, 10, 9, 116, 104, 105, 115, 46, 112, 116, 114, 50,
-		115, 101, 101, 107, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40,
-		99, 120, 44, 32, 99, 121, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32,
-		109, 97, 114, 103, 105, 110, 115, 122, 32, 61, 32, 77, 97, 116, 104, 46,
-		102, 108, 111, 111, 114, 40, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105,
-		110, 115, 122, 47, 50, 41, 59, 10, 9, 9, 118, 97, 114, 32, 120, 32,
-		61, 32, 99, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32,
-		99, 121, 59, 10, 9, 9, 118, 97, 114, 32, 111, 118, 102, 32, 61, 32,
-		48, 59, 10, 9, 9, 120, 32, 42, 61, 32, 116, 104, 105, 115, 46, 116,
-		115, 99, 97, 108, 101, 59, 10, 9, 9, 121, 32, 42, 61, 32, 116, 104,
-		105, 115, 46, 116, 115, 99, 97, 108, 101, 59, 10, 9, 9, 118, 97, 114,
-		32, 110, 108, 110, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111,
-		114, 40, 121, 47, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41,
-		59, 10, 9, 9, 105, 102, 40, 110, 108, 110, 32, 60, 32, 48, 41, 32,
-		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 104, 105,
-		115, 46, 108, 110, 48, 44, 32, 48, 44, 32, 102, 97, 108, 115, 101, 93,
-		59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 110, 108, 110, 32, 62,
-		61, 32, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 41, 32,
-		123, 9, 9, 47, 47, 32, 111, 118, 101, 114, 102, 108, 111, 119, 10, 9,
-		9, 9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 104, 105, 115, 46, 108,
-		110, 101, 44, 32, 116, 104, 105, 115, 46, 108, 110, 101, 46, 116, 120, 116,
-		46, 108, 101, 110, 103, 116, 104, 44, 32, 116, 114, 117, 101, 93, 59, 10,
-		9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 108, 110, 32, 61, 32, 116,
-		104, 105, 115, 46, 108, 110, 48, 59, 10, 9, 9, 119, 104, 105, 108, 101,
-		40, 110, 108, 110, 45, 45, 32, 62, 32, 48, 32, 38, 38, 32, 108, 110,
-		46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 108, 110, 32, 61,
-		32, 108, 110, 46, 110, 101, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9,
-		118, 97, 114, 32, 112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9, 102,
-		111, 114, 40, 59, 32, 112, 111, 115, 32, 60, 61, 32, 108, 110, 46, 116,
-		120, 116, 46, 108, 101, 110, 103, 116, 104, 59, 32, 112, 111, 115, 43, 43,
-		41, 123, 10, 9, 9, 9, 118, 97, 114, 32, 99, 111, 102, 102, 32, 61,
-		32, 116, 104, 105, 115, 46, 112, 111, 115, 100, 120, 40, 108, 110, 46, 116,
-		120, 116, 44, 32, 112, 111, 115, 41, 59, 10, 9, 9, 9, 105, 102, 40,
-		99, 111, 102, 102, 43, 109, 97, 114, 103, 105, 110, 115, 122, 32, 62, 32,
-		120, 41, 123, 10, 9, 9, 9, 9, 105, 102, 40, 112, 111, 115, 32, 62,
-		32, 48, 41, 10, 9, 9, 9, 9, 9, 112, 111, 115, 45, 45, 59, 10,
-		9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 125, 10, 9, 9, 105, 102, 40, 112, 111, 115, 32, 62, 32, 108,
-		110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 123, 10, 9,
-		9, 9, 112, 111, 115, 32, 61, 32, 108, 110, 46, 116, 120, 116, 46, 108,
-		101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110,
-		32, 91, 108, 110, 44, 32, 112, 111, 115, 44, 32, 116, 114, 117, 101, 93,
-		59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 91,
-		108, 110, 44, 32, 112, 111, 115, 44, 32, 102, 97, 108, 115, 101, 93, 59,
-		10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 118, 105, 101, 119,
-		115, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41,
-		32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32,
-		62, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 111, 102, 102, 32,
-		38, 38, 32, 116, 104, 105, 115, 46, 112, 48, 32, 60, 61, 32, 116, 104,
-		105, 115, 46, 108, 110, 48, 46, 111, 102, 102, 43, 116, 104, 105, 115, 46,
-		102, 114, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116,
-		117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 102, 111, 114, 40, 118,
-		97, 114, 32, 108, 110, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 115,
-		59, 32, 108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 108, 110,
-		32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9,
-		9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 61, 32, 108,
-		110, 46, 111, 102, 102, 32, 38, 38, 32, 116, 104, 105, 115, 46, 112, 48,
-		32, 60, 61, 32, 108, 110, 46, 111, 102, 102, 43, 108, 110, 46, 116, 120,
-		116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9,
-		102, 111, 114, 40, 118, 97, 114, 32, 110, 32, 61, 32, 77, 97, 116, 104,
-		46, 102, 108, 111, 111, 114, 40, 116, 104, 105, 115, 46, 102, 114, 108, 105,
-		110, 101, 115, 47, 51, 41, 59, 32, 110, 32, 62, 32, 48, 32, 38, 38,
-		32, 108, 110, 46, 112, 114, 101, 118, 59, 32, 110, 45, 45, 41, 32, 123,
-		10, 9, 9, 9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46, 112, 114,
-		101, 118, 59, 10, 9, 9, 9, 9, 125, 10, 9, 9, 9, 9, 116, 104,
-		105, 115, 46, 108, 110, 48, 32, 61, 32, 108, 110, 59, 10, 9, 9, 9,
-		9, 116, 104, 105, 115, 46, 114, 101, 100, 114, 97, 119, 116, 101, 120, 116,
-		40, 41, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
-		9, 9, 125, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104,
-		105, 115, 46, 115, 101, 116, 115, 101, 108, 32, 61, 32, 102, 117, 110, 99,
-		116, 105, 111, 110, 40, 112, 48, 44, 32, 112, 49, 44, 32, 114, 101, 102,
-		114, 101, 115, 104, 97, 108, 108, 41, 32, 123, 10, 9, 9, 118, 97, 114,
-		32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115, 46, 99, 116, 120, 59,
-		10, 9, 9, 105, 102, 40, 112, 48, 32, 62, 32, 116, 104, 105, 115, 46,
-		110, 114, 117, 110, 101, 115, 41, 32, 123, 10, 9, 9, 9, 112, 48, 32,
-		61, 32, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 59, 10, 9,
-		9, 125, 10, 9, 9, 105, 102, 40, 112, 49, 32, 60, 32, 112, 48, 41,
-		32, 123, 10, 9, 9, 9, 112, 49, 32, 61, 32, 112, 48, 59, 10, 9,
-		9, 125, 10, 9, 9, 105, 102, 40, 112, 49, 32, 62, 32, 116, 104, 105,
-		115, 46, 110, 114, 117, 110, 101, 115, 41, 32, 123, 10, 9, 9, 9, 112,
-		49, 32, 61, 32, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 59,
-		10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112,
-		48, 32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 32, 123, 10,
-		9, 9, 9, 114, 101, 102, 114, 101, 115, 104, 97, 108, 108, 32, 61, 32,
-		116, 114, 117, 101, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
-		102, 114, 111, 102, 102, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48,
-		46, 111, 102, 102, 59, 10, 9, 9, 105, 102, 40, 114, 101, 102, 114, 101,
-		115, 104, 97, 108, 108, 32, 38, 38, 32, 40, 116, 104, 105, 115, 46, 112,
-		49, 32, 60, 102, 114, 111, 102, 102, 32, 124, 124, 32, 116, 104, 105, 115,
-		46, 112, 48, 32, 62, 102, 114, 111, 102, 102, 43, 116, 104, 105, 115, 46,
-		102, 114, 115, 105, 122, 101, 41, 41, 10, 9, 9, 9, 114, 101, 102, 114,
-		101, 115, 104, 97, 108, 108, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10,
-		9, 9, 118, 97, 114, 32, 109, 112, 48, 32, 61, 32, 112, 48, 59, 10,
-		9, 9, 118, 97, 114, 32, 109, 112, 49, 32, 61, 32, 112, 49, 59, 10,
-		9, 9, 105, 102, 40, 114, 101, 102, 114, 101, 115, 104, 97, 108, 108, 41,
-		123, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32,
-		60, 32, 109, 112, 48, 41, 32, 123, 10, 9, 9, 9, 9, 109, 112, 48,
-		32, 61, 32, 116, 104, 105, 115, 46, 112, 48, 59, 10, 9, 9, 9, 125,
-		10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 49, 32, 62,
-		32, 109, 112, 49, 41, 32, 123, 10, 9, 9, 9, 9, 109, 112, 49, 32,
-		61, 32, 116, 104, 105, 115, 46, 112, 49, 59, 10, 9, 9, 9, 125, 10,
-		9, 9, 125, 10, 9, 9, 116, 104, 105, 115, 46, 112, 48, 32, 61, 32,
-		112, 48, 59, 10, 9, 9, 116, 104, 105, 115, 46, 112, 49, 32, 61, 32,
-		112, 49, 59, 10, 9, 9, 116, 104, 105, 115, 46, 117, 110, 116, 105, 99,
-		107, 40, 41, 59, 10, 9, 9, 105, 102, 40, 109, 112, 49, 32, 60, 102,
-		114, 111, 102, 102, 32, 124, 124, 32, 109, 112, 48, 32, 62, 102, 114, 111,
+		108, 110, 104, 116, 32, 61, 32, 116, 104, 105, 115, 46, 102, 111, 110, 116,
+		104, 116, 59, 10, 9, 9, 118, 97, 114, 32, 97, 118, 97, 105, 108, 32,
+		61, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 32, 45,
+		32, 50, 42, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122,
+		32, 45, 32, 116, 104, 105, 115, 46, 103, 117, 116, 116, 101, 114, 32, 45,
+		32, 49, 59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32, 40, 108,
+		110, 46, 108, 110, 105, 45, 116, 104, 105, 115, 46, 108, 110, 48, 46, 108,
+		110, 105, 41, 42, 108, 110, 104, 116, 59, 10, 9, 9, 105, 102, 40, 121,
+		32, 62, 32, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116,
+		41, 32, 123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 102, 97,
+		108, 115, 101, 59, 10, 9, 9, 125, 10, 10, 9, 9, 47, 47, 32, 110,
+		111, 110, 45, 101, 109, 112, 116, 121, 32, 115, 101, 108, 101, 99, 116, 105,
+		111, 110, 46, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48,
+		32, 33, 61, 32, 116, 104, 105, 115, 46, 112, 49, 41, 32, 123, 10, 9,
+		9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32, 108,
+		110, 46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110,
+		103, 116, 104, 32, 124, 124, 32, 116, 104, 105, 115, 46, 112, 49, 32, 60,
+		32, 108, 110, 46, 111, 102, 102, 41, 123, 10, 9, 9, 9, 9, 47, 47,
+		32, 117, 110, 115, 101, 108, 101, 99, 116, 101, 100, 32, 108, 105, 110, 101,
+		10, 9, 9, 9, 9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99,
+		116, 40, 99, 116, 120, 44, 32, 49, 44, 32, 121, 44, 32, 116, 104, 105,
+		115, 46, 99, 46, 119, 105, 100, 116, 104, 45, 116, 104, 105, 115, 46, 109,
+		97, 114, 103, 105, 110, 115, 122, 45, 49, 44, 32, 108, 110, 104, 116, 41,
+		59, 10, 9, 9, 9, 9, 118, 97, 114, 32, 116, 32, 61, 32, 116, 104,
+		105, 115, 46, 116, 97, 98, 116, 120, 116, 40, 108, 110, 46, 116, 120, 116,
+		41, 59, 10, 9, 9, 9, 9, 99, 116, 120, 70, 105, 108, 108, 84, 101,
+		120, 116, 40, 99, 116, 120, 44, 32, 116, 44, 32, 116, 104, 105, 115, 46,
+		108, 109, 97, 114, 103, 105, 110, 40, 41, 44, 32, 121, 41, 59, 10, 9,
+		9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 47, 47, 32, 117, 112, 32, 116, 111,
+		32, 112, 48, 32, 117, 110, 115, 101, 108, 101, 99, 116, 101, 100, 10, 9,
+		9, 9, 118, 97, 114, 32, 100, 120, 32, 61, 32, 116, 104, 105, 115, 46,
+		108, 109, 97, 114, 103, 105, 110, 40, 41, 59, 10, 9, 9, 9, 118, 97,
+		114, 32, 115, 48, 32, 61, 32, 48, 59, 10, 9, 9, 9, 118, 97, 114,
+		32, 115, 48, 112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 62, 32, 108, 110, 46, 111,
+		102, 102, 41, 123, 10, 9, 9, 9, 9, 115, 48, 32, 61, 32, 116, 104,
+		105, 115, 46, 112, 48, 32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10,
+		9, 9, 9, 9, 118, 97, 114, 32, 115, 48, 116, 32, 61, 32, 116, 104,
+		105, 115, 46, 116, 97, 98, 116, 120, 116, 40, 108, 110, 46, 116, 120, 116,
+		46, 115, 108, 105, 99, 101, 40, 48, 44, 32, 115, 48, 41, 41, 59, 10,
+		9, 9, 9, 9, 115, 48, 112, 111, 115, 32, 61, 32, 115, 48, 116, 46,
+		108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 9, 100, 120, 32, 43,
+		61, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120,
+		116, 40, 115, 48, 116, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 9,
+		9, 9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99,
+		116, 120, 44, 32, 49, 44, 32, 121, 44, 32, 100, 120, 44, 32, 108, 110,
+		104, 116, 41, 59, 10, 9, 9, 9, 9, 99, 116, 120, 70, 105, 108, 108,
+		84, 101, 120, 116, 40, 99, 116, 120, 44, 32, 115, 48, 116, 44, 32, 116,
+		104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110, 40, 41, 44, 32, 121,
+		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 47, 47, 32, 102, 114,
+		111, 109, 32, 112, 48, 32, 116, 111, 32, 112, 49, 32, 115, 101, 108, 101,
+		99, 116, 101, 100, 10, 9, 9, 9, 118, 97, 114, 32, 115, 49, 32, 61,
+		32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 32, 45,
+		32, 115, 48, 59, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46,
+		112, 49, 32, 60, 32, 108, 110, 46, 111, 102, 102, 43, 108, 110, 46, 116,
+		120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 10, 9, 9, 9, 9, 115,
+		49, 32, 61, 32, 116, 104, 105, 115, 46, 112, 49, 32, 45, 32, 115, 48,
+		32, 45, 32, 108, 110, 46, 111, 102, 102, 59, 10, 9, 9, 9, 118, 97,
+		114, 32, 115, 49, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 97, 98,
+		116, 120, 116, 40, 108, 110, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101,
+		40, 115, 48, 44, 32, 115, 48, 43, 115, 49, 41, 44, 32, 115, 48, 112,
+		111, 115, 41, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115, 49, 112, 111,
+		115, 32, 61, 32, 115, 48, 112, 111, 115, 32, 43, 32, 115, 49, 116, 46,
+		108, 101, 110, 103, 116, 104, 59, 10, 9, 9, 9, 118, 97, 114, 32, 115,
+		120, 32, 61, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84,
+		101, 120, 116, 40, 115, 49, 116, 41, 46, 119, 105, 100, 116, 104, 59, 10,
+		9, 9, 9, 118, 97, 114, 32, 111, 108, 100, 32, 61, 32, 99, 116, 120,
+		46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 59, 10, 9, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100, 97, 114, 121,
+		32, 62, 61, 32, 50, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120,
+		46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 34, 35, 70,
+		70, 55, 53, 55, 53, 34, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115,
+		101, 32, 105, 102, 40, 116, 104, 105, 115, 46, 115, 101, 99, 111, 110, 100,
+		97, 114, 121, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102,
+		105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 34, 35, 55, 51, 55,
+		51, 70, 70, 34, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32,
+		123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 83, 116,
+		121, 108, 101, 32, 61, 32, 99, 116, 120, 84, 104, 101, 109, 101, 40, 99,
+		116, 120, 41, 46, 115, 101, 108, 59, 10, 9, 9, 9, 125, 10, 9, 9,
+		9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 49, 32, 62, 32, 108, 110,
+		46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103,
+		116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102, 105,
+		108, 108, 82, 101, 99, 116, 40, 100, 120, 44, 32, 121, 44, 32, 116, 104,
+		105, 115, 46, 99, 46, 119, 105, 100, 116, 104, 45, 100, 120, 45, 116, 104,
+		105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 45, 49, 44, 32, 108,
+		110, 104, 116, 41, 59, 10, 9, 9, 9, 125, 32, 101, 108, 115, 101, 32,
+		123, 10, 9, 9, 9, 9, 99, 116, 120, 46, 102, 105, 108, 108, 82, 101,
+		99, 116, 40, 100, 120, 44, 32, 121, 44, 32, 115, 120, 44, 32, 108, 110,
+		104, 116, 41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 99, 116, 120,
+		70, 105, 108, 108, 84, 101, 120, 116, 40, 99, 116, 120, 44, 32, 115, 49,
+		116, 44, 32, 100, 120, 44, 32, 121, 41, 59, 10, 9, 9, 9, 99, 116,
+		120, 46, 102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 111, 108,
+		100, 59, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112, 49,
+		32, 62, 32, 108, 110, 46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116,
+		46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 114,
+		101, 116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 9, 9, 125,
+		10, 9, 9, 9, 47, 47, 32, 102, 114, 111, 109, 32, 112, 49, 32, 117,
+		110, 115, 101, 108, 101, 99, 116, 101, 100, 10, 9, 9, 9, 99, 116, 120,
+		67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99, 116, 120, 44, 32, 100,
+		120, 43, 115, 120, 44, 32, 121, 44, 32, 116, 104, 105, 115, 46, 99, 46,
+		119, 105, 100, 116, 104, 45, 40, 100, 120, 43, 115, 120, 41, 45, 116, 104,
+		105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 45, 49, 44, 32, 108,
+		110, 104, 116, 41, 59, 10, 9, 9, 9, 105, 102, 40, 115, 49, 32, 62,
+		61, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41,
+		32, 123, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114,
+		117, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 118, 97, 114, 32,
+		115, 50, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 97, 98, 116, 120,
+		116, 40, 108, 110, 46, 116, 120, 116, 46, 115, 108, 105, 99, 101, 40, 115,
+		48, 43, 115, 49, 44, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110,
+		103, 116, 104, 41, 44, 32, 115, 49, 112, 111, 115, 41, 59, 10, 9, 9,
+		9, 99, 116, 120, 70, 105, 108, 108, 84, 101, 120, 116, 40, 99, 116, 120,
+		44, 32, 115, 50, 116, 44, 32, 100, 120, 43, 115, 120, 44, 32, 121, 41,
+		59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101,
+		59, 10, 9, 9, 125, 10, 10, 9, 9, 47, 47, 32, 117, 110, 115, 101,
+		108, 101, 99, 116, 101, 100, 32, 108, 105, 110, 101, 10, 9, 9, 99, 116,
+		120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40, 99, 116, 120, 44, 32,
+		49, 44, 32, 121, 44, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100,
+		116, 104, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122,
+		45, 49, 44, 32, 108, 110, 104, 116, 41, 59, 10, 9, 9, 118, 97, 114,
+		32, 116, 32, 61, 32, 116, 104, 105, 115, 46, 116, 97, 98, 116, 120, 116,
+		40, 108, 110, 46, 116, 120, 116, 41, 59, 10, 9, 9, 99, 116, 120, 70,
+		105, 108, 108, 84, 101, 120, 116, 40, 99, 116, 120, 44, 32, 116, 44, 32,
+		116, 104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110, 40, 41, 44, 32,
+		121, 41, 59, 10, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115, 46, 112,
+		48, 32, 60, 32, 108, 110, 46, 111, 102, 102, 32, 124, 124, 32, 116, 104,
+		105, 115, 46, 112, 48, 32, 62, 32, 108, 110, 46, 111, 102, 102, 32, 43,
+		32, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32,
+		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 116, 114, 117, 101,
+		59, 10, 9, 9, 125, 10, 10, 9, 9, 47, 47, 32, 108, 105, 110, 101,
+		32, 119, 105, 116, 104, 32, 116, 105, 99, 107, 10, 9, 9, 118, 97, 114,
+		32, 120, 32, 61, 32, 116, 104, 105, 115, 46, 112, 111, 115, 100, 120, 40,
+		108, 110, 46, 116, 120, 116, 44, 32, 116, 104, 105, 115, 46, 112, 48, 32,
+		45, 32, 108, 110, 46, 111, 102, 102, 41, 59, 10, 9, 9, 120, 32, 43,
+		61, 32, 116, 104, 105, 115, 46, 108, 109, 97, 114, 103, 105, 110, 40, 41,
+		32, 45, 32, 51, 42, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108, 101,
+		47, 50, 59, 9, 47, 47, 32, 97, 32, 98, 105, 116, 32, 116, 111, 32,
+		116, 104, 101, 32, 108, 101, 102, 116, 10, 9, 9, 116, 104, 105, 115, 46,
+		116, 105, 99, 107, 40, 120, 44, 32, 121, 41, 59, 10, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 116, 114, 117, 101, 59, 10, 9, 125, 59, 10, 10,
+		9, 116, 104, 105, 115, 46, 117, 112, 100, 97, 116, 101, 115, 99, 114, 108,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115,
+		46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 121, 48, 32, 61,
+		32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 108, 110, 105, 32, 47, 32,
+		116, 104, 105, 115, 46, 108, 110, 101, 46, 108, 110, 105, 32, 42, 32, 116,
+		104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104, 116, 59, 10, 9, 9,
+		118, 97, 114, 32, 100, 121, 32, 61, 32, 116, 104, 105, 115, 46, 102, 114,
+		108, 105, 110, 101, 115, 32, 47, 32, 116, 104, 105, 115, 46, 108, 110, 101,
+		46, 108, 110, 105, 32, 42, 32, 116, 104, 105, 115, 46, 99, 46, 104, 101,
+		105, 103, 104, 116, 59, 10, 9, 10, 9, 9, 99, 116, 120, 67, 108, 101,
+		97, 114, 82, 101, 99, 116, 40, 99, 116, 120, 44, 32, 116, 104, 105, 115,
+		46, 99, 46, 119, 105, 100, 116, 104, 45, 116, 104, 105, 115, 46, 109, 97,
+		114, 103, 105, 110, 115, 122, 44, 32, 48, 44, 32, 116, 104, 105, 115, 46,
+		109, 97, 114, 103, 105, 110, 115, 122, 44, 32, 121, 48, 41, 59, 10, 9,
+		9, 118, 97, 114, 32, 111, 108, 100, 32, 61, 32, 99, 116, 120, 46, 102,
+		105, 108, 108, 83, 116, 121, 108, 101, 59, 10, 9, 9, 99, 116, 120, 46,
+		102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 34, 35, 55, 51,
+		55, 51, 70, 70, 34, 59, 10, 9, 9, 99, 116, 120, 46, 102, 105, 108,
+		108, 82, 101, 99, 116, 40, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100,
+		116, 104, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122,
+		44, 32, 121, 48, 44, 32, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105,
+		110, 115, 122, 44, 32, 100, 121, 41, 59, 10, 9, 9, 99, 116, 120, 46,
+		102, 105, 108, 108, 83, 116, 121, 108, 101, 32, 61, 32, 111, 108, 100, 59,
+		10, 9, 9, 99, 116, 120, 67, 108, 101, 97, 114, 82, 101, 99, 116, 40,
+		99, 116, 120, 44, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105, 100, 116,
+		104, 45, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 44,
+		32, 121, 48, 43, 100, 121, 44, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		109, 97, 114, 103, 105, 110, 115, 122, 44, 32, 116, 104, 105, 115, 46, 99,
+		46, 104, 101, 105, 103, 104, 116, 45, 40, 121, 48, 43, 100, 121, 41, 41,
+		59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 114, 101, 100,
+		114, 97, 119, 116, 101, 120, 116, 32, 61, 32, 102, 117, 110, 99, 116, 105,
+		111, 110, 40, 41, 32, 123, 10, 9, 9, 116, 104, 105, 115, 46, 102, 105,
+		120, 102, 111, 110, 116, 40, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46,
+		110, 108, 105, 110, 101, 115, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108,
+		111, 111, 114, 40, 116, 104, 105, 115, 46, 99, 46, 104, 101, 105, 103, 104,
+		116, 47, 116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41, 59, 10,
+		9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 116, 105, 99, 107, 105,
+		109, 103, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 109, 107,
+		116, 105, 99, 107, 40, 41, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102,
+		40, 33, 116, 104, 105, 115, 46, 108, 110, 48, 41, 32, 123, 10, 9, 9,
+		9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 114, 101,
+		100, 114, 97, 119, 116, 101, 120, 116, 58, 32, 110, 111, 32, 108, 110, 48,
+		34, 41, 59, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9,
+		9, 125, 10, 9, 9, 118, 97, 114, 32, 102, 114, 111, 102, 102, 32, 61,
+		32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 111, 102, 102, 59, 10, 9,
+		9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 32, 61, 32, 48,
+		59, 10, 9, 9, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115,
+		32, 61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 108, 110, 32, 61,
+		32, 116, 104, 105, 115, 46, 108, 110, 48, 59, 10, 9, 9, 102, 111, 114,
+		40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 61,
+		32, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115, 59, 32, 105, 43,
+		43, 41, 123, 10, 9, 9, 9, 105, 102, 40, 108, 110, 32, 33, 61, 32,
+		110, 117, 108, 108, 41, 123, 10, 9, 9, 9, 9, 105, 102, 40, 33, 116,
+		104, 105, 115, 46, 100, 114, 97, 119, 108, 105, 110, 101, 40, 108, 110, 41,
+		41, 10, 9, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9,
+		9, 9, 116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 43, 43,
+		59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122,
+		101, 32, 43, 61, 32, 108, 110, 46, 108, 101, 110, 40, 41, 59, 10, 9,
+		9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59,
+		10, 9, 9, 9, 125, 101, 108, 115, 101, 32, 105, 102, 40, 33, 116, 104,
+		105, 115, 46, 99, 108, 101, 97, 114, 108, 105, 110, 101, 40, 105, 41, 41,
+		32, 123, 10, 9, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59, 10, 9,
+		9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40, 116, 100, 101,
+		98, 117, 103, 41, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40,
+		34, 114, 101, 100, 114, 97, 119, 32, 34, 32, 43, 32, 105, 32, 43, 32,
+		34, 32, 34, 32, 43, 32, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101,
+		115, 41, 59, 10, 9, 9, 116, 104, 105, 115, 46, 117, 112, 100, 97, 116,
+		101, 115, 99, 114, 108, 40, 41, 59, 10, 9, 125, 59, 10, 10, 9, 47,
+		47, 32, 114, 101, 113, 117, 105, 114, 101, 115, 32, 97, 32, 114, 101, 100,
+		114, 97, 119, 32, 105, 102, 32, 114, 101, 116, 117, 114, 110, 115, 32, 116,
+		114, 117, 101, 46, 10, 9, 116, 104, 105, 115, 46, 115, 99, 114, 111, 108,
+		108, 100, 111, 119, 110, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 110, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 111, 108, 100, 32,
+		61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 59, 10, 9, 9, 102, 111,
+		114, 40, 59, 32, 110, 32, 62, 32, 48, 59, 32, 110, 45, 45, 41, 32,
+		123, 10, 9, 9, 9, 105, 102, 40, 33, 116, 104, 105, 115, 46, 108, 110,
+		48, 46, 112, 114, 101, 118, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114,
+		101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104, 105,
+		115, 46, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48,
+		46, 112, 114, 101, 118, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116,
+		117, 114, 110, 32, 116, 104, 105, 115, 46, 108, 110, 48, 32, 33, 61, 32,
+		111, 108, 100, 59, 10, 9, 125, 59, 10, 10, 9, 47, 47, 32, 114, 101,
+		113, 117, 105, 114, 101, 115, 32, 97, 32, 114, 101, 100, 114, 97, 119, 32,
+		105, 102, 32, 114, 101, 116, 117, 114, 110, 115, 32, 116, 114, 117, 101, 46,
+		10, 9, 116, 104, 105, 115, 46, 115, 99, 114, 111, 108, 108, 117, 112, 32,
+		61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 110, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 111, 108, 100, 32, 61, 32, 116, 104, 105, 115,
+		46, 108, 110, 48, 59, 10, 9, 9, 102, 111, 114, 40, 59, 32, 110, 32,
+		62, 32, 48, 59, 32, 110, 45, 45, 41, 32, 123, 10, 9, 9, 9, 105,
+		102, 40, 33, 116, 104, 105, 115, 46, 108, 110, 48, 46, 110, 101, 120, 116,
+		32, 124, 124, 32, 33, 116, 104, 105, 115, 46, 108, 110, 48, 46, 110, 101,
+		120, 116, 46, 110, 101, 120, 116, 41, 32, 123, 10, 9, 9, 9, 9, 98,
+		114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 116, 104,
+		105, 115, 46, 108, 110, 48, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110,
+		48, 46, 110, 101, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 111, 108, 100, 32, 33, 61, 32, 116, 104, 105, 115,
+		46, 108, 110, 48, 59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115,
+		46, 110, 115, 99, 114, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111,
+		110, 40, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 110, 115, 99, 114,
+		108, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 116,
+		104, 105, 115, 46, 110, 108, 105, 110, 101, 115, 47, 52, 41, 59, 10, 9,
+		9, 105, 102, 40, 110, 115, 99, 114, 108, 32, 62, 32, 48, 41, 32, 123,
+		10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32, 110, 115, 99, 114, 108,
+		59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114, 110, 32, 49,
+		59, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121,
+		115, 99, 114, 111, 108, 108, 105, 110, 115, 32, 61, 32, 102, 117, 110, 99,
+		116, 105, 111, 110, 40, 108, 110, 41, 32, 123, 10, 9, 9, 105, 102, 40,
+		108, 110, 46, 108, 110, 105, 32, 62, 61, 32, 116, 104, 105, 115, 46, 108,
+		110, 48, 46, 108, 110, 105, 43, 116, 104, 105, 115, 46, 110, 108, 105, 110,
+		101, 115, 45, 49, 32, 38, 38, 10, 9, 9, 32, 32, 32, 108, 110, 46,
+		108, 110, 105, 32, 60, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46,
+		108, 110, 105, 43, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115, 43,
+		49, 32, 38, 38, 32, 116, 104, 105, 115, 46, 110, 108, 105, 110, 101, 115,
+		32, 62, 32, 49, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46,
+		115, 99, 114, 111, 108, 108, 100, 111, 119, 110, 40, 116, 104, 105, 115, 46,
+		110, 115, 99, 114, 108, 40, 41, 41, 59, 10, 9, 9, 125, 10, 9, 125,
+		59, 10, 10, 9, 116, 104, 105, 115, 46, 109, 97, 121, 115, 99, 114, 111,
+		108, 108, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110,
+		40, 108, 110, 41, 32, 123, 10, 9, 9, 105, 102, 40, 116, 104, 105, 115,
+		46, 112, 48, 32, 60, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 111,
+		102, 102, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 115, 99,
+		114, 111, 108, 108, 117, 112, 40, 116, 104, 105, 115, 46, 110, 115, 99, 114,
+		108, 40, 41, 41, 59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101,
+		100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 119, 114, 97, 112, 111,
+		102, 102, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 116, 41,
+		32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116,
+		104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 97,
+		118, 97, 105, 108, 32, 61, 32, 116, 104, 105, 115, 46, 99, 46, 119, 105,
+		100, 116, 104, 32, 45, 32, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105,
+		110, 115, 122, 32, 45, 32, 116, 104, 105, 115, 46, 103, 117, 116, 116, 101,
+		114, 59, 10, 9, 9, 118, 97, 114, 32, 112, 111, 115, 32, 61, 32, 48,
+		59, 10, 9, 9, 118, 97, 114, 32, 115, 32, 61, 32, 34, 34, 59, 10,
+		9, 9, 105, 102, 40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9,
+		9, 9, 99, 111, 110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 34, 119,
+		114, 97, 112, 111, 102, 102, 58, 32, 88, 32, 119, 105, 100, 58, 32, 34,
+		32, 43, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101,
+		120, 116, 40, 34, 88, 34, 41, 46, 119, 105, 100, 116, 104, 41, 59, 10,
+		9, 9, 125, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32,
+		61, 32, 48, 59, 32, 105, 32, 60, 32, 116, 46, 108, 101, 110, 103, 116,
+		104, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118, 97, 114, 32,
+		114, 32, 61, 32, 116, 46, 99, 104, 97, 114, 65, 116, 40, 105, 41, 59,
+		10, 9, 9, 9, 105, 102, 40, 114, 32, 61, 61, 32, 39, 92, 116, 39,
+		41, 32, 123, 10, 9, 9, 9, 9, 100, 111, 32, 123, 10, 9, 9, 9,
+		9, 9, 115, 32, 43, 61, 32, 34, 32, 34, 59, 10, 9, 9, 9, 9,
+		9, 112, 111, 115, 43, 43, 59, 10, 9, 9, 9, 9, 125, 119, 104, 105,
+		108, 101, 40, 112, 111, 115, 37, 116, 104, 105, 115, 46, 116, 97, 98, 115,
+		116, 111, 112, 41, 59, 10, 9, 9, 9, 125, 101, 108, 115, 101, 123, 10,
+		9, 9, 9, 9, 112, 111, 115, 43, 43, 59, 10, 9, 9, 9, 9, 115,
+		32, 43, 61, 32, 114, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105,
+		102, 40, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120,
+		116, 40, 115, 41, 46, 119, 105, 100, 116, 104, 32, 62, 32, 97, 118, 97,
+		105, 108, 41, 123, 10, 9, 9, 9, 9, 105, 102, 40, 116, 100, 101, 98,
+		117, 103, 41, 32, 123, 10, 9, 9, 9, 9, 9, 99, 111, 110, 115, 111,
+		108, 101, 46, 108, 111, 103, 40, 39, 119, 114, 97, 112, 111, 102, 102, 58,
+		32, 39, 32, 43, 32, 115, 32, 43, 32, 39, 58, 32, 119, 114, 97, 112,
+		58, 32, 39, 32, 43, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114,
+		101, 84, 101, 120, 116, 40, 115, 41, 46, 119, 105, 100, 116, 104, 32, 43,
+		32, 34, 32, 34, 32, 43, 32, 97, 118, 97, 105, 108, 41, 59, 10, 9,
+		9, 9, 9, 125, 10, 9, 9, 9, 9, 114, 101, 116, 117, 114, 110, 32,
+		105, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 105, 102,
+		40, 116, 100, 101, 98, 117, 103, 41, 32, 123, 10, 9, 9, 9, 99, 111,
+		110, 115, 111, 108, 101, 46, 108, 111, 103, 40, 39, 119, 114, 97, 112, 111,
+		102, 102, 58, 32, 39, 32, 43, 32, 115, 32, 43, 32, 39, 58, 32, 110,
+		111, 32, 119, 114, 97, 112, 58, 32, 39, 32, 43, 32, 99, 116, 120, 46,
+		109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 115, 41, 46, 119,
+		105, 100, 116, 104, 32, 43, 32, 34, 32, 34, 32, 43, 32, 97, 118, 97,
+		105, 108, 41, 59, 10, 9, 9, 125, 10, 9, 9, 114, 101, 116, 117, 114,
+		110, 32, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9, 125, 59, 10,
+		10, 9, 116, 104, 105, 115, 46, 112, 111, 115, 100, 120, 32, 61, 32, 102,
+		117, 110, 99, 116, 105, 111, 110, 40, 116, 44, 32, 110, 41, 32, 123, 10,
+		9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116, 104, 105, 115,
+		46, 99, 116, 120, 59, 10, 9, 9, 118, 97, 114, 32, 112, 111, 115, 32,
+		61, 32, 48, 59, 10, 9, 9, 118, 97, 114, 32, 100, 120, 32, 61, 32,
+		48, 59, 10, 9, 9, 118, 97, 114, 32, 115, 112, 99, 119, 105, 100, 32,
+		61, 32, 99, 116, 120, 46, 109, 101, 97, 115, 117, 114, 101, 84, 101, 120,
+		116, 40, 34, 32, 34, 41, 46, 119, 105, 100, 116, 104, 59, 10, 9, 9,
+		102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105,
+		32, 60, 32, 116, 46, 108, 101, 110, 103, 116, 104, 32, 38, 38, 32, 105,
+		32, 60, 32, 110, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 118,
+		97, 114, 32, 114, 32, 61, 32, 116, 46, 99, 104, 97, 114, 65, 116, 40,
+		105, 41, 59, 10, 9, 9, 9, 105, 102, 40, 114, 32, 61, 61, 32, 39,
+		92, 116, 39, 41, 32, 123, 10, 9, 9, 9, 9, 100, 111, 32, 123, 10,
+		9, 9, 9, 9, 9, 100, 120, 32, 43, 61, 32, 115, 112, 99, 119, 105,
+		100, 59, 10, 9, 9, 9, 9, 9, 112, 111, 115, 43, 43, 59, 10, 9,
+		9, 9, 9, 125, 119, 104, 105, 108, 101, 40, 112, 111, 115, 37, 116, 104,
+		105, 115, 46, 116, 97, 98, 115, 116, 111, 112, 41, 59, 10, 9, 9, 9,
+		125, 101, 108, 115, 101, 123, 10, 9, 9, 9, 9, 112, 111, 115, 43, 43,
+		59, 10, 9, 9, 9, 9, 100, 120, 32, 43, 61, 32, 99, 116, 120, 46,
+		109, 101, 97, 115, 117, 114, 101, 84, 101, 120, 116, 40, 114, 41, 46, 119,
+		105, 100, 116, 104, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 100, 120, 59, 10, 9, 125, 59, 10,
+		10, 9, 47, 47, 32, 114, 101, 116, 117, 114, 110, 115, 32, 91, 108, 105,
+		110, 101, 44, 32, 111, 102, 102, 32, 97, 116, 32, 108, 105, 110, 101, 44,
+		32, 99, 108, 105, 99, 107, 32, 112, 97, 115, 116, 32, 116, 101, 120, 116,
+		63, 93, 10, 9, 47, 47, 32, 108, 97, 116, 101, 114, 32, 121, 111, 117,
+		32, 99, 97, 110, 32, 117, 115, 101, 32, 115, 101, 101, 107, 112, 111, 115,
+		40, 108, 105, 110, 101, 44, 32, 108, 110, 111, 102, 102, 41, 32, 116, 111,
+		32, 103, 101, 116, 32, 97, 32, 118, 97, 108, 105, 100, 32, 112, 111, 115,
+		46, 10, 9, 116, 104, 105, 115, 46, 112, 116, 114, 50, 115, 101, 101, 107,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 99, 120, 44, 32,
+		99, 121, 41, 32, 123, 10, 9, 9, 118, 97, 114, 32, 109, 97, 114, 103,
+		105, 110, 115, 122, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111,
+		114, 40, 116, 104, 105, 115, 46, 109, 97, 114, 103, 105, 110, 115, 122, 47,
+		50, 41, 59, 10, 9, 9, 118, 97, 114, 32, 120, 32, 61, 32, 99, 120,
+		59, 10, 9, 9, 118, 97, 114, 32, 121, 32, 61, 32, 99, 121, 59, 10,
+		9, 9, 118, 97, 114, 32, 111, 118, 102, 32, 61, 32, 48, 59, 10, 9,
+		9, 120, 32, 42, 61, 32, 116, 104, 105, 115, 46, 116, 115, 99, 97, 108,
+		101, 59, 10, 9, 9, 121, 32, 42, 61, 32, 116, 104, 105, 115, 46, 116,
+		115, 99, 97, 108, 101, 59, 10, 9, 9, 118, 97, 114, 32, 110, 108, 110,
+		32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 121, 47,
+		116, 104, 105, 115, 46, 102, 111, 110, 116, 104, 116, 41, 59, 10, 9, 9,
+		105, 102, 40, 110, 108, 110, 32, 60, 32, 48, 41, 32, 123, 10, 9, 9,
+		9, 114, 101, 116, 117, 114, 110, 32, 91, 116, 104, 105, 115, 46, 108, 110,
+		48, 44, 32, 48, 44, 32, 102, 97, 108, 115, 101, 93, 59, 10, 9, 9,
+		125, 10, 9, 9, 105, 102, 40, 110, 108, 110, 32, 62, 61, 32, 116, 104,
+		105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 41, 32, 123, 9, 9, 47,
+		47, 32, 111, 118, 101, 114, 102, 108, 111, 119, 10, 9, 9, 9, 114, 101,
+		116, 117, 114, 110, 32, 91, 116, 104, 105, 115, 46, 108, 110, 101, 44, 32,
+		116, 104, 105, 115, 46, 108, 110, 101, 46, 116, 120, 116, 46, 108, 101, 110,
+		103, 116, 104, 44, 32, 116, 114, 117, 101, 93, 59, 10, 9, 9, 125, 10,
+		9, 9, 118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104, 105, 115, 46,
+		108, 110, 48, 59, 10, 9, 9, 119, 104, 105, 108, 101, 40, 110, 108, 110,
+		45, 45, 32, 62, 32, 48, 32, 38, 38, 32, 108, 110, 46, 110, 101, 120,
+		116, 41, 32, 123, 10, 9, 9, 9, 108, 110, 32, 61, 32, 108, 110, 46,
+		110, 101, 120, 116, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32,
+		112, 111, 115, 32, 61, 32, 48, 59, 10, 9, 9, 102, 111, 114, 40, 59,
+		32, 112, 111, 115, 32, 60, 61, 32, 108, 110, 46, 116, 120, 116, 46, 108,
+		101, 110, 103, 116, 104, 59, 32, 112, 111, 115, 43, 43, 41, 123, 10, 9,
+		9, 9, 118, 97, 114, 32, 99, 111, 102, 102, 32, 61, 32, 116, 104, 105,
+		115, 46, 112, 111, 115, 100, 120, 40, 108, 110, 46, 116, 120, 116, 44, 32,
+		112, 111, 115, 41, 59, 10, 9, 9, 9, 105, 102, 40, 99, 111, 102, 102,
+		43, 109, 97, 114, 103, 105, 110, 115, 122, 43, 116, 104, 105, 115, 46, 103,
+		117, 116, 116, 101, 114, 32, 62, 32, 120, 41, 123, 10, 9, 9, 9, 9,
+		105, 102, 40, 112, 111, 115, 32, 62, 32, 48, 41, 10, 9, 9, 9, 9,
+		9, 112, 111, 115, 45, 45, 59, 10, 9, 9, 9, 9, 98, 114, 101, 97,
+		107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 105, 102,
+		40, 112, 111, 115, 32, 62, 32, 108, 110, 46, 116, 120, 116, 46, 108, 101,
+		110, 103, 116, 104, 41, 123, 10, 9, 9, 9, 112, 111, 115, 32, 61, 32,
+		108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 59, 10, 9,
+		9, 9, 114, 101, 116, 117, 114, 110, 32, 91, 108, 110, 44, 32, 112, 111,
+		115, 44, 32, 116, 114, 117, 101, 93, 59, 10, 9, 9, 125, 10, 9, 9,
+		114, 101, 116, 117, 114, 110, 32, 91, 108, 110, 44, 32, 112, 111, 115, 44,
+		32, 102, 97, 108, 115, 101, 93, 59, 10, 9, 125, 59, 10, 10, 9, 116,
+		104, 105, 115, 46, 118, 105, 101, 119, 115, 101, 108, 32, 61, 32, 102, 117,
+		110, 99, 116, 105, 111, 110, 40, 41, 32, 123, 10, 9, 9, 105, 102, 40,
+		116, 104, 105, 115, 46, 112, 48, 32, 62, 61, 32, 116, 104, 105, 115, 46,
+		108, 110, 48, 46, 111, 102, 102, 32, 38, 38, 32, 116, 104, 105, 115, 46,
+		112, 48, 32, 60, 61, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 111,
 		102, 102, 43, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 41, 32,
 		123, 10, 9, 9, 9, 114, 101, 116, 117, 114, 110, 59, 10, 9, 9, 125,
-		10, 9, 9, 118, 97, 114, 32, 105, 110, 115, 101, 108, 32, 61, 32, 102,
-		97, 108, 115, 101, 59, 10, 9, 9, 118, 97, 114, 32, 108, 110, 32, 61,
-		32, 116, 104, 105, 115, 46, 108, 110, 48, 59, 10, 9, 9, 102, 111, 114,
-		40, 118, 97, 114, 32, 105, 32, 61, 32, 48, 59, 32, 105, 32, 60, 32,
-		116, 104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 32, 38, 38, 32,
-		108, 110, 32, 33, 61, 32, 110, 117, 108, 108, 59, 32, 105, 43, 43, 41,
-		123, 10, 9, 9, 9, 105, 102, 40, 109, 112, 49, 32, 62, 61, 32, 108,
-		110, 46, 111, 102, 102, 32, 38, 38, 32, 109, 112, 48, 32, 60, 61, 32,
-		108, 110, 46, 111, 102, 102, 43, 108, 110, 46, 116, 120, 116, 46, 108, 101,
-		110, 103, 116, 104, 41, 32, 123, 10, 9, 9, 9, 9, 105, 110, 115, 101,
-		108, 61, 116, 114, 117, 101, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9,
-		105, 102, 40, 105, 110, 115, 101, 108, 41, 32, 123, 10, 9, 9, 9, 9,
-		116, 104, 105, 115, 46, 100, 114, 97, 119, 108, 105, 110, 101, 40, 108, 110,
-		41, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 109, 112,
-		49, 32, 60, 32, 108, 110, 46, 111, 102, 102, 41, 32, 123, 10, 9, 9,
-		9, 9, 98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9,
-		9, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101, 120, 116, 59, 10, 9,
-		9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 102, 114,
-		108, 110, 105, 110, 115, 100, 101, 108, 32, 61, 32, 102, 117, 110, 99, 116,
-		105, 111, 110, 40, 108, 110, 44, 32, 110, 105, 110, 115, 100, 101, 108, 41,
-		123, 10, 9, 9, 105, 102, 40, 108, 110, 46, 108, 110, 105, 32, 62, 61,
-		32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 108, 110, 105, 32, 38, 38,
-		32, 108, 110, 46, 108, 110, 105, 32, 60, 32, 116, 104, 105, 115, 46, 108,
-		110, 48, 46, 108, 110, 105, 43, 116, 104, 105, 115, 46, 102, 114, 108, 105,
-		110, 101, 115, 41, 32, 123, 10, 9, 9, 9, 116, 104, 105, 115, 46, 102,
-		114, 115, 105, 122, 101, 32, 43, 61, 32, 110, 105, 110, 115, 100, 101, 108,
-		59, 10, 9, 9, 9, 116, 104, 105, 115, 46, 100, 114, 97, 119, 108, 105,
-		110, 101, 40, 108, 110, 41, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10,
-		10, 9, 116, 104, 105, 115, 46, 102, 105, 120, 102, 111, 110, 116, 40, 41,
-		59, 10, 125, 10,
+		10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 108, 110, 32, 61, 32,
+		116, 104, 105, 115, 46, 108, 110, 115, 59, 32, 108, 110, 32, 33, 61, 32,
+		110, 117, 108, 108, 59, 32, 108, 110, 32, 61, 32, 108, 110, 46, 110, 101,
+		120, 116, 41, 32, 123, 10, 9, 9, 9, 105, 102, 40, 116, 104, 105, 115,
+		46, 112, 48, 32, 62, 61, 32, 108, 110, 46, 111, 102, 102, 32, 38, 38,
+		32, 116, 104, 105, 115, 46, 112, 48, 32, 60, 61, 32, 108, 110, 46, 111,
+		102, 102, 43, 108, 110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104,
+		41, 32, 123, 10, 9, 9, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32,
+		110, 32, 61, 32, 77, 97, 116, 104, 46, 102, 108, 111, 111, 114, 40, 116,
+		104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 47, 51, 41, 59, 32,
+		110, 32, 62, 32, 48, 32, 38, 38, 32, 108, 110, 46, 112, 114, 101, 118,
+		59, 32, 110, 45, 45, 41, 32, 123, 10, 9, 9, 9, 9, 9, 108, 110,
+		32, 61, 32, 108, 110, 46, 112, 114, 101, 118, 59, 10, 9, 9, 9, 9,
+		125, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 108, 110, 48, 32, 61,
+		32, 108, 110, 59, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 114, 101,
+		100, 114, 97, 119, 116, 101, 120, 116, 40, 41, 59, 10, 9, 9, 9, 9,
+		98, 114, 101, 97, 107, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10,
+		9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 115, 101, 116, 115, 101,
+		108, 32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 112, 48, 44,
+		32, 112, 49, 44, 32, 114, 101, 102, 114, 101, 115, 104, 97, 108, 108, 41,
+		32, 123, 10, 9, 9, 118, 97, 114, 32, 99, 116, 120, 32, 61, 32, 116,
+		104, 105, 115, 46, 99, 116, 120, 59, 10, 9, 9, 105, 102, 40, 112, 48,
+		32, 62, 32, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115, 41, 32,
+		123, 10, 9, 9, 9, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46, 110,
+		114, 117, 110, 101, 115, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
+		112, 49, 32, 60, 32, 112, 48, 41, 32, 123, 10, 9, 9, 9, 112, 49,
+		32, 61, 32, 112, 48, 59, 10, 9, 9, 125, 10, 9, 9, 105, 102, 40,
+		112, 49, 32, 62, 32, 116, 104, 105, 115, 46, 110, 114, 117, 110, 101, 115,
+		41, 32, 123, 10, 9, 9, 9, 112, 49, 32, 61, 32, 116, 104, 105, 115,
+		46, 110, 114, 117, 110, 101, 115, 59, 10, 9, 9, 125, 10, 9, 9, 105,
+		102, 40, 116, 104, 105, 115, 46, 112, 48, 32, 33, 61, 32, 116, 104, 105,
+		115, 46, 112, 49, 41, 32, 123, 10, 9, 9, 9, 114, 101, 102, 114, 101,
+		115, 104, 97, 108, 108, 32, 61, 32, 116, 114, 117, 101, 59, 10, 9, 9,
+		125, 10, 9, 9, 118, 97, 114, 32, 102, 114, 111, 102, 102, 32, 61, 32,
+		116, 104, 105, 115, 46, 108, 110, 48, 46, 111, 102, 102, 59, 10, 9, 9,
+		105, 102, 40, 114, 101, 102, 114, 101, 115, 104, 97, 108, 108, 32, 38, 38,
+		32, 40, 116, 104, 105, 115, 46, 112, 49, 32, 60, 102, 114, 111, 102, 102,
+		32, 124, 124, 32, 116, 104, 105, 115, 46, 112, 48, 32, 62, 102, 114, 111,
+		102, 102, 43, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 41, 41,
+		10, 9, 9, 9, 114, 101, 102, 114, 101, 115, 104, 97, 108, 108, 32, 61,
+		32, 102, 97, 108, 115, 101, 59, 10, 9, 9, 118, 97, 114, 32, 109, 112,
+		48, 32, 61, 32, 112, 48, 59, 10, 9, 9, 118, 97, 114, 32, 109, 112,
+		49, 32, 61, 32, 112, 49, 59, 10, 9, 9, 105, 102, 40, 114, 101, 102,
+		114, 101, 115, 104, 97, 108, 108, 41, 123, 10, 9, 9, 9, 105, 102, 40,
+		116, 104, 105, 115, 46, 112, 48, 32, 60, 32, 109, 112, 48, 41, 32, 123,
+		10, 9, 9, 9, 9, 109, 112, 48, 32, 61, 32, 116, 104, 105, 115, 46,
+		112, 48, 59, 10, 9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 116,
+		104, 105, 115, 46, 112, 49, 32, 62, 32, 109, 112, 49, 41, 32, 123, 10,
+		9, 9, 9, 9, 109, 112, 49, 32, 61, 32, 116, 104, 105, 115, 46, 112,
+		49, 59, 10, 9, 9, 9, 125, 10, 9, 9, 125, 10, 9, 9, 116, 104,
+		105, 115, 46, 112, 48, 32, 61, 32, 112, 48, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 112, 49, 32, 61, 32, 112, 49, 59, 10, 9, 9, 116, 104,
+		105, 115, 46, 117, 110, 116, 105, 99, 107, 40, 41, 59, 10, 9, 9, 105,
+		102, 40, 109, 112, 49, 32, 60, 102, 114, 111, 102, 102, 32, 124, 124, 32,
+		109, 112, 48, 32, 62, 102, 114, 111, 102, 102, 43, 116, 104, 105, 115, 46,
+		102, 114, 115, 105, 122, 101, 41, 32, 123, 10, 9, 9, 9, 114, 101, 116,
+		117, 114, 110, 59, 10, 9, 9, 125, 10, 9, 9, 118, 97, 114, 32, 105,
+		110, 115, 101, 108, 32, 61, 32, 102, 97, 108, 115, 101, 59, 10, 9, 9,
+		118, 97, 114, 32, 108, 110, 32, 61, 32, 116, 104, 105, 115, 46, 108, 110,
+		48, 59, 10, 9, 9, 102, 111, 114, 40, 118, 97, 114, 32, 105, 32, 61,
+		32, 48, 59, 32, 105, 32, 60, 32, 116, 104, 105, 115, 46, 102, 114, 108,
+		105, 110, 101, 115, 32, 38, 38, 32, 108, 110, 32, 33, 61, 32, 110, 117,
+		108, 108, 59, 32, 105, 43, 43, 41, 123, 10, 9, 9, 9, 105, 102, 40,
+		109, 112, 49, 32, 62, 61, 32, 108, 110, 46, 111, 102, 102, 32, 38, 38,
+		32, 109, 112, 48, 32, 60, 61, 32, 108, 110, 46, 111, 102, 102, 43, 108,
+		110, 46, 116, 120, 116, 46, 108, 101, 110, 103, 116, 104, 41, 32, 123, 10,
+		9, 9, 9, 9, 105, 110, 115, 101, 108, 61, 116, 114, 117, 101, 59, 10,
+		9, 9, 9, 125, 10, 9, 9, 9, 105, 102, 40, 105, 110, 115, 101, 108,
+		41, 32, 123, 10, 9, 9, 9, 9, 116, 104, 105, 115, 46, 100, 114, 97,
+		119, 108, 105, 110, 101, 40, 108, 110, 41, 59, 10, 9, 9, 9, 125, 10,
+		9, 9, 9, 105, 102, 40, 109, 112, 49, 32, 60, 32, 108, 110, 46, 111,
+		102, 102, 41, 32, 123, 10, 9, 9, 9, 9, 98, 114, 101, 97, 107, 59,
+		10, 9, 9, 9, 125, 10, 9, 9, 9, 108, 110, 32, 61, 32, 108, 110,
+		46, 110, 101, 120, 116, 59, 10, 9, 9, 125, 10, 9, 125, 59, 10, 10,
+		9, 116, 104, 105, 115, 46, 102, 114, 108, 110, 105, 110, 115, 100, 101, 108,
+		32, 61, 32, 102, 117, 110, 99, 116, 105, 111, 110, 40, 108, 110, 44, 32,
+		110, 105, 110, 115, 100, 101, 108, 41, 123, 10, 9, 9, 105, 102, 40, 108,
+		110, 46, 108, 110, 105, 32, 62, 61, 32, 116, 104, 105, 115, 46, 108, 110,
+		48, 46, 108, 110, 105, 32, 38, 38, 32, 108, 110, 46, 108, 110, 105, 32,
+		60, 32, 116, 104, 105, 115, 46, 108, 110, 48, 46, 108, 110, 105, 43, 116,
+		104, 105, 115, 46, 102, 114, 108, 105, 110, 101, 115, 41, 32, 123, 10, 9,
+		9, 9, 116, 104, 105, 115, 46, 102, 114, 115, 105, 122, 101, 32, 43, 61,
+		32, 110, 105, 110, 115, 100, 101, 108, 59, 10, 9, 9, 9, 116, 104, 105,
+		115, 46, 100, 114, 97, 119, 108, 105, 110, 101, 40, 108, 110, 41, 59, 10,
+		9, 9, 125, 10, 9, 125, 59, 10, 10, 9, 116, 104, 105, 115, 46, 102,
+		105, 120, 102, 111, 110, 116, 40, 41, 59, 10, 125, 10,
 	},
 	"js/latin.js": []byte{
 		34, 117, 115, 101, 32, 115, 116, 114, 105, 99, 116, 34, 59, 10, 47, 42, 10,
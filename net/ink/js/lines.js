@@ -63,11 +63,26 @@ function lparen(c) {
 	return "([{<".charAt(i);
 }
 
+// Color themes for text: background, text, and selection colors.
+// The theme used is kept in ctx.theme.
+var themes = {
+	light: {bg: "#DDDDC8", fg: "black", sel: "#D1A0A0", lnfg: "#8A8A78"},
+	dark: {bg: "#1E1E1E", fg: "#D4D4D4", sel: "#5A4A6A", lnfg: "#6E6E6E"},
+	paper: {bg: "#FFFFEA", fg: "black", sel: "#EEEE9E", lnfg: "#A0A08A"},
+};
+
+function ctxTheme(ctx) {
+	if(ctx.theme) {
+		return ctx.theme;
+	}
+	return themes.light;
+}
+
 // Using ctx.clearRect(x, y, w, h) has problems in Chrome.
 // This seems to work.
 function ctxClearRect(ctx, x, y, wid, ht) {
 	var ofs = ctx.fillStyle;
-	ctx.fillStyle = "#DDDDC8";
+	ctx.fillStyle = ctxTheme(ctx).bg;
 	ctx.fillRect(x, y, wid, ht)
 	ctx.fillStyle = ofs;
 }
@@ -76,7 +91,7 @@ function ctxClearRect(ctx, x, y, wid, ht) {
 // This seems to work.
 function ctxFillText(ctx, txt, x, y) {
 	var ofs = ctx.fillStyle;
-	ctx.fillStyle = "black";
+	ctx.fillStyle = ctxTheme(ctx).fg;
 	ctx.fillText(txt, x, y);
 	ctx.fillStyle = ofs;
 }
@@ -698,10 +713,15 @@ function DrawLines(c) {
 	this.fontstyle = 'r';
 	this.tabstop = 4;
 	this.marginsz = 6;
+	this.linenbs = false;	// show line numbers
+	this.gutter = 0;	// width for line numbers, if shown
 	this.tscale = 4;	// scale must be even; we /2 without Math.floor
 	this.secondary = 0;	// button for selection
 
 
+	this.viewers = {};	// other views: id: {who: user@host, color: c}
+	this.nviewers = 0;
+
 	this.tickimg = undefined;	// tick image
 	this.tickx = 0;
 	this.ticky = 0;
@@ -711,7 +731,7 @@ function DrawLines(c) {
 	this.ctx = ctx;
 
 	checkoutfonts(ctx);
-	ctx.fillStyle = "#FFFFEA";
+	ctx.fillStyle = ctxTheme(ctx).bg;
 	var tabtext = Array(this.tabstop+1).join("X");
 
 	this.tabwid = ctx.measureText(tabtext).width;
@@ -719,24 +739,49 @@ function DrawLines(c) {
 	// but we scale the canvas *tscale.
 	this.fontht = 14*this.tscale;
 
+	// fontstyle is like "tb14:family", see ink.Txt.SetFont
 	this.fixfont = function() {
 		var ctx = this.ctx;
 		var mod = "";
 		var style = "";
+		var fs = this.fontstyle;
+		var family = "";
+		var i = fs.indexOf(':');
+		if(i >= 0) {
+			family = fs.slice(i+1);
+			fs = fs.slice(0, i);
+		}
 		style = tfvar;
-		if(this.fontstyle.indexOf('r') === -1) {
+		if(fs.indexOf('r') === -1) {
 			style = tffixed;
 		}
-		if(this.fontstyle.indexOf('b') > -1) {
+		if(family != "") {
+			style = family;
+		}
+		if(fs.indexOf('b') > -1) {
 			mod = "bold " + mod;
 		}
-		if(this.fontstyle.indexOf('i') > -1) {
+		if(fs.indexOf('i') > -1) {
 			mod = "italic " + mod;
 		}
+		var pt = parseInt(fs.replace(/[^0-9]/g, ""));
+		if(!pt || pt < 4) {
+			pt = 12;
+		}
+		this.fontht = (pt+2)*this.tscale;
 		// at scale 1, we keep two empty pts at the bottom.
 		var ht = this.fontht - 2*this.tscale;
 		ctx.font = mod + " "  + ht+"px "+ style;
 		ctx.textBaseline="top";
+		this.gutter = 0;
+		if(this.linenbs) {
+			this.gutter = this.marginsz + ctx.measureText("00000").width;
+		}
+	};
+
+	// x for the start of the text, past the gutter.
+	this.lmargin = function() {
+		return this.marginsz + this.gutter;
 	};
 
 	var oldclear = this.clear;
@@ -793,9 +838,150 @@ function DrawLines(c) {
 
 	// draw a line and return false if it's out of the draw space.
 	this.drawline = function(ln) {
+		if(!this.drawline1(ln)) {
+			return false;
+		}
+		this.drawviewers(ln);
+		this.drawspans(ln);
+		this.drawmatch(ln);
+		this.drawlineno(ln);
+		return true;
+	};
+
+	// show the number of the line in the gutter, for lines
+	// starting a text line (not for wrapped ones).
+	this.drawlineno = function(ln) {
+		if(!this.gutter || (ln.prev && !ln.prev.eol)) {
+			return;
+		}
+		var n = 1;
+		for(var l = ln.prev; l; l = l.prev) {
+			if(l.eol) {
+				n++;
+			}
+		}
+		var ctx = this.ctx;
+		var y = (ln.lni-this.ln0.lni)*this.fontht;
+		var s = "" + n;
+		var old = ctx.fillStyle;
+		ctx.fillStyle = ctxTheme(ctx).lnfg;
+		ctx.fillText(s, this.gutter - ctx.measureText(s).width, y);
+		ctx.fillStyle = old;
+	};
+
+	// box the bracket at the bmatch mark, if it's in this line.
+	this.drawmatch = function(ln) {
+		var m = this.getmark("bmatch");
+		if(!m || m.pos < ln.off || m.pos >= ln.off+ln.txt.length) {
+			return;
+		}
+		var ctx = this.ctx;
+		var y = (ln.lni-this.ln0.lni)*this.fontht;
+		var x0 = this.posdx(ln.txt, m.pos-ln.off) + this.lmargin();
+		var x1 = this.posdx(ln.txt, m.pos-ln.off+1) + this.lmargin();
+		var old = ctx.strokeStyle;
+		var oldw = ctx.lineWidth;
+		ctx.strokeStyle = "#7373FF";
+		ctx.lineWidth = this.tscale/2;
+		ctx.strokeRect(x0, y, x1-x0, this.fontht-this.tscale);
+		ctx.strokeStyle = old;
+		ctx.lineWidth = oldw;
+	};
+
+	this.isspanmark = function(mark) {
+		return mark.indexOf("span:") == 0;
+	};
+
+	// underline the spans (span:name.0 to span:name.1 marks) in this line.
+	this.drawspans = function(ln) {
+		var ctx = this.ctx;
+		var y = (ln.lni-this.ln0.lni)*this.fontht;
+		var end = ln.off + ln.txt.length;
+		for(var i = 0; i < this.marks.length; i++) {
+			var m0 = this.marks[i];
+			if(!this.isspanmark(m0.name) || m0.name.slice(-2) != ".0") {
+				continue;
+			}
+			var m1 = this.getmark(m0.name.slice(0, -2) + ".1");
+			if(!m1 || m1.pos <= m0.pos || m0.pos > end || m1.pos < ln.off) {
+				continue;
+			}
+			var s0 = Math.max(m0.pos, ln.off) - ln.off;
+			var s1 = Math.min(m1.pos, end) - ln.off;
+			var x0 = this.posdx(ln.txt, s0) + this.lmargin();
+			var x1 = this.posdx(ln.txt, s1) + this.lmargin();
+			var oldfs = ctx.fillStyle;
+			ctx.fillStyle = "#FF3030";
+			ctx.fillRect(x0, y+this.fontht-this.tscale, x1-x0, this.tscale/2);
+			ctx.fillStyle = oldfs;
+		}
+	};
+
+	var viewercolors = ["#2E8B57", "#B8860B", "#8A2BE2", "#D2691E", "#1E90FF", "#C71585"];
+
+	this.addviewer = function(id, who) {
+		if(this.viewers[id]) {
+			this.viewers[id].who = who;
+			return;
+		}
+		var c = viewercolors[this.nviewers%viewercolors.length];
+		this.nviewers++;
+		this.viewers[id] = {who: who, color: c};
+	};
+
+	this.delviewer = function(id) {
+		delete this.viewers[id];
+	};
+
+	this.isviewermark = function(mark) {
+		var id = mark.slice(0, -2);
+		return !!this.viewers[id];
+	};
+
+	// draw the selections of other views in this line, if any.
+	// p0 is shown as a bar with the name of the viewer and
+	// the rest of the selection is underlined.
+	this.drawviewers = function(ln) {
+		var ctx = this.ctx;
+		var y = (ln.lni-this.ln0.lni)*this.fontht;
+		var end = ln.off + ln.txt.length;
+		for(var id in this.viewers) {
+			var v = this.viewers[id];
+			var m0 = this.getmark(id+"p0");
+			var m1 = this.getmark(id+"p1");
+			if(!m0 || !m1) {
+				continue;
+			}
+			var p0 = m0.pos;
+			var p1 = m1.pos;
+			if(p1 < p0 || p0 > end || p1 < ln.off) {
+				continue;
+			}
+			var oldfs = ctx.fillStyle;
+			var oldf = ctx.font;
+			ctx.fillStyle = v.color;
+			var s0 = Math.max(p0, ln.off) - ln.off;
+			var s1 = Math.min(p1, end) - ln.off;
+			var x0 = this.posdx(ln.txt, s0) + this.lmargin();
+			var x1 = this.posdx(ln.txt, s1) + this.lmargin();
+			if(x1 > x0) {
+				ctx.fillRect(x0, y+this.fontht-this.tscale, x1-x0, this.tscale);
+			}
+			if(p0 >= ln.off) {
+				ctx.fillRect(x0-this.tscale/2, y, this.tscale, this.fontht);
+				var who = v.who.split("@")[0];
+				ctx.font = (this.fontht/2) + "px " + tffixed;
+				ctx.fillText(who, x0+this.tscale, y);
+			}
+			ctx.font = oldf;
+			ctx.fillStyle = oldfs;
+		}
+	};
+
+	this.drawline1 = function(ln) {
 		var ctx = this.ctx;
 		var lnht = this.fontht;
-		var avail = this.c.width - 2*this.marginsz - 1;
+		var avail = this.c.width - 2*this.marginsz - this.gutter - 1;
 		var y = (ln.lni-this.ln0.lni)*lnht;
 		if(y > this.c.height) {
 			return false;
@@ -807,11 +993,11 @@ function DrawLines(c) {
 				// unselected line
 				ctxClearRect(ctx, 1, y, this.c.width-this.marginsz-1, lnht);
 				var t = this.tabtxt(ln.txt);
-				ctxFillText(ctx, t, this.marginsz, y);
+				ctxFillText(ctx, t, this.lmargin(), y);
 				return true;
 			}
 			// up to p0 unselected
-			var dx = this.marginsz;
+			var dx = this.lmargin();
 			var s0 = 0;
 			var s0pos = 0;
 			if(this.p0 > ln.off){
@@ -820,7 +1006,7 @@ function DrawLines(c) {
 				s0pos = s0t.length;
 				dx += ctx.measureText(s0t).width;
 				ctxClearRect(ctx, 1, y, dx, lnht);
-				ctxFillText(ctx, s0t, this.marginsz, y);
+				ctxFillText(ctx, s0t, this.lmargin(), y);
 			}
 			// from p0 to p1 selected
 			var s1 = ln.txt.length - s0;
@@ -835,7 +1021,7 @@ function DrawLines(c) {
 			} else if(this.secondary) {
 				ctx.fillStyle = "#7373FF";
 			} else {
-				ctx.fillStyle = "#D1A0A0";
+				ctx.fillStyle = ctxTheme(ctx).sel;
 			}
 			if(this.p1 > ln.off+ln.txt.length) {
 				ctx.fillRect(dx, y, this.c.width-dx-this.marginsz-1, lnht);
@@ -860,7 +1046,7 @@ function DrawLines(c) {
 		// unselected line
 		ctxClearRect(ctx, 1, y, this.c.width-this.marginsz-1, lnht);
 		var t = this.tabtxt(ln.txt);
-		ctxFillText(ctx, t, this.marginsz, y);
+		ctxFillText(ctx, t, this.lmargin(), y);
 
 		if(this.p0 < ln.off || this.p0 > ln.off + ln.txt.length) {
 			return true;
@@ -868,7 +1054,7 @@ function DrawLines(c) {
 
 		// line with tick
 		var x = this.posdx(ln.txt, this.p0 - ln.off);
-		x += this.marginsz - 3*this.tscale/2;	// a bit to the left
+		x += this.lmargin() - 3*this.tscale/2;	// a bit to the left
 		this.tick(x, y);
 		return true;
 	};
@@ -964,7 +1150,7 @@ function DrawLines(c) {
 
 	this.wrapoff = function(t) {
 		var ctx = this.ctx;
-		var avail = this.c.width - this.marginsz;
+		var avail = this.c.width - this.marginsz - this.gutter;
 		var pos = 0;
 		var s = "";
 		if(tdebug) {
@@ -1037,7 +1223,7 @@ function DrawLines(c) {
 		var pos = 0;
 		for(; pos <= ln.txt.length; pos++){
 			var coff = this.posdx(ln.txt, pos);
-			if(coff+marginsz > x){
+			if(coff+marginsz+this.gutter > x){
 				if(pos > 0)
 					pos--;
 				break;
@@ -103,7 +103,19 @@ function showcontrol(e, tag) {
 }
 
 
+// Ask before closing the page while there are dirty controls,
+// so edits are not lost.
+function beforeunload(e) {
+	if($(".portlet-dirty").length == 0) {
+		return undefined;
+	}
+	var msg = "there are unsaved changes";
+	e.returnValue = msg;
+	return msg;
+}
+
 $(function(){
+	window.addEventListener("beforeunload", beforeunload);
 	document.setdirty = setdirty;
 	document.setfocus = setfocus;
 	document.setclean = setclean;
@@ -323,14 +335,45 @@ function updportlets() {
 	}
 }
 
+// Max size for files dropped on the page and uploaded.
+var maxupload = 16*1024*1024;
+
+// Files dropped are posted as upload name data colname (data in base64),
+// and text (eg. paths) as click4 text colname.
 function pgdrop(col, e) {
-	var data = e.dataTransfer.getData("Text");
 	var id = $(col).attr('id');
+	var files = e.dataTransfer.files;
+	if(files && files.length > 0) {
+		for(var i = 0; i < files.length; i++) {
+			pgupload(files[i], id);
+		}
+		return;
+	}
+	var data = e.dataTransfer.getData("Text");
 	if(data)
 		if(pgdebug)console.log("drop", data, "on", id);
 	document.post(["click4", data, id]);
 }
 
+function pgupload(f, id) {
+	if(f.size > maxupload) {
+		console.log("upload: " + f.name + ": too large");
+		return;
+	}
+	var r = new FileReader();
+	r.onload = function() {
+		// data:type;base64,xxxx
+		var data = r.result;
+		var i = data.indexOf(",");
+		if(pgdebug)console.log("upload", f.name, "on", id);
+		document.post(["upload", f.name, data.slice(i+1), id]);
+	};
+	r.onerror = function() {
+		console.log("upload: " + f.name + ": " + r.error);
+	};
+	r.readAsDataURL(f);
+}
+
 function pgupdate() {
 	if(pgdebug)console.log("layout updated");
 	var layout=["layout"];
@@ -349,6 +392,30 @@ function pgupdate() {
 	if(pgdebug)console.log(layout);
 }
 
+// Show a browser notification, posting notified when clicked.
+function notify(title, body, tag) {
+	if(!("Notification" in window)) {
+		return;
+	}
+	if(Notification.permission == "default") {
+		Notification.requestPermission(function(p) {
+			if(p == "granted") {
+				notify(title, body, tag);
+			}
+		});
+		return;
+	}
+	if(Notification.permission != "granted") {
+		return;
+	}
+	var n = new Notification(title, {body: body, tag: tag});
+	n.onclick = function() {
+		window.focus();
+		document.post(["notified", tag]);
+		n.close();
+	};
+}
+
 function pgapply(ev) {
 	if(!ev || !ev.Args || !ev.Args[0]){
 		console.log("apply: nil ev");
@@ -390,6 +457,78 @@ function pgapply(ev) {
 			removecontrol(el, false);
 		});
 		break;
+	case "move":
+		if(arg.length < 3){
+			console.log(this.divid, "apply: short move");
+			break;
+		}
+		var cols = $(".column");
+		var n = parseInt(arg[2]);
+		if(n < 0 || n >= cols.length) {
+			break;
+		}
+		var pl = $('.portlet[pgid="'+arg[1]+'"]');
+		$(cols[n]).append(pl);
+		break;
+	case "collapse":
+	case "expand":
+		if(arg.length < 2){
+			console.log(this.divid, "apply: short " + arg[0]);
+			break;
+		}
+		var icon = $('.portlet[pgid="'+arg[1]+'"]').find(".portlet-toggle").first();
+		var shown = icon.hasClass("ui-icon-minus");
+		if((arg[0] == "collapse") == shown) {
+			icon.click();
+		}
+		break;
+	case "max":
+		if(arg.length < 2){
+			console.log(this.divid, "apply: short max");
+			break;
+		}
+		// collapse the others in the column, show this one.
+		var pl = $('.portlet[pgid="'+arg[1]+'"]');
+		pl.closest(".column").find(".portlet").each(function(){
+			var icon = $(this).find(".portlet-toggle").first();
+			if(icon.hasClass("ui-icon-minus")) {
+				icon.click();
+			}
+		});
+		maxpl(pl);
+		break;
+	case "colname":
+		if(arg.length < 3){
+			console.log(this.divid, "apply: short colname");
+			break;
+		}
+		var cols = $(".column");
+		var n = parseInt(arg[1]);
+		if(n >= 0 && n < cols.length) {
+			$(cols[n]).find(".colname").first().html("<b><tt>"+arg[2]+"</tt></b>");
+		}
+		break;
+	case "ncols":
+		if(arg.length < 2){
+			console.log(this.divid, "apply: short ncols");
+			break;
+		}
+		location.replace(window.location.origin + "?ncol=" + arg[1]);
+		break;
+	case "notify":
+		if(arg.length < 4){
+			console.log(this.divid, "apply: short notify");
+			break;
+		}
+		notify(arg[1], arg[2], arg[3]);
+		break;
+	case "browse":
+		if(arg.length < 2){
+			console.log(this.divid, "apply: short browse");
+			break;
+		}
+		window.open(arg[1], "_blank");
+		break;
 	}
 }
 
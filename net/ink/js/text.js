@@ -42,6 +42,7 @@ function CliveText(d, c, cid, id) {
 
 	this.vers = 0;
 	this.noedits = false;
+	this.completes = false;
 
 	this.islocked = false;
 	this.locking = false;
@@ -202,6 +203,23 @@ function CliveText(d, c, cid, id) {
 		selecting = false;
 	};
 
+	// Paste the browser clipboard, if we may read it,
+	// or the snarf buffer kept by the server.
+	this.paste = function() {
+		var self = this;
+		var p0 = this.p0, p1 = this.p1;
+		if(!navigator.clipboard || !navigator.clipboard.readText){
+			this.post(["epaste", ""+p0, ""+p1]);
+			return;
+		}
+		navigator.clipboard.readText().then(function(s){
+			self.post(["esnarf", s]);
+			self.post(["epaste", ""+p0, ""+p1]);
+		}, function(){
+			self.post(["epaste", ""+p0, ""+p1]);
+		});
+	};
+
 	this.adjdel = function(pos, delp0, delp1) {
 		if(pos <= delp0)
 			return pos;
@@ -236,6 +254,9 @@ function CliveText(d, c, cid, id) {
 		case "edits":
 			this.noedits = false;
 			break;
+		case "completes":
+			this.completes = true;
+			break;
 		case "clean":
 			this.setclean();
 			break;
@@ -260,9 +281,38 @@ function CliveText(d, c, cid, id) {
 			console.log(this.id, "font", arg[1]);
 			this.fontstyle = arg[1];
 			this.fixfont();
+			this.nlines = Math.floor(this.c.height/this.fontht);
 			this.reformat(this.lns);
 			this.redrawtext();
 			break;
+		case "linenbs":
+			if(arg.length < 2){
+				console.log(this.id, "apply: short linenbs");
+				break;
+			}
+			this.linenbs = arg[1] == "on";
+			this.fixfont();
+			this.reformat(this.lns);
+			this.redrawtext();
+			break;
+		case "snarf":
+			if(arg.length < 2){
+				console.log(this.id, "apply: short snarf");
+				break;
+			}
+			// only the page with the focus may write the clipboard.
+			if(navigator.clipboard && document.hasFocus()){
+				navigator.clipboard.writeText(arg[1]).catch(function(){});
+			}
+			break;
+		case "theme":
+			if(arg.length < 2 || !themes[arg[1]]){
+				console.log(this.id, "apply: bad theme");
+				break;
+			}
+			this.ctx.theme = themes[arg[1]];
+			this.redrawtext();
+			break;
 		case "markinsing":
 			if(arg.length < 3){
 				console.log(this.id, "apply: short markinsing");
@@ -485,6 +535,25 @@ function CliveText(d, c, cid, id) {
 			}
 			var pos = parseInt(arg[2]);
 			this.setmark(arg[1], pos);
+			if(arg[1] == "bmatch" || this.isviewermark(arg[1]) || this.isspanmark(arg[1])) {
+				this.redrawtext();
+			}
+			break;
+		case "viewer":
+			if(arg.length < 3){
+				console.log(this.id, "apply: short viewer");
+				break;
+			}
+			this.addviewer(arg[1], arg[2]);
+			this.redrawtext();
+			break;
+		case "noviewer":
+			if(arg.length < 2){
+				console.log(this.id, "apply: short noviewer");
+				break;
+			}
+			this.delviewer(arg[1]);
+			this.redrawtext();
 			break;
 		case "sel":
 			if(arg.length < 3){
@@ -505,6 +574,9 @@ function CliveText(d, c, cid, id) {
 				break;
 			}
 			this.delmark(arg[1]);
+			if(arg[1] == "bmatch" || this.isspanmark(arg[1])) {
+				this.redrawtext();
+			}
 			break;
 		case "close":
 			this.ws.close();
@@ -572,6 +644,10 @@ function CliveText(d, c, cid, id) {
 			if(deferred) {
 				break;
 			}
+			if(this.completes && !e.shiftKey && this.p0 == this.p1) {
+				this.post(["complete", ""+this.p0]);
+				break;
+			}
 			if(this.p0 != this.p1){
 				this.Post(["edel", ""+this.p0, ""+this.p1]);
 			}
@@ -596,6 +672,10 @@ function CliveText(d, c, cid, id) {
 			if(deferred) {
 				break;
 			}
+			if(e.ctrlKey || e.metaKey) {
+				this.post(["hist", "prev"]);
+				break;
+			}
 			var n = Math.floor(this.frlines/4);
 			if(n < 1) {
 				n = 1;
@@ -618,6 +698,10 @@ function CliveText(d, c, cid, id) {
 			if(deferred) {
 				break;
 			}
+			if(e.ctrlKey || e.metaKey) {
+				this.post(["hist", "next"]);
+				break;
+			}
 			this.untick();
 			var n = Math.floor(this.frlines/4);
 			if(n < 1) {
@@ -721,7 +805,7 @@ function CliveText(d, c, cid, id) {
 				if(this.p0 != this.p1){
 					this.Post(["edel", ""+this.p0, ""+this.p1]);
 				}
-				this.post(["epaste", ""+this.p0, ""+this.p1]);
+				this.paste();
 				return false;
 			}
 			break;
@@ -1096,7 +1180,7 @@ function CliveText(d, c, cid, id) {
 				if(self.p0 != self.p1){
 					self.Post(["edel", ""+self.p0, ""+self.p1]);
 				}
-				self.post(["epaste", ""+self.p0, ""+self.p1]);
+				self.paste();
 			}
 			if(self.buttons == 1+8){
 				wassel = false;
@@ -5,6 +5,9 @@ import (
 	"clive/cmd"
 	"clive/net/auth"
 	"clive/net/ink/js"
+	"clive/zx"
+	"clive/zx/dav"
+	"crypto/tls"
 	"fmt"
 	"html"
 	"io"
@@ -25,7 +28,9 @@ import (
 // It's itself a control, and posts the events:
 //	start
 //	end
-//	click4 name colname
+//	click4 name colname	(text dropped on a column)
+//	upload name data colname	(file dropped on a column, data in base64)
+//	notified tag	(see Notify)
 //
 struct Pg {
 	*Ctlr
@@ -35,6 +40,7 @@ struct Pg {
 	Path   string
 	NoAuth bool            // set to true to disable auth
 	els    [][]io.WriterTo // of [] of string, Html, io.WriterTo
+	cnames []string        // column names, if any
 	idgen  int
 }
 
@@ -118,6 +124,19 @@ func ServeZX() {
 	http.HandleFunc("/zx/", AuthHandler(zxHandler))
 }
 
+// Serve fs by WebDAV at prefix (eg. "/dav"), authenticating
+// with the clive cookie or HTTP basic auth (see BasicAuthHandler).
+// Several trees may be served at different prefixes.
+func ServeDAV(prefix string, fs zx.Fs) *dav.Handler {
+	h := dav.New(prefix, fs)
+	ah := BasicAuthHandler(h)
+	http.Handle(h.Prefix()+"/", ah)
+	if h.Prefix() != "" {
+		http.Handle(h.Prefix(), ah)
+	}
+	return h
+}
+
 //go:generate mkjs
 // Serve the javascript files at /js.
 // Only needed if NewPg() is not used.
@@ -138,8 +157,29 @@ func UsePort(port string) {
 
 // Serve the pages.
 // Even if they are NoAuth, it's always through TLS.
+// The certificate is that of auth.TLSserver, and it's picked up
+// again when renewed or when auth.ReloadCerts is called (eg. on a hup),
+// without closing the connections already made.
 func Serve() error {
-	if err := http.ListenAndServeTLS(":"+servePort, auth.ServerPem, auth.ServerKey, nil); err != nil {
+	cfg := auth.TLSserver
+	if cfg == nil {
+		var err error
+		var cas []string
+		if auth.ServerCA != "" {
+			cas = append(cas, auth.ServerCA)
+		}
+		cfg, err = auth.ReloadingTLScfg(auth.ServerPem, auth.ServerKey, cas...)
+		if err != nil {
+			cmd.Warn("%s", err)
+			return err
+		}
+	}
+	l, err := tls.Listen("tcp", ":"+servePort, cfg)
+	if err != nil {
+		cmd.Warn("%s", err)
+		return err
+	}
+	if err := http.Serve(l, nil); err != nil {
 		cmd.Warn("%s", err)
 		return err
 	}
@@ -271,6 +311,11 @@ func NewColsPg(path string, cols ...[]face{}) *Pg {
 				}
 				pre += `<p></b></div>`
 			}
+			cname := ""
+			if i < len(pg.cnames) {
+				cname = html.EscapeString(pg.cnames[i])
+			}
+			pre += `<div class="colname"><b><tt>` + cname + `</tt></b></div>`
 			// $$ is replaced by writeEls to pgid="xxx"
 			pg.els[i] = writeEls(w, pg.els[i],
 				pre,
@@ -511,6 +556,90 @@ func (pg *Pg) layout(args []string) {
 	}
 }
 
+// Move the element with the given id to the end of the given column.
+func (pg *Pg) Move(id string, colnb int) error {
+	pg.Lock()
+	if colnb < 0 || colnb >= len(pg.els) {
+		pg.Unlock()
+		return fmt.Errorf("no column %d", colnb)
+	}
+	el := pg.dettach(id)
+	if el == nil {
+		pg.Unlock()
+		return fmt.Errorf("no element %s", id)
+	}
+	pg.els[colnb] = append(pg.els[colnb], el)
+	pg.Unlock()
+	pg.out <- &Ev{Id: pg.Id, Src: "app",
+		Args: []string{"move", id, strconv.Itoa(colnb)},
+	}
+	return nil
+}
+
+// Collapse the element with the given id, showing just its tag.
+func (pg *Pg) Collapse(id string) {
+	pg.out <- &Ev{Id: pg.Id, Src: "app", Args: []string{"collapse", id}}
+}
+
+// Undo a Collapse for the element with the given id.
+func (pg *Pg) Expand(id string) {
+	pg.out <- &Ev{Id: pg.Id, Src: "app", Args: []string{"expand", id}}
+}
+
+// Show the element with the given id, collapsing the others in its column.
+func (pg *Pg) Maximize(id string) {
+	pg.out <- &Ev{Id: pg.Id, Src: "app", Args: []string{"max", id}}
+}
+
+// Show a browser notification with the given title and body.
+// If the user clicks on it, a "notified" event with tag is posted
+// to the page events.
+func (pg *Pg) Notify(title, body, tag string) {
+	pg.out <- &Ev{Id: pg.Id, Src: "app", Args: []string{"notify", title, body, tag}}
+}
+
+// Ask the browser to open url in a new tab (it may block it as a popup).
+func (pg *Pg) Browse(url string) {
+	pg.out <- &Ev{Id: pg.Id, Src: "app", Args: []string{"browse", url}}
+}
+
+// Set the name shown for the given column.
+func (pg *Pg) NameCol(colnb int, name string) error {
+	pg.Lock()
+	if colnb < 0 || colnb >= len(pg.els) {
+		pg.Unlock()
+		return fmt.Errorf("no column %d", colnb)
+	}
+	for len(pg.cnames) < len(pg.els) {
+		pg.cnames = append(pg.cnames, "")
+	}
+	pg.cnames[colnb] = name
+	pg.Unlock()
+	pg.out <- &Ev{Id: pg.Id, Src: "app",
+		Args: []string{"colname", strconv.Itoa(colnb), html.EscapeString(name)},
+	}
+	return nil
+}
+
+// Return the column names, "" for unnamed ones.
+func (pg *Pg) ColNames() []string {
+	pg.Lock()
+	defer pg.Unlock()
+	names := make([]string, len(pg.els))
+	copy(names, pg.cnames)
+	return names
+}
+
+// Set the number of columns, moving the elements of removed columns
+// to the remaining ones. Views are reloaded to show the new columns.
+func (pg *Pg) SetNumCols(n int) {
+	if n <= 0 {
+		return
+	}
+	pg.setNumCols(n)
+	pg.out <- &Ev{Id: pg.Id, Src: "app", Args: []string{"ncols", strconv.Itoa(n)}}
+}
+
 func (pg *Pg) setNumCols(n int) {
 	if n <= 0 {
 		return
@@ -553,7 +682,7 @@ func (pg *Pg) handle(wev *Ev) {
 			dprintf("%s: del %s\ncols: %s\n", pg.Id, ev[1], pg.Cols())
 			go pg.Del(ev[1])
 		}
-	case "click2", "click4":
+	case "click2", "click4", "upload", "notified":
 		pg.post(wev)
 	case "layout":
 		if len(ev) < 2 {
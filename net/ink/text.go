@@ -21,6 +21,7 @@ import (
 //	epaste	p0 p1
 //	ecopy	p0 p1
 //	ecut	p0 p1
+//	esnarf	text	(the browser clipboard, sent before epaste)
 //	eins	text p0
 //	edel	p0 p1
 //	eundo
@@ -32,6 +33,8 @@ import (
 //	save
 //	quit
 //	focus
+//	hist	prev|next	(ctrl-up/down, to recall commands)
+//	complete	p0	(tab, if t.Completes() has been called)
 // Events sent from the viewer but not for the user:
 //	id
 // Events sent to the viewer (besides all reflected events):
@@ -43,7 +46,11 @@ import (
 //	close
 //	noedits
 //	edits
+//	completes
 //	font name
+//	theme name
+//	linenbs on|off
+//	snarf text	(for the browser clipboard)
 //	held
 //	rlse
 //	mark name pos
@@ -55,14 +62,16 @@ import (
 //	tag str
 //	show
 //	sel pos pos	(sets p0 and p1 marks)
+//	viewer id who	(another view is looking at the text)
+//	noviewer id	(it's gone)
 // Events sent to the user (besides those from the viewer):
-//	start
+//	start who
 //	end
 
 // Editable text control.
 // See Ctlr for the common API for controls.
 // The events posted to the user are:
-//	start
+//	start who	(who is user@host for the new view)
 //	end
 //	tag wordclicked
 //	click[1248]	textclicked	p0 p1	(buttons are 1, 2, 4, 8, 16, ...)
@@ -70,12 +79,15 @@ import (
 //	eins	text p0
 //	edel	p0 p1
 //	intr	esc|...
+//	hist	prev|next
+//	complete	p0
 //
 struct Txt {
 	*Ctlr
 	t             *txt.Text
 	tag           string // NB: this is not the page element tag.
 	noedits       bool   // It was a tag line but we no longer use it.
+	completes     bool   // tab asks for completion instead of inserting
 	cundo         bool
 	owner         string
 	held          []*Ev
@@ -84,6 +96,19 @@ struct Txt {
 	getslk        sync.Mutex
 	dirty, istemp bool
 	font          string
+	theme         string
+	linenbs       bool              // show line numbers
+	els           map[string]string // page element for each view id
+}
+
+// Another page element showing a Txt, made by Txt.Zerox.
+// Its views share the text, marks, dirty state, and undo history with
+// those of the Txt, but have their own selection and scroll.
+// Events from its views are posted to the Txt event channel and
+// Txt.ElemOf tells from which element they come.
+struct TxtView {
+	*Txt
+	id string
 }
 
 // Prevent t from getting dirty despite viewer or user calls.
@@ -99,7 +124,40 @@ func (t *Txt) ClientDoesUndoRedo() {
 
 // Write the HTML for the text control to a page.
 func (t *Txt) WriteTo(w io.Writer) (tot int64, err error) {
+	return t.writeTo(w, t.Id)
+}
+
+// Make a new page element showing the text.
+func (t *Txt) Zerox() *TxtView {
+	return &TxtView{Txt: t, id: fmt.Sprintf("%sz%d", t.Id, newId())}
+}
+
+// Return the page element id for the zerox.
+func (v *TxtView) GetId() string {
+	return v.id
+}
+
+// Write the HTML for the zerox to a page.
+func (v *TxtView) WriteTo(w io.Writer) (tot int64, err error) {
+	return v.Txt.writeTo(w, v.id)
+}
+
+// Return the id of the page element (the Txt or one of its zeroxes)
+// for the view with the given id, or "" if it's unknown.
+func (t *Txt) ElemOf(vid string) string {
+	t.Lock()
+	defer t.Unlock()
+	return t.els[vid]
+}
+
+func (t *Txt) writeTo(w io.Writer, elid string) (tot int64, err error) {
 	vid := t.newViewId()
+	t.Lock()
+	if t.els == nil {
+		t.els = map[string]string{}
+	}
+	t.els[vid] = elid
+	t.Unlock()
 
 	n, err := io.WriteString(w, `
 		<div id="`+vid+`" class="`+t.Id+` ui-widget-content", `+
@@ -159,11 +217,56 @@ func NewTxt(lines ...string) *Txt {
 // Change the font used.
 // Known fonts are "r", "b", "i", "t".
 // Known combinations are "rb", "tb", and "ri".
+// The style may be followed by the size in points (12 by default)
+// and by :family to use that font family, as in "t14" or "r:Georgia".
 func (t *Txt) SetFont(f string) {
 	t.font = f
 	t.out <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"font", f}}
 }
 
+// Known color themes.
+var Themes = []string{"light", "dark", "paper"}
+
+// Change the color theme used, one of Themes.
+func (t *Txt) SetTheme(name string) error {
+	for _, th := range Themes {
+		if th == name {
+			t.theme = name
+			t.out <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"theme", name}}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown theme %s", name)
+}
+
+// Show (or hide) line numbers at the left of the text.
+func (t *Txt) ShowLineNbs(on bool) {
+	t.Lock()
+	t.linenbs = on
+	t.Unlock()
+	v := "off"
+	if on {
+		v = "on"
+	}
+	t.out <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"linenbs", v}}
+}
+
+// Are line numbers shown?
+func (t *Txt) LineNbs() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.linenbs
+}
+
+// Set the snarf buffer to s, and the clipboard of the browser
+// where the page has the focus.
+func (t *Txt) Snarf(s string) {
+	if err := snarf.Set(s); err != nil {
+		dprintf("%s: snarf: %s\n", t.Id, err)
+	}
+	t.out <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"snarf", s}}
+}
+
 func (t *Txt) sendLine(toid string, to chan<- *Ev, buf *bytes.Buffer) bool {
 	s := buf.String()
 	buf.Reset()
@@ -179,6 +282,15 @@ func (t *Txt) update(toid string) {
 	} else {
 		to <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"edits"}}
 	}
+	if t.completes {
+		to <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"completes"}}
+	}
+	if t.theme != "" {
+		to <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"theme", t.theme}}
+	}
+	if t.linenbs {
+		to <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"linenbs", "on"}}
+	}
 	ev := &Ev{Id: t.Id, Src: "", Args: []string{"reload"}}
 	if ok := to <- ev; !ok {
 		return
@@ -450,6 +562,26 @@ func (t *Txt) undoRedo(isredo bool) bool {
 	}
 }
 
+// Let other views know about the new one, and the new one about the others,
+// so they can show who is looking and where their selections are.
+func (t *Txt) viewers(wev *Ev) {
+	who := ""
+	if len(wev.Args) > 1 {
+		who = wev.Args[1]
+	}
+	t.out <- &Ev{Id: t.Id, Src: wev.Src, Args: []string{"viewer", wev.Src, who}}
+	to := t.viewOut(wev.Src)
+	for _, v := range t.getViews() {
+		if v.Id == "" || v.Id == wev.Src {
+			continue
+		}
+		ev := &Ev{Id: t.Id, Src: "", Args: []string{"viewer", v.Id, v.Who}}
+		if ok := to <- ev; !ok {
+			return
+		}
+	}
+}
+
 func (t *Txt) apply(wev *Ev) {
 
 	ev := wev.Args
@@ -462,7 +594,7 @@ func (t *Txt) apply(wev *Ev) {
 	default:
 		dprintf("%s: unhandled %v\n", t.Id, ev)
 		return
-	case "save", "quit", "tag", "click1", "click2", "click4", "click8", "focus":
+	case "save", "quit", "tag", "click1", "click2", "click4", "click8", "focus", "hist", "complete":
 		dprintf("%s: %v\n", t.Id, wev)
 		t.post(wev)
 	case "hold", "held", "rlse", "rlsed":
@@ -477,6 +609,7 @@ func (t *Txt) apply(wev *Ev) {
 		p1 := t.t.Mark("p1")
 		t.t.SetMark(wev.Src+"p1", p1.Off)
 		t.update(wev.Src)
+		t.viewers(wev)
 		t.post(wev)
 	case "needreload":
 		t.update(wev.Src)
@@ -491,6 +624,9 @@ func (t *Txt) apply(wev *Ev) {
 		t.out <- &Ev{Id: t.Id, Src: wev.Src, Args: []string{
 			"delmark", wev.Src + "p1",
 		}}
+		t.out <- &Ev{Id: t.Id, Src: wev.Src, Args: []string{
+			"noviewer", wev.Src,
+		}}
 		t.discard(wev.Src)
 	case "tick":
 		if len(ev) < 3 {
@@ -509,8 +645,14 @@ func (t *Txt) apply(wev *Ev) {
 		}
 		t.t.SetMark(wev.Src+"p0", p0)
 		t.t.SetMark(wev.Src+"p1", p1)
-		t.t.SetMark("p0", p0)
-		t.t.SetMark("p1", p1)
+		if el := t.ElemOf(wev.Src); el == "" || el == t.Id {
+			t.t.SetMark("p0", p0)
+			t.t.SetMark("p1", p1)
+		} else {
+			// zeroxes have their own selection
+			t.t.SetMark(el+"p0", p0)
+			t.t.SetMark(el+"p1", p1)
+		}
 		t.out <- &Ev{Id: t.Id, Src: wev.Src, Args: []string{
 			"mark", wev.Src + "p0", ev[1],
 		}}
@@ -553,9 +695,7 @@ func (t *Txt) apply(wev *Ev) {
 		} else {
 			rs := t.t.Del(p0, p1-p0)
 			if ev[0] == "ecut" {
-				if err := snarf.Set(string(rs)); err != nil {
-					dprintf("%s: %s: snarf: %s\n", t.Id, ev[0], err)
-				}
+				t.Snarf(string(rs))
 			} else {
 				t.t.ContdEdit()
 			}
@@ -576,7 +716,13 @@ func (t *Txt) apply(wev *Ev) {
 		if p1 > p0 {
 			s = t.getString(p0, p1-p0)
 		}
-		if err := snarf.Set(s); err != nil {
+		t.Snarf(s)
+	case "esnarf":
+		if len(ev) < 2 {
+			dprintf("%s: short esnarf\n", t.Id)
+			return
+		}
+		if err := snarf.Set(ev[1]); err != nil {
 			dprintf("%s: %s: snarf: %s\n", t.Id, ev[0], err)
 		}
 	case "epaste":
@@ -605,8 +751,14 @@ func (t *Txt) apply(wev *Ev) {
 		p1 := p0 + len(rs)
 		t.t.SetMark(wev.Src+"p0", p0)
 		t.t.SetMark(wev.Src+"p1", p1)
-		t.t.SetMark("p0", p0)
-		t.t.SetMark("p1", p1)
+		if el := t.ElemOf(wev.Src); el == "" || el == t.Id {
+			t.t.SetMark("p0", p0)
+			t.t.SetMark("p1", p1)
+		} else {
+			// zeroxes have their own selection
+			t.t.SetMark(el+"p0", p0)
+			t.t.SetMark(el+"p1", p1)
+		}
 		t.out <- &Ev{Id: t.Id, Src: "", Args: []string{"sel", strconv.Itoa(p0), strconv.Itoa(p1)}}
 		nev = &Ev{Id: t.Id, Src: "app", Vers: t.t.Vers(), Args: []string{
 			"tick", strconv.Itoa(p0), strconv.Itoa(p1),
@@ -669,6 +821,14 @@ func (t *Txt) NoEdits() {
 	t.out <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"noedits"}}
 }
 
+// Make tab post a complete event to the user instead of inserting a tab.
+func (t *Txt) Completes() {
+	t.Lock()
+	t.completes = true
+	t.Unlock()
+	t.out <- &Ev{Id: t.Id, Src: t.Id + "u", Args: []string{"completes"}}
+}
+
 // Permit user edits (default)
 func (t *Txt) Edits() {
 	t.Lock()
@@ -808,6 +968,30 @@ func (t *Txt) ContdEdit() {
 	t.t.ContdEdit()
 }
 
+// Set the selection just for the views of the given page element
+// (the Txt or one of its zeroxes).
+// For zeroxes, the selection is kept in the marks elid+"p0" and elid+"p1"
+// instead of p0 and p1.
+func (t *Txt) SetElSel(elid string, p0, p1 int) {
+	if elid == "" || elid == t.Id {
+		t.SetSel(p0, p1)
+		return
+	}
+	t.getText()
+	m0 := t.t.SetMark(elid+"p0", p0)
+	m1 := t.t.SetMark(elid+"p1", p1)
+	t.putText()
+	if m0 == nil || m1 == nil {
+		return
+	}
+	ev := &Ev{Id: t.Id, Src: "", Args: []string{"sel", strconv.Itoa(m0.Off), strconv.Itoa(m1.Off)}}
+	for _, vid := range t.Views() {
+		if t.ElemOf(vid) == elid {
+			t.viewOut(vid) <- ev
+		}
+	}
+}
+
 func (t *Txt) SetSel(p0, p1 int) {
 	t.getText()
 	defer t.putText()
@@ -835,6 +1019,49 @@ func (t *Txt) DelMark(name string) {
 	t.t.DelMark(name)
 }
 
+// Spans are ranges of text flagged by the user (eg., misspelled words),
+// kept as marks span:name.0 and span:name.1, and underlined by the viewers.
+func (t *Txt) SetSpan(name string, p0, p1 int) {
+	t.getText()
+	defer t.putText()
+	for i, p := range []int{p0, p1} {
+		mn := fmt.Sprintf("span:%s.%d", name, i)
+		if m := t.t.SetMark(mn, p); m != nil {
+			t.out <- &Ev{Id: t.Id, Src: "", Args: []string{"mark", mn, strconv.Itoa(m.Off)}}
+		}
+	}
+}
+
+// Return the name and range of the span with a name starting with pref
+// that contains off, if any.
+func (t *Txt) SpanAt(pref string, off int) (string, int, int, bool) {
+	t.getText()
+	defer t.putText()
+	for _, mn := range t.t.Marks() {
+		if !strings.HasPrefix(mn, "span:"+pref) || !strings.HasSuffix(mn, ".0") {
+			continue
+		}
+		name := mn[5 : len(mn)-2]
+		m0, m1 := t.t.Mark(mn), t.t.Mark("span:"+name+".1")
+		if m0 != nil && m1 != nil && m0.Off <= off && off <= m1.Off {
+			return name, m0.Off, m1.Off, true
+		}
+	}
+	return "", 0, 0, false
+}
+
+// Remove the spans with names starting with pref.
+func (t *Txt) DelSpans(pref string) {
+	t.getText()
+	defer t.putText()
+	for _, mn := range t.t.Marks() {
+		if strings.HasPrefix(mn, "span:"+pref) {
+			t.out <- &Ev{Id: t.Id, Src: "", Args: []string{"delmark", mn}}
+			t.t.DelMark(mn)
+		}
+	}
+}
+
 func (t *Txt) Mark(name string) *txt.Mark {
 	return t.t.Mark(name)
 }
@@ -859,6 +1086,16 @@ func (t *Txt) Marks() []string {
 	return t.t.Marks()
 }
 
+// Return the offset for the bracket matching the one at off, or -1.
+func (t *Txt) Match(off int) int {
+	return t.t.Match(off)
+}
+
+// Return the indentation context for the given line, or nil.
+func (t *Txt) IndentAt(ln int) *txt.Indent {
+	return t.t.IndentAt(ln)
+}
+
 func (t *Txt) MarkIns(mark string, data []rune) error {
 	// Sending 4k or so in a single event makes Safari
 	// take a very long time (30s) to post the event.
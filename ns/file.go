@@ -3,6 +3,8 @@ package ns
 import (
 	"clive/net/auth"
 	"clive/zx"
+	"clive/zx/p9"
+	"clive/zx/s3"
 	"clive/zx/rzx"
 	"clive/zx/zux"
 	"fmt"
@@ -16,11 +18,20 @@ var (
 	lfs   = map[string]zx.Fs{}
 	lfslk sync.Mutex
 
-	_fs  zx.RWFs       = &NS{}
-	_fs2 zx.Finder     = &NS{}
-	_fs3 zx.FindGetter = &NS{}
+	_fs  zx.RWFs        = &NS{}
+	_fs2 zx.Finder      = &NS{}
+	_fs3 zx.FindGetter  = &NS{}
+	_fs4 zx.Symlinker   = &NS{}
+	_fs5 zx.Copier      = &NS{}
+	_fs6 zx.Grepper     = &NS{}
+	_fs7 zx.Summer      = &NS{}
+	_fs8 zx.MultiStater = &NS{}
 )
 
+// Max number of concurrent stats made by Stats for trees that
+// are not MultiStaters.
+const maxStats = 16
+
 // For testing
 func delLfsPath(path string) {
 	path, err := zx.UseAbsPath(path)
@@ -108,6 +119,12 @@ func DirFs(d zx.Dir) (zx.Fs, error) {
 		addr = addr[3:] // remove zx!
 		// rzx does cache dials, no need to do it again here.
 		return rzx.Dial(addr, auth.TLSclient)
+	case "9p":
+		// and so does p9.
+		return p9.Dial(d.SAddr()[3:])
+	case "s3":
+		// and so does s3, using the keys in the env.
+		return s3.Dial(d.SAddr()[3:], nil)
 	default:
 		return nil, fmt.Errorf("ns: no tree for addr %q", d["addr"])
 	}
@@ -125,6 +142,18 @@ func derr(err error) <-chan zx.Dir {
 	return c
 }
 
+func serr(err error) <-chan string {
+	c := make(chan string)
+	close(c, err)
+	return c
+}
+
+func gerr(err error) <-chan face{} {
+	c := make(chan face{})
+	close(c, err)
+	return c
+}
+
 func rerr(err error) <-chan error {
 	c := make(chan error, 1)
 	c <- err
@@ -162,6 +191,119 @@ func (ns *NS) Stat(path string) <-chan zx.Dir {
 	return rc
 }
 
+// Paths stated in a single request to a tree
+struct statBatch {
+	fs    zx.MultiStater
+	idx   []int    // of the paths given to Stats
+	paths []string // in the tree
+}
+
+// Stat the given paths and send their entries in the same order.
+// Paths in the same tree are stated using a single request if the
+// tree is a zx.MultiStater, and the others are stated concurrently.
+// The entry for a path that can't be stated has just the "path"
+// and "err" attributes.
+func (ns *NS) Stats(paths ...string) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		ds := make([]zx.Dir, len(paths))
+		pnames := make([]string, len(paths))
+		failed := func(i int, err error) {
+			if err == nil {
+				err = zx.ErrBug
+			}
+			ds[i] = zx.Dir{"path": paths[i], "err": err.Error()}
+		}
+		var batches []*statBatch
+		byfs := map[zx.MultiStater]*statBatch{}
+		var rest []int
+		for i, p := range paths {
+			pname, mnts, err := ns.Resolve(p)
+			if err != nil {
+				failed(i, err)
+				continue
+			}
+			d := mnts[0]
+			pnames[i] = pname
+			if d["addr"] == "" {
+				d["path"] = fpath.Join(pname, d.SPath())
+				ds[i] = d
+				continue
+			}
+			fs, err := DirFs(d)
+			if err != nil {
+				failed(i, err)
+				continue
+			}
+			mfs, ok := fs.(zx.MultiStater)
+			if !ok {
+				rest = append(rest, i)
+				continue
+			}
+			b := byfs[mfs]
+			if b == nil {
+				b = &statBatch{fs: mfs}
+				byfs[mfs] = b
+				batches = append(batches, b)
+			}
+			b.idx = append(b.idx, i)
+			b.paths = append(b.paths, d.SPath())
+		}
+		var wg sync.WaitGroup
+		for _, b := range batches {
+			wg.Add(1)
+			go func(b *statBatch) {
+				defer wg.Done()
+				dc := b.fs.Stats(b.paths...)
+				n := 0
+				for d := range dc {
+					if n >= len(b.idx) {
+						continue
+					}
+					i := b.idx[n]
+					if d["err"] != "" {
+						ds[i] = zx.Dir{"path": paths[i], "err": d["err"]}
+					} else {
+						d["path"] = fpath.Join(pnames[i], b.paths[n])
+						ds[i] = d
+					}
+					n++
+				}
+				err := cerror(dc)
+				if err == nil && n < len(b.idx) {
+					err = zx.ErrBug
+				}
+				for ; n < len(b.idx); n++ {
+					failed(b.idx[n], err)
+				}
+			}(b)
+		}
+		tokc := make(chan bool, maxStats)
+		for _, i := range rest {
+			wg.Add(1)
+			tokc <- true
+			go func(i int) {
+				defer wg.Done()
+				dc := ns.Stat(paths[i])
+				if d := <-dc; d != nil {
+					ds[i] = d
+				} else {
+					failed(i, cerror(dc))
+				}
+				<-tokc
+			}(i)
+		}
+		wg.Wait()
+		for _, d := range ds {
+			if ok := rc <- d; !ok {
+				return
+			}
+		}
+		close(rc)
+	}()
+	return rc
+}
+
 func (ns *NS) Get(path string, off, count int64) <-chan []byte {
 	_, ds, err := ns.Resolve(path)
 	if err != nil {
@@ -295,3 +437,164 @@ func (ns *NS) Move(from, to string) <-chan error {
 	}
 	return xfs.Move(fromd.SPath(), tod.SPath())
 }
+
+// Absolute targets for links are names in the ns, and they must refer
+// to the tree where the link is made.
+// On unions, the first entry is always used.
+func (ns *NS) Symlink(target, newp string) <-chan error {
+	_, ds, err := ns.Resolve(newp)
+	if err != nil {
+		return rerr(err)
+	}
+	d := ds[0]
+	fs, err := DirFs(d)
+	if err != nil {
+		return rerr(err)
+	}
+	if fpath.IsAbs(target) {
+		_, tds, err := ns.Resolve(target)
+		if err != nil {
+			return rerr(err)
+		}
+		td := tds[0]
+		if td.SAddr() != d.SAddr() {
+			return rerr(fmt.Errorf("%s: cross device link", newp))
+		}
+		target = td.SPath()
+	}
+	xfs, ok := fs.(zx.Symlinker)
+	if !ok {
+		return rerr(fmt.Errorf("%s: tree is not a symlinker", newp))
+	}
+	return xfs.Symlink(target, d.SPath())
+}
+
+// Absolute targets for links are reported as names in the ns.
+func (ns *NS) Lstat(path string) <-chan zx.Dir {
+	pname, ds, err := ns.Resolve(path)
+	if err != nil {
+		return derr(err)
+	}
+	d := ds[0]
+	if d["addr"] == "" {
+		return ns.Stat(path)
+	}
+	fs, err := DirFs(d)
+	if err != nil {
+		return derr(err)
+	}
+	rc := make(chan zx.Dir)
+	go func() {
+		rd, err := zx.Lstat(fs, d.SPath())
+		if rd != nil {
+			rd["path"] = fpath.Join(pname, d.SPath())
+			if t := rd["target"]; fpath.IsAbs(t) {
+				rd["target"] = fpath.Join(pname, t)
+			}
+			rc <- rd
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Copies within a tree are made by the tree, and copies between trees
+// in the same rzx server are made by the server.
+// Otherwise, the data is retrieved and put by the caller.
+// On unions, the first entry is always used.
+func (ns *NS) Copy(from, to string) <-chan error {
+	_, fromds, err := ns.Resolve(from)
+	if err != nil {
+		return rerr(err)
+	}
+	fromd := fromds[0]
+	fromfs, err := DirFs(fromd)
+	if err != nil {
+		return rerr(err)
+	}
+	_, tods, err := ns.Resolve(to)
+	if err != nil {
+		return rerr(err)
+	}
+	tod := tods[0]
+	if fromd.SAddr() == tod.SAddr() {
+		return rerr(zx.Copy(fromfs, fromd.SPath(), tod.SPath()))
+	}
+	tofs, err := DirFs(tod)
+	if err != nil {
+		return rerr(err)
+	}
+	rfrom, ok1 := fromfs.(*rzx.Fs)
+	rto, ok2 := tofs.(*rzx.Fs)
+	if ok1 && ok2 {
+		return rfrom.CopyTo(fromd.SPath(), rto, tod.SPath())
+	}
+	return rerr(zx.CopyTo(fromfs, fromd.SPath(), tofs, tod.SPath()))
+}
+
+// Does the ns have other prefixes mounted under name?
+func (ns *NS) hasSuffixes(name string) bool {
+	ns.lk.RLock()
+	defer ns.lk.RUnlock()
+	for _, p := range ns.pref {
+		if p.name != name && zx.HasPrefix(p.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Searches at a single tree with nothing mounted under name are made
+// by the tree, and file names in the matches are reported as names in the ns.
+// Otherwise, the files are found and retrieved through the ns to search them.
+// On unions, all entries are searched.
+func (ns *NS) Grep(name, fpred, rexp string, nctx int) <-chan face{} {
+	path, err := zx.UseAbsPath(name)
+	if err != nil {
+		return gerr(err)
+	}
+	_, ds, err := ns.Resolve(path)
+	if err != nil {
+		return gerr(err)
+	}
+	if len(ds) > 1 || ds[0]["addr"] == "" || ns.hasSuffixes(path) {
+		return zx.GrepFiles(ns, path, fpred, rexp, nctx)
+	}
+	d := ds[0]
+	fs, err := DirFs(d)
+	if err != nil {
+		return gerr(err)
+	}
+	spath := d.SPath()
+	rc := make(chan face{})
+	go func() {
+		gc := zx.Grep(fs, spath, fpred, rexp, nctx)
+		for x := range gc {
+			if a, ok := x.(zx.Addr); ok {
+				a.Name = fpath.Join(path, zx.Suffix(a.Name, spath))
+				x = a
+			}
+			if ok := rc <- x; !ok {
+				close(gc, cerror(rc))
+				break
+			}
+		}
+		close(rc, cerror(gc))
+	}()
+	return rc
+}
+
+// Checksums are computed by the tree for the file.
+// On unions, the first entry is always used.
+func (ns *NS) Sums(path string, blksz int64) <-chan string {
+	_, ds, err := ns.Resolve(path)
+	if err != nil {
+		return serr(err)
+	}
+	d := ds[0]
+	fs, err := DirFs(d)
+	if err != nil {
+		return serr(err)
+	}
+	return zx.Sums(fs, d.SPath(), blksz)
+}
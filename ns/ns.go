@@ -14,6 +14,8 @@ import (
 	"bytes"
 	"clive/dbg"
 	"clive/zx"
+	"clive/zx/p9"
+	"clive/zx/s3"
 	"clive/zx/rzx"
 	"fmt"
 	"io/ioutil"
@@ -197,7 +199,7 @@ func specialForm(ln string) zx.Dir {
 		addr = fmt.Sprintf("lfs!%s!/", addr)
 	} else {
 		els := strings.Split(addr, "!")
-		if els[0] != "zx" && els[0] != "lfs" {
+		if els[0] != "zx" && els[0] != "lfs" && els[0] != "9p" && els[0] != "s3" {
 			els = append([]string{"zx"}, els...)
 			addr = "zx!" + addr
 		}
@@ -206,6 +208,24 @@ func specialForm(ln string) zx.Dir {
 			case 2:
 				addr += "!/"
 			}
+		} else if els[0] == "9p" {
+			switch len(els) {
+			case 6: // 9p!unix!*!/tmp/ns.nemo/acme!!/
+			case 5: // 9p!tcp!host!564!aname
+				addr += "!/"
+			default:
+				naddr := p9.FillAddr(strings.Join(els[1:], "!"))
+				addr = els[0] + "!" + naddr + "!/"
+			}
+		} else if els[0] == "s3" {
+			switch len(els) {
+			case 6: // s3!https!host!443!bucket!/
+			case 5: // s3!https!host!443!bucket/prefix
+				addr += "!/"
+			default:
+				naddr := s3.FillAddr(strings.Join(els[1:], "!"))
+				addr = els[0] + "!" + naddr + "!/"
+			}
 		} else {
 			switch len(els) {
 			case 6: // zx!unix!localhost!zx!main!/
@@ -234,8 +254,12 @@ func specialForm(ln string) zx.Dir {
 // to dial the given addr or use the given lfs filepath and mount it at path.
 //
 // A full addr is proto!net!host!port!tree!path,
-// where proto can be zx|lfs.
+// where proto can be zx|lfs|9p|s3.
 // For lfs, the addr is of the form lfs!lfsroot!path
+// For 9p, the tree is the attach name, and the port the
+// path for unix sockets, eg. 9p!unix!*!/tmp/ns.nemo/acme!!/
+// For s3, the net is the URL scheme and the tree the bucket,
+// perhaps with a key prefix, eg. s3!https!host!443!bucket/a!/
 // zx is implied it no proto is given.
 // Any suffix components may be absent so we accept
 //	localhost!zx	-> zx!tcp!localhost!zx!main!/
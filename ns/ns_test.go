@@ -27,7 +27,9 @@ var (
 /tmp	lfs!/!/tmp
 /tmp	lfs!/tmp
 /usr
+/usr/acme	9p!unix!*!/tmp/ns.nemo/acme
 /usr/nemo	zx!unix!8089!/tmp
+/usr/s3	s3!minio!9000!bkt/nemo
 path:"/x"	io:"0"	addr:"zx!unix!8089!/tmp"
 `
 
@@ -36,7 +38,9 @@ path:"/x"	io:"0"	addr:"zx!unix!8089!/tmp"
 /tmp	lfs!/!/tmp
 /tmp
 /usr
+/usr/acme	9p!unix!*!/tmp/ns.nemo/acme!!/
 /usr/nemo	zx!unix!8089!/tmp!main!/
+/usr/s3	s3!https!minio!9000!bkt/nemo!/
 name:"x" type:"p" mode:"0644" path:"/x" addr:"zx!unix!8089!/tmp" io:"0"
 `
 )
@@ -528,10 +532,30 @@ func TestGets(t *testing.T) {
 	runTest(t, fstest.Gets)
 }
 
+func TestDirPages(t *testing.T) {
+	runTest(t, fstest.DirPages)
+}
+
 func TestPuts(t *testing.T) {
 	runTest(t, fstest.Puts)
 }
 
+func TestAppends(t *testing.T) {
+	runTest(t, fstest.Appends)
+}
+
+func TestCopies(t *testing.T) {
+	runTest(t, fstest.Copies)
+}
+
+func TestGreps(t *testing.T) {
+	runTest(t, fstest.Greps)
+}
+
+func TestSums(t *testing.T) {
+	runTest(t, fstest.Sums)
+}
+
 func TestMkdirs(t *testing.T) {
 	runTest(t, fstest.Mkdirs)
 }
@@ -548,6 +572,10 @@ func TestAsAFile(t *testing.T) {
 	runTest(t, fstest.AsAFile)
 }
 
+func TestSymlinks(t *testing.T) {
+	runTest(t, fstest.Symlinks)
+}
+
 func TestFinds(t *testing.T) {
 	runTest(t, fstest.Finds)
 }
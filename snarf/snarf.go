@@ -1,23 +1,34 @@
 /*
 	Access to the system clipboard.
+
+	Texts set are also kept here, and Get returns the last one
+	when there is no system clipboard, so that programs (eg., ink
+	pages) have a snarf buffer anyway.
 */
 package snarf
 
-// BUG(x): As of now, only for darwin
+// BUG(x): As of now, the system clipboard is used only on darwin.
 
 // REFERNCE(x): clive/wax/..., wax window system.
 
 import (
 	"os/exec"
+	"runtime"
 	"sync"
 )
 
-var snarflk sync.Mutex
+var (
+	snarflk sync.Mutex
+	buf     string // last text set
+)
 
 // Get the clipboard text
 func Get() (string, error) {
 	snarflk.Lock()
 	defer snarflk.Unlock()
+	if runtime.GOOS != "darwin" {
+		return buf, nil
+	}
 	cmd := exec.Command("/usr/bin/pbpaste")
 	txt, err := cmd.Output()
 	return string(txt), err
@@ -27,6 +38,10 @@ func Get() (string, error) {
 func Set(s string) error {
 	snarflk.Lock()
 	defer snarflk.Unlock()
+	buf = s
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
 	cmd := exec.Command("/usr/bin/pbcopy")
 	ifd, err := cmd.StdinPipe()
 	if err != nil {
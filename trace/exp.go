@@ -0,0 +1,166 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter printing spans to a writer, one per line.
+struct Log {
+	sync.Mutex
+	w io.Writer
+}
+
+// Exporter sending spans to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding. Spans are sent in batches.
+struct OTLP {
+	URL     string // eg. http://localhost:4318/v1/traces
+	Service string // service.name for the spans
+
+	sync.Mutex
+	spans []*Span
+	kick  chan bool
+}
+
+// Spans are sent to OTLP collectors at least once per OTLPival,
+// or when this many are pending.
+var (
+	OTLPival  = 2 * time.Second
+	OTLPbatch = 128
+)
+
+func NewLog(w io.Writer) *Log {
+	return &Log{w: w}
+}
+
+func (l *Log) Export(s *Span) {
+	l.Lock()
+	defer l.Unlock()
+	fmt.Fprintf(l.w, "trace: %s\n", s)
+}
+
+func NewOTLP(url, service string) *OTLP {
+	o := &OTLP{URL: url, Service: service, kick: make(chan bool, 1)}
+	go o.sender()
+	return o
+}
+
+func (o *OTLP) Export(s *Span) {
+	o.Lock()
+	o.spans = append(o.spans, s)
+	n := len(o.spans)
+	o.Unlock()
+	if n >= OTLPbatch {
+		select {
+		case o.kick <- true:
+		default:
+		}
+	}
+}
+
+func (o *OTLP) sender() {
+	for {
+		select {
+		case <-o.kick:
+		case <-time.After(OTLPival):
+		}
+		o.Lock()
+		ss := o.spans
+		o.spans = nil
+		o.Unlock()
+		if len(ss) == 0 {
+			continue
+		}
+		if err := o.send(ss); err != nil {
+			fmt.Fprintf(os.Stderr, "trace: otlp: %s\n", err)
+		}
+	}
+}
+
+type otlpAttr map[string]face{}
+
+func attr(k, v string) otlpAttr {
+	return otlpAttr{"key": k, "value": map[string]string{"stringValue": v}}
+}
+
+func (o *OTLP) send(ss []*Span) error {
+	var spans []face{}
+	for _, s := range ss {
+		s.Lock()
+		var attrs []otlpAttr
+		for _, a := range s.Attrs {
+			attrs = append(attrs, attr(a[0], a[1]))
+		}
+		st := map[string]face{}{"code": 1}
+		if s.Err != "" {
+			st = map[string]face{}{"code": 2, "message": s.Err}
+		}
+		spans = append(spans, map[string]face{}{
+			"traceId":           s.Trace,
+			"spanId":            s.Span,
+			"parentSpanId":      s.Parent,
+			"name":              s.Name,
+			"kind":              1,
+			"startTimeUnixNano": fmt.Sprintf("%d", s.Start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprintf("%d", s.End.UnixNano()),
+			"attributes":        attrs,
+			"status":            st,
+		})
+		s.Unlock()
+	}
+	req := map[string]face{}{
+		"resourceSpans": []face{}{
+			map[string]face{}{
+				"resource": map[string]face{}{
+					"attributes": []otlpAttr{attr("service.name", o.Service)},
+				},
+				"scopeSpans": []face{}{
+					map[string]face{}{
+						"scope": map[string]string{"name": "clive"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	dat, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.Post(o.URL, "application/json", bytes.NewReader(dat))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", o.URL, r.Status)
+	}
+	return nil
+}
+
+/*
+	Add the exporters described by spec, a space separated list of
+		log		print spans to stderr
+		otlp=url	send spans to an OpenTelemetry collector at url
+	The service name is used for OTLP.
+*/
+func Setup(spec, service string) error {
+	for _, f := range strings.Fields(spec) {
+		switch {
+		case f == "log":
+			AddExporter(NewLog(os.Stderr))
+		case strings.HasPrefix(f, "otlp="):
+			AddExporter(NewOTLP(f[5:], service))
+		default:
+			return fmt.Errorf("trace: unknown exporter '%s'", f)
+		}
+	}
+	return nil
+}
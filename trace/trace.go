@@ -0,0 +1,207 @@
+/*
+	Request tracing for clive.
+
+	When tracing is enabled (see cmd and $clivetrace), a trace id is made
+	for each command (or RPC without a trace) and each step done on its
+	behalf is recorded as a span.
+	The trace context (trace and parent span ids) is carried by cmd.Ctx,
+	by ch.Mux conns, and by rzx requests, so that spans recorded by
+	ix, commands, and zx servers can be put together.
+
+	Spans are sent to the exporters added, eg. the OTLP one, to use
+	OpenTelemetry collectors. Without exporters spans are not recorded
+	but trace contexts are still propagated.
+*/
+package trace
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A trace context, using the W3C trace-context ids.
+struct Ctx {
+	Trace string // 32 hex digits
+	Span  string // 16 hex digits, the parent for new spans
+}
+
+// A timed step done on behalf of a trace.
+struct Span {
+	Ctx                // trace and id for this span
+	Parent string      // parent span id, or ""
+	Name   string      // what's being done
+	Start  time.Time   // when it started
+	End    time.Time   // when it ended
+	Attrs  [][2]string // attributes, in order
+	Err    string      // error for failed spans
+
+	sync.Mutex
+	onEnd func()
+}
+
+// Exporters get the spans once they end.
+interface Exporter {
+	Export(s *Span)
+}
+
+var (
+	// Return the trace context for the running process.
+	// The cmd package sets this to use the command context.
+	Current = func() Ctx { return Ctx{} }
+
+	ErrBadCtx = errors.New("bad trace context")
+
+	lk   sync.Mutex
+	exps []Exporter
+)
+
+func newId(n int) string {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		t := time.Now().UnixNano()
+		for i := range b {
+			b[i] = byte(t >> uint(8*(i%8)))
+		}
+	}
+	return hex.EncodeToString(b)
+}
+
+// Make a new trace context.
+func New() Ctx {
+	return Ctx{Trace: newId(16)}
+}
+
+// Return true if there's no trace in c.
+func (c Ctx) IsZero() bool {
+	return c.Trace == ""
+}
+
+// Return the context as a W3C traceparent string.
+func (c Ctx) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	sp := c.Span
+	if sp == "" {
+		sp = strings.Repeat("0", 16)
+	}
+	return fmt.Sprintf("00-%s-%s-01", c.Trace, sp)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Parse a context printed by Ctx.String().
+func Parse(s string) (Ctx, error) {
+	toks := strings.Split(s, "-")
+	if len(toks) != 4 || !isHex(toks[1], 32) || !isHex(toks[2], 16) {
+		return Ctx{}, ErrBadCtx
+	}
+	c := Ctx{Trace: toks[1], Span: toks[2]}
+	if c.Span == strings.Repeat("0", 16) {
+		c.Span = ""
+	}
+	return c, nil
+}
+
+// Add an exporter for spans.
+func AddExporter(e Exporter) {
+	lk.Lock()
+	defer lk.Unlock()
+	exps = append(exps, e)
+}
+
+// Return true if spans are being exported.
+func Enabled() bool {
+	lk.Lock()
+	defer lk.Unlock()
+	return len(exps) > 0
+}
+
+// Start a span for the given parent context.
+// If the parent is zero, a new trace is started.
+func Start(parent Ctx, name string) *Span {
+	if parent.IsZero() {
+		parent = New()
+	}
+	return &Span{
+		Ctx:    Ctx{Trace: parent.Trace, Span: newId(8)},
+		Parent: parent.Span,
+		Name:   name,
+		Start:  time.Now(),
+	}
+}
+
+// Add an attribute to the span.
+func (s *Span) Set(k, v string) {
+	s.Lock()
+	defer s.Unlock()
+	s.Attrs = append(s.Attrs, [2]string{k, v})
+}
+
+// Flag the span as failed with the given error (if not nil).
+func (s *Span) Fail(err error) {
+	if err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Err = err.Error()
+}
+
+// Call fn when the span ends.
+func (s *Span) OnEnd(fn func()) {
+	s.Lock()
+	defer s.Unlock()
+	s.onEnd = fn
+}
+
+// Terminate the span and send it to the exporters.
+// Calling it more than once is a nop.
+func (s *Span) Finish() {
+	s.Lock()
+	if !s.End.IsZero() {
+		s.Unlock()
+		return
+	}
+	s.End = time.Now()
+	fn := s.onEnd
+	s.Unlock()
+	if fn != nil {
+		fn()
+	}
+	lk.Lock()
+	es := exps
+	lk.Unlock()
+	for _, e := range es {
+		e.Export(s)
+	}
+}
+
+// Return the span duration.
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+func (s *Span) String() string {
+	s.Lock()
+	defer s.Unlock()
+	str := fmt.Sprintf("%s %s<-%s %s %v", s.Trace, s.Span, s.Parent, s.Name, s.Duration())
+	for _, a := range s.Attrs {
+		str += fmt.Sprintf(" %s=%s", a[0], a[1])
+	}
+	if s.Err != "" {
+		str += " err=" + s.Err
+	}
+	return str
+}
@@ -0,0 +1,79 @@
+package trace
+
+import (
+	"errors"
+	"testing"
+)
+
+struct testExp {
+	spans []*Span
+}
+
+func (e *testExp) Export(s *Span) {
+	e.spans = append(e.spans, s)
+}
+
+func TestParse(t *testing.T) {
+	c := New()
+	if c.IsZero() || len(c.Trace) != 32 {
+		t.Fatalf("bad new ctx %v", c)
+	}
+	s := c.String()
+	t.Logf("ctx %s", s)
+	nc, err := Parse(s)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if nc != c {
+		t.Fatalf("parse: got %v", nc)
+	}
+	sp := Start(c, "x")
+	nc, err = Parse(sp.Ctx.String())
+	if err != nil || nc != sp.Ctx {
+		t.Fatalf("parse: %v %v", nc, err)
+	}
+	bad := []string{"", "00-xx-yy-01", "00-" + c.Trace + "-0123-01", c.Trace}
+	for _, b := range bad {
+		if _, err := Parse(b); err == nil {
+			t.Fatalf("parse '%s': no error", b)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	e := &testExp{}
+	AddExporter(e)
+	defer func() {
+		lk.Lock()
+		exps = nil
+		lk.Unlock()
+	}()
+	if !Enabled() {
+		t.Fatalf("not enabled")
+	}
+	s1 := Start(Ctx{}, "one")
+	if s1.IsZero() || s1.Parent != "" {
+		t.Fatalf("bad root span %v", s1)
+	}
+	s2 := Start(s1.Ctx, "two")
+	if s2.Trace != s1.Trace || s2.Parent != s1.Span || s2.Span == s1.Span {
+		t.Fatalf("bad child span %v", s2)
+	}
+	ended := false
+	s2.OnEnd(func() { ended = true })
+	s2.Set("path", "/a")
+	s2.Fail(errors.New("oops"))
+	s2.Finish()
+	s2.Finish()
+	s1.Finish()
+	if !ended {
+		t.Fatalf("on end not called")
+	}
+	if len(e.spans) != 2 || e.spans[0] != s2 || e.spans[1] != s1 {
+		t.Fatalf("bad exported spans %v", e.spans)
+	}
+	t.Logf("%s", s2)
+	if s2.Err != "oops" || len(s2.Attrs) != 1 || s2.Attrs[0][1] != "/a" {
+		t.Fatalf("bad span %s", s2)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -201,6 +202,44 @@ func (t *Text) DropEdits() {
 	t.contd = false
 }
 
+/*
+	Return a copy of the edits kept for undo/redo and
+	the number of them applied to the text (those that can be undone).
+*/
+func (t *Text) Edits() ([]Edit, int) {
+	t.Lock()
+	defer t.Unlock()
+	es := make([]Edit, len(t.edits))
+	for i, e := range t.edits {
+		es[i] = *e
+		es[i].Data = append([]rune(nil), e.Data...)
+	}
+	return es, t.nedits
+}
+
+/*
+	Replace the edits kept for undo/redo with the given ones,
+	of which n are taken as applied to the text.
+	The caller must make sure they correspond to the text.
+*/
+func (t *Text) SetEdits(es []Edit, n int) {
+	t.Lock()
+	defer t.Unlock()
+	if t.edits == nil {
+		return
+	}
+	if n < 0 || n > len(es) {
+		n = len(es)
+	}
+	t.edits = make([]*Edit, 0, len(es))
+	for _, e := range es {
+		e := e
+		t.edits = append(t.edits, &e)
+	}
+	t.nedits = n
+	t.contd = false
+}
+
 func (t *Text) addEdit(op Tedit, pos int, data []rune, same bool) *Edit {
 	if t.edits == nil {
 		return &Edit{op, pos, data, same}
@@ -597,6 +636,10 @@ func (t *Text) Get(off int, n int) <-chan []rune {
 func (t *Text) Getc(off int) rune {
 	t.Lock()
 	defer t.Unlock()
+	return t.getc(off)
+}
+
+func (t *Text) getc(off int) rune {
 	d := t.data
 	switch off {
 	case t.seek.off:
@@ -843,3 +886,119 @@ func (t *Text) DelAll() {
 	e := t.addEdit(Edel, 0, dat, contd)
 	t.markEdit(e)
 }
+
+const brackets = "()[]{}"
+
+/*
+	Return the offset for the bracket matching the one at off,
+	or -1 if there's no bracket at off or it's not matched.
+*/
+func (t *Text) Match(off int) int {
+	t.Lock()
+	defer t.Unlock()
+	if off < 0 || off >= t.sz {
+		return -1
+	}
+	c := t.getc(off)
+	i := strings.IndexRune(brackets, c)
+	if i < 0 {
+		return -1
+	}
+	o := rune(brackets[i^1])
+	dir := 1
+	if i%2 == 1 {
+		dir = -1
+	}
+	n := 0
+	for p := off; p >= 0 && p < t.sz; p += dir {
+		switch t.getc(p) {
+		case c:
+			n++
+		case o:
+			n--
+			if n == 0 {
+				return p
+			}
+		}
+	}
+	return -1
+}
+
+// Width for tabs in indentation widths.
+var TabWidth = 8
+
+/*
+	Indentation context for a line
+*/
+struct Indent {
+	Ln       int    // line number
+	Ind      string // leading blanks
+	Width    int    // width of Ind in columns
+	Blank    bool   // blank line; Ind and Width are those of the previous line
+	Ln0, Ln1 int    // block: lines around Ln indented at least as much
+	Parent   int    // line before the block, indented less, or 0
+}
+
+func indentOf(ln string) (string, int) {
+	w := 0
+	for i, r := range ln {
+		switch r {
+		case ' ':
+			w++
+		case '\t':
+			w += TabWidth - w%TabWidth
+		default:
+			return ln[:i], w
+		}
+	}
+	return ln, w
+}
+
+func isBlank(ln string) bool {
+	return strings.TrimSpace(ln) == ""
+}
+
+/*
+	Return the indentation context for the given line number
+	or nil if there's no such line.
+	Blank lines within a block are part of it.
+*/
+func (t *Text) IndentAt(ln int) *Indent {
+	t.Lock()
+	var w bytes.Buffer
+	for _, d := range t.data {
+		w.WriteString(string(d))
+	}
+	t.Unlock()
+	lns := strings.Split(w.String(), "\n")
+	if ln < 1 || ln > len(lns) {
+		return nil
+	}
+	in := &Indent{Ln: ln}
+	i := ln - 1
+	for i > 0 && isBlank(lns[i]) {
+		i--
+	}
+	in.Blank = isBlank(lns[ln-1])
+	in.Ind, in.Width = indentOf(lns[i])
+	inblk := func(l string) bool {
+		_, w := indentOf(l)
+		return isBlank(l) || w >= in.Width
+	}
+	l0, l1 := ln-1, ln-1
+	for l0 > 0 && inblk(lns[l0-1]) {
+		l0--
+	}
+	for l1 < len(lns)-1 && inblk(lns[l1+1]) {
+		l1++
+	}
+	in.Parent = l0
+	for l0 < ln-1 && isBlank(lns[l0]) {
+		l0++
+	}
+	for l1 > ln-1 && isBlank(lns[l1]) {
+		l1--
+	}
+	in.Ln0, in.Ln1 = l0+1, l1+1
+	return in
+}
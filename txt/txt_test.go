@@ -204,6 +204,30 @@ var tests2 = []test{
 	},
 }
 
+func TestSetEdits(t *testing.T) {
+	tx := NewEditing(nil)
+	tx.Ins([]rune("hello"), 0)
+	tx.Ins([]rune("!"), 5)
+	tx.Del(0, 1)
+	es, n := tx.Edits()
+	if n != len(es) || n == 0 {
+		t.Fatalf("bad edits %v %d", es, n)
+	}
+	nt := NewEditing([]rune(tx.String()))
+	nt.DropEdits()
+	nt.SetEdits(es, n)
+	for nt.Undo() != nil {
+	}
+	if s := nt.String(); s != "" {
+		t.Fatalf("bad undo: %q", s)
+	}
+	for nt.Redo() != nil {
+	}
+	if s := nt.String(); s != "ello!" {
+		t.Fatalf("bad redo: %q", s)
+	}
+}
+
 func TestMark(t *testing.T) {
 	debug = testing.Verbose()
 
@@ -251,3 +275,38 @@ func TestMark(t *testing.T) {
 		}
 	}
 }
+
+func TestMatch(t *testing.T) {
+	debug = testing.Verbose()
+	tx := NewEditing([]rune("f(a[1], {b}) (x"))
+	outs := map[int]int{1: 11, 11: 1, 3: 5, 5: 3, 8: 10, 10: 8, 13: -1, 0: -1, 20: -1}
+	for o, m := range outs {
+		n := tx.Match(o)
+		printf("\t%d -> %d\n", o, n)
+		if n != m {
+			t.Fatalf("match %d: got %d; want %d", o, n, m)
+		}
+	}
+}
+
+func TestIndentAt(t *testing.T) {
+	debug = testing.Verbose()
+	tx := NewEditing([]rune("func f() {\n\tif x {\n\t\ta()\n\n\t\tb()\n\t}\n\tc()\n}\n"))
+	ins := []Indent{
+		{Ln: 1, Ind: "", Width: 0, Ln0: 1, Ln1: 8, Parent: 0},
+		{Ln: 2, Ind: "\t", Width: 8, Ln0: 2, Ln1: 7, Parent: 1},
+		{Ln: 3, Ind: "\t\t", Width: 16, Ln0: 3, Ln1: 5, Parent: 2},
+		{Ln: 4, Ind: "\t\t", Width: 16, Blank: true, Ln0: 3, Ln1: 5, Parent: 2},
+		{Ln: 7, Ind: "\t", Width: 8, Ln0: 2, Ln1: 7, Parent: 1},
+	}
+	for _, want := range ins {
+		in := tx.IndentAt(want.Ln)
+		printf("\t%d -> %+v\n", want.Ln, in)
+		if in == nil || *in != want {
+			t.Fatalf("line %d: got %+v; want %+v", want.Ln, in, want)
+		}
+	}
+	if tx.IndentAt(0) != nil || tx.IndentAt(20) != nil {
+		t.Fatalf("bad line with indent")
+	}
+}
@@ -0,0 +1,136 @@
+package zx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+	Attributes in a Dir are of four kinds:
+
+	Standard attributes (see StdAttrOrder) are those known by
+	all file systems.
+
+	System attributes (see SysAttrs) are computed by file systems
+	when they reply, like those describing locks, changes, or links.
+	They are never stored, and SysDup drops them.
+
+	Temporary attributes start with an upper-case letter and
+	are not stored either.
+
+	Any other attribute is a user attribute. Its name must start with
+	a lower-case letter and contain just letters, digits, '_', '-', and '.'.
+	Its value may be any UTF-8 string, and setting it to "" removes it.
+	File systems that store user attributes (eg. zux trees made
+	with NewZX) keep them as given, and rzx sends them untouched.
+
+	Lists are kept as their elements separated by ListSep,
+	as done for lists in the environment.
+*/
+
+// System attributes, computed by file systems and never stored.
+var SysAttrs = map[string]bool{
+	"chg":       true, // see Watcher
+	"lockuid":   true, // see Locker
+	"lockowner": true,
+	"lockexp":   true,
+	"target":    true, // see Symlinker
+}
+
+// Separator for elements in list attributes.
+const ListSep = "\b"
+
+// Is this the name of a system attribute
+func IsSys(name string) bool {
+	return SysAttrs[name]
+}
+
+// Is this the name of a user attribute
+func IsUser(name string) bool {
+	return name != "" && !IsStd(name) && !IsSys(name) && !IsTemp(name)
+}
+
+func validName(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsLower(r) {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+			r != '_' && r != '-' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// Check that the value is valid for the attribute.
+func CheckAttr(name, value string) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("attr %s: %s: value is not utf8", name, ErrBadAttr)
+	}
+	if IsTemp(name) || IsSys(name) || value == "" {
+		return nil
+	}
+	var err error
+	switch name {
+	case "mode":
+		var m uint64
+		m, err = strconv.ParseUint(value, 8, 64)
+		if err == nil && m > 07777 {
+			err = ErrBadAttr
+		}
+	case "size", "mtime":
+		_, err = strconv.ParseUint(value, 10, 64)
+	case "type":
+		if len(value) != 1 {
+			err = ErrBadAttr
+		}
+	default:
+		if !IsStd(name) && !validName(name) {
+			return fmt.Errorf("attr %q: %s: bad name", name, ErrBadAttr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("attr %s: %s: bad value %q", name, ErrBadAttr, value)
+	}
+	return nil
+}
+
+// Check all the attributes in d, eg. before a wstat.
+func (d Dir) CheckAttrs() error {
+	for k, v := range d {
+		if err := CheckAttr(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the value for a signed integer attribute at dir.
+func (d Dir) Int(attr string) int64 {
+	n, _ := strconv.ParseInt(d[attr], 0, 64)
+	return n
+}
+
+// Set a signed integer attribute
+func (d Dir) SetInt(name string, v int64) {
+	d[name] = strconv.FormatInt(v, 10)
+}
+
+// Get the value for a list attribute at dir (empty if not set).
+func (d Dir) List(attr string) []string {
+	v := d[attr]
+	if v == "" {
+		return []string{}
+	}
+	return strings.Split(v, ListSep)
+}
+
+// Set a list attribute
+func (d Dir) SetList(name string, l []string) {
+	d[name] = strings.Join(l, ListSep)
+}
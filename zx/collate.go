@@ -0,0 +1,162 @@
+package zx
+
+import (
+	"errors"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collation orders for names in dir listings.
+// Natural and Locale may be combined.
+type Collation int
+
+const (
+	ByteOrder Collation = 0 // plain byte order
+	Natural   Collation = 1 // numbers sort by value: file2 < file10
+	Locale    Collation = 2 // use the collation rules of $LC_COLLATE/$LANG
+)
+
+var (
+	colmu sync.Mutex
+	cols  = map[Collation]*collate.Collator{}
+)
+
+/*
+	Parse a collation: a comma separated list of
+	bytes, natural and locale. The empty string is ByteOrder.
+*/
+func ParseCollation(s string) (Collation, error) {
+	c := ByteOrder
+	for _, f := range strings.Split(s, ",") {
+		switch strings.TrimSpace(f) {
+		case "", "bytes":
+		case "natural":
+			c |= Natural
+		case "locale":
+			c |= Locale
+		default:
+			return c, errors.New("unknown collation '" + f + "'")
+		}
+	}
+	return c, nil
+}
+
+func (c Collation) String() string {
+	switch c {
+	case Natural:
+		return "natural"
+	case Locale:
+		return "locale"
+	case Natural | Locale:
+		return "locale,natural"
+	default:
+		return "bytes"
+	}
+}
+
+func localeTag() language.Tag {
+	for _, v := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		s := os.Getenv(v)
+		if s == "" || s == "C" || s == "POSIX" {
+			continue
+		}
+		if i := strings.IndexAny(s, ".@"); i >= 0 {
+			s = s[:i]
+		}
+		if t, err := language.Parse(strings.Replace(s, "_", "-", -1)); err == nil {
+			return t
+		}
+	}
+	return language.Und
+}
+
+func naturalLess(a, b string) bool {
+	isdig := func(c byte) bool { return c >= '0' && c <= '9' }
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isdig(a[i]) || !isdig(b[j]) {
+			if a[i] != b[j] {
+				return a[i] < b[j]
+			}
+			i++
+			j++
+			continue
+		}
+		i0, j0 := i, j
+		for i < len(a) && isdig(a[i]) {
+			i++
+		}
+		for j < len(b) && isdig(b[j]) {
+			j++
+		}
+		na := strings.TrimLeft(a[i0:i], "0")
+		nb := strings.TrimLeft(b[j0:j], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		// same value: fewer leading zeros first.
+		if i-i0 != j-j0 {
+			return i-i0 < j-j0
+		}
+	}
+	return len(a)-i < len(b)-j
+}
+
+// Return true if name a sorts before b.
+func (c Collation) Less(a, b string) bool {
+	if c&Locale != 0 {
+		colmu.Lock()
+		defer colmu.Unlock()
+		col, ok := cols[c]
+		if !ok {
+			var opts []collate.Option
+			if c&Natural != 0 {
+				opts = append(opts, collate.Numeric)
+			}
+			col = collate.New(localeTag(), opts...)
+			cols[c] = col
+		}
+		if r := col.CompareString(a, b); r != 0 {
+			return r < 0
+		}
+		return a < b
+	}
+	if c&Natural != 0 {
+		return naturalLess(a, b)
+	}
+	return a < b
+}
+
+// Return true if path a sorts before b, comparing their elements
+// so that a dir sorts right before its contents.
+func (c Collation) LessPath(a, b string) bool {
+	as := Elems(a)
+	bs := Elems(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return c.Less(as[i], bs[i])
+		}
+	}
+	return len(as) < len(bs)
+}
+
+struct collated {
+	ds []Dir
+	c  Collation
+}
+
+func (x collated) Len() int           { return len(x.ds) }
+func (x collated) Less(i, j int) bool { return x.c.Less(x.ds[i]["name"], x.ds[j]["name"]) }
+func (x collated) Swap(i, j int)      { x.ds[i], x.ds[j] = x.ds[j], x.ds[i] }
+
+// Sort dir entries by name using the given collation.
+func SortDirsBy(ds []Dir, c Collation) {
+	sort.Stable(collated{ds, c})
+}
@@ -0,0 +1,96 @@
+package zx
+
+import (
+	"fmt"
+	fpath "path"
+)
+
+// Copy the file or directory at from to be at to, in the same tree.
+// If fs is a Copier it makes the copy; otherwise the caller
+// gets the data and puts it back (see CopyTo).
+func Copy(fs Fs, from, to string) error {
+	if cfs, ok := fs.(Copier); ok {
+		return <-cfs.Copy(from, to)
+	}
+	return CopyTo(fs, from, fs, to)
+}
+
+// Copy the file or directory at from in sfs to be at to in dfs,
+// by getting its data from sfs and putting it into dfs.
+// Directories are copied with all their contents.
+// The mode and user attributes are copied, but not the owner.
+// Links within directories are copied as links if dfs is a Symlinker and
+// ignored otherwise, and so are files of other types (eg. /Ctl).
+func CopyTo(sfs Fs, from string, dfs Fs, to string) error {
+	gfs, ok := sfs.(Getter)
+	if !ok {
+		return fmt.Errorf("copy %s: tree is not a getter", from)
+	}
+	pfs, ok := dfs.(Putter)
+	if !ok {
+		return fmt.Errorf("copy %s: tree is not a putter", to)
+	}
+	from, err := UseAbsPath(from)
+	if err != nil {
+		return err
+	}
+	to, err = UseAbsPath(to)
+	if err != nil {
+		return err
+	}
+	if sfs == dfs && from == to {
+		return nil
+	}
+	if to == "/" || to == "/Ctl" {
+		return fmt.Errorf("copy %s: %s", to, ErrPerm)
+	}
+	d, err := Stat(sfs, from)
+	if err != nil {
+		return err
+	}
+	if sfs == dfs && d["type"] == "d" && HasPrefix(to, from) {
+		return fmt.Errorf("copy %s: inconsistent copy", from)
+	}
+	return copyr(gfs, d, pfs, to)
+}
+
+func copyr(sfs Getter, d Dir, dfs Putter, to string) error {
+	nd := Dir{"type": d["type"], "mode": d["mode"]}
+	for k, v := range d {
+		if IsUser(k) {
+			nd[k] = v
+		}
+	}
+	switch d["type"] {
+	case "l":
+		lfs, ok := dfs.(Symlinker)
+		if !ok {
+			return nil
+		}
+		return <-lfs.Symlink(d["target"], to)
+	case "-":
+		nd["size"] = "0"
+		dc := sfs.Get(d["path"], 0, All)
+		rc := dfs.Put(to, nd, 0, dc)
+		<-rc
+		return cerror(rc)
+	case "d":
+	default:
+		return nil
+	}
+	rc := dfs.Put(to, nd, 0, nil)
+	<-rc
+	if err := cerror(rc); err != nil {
+		return err
+	}
+	ds, err := GetDir(sfs, d["path"])
+	if err != nil {
+		return err
+	}
+	for _, cd := range ds {
+		if err := copyr(sfs, cd, dfs, fpath.Join(to, cd["name"])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+/*
+	WebDAV server for zx trees.
+
+	A Handler serves a zx tree through HTTP using WebDAV (class 1 and 2),
+	so that WebDAV clients (eg. macOS Finder, Windows Explorer, cadaver,
+	or davfs2) may browse and edit clive trees.
+
+	Handlers do no authentication and are meant to be mounted
+	under an HTTP server that does, like ink (see ink.ServeDAV).
+
+	Locks are exclusive write locks on single files. They are
+	kept by the handler and are also set on the tree if it's a zx.Locker.
+	Dead properties are accepted but not kept, except for the
+	modification time, which is updated.
+*/
+package dav
+
+import (
+	"clive/dbg"
+	"clive/zx"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	fpath "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A WebDAV server for a zx tree
+struct Handler {
+	*dbg.Flag
+	sync.Mutex
+	prefix string // where in the URL space
+	fs     zx.Fs
+	locks  map[string]*davLock // by token
+}
+
+const (
+	maxBody  = 64 * 1024 // max size for xml request bodies
+	bufSz    = 32 * 1024 // size of put messages
+	defTmout = 10 * time.Minute
+	maxTmout = time.Hour
+)
+
+var errBadDst = errors.New("bad destination")
+
+// Return a handler serving fs at URLs under prefix (eg. "/dav").
+func New(prefix string, fs zx.Fs) *Handler {
+	prefix = strings.TrimSuffix(fpath.Join("/", prefix), "/")
+	return &Handler{
+		Flag:   &dbg.Flag{Tag: "dav" + prefix},
+		prefix: prefix,
+		fs:     fs,
+		locks:  map[string]*davLock{},
+	}
+}
+
+// Return the prefix for the URLs served, without a trailing "/".
+func (h *Handler) Prefix() string {
+	return h.prefix
+}
+
+func (h *Handler) String() string {
+	return h.Tag
+}
+
+// Return the tree path for the URL path up, if it's ours.
+func (h *Handler) path(up string) (string, bool) {
+	if up != h.prefix && !strings.HasPrefix(up, h.prefix+"/") {
+		return "", false
+	}
+	return fpath.Join("/", up[len(h.prefix):]), true
+}
+
+// Return the escaped URL path for the tree path p.
+func (h *Handler) href(p string, isdir bool) string {
+	up := h.prefix + p
+	if isdir && !strings.HasSuffix(up, "/") {
+		up += "/"
+	}
+	u := &url.URL{Path: up}
+	return u.EscapedPath()
+}
+
+func errStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case zx.IsNotExist(err):
+		return http.StatusNotFound
+	case zx.IsPerm(err) || strings.Contains(err.Error(), zx.ErrRO.Error()):
+		return http.StatusForbidden
+	case zx.IsLocked(err):
+		return http.StatusLocked
+	case zx.IsExists(err):
+		return http.StatusMethodNotAllowed
+	case zx.IsNotEmpty(err):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.path(r.URL.Path)
+	if !ok {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	h.Dprintf("<- %s %s\n", r.Method, p)
+	var sts int
+	var err error
+	switch r.Method {
+	case "OPTIONS":
+		sts, err = h.options(w, p)
+	case "GET", "HEAD":
+		sts, err = h.get(w, r, p)
+	case "PUT":
+		sts, err = h.put(r, p)
+	case "DELETE":
+		sts, err = h.remove(p)
+	case "MKCOL":
+		sts, err = h.mkcol(r, p)
+	case "COPY", "MOVE":
+		sts, err = h.copyMove(r, p)
+	case "PROPFIND":
+		sts, err = h.propfind(w, r, p)
+	case "PROPPATCH":
+		sts, err = h.proppatch(w, r, p)
+	case "LOCK":
+		sts, err = h.lockReq(w, r, p)
+	case "UNLOCK":
+		sts, err = h.unlockReq(r, p)
+	default:
+		sts, err = http.StatusMethodNotAllowed, fmt.Errorf("%s: not supported", r.Method)
+	}
+	if err != nil {
+		h.Dprintf("-> %s %s: %d %s\n", r.Method, p, sts, err)
+		http.Error(w, err.Error(), sts)
+		return
+	}
+	if sts != 0 {
+		h.Dprintf("-> %s %s: %d\n", r.Method, p, sts)
+		w.WriteHeader(sts)
+	}
+}
+
+func (h *Handler) options(w http.ResponseWriter, p string) (int, error) {
+	hdr := w.Header()
+	hdr.Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, "+
+		"PROPFIND, PROPPATCH, LOCK, UNLOCK")
+	hdr.Set("DAV", "1, 2")
+	hdr.Set("MS-Author-Via", "DAV")
+	return http.StatusOK, nil
+}
+
+func ctype(d zx.Dir) string {
+	if t := mime.TypeByExtension(fpath.Ext(d["name"])); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func etag(d zx.Dir) string {
+	return fmt.Sprintf(`"%s-%d"`, d["mtime"], d.Size())
+}
+
+func (h *Handler) getter(p string) (zx.Getter, error) {
+	gfs, ok := h.fs.(zx.Getter)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", p, zx.ErrBug)
+	}
+	return gfs, nil
+}
+
+func (h *Handler) putter(p string) (zx.Putter, error) {
+	pfs, ok := h.fs.(zx.Putter)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", p, zx.ErrRO)
+	}
+	return pfs, nil
+}
+
+func (h *Handler) remover(p string) (zx.Remover, error) {
+	rfs, ok := h.fs.(zx.Remover)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", p, zx.ErrRO)
+	}
+	return rfs, nil
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	d, err := zx.Stat(h.fs, p)
+	if err != nil {
+		return errStatus(err), err
+	}
+	gfs, err := h.getter(p)
+	if err != nil {
+		return http.StatusMethodNotAllowed, err
+	}
+	if d["type"] == "d" {
+		return h.list(w, r, gfs, p)
+	}
+	hdr := w.Header()
+	hdr.Set("Content-Type", ctype(d))
+	hdr.Set("Content-Length", d["size"])
+	hdr.Set("Last-Modified", d.Time("mtime").UTC().Format(http.TimeFormat))
+	hdr.Set("ETag", etag(d))
+	if r.Method == "HEAD" {
+		return http.StatusOK, nil
+	}
+	gc := gfs.Get(p, 0, zx.All)
+	for b := range gc {
+		if _, err := w.Write(b); err != nil {
+			close(gc, err)
+			break
+		}
+	}
+	if err := cerror(gc); err != nil {
+		// too late to report it
+		h.Dprintf("get %s: %s\n", p, err)
+	}
+	return 0, nil
+}
+
+// List the directory at p as a web page.
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, gfs zx.Getter, p string) (int, error) {
+	ds, err := zx.GetDir(gfs, p)
+	if err != nil {
+		return errStatus(err), err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return http.StatusOK, nil
+	}
+	fmt.Fprintf(w, "<html><head><title>%s</title></head><body><ul>\n", html.EscapeString(p))
+	for _, d := range ds {
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a>\n",
+			h.href(d["path"], d["type"] == "d"), html.EscapeString(d["name"]))
+	}
+	fmt.Fprintf(w, "</ul></body></html>\n")
+	return 0, nil
+}
+
+func (h *Handler) put(r *http.Request, p string) (int, error) {
+	pfs, err := h.putter(p)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	d := zx.Dir{"type": "-", "size": "0"}
+	sts := http.StatusNoContent
+	od, err := zx.Stat(h.fs, p)
+	switch {
+	case err == nil && od["type"] == "d":
+		return http.StatusMethodNotAllowed, fmt.Errorf("%s: %s", p, zx.ErrIsDir)
+	case err != nil && !zx.IsNotExist(err):
+		return errStatus(err), err
+	case err != nil:
+		if _, err := zx.Stat(h.fs, fpath.Dir(p)); err != nil {
+			return http.StatusConflict, err
+		}
+		d["mode"] = "0644"
+		sts = http.StatusCreated
+	}
+	dc := make(chan []byte)
+	rc := pfs.Put(p, d, 0, dc)
+	var rerr error
+	for rerr == nil {
+		b := make([]byte, bufSz)
+		var n int
+		n, rerr = r.Body.Read(b)
+		if n > 0 {
+			if ok := dc <- b[:n]; !ok {
+				break
+			}
+		}
+	}
+	if rerr == io.EOF {
+		rerr = nil
+	}
+	close(dc, rerr)
+	<-rc
+	if err := cerror(rc); err != nil {
+		return errStatus(err), err
+	}
+	return sts, nil
+}
+
+func (h *Handler) remove(p string) (int, error) {
+	rfs, err := h.remover(p)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	if err := <-rfs.RemoveAll(p); err != nil {
+		return errStatus(err), err
+	}
+	h.dropLocks(p)
+	return http.StatusNoContent, nil
+}
+
+func (h *Handler) mkcol(r *http.Request, p string) (int, error) {
+	if r.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType, errors.New("mkcol with body")
+	}
+	pfs, err := h.putter(p)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	if _, err := zx.Stat(h.fs, p); err == nil {
+		return http.StatusMethodNotAllowed, fmt.Errorf("%s: %s", p, zx.ErrExists)
+	}
+	if _, err := zx.Stat(h.fs, fpath.Dir(p)); err != nil {
+		return http.StatusConflict, err
+	}
+	rc := pfs.Put(p, zx.Dir{"type": "d", "mode": "0755"}, 0, nil)
+	<-rc
+	if err := cerror(rc); err != nil {
+		return errStatus(err), err
+	}
+	return http.StatusCreated, nil
+}
+
+// Return the tree path for the destination of a copy or move.
+func (h *Handler) dest(r *http.Request) (string, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return "", errBadDst
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", errBadDst
+	}
+	p, ok := h.path(u.Path)
+	if !ok {
+		return "", errBadDst
+	}
+	return p, nil
+}
+
+func (h *Handler) copyMove(r *http.Request, p string) (int, error) {
+	to, err := h.dest(r)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	if to == p {
+		return http.StatusForbidden, fmt.Errorf("%s: same source and destination", p)
+	}
+	if _, err := zx.Stat(h.fs, p); err != nil {
+		return errStatus(err), err
+	}
+	if _, err := zx.Stat(h.fs, fpath.Dir(to)); err != nil {
+		return http.StatusConflict, err
+	}
+	sts := http.StatusCreated
+	if _, err := zx.Stat(h.fs, to); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, fmt.Errorf("%s: %s", to, zx.ErrExists)
+		}
+		if rsts, err := h.remove(to); err != nil {
+			return rsts, err
+		}
+		sts = http.StatusNoContent
+	}
+	if r.Method == "COPY" {
+		err = zx.Copy(h.fs, p, to)
+	} else {
+		err = h.move(p, to)
+	}
+	if err != nil {
+		return errStatus(err), err
+	}
+	return sts, nil
+}
+
+func (h *Handler) move(from, to string) error {
+	if mfs, ok := h.fs.(zx.Mover); ok {
+		if err := <-mfs.Move(from, to); err != nil {
+			return err
+		}
+		h.dropLocks(from)
+		return nil
+	}
+	if err := zx.Copy(h.fs, from, to); err != nil {
+		return err
+	}
+	_, err := h.remove(from)
+	return err
+}
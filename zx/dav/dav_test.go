@@ -0,0 +1,161 @@
+package dav
+
+import (
+	"clive/zx"
+	"clive/zx/fstest"
+	"clive/zx/zux"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const tdir = "/tmp/davtest"
+
+// Issue a request and return its status and body.
+func req(t *testing.T, meth, u, body string, hdr ...string) (int, http.Header, string) {
+	r, err := http.NewRequest(meth, u, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s %s: %s", meth, u, err)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		r.Header.Set(hdr[i], hdr[i+1])
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%s %s: %s", meth, u, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %s", meth, u, err)
+	}
+	t.Logf("%s %s: %d\n", meth, u, resp.StatusCode)
+	return resp.StatusCode, resp.Header, string(b)
+}
+
+func TestDav(t *testing.T) {
+	fstest.MkTree(t, tdir)
+	defer os.RemoveAll(tdir)
+	fs, err := zux.NewZX(tdir)
+	if err != nil {
+		t.Fatalf("lfs: %s", err)
+	}
+	h := New("/dav/", fs)
+	h.Debug = testing.Verbose()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	u := srv.URL + "/dav"
+
+	sts, hdr, _ := req(t, "OPTIONS", u+"/", "")
+	if sts != 200 || hdr.Get("DAV") != "1, 2" {
+		t.Fatalf("bad options")
+	}
+	sts, _, body := req(t, "PROPFIND", u+"/a", "", "Depth", "1")
+	if sts != 207 {
+		t.Fatalf("propfind: %d", sts)
+	}
+	for _, s := range []string{"<D:href>/dav/a/</D:href>", "<D:href>/dav/a/a1</D:href>",
+		"<D:href>/dav/a/b/</D:href>", "<D:collection/>"} {
+		if !strings.Contains(body, s) {
+			t.Fatalf("propfind: no %s in\n%s", s, body)
+		}
+	}
+	pf := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:X="urn:x">` +
+		`<D:prop><D:getcontentlength/><X:color/></D:prop></D:propfind>`
+	_, _, body = req(t, "PROPFIND", u+"/a/a1", pf, "Depth", "0")
+	if !strings.Contains(body, "<D:getcontentlength>") ||
+		!strings.Contains(body, `<x:color xmlns:x="urn:x"/>`) ||
+		!strings.Contains(body, "404 Not Found") {
+		t.Fatalf("bad propfind prop:\n%s", body)
+	}
+
+	sts, _, body = req(t, "GET", u+"/a/a1", "")
+	if sts != 200 || body != string(fstest.FileData["/a/a1"]) {
+		t.Fatalf("get: %d", sts)
+	}
+	if sts, _, _ := req(t, "GET", u+"/nothere", ""); sts != 404 {
+		t.Fatalf("get: %d", sts)
+	}
+
+	if sts, _, _ := req(t, "PUT", u+"/n1", "hello there"); sts != 201 {
+		t.Fatalf("put: %d", sts)
+	}
+	if sts, _, _ := req(t, "PUT", u+"/n1", "hi"); sts != 204 {
+		t.Fatalf("put: %d", sts)
+	}
+	if dat, err := zx.GetAll(fs, "/n1"); err != nil || string(dat) != "hi" {
+		t.Fatalf("bad put data %q %v", dat, err)
+	}
+	if sts, _, _ := req(t, "PUT", u+"/x/n1", "hi"); sts != 409 {
+		t.Fatalf("put: %d", sts)
+	}
+
+	if sts, _, _ := req(t, "MKCOL", u+"/nd", ""); sts != 201 {
+		t.Fatalf("mkcol: %d", sts)
+	}
+	if sts, _, _ := req(t, "MKCOL", u+"/nd", ""); sts != 405 {
+		t.Fatalf("mkcol: %d", sts)
+	}
+	if sts, _, _ := req(t, "MOVE", u+"/n1", "", "Destination", u+"/nd/n1"); sts != 201 {
+		t.Fatalf("move: %d", sts)
+	}
+	if sts, _, _ := req(t, "COPY", u+"/nd/n1", "", "Destination", u+"/nd/n2"); sts != 201 {
+		t.Fatalf("copy: %d", sts)
+	}
+	if sts, _, _ := req(t, "COPY", u+"/1", "", "Destination", u+"/nd/n2",
+		"Overwrite", "F"); sts != 412 {
+		t.Fatalf("copy: %d", sts)
+	}
+	if sts, _, _ := req(t, "COPY", u+"/1", "", "Destination", u+"/nd/n2"); sts != 204 {
+		t.Fatalf("copy: %d", sts)
+	}
+	if dat, err := zx.GetAll(fs, "/nd/n2"); err != nil || string(dat) != string(fstest.FileData["/1"]) {
+		t.Fatalf("bad copy data %v", err)
+	}
+
+	li := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype><D:owner>nemo</D:owner></D:lockinfo>`
+	sts, hdr, body = req(t, "LOCK", u+"/nd/n3", li, "Timeout", "Second-60")
+	tok := hdr.Get("Lock-Token")
+	if sts != 201 || !strings.HasPrefix(tok, "<opaquelocktoken:") ||
+		!strings.Contains(body, "<D:owner>nemo</D:owner>") {
+		t.Fatalf("lock: %d %s\n%s", sts, tok, body)
+	}
+	if sts, _, _ := req(t, "LOCK", u+"/nd/n3", li); sts != 423 {
+		t.Fatalf("lock: %d", sts)
+	}
+	if sts, _, _ := req(t, "LOCK", u+"/nd/n3", "", "If", "("+tok+")"); sts != 200 {
+		t.Fatalf("lock refresh: %d", sts)
+	}
+	if sts, _, _ := req(t, "UNLOCK", u+"/nd/n3", "", "Lock-Token", tok); sts != 204 {
+		t.Fatalf("unlock: %d", sts)
+	}
+	if sts, _, _ := req(t, "UNLOCK", u+"/nd/n3", "", "Lock-Token", tok); sts != 409 {
+		t.Fatalf("unlock: %d", sts)
+	}
+
+	pp := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:">` +
+		`<D:set><D:prop><Z:Win32LastModifiedTime>Wed, 15 Nov 1995 06:25:24 GMT` +
+		`</Z:Win32LastModifiedTime></D:prop></D:set></D:propertyupdate>`
+	if sts, _, body := req(t, "PROPPATCH", u+"/nd/n3", pp); sts != 207 || !strings.Contains(body, "200 OK") {
+		t.Fatalf("proppatch: %d\n%s", sts, body)
+	}
+	if d, err := zx.Stat(fs, "/nd/n3"); err != nil || d.Time("mtime").Year() != 1995 {
+		t.Fatalf("proppatch: mtime not set")
+	}
+
+	if sts, _, _ := req(t, "DELETE", u+"/nd", ""); sts != 204 {
+		t.Fatalf("delete: %d", sts)
+	}
+	if _, err := zx.Stat(fs, "/nd"); !zx.IsNotExist(err) {
+		t.Fatalf("delete: still there")
+	}
+	ro := httptest.NewServer(New("/dav", zx.MakeRO(fs)))
+	defer ro.Close()
+	if sts, _, _ := req(t, "PUT", ro.URL+"/dav/1", "hi"); sts != 403 {
+		t.Fatalf("ro put: %d", sts)
+	}
+}
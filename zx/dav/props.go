@@ -0,0 +1,406 @@
+package dav
+
+import (
+	"bytes"
+	"clive/zx"
+	crand "crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const xmlHdr = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+
+// A property named in a request
+struct xprop {
+	XMLName xml.Name
+	Val     string `xml:",chardata"`
+}
+
+struct xprops {
+	Props []xprop `xml:",any"`
+}
+
+struct xempty {}
+
+struct propfind {
+	Allprop  *xempty `xml:"allprop"`
+	Propname *xempty `xml:"propname"`
+	Prop     *xprops `xml:"prop"`
+}
+
+struct propupdate {
+	Set    []xprops `xml:"set>prop"`
+	Remove []xprops `xml:"remove>prop"`
+}
+
+struct xowner {
+	Inner string `xml:",innerxml"`
+}
+
+struct lockinfo {
+	Owner xowner `xml:"owner"`
+}
+
+// A live property and its value, as xml
+struct prop {
+	name, val string
+}
+
+// A lock held on a file
+struct davLock {
+	path, token string
+	owner       string // as given by the client, in xml
+	tmout       time.Duration
+	exp         time.Time
+}
+
+func xmlEsc(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Read and parse the xml body of r into v.
+// It returns false if there's no body.
+func readBody(r *http.Request, v face{}) (bool, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		return false, err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return false, nil
+	}
+	return true, xml.Unmarshal(b, v)
+}
+
+// Return the live properties for d.
+func (h *Handler) props(d zx.Dir) []prop {
+	mt := d.Time("mtime").UTC()
+	ps := []prop{
+		{"displayname", xmlEsc(d["name"])},
+		{"resourcetype", ""},
+		{"getlastmodified", mt.Format(http.TimeFormat)},
+		{"creationdate", mt.Format(time.RFC3339)},
+	}
+	if d["type"] == "d" {
+		ps[1].val = "<D:collection/>"
+	} else {
+		ps = append(ps,
+			prop{"getcontentlength", d["size"]},
+			prop{"getcontenttype", xmlEsc(ctype(d))},
+			prop{"getetag", xmlEsc(etag(d))},
+		)
+	}
+	ps = append(ps,
+		prop{"supportedlock", "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope>" +
+			"<D:locktype><D:write/></D:locktype></D:lockentry>"},
+		prop{"lockdiscovery", ""},
+	)
+	if l := h.lockFor(d["path"]); l != nil {
+		ps[len(ps)-1].val = h.activeLock(l)
+	}
+	return ps
+}
+
+func propstat(b *bytes.Buffer, ps []prop, sts int, names bool) {
+	if len(ps) == 0 {
+		return
+	}
+	b.WriteString("<D:propstat><D:prop>")
+	for _, p := range ps {
+		switch {
+		case strings.HasPrefix(p.name, "{"):
+			// not in the DAV: namespace
+			toks := strings.SplitN(p.name[1:], "}", 2)
+			fmt.Fprintf(b, `<x:%s xmlns:x="%s"/>`, toks[1], xmlEsc(toks[0]))
+		case names || p.val == "":
+			fmt.Fprintf(b, "<D:%s/>", p.name)
+		default:
+			fmt.Fprintf(b, "<D:%s>%s</D:%s>", p.name, p.val, p.name)
+		}
+	}
+	fmt.Fprintf(b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>",
+		sts, http.StatusText(sts))
+}
+
+func writeMulti(w http.ResponseWriter, b *bytes.Buffer) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xmlHdr+`<D:multistatus xmlns:D="DAV:">`)
+	w.Write(b.Bytes())
+	io.WriteString(w, "</D:multistatus>\n")
+}
+
+func propName(n xml.Name) string {
+	if n.Space == "DAV:" || n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
+
+func (h *Handler) propfind(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	d, err := zx.Stat(h.fs, p)
+	if err != nil {
+		return errStatus(err), err
+	}
+	var pf propfind
+	if _, err := readBody(r, &pf); err != nil {
+		return http.StatusBadRequest, err
+	}
+	ds := []zx.Dir{d}
+	depth := r.Header.Get("Depth")
+	if depth == "infinity" {
+		return http.StatusForbidden, errors.New("infinite depth not supported")
+	}
+	if d["type"] == "d" && depth != "0" {
+		gfs, err := h.getter(p)
+		if err != nil {
+			return http.StatusForbidden, err
+		}
+		cds, err := zx.GetDir(gfs, p)
+		if err != nil {
+			return errStatus(err), err
+		}
+		ds = append(ds, cds...)
+	}
+	var b bytes.Buffer
+	for _, d := range ds {
+		fmt.Fprintf(&b, "<D:response><D:href>%s</D:href>", h.href(d["path"], d["type"] == "d"))
+		ps := h.props(d)
+		switch {
+		case pf.Propname != nil:
+			propstat(&b, ps, http.StatusOK, true)
+		case pf.Prop == nil:
+			propstat(&b, ps, http.StatusOK, false)
+		default:
+			var found, missing []prop
+		Loop:
+			for _, xp := range pf.Prop.Props {
+				n := propName(xp.XMLName)
+				for _, p := range ps {
+					if p.name == n {
+						found = append(found, p)
+						continue Loop
+					}
+				}
+				missing = append(missing, prop{name: n})
+			}
+			propstat(&b, found, http.StatusOK, false)
+			propstat(&b, missing, http.StatusNotFound, true)
+		}
+		b.WriteString("</D:response>\n")
+	}
+	writeMulti(w, &b)
+	return 0, nil
+}
+
+// Dead properties are accepted but not kept.
+// The modification time is updated if the tree is a zx.Wstater.
+func (h *Handler) proppatch(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	if _, err := zx.Stat(h.fs, p); err != nil {
+		return errStatus(err), err
+	}
+	var pu propupdate
+	if _, err := readBody(r, &pu); err != nil {
+		return http.StatusBadRequest, err
+	}
+	var ps []prop
+	var mt time.Time
+	for _, xps := range append(pu.Set, pu.Remove...) {
+		for _, xp := range xps.Props {
+			ps = append(ps, prop{name: propName(xp.XMLName)})
+			switch xp.XMLName.Local {
+			case "getlastmodified", "Win32LastModifiedTime":
+				if t, err := http.ParseTime(xp.Val); err == nil {
+					mt = t
+				}
+			}
+		}
+	}
+	sts := http.StatusOK
+	if !mt.IsZero() {
+		if wfs, ok := h.fs.(zx.Wstater); ok {
+			d := zx.Dir{}
+			d.SetTime("mtime", mt)
+			rc := wfs.Wstat(p, d)
+			<-rc
+			if err := cerror(rc); err != nil {
+				h.Dprintf("proppatch %s: %s\n", p, err)
+				sts = errStatus(err)
+			}
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<D:response><D:href>%s</D:href>", h.href(p, false))
+	propstat(&b, ps, sts, true)
+	b.WriteString("</D:response>\n")
+	writeMulti(w, &b)
+	return 0, nil
+}
+
+func newToken() string {
+	var b [16]byte
+	crand.Read(b[:])
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Parse a Timeout header.
+func timeout(s string) time.Duration {
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "Infinite" {
+			return maxTmout
+		}
+		if strings.HasPrefix(t, "Second-") {
+			n, err := strconv.Atoi(t[7:])
+			if err != nil || n <= 0 {
+				continue
+			}
+			if tm := time.Duration(n) * time.Second; tm < maxTmout {
+				return tm
+			}
+			return maxTmout
+		}
+	}
+	return defTmout
+}
+
+// Return the lock tokens in an If header.
+func ifTokens(s string) []string {
+	var toks []string
+	for {
+		i := strings.Index(s, "<opaquelocktoken:")
+		if i < 0 {
+			return toks
+		}
+		s = s[i+1:]
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			return toks
+		}
+		toks = append(toks, s[:j])
+		s = s[j:]
+	}
+}
+
+// Return the lock for the file at path, if any.
+func (h *Handler) lockFor(path string) *davLock {
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	for tok, l := range h.locks {
+		if now.After(l.exp) {
+			delete(h.locks, tok)
+			continue
+		}
+		if l.path == path {
+			return l
+		}
+	}
+	return nil
+}
+
+// Forget the locks for files at or under path.
+func (h *Handler) dropLocks(path string) {
+	h.Lock()
+	defer h.Unlock()
+	for tok, l := range h.locks {
+		if zx.HasPrefix(l.path, path) {
+			delete(h.locks, tok)
+		}
+	}
+}
+
+func (h *Handler) activeLock(l *davLock) string {
+	return fmt.Sprintf("<D:activelock><D:locktype><D:write/></D:locktype>"+
+		"<D:lockscope><D:exclusive/></D:lockscope><D:depth>0</D:depth>"+
+		"<D:owner>%s</D:owner><D:timeout>Second-%d</D:timeout>"+
+		"<D:locktoken><D:href>%s</D:href></D:locktoken>"+
+		"<D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock>",
+		l.owner, int(l.tmout/time.Second), l.token, h.href(l.path, false))
+}
+
+func (h *Handler) lockReq(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	var li lockinfo
+	some, err := readBody(r, &li)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	sts := http.StatusOK
+	var l *davLock
+	if !some {
+		// refresh
+		for _, tok := range ifTokens(r.Header.Get("If")) {
+			if ol := h.lockFor(p); ol != nil && ol.token == tok {
+				l = ol
+			}
+		}
+		if l == nil {
+			return http.StatusPreconditionFailed, fmt.Errorf("%s: no lock to refresh", p)
+		}
+	} else {
+		_, err := zx.Stat(h.fs, p)
+		if err != nil && !zx.IsNotExist(err) {
+			return errStatus(err), err
+		}
+		if err != nil {
+			pfs, err := h.putter(p)
+			if err != nil {
+				return http.StatusForbidden, err
+			}
+			rc := pfs.Put(p, zx.Dir{"type": "-", "mode": "0644"}, 0, nil)
+			<-rc
+			if err := cerror(rc); err != nil {
+				return http.StatusConflict, err
+			}
+			sts = http.StatusCreated
+		}
+		if h.lockFor(p) != nil {
+			return http.StatusLocked, fmt.Errorf("%s: %s", p, zx.ErrLocked)
+		}
+		l = &davLock{path: p, token: newToken(), owner: li.Owner.Inner}
+	}
+	tmout := timeout(r.Header.Get("Timeout"))
+	if lfs, ok := h.fs.(zx.Locker); ok {
+		dc := lfs.Lock(p, l.token, tmout)
+		<-dc
+		if err := cerror(dc); err != nil {
+			return errStatus(err), err
+		}
+	}
+	h.Lock()
+	l.tmout = tmout
+	l.exp = time.Now().Add(tmout)
+	h.locks[l.token] = l
+	h.Unlock()
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Lock-Token", "<"+l.token+">")
+	w.WriteHeader(sts)
+	fmt.Fprintf(w, xmlHdr+`<D:prop xmlns:D="DAV:"><D:lockdiscovery>%s</D:lockdiscovery></D:prop>`+"\n",
+		h.activeLock(l))
+	return 0, nil
+}
+
+func (h *Handler) unlockReq(r *http.Request, p string) (int, error) {
+	tok := strings.Trim(r.Header.Get("Lock-Token"), "<>")
+	l := h.lockFor(p)
+	if l == nil || l.token != tok {
+		return http.StatusConflict, fmt.Errorf("%s: not locked with %s", p, tok)
+	}
+	if lfs, ok := h.fs.(zx.Locker); ok {
+		if err := <-lfs.Unlock(p, tok); err != nil {
+			return errStatus(err), err
+		}
+	}
+	h.Lock()
+	delete(h.locks, tok)
+	h.Unlock()
+	return http.StatusNoContent, nil
+}
@@ -105,11 +105,11 @@ func IsTemp(name string) bool {
 	return unicode.IsUpper(r)
 }
 
-// Make a dup of the dir entry w/o temporary attributes
+// Make a dup of the dir entry w/o temporary and system attributes
 func (d Dir) SysDup() Dir {
 	nd := Dir{}
 	for k, v := range d {
-		if !IsTemp(k) {
+		if !IsTemp(k) && !IsSys(k) {
 			nd[k] = v
 		}
 	}
@@ -684,7 +684,7 @@ func (d Dir) Proto() string {
 func (d Dir) IsFinder() bool {
 	p := d.Proto()
 	switch p {
-	case "lfs", "zxc", "zx", "finder":
+	case "lfs", "zxc", "zx", "9p", "s3", "finder":
 		return true
 	default:
 		return false
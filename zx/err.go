@@ -19,6 +19,9 @@ var (
 	ErrBadCtl    = errors.New("bad ctl request")
 	ErrNotSuffix = errors.New("not an inner path")
 	ErrBadType   = errors.New("bad file type")
+	ErrLocked    = errors.New("file is locked")
+	ErrBadAttr   = errors.New("bad attribute")
+	ErrCorrupt   = errors.New("data corrupted")
 	ErrIO        = ch.ErrIO
 )
 
@@ -78,3 +81,23 @@ func IsPerm(e error) bool {
 	s := e.Error()
 	return strings.Contains(s, "permission denied")
 }
+
+func IsLocked(e error) bool {
+	if e == nil {
+		return false
+	}
+	if e == ErrLocked {
+		return true
+	}
+	return strings.Contains(e.Error(), "is locked")
+}
+
+func IsBadAttr(e error) bool {
+	if e == nil {
+		return false
+	}
+	if e == ErrBadAttr {
+		return true
+	}
+	return strings.Contains(e.Error(), "bad attribute")
+}
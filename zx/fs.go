@@ -3,6 +3,7 @@ package zx
 import (
 	"bytes"
 	"clive/net/auth"
+	"time"
 )
 
 // A zx file system.
@@ -37,7 +38,8 @@ interface Getter {
 	Fs
 	// Retrieve the contents of the file at path.
 	// For directories, off and count refer to the number of
-	// directory entries, counting from 0.
+	// directory entries, counting from 0, so that large directories
+	// may be listed a few entries at a time (see DirEntries).
 	// A count of -1 means "everything".
 	// Each directory entry is returned as a []byte with the format
 	// produced by Dir.Bytes(),
@@ -65,6 +67,12 @@ interface Putter {
 	// If extra attributes are included in d, they are also updated.
 	// If off is < 0 then the new data is appended to the file.
 	// Note off<0 makes sense even if d["mode"] is defined.
+	// When appending, the file is truncated only if d["size"] is defined,
+	// and d["type"] "-" creates the file if it does not exist but does not
+	// truncate it. Each message sent through dc is written as a whole
+	// at the end of the file as it is at that time, so that concurrent
+	// appends do not overwrite each other, although their messages may
+	// interleave.
 	// The file mtime and size after the put, or the error is reported
 	// through the returned channel.
 	// If d["type"] is "d", then dc is ignored and a directory is created
@@ -100,12 +108,98 @@ interface Mover {
 	Move(from, to string) <-chan error
 }
 
+// File systems able to copy files by themselves, without sending
+// their data to the caller.
+// See also the Copy and CopyTo functions.
+interface Copier {
+	// Copy the file or directory at from to be at to, which is
+	// created or replaced.
+	// Directories are copied with all their contents.
+	Copy(from, to string) <-chan error
+}
+
+// File systems able to search file contents by themselves,
+// without sending the files to the caller.
+// See also the Grep and GrepFiles functions.
+interface Grepper {
+	// Search the files at or under path matching pred (as in Find)
+	// for lines matching the sre regexp rexp.
+	// Each match is sent as an Addr with the name of the file and
+	// the range for the matching lines, including nctx lines before
+	// and after them, followed by a string with those lines.
+	// Ranges that overlap are merged.
+	Grep(path, pred, rexp string, nctx int) <-chan face{}
+}
+
+// File systems able to checksum file contents by themselves,
+// without sending the files to the caller, so that transfers
+// may be resumed or avoided (see Sums and Resume).
+interface Summer {
+	// Report the SHA1 for each consecutive block of blksz bytes
+	// in the file at path, as a hex string.
+	// The last block may be shorter, and an empty file has no blocks.
+	Sums(path string, blksz int64) <-chan string
+}
+
+// File systems able to stat several files in a single request,
+// eg. to avoid a round trip per file to remote trees.
+interface MultiStater {
+	// Send the entries for the given paths, in the same order.
+	// The entry for a path that can't be stated has just the "path"
+	// and "err" attributes.
+	Stats(paths ...string) <-chan Dir
+}
+
 // File systems able to link files
 interface Linker {
 	// Link new to refer to old
 	Link(oldp, newp string) <-chan error
 }
 
+// File systems with symbolic links.
+// The dir entry for a link has type "l" and a "target" attribute
+// with the path it refers to, relative to the directory holding the link
+// or, if it's absolute, to the root of the tree.
+// Other requests follow links, but directory entries retrieved with Get
+// and Find describe the links and not the files they refer to.
+interface Symlinker {
+	// Create a symbolic link at newp referring to target.
+	Symlink(target, newp string) <-chan error
+	// Return the directory entry for the file at path, which is that
+	// of the link and not the file it refers to if it's a link.
+	Lstat(path string) <-chan Dir
+}
+
+// File systems able to notify changes in files
+interface Watcher {
+	// Send the dir entries for files at or under path matching pred
+	// (evaluated as in Find, with depth relative to path) as they
+	// are created, written, have their attributes changed, or are removed.
+	// The "chg" attribute in each entry tells which one (the String
+	// for Add, Data, Meta, or Del).
+	// Only changes made through the file system are reported, and
+	// removing a directory reports just the directory.
+	// The caller closes the chan to stop watching.
+	Watch(path, pred string) <-chan Dir
+}
+
+// File systems with advisory locks for files.
+// Locks are leases: they expire after the given timeout unless
+// renewed by locking again.
+// They do not prevent other operations; they are for cooperating
+// clients to serialize their writes.
+// While locked, the dir entry for the file has the attributes
+// "lockuid" (the user holding the lock), "lockowner" (the owner given by
+// the client), and "lockexp" (when it expires, in the format of mtime).
+interface Locker {
+	// Lock the file at path for owner during tmout (or DefLease if
+	// it's not positive) and return its dir entry.
+	// It fails with ErrLocked if it's locked by another owner.
+	Lock(path, owner string, tmout time.Duration) <-chan Dir
+	// Release the lock held by owner for the file at path.
+	Unlock(path, owner string) <-chan error
+}
+
 // File systems that can authenticate a user
 interface Auther {
 	// returns a new view of the Fs authenticated for ai
@@ -144,6 +238,18 @@ func Stat(fs Fs, p string) (Dir, error) {
 	return d, cerror(dc)
 }
 
+// Do a Lstat on fs and return the reply now.
+// If fs is not a Symlinker, it has no links and a Stat is used instead.
+func Lstat(fs Fs, p string) (Dir, error) {
+	xfs, ok := fs.(Symlinker)
+	if !ok {
+		return Stat(fs, p)
+	}
+	dc := xfs.Lstat(p)
+	d := <-dc
+	return d, cerror(dc)
+}
+
 // Get all contents for a file
 func GetAll(fs Getter, p string) ([]byte, error) {
 	var buf bytes.Buffer
@@ -171,9 +277,15 @@ func PutAll(fs Putter, path string, data []byte, mode ...string) error {
 
 // Get all dir entries
 func GetDir(fs Getter, p string) ([]Dir, error) {
+	return GetDirPage(fs, p, 0, All)
+}
+
+// Get at most count dir entries (all if count is All) starting
+// at the entry number off.
+func GetDirPage(fs Getter, p string, off, count int64) ([]Dir, error) {
 	ds := make([]Dir, 0, 16)
 	var c <-chan []byte
-	c = fs.Get(p, 0, All)
+	c = fs.Get(p, off, count)
 	for b := range c {
 		_, d, err := UnpackDir(b)
 		if err != nil {
@@ -186,3 +298,36 @@ func GetDir(fs Getter, p string) ([]Dir, error) {
 	}
 	return ds, nil
 }
+
+// Send the dir entries for p through the returned chan, getting them
+// n at a time, so that the listing for a large directory is never kept
+// in memory as a whole.
+// If the directory changes while it's listed, entries may be missed
+// or sent twice.
+// The caller may close the chan to stop.
+func DirEntries(fs Getter, p string, n int64) <-chan Dir {
+	rc := make(chan Dir)
+	if n <= 0 {
+		n = 128
+	}
+	go func() {
+		for off := int64(0); ; {
+			ds, err := GetDirPage(fs, p, off, n)
+			if err != nil {
+				close(rc, err)
+				return
+			}
+			for _, d := range ds {
+				if ok := rc <- d; !ok {
+					return
+				}
+			}
+			if int64(len(ds)) < n {
+				break
+			}
+			off += int64(len(ds))
+		}
+		close(rc)
+	}()
+	return rc
+}
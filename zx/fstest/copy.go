@@ -0,0 +1,49 @@
+package fstest
+
+import (
+	"bytes"
+	"clive/zx"
+)
+
+// Check that files and directories are copied.
+func Copies(t Fataler, xfs zx.Fs) {
+	gfs, ok := xfs.(zx.Getter)
+	if !ok {
+		t.Fatalf("not a Getter")
+	}
+	if _, ok := xfs.(zx.Copier); !ok {
+		Printf("not a copier; copying here\n")
+	}
+	copies := [][2]string{
+		{"/a/a1", "/a/c1"},
+		{"/1", "/a/a2"},
+		{"/a", "/e/f/a"},
+	}
+	for _, c := range copies {
+		if err := zx.Copy(xfs, c[0], c[1]); err != nil {
+			t.Fatalf("copy %s: %s", c[0], err)
+		}
+	}
+	files := map[string]string{
+		"/a/c1":         "/a/a1",
+		"/a/a2":         "/1",
+		"/e/f/a/a1":     "/a/a1",
+		"/e/f/a/a2":     "/1",
+		"/e/f/a/b/c/c3": "/a/b/c/c3",
+	}
+	for p, op := range files {
+		dat, err := zx.GetAll(gfs, p)
+		if err != nil {
+			t.Fatalf("get %s: %s", p, err)
+		}
+		if !bytes.Equal(dat, FileData[op]) {
+			t.Fatalf("%s: bad data", p)
+		}
+	}
+	if err := zx.Copy(xfs, "/a", "/a/b/a"); err == nil {
+		t.Fatalf("could copy a dir into itself")
+	}
+	if err := zx.Copy(xfs, "/n", "/n2"); err == nil {
+		t.Fatalf("could copy a missing file")
+	}
+}
@@ -150,3 +150,41 @@ func Gets(t Fataler, xfs zx.Fs) {
 		}
 	}
 }
+
+// Check that directories can be listed a few entries at a time.
+func DirPages(t Fataler, xfs zx.Fs) {
+	fs, ok := xfs.(zx.Getter)
+	if !ok {
+		t.Fatalf("not a Getter")
+	}
+	for _, p := range []string{"/", "/a", "/d"} {
+		all, err := zx.GetDir(fs, p)
+		if err != nil {
+			t.Fatalf("getdir %s: %s", p, err)
+		}
+		for n := int64(1); n <= 3; n++ {
+			i := 0
+			for d := range zx.DirEntries(fs, p, n) {
+				if i >= len(all) || d["path"] != all[i]["path"] {
+					t.Fatalf("%s: page %d: bad entry %s", p, n, d["path"])
+				}
+				i++
+			}
+			if i != len(all) {
+				t.Fatalf("%s: page %d: %d entries", p, n, i)
+			}
+		}
+		if len(all) < 3 {
+			continue
+		}
+		ds, err := zx.GetDirPage(fs, p, 1, 2)
+		if err != nil {
+			t.Fatalf("getdirpage %s: %s", p, err)
+		}
+		if len(ds) != 2 || ds[0]["path"] != all[1]["path"] ||
+			ds[1]["path"] != all[2]["path"] {
+			t.Fatalf("%s: bad page", p)
+		}
+		Printf("%s: %d entries\n", p, len(all))
+	}
+}
@@ -0,0 +1,47 @@
+package fstest
+
+import (
+	"clive/zx"
+	"fmt"
+)
+
+// Check that file contents are searched, with context lines.
+func Greps(t Fataler, xfs zx.Fs) {
+	rc := zx.Grep(xfs, "/", "", "a1 777|a1 778", 1)
+	var msgs []face{}
+	for m := range rc {
+		Printf("grep %v\n", m)
+		msgs = append(msgs, m)
+	}
+	if err := cerror(rc); err != nil {
+		t.Fatalf("grep: %s", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("grep: got %d msgs", len(msgs))
+	}
+	a, ok := msgs[0].(zx.Addr)
+	if !ok || a.Name != "/a/a1" || a.Ln0 != 777 || a.Ln1 != 780 {
+		t.Fatalf("grep: bad addr %v", msgs[0])
+	}
+	txt := ""
+	for k := 776; k < 780; k++ {
+		txt += fmt.Sprintf("/a/a1 %d\n", k)
+	}
+	if s, ok := msgs[1].(string); !ok || s != txt {
+		t.Fatalf("grep: bad text %v", msgs[1])
+	}
+
+	rc = zx.Grep(xfs, "/a", "name!=a1", "a1 777", 0)
+	for m := range rc {
+		t.Fatalf("grep: unexpected match %v", m)
+	}
+	if err := cerror(rc); err != nil {
+		t.Fatalf("grep: %s", err)
+	}
+	rc = zx.Grep(xfs, "/", "", "a1 (", 0)
+	for range rc {
+	}
+	if cerror(rc) == nil {
+		t.Fatalf("grep with bad rexp did not fail")
+	}
+}
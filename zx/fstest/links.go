@@ -0,0 +1,101 @@
+package fstest
+
+import (
+	"bytes"
+	"clive/zx"
+)
+
+// Check that symbolic links are created, described, and followed.
+func Symlinks(t Fataler, xfs zx.Fs) {
+	fs, ok := xfs.(zx.Symlinker)
+	if !ok {
+		t.Fatalf("not a Symlinker")
+	}
+	links := []string{"a2", "/a/a1", "../e"}
+	paths := []string{"/a/l1", "/l2", "/a/l3"}
+	for i, p := range paths {
+		if err := <-fs.Symlink(links[i], p); err != nil {
+			t.Fatalf("symlink %s: %s", p, err)
+		}
+		d, err := zx.Lstat(xfs, p)
+		if err != nil {
+			t.Fatalf("lstat %s: %s", p, err)
+		}
+		Printf("lstat %s\n", d.LongFmt())
+		if d["type"] != "l" || d["target"] != links[i] || d["path"] != p {
+			t.Fatalf("bad link entry %s", d)
+		}
+		d, err = zx.Stat(xfs, p)
+		if err != nil {
+			t.Fatalf("stat %s: %s", p, err)
+		}
+		Printf("stat %s\n", d.LongFmt())
+		if d["type"] == "l" || d["target"] != "" {
+			t.Fatalf("stat did not follow the link")
+		}
+	}
+	gfs, ok := xfs.(zx.Getter)
+	if !ok {
+		t.Fatalf("not a Getter")
+	}
+	dat, err := zx.GetAll(gfs, "/l2")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if !bytes.Equal(dat, FileData["/a/a1"]) {
+		t.Fatalf("get did not follow the link")
+	}
+	ds, err := zx.GetDir(gfs, "/a")
+	if err != nil {
+		t.Fatalf("getdir: %s", err)
+	}
+	nlinks := 0
+	for _, d := range ds {
+		if d["type"] == "l" {
+			nlinks++
+		}
+	}
+	if nlinks != 2 {
+		t.Fatalf("getdir: %d links", nlinks)
+	}
+	if err := <-fs.Symlink("../../x", "/a/l4"); err == nil {
+		t.Fatalf("could link outside of the tree")
+	}
+	if err := <-fs.Symlink("a1", "/a/a2"); err == nil {
+		t.Fatalf("could link over an existing file")
+	}
+	// a link within the tree may refer to a file out of it once moved.
+	if mfs, ok := xfs.(zx.Mover); ok {
+		if err := <-fs.Symlink("../..", "/a/b/l5"); err != nil {
+			t.Fatalf("symlink: %s", err)
+		}
+		if _, err := zx.Stat(xfs, "/a/b/l5"); err != nil {
+			t.Fatalf("stat: %s", err)
+		}
+		if err := <-mfs.Move("/a/b/l5", "/l5"); err != nil {
+			t.Fatalf("move: %s", err)
+		}
+		if _, err := zx.Stat(xfs, "/l5"); err == nil {
+			t.Fatalf("could stat out of the tree")
+		}
+		if _, err := zx.GetDir(gfs, "/l5"); err == nil {
+			t.Fatalf("could get out of the tree")
+		}
+		if _, err := zx.Stat(xfs, "/l5/tmp"); err == nil {
+			t.Fatalf("could walk out of the tree")
+		}
+		if rfs, ok := xfs.(zx.Remover); ok {
+			if err := <-rfs.Remove("/l5"); err != nil {
+				t.Fatalf("remove: %s", err)
+			}
+		}
+	}
+	if rfs, ok := xfs.(zx.Remover); ok {
+		if err := <-rfs.Remove("/l2"); err != nil {
+			t.Fatalf("remove: %s", err)
+		}
+		if _, err := zx.Stat(xfs, "/a/a1"); err != nil {
+			t.Fatalf("remove removed the target")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package fstest
+
+import (
+	"clive/zx"
+	"time"
+)
+
+// Check that locks exclude other owners and expire.
+func Locks(t Fataler, xfs zx.Fs) {
+	fs, ok := xfs.(zx.Locker)
+	if !ok {
+		t.Fatalf("not a Locker")
+	}
+	lock := func(owner string, tmout time.Duration) (zx.Dir, error) {
+		rc := fs.Lock("/a/a1", owner, tmout)
+		d := <-rc
+		return d, cerror(rc)
+	}
+	d, err := lock("one", time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+	Printf("locked %s\n", d.LongFmt())
+	if d["lockowner"] != "one" || d["lockuid"] == "" || d["lockexp"] == "" {
+		t.Fatalf("no lock attrs")
+	}
+	if _, err := lock("two", time.Minute); !zx.IsLocked(err) {
+		t.Fatalf("locked twice: %v", err)
+	}
+	if err := <-fs.Unlock("/a/a1", "two"); !zx.IsLocked(err) {
+		t.Fatalf("unlocked by other: %v", err)
+	}
+	if _, err := lock("one", time.Minute); err != nil {
+		t.Fatalf("renew: %s", err)
+	}
+	if err := <-fs.Unlock("/a/a1", "one"); err != nil {
+		t.Fatalf("unlock: %s", err)
+	}
+	if d, err := zx.Stat(xfs, "/a/a1"); err != nil || d["lockowner"] != "" {
+		t.Fatalf("still locked: %v", err)
+	}
+	if _, err := lock("two", 100*time.Millisecond); err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := lock("one", time.Minute); err != nil {
+		t.Fatalf("lease did not expire: %s", err)
+	}
+	rc := fs.Lock("/nolock", "one", time.Minute)
+	<-rc
+	if cerror(rc) == nil {
+		t.Fatalf("locked a missing file")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"clive/dbg"
 	"clive/zx"
 	"fmt"
+	"strings"
 )
 
 struct putTest {
@@ -200,3 +201,74 @@ Loop:
 		goto Loop
 	}
 }
+
+// Check that appends do not truncate files and that concurrent
+// appends keep all the data.
+func Appends(t Fataler, xfs zx.Fs) {
+	fs, ok := xfs.(zx.Putter)
+	if !ok {
+		t.Fatalf("not a Putter")
+	}
+	gfs, ok := xfs.(zx.Getter)
+	if !ok {
+		t.Fatalf("not a Getter")
+	}
+	appendf := func(p string, msgs ...string) error {
+		dc := make(chan []byte, len(msgs))
+		for _, m := range msgs {
+			dc <- []byte(m)
+		}
+		close(dc)
+		rc := fs.Put(p, zx.Dir{"type": "-", "mode": "0640"}, -1, dc)
+		<-rc
+		return cerror(rc)
+	}
+	if err := appendf("/a/a1", "more\n"); err != nil {
+		t.Fatalf("append: %s", err)
+	}
+	dat, err := zx.GetAll(gfs, "/a/a1")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	odat := FileData["/a/a1"]
+	if !bytes.Equal(dat, append(odat[:len(odat):len(odat)], "more\n"...)) {
+		t.Fatalf("append truncated the file")
+	}
+
+	nw, nmsgs := 5, 20
+	errc := make(chan error, nw)
+	for i := 0; i < nw; i++ {
+		go func(i int) {
+			var err error
+			for j := 0; j < nmsgs && err == nil; j++ {
+				err = appendf("/log", fmt.Sprintf("w%d m%d\n", i, j))
+			}
+			errc <- err
+		}(i)
+	}
+	for i := 0; i < nw; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("append: %s", err)
+		}
+	}
+	dat, err = zx.GetAll(gfs, "/log")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(dat), "\n"), "\n")
+	if len(lines) != nw*nmsgs {
+		t.Fatalf("log has %d lines and not %d", len(lines), nw*nmsgs)
+	}
+	seen := map[string]bool{}
+	for _, ln := range lines {
+		seen[ln] = true
+	}
+	for i := 0; i < nw; i++ {
+		for j := 0; j < nmsgs; j++ {
+			if ln := fmt.Sprintf("w%d m%d", i, j); !seen[ln] {
+				t.Fatalf("line %q not in the log", ln)
+			}
+		}
+	}
+	Printf("log has %d lines\n", len(lines))
+}
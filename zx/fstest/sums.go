@@ -0,0 +1,71 @@
+package fstest
+
+import (
+	"bytes"
+	"clive/zx"
+	"fmt"
+)
+
+// Check that checksums are computed and used to resume transfers.
+func Sums(t Fataler, xfs zx.Fs) {
+	var sums []string
+	sc := zx.Sums(xfs, "/a/a2", 1000)
+	for s := range sc {
+		sums = append(sums, s)
+	}
+	if err := cerror(sc); err != nil {
+		t.Fatalf("sums: %s", err)
+	}
+	xsums := zx.DataSums(1000, FileData["/a/a2"])
+	Printf("%d sums\n", len(sums))
+	if fmt.Sprint(sums) != fmt.Sprint(xsums) {
+		t.Fatalf("bad sums")
+	}
+	sc = zx.Sums(xfs, "/a", 1000)
+	for range sc {
+	}
+	if cerror(sc) == nil {
+		t.Fatalf("could checksum a directory")
+	}
+
+	pfs, ok := xfs.(zx.Putter)
+	if !ok {
+		t.Fatalf("not a Putter")
+	}
+	gfs, ok := xfs.(zx.Getter)
+	if !ok {
+		t.Fatalf("not a Getter")
+	}
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3*zx.SumBlkSz; i++ {
+		fmt.Fprintf(&buf, "resumed line %d\n", i)
+	}
+	dat := buf.Bytes()
+	part := append([]byte{}, dat[:2*zx.SumBlkSz+100]...)
+	part = append(part, "not in the source\n"...)
+	if err := zx.PutAll(pfs, "/r0", dat); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if err := zx.PutAll(pfs, "/r1", part); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	off, err := zx.ResumeOff(xfs, "/r0", xfs, "/r1")
+	if err != nil {
+		t.Fatalf("resume off: %s", err)
+	}
+	if off != 2*zx.SumBlkSz {
+		t.Fatalf("resume off is %d", off)
+	}
+	for _, p := range []string{"/r1", "/a/r2"} {
+		if _, err := zx.Resume(xfs, "/r0", xfs, p, zx.Dir{"mode": "0640"}); err != nil {
+			t.Fatalf("resume %s: %s", p, err)
+		}
+		got, err := zx.GetAll(gfs, p)
+		if err != nil {
+			t.Fatalf("get: %s", err)
+		}
+		if !bytes.Equal(got, dat) {
+			t.Fatalf("%s: bad data after resume", p)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package fstest
+
+import (
+	"clive/zx"
+	"time"
+)
+
+var watchchgs = []string{
+	"add /nw", "data /nw", "meta /nw", "del /nw",
+}
+
+// Check that changes are notified, and only those matching the predicate.
+func Watches(t Fataler, xfs zx.Fs) {
+	fs, ok := xfs.(zx.Watcher)
+	if !ok {
+		t.Fatalf("not a Watcher")
+	}
+	wc := fs.Watch("/", "type=-")
+	time.Sleep(100 * time.Millisecond) // let remote watches start
+	if err := zx.PutAll(xfs.(zx.Putter), "/nw", []byte("one")); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if err := zx.PutAll(xfs.(zx.Putter), "/nw", []byte("two")); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	pc := xfs.(zx.Putter).Put("/nwd", zx.Dir{"type": "d"}, 0, nil)
+	<-pc
+	if err := cerror(pc); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	rc := xfs.(zx.Wstater).Wstat("/nw", zx.Dir{"mode": "0600"})
+	<-rc
+	if err := cerror(rc); err != nil {
+		t.Fatalf("wstat: %s", err)
+	}
+	for _, p := range []string{"/nw", "/nwd"} {
+		if err := <-xfs.(zx.Remover).Remove(p); err != nil {
+			t.Fatalf("remove: %s", err)
+		}
+	}
+	for i, c := range watchchgs {
+		d := <-wc
+		if d == nil {
+			t.Fatalf("watch: %v", cerror(wc))
+		}
+		Printf("chg #%d %s %s\n", i, d["chg"], d["path"])
+		if s := d["chg"] + " " + d["path"]; s != c {
+			t.Fatalf("chg #%d is %q", i, s)
+		}
+	}
+	close(wc)
+}
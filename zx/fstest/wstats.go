@@ -111,3 +111,55 @@ func Attrs(t Fataler, xfs zx.Fs) {
 		}
 	}
 }
+
+// Check that user attributes are kept as given and bad ones are rejected.
+func UserAttrs(t Fataler, xfs zx.Fs) {
+	if afs, ok := xfs.(zx.Auther); ok {
+		var err error
+		xfs, err = afs.Auth(&auth.Info{Uid: "elf", SpeaksFor: "elf", Ok: true})
+		if err != nil {
+			t.Fatalf("auth failed: %s", err)
+		}
+	}
+	fs, ok := xfs.(zx.Wstater)
+	if !ok {
+		t.Fatalf("not a Wstater")
+	}
+	nd := zx.Dir{
+		"note":    "a \"quoted\" value\nwith ñ and two lines",
+		"x-count": "-42",
+	}
+	nd.SetList("tags", []string{"a b", "c", ""})
+	rc := fs.Wstat("/a", nd)
+	<-rc
+	if err := cerror(rc); err != nil {
+		t.Fatalf("wstat: %s", err)
+	}
+	d, err := zx.Stat(xfs, "/a")
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+	Printf("stat: %s\n", d.LongFmt())
+	for k, v := range nd {
+		if d[k] != v {
+			t.Fatalf("attr %s is %q and not %q", k, d[k], v)
+		}
+	}
+	if d.Int("x-count") != -42 || len(d.List("tags")) != 3 {
+		t.Fatalf("bad typed attrs")
+	}
+	bad := []zx.Dir{
+		{"9lives": "x"},
+		{"a b": "x"},
+		{"mode": "rw"},
+		{"size": "-1"},
+		{"note": "\xff"},
+	}
+	for _, bd := range bad {
+		rc := fs.Wstat("/a", bd)
+		<-rc
+		if err := cerror(rc); !zx.IsBadAttr(err) {
+			t.Fatalf("wstat %s: %v", bd, err)
+		}
+	}
+}
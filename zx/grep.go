@@ -0,0 +1,143 @@
+package zx
+
+import (
+	"bytes"
+	"clive/sre"
+	"strings"
+)
+
+// Search the files at or under path matching pred for lines matching rexp,
+// using fs if it's a Grepper, or getting the files otherwise (see GrepFiles).
+func Grep(fs Fs, path, pred, rexp string, nctx int) <-chan face{} {
+	if gfs, ok := fs.(Grepper); ok {
+		return gfs.Grep(path, pred, rexp, nctx)
+	}
+	return GrepFiles(fs, path, pred, rexp, nctx)
+}
+
+// Search the files at or under path matching pred for lines matching rexp,
+// finding and getting them from fs, which must be a Finder and a Getter.
+// This is what Grep does for trees that are not Greppers, and what
+// a Grepper may do, near the files.
+// Files that can't be read are skipped.
+func GrepFiles(fs Fs, path, pred, rexp string, nctx int) <-chan face{} {
+	c := make(chan face{})
+	go func() {
+		close(c, grepFiles(fs, path, pred, rexp, nctx, c))
+	}()
+	return c
+}
+
+func grepFiles(fs Fs, path, pred, rexp string, nctx int, c chan<- face{}) error {
+	re, err := sre.CompileStr(rexp, sre.Fwd)
+	if err != nil {
+		return err
+	}
+	ffs, ok := fs.(Finder)
+	if !ok {
+		return ErrBug
+	}
+	gfs, ok := fs.(Getter)
+	if !ok {
+		return ErrBug
+	}
+	dc := ffs.Find(path, pred, "", "", 0)
+	for d := range dc {
+		if d["err"] != "" || d["type"] != "-" {
+			continue
+		}
+		g := &grepper{name: d["path"], re: re, nctx: nctx, c: c}
+		if err := g.grep(gfs); err != nil {
+			close(dc, err)
+			return err
+		}
+	}
+	return cerror(dc)
+}
+
+// Matches for a file, with their context lines, merging those that overlap.
+struct grepper {
+	name     string
+	re       *sre.ReProg
+	nctx     int
+	c        chan<- face{}
+	nln      int      // lines seen
+	before   []string // last nctx lines not in the hunk
+	hunk     bytes.Buffer
+	ln0, ln1 int // lines in the hunk
+	after    int // context lines still due after the last match
+}
+
+// Send the current hunk, if any.
+func (g *grepper) flush() bool {
+	if g.hunk.Len() == 0 {
+		return true
+	}
+	if ok := g.c <- (Addr{Name: g.name, Ln0: g.ln0, Ln1: g.ln1}); !ok {
+		return false
+	}
+	ok := g.c <- g.hunk.String()
+	g.hunk.Reset()
+	return ok
+}
+
+func (g *grepper) line(s string) bool {
+	g.nln++
+	if g.re.ExecStr(s, 0, -1) != nil {
+		if g.hunk.Len() == 0 {
+			g.ln0 = g.nln - len(g.before)
+			for _, b := range g.before {
+				g.hunk.WriteString(b)
+			}
+		}
+		g.before = g.before[:0]
+		g.hunk.WriteString(s)
+		g.ln1, g.after = g.nln, g.nctx
+		return true
+	}
+	if g.after > 0 {
+		g.hunk.WriteString(s)
+		g.ln1 = g.nln
+		g.after--
+		return true
+	}
+	if !g.flush() {
+		return false
+	}
+	if g.nctx > 0 {
+		if len(g.before) == g.nctx {
+			g.before = append(g.before[:0], g.before[1:]...)
+		}
+		g.before = append(g.before, s)
+	}
+	return true
+}
+
+// Get the file and send its matches.
+// The error returned is that for the output chan.
+func (g *grepper) grep(fs Getter) error {
+	gc := fs.Get(g.name, 0, All)
+	part := ""
+	for b := range gc {
+		s := part + string(b)
+		for {
+			i := strings.IndexByte(s, '\n')
+			if i < 0 {
+				break
+			}
+			if !g.line(s[:i+1]) {
+				close(gc, cerror(g.c))
+				return cerror(g.c)
+			}
+			s = s[i+1:]
+		}
+		part = s
+	}
+	if part != "" && !g.line(part) {
+		return cerror(g.c)
+	}
+	if !g.flush() {
+		return cerror(g.c)
+	}
+	return nil
+}
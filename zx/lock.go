@@ -0,0 +1,82 @@
+package zx
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lease used by Locker.Lock when no timeout is given.
+const DefLease = time.Minute
+
+// Advisory locks for a file system, to help implement Locker.
+// The zero value is ready to use.
+struct Locks {
+	lk  sync.Mutex
+	set map[string]lease
+}
+
+struct lease {
+	uid, owner string
+	exp        time.Time
+}
+
+// Lock path for uid and owner during tmout (DefLease if not positive),
+// or renew the lock if they already hold it.
+func (ls *Locks) Lock(path, uid, owner string, tmout time.Duration) error {
+	if tmout <= 0 {
+		tmout = DefLease
+	}
+	ls.lk.Lock()
+	defer ls.lk.Unlock()
+	now := time.Now()
+	if l, ok := ls.set[path]; ok && now.Before(l.exp) &&
+		(l.uid != uid || l.owner != owner) {
+		return fmt.Errorf("%s: %s by %s", path, ErrLocked, l.uid)
+	}
+	if ls.set == nil {
+		ls.set = map[string]lease{}
+	}
+	ls.set[path] = lease{uid: uid, owner: owner, exp: now.Add(tmout)}
+	return nil
+}
+
+// Release the lock for path held by uid and owner.
+// It's not an error to release a lock that expired.
+func (ls *Locks) Release(path, uid, owner string) error {
+	ls.lk.Lock()
+	defer ls.lk.Unlock()
+	l, ok := ls.set[path]
+	if !ok {
+		return nil
+	}
+	if time.Now().Before(l.exp) && (l.uid != uid || l.owner != owner) {
+		return fmt.Errorf("%s: %s by %s", path, ErrLocked, l.uid)
+	}
+	delete(ls.set, path)
+	return nil
+}
+
+// Forget the lock for path, if any (eg., when the file is removed).
+func (ls *Locks) Clear(path string) {
+	ls.lk.Lock()
+	defer ls.lk.Unlock()
+	delete(ls.set, path)
+}
+
+// Set in d the lock attributes for path, if it's locked.
+func (ls *Locks) SetAttrs(path string, d Dir) {
+	ls.lk.Lock()
+	defer ls.lk.Unlock()
+	l, ok := ls.set[path]
+	if !ok {
+		return
+	}
+	if !time.Now().Before(l.exp) {
+		delete(ls.set, path)
+		return
+	}
+	d["lockuid"] = l.uid
+	d["lockowner"] = l.owner
+	d.SetTime("lockexp", l.exp)
+}
@@ -0,0 +1,954 @@
+/*
+	ZX client for 9P2000 file servers.
+
+	It makes resources served with 9P (eg. acme, the plumber, or
+	other Plan 9 and plan9port file servers) usable as zx trees,
+	and they can be mounted in name spaces using "9p!" addresses.
+
+	9P has no links, locks, or zx attributes, and authentication
+	is not supported: the server must accept attaches with no auth fid.
+*/
+package p9
+
+import (
+	"clive/dbg"
+	cnet "clive/net"
+	"clive/u"
+	"clive/zx"
+	"clive/zx/pred"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	fpath "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 9P client
+struct Fs {
+	*dbg.Flag
+	*zx.Flags
+	*zx.Stats
+	addr       string // net!host!port
+	raddr      string // addr used to cache dials
+	aname      string
+	conn       net.Conn
+	msize      uint32
+	wlk        sync.Mutex // for writes to conn
+	sync.Mutex            // for the fields below
+	rpcs       map[uint16]chan *Msg
+	tag        uint16
+	fid        uint32
+	fids       []uint32 // free fids
+	err        error    // set when the connection is gone
+}
+
+const (
+	rootFid  = 0
+	maxMsize = 64*1024 + ioHdrSz
+)
+
+var (
+	dials   = map[string]*Fs{}
+	dialslk sync.Mutex
+	_fs     zx.RWFs       = &Fs{}
+	_fs2    zx.Mover      = &Fs{}
+	_fs3    zx.Finder     = &Fs{}
+	_fs4    zx.FindGetter = &Fs{}
+)
+
+func (fs *Fs) String() string {
+	return fs.Tag
+}
+
+// return network!host!port!aname from addr.
+// 	host -> tcp!host!564!
+//	host!port -> tcp!host!port!
+//	net!host!port -> net!host!port!
+// For unix sockets, the port is the path for the socket.
+func FillAddr(addr string) string {
+	toks := strings.Split(addr, "!")
+	switch len(toks) {
+	case 1:
+		return fmt.Sprintf("tcp!%s!564!", toks[0])
+	case 2:
+		return fmt.Sprintf("tcp!%s!%s!", toks[0], toks[1])
+	case 3:
+		return addr + "!"
+	default:
+		return addr
+	}
+}
+
+func dial(addr string) (net.Conn, error) {
+	nw, host, port := cnet.ParseAddr(addr)
+	switch nw {
+	case "unix":
+		return net.Dial("unix", port)
+	case "tcp", "*":
+		if host == "*" || host == "local" {
+			host = "localhost"
+		}
+		return net.Dial("tcp", net.JoinHostPort(host, port))
+	default:
+		return nil, cnet.ErrBadAddr
+	}
+}
+
+// Dial the 9P server at addr (completed if needed using FillAddr)
+// and attach to the tree named by the last element of the address.
+// The previously dialed addresses are cached and the
+// old connections are returned, unless they are gone.
+// Network errors are reported including "i/o error".
+func Dial(addr string) (*Fs, error) {
+	addr = FillAddr(addr)
+	dialslk.Lock()
+	defer dialslk.Unlock()
+	if fs, ok := dials[addr]; ok && fs.error() == nil {
+		return fs, nil
+	}
+	n := strings.LastIndexByte(addr, '!')
+	c, err := dial(addr[:n])
+	if err != nil {
+		return nil, err
+	}
+	fs := &Fs{
+		Flag:  &dbg.Flag{Tag: "9p!" + addr[:n]},
+		Flags: &zx.Flags{},
+		Stats: &zx.Stats{},
+		addr:  addr[:n],
+		raddr: addr,
+		aname: addr[n+1:],
+		conn:  c,
+		msize: maxMsize,
+		rpcs:  map[uint16]chan *Msg{},
+	}
+	fs.Flags.Add("debug", &fs.Debug)
+	fs.Flags.Add("clear", func(...string) error {
+		fs.Stats.Clear()
+		return nil
+	})
+	go fs.reader()
+	if err := fs.attach(); err != nil {
+		fs.Close()
+		return nil, fmt.Errorf("%s: %s", fs.addr, err)
+	}
+	dials[addr] = fs
+	return fs, nil
+}
+
+func (fs *Fs) attach() error {
+	r, err := fs.rpc(&Msg{Type: Tversion, Msize: maxMsize, Version: Version})
+	if err != nil {
+		return err
+	}
+	if r.Version != Version {
+		return fmt.Errorf("9P version %q not supported", r.Version)
+	}
+	if r.Msize < fs.msize {
+		if r.Msize <= ioHdrSz {
+			return ErrBadMsg
+		}
+		fs.msize = r.Msize
+	}
+	_, err = fs.rpc(&Msg{Type: Tattach, Fid: rootFid, Afid: NoFid, Uname: u.Uid, Aname: fs.aname})
+	return err
+}
+
+// Hang up the connection to the server.
+func (fs *Fs) Close() error {
+	fs.hangup(errors.New("closed"))
+	return nil
+}
+
+func (fs *Fs) error() error {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.err
+}
+
+// Record the error for the connection, hang it up,
+// and fail the rpcs in progress.
+func (fs *Fs) hangup(err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if fs.err != nil {
+		return
+	}
+	fs.Dprintf("hangup: %s\n", err)
+	fs.err = fmt.Errorf("%s: i/o error: %s", fs.addr, err)
+	fs.conn.Close()
+	for t, c := range fs.rpcs {
+		close(c)
+		delete(fs.rpcs, t)
+	}
+}
+
+// Read replies and send them to the rpcs waiting for them.
+func (fs *Fs) reader() {
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(fs.conn, hdr[:]); err != nil {
+			fs.hangup(err)
+			return
+		}
+		n := binary.LittleEndian.Uint32(hdr[:])
+		if n < 7 || n > maxMsize {
+			fs.hangup(ErrBadMsg)
+			return
+		}
+		b := make([]byte, n)
+		copy(b, hdr[:])
+		if _, err := io.ReadFull(fs.conn, b[4:]); err != nil {
+			fs.hangup(err)
+			return
+		}
+		m, err := UnpackMsg(b)
+		if err != nil {
+			fs.hangup(err)
+			return
+		}
+		fs.Lock()
+		c, ok := fs.rpcs[m.Tag]
+		delete(fs.rpcs, m.Tag)
+		fs.Unlock()
+		if !ok {
+			fs.Dprintf("<-%s: no such tag\n", m)
+			continue
+		}
+		c <- m
+	}
+}
+
+// Send a request and wait for its reply.
+// Rerrors are returned as errors.
+func (fs *Fs) rpc(m *Msg) (*Msg, error) {
+	rc := make(chan *Msg, 1)
+	fs.Lock()
+	if err := fs.err; err != nil {
+		fs.Unlock()
+		return nil, err
+	}
+	m.Tag = NoTag
+	if m.Type != Tversion {
+		for {
+			if fs.tag++; fs.tag == NoTag {
+				fs.tag = 0
+			}
+			if _, ok := fs.rpcs[fs.tag]; !ok {
+				break
+			}
+		}
+		m.Tag = fs.tag
+	}
+	fs.rpcs[m.Tag] = rc
+	fs.Unlock()
+	fs.Dprintf("->%s\n", m)
+	fs.wlk.Lock()
+	_, err := fs.conn.Write(m.Pack())
+	fs.wlk.Unlock()
+	if err != nil {
+		fs.hangup(err)
+	}
+	r, ok := <-rc
+	if !ok {
+		return nil, fs.error()
+	}
+	fs.Dprintf("<-%s\n", r)
+	if r.Type == Rerror {
+		return nil, errors.New(r.Ename)
+	}
+	if r.Type != m.Type+1 {
+		return nil, ErrBadMsg
+	}
+	return r, nil
+}
+
+func (fs *Fs) newFid() uint32 {
+	fs.Lock()
+	defer fs.Unlock()
+	if n := len(fs.fids); n > 0 {
+		fid := fs.fids[n-1]
+		fs.fids = fs.fids[:n-1]
+		return fid
+	}
+	fs.fid++
+	return fs.fid
+}
+
+func (fs *Fs) putFid(fid uint32) {
+	fs.Lock()
+	fs.fids = append(fs.fids, fid)
+	fs.Unlock()
+}
+
+func (fs *Fs) clunk(fid uint32) {
+	fs.rpc(&Msg{Type: Tclunk, Fid: fid})
+	fs.putFid(fid)
+}
+
+// Walk to the (clean) path p and return a new fid for it.
+func (fs *Fs) walk(p string) (uint32, error) {
+	els := zx.Elems(p)
+	fid := fs.newFid()
+	from := uint32(rootFid)
+	for {
+		n := len(els)
+		if n > maxWelem {
+			n = maxWelem
+		}
+		r, err := fs.rpc(&Msg{Type: Twalk, Fid: from, Newfid: fid, Wname: els[:n]})
+		if err == nil && len(r.Wqid) < n {
+			err = zx.ErrNotExist
+		}
+		if err != nil {
+			// a failed walk leaves fid as it was
+			if from == fid {
+				fs.clunk(fid)
+			} else {
+				fs.putFid(fid)
+			}
+			return 0, fmt.Errorf("%s: %s", p, err)
+		}
+		from, els = fid, els[n:]
+		if len(els) == 0 {
+			return fid, nil
+		}
+	}
+}
+
+// Walk to the parent of p and create its last element there,
+// opened with the given mode.
+func (fs *Fs) create(p string, perm uint32, mode uint8) (uint32, error) {
+	fid, err := fs.walk(fpath.Dir(p))
+	if err != nil {
+		return 0, err
+	}
+	_, err = fs.rpc(&Msg{Type: Tcreate, Fid: fid, Name: fpath.Base(p), Perm: perm, Mode: mode})
+	if err != nil {
+		fs.clunk(fid)
+		return 0, fmt.Errorf("%s: %s", p, err)
+	}
+	return fid, nil
+}
+
+// Return the max data size for reads and writes.
+func (fs *Fs) iosz(iounit uint32) uint32 {
+	n := fs.msize - ioHdrSz
+	if iounit != 0 && iounit < n {
+		n = iounit
+	}
+	return n
+}
+
+func (fs *Fs) zxDir(d *Dir, p string) zx.Dir {
+	zd := zx.Dir{
+		"name": d.Name,
+		"path": p,
+		"addr": fmt.Sprintf("9p!%s!%s", fs.raddr, p),
+		"type": "-",
+		"uid":  d.Uid,
+		"gid":  d.Gid,
+		"wuid": d.Muid,
+	}
+	zd.SetMode(uint64(d.Mode))
+	zd.SetSize(int64(d.Length))
+	zd.SetTime("mtime", time.Unix(int64(d.Mtime), 0))
+	if d.Mode&DMDIR != 0 {
+		zd["type"] = "d"
+		zd["size"] = "0"
+	}
+	if p == "/" {
+		zd["name"] = "/"
+	}
+	return zd
+}
+
+func (fs *Fs) fstat(fid uint32) (*Dir, error) {
+	r, err := fs.rpc(&Msg{Type: Tstat, Fid: fid})
+	if err != nil {
+		return nil, err
+	}
+	_, d, err := UnpackDir(r.Stat)
+	return d, err
+}
+
+func (fs *Fs) stat(p string) (zx.Dir, error) {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return nil, err
+	}
+	fid, err := fs.walk(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.clunk(fid)
+	d, err := fs.fstat(fid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p, err)
+	}
+	return fs.zxDir(d, p), nil
+}
+
+func (fs *Fs) Stat(p string) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	go func() {
+		fs.Count(zx.Sstat)
+		d, err := fs.stat(p)
+		if err == nil {
+			c <- d
+		}
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) get(p string, off, count int64, c chan<- []byte) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	fid, err := fs.walk(p)
+	if err != nil {
+		return err
+	}
+	defer fs.clunk(fid)
+	r, err := fs.rpc(&Msg{Type: Topen, Fid: fid, Mode: OREAD})
+	if err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	if r.Qid.Type&QTDIR != 0 {
+		return fs.getDir(fid, p, off, count, c)
+	}
+	n := fs.iosz(r.Iounit)
+	for count != 0 {
+		if count > 0 && count < int64(n) {
+			n = uint32(count)
+		}
+		r, err := fs.rpc(&Msg{Type: Tread, Fid: fid, Offset: uint64(off), Count: n})
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		if len(r.Data) == 0 {
+			break
+		}
+		if ok := c <- r.Data; !ok {
+			return cerror(c)
+		}
+		off += int64(len(r.Data))
+		if count > 0 {
+			count -= int64(len(r.Data))
+		}
+	}
+	return nil
+}
+
+// Directories can only be read sequentially, so off entries are
+// read and skipped before sending those asked for.
+func (fs *Fs) getDir(fid uint32, p string, off, count int64, c chan<- []byte) error {
+	doff := uint64(0)
+	for count != 0 {
+		r, err := fs.rpc(&Msg{Type: Tread, Fid: fid, Offset: doff, Count: fs.iosz(0)})
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		if len(r.Data) == 0 {
+			break
+		}
+		doff += uint64(len(r.Data))
+		for b := r.Data; len(b) > 0 && count != 0; {
+			var d *Dir
+			b, d, err = UnpackDir(b)
+			if err != nil {
+				return fmt.Errorf("%s: %s", p, err)
+			}
+			if off > 0 {
+				off--
+				continue
+			}
+			if count > 0 {
+				count--
+			}
+			cd := fs.zxDir(d, fpath.Join(p, d.Name))
+			if ok := c <- cd.Bytes(); !ok {
+				return cerror(c)
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
+	c := make(chan []byte)
+	go func() {
+		fs.Count(zx.Sget)
+		err := fs.get(p, off, count, c)
+		close(c, err)
+	}()
+	return c
+}
+
+// Update the mode, size, and mtime of the file for fid
+// with those present in d.
+func (fs *Fs) fwstat(fid uint32, d zx.Dir) error {
+	nd := NoChgDir()
+	chg := false
+	if d["mode"] != "" {
+		od, err := fs.fstat(fid)
+		if err != nil {
+			return err
+		}
+		nd.Mode = od.Mode&^0777 | uint32(d.Mode())
+		chg = true
+	}
+	if d["size"] != "" && d["type"] != "d" {
+		nd.Length = uint64(d.Size())
+		chg = true
+	}
+	if d["mtime"] != "" {
+		nd.Mtime = uint32(d.Time("mtime").Unix())
+		chg = true
+	}
+	if !chg {
+		return nil
+	}
+	_, err := fs.rpc(&Msg{Type: Twstat, Fid: fid, Stat: nd.Pack()})
+	return err
+}
+
+func (fs *Fs) wstat(p string, d zx.Dir) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	fid, err := fs.walk(p)
+	if err != nil {
+		return err
+	}
+	defer fs.clunk(fid)
+	if err := fs.fwstat(fid, d); err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	return nil
+}
+
+func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		fs.Count(zx.Swstat)
+		d = d.SysDup()
+		if err := d.CheckAttrs(); err != nil {
+			close(rc, err)
+			return
+		}
+		err := fs.wstat(p, d)
+		if err == nil {
+			var d zx.Dir
+			d, err = fs.stat(p)
+			if err == nil {
+				rc <- d
+			}
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Create the directory p and its parents if they do not exist.
+func (fs *Fs) mkdirAll(p string) error {
+	d, err := fs.stat(p)
+	if err == nil {
+		if d["type"] != "d" {
+			return fmt.Errorf("%s: %s", p, zx.ErrNotDir)
+		}
+		return nil
+	}
+	if err := fs.mkdirAll(fpath.Dir(p)); err != nil {
+		return err
+	}
+	fid, err := fs.create(p, DMDIR|0755, OREAD)
+	if err != nil {
+		return err
+	}
+	fs.clunk(fid)
+	return nil
+}
+
+func (fs *Fs) mkdir(p string, d zx.Dir) error {
+	mode := uint32(0755)
+	if d["mode"] != "" {
+		mode = uint32(d.Mode())
+	}
+	fid, err := fs.create(p, DMDIR|mode, OREAD)
+	if err != nil {
+		if od, nerr := fs.stat(p); nerr != nil || od["type"] != "d" {
+			return err
+		}
+		return fs.wstat(p, d)
+	}
+	defer fs.clunk(fid)
+	delete(d, "mode")
+	return fs.fwstat(fid, d)
+}
+
+// Write the data from c into the file open for fid, at off,
+// or at the end of the file for each message if off is < 0.
+func (fs *Fs) write(fid uint32, iounit uint32, off int64, c <-chan []byte) error {
+	if c == nil {
+		return nil
+	}
+	n := fs.iosz(iounit)
+	for b := range c {
+		if off < 0 {
+			d, err := fs.fstat(fid)
+			if err != nil {
+				return err
+			}
+			off = int64(d.Length)
+		}
+		for len(b) > 0 {
+			dat := b
+			if len(dat) > int(n) {
+				dat = dat[:n]
+			}
+			r, err := fs.rpc(&Msg{Type: Twrite, Fid: fid, Offset: uint64(off), Data: dat})
+			if err != nil {
+				return err
+			}
+			if r.Count == 0 {
+				return io.ErrShortWrite
+			}
+			b = b[r.Count:]
+			off += int64(r.Count)
+		}
+	}
+	return cerror(c)
+}
+
+func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	mkall := false
+	if d["type"] == "F" {
+		d["type"] = "-"
+		mkall = true
+	} else if d["type"] == "D" {
+		d["type"] = "d"
+		mkall = true
+	}
+	if mkall {
+		if err := fs.mkdirAll(fpath.Dir(p)); err != nil {
+			return err
+		}
+	}
+	switch d["type"] {
+	case "d":
+		if c != nil {
+			close(c, zx.ErrIsDir)
+		}
+		delete(d, "size")
+		return fs.mkdir(p, d)
+	case "-", "":
+	default:
+		return zx.ErrBadType
+	}
+	if d["type"] == "-" && d["size"] == "" && off >= 0 {
+		d["size"] = "0"
+	}
+	var iounit uint32
+	fid, err := fs.walk(p)
+	if err == nil {
+		mode := uint8(OWRITE)
+		if d["size"] == "0" {
+			mode |= OTRUNC
+		}
+		var r *Msg
+		r, err = fs.rpc(&Msg{Type: Topen, Fid: fid, Mode: mode})
+		if err != nil {
+			fs.clunk(fid)
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		iounit = r.Iounit
+	} else if d["type"] == "" || !zx.IsNotExist(err) {
+		return err
+	} else {
+		mode := uint32(0644)
+		if d["mode"] != "" {
+			mode = uint32(d.Mode())
+		}
+		if fid, err = fs.create(p, mode, OWRITE); err != nil {
+			return err
+		}
+		delete(d, "mode")
+	}
+	defer fs.clunk(fid)
+	if d["size"] != "" && d["size"] != "0" {
+		if err := fs.fwstat(fid, zx.Dir{"size": d["size"]}); err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	if err := fs.write(fid, iounit, off, c); err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	delete(d, "size")
+	if err := fs.fwstat(fid, d); err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	return nil
+}
+
+func (fs *Fs) Put(p string, d zx.Dir, off int64, c <-chan []byte) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		fs.Count(zx.Sput)
+		d = d.SysDup()
+		err := fs.put(p, d, off, c)
+		if err != nil && c != nil {
+			close(c, err)
+		}
+		if err == nil {
+			var d zx.Dir
+			d, err = fs.stat(p)
+			if err == nil {
+				rc <- d
+			}
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+func (fs *Fs) remove(p string, all bool) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return fmt.Errorf("remove %s: %s", p, zx.ErrPerm)
+	}
+	if all {
+		if d, err := fs.stat(p); err == nil && d["type"] == "d" {
+			ds, err := zx.GetDir(fs, p)
+			if err != nil {
+				return err
+			}
+			for _, cd := range ds {
+				if err := fs.remove(cd["path"], cd["type"] == "d"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	fid, err := fs.walk(p)
+	if err != nil {
+		return err
+	}
+	// the fid is clunked even if the remove fails
+	_, err = fs.rpc(&Msg{Type: Tremove, Fid: fid})
+	fs.putFid(fid)
+	if err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	return nil
+}
+
+func (fs *Fs) Remove(p string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Sremove)
+		err := fs.remove(p, false)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) RemoveAll(p string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Sremove)
+		err := fs.remove(p, true)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+// 9P can only rename files within their directory; other moves
+// copy the file and then remove the old one.
+// Like rename(2), an existing file at to is replaced only by a file,
+// and an existing directory only by a directory, if it is empty.
+func (fs *Fs) move(from, to string) error {
+	pfrom, err := zx.UseAbsPath(from)
+	if err != nil {
+		return err
+	}
+	pto, err := zx.UseAbsPath(to)
+	if err != nil {
+		return err
+	}
+	if pfrom == pto {
+		return nil
+	}
+	if pfrom == "/" || pto == "/" {
+		return fmt.Errorf("move %s: %s", pfrom, zx.ErrPerm)
+	}
+	if zx.HasPrefix(pto, pfrom) {
+		return fmt.Errorf("move %s: inconsistent move", from)
+	}
+	d, err := fs.stat(pfrom)
+	if err != nil {
+		return err
+	}
+	if od, err := fs.stat(pto); err == nil {
+		if d["type"] == "d" && od["type"] != "d" {
+			return fmt.Errorf("move %s: %s: %s", pfrom, pto, zx.ErrNotDir)
+		}
+		if d["type"] != "d" && od["type"] == "d" {
+			return fmt.Errorf("move %s: %s: %s", pfrom, pto, zx.ErrIsDir)
+		}
+		if err := fs.remove(pto, false); err != nil {
+			return err
+		}
+	}
+	if fpath.Dir(pfrom) != fpath.Dir(pto) {
+		if err := zx.CopyTo(fs, pfrom, fs, pto); err != nil {
+			return err
+		}
+		return fs.remove(pfrom, true)
+	}
+	fid, err := fs.walk(pfrom)
+	if err != nil {
+		return err
+	}
+	defer fs.clunk(fid)
+	nd := NoChgDir()
+	nd.Name = fpath.Base(pto)
+	if _, err = fs.rpc(&Msg{Type: Twstat, Fid: fid, Stat: nd.Pack()}); err != nil {
+		return fmt.Errorf("move %s: %s", pfrom, err)
+	}
+	return nil
+}
+
+func (fs *Fs) Move(from, to string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Smove)
+		err := fs.move(from, to)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+// d is a dup and can be changed.
+func (fs *Fs) findr(d zx.Dir, fp *pred.Pred, p, spref, dpref string, lvl int, c chan<- zx.Dir) error {
+	match, pruned, err := fp.EvalAt(d, lvl)
+	if pruned {
+		if !match {
+			d["proto"] = "9p"
+			d["err"] = "pruned"
+		}
+		c <- d
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var ds []zx.Dir
+	if d["type"] == "d" {
+		ds, err = zx.GetDir(fs, p)
+		if err != nil {
+			d["err"] = err.Error()
+		}
+	}
+	if match || err != nil {
+		if ok := c <- d; !ok {
+			return cerror(c)
+		}
+	}
+	for _, cd := range ds {
+		cp := cd["path"]
+		if spref != dpref {
+			suff := zx.Suffix(cp, spref)
+			if suff == "" {
+				return fmt.Errorf("%s: %s: %s", spref, cp, zx.ErrNotSuffix)
+			}
+			cd["path"] = fpath.Join(dpref, suff)
+		}
+		if err := fs.findr(cd, fp, cp, spref, dpref, lvl+1, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) find(p, fpred, spref, dpref string, depth int, c chan<- zx.Dir) error {
+	d, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+	p = d["path"]
+	if spref != "" || dpref != "" {
+		spref, err = zx.UseAbsPath(spref)
+		if err != nil {
+			return err
+		}
+		dpref, err = zx.UseAbsPath(dpref)
+		if err != nil {
+			return err
+		}
+	}
+	fp, err := pred.New(fpred)
+	if err != nil {
+		return err
+	}
+	if spref != dpref {
+		suff := zx.Suffix(p, spref)
+		if suff == "" {
+			return fmt.Errorf("suffix %s %s: %s", spref, p, zx.ErrNotSuffix)
+		}
+		d["path"] = fpath.Join(dpref, suff)
+	}
+	return fs.findr(d, fp, p, spref, dpref, depth, c)
+}
+
+func (fs *Fs) Find(p, fpred, spref, dpref string, depth0 int) <-chan zx.Dir {
+	c := make(chan zx.Dir)
+	go func() {
+		fs.Count(zx.Sfind)
+		err := fs.find(p, fpred, spref, dpref, depth0, c)
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) FindGet(p, fpred, spref, dpref string, depth0 int) <-chan face{} {
+	c := make(chan face{})
+	go func() {
+		dc := fs.Find(p, fpred, spref, dpref, depth0)
+		for d := range dc {
+			if ok := c <- d.Dup(); !ok {
+				close(dc, cerror(c))
+				return
+			}
+			if d["err"] != "" || d["type"] == "d" {
+				continue
+			}
+			bc := fs.Get(d.SPath(), 0, zx.All)
+			for b := range bc {
+				c <- b
+			}
+			if err := cerror(bc); err != nil {
+				c <- err
+			}
+		}
+		close(c, cerror(dc))
+	}()
+	return c
+}
@@ -0,0 +1,263 @@
+package p9
+
+import (
+	"bytes"
+	"clive/zx"
+	"clive/zx/fstest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	tdir  = "/tmp/p9test"
+	tsock = "/tmp/p9test.sock"
+)
+
+func TestProto(t *testing.T) {
+	msgs := []*Msg{
+		&Msg{Type: Tversion, Tag: NoTag, Msize: 8192, Version: Version},
+		&Msg{Type: Tattach, Tag: 1, Fid: 0, Afid: NoFid, Uname: "nemo", Aname: ""},
+		&Msg{Type: Rerror, Tag: 2, Ename: "file does not exist"},
+		&Msg{Type: Twalk, Tag: 3, Fid: 0, Newfid: 1, Wname: []string{"a", "b"}},
+		&Msg{Type: Rwalk, Tag: 3, Wqid: []Qid{{Type: QTDIR, Path: 1}, {Path: 2}}},
+		&Msg{Type: Tcreate, Tag: 4, Fid: 1, Name: "c", Perm: DMDIR | 0755, Mode: OREAD},
+		&Msg{Type: Ropen, Tag: 5, Qid: Qid{Path: 3}, Iounit: 8168},
+		&Msg{Type: Tread, Tag: 6, Fid: 1, Offset: 10, Count: 100},
+		&Msg{Type: Twrite, Tag: 7, Fid: 1, Offset: 10, Data: []byte("hi there")},
+		&Msg{Type: Rwrite, Tag: 7, Count: 8},
+		&Msg{Type: Tclunk, Tag: 8, Fid: 1},
+	}
+	omsgs := []string{
+		`Tversion tag 65535 msize 8192 "9P2000"`,
+		`Tattach tag 1 fid 0 afid -1 "nemo" ""`,
+		`Rerror tag 2 "file does not exist"`,
+		`Twalk tag 3 fid 0 newfid 1 ["a" "b"]`,
+		`Rwalk tag 3 nwqid 2`,
+		`Tcreate tag 4 fid 1 "c" perm 020000000755 mode 0x0`,
+		`Ropen tag 5 iounit 8168`,
+		`Tread tag 6 fid 1 off 10 count 100`,
+		`Twrite tag 7 fid 1 off 10 count 8`,
+		`Rwrite tag 7 count 8`,
+		`Tclunk tag 8 fid 1`,
+	}
+	for i, m := range msgs {
+		b := m.Pack()
+		nm, err := UnpackMsg(b)
+		if err != nil {
+			t.Fatalf("unpack %s: %s", m, err)
+		}
+		t.Logf("msg %s\n", nm)
+		if nm.String() != omsgs[i] {
+			t.Fatalf("bad msg %s", nm)
+		}
+		if string(nm.Pack()) != string(b) {
+			t.Fatalf("bad msg repack %s", nm)
+		}
+		if _, err := UnpackMsg(b[:len(b)-1]); err == nil {
+			t.Fatalf("could unpack short msg")
+		}
+	}
+
+	d := &Dir{Qid: Qid{Path: 4}, Mode: 0640, Mtime: 10, Length: 3,
+		Name: "a", Uid: "nemo", Gid: "sys", Muid: "nemo"}
+	b := append(d.Pack(), NoChgDir().Pack()...)
+	b, nd, err := UnpackDir(b)
+	if err != nil {
+		t.Fatalf("unpack dir: %s", err)
+	}
+	if *nd != *d {
+		t.Fatalf("bad dir %v", nd)
+	}
+	b, nd, err = UnpackDir(b)
+	if err != nil || len(b) != 0 || nd.Mode != 0xFFFFFFFF || nd.Name != "" {
+		t.Fatalf("bad nochg dir %v %s", nd, err)
+	}
+}
+
+func TestFillAddr(t *testing.T) {
+	addrs := []string{"h", "h!99", "unix!*!/tmp/ns/acme", "tcp!h!99!main"}
+	oaddrs := []string{"tcp!h!564!", "tcp!h!99!", "unix!*!/tmp/ns/acme!", "tcp!h!99!main"}
+	for i, a := range addrs {
+		if fa := FillAddr(a); fa != oaddrs[i] {
+			t.Fatalf("fill %s: %s", a, fa)
+		}
+	}
+}
+
+// Run fn against a 9P client for an in-process server of a test tree.
+func runTest(t *testing.T, fn fstest.TestFunc) {
+	os.Args[0] = "p9.test"
+	fstest.Verb = testing.Verbose()
+	fstest.MkTree(t, tdir)
+	defer os.RemoveAll(tdir)
+	srv, err := newTestSrv(tdir, tsock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	fs, err := Dial("unix!*!" + tsock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fn(t, fs)
+}
+
+// 9P trees have no /Ctl, and the fstest suites listing the tree
+// or its directories expect one; these tests check the same here.
+// fstest.Appends is not run: 9P has no atomic appends, and
+// the client writes at the size it finds for each message.
+
+func TestStats(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, fs zx.Fs) {
+		for _, p := range fstest.AllFiles {
+			d, err := zx.Stat(fs, p)
+			if err != nil {
+				t.Fatalf("stat %s: %s", p, err)
+			}
+			fstest.Printf("%s\n", d.Fmt())
+			if d["path"] != p || !strings.Contains(fstest.AllFilesList, d.Fmt()+"\n") {
+				t.Fatalf("bad stat %s", d.Fmt())
+			}
+		}
+		for _, p := range fstest.NotThere {
+			if _, err := zx.Stat(fs, p); !zx.IsNotExist(err) {
+				t.Fatalf("stat %s: %v", p, err)
+			}
+		}
+		for i, p := range fstest.BadPaths {
+			d, err := zx.Stat(fs, p)
+			if i == 0 && (err != nil || d["path"] != "/") {
+				t.Fatalf("stat %s: %v", p, err)
+			}
+			if i > 0 && err == nil {
+				t.Fatalf("stat %s: didn't fail", p)
+			}
+		}
+	})
+}
+
+func TestGets(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, fs zx.Fs) {
+		gfs := fs.(zx.Getter)
+		for _, p := range fstest.Files {
+			dat, err := zx.GetAll(gfs, p)
+			if err != nil {
+				t.Fatalf("get %s: %s", p, err)
+			}
+			if !bytes.Equal(dat, fstest.FileData[p]) {
+				t.Fatalf("get %s: bad data", p)
+			}
+			var b bytes.Buffer
+			gc := gfs.Get(p, 1024, 10*1024)
+			for m := range gc {
+				b.Write(m)
+			}
+			if err := cerror(gc); err != nil {
+				t.Fatalf("get %s: %s", p, err)
+			}
+			odat := fstest.FileData[p]
+			if len(odat) < 1024 {
+				odat = odat[:0]
+			} else if odat = odat[1024:]; len(odat) > 10*1024 {
+				odat = odat[:10*1024]
+			}
+			if !bytes.Equal(b.Bytes(), odat) {
+				t.Fatalf("get %s 1k 10k: bad data", p)
+			}
+		}
+		ds, err := zx.GetDir(gfs, "/a")
+		if err != nil {
+			t.Fatalf("getdir: %s", err)
+		}
+		out := ""
+		for _, d := range ds {
+			out += d.Fmt() + "\n"
+		}
+		if out != `- rw-r--r--   9.9k /a/a1
+- rw-r--r--  20.9k /a/a2
+d rwxr-xr-x      0 /a/b
+` {
+			t.Fatalf("bad dir /a:\n%s", out)
+		}
+		for _, p := range fstest.BadPaths[1:] {
+			if dat, err := zx.GetAll(gfs, p); err == nil || len(dat) > 0 {
+				t.Fatalf("could get %s", p)
+			}
+		}
+	})
+}
+
+func TestFinds(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, fs zx.Fs) {
+		ffs := fs.(zx.Finder)
+		find := func(p, pred string) (string, error) {
+			out := ""
+			dc := ffs.Find(p, pred, "", "", 0)
+			for d := range dc {
+				out += d.Fmt() + "\n"
+			}
+			return out, cerror(dc)
+		}
+		out, err := find("/", "")
+		if err != nil || out != fstest.AllFilesList {
+			t.Fatalf("find all: %v:\n%s", err, out)
+		}
+		out, err = find("/", "type=d&depth>1")
+		if err != nil || out != `d rwxr-xr-x      0 /a/b
+d rwxr-xr-x      0 /a/b/c
+d rwxr-xr-x      0 /e/f
+` {
+			t.Fatalf("find dirs: %v:\n%s", err, out)
+		}
+		if _, err := find("/xxx", ""); err == nil {
+			t.Fatalf("find /xxx didn't fail")
+		}
+	})
+}
+
+func TestDirPages(t *testing.T) {
+	runTest(t, fstest.DirPages)
+}
+
+func TestPuts(t *testing.T) {
+	runTest(t, fstest.Puts)
+}
+
+func TestMkdirs(t *testing.T) {
+	runTest(t, fstest.Mkdirs)
+}
+
+func TestRemoves(t *testing.T) {
+	runTest(t, fstest.Removes)
+}
+
+func TestWstats(t *testing.T) {
+	runTest(t, fstest.Wstats)
+}
+
+func TestCopies(t *testing.T) {
+	runTest(t, fstest.Copies)
+}
+
+func TestGreps(t *testing.T) {
+	runTest(t, fstest.Greps)
+}
+
+func TestSums(t *testing.T) {
+	runTest(t, fstest.Sums)
+}
+
+// 9P keeps no zx attributes, and fstest.Moves checks that they
+// move along if the tree is a Wstater; this tree hides Wstat.
+struct mvFs {
+	zx.Fs
+	zx.Mover
+}
+
+func TestMoves(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, fs zx.Fs) {
+		fstest.Moves(t, mvFs{fs, fs.(zx.Mover)})
+	})
+}
@@ -0,0 +1,489 @@
+package p9
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type MsgId byte
+
+// 9P2000 message types
+const (
+	Tversion MsgId = iota + 100
+	Rversion
+	Tauth
+	Rauth
+	Tattach
+	Rattach
+	Terror // not used
+	Rerror
+	Tflush
+	Rflush
+	Twalk
+	Rwalk
+	Topen
+	Ropen
+	Tcreate
+	Rcreate
+	Tread
+	Rread
+	Twrite
+	Rwrite
+	Tclunk
+	Rclunk
+	Tremove
+	Rremove
+	Tstat
+	Rstat
+	Twstat
+	Rwstat
+	Tend
+)
+
+const (
+	Version = "9P2000"
+	NoTag   = 0xFFFF
+	NoFid   = 0xFFFFFFFF
+
+	// open modes
+	OREAD  = 0
+	OWRITE = 1
+	ORDWR  = 2
+	OTRUNC = 0x10
+
+	// file mode bits
+	DMDIR    = 0x80000000
+	DMAPPEND = 0x40000000
+	DMEXCL   = 0x20000000
+	DMAUTH   = 0x08000000
+	DMTMP    = 0x04000000
+
+	QTDIR = 0x80
+
+	ioHdrSz  = 24 // header for Tread/Twrite
+	maxWelem = 16 // max names in a walk
+)
+
+var ErrBadMsg = errors.New("bad 9P message")
+
+struct Qid {
+	Type uint8
+	Vers uint32
+	Path uint64
+}
+
+// A 9P2000 message.
+// Only the fields for its type are used.
+struct Msg {
+	Type    MsgId
+	Tag     uint16
+	Fid     uint32   // Tattach, Twalk, Topen, Tcreate, Tread, Twrite, Tclunk, Tremove, Tstat, Twstat
+	Newfid  uint32   // Twalk
+	Afid    uint32   // Tauth, Tattach
+	Msize   uint32   // Tversion, Rversion
+	Version string   // Tversion, Rversion
+	Uname   string   // Tauth, Tattach
+	Aname   string   // Tauth, Tattach
+	Ename   string   // Rerror
+	Oldtag  uint16   // Tflush
+	Wname   []string // Twalk
+	Wqid    []Qid    // Rwalk
+	Qid     Qid      // Rauth, Rattach, Ropen, Rcreate
+	Iounit  uint32   // Ropen, Rcreate
+	Mode    uint8    // Topen, Tcreate
+	Perm    uint32   // Tcreate
+	Name    string   // Tcreate
+	Offset  uint64   // Tread, Twrite
+	Count   uint32   // Tread
+	Data    []byte   // Rread, Twrite
+	Stat    []byte   // Rstat, Twstat
+}
+
+// A 9P2000 directory entry.
+struct Dir {
+	Type   uint16
+	Dev    uint32
+	Qid    Qid
+	Mode   uint32
+	Atime  uint32
+	Mtime  uint32
+	Length uint64
+	Name   string
+	Uid    string
+	Gid    string
+	Muid   string
+}
+
+func (o MsgId) String() string {
+	names := []string{
+		"Tversion", "Rversion", "Tauth", "Rauth", "Tattach", "Rattach",
+		"Terror", "Rerror", "Tflush", "Rflush", "Twalk", "Rwalk",
+		"Topen", "Ropen", "Tcreate", "Rcreate", "Tread", "Rread",
+		"Twrite", "Rwrite", "Tclunk", "Rclunk", "Tremove", "Rremove",
+		"Tstat", "Rstat", "Twstat", "Rwstat",
+	}
+	if o < Tversion || o >= Tend {
+		return fmt.Sprintf("Tunknown(%d)", o)
+	}
+	return names[o-Tversion]
+}
+
+func (m *Msg) String() string {
+	s := fmt.Sprintf("%s tag %d", m.Type, m.Tag)
+	switch m.Type {
+	case Tversion, Rversion:
+		s += fmt.Sprintf(" msize %d %q", m.Msize, m.Version)
+	case Tattach:
+		s += fmt.Sprintf(" fid %d afid %d %q %q", m.Fid, int32(m.Afid), m.Uname, m.Aname)
+	case Rerror:
+		s += fmt.Sprintf(" %q", m.Ename)
+	case Twalk:
+		s += fmt.Sprintf(" fid %d newfid %d %q", m.Fid, m.Newfid, m.Wname)
+	case Rwalk:
+		s += fmt.Sprintf(" nwqid %d", len(m.Wqid))
+	case Topen:
+		s += fmt.Sprintf(" fid %d mode %#x", m.Fid, m.Mode)
+	case Tcreate:
+		s += fmt.Sprintf(" fid %d %q perm %#o mode %#x", m.Fid, m.Name, m.Perm, m.Mode)
+	case Ropen, Rcreate:
+		s += fmt.Sprintf(" iounit %d", m.Iounit)
+	case Tread:
+		s += fmt.Sprintf(" fid %d off %d count %d", m.Fid, m.Offset, m.Count)
+	case Twrite:
+		s += fmt.Sprintf(" fid %d off %d count %d", m.Fid, m.Offset, len(m.Data))
+	case Rread:
+		s += fmt.Sprintf(" count %d", len(m.Data))
+	case Rwrite:
+		s += fmt.Sprintf(" count %d", m.Count)
+	case Tclunk, Tremove, Tstat, Twstat:
+		s += fmt.Sprintf(" fid %d", m.Fid)
+	case Tflush:
+		s += fmt.Sprintf(" oldtag %d", m.Oldtag)
+	}
+	return s
+}
+
+struct buf {
+	b []byte
+}
+
+func (b *buf) u8(v uint8) {
+	b.b = append(b.b, v)
+}
+
+func (b *buf) u16(v uint16) {
+	b.b = append(b.b, byte(v), byte(v>>8))
+}
+
+func (b *buf) u32(v uint32) {
+	b.b = append(b.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (b *buf) u64(v uint64) {
+	b.u32(uint32(v))
+	b.u32(uint32(v >> 32))
+}
+
+func (b *buf) str(s string) {
+	b.u16(uint16(len(s)))
+	b.b = append(b.b, s...)
+}
+
+func (b *buf) qid(q Qid) {
+	b.u8(q.Type)
+	b.u32(q.Vers)
+	b.u64(q.Path)
+}
+
+func (b *buf) data(d []byte) {
+	b.u32(uint32(len(d)))
+	b.b = append(b.b, d...)
+}
+
+func (b *buf) stat(d []byte) {
+	b.u16(uint16(len(d)))
+	b.b = append(b.b, d...)
+}
+
+// Return the message in wire format, including its size.
+func (m *Msg) Pack() []byte {
+	b := &buf{b: make([]byte, 4, 64+len(m.Data)+len(m.Stat))}
+	b.u8(uint8(m.Type))
+	b.u16(m.Tag)
+	switch m.Type {
+	case Tversion, Rversion:
+		b.u32(m.Msize)
+		b.str(m.Version)
+	case Tauth:
+		b.u32(m.Afid)
+		b.str(m.Uname)
+		b.str(m.Aname)
+	case Rauth, Rattach:
+		b.qid(m.Qid)
+	case Tattach:
+		b.u32(m.Fid)
+		b.u32(m.Afid)
+		b.str(m.Uname)
+		b.str(m.Aname)
+	case Rerror:
+		b.str(m.Ename)
+	case Tflush:
+		b.u16(m.Oldtag)
+	case Twalk:
+		b.u32(m.Fid)
+		b.u32(m.Newfid)
+		b.u16(uint16(len(m.Wname)))
+		for _, n := range m.Wname {
+			b.str(n)
+		}
+	case Rwalk:
+		b.u16(uint16(len(m.Wqid)))
+		for _, q := range m.Wqid {
+			b.qid(q)
+		}
+	case Topen:
+		b.u32(m.Fid)
+		b.u8(m.Mode)
+	case Ropen, Rcreate:
+		b.qid(m.Qid)
+		b.u32(m.Iounit)
+	case Tcreate:
+		b.u32(m.Fid)
+		b.str(m.Name)
+		b.u32(m.Perm)
+		b.u8(m.Mode)
+	case Tread:
+		b.u32(m.Fid)
+		b.u64(m.Offset)
+		b.u32(m.Count)
+	case Rread:
+		b.data(m.Data)
+	case Twrite:
+		b.u32(m.Fid)
+		b.u64(m.Offset)
+		b.data(m.Data)
+	case Rwrite:
+		b.u32(m.Count)
+	case Tclunk, Tremove, Tstat:
+		b.u32(m.Fid)
+	case Rstat:
+		b.stat(m.Stat)
+	case Twstat:
+		b.u32(m.Fid)
+		b.stat(m.Stat)
+	}
+	binary.LittleEndian.PutUint32(b.b, uint32(len(b.b)))
+	return b.b
+}
+
+struct unbuf {
+	b   []byte
+	err error
+}
+
+func (b *unbuf) get(n int) []byte {
+	if b.err != nil || len(b.b) < n {
+		b.err = ErrBadMsg
+		return nil
+	}
+	v := b.b[:n]
+	b.b = b.b[n:]
+	return v
+}
+
+func (b *unbuf) u8() uint8 {
+	if v := b.get(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (b *unbuf) u16() uint16 {
+	if v := b.get(2); v != nil {
+		return binary.LittleEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (b *unbuf) u32() uint32 {
+	if v := b.get(4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (b *unbuf) u64() uint64 {
+	if v := b.get(8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (b *unbuf) str() string {
+	return string(b.get(int(b.u16())))
+}
+
+func (b *unbuf) qid() Qid {
+	return Qid{Type: b.u8(), Vers: b.u32(), Path: b.u64()}
+}
+
+func (b *unbuf) bytes(n int) []byte {
+	v := b.get(n)
+	if v == nil {
+		return nil
+	}
+	nv := make([]byte, n)
+	copy(nv, v)
+	return nv
+}
+
+// Unpack a message in wire format, including its size.
+func UnpackMsg(b []byte) (*Msg, error) {
+	if len(b) < 7 || binary.LittleEndian.Uint32(b) != uint32(len(b)) {
+		return nil, ErrBadMsg
+	}
+	u := &unbuf{b: b[4:]}
+	m := &Msg{}
+	m.Type = MsgId(u.u8())
+	m.Tag = u.u16()
+	switch m.Type {
+	case Tversion, Rversion:
+		m.Msize = u.u32()
+		m.Version = u.str()
+	case Tauth:
+		m.Afid = u.u32()
+		m.Uname = u.str()
+		m.Aname = u.str()
+	case Rauth, Rattach:
+		m.Qid = u.qid()
+	case Tattach:
+		m.Fid = u.u32()
+		m.Afid = u.u32()
+		m.Uname = u.str()
+		m.Aname = u.str()
+	case Rerror:
+		m.Ename = u.str()
+	case Tflush:
+		m.Oldtag = u.u16()
+	case Twalk:
+		m.Fid = u.u32()
+		m.Newfid = u.u32()
+		n := int(u.u16())
+		if n > maxWelem {
+			return nil, ErrBadMsg
+		}
+		for i := 0; i < n; i++ {
+			m.Wname = append(m.Wname, u.str())
+		}
+	case Rwalk:
+		n := int(u.u16())
+		if n > maxWelem {
+			return nil, ErrBadMsg
+		}
+		for i := 0; i < n; i++ {
+			m.Wqid = append(m.Wqid, u.qid())
+		}
+	case Topen:
+		m.Fid = u.u32()
+		m.Mode = u.u8()
+	case Ropen, Rcreate:
+		m.Qid = u.qid()
+		m.Iounit = u.u32()
+	case Tcreate:
+		m.Fid = u.u32()
+		m.Name = u.str()
+		m.Perm = u.u32()
+		m.Mode = u.u8()
+	case Tread:
+		m.Fid = u.u32()
+		m.Offset = u.u64()
+		m.Count = u.u32()
+	case Rread:
+		m.Data = u.bytes(int(u.u32()))
+	case Twrite:
+		m.Fid = u.u32()
+		m.Offset = u.u64()
+		m.Data = u.bytes(int(u.u32()))
+	case Rwrite:
+		m.Count = u.u32()
+	case Tclunk, Tremove, Tstat:
+		m.Fid = u.u32()
+	case Rstat:
+		m.Stat = u.bytes(int(u.u16()))
+	case Twstat:
+		m.Fid = u.u32()
+		m.Stat = u.bytes(int(u.u16()))
+	case Rflush, Rclunk, Rremove, Rwstat:
+	default:
+		return nil, ErrBadMsg
+	}
+	if u.err != nil {
+		return nil, u.err
+	}
+	if len(u.b) != 0 {
+		return nil, ErrBadMsg
+	}
+	return m, nil
+}
+
+// A Dir that changes nothing when used in a Twstat.
+func NoChgDir() *Dir {
+	return &Dir{
+		Type:   0xFFFF,
+		Dev:    0xFFFFFFFF,
+		Qid:    Qid{Type: 0xFF, Vers: 0xFFFFFFFF, Path: 0xFFFFFFFFFFFFFFFF},
+		Mode:   0xFFFFFFFF,
+		Atime:  0xFFFFFFFF,
+		Mtime:  0xFFFFFFFF,
+		Length: 0xFFFFFFFFFFFFFFFF,
+	}
+}
+
+// Return the dir entry in wire format, including its size.
+func (d *Dir) Pack() []byte {
+	b := &buf{b: make([]byte, 2, 64)}
+	b.u16(d.Type)
+	b.u32(d.Dev)
+	b.qid(d.Qid)
+	b.u32(d.Mode)
+	b.u32(d.Atime)
+	b.u32(d.Mtime)
+	b.u64(d.Length)
+	b.str(d.Name)
+	b.str(d.Uid)
+	b.str(d.Gid)
+	b.str(d.Muid)
+	binary.LittleEndian.PutUint16(b.b, uint16(len(b.b)-2))
+	return b.b
+}
+
+// Unpack the dir entry at the start of b (in wire format, including
+// its size) and return the rest of b.
+func UnpackDir(b []byte) ([]byte, *Dir, error) {
+	if len(b) < 2 {
+		return nil, nil, ErrBadMsg
+	}
+	n := int(binary.LittleEndian.Uint16(b)) + 2
+	if len(b) < n {
+		return nil, nil, ErrBadMsg
+	}
+	u := &unbuf{b: b[2:n]}
+	d := &Dir{}
+	d.Type = u.u16()
+	d.Dev = u.u32()
+	d.Qid = u.qid()
+	d.Mode = u.u32()
+	d.Atime = u.u32()
+	d.Mtime = u.u32()
+	d.Length = u.u64()
+	d.Name = u.str()
+	d.Uid = u.str()
+	d.Gid = u.str()
+	d.Muid = u.str()
+	if u.err != nil {
+		return nil, nil, u.err
+	}
+	// extensions (eg. 9P2000.u) may add fields at the end.
+	return b[n:], d, nil
+}
@@ -0,0 +1,415 @@
+package p9
+
+import (
+	"clive/u"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	fpath "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A 9P2000 server for the files in a local directory, to test the client.
+// Requests are served one at a time for each connection.
+// Qids are not unique: the client does not use them.
+struct testSrv {
+	root string
+	ln   net.Listener
+	sync.Mutex
+	conns []net.Conn
+}
+
+struct testFid {
+	path string   // clean, rooted at the served dir
+	f    *os.File // if open
+	ents [][]byte // entries left to read, for directories
+}
+
+struct testConn {
+	*testSrv
+	c    net.Conn
+	fids map[uint32]*testFid
+}
+
+var (
+	errFid    = errors.New("unknown fid")
+	errFidUse = errors.New("fid in use")
+	errOpen   = errors.New("fid is open")
+	errNoOpen = errors.New("fid is not open")
+)
+
+// Serve the files at root in the unix socket sock.
+func newTestSrv(root, sock string) (*testSrv, error) {
+	os.Remove(sock)
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	s := &testSrv{root: root, ln: ln}
+	go s.loop()
+	return s, nil
+}
+
+func (s *testSrv) loop() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.Lock()
+		s.conns = append(s.conns, c)
+		s.Unlock()
+		tc := &testConn{testSrv: s, c: c, fids: map[uint32]*testFid{}}
+		go tc.serve()
+	}
+}
+
+// Stop listening and hang up all connections.
+func (s *testSrv) Close() {
+	s.ln.Close()
+	s.Lock()
+	defer s.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+func (tc *testConn) serve() {
+	defer tc.clunkAll()
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(tc.c, hdr[:]); err != nil {
+			return
+		}
+		n := binary.LittleEndian.Uint32(hdr[:])
+		if n < 7 || n > maxMsize {
+			return
+		}
+		b := make([]byte, n)
+		copy(b, hdr[:])
+		if _, err := io.ReadFull(tc.c, b[4:]); err != nil {
+			return
+		}
+		m, err := UnpackMsg(b)
+		if err != nil {
+			return
+		}
+		r, err := tc.rpc(m)
+		if err != nil {
+			r = &Msg{Type: Rerror, Ename: err.Error()}
+		}
+		r.Tag = m.Tag
+		if _, err := tc.c.Write(r.Pack()); err != nil {
+			return
+		}
+	}
+}
+
+func (tc *testConn) clunkAll() {
+	for _, f := range tc.fids {
+		if f.f != nil {
+			f.f.Close()
+		}
+	}
+	tc.c.Close()
+}
+
+func (tc *testConn) uxpath(p string) string {
+	return tc.root + p
+}
+
+// Report errors without the local paths.
+func uxErr(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	}
+	return err
+}
+
+func uxMode(mode uint8) int {
+	flag := os.O_RDONLY
+	switch mode & 3 {
+	case OWRITE:
+		flag = os.O_WRONLY
+	case ORDWR:
+		flag = os.O_RDWR
+	}
+	if mode&OTRUNC != 0 {
+		flag |= os.O_TRUNC
+	}
+	return flag
+}
+
+func newDir(fi os.FileInfo) *Dir {
+	d := &Dir{
+		Mode:   uint32(fi.Mode().Perm()),
+		Atime:  uint32(fi.ModTime().Unix()),
+		Mtime:  uint32(fi.ModTime().Unix()),
+		Length: uint64(fi.Size()),
+		Name:   fi.Name(),
+		Uid:    u.Uid,
+		Gid:    u.Uid,
+		Muid:   u.Uid,
+	}
+	if fi.IsDir() {
+		d.Qid.Type = QTDIR
+		d.Mode |= DMDIR
+		d.Length = 0
+	}
+	return d
+}
+
+func (tc *testConn) stat(p string) (*Dir, error) {
+	fi, err := os.Lstat(tc.uxpath(p))
+	if err != nil {
+		return nil, uxErr(err)
+	}
+	d := newDir(fi)
+	if p == "/" {
+		d.Name = "/"
+	}
+	return d, nil
+}
+
+func (tc *testConn) readDir(p string) ([][]byte, error) {
+	fis, err := ioutil.ReadDir(tc.uxpath(p))
+	if err != nil {
+		return nil, uxErr(err)
+	}
+	ents := make([][]byte, 0, len(fis))
+	for _, fi := range fis {
+		ents = append(ents, newDir(fi).Pack())
+	}
+	return ents, nil
+}
+
+func (tc *testConn) rpc(m *Msg) (*Msg, error) {
+	r := &Msg{Type: m.Type + 1}
+	switch m.Type {
+	case Tversion:
+		r.Msize = m.Msize
+		if r.Msize > maxMsize {
+			r.Msize = maxMsize
+		}
+		r.Version = Version
+		return r, nil
+	case Tauth:
+		return nil, errors.New("authentication not required")
+	case Tflush:
+		return r, nil
+	case Tattach:
+		if _, ok := tc.fids[m.Fid]; ok {
+			return nil, errFidUse
+		}
+		d, err := tc.stat("/")
+		if err != nil {
+			return nil, err
+		}
+		tc.fids[m.Fid] = &testFid{path: "/"}
+		r.Qid = d.Qid
+		return r, nil
+	}
+	f, ok := tc.fids[m.Fid]
+	if !ok {
+		return nil, errFid
+	}
+	switch m.Type {
+	case Twalk:
+		return r, tc.walk(f, m, r)
+	case Topen:
+		if f.f != nil {
+			return nil, errOpen
+		}
+		d, err := tc.stat(f.path)
+		if err != nil {
+			return nil, err
+		}
+		if f.f, err = os.OpenFile(tc.uxpath(f.path), uxMode(m.Mode), 0); err != nil {
+			return nil, uxErr(err)
+		}
+		r.Qid = d.Qid
+		return r, nil
+	case Tcreate:
+		return r, tc.create(f, m, r)
+	case Tread:
+		return r, tc.read(f, m, r)
+	case Twrite:
+		if f.f == nil {
+			return nil, errNoOpen
+		}
+		n, err := f.f.WriteAt(m.Data, int64(m.Offset))
+		if err != nil {
+			return nil, uxErr(err)
+		}
+		r.Count = uint32(n)
+		return r, nil
+	case Tclunk:
+		if f.f != nil {
+			f.f.Close()
+		}
+		delete(tc.fids, m.Fid)
+		return r, nil
+	case Tremove:
+		if f.f != nil {
+			f.f.Close()
+		}
+		delete(tc.fids, m.Fid)
+		if f.path == "/" {
+			return nil, errors.New("permission denied")
+		}
+		if err := os.Remove(tc.uxpath(f.path)); err != nil {
+			return nil, uxErr(err)
+		}
+		return r, nil
+	case Tstat:
+		d, err := tc.stat(f.path)
+		if err != nil {
+			return nil, err
+		}
+		r.Stat = d.Pack()
+		return r, nil
+	case Twstat:
+		return r, tc.wstat(f, m)
+	default:
+		return nil, ErrBadMsg
+	}
+}
+
+// A failed walk of the first name is an error, and a walk of
+// some of the names leaves newfid as it was.
+func (tc *testConn) walk(f *testFid, m, r *Msg) error {
+	if f.f != nil {
+		return errOpen
+	}
+	if _, ok := tc.fids[m.Newfid]; ok && m.Newfid != m.Fid {
+		return errFidUse
+	}
+	p := f.path
+	for _, n := range m.Wname {
+		np := fpath.Join(p, n)
+		d, err := tc.stat(np)
+		if err != nil {
+			if len(r.Wqid) == 0 {
+				return err
+			}
+			return nil
+		}
+		r.Wqid = append(r.Wqid, d.Qid)
+		p = np
+	}
+	tc.fids[m.Newfid] = &testFid{path: p}
+	return nil
+}
+
+func (tc *testConn) create(f *testFid, m, r *Msg) error {
+	if f.f != nil {
+		return errOpen
+	}
+	if m.Name == "" || m.Name == "." || m.Name == ".." || strings.Contains(m.Name, "/") {
+		return errors.New("bad file name")
+	}
+	p := fpath.Join(f.path, m.Name)
+	up := tc.uxpath(p)
+	mode := os.FileMode(m.Perm & 0777)
+	var fd *os.File
+	var err error
+	if m.Perm&DMDIR != 0 {
+		if err = os.Mkdir(up, mode); err == nil {
+			fd, err = os.Open(up)
+		}
+	} else {
+		fd, err = os.OpenFile(up, uxMode(m.Mode)|os.O_CREATE|os.O_EXCL, mode)
+	}
+	if err != nil {
+		return uxErr(err)
+	}
+	// don't let the umask change the permissions
+	os.Chmod(up, mode)
+	d, err := tc.stat(p)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	f.path, f.f = p, fd
+	r.Qid = d.Qid
+	return nil
+}
+
+// Directory reads at offset 0 start again; others continue
+// where the previous one stopped.
+func (tc *testConn) read(f *testFid, m, r *Msg) error {
+	if f.f == nil {
+		return errNoOpen
+	}
+	fi, err := f.f.Stat()
+	if err != nil {
+		return uxErr(err)
+	}
+	if fi.IsDir() {
+		if m.Offset == 0 {
+			if f.ents, err = tc.readDir(f.path); err != nil {
+				return err
+			}
+		}
+		for len(f.ents) > 0 && len(r.Data)+len(f.ents[0]) <= int(m.Count) {
+			r.Data = append(r.Data, f.ents[0]...)
+			f.ents = f.ents[1:]
+		}
+		return nil
+	}
+	b := make([]byte, m.Count)
+	n, err := f.f.ReadAt(b, int64(m.Offset))
+	if err != nil && err != io.EOF {
+		return uxErr(err)
+	}
+	r.Data = b[:n]
+	return nil
+}
+
+// Renames fail if the new name exists, as they do in Plan 9.
+func (tc *testConn) wstat(f *testFid, m *Msg) error {
+	_, nd, err := UnpackDir(m.Stat)
+	if err != nil {
+		return err
+	}
+	up := tc.uxpath(f.path)
+	if nd.Mode != 0xFFFFFFFF {
+		if err := os.Chmod(up, os.FileMode(nd.Mode&0777)); err != nil {
+			return uxErr(err)
+		}
+	}
+	if nd.Length != 0xFFFFFFFFFFFFFFFF {
+		if err := os.Truncate(up, int64(nd.Length)); err != nil {
+			return uxErr(err)
+		}
+	}
+	if nd.Mtime != 0xFFFFFFFF {
+		t := time.Unix(int64(nd.Mtime), 0)
+		if err := os.Chtimes(up, t, t); err != nil {
+			return uxErr(err)
+		}
+	}
+	if nd.Name == "" || nd.Name == fpath.Base(f.path) {
+		return nil
+	}
+	if f.path == "/" || strings.Contains(nd.Name, "/") || nd.Name == ".." {
+		return errors.New("bad file name")
+	}
+	np := fpath.Join(fpath.Dir(f.path), nd.Name)
+	if _, err := os.Lstat(tc.uxpath(np)); err == nil {
+		return errors.New("file already exists")
+	}
+	if err := os.Rename(up, tc.uxpath(np)); err != nil {
+		return uxErr(err)
+	}
+	f.path = np
+	return nil
+}
@@ -11,11 +11,11 @@ func (d Dir) Can(ai *auth.Info, what int) bool {
 		return true
 	}
 	mode := int(d.Mode())
-	if ai.InGroup(d["Uid"]) {
+	if ai.InGroup(d["uid"]) {
 		return mode&what != 0
 	}
 
-	if ai.InGroup(d["Gid"]) {
+	if ai.InGroup(d["gid"]) {
 		return mode&what&077 != 0
 	}
 
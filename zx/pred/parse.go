@@ -33,8 +33,19 @@ const (
 	tPrune tok = 'p'
 	tTrue  tok = 't'
 	tFalse tok = 'f'
+
+	// not ops, these are parsed as !(name ~ value) and !(name ~~ value)
+	tNmatch tok = '≁'
+	tNrexp  tok = '≉'
 )
 
+// Other names for attributes
+var aliases = map[string]string{
+	"user":   "uid",
+	"group":  "gid",
+	"writer": "wuid",
+}
+
 type tClass int
 
 const (
@@ -50,7 +61,7 @@ const (
 
 func ispunct(r rune) bool {
 	switch r {
-	case '!', '&', '|', '~', '=', '(', ')', '>', '<', '\'', ',', ':', '≈', '≡', '≠', '≥', '≤':
+	case '!', '&', '|', '~', '=', '(', ')', '>', '<', '\'', ',', ':', '≈', '≡', '≠', '≥', '≤', '≁', '≉':
 		return true
 	}
 	return false
@@ -66,7 +77,7 @@ func (t tok) class() tClass {
 		return cUn
 	case tAnd, tOr:
 		return cBin
-	case tLe, tEq, tNeq, tGe, tLt, tGt, tEqs, tNeqs, tMatch, tRexp:
+	case tLe, tEq, tNeq, tGe, tLt, tGt, tEqs, tNeqs, tMatch, tRexp, tNmatch, tNrexp:
 		return cOp
 	case tLpar, tRpar:
 		return cPar
@@ -252,7 +263,7 @@ func (l *lex) next() (tok, string, error) {
 		}
 		l.t = l.t[1:]
 		return tMatch, "~", nil
-	case '(', ')', '|', '&', ',', ':', '≈', '≡', '≠':
+	case '(', ')', '|', '&', ',', ':', '≈', '≡', '≠', '≁', '≉':
 		if c == '&' {
 			c = ','
 		} else if c == '|' {
@@ -262,6 +273,14 @@ func (l *lex) next() (tok, string, error) {
 		l.t = l.t[1:]
 		return tok(c), t, nil
 	case '>', '<', '!', '=':
+		if c == '!' && len(l.t) > 1 && l.t[1] == '~' {
+			if len(l.t) > 2 && l.t[2] == '~' {
+				l.t = l.t[3:]
+				return tNrexp, "!~~", nil
+			}
+			l.t = l.t[2:]
+			return tNmatch, "!~", nil
+		}
 		if len(l.t) > 1 && l.t[1] == '=' {
 			l.t = l.t[2:]
 			if c == '!' {
@@ -396,6 +415,18 @@ func (l *lex) parseAnds() (*Pred, error) {
 	}
 }
 
+// Return the predicate for name op value, where negated matches
+// are the negation of the match.
+func newOp(t tok, name, value string) *Pred {
+	switch t {
+	case tNmatch:
+		return Not(&Pred{op: oMatch, name: name, value: value})
+	case tNrexp:
+		return Not(&Pred{op: oRexp, name: name, value: value})
+	}
+	return &Pred{op: op(t), name: name, value: value}
+}
+
 // prim ::= prune | t | true | f | false | name op name | unop prim | maxmin name | n | ( ors )
 // but handle ~ str as meaning "name~str"
 func (l *lex) parsePrim() (*Pred, error) {
@@ -407,7 +438,7 @@ func (l *lex) parsePrim() (*Pred, error) {
 	case t == tPrune, t == tTrue, t == tFalse:
 		l.scan()
 		return &Pred{op: op(t)}, nil
-	case t == tMatch || t == tEqs || t == tRexp:
+	case t == tMatch || t == tEqs || t == tRexp || t == tNmatch || t == tNrexp:
 		// unary usage assumes path op ... if value does contain '/'
 		// and name op ... if value does not contain '/'.
 		l.scan()
@@ -419,7 +450,7 @@ func (l *lex) parsePrim() (*Pred, error) {
 		if strings.ContainsRune(v2, '/') {
 			nm = "path"
 		}
-		return &Pred{op: op(t), name: nm, value: v2}, nil
+		return newOp(t, nm, v2), nil
 	case t == tName:
 		_, v1, _ := l.scan()
 		if v1 == "d" || v1 == "-" || v1 == "c" {
@@ -439,7 +470,10 @@ func (l *lex) parsePrim() (*Pred, error) {
 		if err != nil || t2.class() != cName {
 			return nil, errors.New("name expected")
 		}
-		return &Pred{op: op(x), name: v1, value: v2}, nil
+		if a, ok := aliases[v1]; ok {
+			v1 = a
+		}
+		return newOp(x, v1, v2), nil
 	case t.class() == cUn:
 		l.scan()
 		arg, err := l.parsePrim()
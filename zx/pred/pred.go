@@ -21,12 +21,33 @@
 		`~~regexp`	idem for attrs path (val == /...) or name (val != /...)
 		`attr≈"regexp"	true if dir[attr] matches "regexp" (not globbing)
 		`≈regexp`	idem for attrs path (val == /...) or name (val != /...)
+		`attr!~"expr"	true if dir[attr] does not match "expr" (globbing)
+		`!~expr`		idem for attrs path (val == /...) or name (val != /...)
+		`attr!~~"regexp"	true if dir[attr] does not match "regexp" (also `≉`)
 		`attr>value`	true if dir.Int64(attr) >= value
 				// you can use also >=, <, and <=, ≤, ≥
+		`data~~"regexp"	true if the file data matches "regexp" (see Pred.SetData)
 		`prune`		false, and indicates that the tree can be pruned
 	The predefined attribute "depth" may be used to indicate the depth of the
 	Dir evaluted.
 
+	Numeric values may have a k, m, g, or t suffix (either case) to multiply
+	them by 1024, 1024², and so on, as in `size>10M`.
+
+	For time attributes (those with names ending in "time", like mtime),
+	the value may also be an age, as in 10s, 30m, 4h, 3d, 2w, or 1y,
+	and then the age of the file is compared, or a date, as in 2006-01-02 or
+	'2006-01-02 15:04:05', and then the time is compared with the date.
+	That is, `mtime<2w` is true for files modified less than two weeks ago,
+	and `mtime<2026-01-02` for those modified before that date.
+
+	The attributes user, group, and writer may be used for uid, gid, and wuid,
+	as in `user=nemo` or `group!=sys`.
+
+	The pseudo-attribute "data" refers to the file data, only for
+	regular files not larger than MaxData and when the tree evaluating the
+	predicate supports that. It's false otherwise.
+
 		n	(where n is an int) is understood as `depth<=n`
 		d	is understood as `type=d`
 		-	is understood as `type=-`
@@ -69,6 +90,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 /*
@@ -100,8 +123,14 @@ const (
 
 var (
 	debug bool
+
+	// Max size for files whose data is matched by "data" terms.
+	MaxData int64 = 16 * 1024 * 1024
 )
 
+// Function used to read the data of files for "data" terms.
+type DataFn func(d zx.Dir) ([]byte, error)
+
 // A compiled predicate.
 struct Pred {
 	op    op // operation
@@ -109,6 +138,7 @@ struct Pred {
 	value string
 	args  []*Pred // for Or and And
 	re    *sre.ReProg
+	data  DataFn
 }
 
 // Compile a predicate from a string representation.
@@ -167,6 +197,117 @@ func pathMatch(exp, p string) (value, pruned bool, err error) {
 	return len(pels) == len(els), false, nil
 }
 
+// Make p read the data for the file at a dir using fn, to evaluate
+// "data" terms.
+func (p *Pred) SetData(fn DataFn) {
+	if p == nil {
+		return
+	}
+	p.data = fn
+	for _, a := range p.args {
+		a.SetData(fn)
+	}
+}
+
+// Return a copy of p that reads data using fn, leaving p as it is.
+func (p *Pred) withData(fn DataFn) *Pred {
+	if p == nil {
+		return nil
+	}
+	np := *p
+	np.data = fn
+	np.args = make([]*Pred, len(p.args))
+	for i, a := range p.args {
+		np.args[i] = a.withData(fn)
+	}
+	return &np
+}
+
+// Data for the file at e, if it's a regular file that can be read.
+func (p *Pred) dataOf(e zx.Dir) (string, bool) {
+	if p.data == nil || e["type"] != "-" || e.Size() > MaxData {
+		return "", false
+	}
+	dat, err := p.data(e)
+	if err != nil {
+		return "", false
+	}
+	return string(dat), true
+}
+
+func isTime(name string) bool {
+	return strings.HasSuffix(name, "time")
+}
+
+var ageUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+// Parse an age as in 10s, 30m, 4h, 3d, 2w, or 1y.
+func parseAge(v string) (time.Duration, bool) {
+	n := len(v)
+	if n < 2 {
+		return 0, false
+	}
+	u, ok := ageUnits[v[n-1]]
+	if !ok {
+		return 0, false
+	}
+	x, err := strconv.ParseFloat(v[:n-1], 64)
+	if err != nil || x < 0 {
+		return 0, false
+	}
+	return time.Duration(x * float64(u)), true
+}
+
+var dateFmts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+}
+
+// Parse a date in the local time zone.
+func parseDate(v string) (time.Time, bool) {
+	for _, f := range dateFmts {
+		if t, err := time.ParseInLocation(f, v, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parse a number with an optional k, m, g, or t suffix.
+func parseSize(v string) (int64, error) {
+	mul := int64(1)
+	if n := len(v); n > 1 {
+		switch v[n-1] {
+		case 'k', 'K':
+			mul = 1024
+		case 'm', 'M':
+			mul = 1024 * 1024
+		case 'g', 'G':
+			mul = 1024 * 1024 * 1024
+		case 't', 'T':
+			mul = 1024 * 1024 * 1024 * 1024
+		}
+		if mul > 1 {
+			v = v[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 0, 64)
+	if err != nil {
+		return 0, errors.New("not a number")
+	}
+	return n * mul, nil
+}
+
 // Like Pred.EvalAt, but useful when you want to specify the predicate
 // using a string.
 func EvalStr(e zx.Dir, p string, depth int) (value, pruned bool, err error) {
@@ -235,9 +376,14 @@ func (p *Pred) EvalAt(e zx.Dir, lvl int) (value, pruned bool, err error) {
 				return false, false, err
 			}
 		}
-		n2, err := strconv.ParseInt(p.value, 0, 64)
-		if err != nil {
-			err = errors.New("not a number")
+		var n2 int64
+		if age, ok := parseAge(p.value); ok && isTime(p.name) {
+			// compare the age instead
+			n1 = int64(time.Since(time.Unix(0, n1)))
+			n2 = int64(age)
+		} else if t, ok := parseDate(p.value); ok && isTime(p.name) {
+			n2 = t.UnixNano()
+		} else if n2, err = parseSize(p.value); err != nil {
 			return false, false, err
 		}
 		toodeep := false
@@ -274,6 +420,9 @@ func (p *Pred) EvalAt(e zx.Dir, lvl int) (value, pruned bool, err error) {
 			nm = "name"
 		}
 		n, ok := e[nm]
+		if nm == "data" {
+			n, ok = p.dataOf(e)
+		}
 		if !ok {
 			return false, false, nil
 		}
@@ -322,7 +471,18 @@ func (p *Pred) EvalAt(e zx.Dir, lvl int) (value, pruned bool, err error) {
 // indicated). Found entries are sent through the given channel,
 // which is closed only upon errors.
 // This is useful to implement ns.Find when writting services.
+// Unless set, "data" terms read the data from fs, using a copy of p,
+// so that p can be used for other trees at the same time.
 func (p *Pred) FindAt(fs zx.Getter, d zx.Dir, c chan<- zx.Dir, lvl int) {
+	if p != nil && p.data == nil {
+		p = p.withData(func(d zx.Dir) ([]byte, error) {
+			return zx.GetAll(fs, d["path"])
+		})
+	}
+	p.findAt(fs, d, c, lvl)
+}
+
+func (p *Pred) findAt(fs zx.Getter, d zx.Dir, c chan<- zx.Dir, lvl int) {
 	match, pruned, err := p.EvalAt(d, lvl)
 	if err != nil {
 		close(c, err)
@@ -357,7 +517,7 @@ func (p *Pred) FindAt(fs zx.Getter, d zx.Dir, c chan<- zx.Dir, lvl int) {
 		if cd["rm"] != "" {
 			continue
 		}
-		p.FindAt(fs, cd, c, lvl+1)
+		p.findAt(fs, cd, c, lvl+1)
 	}
 }
 
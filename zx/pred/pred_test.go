@@ -5,6 +5,7 @@ import (
 	"clive/zx"
 	"path"
 	"testing"
+	"time"
 )
 
 /*
@@ -42,6 +43,13 @@ var preds = []top{
 	{"path~*.c", false, "path ~ *.c"},
 	{"prune", false, "prune"},
 	{"true", false, "true"},
+	{"size>10M", false, "size > 10M"},
+	{"mtime<2w", false, "mtime < 2w"},
+	{"name!~*.o", false, "!name ~ *.o"},
+	{"!~*.o", false, "!name ~ *.o"},
+	{"path!~~x.*", false, "!path ~~ x.*"},
+	{"user=nemo&group!=sys", false, "uid = nemo & gid != sys"},
+	{"data~~'hel+o'", false, "data ~~ hel+o"},
 }
 
 func TestPred(t *testing.T) {
@@ -175,6 +183,126 @@ func TestMatch(t *testing.T) {
 	}
 }
 
+func TestUnits(t *testing.T) {
+	debug = testing.Verbose()
+	d := zx.Dir{
+		"path": "/a/b.o",
+		"name": "b.o",
+		"type": "-",
+		"size": "20971520",
+		"uid":  "nemo",
+		"gid":  "sys",
+	}
+	d.SetTime("mtime", time.Now().Add(-24*time.Hour))
+	preds := []mTest{
+		mTest{`size>10M`, "", true, false},
+		mTest{`size>1g`, "", false, false},
+		mTest{`size<=20m`, "", true, false},
+		mTest{`mtime<2w`, "", true, false},
+		mTest{`mtime>2d`, "", false, false},
+		mTest{`mtime>12h`, "", true, false},
+		mTest{`mtime>2000-01-02`, "", true, false},
+		mTest{`mtime<'2000-01-02 10:00'`, "", false, false},
+		mTest{`user=nemo`, "", true, false},
+		mTest{`group!=sys`, "", false, false},
+		mTest{`name!~*.o`, "", false, true},
+		mTest{`name!~*.c`, "", true, false},
+		mTest{`!~~b\.o`, "", false, true},
+	}
+	for _, pr := range preds {
+		p, err := New(pr.pred)
+		if err != nil {
+			t.Fatalf("parse %s", err)
+		}
+		m, prune, err := p.EvalAt(d, 0)
+		t.Logf("%s: match %v prune %v sts %v", p, m, prune, err)
+		if err != nil || m != pr.matches || prune != pr.prunes {
+			t.Fatalf("wrong result for %s", pr.pred)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	debug = testing.Verbose()
+	d := zx.Dir{
+		"path": "/a/b",
+		"name": "b",
+		"type": "-",
+		"size": "11",
+	}
+	preds := []mTest{
+		mTest{`data~~wor.d`, "", true, false},
+		mTest{`data~~bye`, "", false, false},
+		mTest{`data!~~bye`, "", true, false},
+		mTest{`type=-&data≈hel+o`, "", true, false},
+	}
+	p, err := New(preds[0].pred)
+	if err != nil {
+		t.Fatalf("parse %s", err)
+	}
+	if m, _, _ := p.EvalAt(d, 0); m {
+		t.Fatalf("matched without data")
+	}
+	for _, pr := range preds {
+		p, err := New(pr.pred)
+		if err != nil {
+			t.Fatalf("parse %s", err)
+		}
+		p.SetData(func(d zx.Dir) ([]byte, error) {
+			return []byte("hello world"), nil
+		})
+		m, prune, err := p.EvalAt(d, 0)
+		t.Logf("%s: match %v prune %v sts %v", p, m, prune, err)
+		if err != nil || m != pr.matches || prune != pr.prunes {
+			t.Fatalf("wrong result for %s", pr.pred)
+		}
+	}
+}
+
+// A tree with a single file, /a/b, with the given data.
+struct dataFs {
+	dat string
+}
+
+func (fs dataFs) Stat(p string) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	c <- zx.Dir{"path": "/a/b", "name": "b", "type": "-", "size": "11"}
+	close(c)
+	return c
+}
+
+func (fs dataFs) Get(p string, off, count int64) <-chan []byte {
+	c := make(chan []byte, 1)
+	c <- []byte(fs.dat)
+	close(c)
+	return c
+}
+
+func TestFindData(t *testing.T) {
+	p, err := New(`data~~wor.d`)
+	if err != nil {
+		t.Fatalf("parse %s", err)
+	}
+	d := zx.Dir{"path": "/a/b", "name": "b", "type": "-", "size": "11"}
+	// FindAt must not bind p to the first tree it's used for.
+	for _, fs := range []dataFs{{"hello world"}, {"hello there"}, {"hello world"}} {
+		c := make(chan zx.Dir, 1)
+		p.FindAt(fs, d, c, 0)
+		close(c)
+		n := 0
+		for range c {
+			n++
+		}
+		t.Logf("%s: %d found", fs.dat, n)
+		if (n == 1) != (fs.dat == "hello world") {
+			t.Fatalf("wrong result for %q", fs.dat)
+		}
+	}
+	if p.data != nil {
+		t.Fatalf("FindAt changed the pred")
+	}
+}
+
 func BenchmarkNew(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		id := i % len(preds)
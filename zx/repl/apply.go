@@ -227,6 +227,9 @@ func (db *DB) applyAdd(c Chg, rdb *DB) error {
 	return cerror(fc)
 }
 
+// Files are updated with zx.Resume, so that data already in the
+// target (eg. from a previous, interrupted, apply or because the file
+// only grew) is not sent again.
 func (db *DB) applyData(c Chg, rdb *DB) error {
 	fs := rdb.Fs
 	rpath := rdb.rpath
@@ -239,18 +242,26 @@ func (db *DB) applyData(c Chg, rdb *DB) error {
 		return errors.New("fs can't put")
 	}
 	db.Dprintf("data %s\n", c.D.Fmt())
-	dc := gfs.Get(fpath.Join(rpath, c.D["path"]), 0, zx.All)
-	pc := pfs.Put(fpath.Join(db.rpath, c.D["path"]), c.D, 0, dc)
-	rd := <-pc
-	if rd == nil {
-		return cerror(pc)
+	var rd zx.Dir
+	var err error
+	from, to := fpath.Join(rpath, c.D["path"]), fpath.Join(db.rpath, c.D["path"])
+	if c.D["type"] == "-" {
+		rd, err = zx.Resume(fs, from, db.Fs, to, c.D)
+	} else {
+		dc := gfs.Get(from, 0, zx.All)
+		pc := pfs.Put(to, c.D, 0, dc)
+		rd = <-pc
+		err = cerror(pc)
+	}
+	if rd == nil || err != nil {
+		return err
 	}
 	for k, v := range rd {
 		if k != "path" && k != "name" {
 			c.D[k] = v
 		}
 	}
-	err := db.Add(c.D)
+	err = db.Add(c.D)
 	if err == nil {
 		rdb.Add(c.D)
 	}
@@ -0,0 +1,128 @@
+package rzx
+
+import (
+	"clive/zx"
+	"clive/zx/pred"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Audit records are written for requests that change the served trees,
+	one line per request, with the time, the operation, the user, the client
+	address, the tree, the paths involved, and the result, as in
+
+		2026-10-16 10:20:30.123 put nemo tcp!10.0.0.1!4242 main "/a/b" ok
+		2026-10-16 10:20:31.456 move nemo tcp!10.0.0.1!4242 main "/a/b" "/c" err "..."
+
+	The filter is a predicate (see clive/zx/pred) evaluated on a dir
+	with the attributes op, uid, addr, fsys, path, to, and err (not set
+	on success), and only the requests where it's true are logged.
+	For example, `op=remove|op=removeall` or `uid!=nemo&fsys=main`.
+*/
+struct auditor {
+	sync.Mutex
+	w io.Writer
+	p *pred.Pred
+}
+
+// Write audit records to an append-only zx file.
+struct auditFile {
+	fs   zx.Putter
+	path string
+}
+
+// Audit the requests that change the served trees, writing one line
+// per request to w.
+// If filter is not empty, only the requests for which it's true are
+// audited. A nil w disables auditing.
+func (s *Server) Audit(w io.Writer, filter string) error {
+	var p *pred.Pred
+	if filter != "" {
+		var err error
+		if p, err = pred.New(filter); err != nil {
+			return fmt.Errorf("audit: %s", err)
+		}
+	}
+	s.auditor.Lock()
+	defer s.auditor.Unlock()
+	s.auditor.w = w
+	s.auditor.p = p
+	return nil
+}
+
+// Return a writer for audit records appending each one to the file
+// at path in fs, which is created if needed.
+func AuditFile(fs zx.Putter, path string) io.Writer {
+	return &auditFile{fs: fs, path: path}
+}
+
+func (af *auditFile) Write(b []byte) (int, error) {
+	dc := make(chan []byte, 1)
+	dc <- b
+	close(dc)
+	rc := af.fs.Put(af.path, zx.Dir{"type": "F", "mode": "0600"}, -1, dc)
+	<-rc
+	if err := cerror(rc); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (op MsgId) mutates() bool {
+	switch op {
+	case Tput, Tmove, Tlink, Tsymlink, Tcopy, Tremove, Tremoveall, Twstat:
+		return true
+	}
+	return false
+}
+
+func (s *Server) audit(m *Msg, err error) {
+	a := s.auditor
+	a.Lock()
+	defer a.Unlock()
+	if a.w == nil {
+		return
+	}
+	addr := ""
+	if s.mx != nil {
+		addr = s.mx.Tag
+	}
+	d := zx.Dir{
+		"op":   strings.ToLower(m.Op.String()[1:]),
+		"uid":  s.uid,
+		"addr": addr,
+		"fsys": m.Fsys,
+		"path": m.Path,
+	}
+	if m.To != "" {
+		d["to"] = m.To
+	}
+	if err != nil {
+		d["err"] = err.Error()
+	}
+	if a.p != nil {
+		if ok, _, perr := a.p.EvalAt(d, 0); perr != nil || !ok {
+			return
+		}
+	}
+	ln := fmt.Sprintf("%s %s %s %s %s %q",
+		time.Now().Format("2006-01-02 15:04:05.000"),
+		d["op"], d["uid"], d["addr"], d["fsys"], d["path"])
+	if m.To != "" {
+		if m.ToFs != "" && m.ToFs != m.Fsys {
+			ln += fmt.Sprintf(" %s!%q", m.ToFs, m.To)
+		} else {
+			ln += fmt.Sprintf(" %q", m.To)
+		}
+	}
+	if err != nil {
+		ln += fmt.Sprintf(" err %q\n", err)
+	} else {
+		ln += " ok\n"
+	}
+	io.WriteString(a.w, ln)
+}
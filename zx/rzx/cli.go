@@ -1,16 +1,20 @@
 package rzx
 
 import (
+	"bytes"
 	"clive/ch"
 	"clive/dbg"
 	"clive/net"
 	"clive/net/auth"
+	"clive/trace"
 	"clive/zx"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Remote zx client
@@ -27,7 +31,12 @@ struct Fs {
 	m          *ch.Mux
 	closed     bool // mux is gone, can redial
 	closewc    chan bool
-	sync.Mutex // for redials
+	zip        string // compression used by the server, if any
+	nozip      bool   // don't ask for compression
+	check      bool   // ask for hashes on chunks in gets/puts
+	checked    bool   // and the server agreed
+	stripes    int    // conversations for striped gets
+	redialLk   sync.Mutex // for redials
 }
 
 type ddir zx.Dir
@@ -37,9 +46,32 @@ func (d ddir) String() string {
 }
 
 var (
+	// If false, Dial does not ask the server to compress the
+	// connection. It never does for unix sockets.
+	Compress = true
+
+	// If true, Dial asks the server to send a hash after each
+	// chunk of data in gets and to check those we send in puts,
+	// to detect data corrupted on the way (see zx.ChunkSum).
+	Check = false
+
+	// If greater than 1, gets for files of at least StripeMin bytes
+	// are made by fetching StripeSize ranges of the file using this
+	// many concurrent conversations, and the data is sent in order.
+	// This helps on links with high bandwidth and latency.
+	Stripes = 0
+
+	StripeSize int64 = 1024 * 1024
+	StripeMin  int64 = 8 * 1024 * 1024
+
 	dials   = map[string]*Fs{}
 	dialslk sync.Mutex
-	_fs     zx.FullFs = &Fs{}
+	_fs     zx.FullFs      = &Fs{}
+	_fs2    zx.Symlinker   = &Fs{}
+	_fs3    zx.Copier      = &Fs{}
+	_fs4    zx.Grepper     = &Fs{}
+	_fs5    zx.Summer      = &Fs{}
+	_fs6    zx.MultiStater = &Fs{}
 )
 
 func (fs *Fs) String() string {
@@ -111,6 +143,15 @@ func Dial(addr string, tlscfg ...*tls.Config) (*Fs, error) {
 	fs.Tag = "rfs"
 	fs.Flags.Add("debug", &fs.Debug)
 	fs.Flags.Add("verbdebug", &fs.Verb)
+	fs.Flags.Add("nocompress", &fs.nozip)
+	fs.Flags.AddRO("compression", &fs.zip)
+	fs.Flags.Add("check", &fs.check)
+	fs.Flags.AddRO("checked", &fs.checked)
+	fs.Flags.Add("stripes", &fs.stripes)
+	fs.stripes = Stripes
+	nw, _, _ := net.ParseAddr(addr)
+	fs.nozip = !Compress || nw == "unix"
+	fs.check = Check
 	if err := fs.Redial(); err != nil {
 		return nil, err
 	}
@@ -124,8 +165,8 @@ func Dial(addr string, tlscfg ...*tls.Config) (*Fs, error) {
 // the caller might just redial the file system to try to continue
 // its operation, or Close() might be called instead.
 func (fs *Fs) Redial() error {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.redialLk.Lock()
+	defer fs.redialLk.Unlock()
 	if !fs.closed {
 		if fs.m != nil {
 			fs.m.Close()
@@ -135,26 +176,12 @@ func (fs *Fs) Redial() error {
 		fs.closed = true
 		fs.closewc = make(chan bool)
 	}
-	m, err := net.MuxDial(fs.addr, fs.tc)
-	if err != nil {
-		return err
-	}
-	call := m.Rpc()
-	ai, err := auth.AtClient(call, "", "zx")
-	if err != nil {
-		if !strings.Contains(err.Error(), "auth disabled") {
-			m.Close()
-			return fmt.Errorf("%s: %s", fs.addr, err)
-		}
+	m, ai, err := fs.dial(true)
+	if err == errNegHup {
 		dbg.Warn("%s: %s", fs.addr, err)
+		m, ai, err = fs.dial(false)
 	}
-	fs.ai = ai
-	fs.m = m
-	err = fs.getTrees()
-	fs.ai = nil
-	fs.m = nil
 	if err != nil {
-		m.Close()
 		return err
 	}
 	if !fs.trees[fs.fsys] {
@@ -170,9 +197,9 @@ func (fs *Fs) Redial() error {
 	closewc := fs.closewc
 	go func() {
 		<-m.Hup
-		fs.Lock()
+		fs.redialLk.Lock()
 		fs.closed = true
-		fs.Unlock()
+		fs.redialLk.Unlock()
 		dialslk.Lock()
 		delete(dials, fs.raddr)
 		dialslk.Unlock()
@@ -181,13 +208,64 @@ func (fs *Fs) Redial() error {
 	return nil
 }
 
+// Old servers hang up when asked for things they don't know,
+// and are dialed again without asking for compression or checks.
+var errNegHup = errors.New("server hung up when negotiating options")
+
+// Dial and authenticate, retrieve the trees and, if neg is set,
+// negotiate compression and checks as asked for.
+// Called with fs locked.
+func (fs *Fs) dial(neg bool) (*ch.Mux, *auth.Info, error) {
+	m, err := net.MuxDial(fs.addr, fs.tc)
+	if err != nil {
+		return nil, nil, err
+	}
+	call := m.Rpc()
+	ai, err := auth.AtClient(call, "", "zx")
+	if err != nil {
+		if !strings.Contains(err.Error(), "auth disabled") {
+			m.Close()
+			return nil, nil, fmt.Errorf("%s: %s", fs.addr, err)
+		}
+		dbg.Warn("%s: %s", fs.addr, err)
+	}
+	fs.ai = ai
+	fs.m = m
+	fs.zip, fs.checked = "", false
+	err = fs.getTrees()
+	if err == nil && neg && !fs.nozip {
+		if err = fs.getZip(); err != nil {
+			fs.Dprintf("compress: %s\n", err)
+			err = errNegHup
+		}
+	}
+	if err == nil && neg && fs.check {
+		if err = fs.getCheck(); err != nil {
+			fs.Dprintf("check: %s\n", err)
+			err = errNegHup
+		}
+	}
+	fs.ai = nil
+	fs.m = nil
+	if err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	return m, ai, nil
+}
+
 func (fs *Fs) Close() error {
 	fs.m.Close()
 	return nil
 }
 
+// Start an rpc carrying the trace context of the caller, if any.
+func (fs *Fs) rpc() ch.Conn {
+	return fs.m.TracedRpc(trace.Current().String())
+}
+
 func (fs *Fs) getTrees() error {
-	c := fs.m.Rpc()
+	c := fs.rpc()
 	m := &Msg{Op: Ttrees, Fsys: "main"}
 	fs.Dprintf("->%s\n", m)
 	if ok := c.Out <- m; !ok {
@@ -210,6 +288,67 @@ func (fs *Fs) getTrees() error {
 	return cerror(c.In)
 }
 
+// Ask the server to compress what it sends, with the first algorithm
+// it knows from those we know, and compress what we send in the same way.
+func (fs *Fs) getZip() error {
+	c := fs.rpc()
+	m := &Msg{Op: Tcompress, Fsys: "main", Paths: ch.ZipAlgs}
+	fs.Dprintf("->%s\n", m)
+	if ok := c.Out <- m; !ok {
+		err := cerror(c.Out)
+		close(c.In, err)
+		return err
+	}
+	close(c.Out)
+	alg := ""
+	for m := range c.In {
+		fs.Dprintf("<-%v\n", m)
+		if s, ok := m.(string); ok {
+			alg = s
+		}
+	}
+	if err := cerror(c.In); err != nil {
+		return err
+	}
+	if alg == "" {
+		return nil
+	}
+	if err := fs.m.Compress(alg); err != nil {
+		return err
+	}
+	fs.zip = alg
+	return nil
+}
+
+// Ask the server to send hashes for chunks in gets and to check
+// those we send in puts.
+func (fs *Fs) getCheck() error {
+	c := fs.rpc()
+	m := &Msg{Op: Tcheck, Fsys: "main"}
+	fs.Dprintf("->%s\n", m)
+	if ok := c.Out <- m; !ok {
+		err := cerror(c.Out)
+		close(c.In, err)
+		return err
+	}
+	close(c.Out)
+	alg := ""
+	for m := range c.In {
+		fs.Dprintf("<-%v\n", m)
+		if s, ok := m.(string); ok {
+			alg = s
+		}
+	}
+	if err := cerror(c.In); err != nil {
+		return err
+	}
+	if alg != "sha1" {
+		return fmt.Errorf("unknown hash '%s'", alg)
+	}
+	fs.checked = true
+	return nil
+}
+
 func (fs *Fs) Trees() []string {
 	ts := []string{}
 	for t := range fs.trees {
@@ -232,7 +371,7 @@ func (fs *Fs) Fsys(name string) (*Fs, error) {
 func (fs *Fs) dircall(p string, m *Msg) chan zx.Dir {
 	rc := make(chan zx.Dir, 1)
 	go func() {
-		c := fs.m.Rpc()
+		c := fs.rpc()
 		fs.Dprintf("->%s\n", m)
 		if ok := c.Out <- m; !ok {
 			err := cerror(c.Out)
@@ -263,6 +402,46 @@ func (fs *Fs) Stat(p string) <-chan zx.Dir {
 	return fs.dircall(p, m)
 }
 
+// Stat the given paths in a single request and send their entries
+// in the same order.
+// The entry for a path that can't be stated has just the "path"
+// and "err" attributes.
+func (fs *Fs) Stats(paths ...string) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		m := &Msg{Op: Tstats, Fsys: fs.fsys, Paths: paths}
+		c := fs.rpc()
+		fs.Dprintf("->%s\n", m)
+		if ok := c.Out <- m; !ok {
+			err := cerror(c.Out)
+			close(c.In, err)
+			close(rc, err)
+			return
+		}
+		close(c.Out)
+		for m := range c.In {
+			d, ok := m.(zx.Dir)
+			if !ok {
+				err := ErrBadMsg
+				close(c.In, err)
+				close(rc, err)
+				return
+			}
+			fs.Dprintf("<-%s\n", ddir(d))
+			if ok := rc <- d; !ok {
+				close(c.In, cerror(rc))
+				return
+			}
+		}
+		err := cerror(c.In)
+		if err != nil {
+			fs.Dprintf("<-%s\n", err)
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
 func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
 	m := &Msg{Op: Twstat, Fsys: fs.fsys, Path: p, D: d.Dup()}
 	return fs.dircall(p, m)
@@ -271,7 +450,7 @@ func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
 func (fs *Fs) errcall(m *Msg) chan error {
 	rc := make(chan error, 1)
 	go func() {
-		c := fs.m.Rpc()
+		c := fs.rpc()
 		fs.Dprintf("->%s\n", m)
 		if ok := c.Out <- m; !ok {
 			err := cerror(c.Out)
@@ -293,6 +472,16 @@ func (fs *Fs) errcall(m *Msg) chan error {
 	return rc
 }
 
+func (fs *Fs) Lock(p, owner string, tmout time.Duration) <-chan zx.Dir {
+	m := &Msg{Op: Tlock, Fsys: fs.fsys, Path: p, Owner: owner, Tmout: tmout}
+	return fs.dircall(p, m)
+}
+
+func (fs *Fs) Unlock(p, owner string) <-chan error {
+	m := &Msg{Op: Tunlock, Fsys: fs.fsys, Path: p, Owner: owner}
+	return fs.errcall(m)
+}
+
 func (fs *Fs) Remove(p string) <-chan error {
 	m := &Msg{Op: Tremove, Fsys: fs.fsys, Path: p}
 	return fs.errcall(m)
@@ -313,10 +502,135 @@ func (fs *Fs) Link(oldp, newp string) <-chan error {
 	return fs.errcall(m)
 }
 
+func (fs *Fs) Copy(from, to string) <-chan error {
+	return fs.CopyTo(from, fs, to)
+}
+
+// Copy the file or directory at from to be at to in tfs.
+// If tfs is a tree in the same server, the server makes the copy;
+// otherwise, or if the server does not know how to copy,
+// the data is retrieved and put into tfs by the client.
+func (fs *Fs) CopyTo(from string, tfs *Fs, to string) <-chan error {
+	if tfs.addr != fs.addr || !fs.trees[tfs.fsys] {
+		return fs.copyTo(from, tfs, to)
+	}
+	m := &Msg{Op: Tcopy, Fsys: fs.fsys, Path: from, To: to}
+	if tfs.fsys != fs.fsys {
+		m.ToFs = tfs.fsys
+	}
+	rc := make(chan error, 1)
+	go func() {
+		err := <-fs.errcall(m)
+		if err != nil && strings.Contains(err.Error(), "unknown msg") {
+			fs.Dprintf("copy: old server, copying here\n")
+			err = <-fs.copyTo(from, tfs, to)
+		}
+		rc <- err
+		close(rc, err)
+	}()
+	return rc
+}
+
+func (fs *Fs) copyTo(from string, tfs *Fs, to string) <-chan error {
+	rc := make(chan error, 1)
+	err := zx.CopyTo(fs, from, tfs, to)
+	rc <- err
+	close(rc, err)
+	return rc
+}
+
+func (fs *Fs) Symlink(target, newp string) <-chan error {
+	m := &Msg{Op: Tsymlink, Fsys: fs.fsys, Path: newp, To: target}
+	return fs.errcall(m)
+}
+
+func (fs *Fs) Lstat(p string) <-chan zx.Dir {
+	m := &Msg{Op: Tlstat, Fsys: fs.fsys, Path: p}
+	return fs.dircall(p, m)
+}
+
 func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
+	n := fs.stripes
+	if n <= 1 || off < 0 {
+		return fs.get(p, off, count)
+	}
+	d, err := zx.Stat(fs, p)
+	if err != nil || d["type"] != "-" {
+		return fs.get(p, off, count)
+	}
+	end := d.Size()
+	if count >= 0 && off+count < end {
+		end = off + count
+	}
+	if end-off < StripeMin {
+		return fs.get(p, off, count)
+	}
+	rc := make(chan []byte, 1)
+	go fs.stripedGet(p, off, end, n, rc)
+	return rc
+}
+
+// Get the data for [off, end) from p in ranges, fetching up to n
+// at a time, and send it in order to rc.
+func (fs *Fs) stripedGet(p string, off, end int64, n int, rc chan<- []byte) {
+	nparts := (end - off + StripeSize - 1) / StripeSize
+	parts := make([]chan []byte, nparts)
+	for i := range parts {
+		parts[i] = make(chan []byte, 1)
+	}
+	fs.Dprintf("get %s: %d ranges, %d at a time\n", p, nparts, n)
+	fetch := func(i int) {
+		o := off + int64(i)*StripeSize
+		cnt := StripeSize
+		if o+cnt > end {
+			cnt = end - o
+		}
+		var buf bytes.Buffer
+		gc := fs.get(p, o, cnt)
+		for x := range gc {
+			buf.Write(x)
+		}
+		if err := cerror(gc); err != nil {
+			close(parts[i], err)
+			return
+		}
+		parts[i] <- buf.Bytes()
+		close(parts[i])
+	}
+	// at most n ranges are being fetched or waiting to be sent.
+	slots := make(chan bool, n)
+	go func() {
+		for i := range parts {
+			if ok := slots <- true; !ok {
+				return
+			}
+			go fetch(i)
+		}
+	}()
+	for _, pc := range parts {
+		dat := <-pc
+		err := cerror(pc)
+		<-slots
+		if err == nil && len(dat) > 0 {
+			if ok := rc <- dat; !ok {
+				err = cerror(rc)
+			}
+		}
+		if err != nil {
+			close(slots, err)
+			close(rc, err)
+			return
+		}
+	}
+	close(slots)
+	close(rc)
+}
+
+// Get using a single conversation
+func (fs *Fs) get(p string, off, count int64) <-chan []byte {
 	rc := make(chan []byte, 1)
 	go func() {
-		c := fs.m.Rpc()
+		c := fs.rpc()
 		m := &Msg{Op: Tget, Fsys: fs.fsys, Path: p, Off: off, Count: count}
 		fs.Dprintf("->%s\n", m)
 		if ok := c.Out <- m; !ok {
@@ -325,11 +639,30 @@ func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
 			return
 		}
 		close(c.Out)
+		var last []byte
+		pending := false // last is waiting for its hash
 		for m := range c.In {
+			if s, ok := m.(string); ok && fs.checked {
+				if !pending || zx.ChunkSum(last) != s {
+					err := errCorrupt(p)
+					close(c.In, err)
+					close(rc, err)
+					break
+				}
+				pending = false
+				if ok := rc <- last; !ok {
+					close(c.In, cerror(rc))
+					break
+				}
+				continue
+			}
 			m, ok := m.([]byte)
-			if !ok {
+			if !ok || pending {
 				fs.Dprintf("<- %v\n", m)
 				err := ErrBadMsg
+				if pending {
+					err = errCorrupt(p)
+				}
 				close(c.In, err)
 				close(rc, err)
 				break
@@ -337,6 +670,10 @@ func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
 				if fs.Verb {
 					fs.Dprintf("<- [%d]bytes\n", len(m))
 				}
+				if fs.checked {
+					last, pending = m, true
+					continue
+				}
 				if ok := rc <- m; !ok {
 					close(c.In, cerror(rc))
 					break
@@ -344,6 +681,9 @@ func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
 			}
 		}
 		err := cerror(c.In)
+		if err == nil && pending {
+			err = errCorrupt(p)
+		}
 		if err != nil {
 			fs.Dprintf("<-%s\n", err)
 		}
@@ -356,7 +696,7 @@ func (fs *Fs) Put(p string, d zx.Dir, off int64, dc <-chan []byte) <-chan zx.Dir
 	rc := make(chan zx.Dir, 1)
 	d = d.Dup()
 	go func() {
-		c := fs.m.Rpc()
+		c := fs.rpc()
 		if dc == nil || d["type"] == "d" {
 			dc = make(chan []byte)
 			close(dc)
@@ -375,7 +715,11 @@ func (fs *Fs) Put(p string, d zx.Dir, off int64, dc <-chan []byte) <-chan zx.Dir
 				if fs.Verb {
 					fs.Dprintf("-> [%d]bytes\n", len(m))
 				}
-				if ok := c.Out <- m; !ok {
+				ok := c.Out <- m
+				if ok && fs.checked {
+					ok = c.Out <- zx.ChunkSum(m)
+				}
+				if !ok {
 					err := cerror(c.Out)
 					close(dc, err)
 					close(c.In, err)
@@ -418,7 +762,7 @@ func (fs *Fs) Find(p, fpred, spref, dpref string, depth0 int) <-chan zx.Dir {
 		m := &Msg{Op: Tfind, Fsys: fs.fsys, Path: p,
 			Pred: fpred, Spref: spref, Dpref: dpref, Depth: depth0,
 		}
-		c := fs.m.Rpc()
+		c := fs.rpc()
 		fs.Dprintf("->%s\n", m)
 		if ok := c.Out <- m; !ok {
 			err := cerror(c.Out)
@@ -455,7 +799,7 @@ func (fs *Fs) FindGet(p, fpred, spref, dpref string, depth0 int) <-chan face{} {
 		m := &Msg{Op: Tfindget, Fsys: fs.fsys, Path: p,
 			Pred: fpred, Spref: spref, Dpref: dpref, Depth: depth0,
 		}
-		c := fs.m.Rpc()
+		c := fs.rpc()
 		fs.Dprintf("->%s\n", m)
 		if ok := c.Out <- m; !ok {
 			err := cerror(c.Out)
@@ -473,3 +817,132 @@ func (fs *Fs) FindGet(p, fpred, spref, dpref string, depth0 int) <-chan face{} {
 	}()
 	return rc
 }
+
+// Search files in the server (see zx.Grepper).
+// If the server does not know how to do it, the files are
+// retrieved and searched here instead.
+func (fs *Fs) Grep(p, fpred, rexp string, nctx int) <-chan face{} {
+	rc := make(chan face{})
+	go func() {
+		m := &Msg{Op: Tgrep, Fsys: fs.fsys, Path: p,
+			Pred: fpred, Rexp: rexp, Nctx: nctx,
+		}
+		c := fs.rpc()
+		fs.Dprintf("->%s\n", m)
+		if ok := c.Out <- m; !ok {
+			err := cerror(c.Out)
+			close(c.In, err)
+			close(rc, err)
+			return
+		}
+		close(c.Out)
+		n := 0
+		for m := range c.In {
+			n++
+			if ok := rc <- m; !ok {
+				close(c.In, cerror(rc))
+				break
+			}
+		}
+		err := cerror(c.In)
+		if n == 0 && err != nil && strings.Contains(err.Error(), "unknown msg") {
+			fs.Dprintf("grep: old server, searching here\n")
+			lc := zx.GrepFiles(fs, p, fpred, rexp, nctx)
+			for x := range lc {
+				if ok := rc <- x; !ok {
+					close(lc, cerror(rc))
+					break
+				}
+			}
+			err = cerror(lc)
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Checksum a file in the server (see zx.Summer).
+// If the server does not know how to do it, the file is
+// retrieved and checksummed here instead.
+func (fs *Fs) Sums(p string, blksz int64) <-chan string {
+	rc := make(chan string)
+	go func() {
+		m := &Msg{Op: Tsums, Fsys: fs.fsys, Path: p, Count: blksz}
+		c := fs.rpc()
+		fs.Dprintf("->%s\n", m)
+		if ok := c.Out <- m; !ok {
+			err := cerror(c.Out)
+			close(c.In, err)
+			close(rc, err)
+			return
+		}
+		close(c.Out)
+		n := 0
+		for m := range c.In {
+			n++
+			s, ok := m.(string)
+			if !ok {
+				close(c.In, ErrBadMsg)
+				break
+			}
+			if ok := rc <- s; !ok {
+				close(c.In, cerror(rc))
+				break
+			}
+		}
+		err := cerror(c.In)
+		if n == 0 && err != nil && strings.Contains(err.Error(), "unknown msg") {
+			fs.Dprintf("sums: old server, checksumming here\n")
+			lc := zx.GetSums(fs, p, blksz)
+			for s := range lc {
+				if ok := rc <- s; !ok {
+					close(lc, cerror(rc))
+					break
+				}
+			}
+			err = cerror(lc)
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Watch changes in the remote file system (see zx.Watcher).
+// Closing the returned chan stops watching once the next change
+// is received.
+func (fs *Fs) Watch(p, fpred string) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		m := &Msg{Op: Tnotify, Fsys: fs.fsys, Path: p, Pred: fpred}
+		c := fs.rpc()
+		fs.Dprintf("->%s\n", m)
+		if ok := c.Out <- m; !ok {
+			err := cerror(c.Out)
+			close(c.In, err)
+			close(rc, err)
+			return
+		}
+		// c.Out is kept open while watching
+		for m := range c.In {
+			if m, ok := m.(zx.Dir); !ok {
+				err := ErrBadMsg
+				close(c.In, err)
+				close(rc, err)
+				break
+			} else {
+				fs.Dprintf("<-%s\n", ddir(m))
+				if ok := rc <- m; !ok {
+					close(c.In, cerror(rc))
+					break
+				}
+			}
+		}
+		err := cerror(c.In)
+		if err != nil {
+			fs.Dprintf("<-%s\n", err)
+		}
+		close(c.Out, err)
+		close(rc, err)
+	}()
+	return rc
+}
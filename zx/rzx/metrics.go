@@ -0,0 +1,254 @@
+package rzx
+
+import (
+	"bytes"
+	"clive/zx"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency samples kept for each request type to compute percentiles.
+const nlat = 512
+
+// Counts and recent latencies for a request type
+struct opStats {
+	n, nerr int64
+	lat     [nlat]time.Duration
+	nlat    int64 // samples taken, the last nlat are in lat
+}
+
+// Metrics for the server, shared by all the clients
+struct metrics {
+	sync.Mutex
+	ops      map[string]map[MsgId]*opStats // by fsys
+	nconv    int64                         // conversations in progress
+	nconn    int64                         // clients connected so far
+	authfail int64
+	start    time.Time
+}
+
+// Trees keeping call stats (those with a *zx.Stats)
+interface counter {
+	Counts() [zx.Nstats]int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{ops: map[string]map[MsgId]*opStats{}, start: time.Now()}
+}
+
+func (m *metrics) began() {
+	m.Lock()
+	m.nconv++
+	m.Unlock()
+}
+
+func (m *metrics) done(fsys string, op MsgId, lat time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.nconv--
+	fm := m.ops[fsys]
+	if fm == nil {
+		fm = map[MsgId]*opStats{}
+		m.ops[fsys] = fm
+	}
+	st := fm[op]
+	if st == nil {
+		st = &opStats{}
+		fm[op] = st
+	}
+	st.n++
+	if err != nil {
+		st.nerr++
+	}
+	st.lat[st.nlat%nlat] = lat
+	st.nlat++
+}
+
+func (m *metrics) connected(authok bool) {
+	m.Lock()
+	if authok {
+		m.nconn++
+	} else {
+		m.authfail++
+	}
+	m.Unlock()
+}
+
+type durations []time.Duration
+
+func (ds durations) Len() int           { return len(ds) }
+func (ds durations) Less(i, j int) bool { return ds[i] < ds[j] }
+func (ds durations) Swap(i, j int)      { ds[i], ds[j] = ds[j], ds[i] }
+
+// Latency percentiles for the samples in st
+func (st *opStats) pcts(qs ...float64) []time.Duration {
+	n := st.nlat
+	if n > nlat {
+		n = nlat
+	}
+	lats := make(durations, n)
+	copy(lats, st.lat[:n])
+	sort.Sort(lats)
+	ps := make([]time.Duration, len(qs))
+	if n == 0 {
+		return ps
+	}
+	for i, q := range qs {
+		ps[i] = lats[int(q*float64(n-1))]
+	}
+	return ps
+}
+
+func opName(op MsgId) string {
+	return strings.ToLower(op.String()[1:])
+}
+
+/*
+	Return the server metrics in the Prometheus text format:
+
+		rzx_ops_total{fsys,op}	requests served
+		rzx_op_errors_total{fsys,op}	requests failed
+		rzx_op_latency_seconds{fsys,op,quantile}	for the last requests
+		rzx_conversations	requests in progress
+		rzx_clients	clients connected now
+		rzx_connections_total	clients connected so far
+		rzx_auth_failures_total	clients failing to authenticate
+		rzx_uptime_seconds
+		rzx_tree_calls_total{fsys,call}	calls made to trees with stats,
+			which for caches are those not sent to the cached tree.
+*/
+func (s *Server) Metrics() string {
+	var buf bytes.Buffer
+	m := s.metrics
+	m.Lock()
+	mfs := make([]string, 0, len(m.ops))
+	for fsys := range m.ops {
+		mfs = append(mfs, fsys)
+	}
+	sort.Strings(mfs)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	ops := func(fn func(fsys string, op MsgId, st *opStats)) {
+		for _, fsys := range mfs {
+			for op := Tmin; op < Tend; op++ {
+				if st := m.ops[fsys][op]; st != nil {
+					fn(fsys, op, st)
+				}
+			}
+		}
+	}
+	metric("rzx_ops_total", "counter", "Requests served.")
+	ops(func(fsys string, op MsgId, st *opStats) {
+		fmt.Fprintf(&buf, "rzx_ops_total{fsys=%q,op=%q} %d\n", fsys, opName(op), st.n)
+	})
+	metric("rzx_op_errors_total", "counter", "Requests failed.")
+	ops(func(fsys string, op MsgId, st *opStats) {
+		fmt.Fprintf(&buf, "rzx_op_errors_total{fsys=%q,op=%q} %d\n", fsys, opName(op), st.nerr)
+	})
+	metric("rzx_op_latency_seconds", "summary", "Latency for the last requests.")
+	qs := []float64{0.5, 0.9, 0.99}
+	ops(func(fsys string, op MsgId, st *opStats) {
+		for i, p := range st.pcts(qs...) {
+			fmt.Fprintf(&buf, "rzx_op_latency_seconds{fsys=%q,op=%q,quantile=\"%g\"} %g\n",
+				fsys, opName(op), qs[i], p.Seconds())
+		}
+	})
+	metric("rzx_conversations", "gauge", "Requests in progress.")
+	fmt.Fprintf(&buf, "rzx_conversations %d\n", m.nconv)
+	metric("rzx_connections_total", "counter", "Clients connected.")
+	fmt.Fprintf(&buf, "rzx_connections_total %d\n", m.nconn)
+	metric("rzx_auth_failures_total", "counter", "Clients failing to authenticate.")
+	fmt.Fprintf(&buf, "rzx_auth_failures_total %d\n", m.authfail)
+	metric("rzx_uptime_seconds", "gauge", "Time since the server started.")
+	fmt.Fprintf(&buf, "rzx_uptime_seconds %g\n", time.Since(m.start).Seconds())
+	m.Unlock()
+
+	metric("rzx_clients", "gauge", "Clients connected now.")
+	fmt.Fprintf(&buf, "rzx_clients %d\n", len(s.clients.list()))
+	metric("rzx_tree_calls_total", "counter", "Calls made to served trees.")
+	trs, fss := s.served()
+	for i, fs := range fss {
+		t, ok := fs.(counter)
+		if !ok {
+			continue
+		}
+		nb := t.Counts()
+		for c := zx.Call(0); c < zx.Nstats; c++ {
+			fmt.Fprintf(&buf, "rzx_tree_calls_total{fsys=%q,call=%q} %d\n", trs[i], c, nb[c])
+		}
+	}
+	return buf.String()
+}
+
+// Return the names of the served trees, sorted, and the trees.
+func (s *Server) served() ([]string, []zx.Fs) {
+	s.Lock()
+	defer s.Unlock()
+	trs := make([]string, 0, len(s.fs))
+	for fsys := range s.fs {
+		trs = append(trs, fsys)
+	}
+	sort.Strings(trs)
+	fss := make([]zx.Fs, len(trs))
+	for i, fsys := range trs {
+		fss[i] = s.fs[fsys]
+	}
+	return trs, fss
+}
+
+// Report if the server is running and its trees can be reached,
+// with a line per tree.
+func (s *Server) Health() (string, bool) {
+	select {
+	case <-s.endc:
+		return "server exiting\n", false
+	default:
+	}
+	trs, fss := s.served()
+	var buf bytes.Buffer
+	ok := true
+	for i, fs := range fss {
+		if _, err := zx.Stat(fs, "/"); err != nil {
+			fmt.Fprintf(&buf, "tree %s: %s\n", trs[i], err)
+			ok = false
+		} else {
+			fmt.Fprintf(&buf, "tree %s: ok\n", trs[i])
+		}
+	}
+	return buf.String(), ok
+}
+
+// Serve the metrics at http://addr/metrics and the health
+// status at http://addr/health, until the server is closed.
+// The addr is a TCP address as in ":9100".
+func (s *Server) ServeMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, s.Metrics())
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		st, ok := s.Health()
+		w.Header().Set("Content-Type", "text/plain")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprint(w, st)
+	})
+	go http.Serve(l, mux)
+	go func() {
+		<-s.endc
+		l.Close()
+	}()
+	return nil
+}
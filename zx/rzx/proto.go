@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 type MsgId byte
@@ -25,22 +26,39 @@ const (
 	Twstat
 	Tfind
 	Tfindget
+	Tnotify
+	Tlock
+	Tunlock
+	Tsymlink
+	Tlstat
+	Tcopy
+	Tstats
+	Tgrep
+	Tsums
+	Tcompress
+	Tcheck
 	Tend
 	Tmin = Ttrees
 )
 
 struct Msg {
 	Op    MsgId
-	Fsys  string // All requests
-	Path  string // All requests
-	Off   int64  // Get, Put
-	Count int64  // Get
-	D     zx.Dir // Put, Wstat
-	To    string // Move, Liink
-	Pred  string // Find, Findget
-	Spref string // Find, Findget
-	Dpref string // Find, Findget
-	Depth int    // Find, Findget
+	Fsys  string        // All requests
+	Path  string        // All requests
+	Off   int64         // Get, Put
+	Count int64         // Get, Sums (block size)
+	D     zx.Dir        // Put, Wstat
+	To    string        // Move, Link, Symlink, Copy
+	ToFs  string        // Copy (if not Fsys)
+	Pred  string        // Find, Findget, Notify, Grep
+	Spref string        // Find, Findget
+	Dpref string        // Find, Findget
+	Depth int           // Find, Findget
+	Owner string        // Lock, Unlock
+	Tmout time.Duration // Lock
+	Paths []string      // Stats, Compress (algorithms)
+	Rexp  string        // Grep
+	Nctx  int           // Grep
 }
 
 var ErrBadMsg = errors.New("bad message type")
@@ -73,6 +91,28 @@ func (o MsgId) String() string {
 		return "Tfindget"
 	case Twstat:
 		return "Twstat"
+	case Tnotify:
+		return "Tnotify"
+	case Tlock:
+		return "Tlock"
+	case Tunlock:
+		return "Tunlock"
+	case Tsymlink:
+		return "Tsymlink"
+	case Tlstat:
+		return "Tlstat"
+	case Tcopy:
+		return "Tcopy"
+	case Tstats:
+		return "Tstats"
+	case Tgrep:
+		return "Tgrep"
+	case Tsums:
+		return "Tsums"
+	case Tcompress:
+		return "Tcompress"
+	case Tcheck:
+		return "Tcheck"
 	default:
 		return fmt.Sprintf("Tunknown<%d>", o)
 	}
@@ -109,7 +149,7 @@ func (m *Msg) WriteTo(w io.Writer) (n int64, err error) {
 		}
 		n += 8
 	}
-	if m.Op == Tget {
+	if m.Op == Tget || m.Op == Tsums {
 		if err = binary.Write(w, binary.LittleEndian, uint64(m.Count)); err != nil {
 			return n, err
 		}
@@ -122,14 +162,21 @@ func (m *Msg) WriteTo(w io.Writer) (n int64, err error) {
 			return n, err
 		}
 	}
-	if m.Op == Tmove || m.Op == Tlink {
+	if m.Op == Tmove || m.Op == Tlink || m.Op == Tsymlink || m.Op == Tcopy {
 		nw, err = ch.WriteStringTo(w, m.To)
 		n += nw
 		if err != nil {
 			return n, err
 		}
 	}
-	if m.Op == Tfind || m.Op == Tfindget {
+	if m.Op == Tcopy {
+		nw, err = ch.WriteStringTo(w, m.ToFs)
+		n += nw
+		if err != nil {
+			return n, err
+		}
+	}
+	if m.Op == Tfind || m.Op == Tfindget || m.Op == Tnotify || m.Op == Tgrep {
 		nw, err = ch.WriteStringTo(w, m.Pred)
 		n += nw
 		if err != nil {
@@ -152,6 +199,43 @@ func (m *Msg) WriteTo(w io.Writer) (n int64, err error) {
 		}
 		n += 8
 	}
+	if m.Op == Tlock || m.Op == Tunlock {
+		nw, err = ch.WriteStringTo(w, m.Owner)
+		n += nw
+		if err != nil {
+			return n, err
+		}
+	}
+	if m.Op == Tlock {
+		if err = binary.Write(w, binary.LittleEndian, uint64(m.Tmout)); err != nil {
+			return n, err
+		}
+		n += 8
+	}
+	if m.Op == Tgrep {
+		nw, err = ch.WriteStringTo(w, m.Rexp)
+		n += nw
+		if err != nil {
+			return n, err
+		}
+		if err = binary.Write(w, binary.LittleEndian, uint64(m.Nctx)); err != nil {
+			return n, err
+		}
+		n += 8
+	}
+	if m.Op == Tstats || m.Op == Tcompress {
+		if err = binary.Write(w, binary.LittleEndian, uint64(len(m.Paths))); err != nil {
+			return n, err
+		}
+		n += 8
+		for _, p := range m.Paths {
+			nw, err = ch.WriteStringTo(w, p)
+			n += nw
+			if err != nil {
+				return n, err
+			}
+		}
+	}
 	return n, nil
 }
 
@@ -168,22 +252,37 @@ func (m *Msg) String() string {
 	if m.Op == Tget || m.Op == Tput {
 		fmt.Fprintf(&buf, " off %d", m.Off)
 	}
-	if m.Op == Tget {
+	if m.Op == Tget || m.Op == Tsums {
 		fmt.Fprintf(&buf, " count %d", m.Count)
 	}
 	if m.Op == Tput || m.Op == Twstat {
 		fmt.Fprintf(&buf, " d <%s> ", m.D)
 	}
-	if m.Op == Tmove || m.Op == Tlink {
+	if m.Op == Tmove || m.Op == Tlink || m.Op == Tsymlink || m.Op == Tcopy {
 		fmt.Fprintf(&buf, " to '%s'", m.To)
 	}
-	if m.Op == Tfind || m.Op == Tfindget {
+	if m.Op == Tcopy && m.ToFs != "" {
+		fmt.Fprintf(&buf, " tofs '%s'", m.ToFs)
+	}
+	if m.Op == Tfind || m.Op == Tfindget || m.Op == Tnotify || m.Op == Tgrep {
 		fmt.Fprintf(&buf, " pred '%s'", m.Pred)
 	}
 	if m.Op == Tfind || m.Op == Tfindget {
 		fmt.Fprintf(&buf, " spref '%s' dpref '%s' depth %d",
 			m.Spref, m.Dpref, m.Depth)
 	}
+	if m.Op == Tlock || m.Op == Tunlock {
+		fmt.Fprintf(&buf, " owner '%s'", m.Owner)
+	}
+	if m.Op == Tlock {
+		fmt.Fprintf(&buf, " tmout %s", m.Tmout)
+	}
+	if m.Op == Tstats || m.Op == Tcompress {
+		fmt.Fprintf(&buf, " paths %q", m.Paths)
+	}
+	if m.Op == Tgrep {
+		fmt.Fprintf(&buf, " rexp '%s' nctx %d", m.Rexp, m.Nctx)
+	}
 	return buf.String()
 
 }
@@ -217,7 +316,7 @@ func UnpackMsg(buf []byte) ([]byte, *Msg, error) {
 		m.Off = int64(binary.LittleEndian.Uint64(buf[0:]))
 		buf = buf[8:]
 	}
-	if m.Op == Tget {
+	if m.Op == Tget || m.Op == Tsums {
 		if len(buf) < 8 {
 			return buf, nil, ch.ErrTooSmall
 		}
@@ -230,13 +329,19 @@ func UnpackMsg(buf []byte) ([]byte, *Msg, error) {
 			return buf, nil, err
 		}
 	}
-	if m.Op == Tmove || m.Op == Tlink {
+	if m.Op == Tmove || m.Op == Tlink || m.Op == Tsymlink || m.Op == Tcopy {
 		buf, m.To, err = ch.UnpackString(buf)
 		if err != nil {
 			return buf, nil, err
 		}
 	}
-	if m.Op == Tfind || m.Op == Tfindget {
+	if m.Op == Tcopy {
+		buf, m.ToFs, err = ch.UnpackString(buf)
+		if err != nil {
+			return buf, nil, err
+		}
+	}
+	if m.Op == Tfind || m.Op == Tfindget || m.Op == Tnotify || m.Op == Tgrep {
 		buf, m.Pred, err = ch.UnpackString(buf)
 		if err != nil {
 			return buf, nil, err
@@ -257,6 +362,47 @@ func UnpackMsg(buf []byte) ([]byte, *Msg, error) {
 		m.Depth = int(binary.LittleEndian.Uint64(buf[0:]))
 		buf = buf[8:]
 	}
+	if m.Op == Tlock || m.Op == Tunlock {
+		buf, m.Owner, err = ch.UnpackString(buf)
+		if err != nil {
+			return buf, nil, err
+		}
+	}
+	if m.Op == Tlock {
+		if len(buf) < 8 {
+			return buf, nil, ch.ErrTooSmall
+		}
+		m.Tmout = time.Duration(binary.LittleEndian.Uint64(buf[0:]))
+		buf = buf[8:]
+	}
+	if m.Op == Tgrep {
+		buf, m.Rexp, err = ch.UnpackString(buf)
+		if err != nil {
+			return buf, nil, err
+		}
+		if len(buf) < 8 {
+			return buf, nil, ch.ErrTooSmall
+		}
+		m.Nctx = int(binary.LittleEndian.Uint64(buf[0:]))
+		buf = buf[8:]
+	}
+	if m.Op == Tstats || m.Op == Tcompress {
+		if len(buf) < 8 {
+			return buf, nil, ch.ErrTooSmall
+		}
+		n := binary.LittleEndian.Uint64(buf[0:])
+		buf = buf[8:]
+		if n > uint64(len(buf)) {
+			return buf, nil, ch.ErrTooSmall
+		}
+		m.Paths = make([]string, n)
+		for i := range m.Paths {
+			buf, m.Paths[i], err = ch.UnpackString(buf)
+			if err != nil {
+				return buf, nil, err
+			}
+		}
+	}
 	return buf, m, nil
 }
 
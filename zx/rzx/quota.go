@@ -0,0 +1,296 @@
+package rzx
+
+import (
+	"clive/zx"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Limits for the data and number of files in a served tree,
+// both in total and for each user, who is charged for the files
+// owned.
+// Zero means there's no limit.
+struct Quota {
+	Bytes, Files         int64 // for the tree
+	UserBytes, UserFiles int64 // for each user
+}
+
+struct usage {
+	bytes, files int64
+}
+
+// usage by uid
+type usages map[string]*usage
+
+// Usage accounting for a tree with a quota
+struct quota {
+	sync.Mutex
+	Quota
+	fsys  string
+	tot   usage
+	users usages
+}
+
+var ErrQuota = errors.New("quota exceeded")
+
+// Parse a quota given as in "bytes=10g files=10000 ubytes=1g ufiles=1000",
+// where ubytes and ufiles are the limits for each user.
+// Sizes may have a k, m, g, or t suffix.
+func ParseQuota(s string) (Quota, error) {
+	var q Quota
+	for _, kv := range strings.Fields(s) {
+		toks := strings.SplitN(kv, "=", 2)
+		if len(toks) != 2 {
+			return q, fmt.Errorf("quota: bad '%s'", kv)
+		}
+		v := strings.ToLower(toks[1])
+		mul := int64(1)
+		if n := len(v); n > 0 {
+			switch v[n-1] {
+			case 'k':
+				mul = 1024
+			case 'm':
+				mul = 1024 * 1024
+			case 'g':
+				mul = 1024 * 1024 * 1024
+			case 't':
+				mul = 1024 * 1024 * 1024 * 1024
+			}
+			if mul > 1 {
+				v = v[:n-1]
+			}
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("quota: bad value in '%s'", kv)
+		}
+		n *= mul
+		switch toks[0] {
+		case "bytes":
+			q.Bytes = n
+		case "files":
+			q.Files = n
+		case "ubytes":
+			q.UserBytes = n
+		case "ufiles":
+			q.UserFiles = n
+		default:
+			return q, fmt.Errorf("quota: unknown '%s'", toks[0])
+		}
+	}
+	return q, nil
+}
+
+func (q Quota) String() string {
+	return fmt.Sprintf("bytes=%d files=%d ubytes=%d ufiles=%d",
+		q.Bytes, q.Files, q.UserBytes, q.UserFiles)
+}
+
+func (us usages) add(uid string, bytes, files int64) {
+	u := us[uid]
+	if u == nil {
+		u = &usage{}
+		us[uid] = u
+	}
+	u.bytes += bytes
+	u.files += files
+}
+
+// Bytes used by the file for d; only the data of regular files counts.
+func dsize(d zx.Dir) int64 {
+	if d["type"] != "-" {
+		return 0
+	}
+	return d.Size()
+}
+
+// Add to us the usage for the files under d, and d itself if self is set.
+func scan(fs zx.Getter, d zx.Dir, us usages, self bool) error {
+	if d["path"] == "/Ctl" {
+		return nil
+	}
+	if self {
+		us.add(d["uid"], dsize(d), 1)
+	}
+	if d["type"] != "d" {
+		return nil
+	}
+	ds, err := zx.GetDir(fs, d["path"])
+	if err != nil {
+		return err
+	}
+	for _, cd := range ds {
+		if err := scan(fs, cd, us, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the usage for the file or tree at p, empty if it's not there.
+func scanAt(fs zx.Fs, p string) (usages, error) {
+	us := usages{}
+	gfs, ok := fs.(zx.Getter)
+	if !ok {
+		return us, zx.ErrBug
+	}
+	d, err := zx.Stat(fs, p)
+	if zx.IsNotExist(err) {
+		return us, nil
+	}
+	if err != nil {
+		return us, err
+	}
+	return us, scan(gfs, d, us, p != "/")
+}
+
+func over(used, inc, lim int64) bool {
+	return lim > 0 && inc > 0 && used+inc > lim
+}
+
+// Charge uid for the given bytes and files (which may be negative),
+// failing with ErrQuota if check is set and that exceeds a limit.
+func (q *quota) charge(uid string, bytes, files int64, check bool) error {
+	q.Lock()
+	defer q.Unlock()
+	u := q.users[uid]
+	if u == nil {
+		u = &usage{}
+	}
+	if check {
+		if over(q.tot.bytes, bytes, q.Bytes) || over(q.tot.files, files, q.Files) {
+			return fmt.Errorf("%s: %s", q.fsys, ErrQuota)
+		}
+		if over(u.bytes, bytes, q.UserBytes) || over(u.files, files, q.UserFiles) {
+			return fmt.Errorf("%s: user %s: %s", q.fsys, uid, ErrQuota)
+		}
+	}
+	q.users[uid] = u
+	u.bytes += bytes
+	u.files += files
+	q.tot.bytes += bytes
+	q.tot.files += files
+	return nil
+}
+
+// Account for the file od (nil if none) becoming nd (nil if gone).
+func (q *quota) chg(od, nd zx.Dir) {
+	if od != nil {
+		q.charge(od["uid"], -dsize(od), -1, false)
+	}
+	if nd != nil {
+		q.charge(nd["uid"], dsize(nd), 1, false)
+	}
+}
+
+// Add (or remove, if neg is set) the usage in us.
+func (q *quota) addAll(us usages, neg bool) {
+	for uid, u := range us {
+		if neg {
+			q.charge(uid, -u.bytes, -u.files, false)
+		} else {
+			q.charge(uid, u.bytes, u.files, false)
+		}
+	}
+}
+
+func lim(n int64) string {
+	if n <= 0 {
+		return "none"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// Report the usage, as shown in the "quota" flag of the tree.
+func (q *quota) String() string {
+	q.Lock()
+	defer q.Unlock()
+	ls := []string{fmt.Sprintf("tree %s: %d bytes %d files, limits %s bytes %s files",
+		q.fsys, q.tot.bytes, q.tot.files, lim(q.Bytes), lim(q.Files))}
+	uids := make([]string, 0, len(q.users))
+	for uid := range q.users {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		u := q.users[uid]
+		ls = append(ls, fmt.Sprintf("user %s: %d bytes %d files, limits %s bytes %s files",
+			uid, u.bytes, u.files, lim(q.UserBytes), lim(q.UserFiles)))
+	}
+	return strings.Join(ls, "\nquota ")
+}
+
+// Accounting for a put in progress.
+// Space is reserved as data arrives, and the usage is fixed
+// once the put is done using the actual file.
+struct qput {
+	q      *quota
+	path   string
+	uid    string
+	od     zx.Dir // file before the put, or nil
+	off    int64
+	size   int64 // expected size for the file
+	nb, nf int64 // bytes and files reserved
+}
+
+func (q *quota) startPut(fs zx.Fs, p string, d zx.Dir, off int64, uid string) (*qput, error) {
+	qp := &qput{q: q, path: p, uid: uid, off: off}
+	od, err := zx.Stat(fs, p)
+	if err == nil {
+		qp.od = od
+		qp.uid = od["uid"]
+		qp.size = dsize(od)
+	} else if d != nil && d["type"] != "" {
+		// a new file
+		if err := q.charge(qp.uid, 0, 1, true); err != nil {
+			return nil, err
+		}
+		qp.nf = 1
+	}
+	if d != nil && d["size"] != "" && d["type"] != "d" && d["type"] != "D" {
+		if err := qp.grow(d.Size()); err != nil {
+			qp.done(fs)
+			return nil, err
+		}
+	}
+	return qp, nil
+}
+
+// The file is expected to be of size sz.
+func (qp *qput) grow(sz int64) error {
+	if sz <= qp.size {
+		qp.size = sz
+		return nil
+	}
+	if err := qp.q.charge(qp.uid, sz-qp.size, 0, true); err != nil {
+		return err
+	}
+	qp.nb += sz - qp.size
+	qp.size = sz
+	return nil
+}
+
+// n bytes are about to be written.
+func (qp *qput) wrote(n int) error {
+	if qp.off < 0 {
+		return qp.grow(qp.size + int64(n))
+	}
+	qp.off += int64(n)
+	if qp.off <= qp.size {
+		return nil
+	}
+	return qp.grow(qp.off)
+}
+
+func (qp *qput) done(fs zx.Fs) {
+	qp.q.charge(qp.uid, -qp.nb, -qp.nf, false)
+	nd, err := zx.Stat(fs, qp.path)
+	if err != nil {
+		nd = nil
+	}
+	qp.q.chg(qp.od, nd)
+}
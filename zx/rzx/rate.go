@@ -0,0 +1,203 @@
+package rzx
+
+import (
+	"clive/zx"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limits for clients, per second.
+// Zero means there's no limit.
+struct Rate {
+	Ops   float64 // requests
+	Bytes float64 // data sent or received
+}
+
+// Token bucket for a user or address.
+// Up to a second worth of requests (at least one) and bytes may be
+// used at once.
+// Requests fail with ErrThrottled while there are no requests left
+// or the bytes used exceed those available, and data transfers
+// in progress are slowed down to the rate.
+struct bucket {
+	sync.Mutex
+	Rate
+	ops, bytes float64 // available
+	last       time.Time
+}
+
+// Limits configured and buckets for those limited
+struct limits {
+	sync.Mutex
+	rates   map[string]Rate    // by uid or address; "" is the default
+	buckets map[string]*bucket // by uid or address
+}
+
+var ErrThrottled = errors.New("throttled: rate limit exceeded")
+
+// Parse a rate limit given as in "nemo ops=100 bytes=1m", where the
+// first word is the user or address limited, if any, and sizes may
+// have a k, m, or g suffix.
+func ParseRate(s string) (string, Rate, error) {
+	var who string
+	var r Rate
+	for i, kv := range strings.Fields(s) {
+		toks := strings.SplitN(kv, "=", 2)
+		if len(toks) != 2 {
+			if i > 0 {
+				return who, r, fmt.Errorf("rate: bad '%s'", kv)
+			}
+			who = kv
+			continue
+		}
+		v := strings.ToLower(toks[1])
+		mul := 1.0
+		if n := len(v); n > 0 {
+			switch v[n-1] {
+			case 'k':
+				mul = 1024
+			case 'm':
+				mul = 1024 * 1024
+			case 'g':
+				mul = 1024 * 1024 * 1024
+			}
+			if mul > 1 {
+				v = v[:n-1]
+			}
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			return who, r, fmt.Errorf("rate: bad value in '%s'", kv)
+		}
+		switch toks[0] {
+		case "ops":
+			r.Ops = n
+		case "bytes":
+			r.Bytes = n * mul
+		default:
+			return who, r, fmt.Errorf("rate: unknown '%s'", toks[0])
+		}
+	}
+	return who, r, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("ops=%g bytes=%g", r.Ops, r.Bytes)
+}
+
+// Limit the rate for requests made by the given user, or by clients at
+// the given address (eg. "10.0.0.1" or "local" for unix sockets).
+// If who is "", the limit is the default for each user without one.
+// Users are looked for before addresses, and all the clients for
+// a user or address share the same limit.
+// Limits apply to clients authenticated after they are set.
+func (s *Server) RateLimit(who string, r Rate) {
+	l := s.limits
+	l.Lock()
+	defer l.Unlock()
+	l.rates[who] = r
+}
+
+// Return the bucket for a client with the given uid and address (as in
+// a mux tag), or nil if not limited.
+func (l *limits) bucketFor(uid, tag string) *bucket {
+	addr := tag
+	if n := strings.LastIndexByte(tag, '!'); n > 0 {
+		addr = tag[:n]
+	}
+	l.Lock()
+	defer l.Unlock()
+	who := uid
+	r, ok := l.rates[uid]
+	if !ok {
+		who = addr
+		r, ok = l.rates[addr]
+	}
+	if !ok {
+		who = uid
+		r, ok = l.rates[""]
+	}
+	if !ok || (r.Ops <= 0 && r.Bytes <= 0) {
+		return nil
+	}
+	b := l.buckets[who]
+	if b == nil || b.Rate != r {
+		b = &bucket{Rate: r, ops: r.maxOps(), bytes: r.Bytes, last: time.Now()}
+		l.buckets[who] = b
+	}
+	return b
+}
+
+func (r Rate) maxOps() float64 {
+	if r.Ops < 1 {
+		return 1
+	}
+	return r.Ops
+}
+
+// Refill b, which must be locked.
+func (b *bucket) fill() {
+	now := time.Now()
+	secs := now.Sub(b.last).Seconds()
+	b.last = now
+	if b.Ops > 0 {
+		b.ops += secs * b.Ops
+		if max := b.maxOps(); b.ops > max {
+			b.ops = max
+		}
+	}
+	if b.Bytes > 0 {
+		b.bytes += secs * b.Bytes
+		if b.bytes > b.Bytes {
+			b.bytes = b.Bytes
+		}
+	}
+}
+
+// Take a request from b, or fail with ErrThrottled.
+func (b *bucket) op() error {
+	if b == nil {
+		return nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.fill()
+	if b.Bytes > 0 && b.bytes < 0 {
+		return ErrThrottled
+	}
+	if b.Ops > 0 {
+		if b.ops < 1 {
+			return ErrThrottled
+		}
+		b.ops--
+	}
+	return nil
+}
+
+// Take n bytes from b, waiting until they are available.
+func (b *bucket) data(n int) {
+	if b == nil || b.Bytes <= 0 {
+		return
+	}
+	b.Lock()
+	b.fill()
+	b.bytes -= float64(n)
+	owed := b.bytes
+	b.Unlock()
+	if owed < 0 {
+		time.Sleep(time.Duration(-owed / b.Bytes * float64(time.Second)))
+	}
+}
+
+// Bytes sent for d, roughly
+func dirLen(d zx.Dir) int {
+	n := 0
+	for k, v := range d {
+		n += len(k) + len(v) + 2
+	}
+	return n
+}
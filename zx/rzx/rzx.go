@@ -8,6 +8,7 @@ import (
 	"clive/dbg"
 	"clive/net"
 	"clive/net/auth"
+	"clive/trace"
 	"clive/zx"
 	"crypto/tls"
 	"fmt"
@@ -22,6 +23,12 @@ struct client {
 	when time.Time
 }
 
+// A subtree exported to a user or group
+struct export {
+	group string
+	path  string
+}
+
 struct clients {
 	sync.Mutex
 	set map[string]client
@@ -34,9 +41,19 @@ struct Server {
 	addr    string           // where served
 	rdonly  bool
 	noauth  bool
+	nozip   bool
+	mx      *ch.Mux // for the client, once authenticated
+	check   bool    // the client wants hashes for chunks in gets/puts
 	inc     <-chan *ch.Mux
 	endc    chan bool
 	clients *clients
+	quotas  map[string]*quota // by tree name
+	uid     string            // authenticated user
+	auditor *auditor
+	exports map[string][]export // by tree name
+	metrics *metrics
+	limits  *limits
+	bucket  *bucket // rate limit for the user
 	// when we auth a user, we make a new copy of the Server
 	// struct, with local copies of everything that's not a pointer,
 	// and a new ai for the user.
@@ -105,6 +122,11 @@ func newServer(addr string, tc *tls.Config, ro bool) (*Server, error) {
 		rdonly:  ro,
 		fs:      map[string]zx.Fs{},
 		clients: &clients{set: map[string]client{}},
+		quotas:  map[string]*quota{},
+		auditor: &auditor{},
+		exports: map[string][]export{},
+		metrics: newMetrics(),
+		limits:  &limits{rates: map[string]Rate{}, buckets: map[string]*bucket{}},
 	}
 	s.Tag = addr
 	go s.loop()
@@ -134,6 +156,12 @@ func (s *Server) NoAuth() {
 	s.noauth = true
 }
 
+// Never compress what is sent to clients, eg. for local links.
+// Clients are told so when they ask for compression.
+func (s *Server) NoCompress() {
+	s.nozip = true
+}
+
 interface flagAdder {
 	Add(name string, vp face{})
 	AddRO(name string, vp face{})
@@ -150,6 +178,7 @@ func (s *Server) Serve(name string, fs zx.Fs) error {
 	if ffs, ok := fs.(flagAdder); ok {
 		ffs.AddRO("server rdonly", &s.rdonly)
 		ffs.AddRO("server noauth", &s.noauth)
+		ffs.AddRO("server nocompress", &s.nozip)
 		ffs.AddRO("server addr", &s.addr)
 		ffs.AddRO("user", s.clients)
 	}
@@ -157,6 +186,77 @@ func (s *Server) Serve(name string, fs zx.Fs) error {
 	return nil
 }
 
+// Set a quota for the tree served with the given name, and report
+// its usage in the "quota" flag of the tree, if it has flags.
+// The usage is computed by walking the tree and is kept up to date
+// as clients change it through the server; changes made by others
+// are not noticed until the quota is set again.
+func (s *Server) SetQuota(name string, q Quota) error {
+	fs := s.tree(name)
+	if fs == nil {
+		return fmt.Errorf("%s: no fsys '%s'", s.addr, name)
+	}
+	us, err := scanAt(fs, "/")
+	if err != nil {
+		return fmt.Errorf("%s: %s: quota: %s", s.addr, name, err)
+	}
+	qt := &quota{Quota: q, fsys: name, users: usages{}}
+	qt.addAll(us, false)
+	s.Lock()
+	s.quotas[name] = qt
+	s.Unlock()
+	if ffs, ok := fs.(flagAdder); ok {
+		ffs.AddRO("quota", qt)
+	}
+	return nil
+}
+
+// Export only the subtree at path of the tree served with the given
+// name to the users in group (a user name is also a group),
+// who see it as the root of the tree.
+// Once a tree has exports, it's not seen by users not in them.
+// Exports are tried in the order they are made and the first
+// one including the user is used, so restrictions should be
+// exported before the more general ones, eg.:
+//	s.Export("main", "nemo", "/usr/nemo")
+//	s.Export("main", "sys", "/")
+// Exports apply to clients authenticated after they are made.
+func (s *Server) Export(name, group, path string) error {
+	path, err := zx.UseAbsPath(path)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.fs[name] == nil {
+		return fmt.Errorf("%s: no fsys '%s'", s.addr, name)
+	}
+	s.exports[name] = append(s.exports[name], export{group, path})
+	return nil
+}
+
+// Return the subtree exported to ai for the given tree,
+// or false if the user can't see it.
+// The server must be locked.
+func (s *Server) exported(name string, ai *auth.Info) (string, bool) {
+	xs, ok := s.exports[name]
+	if !ok {
+		return "/", true
+	}
+	for _, x := range xs {
+		if ai.InGroup(x.group) {
+			return x.path, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) quota(name string) *quota {
+	s.Lock()
+	defer s.Unlock()
+	return s.quotas[name]
+}
+
 func (s *Server) tree(name string) zx.Fs {
 	s.Lock()
 	defer s.Unlock()
@@ -187,6 +287,23 @@ func (s *Server) stat(c ch.Conn, m *Msg, fs zx.Fs) error {
 	return err
 }
 
+// Failures are reported in the entries for the paths, so that
+// the reply has one entry per path, in order.
+func (s *Server) stats(c ch.Conn, m *Msg, fs zx.Fs) error {
+	for _, p := range m.Paths {
+		d, err := zx.Stat(fs, p)
+		if err != nil {
+			d = zx.Dir{"path": p, "err": err.Error()}
+		} else {
+			s.mkaddr(d, m.Fsys)
+		}
+		if ok := c.Out <- d; !ok {
+			return cerror(c.Out)
+		}
+	}
+	return nil
+}
+
 func (s *Server) get(c ch.Conn, m *Msg, fs zx.Fs) error {
 	xfs, ok := fs.(zx.Getter)
 	if !ok {
@@ -206,15 +323,27 @@ func (s *Server) get(c ch.Conn, m *Msg, fs zx.Fs) error {
 				x = d.Bytes()
 			}
 		}
+		s.bucket.data(len(x))
 		if ok := c.Out <- x; !ok {
 			err := cerror(c.Out)
 			close(rc, err)
 			return err
 		}
+		if s.check {
+			if ok := c.Out <- zx.ChunkSum(x); !ok {
+				err := cerror(c.Out)
+				close(rc, err)
+				return err
+			}
+		}
 	}
 	return cerror(rc)
 }
 
+func errCorrupt(p string) error {
+	return fmt.Errorf("%s: %s", p, zx.ErrCorrupt)
+}
+
 func (s *Server) put(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if s.rdonly {
 		return fmt.Errorf("%s: %s", s.addr, zx.ErrRO)
@@ -223,17 +352,58 @@ func (s *Server) put(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if !ok {
 		return zx.ErrBug
 	}
+	var qp *qput
+	if q := s.quota(m.Fsys); q != nil {
+		var err error
+		if qp, err = q.startPut(fs, m.Path, m.D, m.Off, s.uid); err != nil {
+			return err
+		}
+		defer qp.done(fs)
+	}
 	ic := make(chan []byte)
+	path := m.Path
+	// send data to the tree, if the quota permits
+	send := func(b []byte) bool {
+		if qp != nil {
+			if err := qp.wrote(len(b)); err != nil {
+				close(c.In, err)
+				return false
+			}
+		}
+		s.bucket.data(len(b))
+		if ok := ic <- b; !ok {
+			close(c.In, cerror(ic))
+			return false
+		}
+		return true
+	}
 	if m.D["type"] == "d" {
 		close(ic)
 	} else {
 		go func() {
+			var last []byte
+			pending := false // last is waiting for its hash
 			for m := range c.In {
 				switch m := m.(type) {
 				case []byte:
-					ok := ic <- m
-					if !ok {
-						close(c.In, cerror(ic))
+					if s.check {
+						if pending {
+							close(c.In, errCorrupt(path))
+							break
+						}
+						last, pending = m, true
+						continue
+					}
+					if !send(m) {
+						break
+					}
+				case string:
+					if !s.check || !pending || zx.ChunkSum(last) != m {
+						close(c.In, errCorrupt(path))
+						break
+					}
+					pending = false
+					if !send(last) {
 						break
 					}
 				default:
@@ -243,7 +413,11 @@ func (s *Server) put(c ch.Conn, m *Msg, fs zx.Fs) error {
 					break
 				}
 			}
-			close(ic, cerror(c.In))
+			err := cerror(c.In)
+			if err == nil && pending {
+				err = errCorrupt(path)
+			}
+			close(ic, err)
 		}()
 	}
 	rc := xfs.Put(m.Path, m.D, m.Off, ic)
@@ -266,7 +440,20 @@ func (s *Server) move(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if !ok {
 		return zx.ErrBug
 	}
-	return <-xfs.Move(m.Path, m.To)
+	q := s.quota(m.Fsys)
+	if q == nil {
+		return <-xfs.Move(m.Path, m.To)
+	}
+	// what's replaced at the target is gone
+	us, err := scanAt(fs, m.To)
+	if err != nil {
+		return err
+	}
+	if err := <-xfs.Move(m.Path, m.To); err != nil {
+		return err
+	}
+	q.addAll(us, true)
+	return nil
 }
 
 func (s *Server) link(c ch.Conn, m *Msg, fs zx.Fs) error {
@@ -277,7 +464,98 @@ func (s *Server) link(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if !ok {
 		return zx.ErrBug
 	}
-	return <-xfs.Link(m.To, m.Path)
+	return s.newFile(m.Fsys, func() error {
+		return <-xfs.Link(m.To, m.Path)
+	})
+}
+
+func (s *Server) symlink(c ch.Conn, m *Msg, fs zx.Fs) error {
+	if s.rdonly {
+		return fmt.Errorf("%s: %s", s.addr, zx.ErrRO)
+	}
+	xfs, ok := fs.(zx.Symlinker)
+	if !ok {
+		return zx.ErrBug
+	}
+	return s.newFile(m.Fsys, func() error {
+		return <-xfs.Symlink(m.To, m.Path)
+	})
+}
+
+// Make a new file using fn, charging the user for it.
+func (s *Server) newFile(fsys string, fn func() error) error {
+	q := s.quota(fsys)
+	if q == nil {
+		return fn()
+	}
+	if err := q.charge(s.uid, 0, 1, true); err != nil {
+		return err
+	}
+	err := fn()
+	if err != nil {
+		q.charge(s.uid, 0, -1, false)
+	}
+	return err
+}
+
+func (s *Server) lstat(c ch.Conn, m *Msg, fs zx.Fs) error {
+	d, err := zx.Lstat(fs, m.Path)
+	if err == nil {
+		s.mkaddr(d, m.Fsys)
+		c.Out <- d
+	}
+	return err
+}
+
+// Copies are made here, even if the tree is not a Copier,
+// so the data does not go to the client and back.
+func (s *Server) copy(c ch.Conn, m *Msg, fs zx.Fs) error {
+	if s.rdonly {
+		return fmt.Errorf("%s: %s", s.addr, zx.ErrRO)
+	}
+	tfs, tname := fs, m.Fsys
+	same := m.ToFs == "" || m.ToFs == m.Fsys
+	if !same {
+		if tfs = s.tree(m.ToFs); tfs == nil {
+			return fmt.Errorf("no fsys '%s'", m.ToFs)
+		}
+		tname = m.ToFs
+	}
+	cp := func() error {
+		if same {
+			return zx.Copy(fs, m.Path, m.To)
+		}
+		return zx.CopyTo(fs, m.Path, tfs, m.To)
+	}
+	q := s.quota(tname)
+	if q == nil {
+		return cp()
+	}
+	// the user is charged for the copy in advance and
+	// the usage is fixed later with what's at the target.
+	from, err := scanAt(fs, m.Path)
+	if err != nil {
+		return err
+	}
+	old, err := scanAt(tfs, m.To)
+	if err != nil {
+		return err
+	}
+	var nb, nf int64
+	for _, u := range from {
+		nb += u.bytes
+		nf += u.files
+	}
+	if err := q.charge(s.uid, nb, nf, true); err != nil {
+		return err
+	}
+	err = cp()
+	q.charge(s.uid, -nb, -nf, false)
+	if nus, nerr := scanAt(tfs, m.To); nerr == nil {
+		q.addAll(old, true)
+		q.addAll(nus, false)
+	}
+	return err
 }
 
 func (s *Server) remove(c ch.Conn, m *Msg, fs zx.Fs) error {
@@ -291,10 +569,23 @@ func (s *Server) remove(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if m.Path == "" || m.Path == "/" {
 		return fmt.Errorf("%s: won't remove /", s.addr)
 	}
+	rm := xfs.RemoveAll
 	if m.Op == Tremove {
-		return <-xfs.Remove(m.Path)
+		rm = xfs.Remove
 	}
-	return <-xfs.RemoveAll(m.Path)
+	q := s.quota(m.Fsys)
+	if q == nil {
+		return <-rm(m.Path)
+	}
+	us, err := scanAt(fs, m.Path)
+	if err != nil {
+		return err
+	}
+	if err := <-rm(m.Path); err != nil {
+		return err
+	}
+	q.addAll(us, true)
+	return nil
 }
 
 func (s *Server) find(c ch.Conn, m *Msg, fs zx.Fs) error {
@@ -305,6 +596,7 @@ func (s *Server) find(c ch.Conn, m *Msg, fs zx.Fs) error {
 	rc := xfs.Find(m.Path, m.Pred, m.Spref, m.Dpref, m.Depth)
 	for d := range rc {
 		s.mkaddr(d, m.Fsys)
+		s.bucket.data(dirLen(d))
 		if ok := c.Out <- d; !ok {
 			err := cerror(c.Out)
 			close(rc, err)
@@ -314,6 +606,61 @@ func (s *Server) find(c ch.Conn, m *Msg, fs zx.Fs) error {
 	return cerror(rc)
 }
 
+// The search is made here, so that only the matches are sent.
+func (s *Server) grep(c ch.Conn, m *Msg, fs zx.Fs) error {
+	rc := zx.Grep(fs, m.Path, m.Pred, m.Rexp, m.Nctx)
+	for x := range rc {
+		if ok := c.Out <- x; !ok {
+			err := cerror(c.Out)
+			close(rc, err)
+			return err
+		}
+	}
+	return cerror(rc)
+}
+
+// The checksums are computed here, so that only they are sent.
+func (s *Server) sums(c ch.Conn, m *Msg, fs zx.Fs) error {
+	rc := zx.Sums(fs, m.Path, m.Count)
+	for x := range rc {
+		if ok := c.Out <- x; !ok {
+			err := cerror(c.Out)
+			close(rc, err)
+			return err
+		}
+	}
+	return cerror(rc)
+}
+
+// Pick the first compression algorithm we know from those the
+// client knows, and use it for what we send after replying.
+// The reply is "" if there's no compression.
+func (s *Server) compress(c ch.Conn, m *Msg) error {
+	alg := ""
+	if !s.nozip {
+		alg = ch.PickZip(m.Paths...)
+	}
+	if ok := c.Out <- alg; !ok {
+		return cerror(c.Out)
+	}
+	if s.mx == nil || alg == "" {
+		return nil
+	}
+	s.Dprintf("%s: compressing with %s\n", s.mx.Tag, alg)
+	return s.mx.Compress(alg)
+}
+
+// Send a hash (see zx.ChunkSum) after each chunk of data in gets,
+// and expect one after each chunk in puts.
+// The reply is the name of the hash used.
+func (s *Server) checks(c ch.Conn, m *Msg) error {
+	s.check = true
+	if ok := c.Out <- "sha1"; !ok {
+		return cerror(c.Out)
+	}
+	return nil
+}
+
 func (s *Server) findget(c ch.Conn, m *Msg, fs zx.Fs) error {
 	xfs, ok := fs.(zx.FindGetter)
 	if !ok {
@@ -321,8 +668,12 @@ func (s *Server) findget(c ch.Conn, m *Msg, fs zx.Fs) error {
 	}
 	rc := xfs.FindGet(m.Path, m.Pred, m.Spref, m.Dpref, m.Depth)
 	for x := range rc {
-		if d, ok := x.(zx.Dir); ok {
-			s.mkaddr(d, m.Fsys)
+		switch x := x.(type) {
+		case zx.Dir:
+			s.mkaddr(x, m.Fsys)
+			s.bucket.data(dirLen(x))
+		case []byte:
+			s.bucket.data(len(x))
 		}
 		if ok := c.Out <- x; !ok {
 			err := cerror(c.Out)
@@ -333,6 +684,54 @@ func (s *Server) findget(c ch.Conn, m *Msg, fs zx.Fs) error {
 	return cerror(rc)
 }
 
+// Replies to Tnotify are the dir entries for the changes, until
+// the client closes the conversation.
+func (s *Server) notify(c ch.Conn, m *Msg, fs zx.Fs) error {
+	xfs, ok := fs.(zx.Watcher)
+	if !ok {
+		return zx.ErrBug
+	}
+	rc := xfs.Watch(m.Path, m.Pred)
+	go func() {
+		// the client closes c.In to stop watching
+		for range c.In {
+		}
+		close(rc, cerror(c.In))
+	}()
+	for d := range rc {
+		s.mkaddr(d, m.Fsys)
+		if ok := c.Out <- d; !ok {
+			err := cerror(c.Out)
+			close(rc, err)
+			return err
+		}
+	}
+	return cerror(rc)
+}
+
+func (s *Server) lock(c ch.Conn, m *Msg, fs zx.Fs) error {
+	if s.rdonly {
+		return fmt.Errorf("%s: %s", s.addr, zx.ErrRO)
+	}
+	xfs, ok := fs.(zx.Locker)
+	if !ok {
+		return zx.ErrBug
+	}
+	if m.Op == Tunlock {
+		return <-xfs.Unlock(m.Path, m.Owner)
+	}
+	rc := xfs.Lock(m.Path, m.Owner, m.Tmout)
+	rd := <-rc
+	if err := cerror(rc); err != nil {
+		return err
+	}
+	s.mkaddr(rd, m.Fsys)
+	if ok := c.Out <- rd; !ok {
+		return cerror(c.Out)
+	}
+	return nil
+}
+
 func (s *Server) wstat(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if s.rdonly {
 		return fmt.Errorf("%s: %s", s.addr, zx.ErrRO)
@@ -341,8 +740,37 @@ func (s *Server) wstat(c ch.Conn, m *Msg, fs zx.Fs) error {
 	if !ok {
 		return zx.ErrBug
 	}
+	var od zx.Dir
+	q := s.quota(m.Fsys)
+	if q != nil && (m.D["size"] != "" || m.D["uid"] != "") {
+		d, err := zx.Stat(fs, m.Path)
+		if err != nil {
+			return err
+		}
+		// check that the new owner can take the file;
+		// the usage is fixed after the wstat.
+		uid, nb, nf := d["uid"], dsize(d), int64(1)
+		if m.D["size"] != "" && d["type"] == "-" {
+			nb = m.D.Size()
+		}
+		if m.D["uid"] != "" && m.D["uid"] != uid {
+			uid = m.D["uid"]
+		} else {
+			nb, nf = nb-dsize(d), 0
+		}
+		if err := q.charge(uid, nb, nf, true); err != nil {
+			return err
+		}
+		q.charge(uid, -nb, -nf, false)
+		od = d
+	}
 	rc := xfs.Wstat(m.Path, m.D)
 	rd := <-rc
+	if od != nil {
+		if nd, err := zx.Stat(fs, m.Path); err == nil {
+			q.chg(od, nd)
+		}
+	}
 	if err := cerror(rc); err != nil {
 		return err
 	}
@@ -365,10 +793,43 @@ func (s *Server) req(c ch.Conn) {
 	switch m := dat.(type) {
 	case *Msg:
 		s.Dprintf("%s: <- %s\n", c.Tag, m)
+		// spans are made only if they are exported, and
+		// requests without a trace start a new one.
+		var sp *trace.Span
+		if trace.Enabled() {
+			tc, _ := trace.Parse(c.Trace)
+			sp = trace.Start(tc, "rzx "+m.Op.String())
+			sp.Set("fsys", m.Fsys)
+			sp.Set("path", m.Path)
+		}
+		t0 := time.Now()
+		s.metrics.began()
+		defer func() {
+			s.metrics.done(m.Fsys, m.Op, time.Since(t0), rerr)
+			if m.Op.mutates() {
+				s.audit(m, rerr)
+			}
+			if sp != nil {
+				sp.Fail(rerr)
+				sp.Finish()
+			}
+		}()
+		if err := s.bucket.op(); err != nil {
+			rerr = err
+			break
+		}
 		if m.Op == Ttrees {
 			rerr = s.trees(c, m, nil)
 			break
 		}
+		if m.Op == Tcompress {
+			rerr = s.compress(c, m)
+			break
+		}
+		if m.Op == Tcheck {
+			rerr = s.checks(c, m)
+			break
+		}
 		fs := s.tree(m.Fsys)
 		if fs == nil {
 			rerr = fmt.Errorf("no fsys '%s'", m.Fsys)
@@ -383,6 +844,20 @@ func (s *Server) req(c ch.Conn) {
 			rerr = s.put(c, m, fs)
 		case Tmove:
 			rerr = s.move(c, m, fs)
+		case Tlink:
+			rerr = s.link(c, m, fs)
+		case Tsymlink:
+			rerr = s.symlink(c, m, fs)
+		case Tlstat:
+			rerr = s.lstat(c, m, fs)
+		case Tcopy:
+			rerr = s.copy(c, m, fs)
+		case Tstats:
+			rerr = s.stats(c, m, fs)
+		case Tgrep:
+			rerr = s.grep(c, m, fs)
+		case Tsums:
+			rerr = s.sums(c, m, fs)
 		case Tremove, Tremoveall:
 			rerr = s.remove(c, m, fs)
 		case Tfind:
@@ -391,6 +866,10 @@ func (s *Server) req(c ch.Conn) {
 			rerr = s.findget(c, m, fs)
 		case Twstat:
 			rerr = s.wstat(c, m, fs)
+		case Tnotify:
+			rerr = s.notify(c, m, fs)
+		case Tlock, Tunlock:
+			rerr = s.lock(c, m, fs)
 		default:
 			rerr = fmt.Errorf("unknown msg op %v", m.Op)
 		}
@@ -410,17 +889,24 @@ func (s *Server) authFor(ai *auth.Info) *Server {
 	ns := &Server{}
 	*ns = *s
 	ns.fs = map[string]zx.Fs{}
+	ns.uid = ai.Uid
 	for n, fs := range s.fs {
+		root, ok := s.exported(n, ai)
+		if !ok {
+			continue
+		}
 		if afs, ok := fs.(zx.Auther); ok {
-			fs, err := afs.Auth(ai)
+			afs, err := afs.Auth(ai)
 			if err != nil {
 				dbg.Warn("%s: user %s: fs auth: %s", s.addr, ai.Uid, err)
-			} else {
-				ns.fs[n] = fs
+				continue
 			}
-		} else {
-			ns.fs[n] = fs
+			fs = afs
+		}
+		if root != "/" {
+			fs = newSubFs(fs, root)
 		}
+		ns.fs[n] = fs
 	}
 	return ns
 }
@@ -447,6 +933,7 @@ func (s *Server) client(mx *ch.Mux) {
 		}
 		if err != nil {
 			dbg.Warn("%s: %s: %s", s.addr, mx.Tag, err)
+			s.metrics.connected(false)
 			continue
 		}
 		if ai == nil {
@@ -462,7 +949,10 @@ func (s *Server) client(mx *ch.Mux) {
 	}
 	s.Dprintf("%s auth as %s\n", mx.Tag, ai.Uid)
 	s.clients.add(mx.Tag, ai.Uid)
+	s.metrics.connected(true)
 	ns := s.authFor(ai)
+	ns.mx = mx
+	ns.bucket = s.limits.bucketFor(ai.Uid, mx.Tag)
 	for c := range mx.In {
 		go ns.req(c)
 	}
@@ -1,6 +1,7 @@
 package rzx
 
 import (
+	"bytes"
 	"clive/ch"
 	"clive/net"
 	"clive/net/auth"
@@ -8,9 +9,14 @@ import (
 	"clive/zx"
 	"clive/zx/fstest"
 	"clive/zx/zux"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 struct tb {
@@ -55,6 +61,17 @@ var (
 			Pred: "name=x", Spref: "/", Dpref: "/", Depth: 1},
 		&Msg{Op: Tfindget, Fsys: "main", Path: "/a",
 			Pred: "name=x", Spref: "/", Dpref: "/", Depth: 1},
+		&Msg{Op: Tnotify, Fsys: "main", Path: "/a", Pred: "name=x"},
+		&Msg{Op: Tlock, Fsys: "main", Path: "/a", Owner: "ix", Tmout: time.Second},
+		&Msg{Op: Tunlock, Fsys: "main", Path: "/a", Owner: "ix"},
+		&Msg{Op: Tsymlink, Fsys: "main", Path: "/a", To: "b/c"},
+		&Msg{Op: Tlstat, Fsys: "main", Path: "/a"},
+		&Msg{Op: Tcopy, Fsys: "main", Path: "/a", To: "/b", ToFs: "other"},
+		&Msg{Op: Tstats, Fsys: "main", Paths: []string{"/a", "/b"}},
+		&Msg{Op: Tgrep, Fsys: "main", Path: "/a", Pred: "type=-", Rexp: "x", Nctx: 1},
+		&Msg{Op: Tsums, Fsys: "main", Path: "/a", Count: 1024},
+		&Msg{Op: Tcompress, Fsys: "main", Paths: []string{"zstd", "flate"}},
+		&Msg{Op: Tcheck, Fsys: "main"},
 	}
 	omsgs = [...]string{
 		`Ttrees`,
@@ -68,6 +85,17 @@ var (
 		`Twstat 'main' '/a' d <type:"d" mode:"0755"> `,
 		`Tfind 'main' '/a' pred 'name=x' spref '/' dpref '/' depth 1`,
 		`Tfindget 'main' '/a' pred 'name=x' spref '/' dpref '/' depth 1`,
+		`Tnotify 'main' '/a' pred 'name=x'`,
+		`Tlock 'main' '/a' owner 'ix' tmout 1s`,
+		`Tunlock 'main' '/a' owner 'ix'`,
+		`Tsymlink 'main' '/a' to 'b/c'`,
+		`Tlstat 'main' '/a'`,
+		`Tcopy 'main' '/a' to '/b' tofs 'other'`,
+		`Tstats 'main' '' paths ["/a" "/b"]`,
+		`Tgrep 'main' '/a' pred 'type=-' rexp 'x' nctx 1`,
+		`Tsums 'main' '/a' count 1024`,
+		`Tcompress 'main' '' paths ["zstd" "flate"]`,
+		`Tcheck 'main' ''`,
 	}
 )
 
@@ -94,6 +122,11 @@ func TestProto(t *testing.T) {
 }
 
 func runTest(t *testing.T, fn fstest.TestFunc) {
+	runSrvTest(t, nil, fn)
+}
+
+// Like runTest, calling setup (if not nil) to configure the server.
+func runSrvTest(t *testing.T, setup func(*Server) error, fn fstest.TestFunc) {
 	os.Remove("/tmp/clive.9898")
 	defer os.Remove("/tmp/clive.9898")
 	os.Args[0] = "rzx.test"
@@ -121,6 +154,11 @@ func runTest(t *testing.T, fn fstest.TestFunc) {
 	if err := srv.Serve("tree", fs); err != nil {
 		t.Fatal(err)
 	}
+	if setup != nil {
+		if err := setup(srv); err != nil {
+			t.Fatal(err)
+		}
+	}
 	rfs, err := Dial("unix!local!9898", ccfg)
 	if err != nil {
 		t.Fatal(err)
@@ -158,6 +196,10 @@ func TestGets(t *testing.T) {
 	runTest(t, fstest.Gets)
 }
 
+func TestDirPages(t *testing.T) {
+	runTest(t, fstest.DirPages)
+}
+
 func TestFinds(t *testing.T) {
 	runTest(t, fstest.Finds)
 }
@@ -170,6 +212,22 @@ func TestPuts(t *testing.T) {
 	runTest(t, fstest.Puts)
 }
 
+func TestAppends(t *testing.T) {
+	runTest(t, fstest.Appends)
+}
+
+func TestCopies(t *testing.T) {
+	runTest(t, fstest.Copies)
+}
+
+func TestGreps(t *testing.T) {
+	runTest(t, fstest.Greps)
+}
+
+func TestSums(t *testing.T) {
+	runTest(t, fstest.Sums)
+}
+
 func TestMkdirs(t *testing.T) {
 	runTest(t, fstest.Mkdirs)
 }
@@ -186,6 +244,10 @@ func TestAttrs(t *testing.T) {
 	runTest(t, fstest.Attrs)
 }
 
+func TestUserAttrs(t *testing.T) {
+	runTest(t, fstest.UserAttrs)
+}
+
 func TestMoves(t *testing.T) {
 	runTest(t, fstest.Moves)
 }
@@ -193,3 +255,478 @@ func TestMoves(t *testing.T) {
 func TestAsAFile(t *testing.T) {
 	runTest(t, fstest.AsAFile)
 }
+
+func TestMultiStats(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		paths := append([]string{}, fstest.AllFiles...)
+		paths = append(paths, fstest.NotThere...)
+		i := 0
+		for d := range fs.Stats(paths...) {
+			if i >= len(paths) {
+				t.Fatalf("too many entries")
+			}
+			fstest.Printf("%s\n", d.LongFmt())
+			if d["path"] != paths[i] {
+				t.Fatalf("got %s for %s", d["path"], paths[i])
+			}
+			if (d["err"] != "") != (i >= len(fstest.AllFiles)) {
+				t.Fatalf("%s: err %q", paths[i], d["err"])
+			}
+			i++
+		}
+		if i != len(paths) {
+			t.Fatalf("got %d entries", i)
+		}
+	})
+}
+
+func TestCompress(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		if fs.zip != "" {
+			t.Fatalf("compressing a unix socket")
+		}
+		fs.nozip = false
+		if err := fs.Redial(); err != nil {
+			t.Fatalf("redial: %s", err)
+		}
+		if fs.zip != ch.ZipAlgs[0] {
+			t.Fatalf("compression is '%s'", fs.zip)
+		}
+		fstest.Gets(t, fs)
+		fstest.FindGets(t, fs)
+	})
+}
+
+func TestChecks(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		fs.check = true
+		if err := fs.Redial(); err != nil {
+			t.Fatalf("redial: %s", err)
+		}
+		if !fs.checked {
+			t.Fatalf("not checked")
+		}
+		fstest.Gets(t, fs)
+		fstest.Puts(t, fs)
+
+		c := fs.rpc()
+		c.Out <- &Msg{Op: Tput, Fsys: fs.fsys, Path: "/bad",
+			D: zx.Dir{"type": "-", "mode": "0644"},
+		}
+		c.Out <- []byte("some data")
+		c.Out <- zx.ChunkSum([]byte("other data"))
+		close(c.Out)
+		for range c.In {
+		}
+		err := cerror(c.In)
+		fstest.Printf("bad put: %v\n", err)
+		if err == nil || !strings.Contains(err.Error(), zx.ErrCorrupt.Error()) {
+			t.Fatalf("corrupt put did not fail")
+		}
+	})
+}
+
+func TestSymlinks(t *testing.T) {
+	runTest(t, fstest.Symlinks)
+}
+
+func TestWatches(t *testing.T) {
+	runTest(t, fstest.Watches)
+}
+
+func TestLocks(t *testing.T) {
+	runTest(t, fstest.Locks)
+}
+
+func TestParseQuota(t *testing.T) {
+	q, err := ParseQuota("bytes=2k files=10 ubytes=1M ufiles=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q != (Quota{2048, 10, 1024 * 1024, 3}) {
+		t.Fatalf("bad quota %s", q)
+	}
+	if _, err := ParseQuota("bytes=x"); err == nil {
+		t.Fatalf("bad value did parse")
+	}
+	if _, err := ParseQuota("foo=1"); err == nil {
+		t.Fatalf("bad limit did parse")
+	}
+}
+
+func TestQuota(t *testing.T) {
+	var nb int64
+	for _, f := range fstest.Files {
+		nb += int64(len(fstest.FileData[f]))
+	}
+	nf := int64(len(fstest.AllFiles) - 1)
+	q := Quota{Bytes: nb + 100, Files: nf + 2}
+	setup := func(s *Server) error {
+		return s.SetQuota("tree", q)
+	}
+	runSrvTest(t, setup, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		isquota := func(err error) bool {
+			return err != nil && strings.Contains(err.Error(), ErrQuota.Error())
+		}
+		if err := zx.PutAll(fs, "/n1", make([]byte, 50)); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		err := zx.PutAll(fs, "/n2", make([]byte, 100))
+		fstest.Printf("put over quota: %v\n", err)
+		if !isquota(err) {
+			t.Fatalf("put over quota: %v", err)
+		}
+		<-fs.Remove("/n2")
+		mkdir := func(p string) error {
+			rc := fs.Put(p, md, 0, nil)
+			<-rc
+			return cerror(rc)
+		}
+		if err := mkdir("/d2"); err != nil {
+			t.Fatalf("mkdir: %s", err)
+		}
+		err = mkdir("/d3")
+		fstest.Printf("mkdir over quota: %v\n", err)
+		if !isquota(err) {
+			t.Fatalf("mkdir over quota: %v", err)
+		}
+		ctl, err := zx.GetAll(fs, "/Ctl")
+		if err != nil {
+			t.Fatalf("ctl: %s", err)
+		}
+		fstest.Printf("ctl:\n%s\n", ctl)
+		st := fmt.Sprintf("quota tree tree: %d bytes %d files", nb+50, nf+2)
+		if !strings.Contains(string(ctl), st) {
+			t.Fatalf("ctl has no '%s'", st)
+		}
+		if err := <-fs.Remove("/n1"); err != nil {
+			t.Fatalf("remove: %s", err)
+		}
+		if err := zx.PutAll(fs, "/n3", make([]byte, 80)); err != nil {
+			t.Fatalf("put after remove: %s", err)
+		}
+	})
+}
+
+type lines chan string
+
+func (lc lines) Write(b []byte) (int, error) {
+	lc <- string(b)
+	return len(b), nil
+}
+
+func TestAudit(t *testing.T) {
+	lc := make(lines, 10)
+	setup := func(s *Server) error {
+		return s.Audit(lc, "op=move|op=remove")
+	}
+	runSrvTest(t, setup, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		if err := zx.PutAll(fs, "/n1", []byte("data")); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		if err := <-fs.Move("/n1", "/n2"); err != nil {
+			t.Fatalf("move: %s", err)
+		}
+		if err := <-fs.Remove("/n2"); err != nil {
+			t.Fatalf("remove: %s", err)
+		}
+		if err := <-fs.Remove("/n2"); err == nil {
+			t.Fatalf("could remove twice")
+		}
+		recs := [][]string{
+			{" move " + u.Uid + " ", ` tree "/n1" "/n2" ok`},
+			{" remove " + u.Uid + " ", ` tree "/n2" ok`},
+			{" remove " + u.Uid + " ", ` tree "/n2" err "`},
+		}
+		for _, rec := range recs {
+			var ln string
+			select {
+			case ln = <-lc:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no audit record")
+			}
+			fstest.Printf("audit: %s", ln)
+			for _, s := range rec {
+				if !strings.Contains(ln, s) {
+					t.Fatalf("bad record %q", ln)
+				}
+			}
+		}
+		select {
+		case ln := <-lc:
+			t.Fatalf("unexpected record %q", ln)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestExports(t *testing.T) {
+	setup := func(s *Server) error {
+		ofs, err := zux.NewZX(tdir)
+		if err != nil {
+			return err
+		}
+		if err := s.Serve("other", ofs); err != nil {
+			return err
+		}
+		if err := s.Export("other", "nobody in here", "/"); err != nil {
+			return err
+		}
+		return s.Export("tree", u.Uid, "/a")
+	}
+	// runSrvTest checks that "other" is not seen.
+	runSrvTest(t, setup, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		ds, err := zx.GetDir(fs, "/")
+		if err != nil || len(ds) != 3 {
+			t.Fatalf("getdir: %v %v", ds, err)
+		}
+		for _, d := range ds {
+			fstest.Printf("%s\n", d.Fmt())
+		}
+		if ds[0]["path"] != "/a1" || ds[2]["path"] != "/b" {
+			t.Fatalf("bad entries")
+		}
+		if d, err := zx.Stat(fs, "/"); err != nil || d["path"] != "/" {
+			t.Fatalf("stat /: %v %v", d, err)
+		}
+		for _, p := range []string{"/1", "/../1", "/a/a1"} {
+			if _, err := zx.Stat(fs, p); !zx.IsNotExist(err) {
+				t.Fatalf("stat %s: %v", p, err)
+			}
+		}
+		if err := zx.PutAll(fs, "/x", []byte("x")); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		if _, err := os.Stat(tdir + "/a/x"); err != nil {
+			t.Fatalf("put not in subtree: %s", err)
+		}
+		if err := <-fs.RemoveAll("/"); err == nil {
+			t.Fatalf("could remove /")
+		}
+		var ps []string
+		for d := range fs.Find("/", "type=-", "/", "/", 0) {
+			ps = append(ps, d["path"])
+		}
+		if strings.Join(ps, " ") != "/a1 /a2 /b/c/c3 /x" {
+			t.Fatalf("find: %v", ps)
+		}
+		// links can't be used to escape the subtree
+		if err := <-fs.Symlink("/", "/l"); err != nil {
+			t.Fatalf("symlink: %s", err)
+		}
+		if _, err := zx.Stat(fs, "/l/a1"); err != nil {
+			t.Fatalf("stat /l/a1: %s", err)
+		}
+		if _, err := zx.Stat(fs, "/l/1"); !zx.IsNotExist(err) {
+			t.Fatalf("stat /l/1: %v", err)
+		}
+		for _, tp := range []string{"../1", "b/../../1", "/../1"} {
+			err := <-fs.Symlink(tp, "/l2")
+			if _, serr := zx.Stat(fs, "/l2"); serr == nil {
+				t.Fatalf("symlink %s: escaped (%v)", tp, err)
+			}
+			<-fs.Remove("/l2")
+		}
+		// nor can links found in it, made by others or moved into it
+		if err := os.Symlink("..", tdir+"/a/up"); err != nil {
+			t.Fatalf("symlink: %s", err)
+		}
+		if _, err := zx.Stat(fs, "/up/1"); err == nil {
+			t.Fatalf("could stat /up/1")
+		}
+		if _, err := zx.GetAll(fs, "/up/1"); err == nil {
+			t.Fatalf("could get /up/1")
+		}
+		if err := zx.PutAll(fs, "/up/x", []byte("x")); err == nil {
+			t.Fatalf("could put /up/x")
+		}
+		dc := fs.Find("/up", "", "/", "/", 0)
+		for d := range dc {
+			t.Fatalf("could find %s", d["path"])
+		}
+		if cerror(dc) == nil {
+			t.Fatalf("could find at /up")
+		}
+		if err := <-fs.Move("/up/1", "/n"); err == nil {
+			t.Fatalf("could move /up/1")
+		}
+		if err := <-fs.Remove("/up/1"); err == nil {
+			t.Fatalf("could remove /up/1")
+		}
+		if _, err := os.Stat(tdir + "/1"); err != nil {
+			t.Fatalf("/1: %s", err)
+		}
+		if err := <-fs.Remove("/up"); err != nil {
+			t.Fatalf("remove /up: %s", err)
+		}
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	var srv *Server
+	setup := func(s *Server) error {
+		srv = s
+		return s.ServeMetrics("localhost:9899")
+	}
+	runSrvTest(t, setup, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		if err := zx.PutAll(fs, "/n1", []byte("data")); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		if _, err := zx.Stat(fs, "/nothere"); err == nil {
+			t.Fatalf("stat did not fail")
+		}
+		// requests are accounted after replying, so wait a bit
+		wants := []string{
+			`rzx_ops_total{fsys="tree",op="put"} 1`,
+			`rzx_op_errors_total{fsys="tree",op="stat"} 1`,
+			`rzx_op_latency_seconds{fsys="tree",op="put",quantile="0.99"} `,
+			`rzx_auth_failures_total 0`,
+			`rzx_tree_calls_total{fsys="tree",call="puts"} 1`,
+		}
+		var ms, missing string
+		for i := 0; i < 100; i++ {
+			ms, missing = srv.Metrics(), ""
+			for _, s := range wants {
+				if !strings.Contains(ms, s) {
+					missing = s
+				}
+			}
+			if missing == "" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		fstest.Printf("metrics:\n%s", ms)
+		if missing != "" {
+			t.Fatalf("no %q in metrics", missing)
+		}
+		resp, err := http.Get("http://localhost:9899/health")
+		if err != nil {
+			t.Fatalf("health: %s", err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "tree tree: ok") {
+			t.Fatalf("health: %s %q", resp.Status, b)
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	var srv *Server
+	setup := func(s *Server) error {
+		srv = s
+		return nil
+	}
+	runSrvTest(t, setup, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		srv.RateLimit("", Rate{Ops: 5})
+		if err := fs.Redial(); err != nil {
+			t.Fatalf("redial: %s", err)
+		}
+		nthr := 0
+		for i := 0; i < 20; i++ {
+			_, err := zx.Stat(fs, "/a")
+			if err != nil && strings.Contains(err.Error(), ErrThrottled.Error()) {
+				nthr++
+			} else if err != nil {
+				t.Fatalf("stat: %s", err)
+			}
+		}
+		fstest.Printf("%d stats throttled\n", nthr)
+		if nthr == 0 {
+			t.Fatalf("not throttled")
+		}
+		time.Sleep(time.Second)
+		if _, err := zx.Stat(fs, "/a"); err != nil {
+			t.Fatalf("stat after a while: %s", err)
+		}
+
+		srv.RateLimit(u.Uid, Rate{Bytes: 64 * 1024})
+		if err := fs.Redial(); err != nil {
+			t.Fatalf("redial: %s", err)
+		}
+		t0 := time.Now()
+		if err := zx.PutAll(fs, "/big", make([]byte, 128*1024)); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		el := time.Since(t0)
+		fstest.Printf("put took %v\n", el)
+		if el < 800*time.Millisecond {
+			t.Fatalf("not slowed down")
+		}
+	})
+}
+
+func TestStripedGets(t *testing.T) {
+	osz, omin := StripeSize, StripeMin
+	StripeSize, StripeMin = 64*1024, 128*1024
+	defer func() {
+		StripeSize, StripeMin = osz, omin
+	}()
+	runTest(t, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		dat := make([]byte, 1000*1000)
+		for i := range dat {
+			dat[i] = byte(i % 251)
+		}
+		if err := zx.PutAll(fs, "/big", dat); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		fs.stripes = 4
+		defer func() {
+			fs.stripes = 0
+		}()
+		got, err := zx.GetAll(fs, "/big")
+		if err != nil || !bytes.Equal(got, dat) {
+			t.Fatalf("get: %d bytes %v", len(got), err)
+		}
+		var buf bytes.Buffer
+		gc := fs.Get("/big", 1000, 300*1000)
+		for x := range gc {
+			buf.Write(x)
+		}
+		if err := cerror(gc); err != nil || !bytes.Equal(buf.Bytes(), dat[1000:301000]) {
+			t.Fatalf("get range: %d bytes %v", buf.Len(), err)
+		}
+		// small files are not striped
+		fstest.Gets(t, fs)
+	})
+}
+
+func TestFindPreds(t *testing.T) {
+	runTest(t, func(t fstest.Fataler, xfs zx.Fs) {
+		fs := xfs.(*Fs)
+		if err := zx.PutAll(fs, "/a/hello", []byte("hello world\n")); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		find := func(pred string) []string {
+			var ps []string
+			dc := fs.Find("/", pred, "/", "/", 0)
+			for d := range dc {
+				if d["err"] == "" {
+					ps = append(ps, d["path"])
+				}
+			}
+			if err := cerror(dc); err != nil {
+				t.Fatalf("find %s: %s", pred, err)
+			}
+			return ps
+		}
+		ps := find(`type=-&data~~wor.d`)
+		if len(ps) != 1 || ps[0] != "/a/hello" {
+			t.Fatalf("data find: %v", ps)
+		}
+		ps = find(`type=-&mtime<1h&size<1k&name!~'*[0-9]*'`)
+		if len(ps) != 1 || ps[0] != "/a/hello" {
+			t.Fatalf("find: %v", ps)
+		}
+	})
+}
@@ -0,0 +1,386 @@
+package rzx
+
+import (
+	"clive/zx"
+	"fmt"
+	fpath "path"
+	"time"
+)
+
+// A subtree of a served tree, seen as a tree of its own by
+// users restricted to it (see Server.Export).
+// Paths can't walk out of it, and links made through it can't
+// refer to files out of it.
+// Links found in it are resolved here, and those referring to
+// files out of it can't be used, for they may be moved into it
+// (or made by others) and their targets are not trusted.
+struct subFs {
+	fs   zx.Fs
+	root string
+}
+
+func newSubFs(fs zx.Fs, root string) *subFs {
+	return &subFs{fs: fs, root: root}
+}
+
+func (s *subFs) String() string {
+	return fmt.Sprintf("%s!%s", s.fs, s.root)
+}
+
+// Path in the underlying tree for p
+func (s *subFs) path(p string) string {
+	return fpath.Join(s.root, fpath.Clean("/"+p))
+}
+
+// Path in the underlying tree for p, with links resolved (but for
+// the last one if follow is not set), checking that it stays in the subtree.
+// Missing files are not resolved, to let them be created.
+func (s *subFs) rpath(p string, follow bool) (string, error) {
+	lfs, ok := s.fs.(zx.Symlinker)
+	if !ok {
+		return s.path(p), nil
+	}
+	rp, els := s.root, zx.Elems(fpath.Clean("/"+p))
+	for n := 0; len(els) > 0; {
+		np := fpath.Join(rp, els[0])
+		if !follow && len(els) == 1 {
+			return np, nil
+		}
+		d, err := zx.Lstat(lfs, np)
+		if zx.IsNotExist(err) {
+			return fpath.Join(append([]string{np}, els[1:]...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if d["type"] != "l" {
+			rp, els = np, els[1:]
+			continue
+		}
+		if n++; n > 255 {
+			return "", fmt.Errorf("%s: too many links", p)
+		}
+		t := d["target"]
+		if !fpath.IsAbs(t) {
+			t = fpath.Join(rp, t)
+		}
+		st := zx.Suffix(t, s.root)
+		if st == "" {
+			return "", fmt.Errorf("%s: %s", p, zx.ErrPerm)
+		}
+		rp, els = s.root, append(zx.Elems(st), els[1:]...)
+	}
+	return rp, nil
+}
+
+// Rewrite d from the underlying tree to refer to the subtree.
+func (s *subFs) dir(d zx.Dir) zx.Dir {
+	if d == nil || d["path"] == "" {
+		return d
+	}
+	p := zx.Suffix(d["path"], s.root)
+	if p == "" {
+		return d
+	}
+	d["path"] = p
+	if p == "/" {
+		d["name"] = "/"
+	}
+	if t := d["target"]; fpath.IsAbs(t) {
+		if t = zx.Suffix(t, s.root); t != "" {
+			d["target"] = t
+		}
+	}
+	return d
+}
+
+// Rewrite the dirs from c to refer to the subtree.
+// If p is not "", they are reported at p, for they are the entry for
+// the file at p in the subtree, perhaps found through links.
+func (s *subFs) dirs(c <-chan zx.Dir, p string) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		for d := range c {
+			d = s.dir(d)
+			if p != "" && d != nil {
+				d["path"] = fpath.Clean("/" + p)
+				d["name"] = fpath.Base(d["path"])
+			}
+			if ok := rc <- d; !ok {
+				close(c, cerror(rc))
+				return
+			}
+		}
+		close(rc, cerror(c))
+	}()
+	return rc
+}
+
+func errc(err error) <-chan error {
+	c := make(chan error, 1)
+	c <- err
+	close(c, err)
+	return c
+}
+
+func errdc(err error) <-chan zx.Dir {
+	c := make(chan zx.Dir)
+	close(c, err)
+	return c
+}
+
+func (s *subFs) Stat(p string) <-chan zx.Dir {
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		return errdc(err)
+	}
+	return s.dirs(s.fs.Stat(rp), p)
+}
+
+func (s *subFs) Get(p string, off, count int64) <-chan []byte {
+	xfs, ok := s.fs.(zx.Getter)
+	if !ok {
+		c := make(chan []byte)
+		close(c, zx.ErrBug)
+		return c
+	}
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		c := make(chan []byte)
+		close(c, err)
+		return c
+	}
+	d, err := zx.Stat(s.fs, rp)
+	if err != nil || d["type"] != "d" {
+		return xfs.Get(rp, off, count)
+	}
+	p = fpath.Clean("/" + p)
+	c := xfs.Get(rp, off, count)
+	rc := make(chan []byte)
+	go func() {
+		for x := range c {
+			if _, d, err := zx.UnpackDir(x); err == nil && d != nil {
+				d = s.dir(d)
+				d["path"] = fpath.Join(p, d["name"])
+				x = d.Bytes()
+			}
+			if ok := rc <- x; !ok {
+				close(c, cerror(rc))
+				return
+			}
+		}
+		close(rc, cerror(c))
+	}()
+	return rc
+}
+
+func (s *subFs) Put(p string, d zx.Dir, off int64, dc <-chan []byte) <-chan zx.Dir {
+	xfs, ok := s.fs.(zx.Putter)
+	if !ok {
+		return errdc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		if dc != nil {
+			close(dc, err)
+		}
+		return errdc(err)
+	}
+	return s.dirs(xfs.Put(rp, d, off, dc), p)
+}
+
+func (s *subFs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
+	xfs, ok := s.fs.(zx.Wstater)
+	if !ok {
+		return errdc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		return errdc(err)
+	}
+	return s.dirs(xfs.Wstat(rp, d), p)
+}
+
+func (s *subFs) Remove(p string) <-chan error {
+	xfs, ok := s.fs.(zx.Remover)
+	if !ok {
+		return errc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, false)
+	if err != nil {
+		return errc(err)
+	}
+	if rp == s.root {
+		return errc(fmt.Errorf("%s: %s", p, zx.ErrPerm))
+	}
+	return xfs.Remove(rp)
+}
+
+func (s *subFs) RemoveAll(p string) <-chan error {
+	xfs, ok := s.fs.(zx.Remover)
+	if !ok {
+		return errc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, false)
+	if err != nil {
+		return errc(err)
+	}
+	if rp == s.root {
+		return errc(fmt.Errorf("%s: %s", p, zx.ErrPerm))
+	}
+	return xfs.RemoveAll(rp)
+}
+
+func (s *subFs) Move(from, to string) <-chan error {
+	xfs, ok := s.fs.(zx.Mover)
+	if !ok {
+		return errc(zx.ErrBug)
+	}
+	rfrom, err := s.rpath(from, false)
+	if err != nil {
+		return errc(err)
+	}
+	rto, err := s.rpath(to, false)
+	if err != nil {
+		return errc(err)
+	}
+	if rfrom == s.root || rto == s.root {
+		return errc(fmt.Errorf("%s: %s", from, zx.ErrPerm))
+	}
+	return xfs.Move(rfrom, rto)
+}
+
+func (s *subFs) Link(oldp, newp string) <-chan error {
+	xfs, ok := s.fs.(zx.Linker)
+	if !ok {
+		return errc(zx.ErrBug)
+	}
+	roldp, err := s.rpath(oldp, false)
+	if err != nil {
+		return errc(err)
+	}
+	rnewp, err := s.rpath(newp, false)
+	if err != nil {
+		return errc(err)
+	}
+	return xfs.Link(roldp, rnewp)
+}
+
+func (s *subFs) Symlink(target, newp string) <-chan error {
+	xfs, ok := s.fs.(zx.Symlinker)
+	if !ok {
+		return errc(zx.ErrBug)
+	}
+	rnewp, err := s.rpath(newp, false)
+	if err != nil {
+		return errc(err)
+	}
+	// absolute targets refer to the subtree, and relative ones
+	// can't leave it.
+	if fpath.IsAbs(target) {
+		target = s.path(target)
+	} else {
+		t := fpath.Join(fpath.Dir(rnewp), target)
+		if zx.Suffix(t, s.root) == "" {
+			return errc(fmt.Errorf("symlink %s: %s: %s", newp, target, zx.ErrPerm))
+		}
+	}
+	return xfs.Symlink(target, rnewp)
+}
+
+func (s *subFs) Lstat(p string) <-chan zx.Dir {
+	xfs, ok := s.fs.(zx.Symlinker)
+	if !ok {
+		return s.Stat(p)
+	}
+	rp, err := s.rpath(p, false)
+	if err != nil {
+		return errdc(err)
+	}
+	return s.dirs(xfs.Lstat(rp), p)
+}
+
+// The underlying tree finds at the resolved path for p, and reports
+// the files found as they are seen through p, using spref and dpref.
+// Predicates on paths refer to the subtree.
+func (s *subFs) Find(p, pred, spref, dpref string, depth0 int) <-chan zx.Dir {
+	xfs, ok := s.fs.(zx.Finder)
+	if !ok {
+		return errdc(zx.ErrBug)
+	}
+	rp, dp, err := s.findPaths(p, spref, dpref)
+	if err != nil {
+		return errdc(err)
+	}
+	return xfs.Find(rp, pred, rp, dp, depth0)
+}
+
+func (s *subFs) FindGet(p, pred, spref, dpref string, depth0 int) <-chan face{} {
+	xfs, ok := s.fs.(zx.FindGetter)
+	if !ok {
+		c := make(chan face{})
+		close(c, zx.ErrBug)
+		return c
+	}
+	rp, dp, err := s.findPaths(p, spref, dpref)
+	if err != nil {
+		c := make(chan face{})
+		close(c, err)
+		return c
+	}
+	return xfs.FindGet(rp, pred, rp, dp, depth0)
+}
+
+// Return the resolved path for a find at p, and the path
+// for p once spref is replaced with dpref in it.
+func (s *subFs) findPaths(p, spref, dpref string) (string, string, error) {
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		return "", "", err
+	}
+	dp := fpath.Clean("/" + p)
+	if spref == "" {
+		return rp, dp, nil
+	}
+	suff := zx.Suffix(dp, spref)
+	if suff == "" {
+		return "", "", fmt.Errorf("%s: %s: %s", spref, dp, zx.ErrNotSuffix)
+	}
+	return rp, fpath.Join(dpref, suff), nil
+}
+
+func (s *subFs) Watch(p, pred string) <-chan zx.Dir {
+	xfs, ok := s.fs.(zx.Watcher)
+	if !ok {
+		return errdc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		return errdc(err)
+	}
+	return s.dirs(xfs.Watch(rp, pred), "")
+}
+
+func (s *subFs) Lock(p, owner string, tmout time.Duration) <-chan zx.Dir {
+	xfs, ok := s.fs.(zx.Locker)
+	if !ok {
+		return errdc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		return errdc(err)
+	}
+	return s.dirs(xfs.Lock(rp, owner, tmout), p)
+}
+
+func (s *subFs) Unlock(p, owner string) <-chan error {
+	xfs, ok := s.fs.(zx.Locker)
+	if !ok {
+		return errc(zx.ErrBug)
+	}
+	rp, err := s.rpath(p, true)
+	if err != nil {
+		return errc(err)
+	}
+	return xfs.Unlock(rp, owner)
+}
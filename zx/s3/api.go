@@ -0,0 +1,309 @@
+package s3
+
+import (
+	"bytes"
+	"clive/zx"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Objects listed in a bucket
+struct object {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+struct cprefix {
+	Prefix string
+}
+
+struct listResult {
+	Contents              []object  `xml:"Contents"`
+	Prefixes              []cprefix `xml:"CommonPrefixes"`
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+struct s3Error {
+	Code, Message string
+}
+
+struct initResult {
+	UploadId string
+}
+
+struct part {
+	PartNumber int
+	ETag       string
+}
+
+struct completeReq {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []part   `xml:"Part"`
+}
+
+func query(q url.Values) string {
+	var qs []string
+	for n, vs := range q {
+		for _, v := range vs {
+			if v == "" {
+				qs = append(qs, uriEncode(n, false))
+			} else {
+				qs = append(qs, uriEncode(n, false)+"="+uriEncode(v, false))
+			}
+		}
+	}
+	sort.Strings(qs)
+	return strings.Join(qs, "&")
+}
+
+// Issue a request for the object with the given key (the bucket if it's "")
+// and return the response if it's a success.
+// Errors include zx.ErrNotExist and zx.ErrPerm when the object is
+// not there or can't be accessed.
+func (fs *Fs) req(method, key string, q url.Values, hdr http.Header, body []byte) (*http.Response, error) {
+	p := "/" + fs.bucket
+	if key != "" {
+		p += "/" + key
+	}
+	u := &url.URL{
+		Scheme:   fs.scheme,
+		Host:     fs.host,
+		Path:     p,
+		RawPath:  uriEncode(p, true),
+		RawQuery: query(q),
+	}
+	r, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for n, v := range hdr {
+		r.Header[n] = v
+	}
+	r.ContentLength = int64(len(body))
+	if fs.keys.Id != "" {
+		fs.keys.sign(r, hexHash(body), time.Now())
+	}
+	fs.Dprintf("%s %s %s\n", method, p, r.URL.RawQuery)
+	resp, err := fs.c.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", zx.ErrIO, err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var e s3Error
+	if method != "HEAD" {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		xml.Unmarshal(b, &e)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, zx.ErrNotExist
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, zx.ErrPerm
+	case e.Code != "":
+		return nil, fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+	default:
+		return nil, fmt.Errorf("s3: %s", resp.Status)
+	}
+}
+
+// Like req, but decode the xml reply into v, if not nil.
+func (fs *Fs) xreq(method, key string, q url.Values, hdr http.Header, body []byte, v face{}) (http.Header, error) {
+	resp, err := fs.req(method, key, q, hdr, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return resp.Header, nil
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", zx.ErrIO, err)
+	}
+	if err := xml.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("s3: %s", err)
+	}
+	return resp.Header, nil
+}
+
+func (fs *Fs) head(key string) (http.Header, error) {
+	return fs.xreq("HEAD", key, nil, nil, nil, nil)
+}
+
+// Return a reader for count bytes at off in the object (all if count < 0).
+func (fs *Fs) getObj(key string, off, count int64) (io.ReadCloser, error) {
+	hdr := http.Header{}
+	switch {
+	case count == 0:
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	case count > 0:
+		hdr.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+count-1))
+	case off > 0:
+		hdr.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := fs.req("GET", key, nil, hdr, nil)
+	if err != nil && strings.Contains(err.Error(), "InvalidRange") {
+		// reading at or past the end
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (fs *Fs) getAll(key string) ([]byte, error) {
+	rc, err := fs.getObj(key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (fs *Fs) putObj(key string, hdr http.Header, data []byte) error {
+	_, err := fs.xreq("PUT", key, nil, hdr, data, nil)
+	return err
+}
+
+// Copy the object at from to be at to, with the metadata in hdr
+// if replace is set or with that of from otherwise.
+func (fs *Fs) copyObj(from, to string, hdr http.Header, replace bool) error {
+	h := http.Header{}
+	for n, v := range hdr {
+		h[n] = v
+	}
+	h.Set("X-Amz-Copy-Source", uriEncode("/"+fs.bucket+"/"+from, true))
+	if replace {
+		h.Set("X-Amz-Metadata-Directive", "REPLACE")
+	}
+	var e s3Error
+	// copies may fail after the 200 status is sent
+	_, err := fs.xreq("PUT", to, nil, h, nil, &e)
+	if err == nil && e.Code != "" {
+		err = fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+	}
+	return err
+}
+
+func (fs *Fs) delObj(key string) error {
+	_, err := fs.xreq("DELETE", key, nil, nil, nil, nil)
+	return err
+}
+
+// List the objects with the given prefix, up to delim, if not empty.
+// Objects not listed because of delim are reported as prefixes.
+// At most max entries are returned if max > 0.
+func (fs *Fs) list(prefix, delim string, max int) ([]object, []string, error) {
+	var objs []object
+	var prefs []string
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("prefix", prefix)
+	if delim != "" {
+		q.Set("delimiter", delim)
+	}
+	if max > 0 {
+		q.Set("max-keys", strconv.Itoa(max))
+	}
+	for {
+		var lr listResult
+		if _, err := fs.xreq("GET", "", q, nil, nil, &lr); err != nil {
+			return nil, nil, err
+		}
+		objs = append(objs, lr.Contents...)
+		for _, p := range lr.Prefixes {
+			prefs = append(prefs, p.Prefix)
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" || max > 0 {
+			return objs, prefs, nil
+		}
+		q.Set("continuation-token", lr.NextContinuationToken)
+	}
+}
+
+// Upload the data sent through dc as the object for key, using a
+// multipart upload if there's more than partSz bytes.
+func (fs *Fs) upload(key string, hdr http.Header, dc <-chan []byte) error {
+	var buf bytes.Buffer
+	var id string
+	var parts []part
+	abort := func(err error) error {
+		if id != "" {
+			q := url.Values{}
+			q.Set("uploadId", id)
+			fs.xreq("DELETE", key, q, nil, nil, nil)
+		}
+		return err
+	}
+	send := func(b []byte) error {
+		if id == "" {
+			q := url.Values{}
+			q.Set("uploads", "")
+			var ir initResult
+			if _, err := fs.xreq("POST", key, q, hdr, nil, &ir); err != nil {
+				return err
+			}
+			id = ir.UploadId
+		}
+		q := url.Values{}
+		q.Set("partNumber", strconv.Itoa(len(parts)+1))
+		q.Set("uploadId", id)
+		h, err := fs.xreq("PUT", key, q, nil, b, nil)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part{PartNumber: len(parts) + 1, ETag: h.Get("ETag")})
+		return nil
+	}
+	if dc != nil {
+		for b := range dc {
+			buf.Write(b)
+			for buf.Len() >= partSz {
+				if err := send(buf.Next(partSz)); err != nil {
+					close(dc, err)
+					return abort(err)
+				}
+			}
+		}
+		if err := cerror(dc); err != nil {
+			return abort(err)
+		}
+	}
+	if id == "" {
+		return fs.putObj(key, hdr, buf.Bytes())
+	}
+	if buf.Len() > 0 {
+		if err := send(buf.Bytes()); err != nil {
+			return abort(err)
+		}
+	}
+	body, err := xml.Marshal(&completeReq{Parts: parts})
+	if err != nil {
+		return abort(err)
+	}
+	q := url.Values{}
+	q.Set("uploadId", id)
+	var e s3Error
+	if _, err := fs.xreq("POST", key, q, nil, body, &e); err != nil {
+		return abort(err)
+	}
+	if e.Code != "" {
+		return abort(fmt.Errorf("s3: %s: %s", e.Code, e.Message))
+	}
+	return nil
+}
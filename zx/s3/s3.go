@@ -0,0 +1,865 @@
+/*
+	ZX trees backed by S3 buckets.
+
+	Objects are files and key prefixes ending in "/" are directories,
+	so that trees stored by other S3 tools can be used.
+	Empty directories are kept as empty objects named after them
+	(with the trailing "/").
+	The mode, mtime, and user attributes for files and directories are
+	kept in the object metadata (as x-amz-meta-zx), but not for the
+	entries listed by Get, because S3 lists no metadata: those have
+	default modes and the mtime of the object.
+
+	Trees can be mounted in name spaces using "s3!" addresses (see
+	FillAddr), and requests are signed with the keys in the environment
+	(see EnvKeys).
+	Large files are uploaded using multipart uploads, and writes that
+	do not replace the whole file rewrite the object.
+*/
+package s3
+
+import (
+	"clive/dbg"
+	"clive/u"
+	"clive/zx"
+	"clive/zx/pred"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	fpath "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A tree in a bucket
+struct Fs {
+	*dbg.Flag
+	*zx.Flags
+	*zx.Stats
+	addr   string // scheme!host!port!bucket[/prefix]
+	scheme string
+	host   string // host:port
+	bucket string
+	pref   string // key prefix for the tree, "" or ending in "/"
+	keys   *Keys
+	c      *http.Client
+}
+
+var (
+	// Size for parts in multipart uploads.
+	// S3 requires at least 5MiB.
+	partSz = 8 * 1024 * 1024
+
+	dials   = map[string]*Fs{}
+	dialslk sync.Mutex
+	_fs     zx.RWFs       = &Fs{}
+	_fs2    zx.Mover      = &Fs{}
+	_fs3    zx.Copier     = &Fs{}
+	_fs4    zx.Finder     = &Fs{}
+	_fs5    zx.FindGetter = &Fs{}
+)
+
+const (
+	metaHdr = "X-Amz-Meta-Zx"
+	msgSz   = 32 * 1024 // size of get messages
+)
+
+func (fs *Fs) String() string {
+	return fs.Tag
+}
+
+// return scheme!host!port!bucket from addr.
+//	bucket -> https!s3.amazonaws.com!443!bucket
+//	host!bucket -> https!host!443!bucket
+//	host!port!bucket -> https!host!port!bucket
+// The bucket may be followed by a key prefix, as in bucket/a/b,
+// to use just the objects under that prefix.
+func FillAddr(addr string) string {
+	toks := strings.Split(addr, "!")
+	switch len(toks) {
+	case 1:
+		return "https!s3.amazonaws.com!443!" + addr
+	case 2:
+		return fmt.Sprintf("https!%s!443!%s", toks[0], toks[1])
+	case 3:
+		return "https!" + addr
+	default:
+		return addr
+	}
+}
+
+// Return a tree for the bucket at addr (completed if needed using
+// FillAddr) using the given keys, or those from EnvKeys if nil.
+// Trees are cached and the previous ones returned for the same address.
+func Dial(addr string, keys *Keys) (*Fs, error) {
+	addr = FillAddr(addr)
+	dialslk.Lock()
+	defer dialslk.Unlock()
+	if fs, ok := dials[addr]; ok {
+		return fs, nil
+	}
+	toks := strings.SplitN(addr, "!", 4)
+	if len(toks) != 4 || toks[3] == "" {
+		return nil, fmt.Errorf("%s: bad s3 address", addr)
+	}
+	if keys == nil {
+		keys = EnvKeys()
+	}
+	bucket, pref := toks[3], ""
+	if n := strings.IndexByte(bucket, '/'); n >= 0 {
+		bucket, pref = bucket[:n], strings.Trim(bucket[n+1:], "/")
+		if pref != "" {
+			pref += "/"
+		}
+	}
+	fs := &Fs{
+		Flag:   &dbg.Flag{Tag: "s3!" + addr},
+		Flags:  &zx.Flags{},
+		Stats:  &zx.Stats{},
+		addr:   addr,
+		scheme: toks[0],
+		host:   net.JoinHostPort(toks[1], toks[2]),
+		bucket: bucket,
+		pref:   pref,
+		keys:   keys,
+		c:      &http.Client{},
+	}
+	fs.Flags.Add("debug", &fs.Debug)
+	fs.Flags.Add("clear", func(...string) error {
+		fs.Stats.Clear()
+		return nil
+	})
+	if _, _, err := fs.list(pref, "/", 1); err != nil {
+		return nil, fmt.Errorf("%s: %s", addr, err)
+	}
+	dials[addr] = fs
+	return fs, nil
+}
+
+// The key for the object at p, which must be absolute.
+func (fs *Fs) key(p string) string {
+	if p == "/" {
+		return strings.TrimSuffix(fs.pref, "/")
+	}
+	return fs.pref + p[1:]
+}
+
+// The prefix for objects in the directory at p.
+func (fs *Fs) dirKey(p string) string {
+	if p == "/" {
+		return fs.pref
+	}
+	return fs.key(p) + "/"
+}
+
+// The path for the object with the given key.
+func (fs *Fs) path(key string) string {
+	return fpath.Join("/", strings.TrimPrefix(key, fs.pref))
+}
+
+func (fs *Fs) newDir(p string, isdir bool, size int64, mt time.Time) zx.Dir {
+	d := zx.Dir{
+		"name": fpath.Base(p),
+		"path": p,
+		"addr": fmt.Sprintf("s3!%s!%s", fs.addr, p),
+		"type": "-",
+		"mode": "0644",
+		"uid":  u.Uid,
+		"gid":  u.Uid,
+		"wuid": u.Uid,
+	}
+	d.SetSize(size)
+	d.SetTime("mtime", mt)
+	if isdir {
+		d["type"] = "d"
+		d["mode"] = "0755"
+		d["size"] = "0"
+	}
+	return d
+}
+
+// Encode the mode, mtime, and user attributes of d as metadata.
+func meta(d zx.Dir) http.Header {
+	md := zx.Dir{}
+	for k, v := range d {
+		if k == "mode" || k == "mtime" || zx.IsUser(k) {
+			md[k] = v
+		}
+	}
+	hdr := http.Header{}
+	hdr.Set(metaHdr, base64.StdEncoding.EncodeToString([]byte(md.String())))
+	return hdr
+}
+
+// Update d with the attributes in the metadata from hdr.
+func setMeta(d zx.Dir, hdr http.Header) {
+	b, err := base64.StdEncoding.DecodeString(hdr.Get(metaHdr))
+	if err != nil || len(b) == 0 {
+		return
+	}
+	md, err := zx.ParseDir(string(b))
+	if err != nil {
+		return
+	}
+	for k, v := range md {
+		d[k] = v
+	}
+}
+
+// Directory entry for the object with the given headers.
+func (fs *Fs) hdrDir(p string, isdir bool, hdr http.Header) zx.Dir {
+	mt, _ := http.ParseTime(hdr.Get("Last-Modified"))
+	var sz int64
+	fmt.Sscan(hdr.Get("Content-Length"), &sz)
+	d := fs.newDir(p, isdir, sz, mt)
+	setMeta(d, hdr)
+	return d
+}
+
+func (fs *Fs) stat(p string) (zx.Dir, error) {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if p == "/" {
+		d := fs.newDir("/", true, 0, time.Unix(0, 0))
+		d["name"] = "/"
+		if fs.pref != "" {
+			if hdr, err := fs.head(fs.pref); err == nil {
+				setMeta(d, hdr)
+			}
+		}
+		return d, nil
+	}
+	hdr, err := fs.head(fs.key(p))
+	if err == nil {
+		return fs.hdrDir(p, false, hdr), nil
+	}
+	if !zx.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %s", p, err)
+	}
+	if hdr, err := fs.head(fs.dirKey(p)); err == nil {
+		return fs.hdrDir(p, true, hdr), nil
+	}
+	objs, prefs, err := fs.list(fs.dirKey(p), "/", 1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p, err)
+	}
+	if len(objs)+len(prefs) == 0 {
+		return nil, fmt.Errorf("%s: %s", p, zx.ErrNotExist)
+	}
+	return fs.newDir(p, true, 0, time.Unix(0, 0)), nil
+}
+
+func (fs *Fs) Stat(p string) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	go func() {
+		fs.Count(zx.Sstat)
+		d, err := fs.stat(p)
+		if err == nil {
+			c <- d
+		}
+		close(c, err)
+	}()
+	return c
+}
+
+// Return the entries for the directory at p, sorted by name.
+func (fs *Fs) getDir(p string) ([]zx.Dir, error) {
+	dk := fs.dirKey(p)
+	objs, prefs, err := fs.list(dk, "/", 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p, err)
+	}
+	ds := make([]zx.Dir, 0, len(objs)+len(prefs))
+	for _, o := range objs {
+		if o.Key == dk {
+			continue // the dir itself
+		}
+		ds = append(ds, fs.newDir(fs.path(o.Key), false, o.Size, o.LastModified))
+	}
+	for _, pk := range prefs {
+		ds = append(ds, fs.newDir(fs.path(pk), true, 0, time.Unix(0, 0)))
+	}
+	zx.SortDirs(ds)
+	return ds, nil
+}
+
+func (fs *Fs) get(p string, off, count int64, c chan<- []byte) error {
+	d, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+	p = d["path"]
+	if d["type"] == "d" {
+		ds, err := fs.getDir(p)
+		if err != nil {
+			return err
+		}
+		for _, cd := range ds {
+			if count == 0 {
+				break
+			}
+			if off > 0 {
+				off--
+				continue
+			}
+			if count > 0 {
+				count--
+			}
+			if ok := c <- cd.Bytes(); !ok {
+				return cerror(c)
+			}
+		}
+		return nil
+	}
+	rc, err := fs.getObj(fs.key(p), off, count)
+	if err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	defer rc.Close()
+	for {
+		b := make([]byte, msgSz)
+		n, err := io.ReadFull(rc, b)
+		if n > 0 {
+			if ok := c <- b[:n]; !ok {
+				return cerror(c)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s: %s", p, zx.ErrIO, err)
+		}
+	}
+}
+
+func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
+	c := make(chan []byte)
+	go func() {
+		fs.Count(zx.Sget)
+		err := fs.get(p, off, count, c)
+		close(c, err)
+	}()
+	return c
+}
+
+// Create the directory p and its parents if they do not exist.
+func (fs *Fs) mkdirAll(p string) error {
+	d, err := fs.stat(p)
+	if err == nil {
+		if d["type"] != "d" {
+			return fmt.Errorf("%s: %s", p, zx.ErrNotDir)
+		}
+		return nil
+	}
+	if err := fs.mkdirAll(fpath.Dir(p)); err != nil {
+		return err
+	}
+	return fs.mkdir(p, zx.Dir{})
+}
+
+// Check that the parent of p exists and is a directory.
+func (fs *Fs) chkParent(p string) error {
+	pd, err := fs.stat(fpath.Dir(p))
+	if err != nil {
+		return err
+	}
+	if pd["type"] != "d" {
+		return fmt.Errorf("%s: %s", pd["path"], zx.ErrNotDir)
+	}
+	return nil
+}
+
+func (fs *Fs) mkdir(p string, d zx.Dir) error {
+	od, err := fs.stat(p)
+	if err == nil && od["type"] != "d" {
+		return fmt.Errorf("%s: %s", p, zx.ErrExists)
+	}
+	if err != nil && !zx.IsNotExist(err) {
+		return err
+	}
+	if err != nil {
+		if err := fs.chkParent(p); err != nil {
+			return err
+		}
+		od = fs.newDir(p, true, 0, time.Now())
+	}
+	for k, v := range d {
+		if k != "type" && k != "size" {
+			od[k] = v
+		}
+	}
+	if d["mtime"] == "" {
+		od.SetTime("mtime", time.Now())
+	}
+	if err := fs.putObj(fs.dirKey(p), meta(od), nil); err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	return nil
+}
+
+// Write the data from c into dat at off,
+// or at the end of dat for each message if off is < 0.
+func write(dat []byte, off int64, c <-chan []byte) []byte {
+	for b := range c {
+		if off < 0 {
+			dat = append(dat, b...)
+			continue
+		}
+		for int64(len(dat)) < off {
+			dat = append(dat, 0)
+		}
+		n := copy(dat[off:], b)
+		dat = append(dat, b[n:]...)
+		off += int64(len(b))
+	}
+	return dat
+}
+
+// Resize dat to size, adding zeros if it grows.
+func resize(dat []byte, size int64) []byte {
+	if int64(len(dat)) >= size {
+		return dat[:size]
+	}
+	return append(dat, make([]byte, size-int64(len(dat)))...)
+}
+
+func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return fmt.Errorf("put %s: %s", p, zx.ErrPerm)
+	}
+	mkall := false
+	if d["type"] == "F" {
+		d["type"] = "-"
+		mkall = true
+	} else if d["type"] == "D" {
+		d["type"] = "d"
+		mkall = true
+	}
+	if mkall {
+		if err := fs.mkdirAll(fpath.Dir(p)); err != nil {
+			return err
+		}
+	}
+	switch d["type"] {
+	case "d":
+		if c != nil {
+			close(c, zx.ErrIsDir)
+		}
+		return fs.mkdir(p, d)
+	case "-", "":
+	default:
+		return zx.ErrBadType
+	}
+	if d["type"] == "-" && d["size"] == "" && off >= 0 {
+		d["size"] = "0"
+	}
+	od, err := fs.stat(p)
+	switch {
+	case err == nil && od["type"] == "d":
+		return fmt.Errorf("%s: %s", p, zx.ErrIsDir)
+	case err != nil && (d["type"] == "" || !zx.IsNotExist(err)):
+		return err
+	case err != nil:
+		if err := fs.chkParent(p); err != nil {
+			return err
+		}
+		od = fs.newDir(p, false, 0, time.Now())
+	}
+	for k, v := range d {
+		if k != "type" && k != "size" {
+			od[k] = v
+		}
+	}
+	if d["mtime"] == "" {
+		od.SetTime("mtime", time.Now())
+	}
+	key := fs.key(p)
+	if off == 0 && d["size"] == "0" {
+		return fs.upload(key, meta(od), c)
+	}
+	// rewrite the object with the new data
+	var dat []byte
+	if od.Size() > 0 && d["size"] != "0" {
+		if dat, err = fs.getAll(key); err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	if d["size"] != "" {
+		dat = resize(dat, d.Size())
+	}
+	if c != nil {
+		dat = write(dat, off, c)
+		if err := cerror(c); err != nil {
+			return err
+		}
+	}
+	nc := make(chan []byte, 1)
+	nc <- dat
+	close(nc)
+	return fs.upload(key, meta(od), nc)
+}
+
+func (fs *Fs) Put(p string, d zx.Dir, off int64, c <-chan []byte) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		fs.Count(zx.Sput)
+		d = d.SysDup()
+		err := fs.put(p, d, off, c)
+		if err != nil && c != nil {
+			close(c, err)
+		}
+		if err == nil {
+			var d zx.Dir
+			d, err = fs.stat(p)
+			if err == nil {
+				rc <- d
+			}
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+// Objects can't be changed, so they are copied onto themselves
+// with the new metadata, or rewritten if the size changes.
+func (fs *Fs) wstat(p string, d zx.Dir) error {
+	od, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+	p = od["path"]
+	if p == "/" && fs.pref == "" {
+		// nowhere to keep them
+		return nil
+	}
+	for k, v := range d {
+		switch {
+		case k == "mode" || k == "mtime":
+			od[k] = v
+		case zx.IsUser(k) && v == "":
+			delete(od, k)
+		case zx.IsUser(k):
+			od[k] = v
+		}
+	}
+	if od["type"] == "d" {
+		return fs.putObj(fs.dirKey(p), meta(od), nil)
+	}
+	key := fs.key(p)
+	if d["size"] == "" || d.Size() == od.Size() {
+		return fs.copyObj(key, key, meta(od), true)
+	}
+	dat, err := fs.getAll(key)
+	if err != nil {
+		return err
+	}
+	return fs.putObj(key, meta(od), resize(dat, d.Size()))
+}
+
+func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
+	rc := make(chan zx.Dir)
+	go func() {
+		fs.Count(zx.Swstat)
+		d = d.SysDup()
+		if err := d.CheckAttrs(); err != nil {
+			close(rc, err)
+			return
+		}
+		err := fs.wstat(p, d)
+		if err == nil {
+			var d zx.Dir
+			d, err = fs.stat(p)
+			if err == nil {
+				rc <- d
+			}
+		}
+		close(rc, err)
+	}()
+	return rc
+}
+
+func (fs *Fs) remove(p string, all bool) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return fmt.Errorf("remove %s: %s", p, zx.ErrPerm)
+	}
+	d, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+	if d["type"] != "d" {
+		return fs.delObj(fs.key(p))
+	}
+	dk := fs.dirKey(p)
+	objs, _, err := fs.list(dk, "", 0)
+	if err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	for _, o := range objs {
+		if o.Key == dk {
+			continue
+		}
+		if !all {
+			return fmt.Errorf("%s: %s", p, zx.ErrNotEmpty)
+		}
+		if err := fs.delObj(o.Key); err != nil {
+			return fmt.Errorf("%s: %s", fs.path(o.Key), err)
+		}
+	}
+	if err := fs.delObj(dk); err != nil && !zx.IsNotExist(err) {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	return nil
+}
+
+func (fs *Fs) Remove(p string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Sremove)
+		err := fs.remove(p, false)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) RemoveAll(p string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Sremove)
+		err := fs.remove(p, true)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+// Copy the objects for from to be those for to, using S3 copies.
+// Objects over 5GiB can't be copied this way.
+func (fs *Fs) copy(from, to string) error {
+	pfrom, err := zx.UseAbsPath(from)
+	if err != nil {
+		return err
+	}
+	pto, err := zx.UseAbsPath(to)
+	if err != nil {
+		return err
+	}
+	if pfrom == pto {
+		return nil
+	}
+	if pto == "/" {
+		return fmt.Errorf("copy %s: %s", pto, zx.ErrPerm)
+	}
+	d, err := fs.stat(pfrom)
+	if err != nil {
+		return err
+	}
+	if d["type"] == "d" && zx.HasPrefix(pto, pfrom) {
+		return fmt.Errorf("copy %s: inconsistent copy", from)
+	}
+	if err := fs.chkParent(pto); err != nil {
+		return err
+	}
+	if od, err := fs.stat(pto); err == nil {
+		if err := fs.remove(pto, od["type"] == "d"); err != nil {
+			return err
+		}
+	}
+	if d["type"] != "d" {
+		return fs.copyObj(fs.key(pfrom), fs.key(pto), nil, false)
+	}
+	dk, tk := fs.dirKey(pfrom), fs.dirKey(pto)
+	objs, _, err := fs.list(dk, "", 0)
+	if err != nil {
+		return fmt.Errorf("%s: %s", pfrom, err)
+	}
+	if len(objs) == 0 || objs[0].Key != dk {
+		// make sure the dir exists even if empty
+		if err := fs.putObj(tk, meta(d), nil); err != nil {
+			return fmt.Errorf("%s: %s", pto, err)
+		}
+	}
+	for _, o := range objs {
+		if err := fs.copyObj(o.Key, tk+o.Key[len(dk):], nil, false); err != nil {
+			return fmt.Errorf("%s: %s", fs.path(o.Key), err)
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) Copy(from, to string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		err := fs.copy(from, to)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+// S3 can't rename objects; they are copied and then removed.
+func (fs *Fs) move(from, to string) error {
+	pfrom, err := zx.UseAbsPath(from)
+	if err != nil {
+		return err
+	}
+	pto, err := zx.UseAbsPath(to)
+	if err != nil {
+		return err
+	}
+	if pfrom == pto {
+		return nil
+	}
+	if pfrom == "/" || pto == "/" {
+		return fmt.Errorf("move %s: %s", pfrom, zx.ErrPerm)
+	}
+	if zx.HasPrefix(pto, pfrom) {
+		return fmt.Errorf("move %s: inconsistent move", from)
+	}
+	if err := fs.copy(pfrom, pto); err != nil {
+		return err
+	}
+	return fs.remove(pfrom, true)
+}
+
+func (fs *Fs) Move(from, to string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Smove)
+		err := fs.move(from, to)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+// d is a dup and can be changed.
+func (fs *Fs) findr(d zx.Dir, fp *pred.Pred, p, spref, dpref string, lvl int, c chan<- zx.Dir) error {
+	match, pruned, err := fp.EvalAt(d, lvl)
+	if pruned {
+		if !match {
+			d["proto"] = "s3"
+			d["err"] = "pruned"
+		}
+		c <- d
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var ds []zx.Dir
+	if d["type"] == "d" {
+		ds, err = fs.getDir(p)
+		if err != nil {
+			d["err"] = err.Error()
+		}
+	}
+	if match || err != nil {
+		if ok := c <- d; !ok {
+			return cerror(c)
+		}
+	}
+	for _, cd := range ds {
+		cp := cd["path"]
+		if spref != dpref {
+			suff := zx.Suffix(cp, spref)
+			if suff == "" {
+				return fmt.Errorf("%s: %s: %s", spref, cp, zx.ErrNotSuffix)
+			}
+			cd["path"] = fpath.Join(dpref, suff)
+		}
+		if err := fs.findr(cd, fp, cp, spref, dpref, lvl+1, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) find(p, fpred, spref, dpref string, depth int, c chan<- zx.Dir) error {
+	d, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+	p = d["path"]
+	if spref != "" || dpref != "" {
+		spref, err = zx.UseAbsPath(spref)
+		if err != nil {
+			return err
+		}
+		dpref, err = zx.UseAbsPath(dpref)
+		if err != nil {
+			return err
+		}
+	}
+	fp, err := pred.New(fpred)
+	if err != nil {
+		return err
+	}
+	if spref != dpref {
+		suff := zx.Suffix(p, spref)
+		if suff == "" {
+			return fmt.Errorf("suffix %s %s: %s", spref, p, zx.ErrNotSuffix)
+		}
+		d["path"] = fpath.Join(dpref, suff)
+	}
+	return fs.findr(d, fp, p, spref, dpref, depth, c)
+}
+
+func (fs *Fs) Find(p, fpred, spref, dpref string, depth0 int) <-chan zx.Dir {
+	c := make(chan zx.Dir)
+	go func() {
+		fs.Count(zx.Sfind)
+		err := fs.find(p, fpred, spref, dpref, depth0, c)
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) FindGet(p, fpred, spref, dpref string, depth0 int) <-chan face{} {
+	c := make(chan face{})
+	go func() {
+		dc := fs.Find(p, fpred, spref, dpref, depth0)
+		for d := range dc {
+			if ok := c <- d.Dup(); !ok {
+				close(dc, cerror(c))
+				return
+			}
+			if d["err"] != "" || d["type"] == "d" {
+				continue
+			}
+			bc := fs.Get(d.SPath(), 0, zx.All)
+			for b := range bc {
+				c <- b
+			}
+			if err := cerror(bc); err != nil {
+				c <- err
+			}
+		}
+		close(c, cerror(dc))
+	}()
+	return c
+}
+
+// Release the cached tree, so the next Dial makes a new one.
+func (fs *Fs) Close() error {
+	dialslk.Lock()
+	defer dialslk.Unlock()
+	if dials[fs.addr] == fs {
+		delete(dials, fs.addr)
+	}
+	return nil
+}
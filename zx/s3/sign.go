@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials used to sign S3 requests (AWS signature version 4).
+// Requests are not signed if Id is empty, for public buckets.
+struct Keys {
+	Id, Secret string
+	Token      string // session token, if any
+	Region     string
+}
+
+// Return the keys found in the environment, using the
+// variables also used by the AWS tools:
+// $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, $AWS_SESSION_TOKEN,
+// and $AWS_REGION (or $AWS_DEFAULT_REGION, or us-east-1).
+func EnvKeys() *Keys {
+	k := &Keys{
+		Id:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		Secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:  os.Getenv("AWS_SESSION_TOKEN"),
+		Region: os.Getenv("AWS_REGION"),
+	}
+	if k.Region == "" {
+		k.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if k.Region == "" {
+		k.Region = "us-east-1"
+	}
+	return k
+}
+
+func hexHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSum(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// URI encode s as S3 wants, keeping slashes if it's a path.
+func uriEncode(s string, path bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Sign the request r made at time t, whose body has the given
+// sha256 hash (in hex), by setting its Authorization header.
+// All headers already set in r are signed.
+func (k *Keys) sign(r *http.Request, phash string, t time.Time) {
+	t = t.UTC()
+	date := t.Format("20060102")
+	now := t.Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", now)
+	r.Header.Set("X-Amz-Content-Sha256", phash)
+	if k.Token != "" {
+		r.Header.Set("X-Amz-Security-Token", k.Token)
+	}
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	hdrs := map[string]string{"host": host}
+	for n, v := range r.Header {
+		hdrs[strings.ToLower(n)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(hdrs))
+	for n := range hdrs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var ch bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&ch, "%s:%s\n", n, hdrs[n])
+	}
+	signed := strings.Join(names, ";")
+
+	var qs []string
+	for n, vs := range r.URL.Query() {
+		for _, v := range vs {
+			qs = append(qs, uriEncode(n, false)+"="+uriEncode(v, false))
+		}
+	}
+	sort.Strings(qs)
+
+	creq := strings.Join([]string{r.Method, uriEncode(r.URL.Path, true),
+		strings.Join(qs, "&"), ch.String(), signed, phash}, "\n")
+	scope := date + "/" + k.Region + "/s3/aws4_request"
+	tosign := "AWS4-HMAC-SHA256\n" + now + "\n" + scope + "\n" + hexHash([]byte(creq))
+	key := hmacSum([]byte("AWS4"+k.Secret), date)
+	key = hmacSum(key, k.Region)
+	key = hmacSum(key, "s3")
+	key = hmacSum(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSum(key, tosign))
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		k.Id, scope, signed, sig))
+}
@@ -0,0 +1,679 @@
+package sftp
+
+import (
+	"bytes"
+	"clive/dbg"
+	"clive/zx"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	fpath "path"
+	"strings"
+	"time"
+)
+
+// SFTP (version 3) packet types
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpRmdir    = 15
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpSymlink  = 20
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+)
+
+// status codes
+const (
+	fxOk = iota
+	fxEOF
+	fxNoSuchFile
+	fxPermDenied
+	fxFailure
+	fxBadMsg
+	fxNoConn
+	fxConnLost
+	fxOpUnsupported
+)
+
+// attribute flags
+const (
+	attrSize     = 0x1
+	attrUidGid   = 0x2
+	attrPerms    = 0x4
+	attrTimes    = 0x8
+	attrExtended = 0x80000000
+)
+
+// open flags
+const (
+	fxfRead   = 0x1
+	fxfWrite  = 0x2
+	fxfAppend = 0x4
+	fxfCreat  = 0x8
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+const (
+	version = 3
+	maxPkt  = 256 * 1024 // max packet size accepted
+	maxData = 32 * 1024  // max data sent in a read reply
+	dirEnts = 64         // max entries sent in a readdir reply
+)
+
+var errBadPkt = errors.New("bad sftp packet")
+
+// An open file or directory
+struct handle {
+	path string
+	dir  bool
+	ents []zx.Dir // for dirs, entries not yet sent
+	read bool     // for dirs, entries already retrieved
+	app  bool     // for files, writes append
+}
+
+// An sftp session for a client
+struct sess {
+	*dbg.Flag
+	fs zx.Fs
+	rw io.ReadWriter
+	hs map[string]*handle
+	nh int
+}
+
+struct pkt {
+	bytes.Buffer
+}
+
+func (p *pkt) u8(v uint8) {
+	p.WriteByte(v)
+}
+
+func (p *pkt) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	p.Write(b[:])
+}
+
+func (p *pkt) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	p.Write(b[:])
+}
+
+func (p *pkt) str(s string) {
+	p.u32(uint32(len(s)))
+	p.WriteString(s)
+}
+
+func (p *pkt) attrs(d zx.Dir) {
+	if d == nil {
+		p.u32(0)
+		return
+	}
+	p.u32(attrSize | attrPerms | attrTimes)
+	p.u64(uint64(d.Size()))
+	p.u32(uxMode(d))
+	mt := uint32(d.Time("mtime").Unix())
+	p.u32(mt)
+	p.u32(mt)
+}
+
+struct unpkt {
+	b   []byte
+	err error
+}
+
+func (u *unpkt) get(n int) []byte {
+	if u.err != nil || n < 0 || len(u.b) < n {
+		u.err = errBadPkt
+		return nil
+	}
+	v := u.b[:n]
+	u.b = u.b[n:]
+	return v
+}
+
+func (u *unpkt) u8() uint8 {
+	if v := u.get(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (u *unpkt) u32() uint32 {
+	if v := u.get(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (u *unpkt) u64() uint64 {
+	if v := u.get(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (u *unpkt) str() string {
+	return string(u.get(int(u.u32())))
+}
+
+// Return the attributes we can update, as a dir.
+func (u *unpkt) attrs() zx.Dir {
+	d := zx.Dir{}
+	flg := u.u32()
+	if flg&attrSize != 0 {
+		d.SetSize(int64(u.u64()))
+	}
+	if flg&attrUidGid != 0 {
+		u.u32()
+		u.u32()
+	}
+	if flg&attrPerms != 0 {
+		d.SetMode(uint64(u.u32()))
+	}
+	if flg&attrTimes != 0 {
+		u.u32()
+		d.SetTime("mtime", time.Unix(int64(u.u32()), 0))
+	}
+	if flg&attrExtended != 0 {
+		for n := u.u32(); n > 0 && u.err == nil; n-- {
+			u.str()
+			u.str()
+		}
+	}
+	return d
+}
+
+// Unix mode bits for d.
+func uxMode(d zx.Dir) uint32 {
+	m := uint32(d.Mode())
+	switch d["type"] {
+	case "d":
+		m |= 0040000
+	case "l":
+		m |= 0120000
+	default:
+		m |= 0100000
+	}
+	return m
+}
+
+// Line for d as printed by ls -l, which some clients show.
+func longName(d zx.Dir) string {
+	perms := []byte("-rwxrwxrwx")
+	switch d["type"] {
+	case "d", "l":
+		perms[0] = d["type"][0]
+	}
+	m := d.Mode()
+	for i := uint(0); i < 9; i++ {
+		if m&(1<<(8-i)) == 0 {
+			perms[i+1] = '-'
+		}
+	}
+	mt := d.Time("mtime").Format("Jan _2 15:04")
+	return fmt.Sprintf("%s 1 %-8s %-8s %8d %s %s",
+		perms, d["uid"], d["gid"], d.Size(), mt, d["name"])
+}
+
+func code(err error) uint32 {
+	switch {
+	case err == nil:
+		return fxOk
+	case zx.IsNotExist(err):
+		return fxNoSuchFile
+	case zx.IsPerm(err) || strings.Contains(err.Error(), zx.ErrRO.Error()):
+		return fxPermDenied
+	default:
+		return fxFailure
+	}
+}
+
+func newSess(tag string, fs zx.Fs, rw io.ReadWriter) *sess {
+	return &sess{
+		Flag: &dbg.Flag{Tag: tag},
+		fs:   fs,
+		rw:   rw,
+		hs:   map[string]*handle{},
+	}
+}
+
+func (s *sess) read() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(s.rw, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > maxPkt {
+		return nil, errBadPkt
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.rw, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *sess) write(p *pkt) error {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(p.Len()))
+	if _, err := s.rw.Write(append(hdr[:], p.Bytes()...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func reply(typ uint8, id uint32) *pkt {
+	p := &pkt{}
+	p.u8(typ)
+	p.u32(id)
+	return p
+}
+
+func (s *sess) status(id uint32, err error) error {
+	p := reply(fxpStatus, id)
+	p.u32(code(err))
+	msg := "ok"
+	if err != nil {
+		msg = err.Error()
+	}
+	p.str(msg)
+	p.str("")
+	return s.write(p)
+}
+
+func (s *sess) eof(id uint32) error {
+	p := reply(fxpStatus, id)
+	p.u32(fxEOF)
+	p.str("eof")
+	p.str("")
+	return s.write(p)
+}
+
+// Serve the sftp protocol until the client hangs up.
+func (s *sess) serve() error {
+	b, err := s.read()
+	if err != nil {
+		return err
+	}
+	u := &unpkt{b: b}
+	if u.u8() != fxpInit {
+		return errBadPkt
+	}
+	s.Dprintf("<- init %d\n", u.u32())
+	p := &pkt{}
+	p.u8(fxpVersion)
+	p.u32(version)
+	if err := s.write(p); err != nil {
+		return err
+	}
+	for {
+		b, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.req(&unpkt{b: b}); err != nil {
+			return err
+		}
+	}
+}
+
+// Absolute path for a path given by the client, whose
+// current directory is /.
+func abs(p string) string {
+	return fpath.Join("/", p)
+}
+
+func (s *sess) handle(u *unpkt) (*handle, error) {
+	h, ok := s.hs[u.str()]
+	if !ok {
+		return nil, errors.New("bad handle")
+	}
+	return h, nil
+}
+
+func (s *sess) newHandle(h *handle) string {
+	s.nh++
+	hs := fmt.Sprintf("%d", s.nh)
+	s.hs[hs] = h
+	return hs
+}
+
+// Put data (if any) into path, at off, with the attributes in d.
+func (s *sess) put(path string, d zx.Dir, off int64, data []byte) error {
+	pfs, ok := s.fs.(zx.Putter)
+	if !ok {
+		return fmt.Errorf("%s: %s", path, zx.ErrRO)
+	}
+	var dc chan []byte
+	if data != nil {
+		dc = make(chan []byte, 1)
+		dc <- data
+		close(dc)
+	}
+	rc := pfs.Put(path, d, off, dc)
+	<-rc
+	return cerror(rc)
+}
+
+func (s *sess) req(u *unpkt) error {
+	typ := u.u8()
+	id := u.u32()
+	if u.err != nil {
+		return u.err
+	}
+	var err error
+	switch typ {
+	case fxpRealpath:
+		p := abs(u.str())
+		s.Dprintf("<- realpath %s\n", p)
+		r := reply(fxpName, id)
+		r.u32(1)
+		r.str(p)
+		r.str(p)
+		r.attrs(nil)
+		return s.write(r)
+	case fxpStat, fxpLstat, fxpFstat:
+		var p string
+		if typ == fxpFstat {
+			var h *handle
+			if h, err = s.handle(u); err != nil {
+				break
+			}
+			p = h.path
+		} else {
+			p = abs(u.str())
+		}
+		s.Dprintf("<- stat %s\n", p)
+		var d zx.Dir
+		if typ == fxpLstat {
+			d, err = zx.Lstat(s.fs, p)
+		} else {
+			d, err = zx.Stat(s.fs, p)
+		}
+		if err != nil {
+			break
+		}
+		r := reply(fxpAttrs, id)
+		r.attrs(d)
+		return s.write(r)
+	case fxpOpen:
+		p := abs(u.str())
+		flg := u.u32()
+		d := u.attrs()
+		if u.err != nil {
+			return u.err
+		}
+		s.Dprintf("<- open %s %#x\n", p, flg)
+		var h *handle
+		if h, err = s.open(p, flg, d); err != nil {
+			break
+		}
+		r := reply(fxpHandle, id)
+		r.str(s.newHandle(h))
+		return s.write(r)
+	case fxpOpendir:
+		p := abs(u.str())
+		s.Dprintf("<- opendir %s\n", p)
+		var d zx.Dir
+		if d, err = zx.Stat(s.fs, p); err != nil {
+			break
+		}
+		if d["type"] != "d" {
+			err = fmt.Errorf("%s: %s", p, zx.ErrNotDir)
+			break
+		}
+		r := reply(fxpHandle, id)
+		r.str(s.newHandle(&handle{path: p, dir: true}))
+		return s.write(r)
+	case fxpClose:
+		hs := u.str()
+		s.Dprintf("<- close %s\n", hs)
+		if _, ok := s.hs[hs]; !ok {
+			err = errors.New("bad handle")
+		}
+		delete(s.hs, hs)
+	case fxpRead:
+		var h *handle
+		if h, err = s.handle(u); err != nil {
+			break
+		}
+		off, n := u.u64(), u.u32()
+		if u.err != nil {
+			return u.err
+		}
+		if h.dir {
+			err = fmt.Errorf("%s: %s", h.path, zx.ErrIsDir)
+			break
+		}
+		if n > maxData {
+			n = maxData
+		}
+		s.Dprintf("<- read %s %d %d\n", h.path, off, n)
+		var dat []byte
+		if dat, err = s.get(h.path, int64(off), int64(n)); err != nil {
+			break
+		}
+		if len(dat) == 0 {
+			return s.eof(id)
+		}
+		r := reply(fxpData, id)
+		r.str(string(dat))
+		return s.write(r)
+	case fxpWrite:
+		var h *handle
+		if h, err = s.handle(u); err != nil {
+			break
+		}
+		off := int64(u.u64())
+		dat := u.get(int(u.u32()))
+		if u.err != nil {
+			return u.err
+		}
+		s.Dprintf("<- write %s %d %d\n", h.path, off, len(dat))
+		if h.app {
+			off = -1
+		}
+		err = s.put(h.path, zx.Dir{}, off, dat)
+	case fxpReaddir:
+		var h *handle
+		if h, err = s.handle(u); err != nil {
+			break
+		}
+		s.Dprintf("<- readdir %s\n", h.path)
+		if !h.read {
+			h.read = true
+			gfs, ok := s.fs.(zx.Getter)
+			if !ok {
+				err = fmt.Errorf("%s: %s", h.path, zx.ErrBug)
+				break
+			}
+			if h.ents, err = zx.GetDir(gfs, h.path); err != nil {
+				break
+			}
+		}
+		if len(h.ents) == 0 {
+			return s.eof(id)
+		}
+		n := len(h.ents)
+		if n > dirEnts {
+			n = dirEnts
+		}
+		r := reply(fxpName, id)
+		r.u32(uint32(n))
+		for _, d := range h.ents[:n] {
+			r.str(d["name"])
+			r.str(longName(d))
+			r.attrs(d)
+		}
+		h.ents = h.ents[n:]
+		return s.write(r)
+	case fxpSetstat, fxpFsetstat:
+		var p string
+		if typ == fxpFsetstat {
+			var h *handle
+			if h, err = s.handle(u); err != nil {
+				break
+			}
+			p = h.path
+		} else {
+			p = abs(u.str())
+		}
+		d := u.attrs()
+		if u.err != nil {
+			return u.err
+		}
+		s.Dprintf("<- setstat %s %s\n", p, d)
+		wfs, ok := s.fs.(zx.Wstater)
+		if !ok {
+			err = fmt.Errorf("%s: %s", p, zx.ErrRO)
+			break
+		}
+		rc := wfs.Wstat(p, d)
+		<-rc
+		err = cerror(rc)
+	case fxpRemove, fxpRmdir:
+		p := abs(u.str())
+		s.Dprintf("<- remove %s\n", p)
+		rfs, ok := s.fs.(zx.Remover)
+		if !ok {
+			err = fmt.Errorf("%s: %s", p, zx.ErrRO)
+			break
+		}
+		err = <-rfs.Remove(p)
+	case fxpMkdir:
+		p := abs(u.str())
+		d := u.attrs()
+		if u.err != nil {
+			return u.err
+		}
+		s.Dprintf("<- mkdir %s\n", p)
+		if _, serr := zx.Stat(s.fs, p); serr == nil {
+			err = fmt.Errorf("%s: %s", p, zx.ErrExists)
+			break
+		}
+		nd := zx.Dir{"type": "d", "mode": "0755"}
+		if d["mode"] != "" {
+			nd["mode"] = d["mode"]
+		}
+		err = s.put(p, nd, 0, nil)
+	case fxpRename:
+		from, to := abs(u.str()), abs(u.str())
+		s.Dprintf("<- rename %s %s\n", from, to)
+		mfs, ok := s.fs.(zx.Mover)
+		if !ok {
+			err = fmt.Errorf("%s: %s", from, zx.ErrRO)
+			break
+		}
+		err = <-mfs.Move(from, to)
+	case fxpReadlink:
+		p := abs(u.str())
+		s.Dprintf("<- readlink %s\n", p)
+		var d zx.Dir
+		if d, err = zx.Lstat(s.fs, p); err != nil {
+			break
+		}
+		if d["type"] != "l" {
+			err = fmt.Errorf("%s: not a link", p)
+			break
+		}
+		r := reply(fxpName, id)
+		r.u32(1)
+		r.str(d["target"])
+		r.str(d["target"])
+		r.attrs(nil)
+		return s.write(r)
+	case fxpSymlink:
+		// OpenSSH sends the target first, despite the draft.
+		target, p := u.str(), abs(u.str())
+		s.Dprintf("<- symlink %s %s\n", target, p)
+		lfs, ok := s.fs.(zx.Symlinker)
+		if !ok {
+			err = fmt.Errorf("%s: %s", p, zx.ErrBug)
+			break
+		}
+		err = <-lfs.Symlink(target, p)
+	default:
+		s.Dprintf("<- unsupported %d\n", typ)
+		p := reply(fxpStatus, id)
+		p.u32(fxOpUnsupported)
+		p.str("operation not supported")
+		p.str("")
+		return s.write(p)
+	}
+	if u.err != nil {
+		return u.err
+	}
+	if err != nil {
+		s.Dprintf("-> %s\n", err)
+	}
+	return s.status(id, err)
+}
+
+func (s *sess) open(p string, flg uint32, d zx.Dir) (*handle, error) {
+	h := &handle{path: p, app: flg&fxfAppend != 0}
+	od, err := zx.Stat(s.fs, p)
+	switch {
+	case err != nil && (flg&fxfCreat == 0 || !zx.IsNotExist(err)):
+		return nil, err
+	case err != nil:
+		nd := zx.Dir{"type": "-", "mode": "0644"}
+		if d["mode"] != "" {
+			nd["mode"] = d["mode"]
+		}
+		err = s.put(p, nd, 0, nil)
+	case flg&(fxfCreat|fxfExcl) == fxfCreat|fxfExcl:
+		err = fmt.Errorf("%s: %s", p, zx.ErrExists)
+	case od["type"] == "d":
+		err = fmt.Errorf("%s: %s", p, zx.ErrIsDir)
+	case flg&fxfTrunc != 0:
+		err = s.put(p, zx.Dir{"size": "0"}, 0, nil)
+	case flg&(fxfWrite|fxfAppend) != 0:
+		if _, ok := s.fs.(zx.Putter); !ok {
+			err = fmt.Errorf("%s: %s", p, zx.ErrRO)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Get count bytes at off from the file at path.
+func (s *sess) get(path string, off, count int64) ([]byte, error) {
+	gfs, ok := s.fs.(zx.Getter)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", path, zx.ErrBug)
+	}
+	var buf bytes.Buffer
+	gc := gfs.Get(path, off, count)
+	for b := range gc {
+		buf.Write(b)
+	}
+	return buf.Bytes(), cerror(gc)
+}
@@ -0,0 +1,198 @@
+/*
+	SFTP gateway for zx trees.
+
+	A Server serves a zx tree through SSH using the SFTP protocol
+	(version 3), so that sftp clients (eg. sftp, scp in OpenSSH 9 and later,
+	WinSCP, or file managers) may copy files in and out of clive trees
+	without clive.
+
+	Users authenticate with the secret for their clive key
+	(see auth.SecretOk), and trees that are zx.Authers are used
+	with the resulting auth info.
+	Only the sftp subsystem is provided: there are no shells or
+	commands, and thus no rsync or legacy scp.
+*/
+package sftp
+
+import (
+	"clive/dbg"
+	cnet "clive/net"
+	"clive/net/auth"
+	"clive/x/code.google.com/p/go.crypto/ssh"
+	"clive/zx"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+struct Server {
+	*dbg.Flag
+	sync.Mutex
+	addr   string // where served
+	l      net.Listener
+	key    ssh.Signer
+	fs     zx.Fs
+	noauth bool
+	endc   chan bool
+}
+
+func init() {
+	cnet.DefSvc("sftp", "8022")
+}
+
+func (s *Server) String() string {
+	return s.addr
+}
+
+// Start a server at the given address, using key as the host key.
+// The address is net!host!port as in clive/net, and only tcp is
+// supported. The default port is that of the "sftp" service (8022).
+func NewServer(addr string, key ssh.Signer) (*Server, error) {
+	nw, host, svc := cnet.ParseAddr(addr)
+	if nw != "tcp" && nw != "*" {
+		return nil, fmt.Errorf("%s: %s", addr, cnet.ErrBadAddr)
+	}
+	if host == "*" {
+		host = ""
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, cnet.Port("tcp", svc)))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Flag: &dbg.Flag{Tag: addr},
+		addr: addr,
+		l:    l,
+		key:  key,
+		endc: make(chan bool),
+	}
+	return s, nil
+}
+
+// Disable auth in server: any user name and secret are accepted.
+func (s *Server) NoAuth() {
+	s.noauth = true
+}
+
+// Serve fs.
+func (s *Server) Serve(fs zx.Fs) error {
+	s.Lock()
+	if s.fs != nil {
+		s.Unlock()
+		return fmt.Errorf("%s: already serving", s.addr)
+	}
+	s.fs = fs
+	s.Unlock()
+	dbg.Warn("%s: serving %s...", s, fs)
+	go s.loop()
+	return nil
+}
+
+func (s *Server) loop() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			dbg.Warn("%s: %s", s, err)
+			close(s.endc, err)
+			return
+		}
+		go s.client(c)
+	}
+}
+
+// Terminate the server.
+func (s *Server) Close() {
+	s.l.Close()
+}
+
+// Wait until the server is done
+func (s *Server) Wait() error {
+	<-s.endc
+	return cerror(s.endc)
+}
+
+// Return the tree as seen by the user authenticated with ai.
+func (s *Server) authFor(ai *auth.Info) zx.Fs {
+	afs, ok := s.fs.(zx.Auther)
+	if !ok || ai == nil {
+		return s.fs
+	}
+	fs, err := afs.Auth(ai)
+	if err != nil {
+		dbg.Warn("%s: user %s: fs auth: %s", s.addr, ai.Uid, err)
+		return nil
+	}
+	return fs
+}
+
+func (s *Server) client(c net.Conn) {
+	tag := c.RemoteAddr().String()
+	s.Dprintf("new client %s\n", tag)
+	defer s.Dprintf("gone client %s\n", tag)
+	var ai *auth.Info
+	cfg := &ssh.ServerConfig{NoClientAuth: s.noauth}
+	cfg.AddHostKey(s.key)
+	cfg.PasswordCallback = func(m ssh.ConnMetadata, secret []byte) (*ssh.Permissions, error) {
+		if s.noauth {
+			return nil, nil
+		}
+		var err error
+		ai, err = auth.SecretOk("", m.User(), string(secret), "sftp")
+		if err == auth.ErrDisabled {
+			return nil, nil
+		}
+		if err != nil {
+			dbg.Warn("%s: %s: user %s: %s", s.addr, tag, m.User(), err)
+		}
+		return nil, err
+	}
+	sc, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		s.Dprintf("%s: %s\n", tag, err)
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	if ai != nil {
+		s.Dprintf("%s auth as %s\n", tag, ai.Uid)
+	}
+	fs := s.authFor(ai)
+	for nc := range chans {
+		if nc.ChannelType() != "session" || fs == nil {
+			nc.Reject(ssh.UnknownChannelType, "only sftp sessions")
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			s.Dprintf("%s: %s\n", tag, err)
+			continue
+		}
+		go s.session(tag, fs, ch, creqs)
+	}
+}
+
+// Start the sftp subsystem when asked to and refuse other requests.
+func (s *Server) session(tag string, fs zx.Fs, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	started := false
+	for r := range reqs {
+		ok := false
+		if r.Type == "subsystem" && !started && len(r.Payload) >= 4 {
+			n := binary.BigEndian.Uint32(r.Payload)
+			ok = int(n) == len(r.Payload)-4 && string(r.Payload[4:]) == "sftp"
+		}
+		r.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		started = true
+		go func() {
+			ss := newSess(tag, fs, ch)
+			ss.Debug = s.Debug
+			if err := ss.serve(); err != nil {
+				s.Dprintf("%s: sftp: %s\n", tag, err)
+			}
+			ch.Close()
+		}()
+	}
+}
@@ -0,0 +1,189 @@
+package sftp
+
+import (
+	"bytes"
+	"clive/zx"
+	"clive/zx/fstest"
+	"clive/zx/zux"
+	"net"
+	"os"
+	"testing"
+)
+
+const tdir = "/tmp/sftptest"
+
+// A client speaking sftp to a session in the test
+struct tcli {
+	t  *testing.T
+	c  net.Conn
+	cs *sess // client side, to read and write packets
+	id uint32
+}
+
+func newCli(t *testing.T, fs zx.Fs) *tcli {
+	c, sc := net.Pipe()
+	s := newSess("sftptest", fs, sc)
+	s.Debug = testing.Verbose()
+	go func() {
+		s.serve()
+		sc.Close()
+	}()
+	tc := &tcli{t: t, c: c, cs: &sess{rw: c}}
+	p := &pkt{}
+	p.u8(fxpInit)
+	p.u32(version)
+	if err := tc.cs.write(p); err != nil {
+		t.Fatalf("init: %s", err)
+	}
+	u := tc.recv()
+	if u.u8() != fxpVersion || u.u32() != version {
+		t.Fatalf("bad version reply")
+	}
+	return tc
+}
+
+func (tc *tcli) recv() *unpkt {
+	b, err := tc.cs.read()
+	if err != nil {
+		tc.t.Fatalf("read: %s", err)
+	}
+	return &unpkt{b: b}
+}
+
+// Send a request and return its reply, after its type and id.
+func (tc *tcli) rpc(typ uint8, args ...face{}) (uint8, *unpkt) {
+	tc.id++
+	p := reply(typ, tc.id)
+	for _, a := range args {
+		switch a := a.(type) {
+		case string:
+			p.str(a)
+		case uint32:
+			p.u32(a)
+		case uint64:
+			p.u64(a)
+		default:
+			tc.t.Fatalf("bad arg type %T", a)
+		}
+	}
+	if err := tc.cs.write(p); err != nil {
+		tc.t.Fatalf("write: %s", err)
+	}
+	u := tc.recv()
+	rtyp, id := u.u8(), u.u32()
+	if id != tc.id {
+		tc.t.Fatalf("bad reply id %d", id)
+	}
+	return rtyp, u
+}
+
+// Issue a request expecting the given status.
+func (tc *tcli) status(code uint32, typ uint8, args ...face{}) {
+	rtyp, u := tc.rpc(typ, args...)
+	if rtyp != fxpStatus {
+		tc.t.Fatalf("reply %d is not a status", rtyp)
+	}
+	if c := u.u32(); c != code {
+		tc.t.Fatalf("status %d (%s), not %d", c, u.str(), code)
+	}
+}
+
+func (tc *tcli) handle(typ uint8, args ...face{}) string {
+	rtyp, u := tc.rpc(typ, args...)
+	if rtyp != fxpHandle {
+		tc.t.Fatalf("reply %d is not a handle", rtyp)
+	}
+	return u.str()
+}
+
+func (tc *tcli) size(p string) int64 {
+	rtyp, u := tc.rpc(fxpStat, p)
+	if rtyp != fxpAttrs {
+		tc.t.Fatalf("stat %s: reply %d", p, rtyp)
+	}
+	if u.u32()&attrSize == 0 {
+		tc.t.Fatalf("stat %s: no size", p)
+	}
+	return int64(u.u64())
+}
+
+func TestSftp(t *testing.T) {
+	fstest.MkTree(t, tdir)
+	defer os.RemoveAll(tdir)
+	fs, err := zux.NewZX(tdir)
+	if err != nil {
+		t.Fatalf("lfs: %s", err)
+	}
+	tc := newCli(t, fs)
+	defer tc.c.Close()
+
+	rtyp, u := tc.rpc(fxpRealpath, "a/..")
+	if rtyp != fxpName || u.u32() != 1 || u.str() != "/" {
+		t.Fatalf("bad realpath")
+	}
+
+	if sz := tc.size("/a/a1"); sz != int64(len(fstest.FileData["/a/a1"])) {
+		t.Fatalf("bad size %d", sz)
+	}
+	h := tc.handle(fxpOpen, "/a/a1", uint32(fxfRead), uint32(0))
+	var dat []byte
+	for {
+		rtyp, u := tc.rpc(fxpRead, h, uint64(len(dat)), uint32(1000))
+		if rtyp == fxpStatus {
+			if c := u.u32(); c != fxEOF {
+				t.Fatalf("read: status %d", c)
+			}
+			break
+		}
+		dat = append(dat, u.str()...)
+	}
+	if !bytes.Equal(dat, fstest.FileData["/a/a1"]) {
+		t.Fatalf("bad data")
+	}
+	tc.status(fxOk, fxpClose, h)
+
+	h = tc.handle(fxpOpendir, "/a")
+	rtyp, u = tc.rpc(fxpReaddir, h)
+	if rtyp != fxpName || u.u32() != 3 {
+		t.Fatalf("bad readdir")
+	}
+	for _, n := range []string{"a1", "a2", "b"} {
+		if nm := u.str(); nm != n {
+			t.Fatalf("readdir: got %s", nm)
+		}
+		t.Logf("%s\n", u.str()) // long name
+		u.attrs()
+	}
+	tc.status(fxEOF, fxpReaddir, h)
+	tc.status(fxOk, fxpClose, h)
+
+	h = tc.handle(fxpOpen, "/n1", uint32(fxfWrite|fxfCreat|fxfTrunc), uint32(0))
+	tc.status(fxOk, fxpWrite, h, uint64(0), "hello")
+	tc.status(fxOk, fxpWrite, h, uint64(5), " there")
+	tc.status(fxOk, fxpClose, h)
+	if dat, err := zx.GetAll(fs, "/n1"); err != nil || string(dat) != "hello there" {
+		t.Fatalf("bad written data %q %v", dat, err)
+	}
+	tc.status(fxFailure, fxpOpen, "/n1", uint32(fxfWrite|fxfCreat|fxfExcl), uint32(0))
+
+	tc.status(fxOk, fxpMkdir, "/nd", uint32(0))
+	tc.status(fxFailure, fxpMkdir, "/nd", uint32(0))
+	tc.status(fxOk, fxpRename, "/n1", "/nd/n1")
+	if sz := tc.size("/nd/n1"); sz != 11 {
+		t.Fatalf("bad size %d", sz)
+	}
+	tc.status(fxOk, fxpSetstat, "/nd/n1", uint32(attrSize), uint64(5))
+	if sz := tc.size("/nd/n1"); sz != 5 {
+		t.Fatalf("bad size %d after setstat", sz)
+	}
+	tc.status(fxOk, fxpRemove, "/nd/n1")
+	tc.status(fxOk, fxpRmdir, "/nd")
+	tc.status(fxNoSuchFile, fxpStat, "/nd")
+	tc.status(fxNoSuchFile, fxpOpen, "/nothere", uint32(fxfRead), uint32(0))
+	tc.status(fxOpUnsupported, 200, "ext")
+
+	ro := newCli(t, zx.MakeRO(fs))
+	defer ro.c.Close()
+	ro.status(fxPermDenied, fxpOpen, "/1", uint32(fxfWrite), uint32(0))
+	ro.status(fxPermDenied, fxpRemove, "/1")
+}
@@ -32,6 +32,13 @@ var name = [...]string{
 	"stats", "gets", "puts", "moves", "links", "removes", "wstats", "finds", "total",
 }
 
+func (c Call) String() string {
+	if c < 0 || c >= Nstats {
+		return fmt.Sprintf("call%d", int(c))
+	}
+	return name[c]
+}
+
 func (s *Stats) Count(what Call) {
 	s.Lock()
 	s.Nb[what]++
@@ -49,6 +56,13 @@ func (s *Stats) String() string {
 	return buf.String()
 }
 
+// Return the current counts, indexed by Call.
+func (s *Stats) Counts() [Nstats]int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.Nb
+}
+
 func (s *Stats) Clear() {
 	s.Lock()
 	defer s.Unlock()
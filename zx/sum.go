@@ -0,0 +1,202 @@
+package zx
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// Block size used by default for checksums (see Summer).
+const SumBlkSz = 128 * 1024
+
+// Checksum blocks of data as they are written.
+struct blkSum {
+	blksz int64
+	h     hash.Hash
+	n     int64 // bytes in the current block
+	sums  []string
+	c     chan<- string // send sums here, if not nil
+}
+
+func newBlkSum(blksz int64) *blkSum {
+	if blksz <= 0 {
+		blksz = SumBlkSz
+	}
+	return &blkSum{blksz: blksz, h: sha1.New()}
+}
+
+func (s *blkSum) sum() bool {
+	x := hex.EncodeToString(s.h.Sum(nil))
+	s.h.Reset()
+	s.n = 0
+	if s.c != nil {
+		return s.c <- x
+	}
+	s.sums = append(s.sums, x)
+	return true
+}
+
+func (s *blkSum) write(b []byte) bool {
+	for len(b) > 0 {
+		n := s.blksz - s.n
+		if n > int64(len(b)) {
+			n = int64(len(b))
+		}
+		s.h.Write(b[:n])
+		s.n += n
+		b = b[n:]
+		if s.n == s.blksz && !s.sum() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *blkSum) flush() bool {
+	if s.n == 0 {
+		return true
+	}
+	return s.sum()
+}
+
+// Return the hash for a chunk of data, as sent along with it
+// in Get and Put streams when their integrity is checked (eg. by rzx).
+func ChunkSum(b []byte) string {
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Return the checksums for the blocks of blksz bytes in data,
+// like a Summer would do for a file with that data.
+func DataSums(blksz int64, data ...[]byte) []string {
+	s := newBlkSum(blksz)
+	for _, b := range data {
+		s.write(b)
+	}
+	s.flush()
+	return s.sums
+}
+
+// Report the checksums for the file at path using fs if it's a Summer,
+// or getting the file and computing them otherwise (see GetSums).
+func Sums(fs Fs, path string, blksz int64) <-chan string {
+	if sfs, ok := fs.(Summer); ok {
+		return sfs.Sums(path, blksz)
+	}
+	return GetSums(fs, path, blksz)
+}
+
+// Report the checksums for the file at path by getting its data
+// from fs, which must be a Getter.
+// This is what Sums does for trees that are not Summers, and what
+// a Summer may do, near the files.
+func GetSums(fs Fs, path string, blksz int64) <-chan string {
+	c := make(chan string)
+	go func() {
+		gfs, ok := fs.(Getter)
+		if !ok {
+			close(c, fmt.Errorf("%s: tree is not a getter", path))
+			return
+		}
+		d, err := Stat(fs, path)
+		if err != nil {
+			close(c, err)
+			return
+		}
+		if d["type"] != "-" {
+			close(c, fmt.Errorf("%s: %s", path, ErrBadType))
+			return
+		}
+		s := newBlkSum(blksz)
+		s.c = c
+		dc := gfs.Get(path, 0, All)
+		for b := range dc {
+			if !s.write(b) {
+				close(dc, cerror(c))
+				return
+			}
+		}
+		if err := cerror(dc); err != nil {
+			close(c, err)
+			return
+		}
+		s.flush()
+		close(c)
+	}()
+	return c
+}
+
+// Return the number of bytes known to be equal at the start
+// of the data with checksums ssums and the one with checksums dsums,
+// both for blocks of blksz bytes.
+// The last block in ssums is never counted, because it may be short,
+// and data sent after it may change it.
+func SumsOff(ssums, dsums []string, blksz int64) int64 {
+	if blksz <= 0 {
+		blksz = SumBlkSz
+	}
+	n := int64(0)
+	for i := 0; i < len(ssums)-1 && i < len(dsums); i++ {
+		if ssums[i] != dsums[i] {
+			break
+		}
+		n += blksz
+	}
+	return n
+}
+
+func allSums(c <-chan string) ([]string, error) {
+	var sums []string
+	for s := range c {
+		sums = append(sums, s)
+	}
+	return sums, cerror(c)
+}
+
+// Return the offset in the file at from in sfs up to which the file at to in
+// dfs has the same data, checking their checksums.
+// If the file at to does not exist or can't be checksummed, the offset is 0.
+func ResumeOff(sfs Fs, from string, dfs Fs, to string) (int64, error) {
+	ssums, err := allSums(Sums(sfs, from, SumBlkSz))
+	if err != nil {
+		return 0, err
+	}
+	dsums, err := allSums(Sums(dfs, to, SumBlkSz))
+	if err != nil {
+		return 0, nil
+	}
+	return SumsOff(ssums, dsums, SumBlkSz), nil
+}
+
+// Get the file at from in sfs and put it at to in dfs with attributes
+// from d, like a Get followed by a Put would do, but skip the data
+// at the start of the file already found in dfs, as checked with Sums.
+// This is used to resume interrupted transfers of large files,
+// and to update files that only grew.
+// Only the checksums are sent over the network for the skipped data.
+func Resume(sfs Fs, from string, dfs Fs, to string, d Dir) (Dir, error) {
+	gfs, ok := sfs.(Getter)
+	if !ok {
+		return nil, fmt.Errorf("%s: tree is not a getter", from)
+	}
+	pfs, ok := dfs.(Putter)
+	if !ok {
+		return nil, fmt.Errorf("%s: tree is not a putter", to)
+	}
+	off, err := ResumeOff(sfs, from, dfs, to)
+	if err != nil {
+		return nil, err
+	}
+	nd := d.Dup()
+	nd["type"] = "-"
+	nd["size"] = fmt.Sprintf("%d", off)
+	dc := gfs.Get(from, off, All)
+	rc := pfs.Put(to, nd, off, dc)
+	rd := <-rc
+	if err := cerror(rc); err != nil {
+		close(dc, err)
+		return rd, err
+	}
+	return rd, cerror(dc)
+}
@@ -0,0 +1,517 @@
+/*
+	Versioned zx trees.
+
+	A versioned tree keeps a copy of each file in another tree
+	before it is changed by a put or wstat of its size, or removed.
+	The copies for /a/b are kept in the directory /a/b of the other tree,
+	named after the file mtime (in UTC) as in 20161016.153000.000000000.
+
+	Old versions are read-only and can be reached in two ways:
+	the copies are listed under /.zx/hist (eg., /.zx/hist/a/b/20161016.153000.000000000),
+	and a path like /a/b@20161016.1530 refers to the file /a/b as it
+	was at that time, if there's no file with that name.
+	Times are given in UTC using one of the formats in TimeFmts.
+
+	Versions are not kept for directories, and they are not moved
+	when files are moved.
+*/
+package vers
+
+import (
+	"clive/dbg"
+	"clive/u"
+	"clive/zx"
+	"fmt"
+	"io"
+	fpath "path"
+	"strings"
+	"time"
+)
+
+// A versioned tree
+struct Fs {
+	*dbg.Flag
+	*zx.Flags
+	*zx.Stats
+	fs   zx.RWFs // the tree
+	hfs  zx.RWFs // where versions are kept
+	keep int     // max number of versions kept per file, if > 0
+	days int     // versions older than this are removed, if > 0
+}
+
+const (
+	// Where old versions are listed
+	HistDir = "/.zx/hist"
+
+	// Format for the names of old versions
+	StampFmt = "20060102.150405.000000000"
+)
+
+var (
+	// Time formats accepted in paths like /a/b@time
+	TimeFmts = []string{
+		StampFmt,
+		"20060102.150405",
+		"20060102.1504",
+		"20060102",
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	}
+
+	_fs  zx.RWFs       = &Fs{}
+	_fs2 zx.Mover      = &Fs{}
+	_fs3 zx.Finder     = &Fs{}
+	_fs4 zx.FindGetter = &Fs{}
+)
+
+// Return a versioned tree for fs, keeping old versions in hfs.
+// The flags "keep" and "days" can be set to remove old versions
+// when there are more than keep of them for a file, or
+// they were written more than the given number of days ago.
+func New(fs, hfs zx.RWFs) *Fs {
+	vfs := &Fs{
+		Flag:  &dbg.Flag{Tag: fmt.Sprintf("vers!%s", fs)},
+		Flags: &zx.Flags{},
+		Stats: &zx.Stats{},
+		fs:    fs,
+		hfs:   hfs,
+	}
+	vfs.Flags.Add("debug", &vfs.Debug)
+	vfs.Flags.Add("keep", &vfs.keep)
+	vfs.Flags.Add("days", &vfs.days)
+	vfs.Flags.Add("clear", func(...string) error {
+		vfs.Stats.Clear()
+		return nil
+	})
+	return vfs
+}
+
+func (fs *Fs) String() string {
+	return fs.Tag
+}
+
+func stamp(t time.Time) string {
+	return t.UTC().Format(StampFmt)
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, f := range TimeFmts {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("wrong time format '%s'", s)
+}
+
+// If p is under HistDir, return the path for it in hfs.
+func histPath(p string) (string, bool) {
+	if p == HistDir {
+		return "/", true
+	}
+	if strings.HasPrefix(p, HistDir+"/") {
+		return p[len(HistDir):], true
+	}
+	return "", false
+}
+
+// If p looks like /a/b@time, return /a/b and the time.
+func versPath(p string) (string, time.Time, bool) {
+	i := strings.LastIndex(p, "@")
+	if i <= 0 || strings.Contains(p[i:], "/") {
+		return "", time.Time{}, false
+	}
+	t, err := parseTime(p[i+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return p[:i], t, true
+}
+
+func zxDir() zx.Dir {
+	return zx.Dir{
+		"name":  ".zx",
+		"path":  "/.zx",
+		"type":  "d",
+		"mode":  "0555",
+		"size":  "1",
+		"mtime": "0",
+		"uid":   u.Uid,
+		"gid":   u.Uid,
+		"wuid":  u.Uid,
+	}
+}
+
+// Make d, a dir from hfs, look like it is at p in our tree.
+func (fs *Fs) histDir(d zx.Dir, p string) zx.Dir {
+	d["path"] = p
+	d["name"] = fpath.Base(p)
+	d["addr"] = fs.Tag + "!" + p
+	d.SetMode(d.Mode() &^ 0222)
+	return d
+}
+
+// Return the tree and path for the data of p as it was at t.
+func (fs *Fs) at(p string, t time.Time) (zx.Getter, string, error) {
+	d, err := zx.Stat(fs.fs, p)
+	if err == nil && !d.Time("mtime").After(t) {
+		return fs.fs, p, nil
+	}
+	ds, err := zx.GetDir(fs.hfs, p)
+	if err != nil && !zx.IsNotExist(err) {
+		return nil, "", err
+	}
+	zx.SortDirs(ds)
+	for i := len(ds) - 1; i >= 0; i-- {
+		if ds[i]["type"] != "-" {
+			continue
+		}
+		vt, err := time.Parse(StampFmt, ds[i]["name"])
+		if err == nil && !vt.After(t) {
+			return fs.hfs, ds[i]["path"], nil
+		}
+	}
+	return nil, "", fmt.Errorf("%s: %s at %s", p, zx.ErrNotExist, stamp(t))
+}
+
+// Return the tree and path for p, which may refer to an old version.
+// If it's a version, the returned dir is not nil and has
+// the entry for p.
+func (fs *Fs) walk(p string) (zx.Getter, string, zx.Dir, error) {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if p == "/.zx" {
+		return nil, p, zxDir(), nil
+	}
+	if hp, ok := histPath(p); ok {
+		d, err := zx.Stat(fs.hfs, hp)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return fs.hfs, hp, fs.histDir(d, p), nil
+	}
+	vp, t, ok := versPath(p)
+	if !ok {
+		return fs.fs, p, nil, nil
+	}
+	if _, err := zx.Stat(fs.fs, p); err == nil {
+		return fs.fs, p, nil, nil
+	}
+	vfs, xp, err := fs.at(vp, t)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	d, err := zx.Stat(vfs, xp)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return vfs, xp, fs.histDir(d, p), nil
+}
+
+func (fs *Fs) stat(p string) (zx.Dir, error) {
+	rfs, xp, d, err := fs.walk(p)
+	if err != nil || d != nil {
+		return d, err
+	}
+	return zx.Stat(rfs, xp)
+}
+
+func (fs *Fs) Stat(p string) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	go func() {
+		fs.Count(zx.Sstat)
+		d, err := fs.stat(p)
+		if err == nil {
+			c <- d
+		}
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) get(p string, off, count int64, c chan<- []byte) error {
+	rfs, xp, d, err := fs.walk(p)
+	if err != nil {
+		return err
+	}
+	if rfs == nil {
+		// /.zx
+		if off > 0 || count == 0 {
+			return nil
+		}
+		hd, err := zx.Stat(fs.hfs, "/")
+		if err != nil {
+			return err
+		}
+		c <- fs.histDir(hd, HistDir).Bytes()
+		return nil
+	}
+	gc := rfs.Get(xp, off, count)
+	if rfs != fs.hfs || d["type"] != "d" {
+		for b := range gc {
+			if ok := c <- b; !ok {
+				close(gc, cerror(c))
+				return cerror(c)
+			}
+		}
+		return cerror(gc)
+	}
+	// dir entries from hfs
+	for b := range gc {
+		_, cd, err := zx.UnpackDir(b)
+		if err != nil {
+			close(gc, err)
+			return err
+		}
+		cd = fs.histDir(cd, fpath.Join(d["path"], cd["name"]))
+		if ok := c <- cd.Bytes(); !ok {
+			close(gc, cerror(c))
+			return cerror(c)
+		}
+	}
+	return cerror(gc)
+}
+
+func (fs *Fs) Get(p string, off, count int64) <-chan []byte {
+	c := make(chan []byte)
+	go func() {
+		fs.Count(zx.Sget)
+		err := fs.get(p, off, count, c)
+		close(c, err)
+	}()
+	return c
+}
+
+// Old versions can't be changed.
+func (fs *Fs) ronly(p string) error {
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return err
+	}
+	if _, ok := histPath(p); ok || p == "/.zx" {
+		return fmt.Errorf("%s: %s", p, zx.ErrRO)
+	}
+	return nil
+}
+
+// Keep a copy of the file at p, if it's a file, and remove
+// old versions if needed.
+func (fs *Fs) save(p string) error {
+	d, err := zx.Stat(fs.fs, p)
+	if err != nil || d["type"] != "-" {
+		return nil
+	}
+	hp := fpath.Join(d["path"], stamp(d.Time("mtime")))
+	if _, err := zx.Stat(fs.hfs, hp); err == nil {
+		return nil
+	}
+	fs.Dprintf("save %s as %s\n", p, hp)
+	nd := zx.Dir{"type": "F", "mode": d["mode"], "mtime": d["mtime"], "size": "0"}
+	for k, v := range d {
+		if zx.IsUser(k) {
+			nd[k] = v
+		}
+	}
+	rc := fs.hfs.Put(hp, nd, 0, fs.fs.Get(p, 0, zx.All))
+	<-rc
+	if err := cerror(rc); err != nil {
+		return fmt.Errorf("%s: save: %s", p, err)
+	}
+	return fs.prune(d["path"])
+}
+
+// Remove old versions for p according to the retention flags.
+func (fs *Fs) prune(p string) error {
+	if fs.keep <= 0 && fs.days <= 0 {
+		return nil
+	}
+	ds, err := zx.GetDir(fs.hfs, p)
+	if err != nil {
+		return err
+	}
+	var vs []zx.Dir
+	for _, d := range ds {
+		if _, err := time.Parse(StampFmt, d["name"]); err == nil && d["type"] == "-" {
+			vs = append(vs, d)
+		}
+	}
+	zx.SortDirs(vs)
+	old := time.Now().Add(-time.Duration(fs.days) * 24 * time.Hour)
+	for i, d := range vs {
+		t, _ := time.Parse(StampFmt, d["name"])
+		if (fs.keep > 0 && len(vs)-i > fs.keep) || (fs.days > 0 && t.Before(old)) {
+			fs.Dprintf("prune %s\n", d["path"])
+			if e := <-fs.hfs.Remove(d["path"]); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (fs *Fs) put(p string, d zx.Dir, off int64, dc <-chan []byte) (zx.Dir, error) {
+	if err := fs.ronly(p); err != nil {
+		return nil, err
+	}
+	if err := fs.save(p); err != nil {
+		return nil, err
+	}
+	rc := fs.fs.Put(p, d, off, dc)
+	rd := <-rc
+	return rd, cerror(rc)
+}
+
+func (fs *Fs) Put(p string, d zx.Dir, off int64, dc <-chan []byte) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	go func() {
+		fs.Count(zx.Sput)
+		rd, err := fs.put(p, d, off, dc)
+		if err == nil {
+			c <- rd
+		} else if dc != nil {
+			close(dc, err)
+		}
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) wstat(p string, d zx.Dir) (zx.Dir, error) {
+	if err := fs.ronly(p); err != nil {
+		return nil, err
+	}
+	if d["size"] != "" {
+		if err := fs.save(p); err != nil {
+			return nil, err
+		}
+	}
+	rc := fs.fs.Wstat(p, d)
+	rd := <-rc
+	return rd, cerror(rc)
+}
+
+func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	go func() {
+		fs.Count(zx.Swstat)
+		rd, err := fs.wstat(p, d)
+		if err == nil {
+			c <- rd
+		}
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) remove(p string, all bool) error {
+	if err := fs.ronly(p); err != nil {
+		return err
+	}
+	if err := fs.save(p); err != nil {
+		return err
+	}
+	if all {
+		return <-fs.fs.RemoveAll(p)
+	}
+	return <-fs.fs.Remove(p)
+}
+
+func (fs *Fs) Remove(p string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Sremove)
+		err := fs.remove(p, false)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) RemoveAll(p string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Sremove)
+		err := fs.remove(p, true)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+func (fs *Fs) move(from, to string) error {
+	mfs, ok := fs.fs.(zx.Mover)
+	if !ok {
+		return fmt.Errorf("%s: move not supported", fs.Tag)
+	}
+	if err := fs.ronly(from); err != nil {
+		return err
+	}
+	if err := fs.ronly(to); err != nil {
+		return err
+	}
+	// the file replaced, if any, is gone
+	if err := fs.save(to); err != nil {
+		return err
+	}
+	return <-mfs.Move(from, to)
+}
+
+func (fs *Fs) Move(from, to string) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		fs.Count(zx.Smove)
+		err := fs.move(from, to)
+		c <- err
+		close(c, err)
+	}()
+	return c
+}
+
+// Old versions are not found.
+func (fs *Fs) Find(p, fpred, spref, dpref string, depth0 int) <-chan zx.Dir {
+	fs.Count(zx.Sfind)
+	if ffs, ok := fs.fs.(zx.Finder); ok {
+		return ffs.Find(p, fpred, spref, dpref, depth0)
+	}
+	c := make(chan zx.Dir)
+	close(c, fmt.Errorf("%s: find not supported", fs.Tag))
+	return c
+}
+
+// Old versions are not found.
+func (fs *Fs) FindGet(p, fpred, spref, dpref string, depth0 int) <-chan face{} {
+	fs.Count(zx.Sfind)
+	if ffs, ok := fs.fs.(zx.FindGetter); ok {
+		return ffs.FindGet(p, fpred, spref, dpref, depth0)
+	}
+	c := make(chan face{})
+	close(c, fmt.Errorf("%s: findget not supported", fs.Tag))
+	return c
+}
+
+func (fs *Fs) Sync() error {
+	var err error
+	for _, t := range []zx.Fs{fs.fs, fs.hfs} {
+		if sfs, ok := t.(zx.Syncer); ok {
+			if e := sfs.Sync(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (fs *Fs) Close() error {
+	var err error
+	for _, t := range []zx.Fs{fs.fs, fs.hfs} {
+		if cfs, ok := t.(io.Closer); ok {
+			if e := cfs.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
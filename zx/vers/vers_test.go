@@ -0,0 +1,92 @@
+package vers
+
+import (
+	"clive/zx"
+	"clive/zx/fstest"
+	"clive/zx/zux"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	tdir = "/tmp/verstest"
+	hdir = "/tmp/verstest.hist"
+)
+
+func TestVers(t *testing.T) {
+	fstest.MkTree(t, tdir)
+	defer os.RemoveAll(tdir)
+	os.RemoveAll(hdir)
+	os.MkdirAll(hdir, 0755)
+	defer os.RemoveAll(hdir)
+	lfs, err := zux.NewZX(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hfs, err := zux.NewZX(hdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := New(lfs, hfs)
+	fs.Debug = testing.Verbose()
+
+	d0, err := zx.Stat(fs, "/a/a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := d0.Time("mtime")
+	for _, s := range []string{"v2", "v3"} {
+		time.Sleep(10 * time.Millisecond)
+		if err := zx.PutAll(fs, "/a/a1", []byte(s)); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+	}
+	ds, err := zx.GetDir(fs, HistDir+"/a/a1")
+	if err != nil || len(ds) != 2 {
+		t.Fatalf("hist: %v %v", ds, err)
+	}
+	if ds[0]["name"] != stamp(t0) || ds[0]["path"] != HistDir+"/a/a1/"+stamp(t0) {
+		t.Fatalf("hist: bad entry %s", ds[0])
+	}
+	t1 := ds[1].Time("mtime")
+	gets := [][]string{
+		{HistDir + "/a/a1/" + stamp(t0), string(fstest.FileData["/a/a1"])},
+		{"/a/a1@" + stamp(t0), string(fstest.FileData["/a/a1"])},
+		{"/a/a1@" + stamp(t1), "v2"},
+		{"/a/a1@" + stamp(time.Now()), "v3"},
+	}
+	for _, g := range gets {
+		dat, err := zx.GetAll(fs, g[0])
+		if err != nil || string(dat) != g[1] {
+			t.Fatalf("get %s: %q %v", g[0], dat, err)
+		}
+	}
+	if _, err := zx.Stat(fs, "/a/a1@"+stamp(t0.Add(-time.Hour))); !zx.IsNotExist(err) {
+		t.Fatalf("stat before first version: %v", err)
+	}
+	if d, err := zx.Stat(fs, "/.zx/hist"); err != nil || d["type"] != "d" {
+		t.Fatalf("stat hist: %v %v", d, err)
+	}
+	if err := zx.PutAll(fs, HistDir+"/a/a1/x", []byte("x")); err == nil {
+		t.Fatalf("could put in hist")
+	}
+
+	fs.Flags.Set("keep", 1)
+	if err := zx.PutAll(fs, "/a/a1", []byte("v4")); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	ds, err = zx.GetDir(fs, HistDir+"/a/a1")
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("keep: %v %v", ds, err)
+	}
+	if dat, _ := zx.GetAll(fs, ds[0]["path"]); string(dat) != "v3" {
+		t.Fatalf("keep: kept %q", dat)
+	}
+	if err := <-fs.Remove("/a/a1"); err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+	if dat, err := zx.GetAll(fs, "/a/a1@"+stamp(time.Now())); err != nil || string(dat) != "v4" {
+		t.Fatalf("get removed: %q %v", dat, err)
+	}
+}
@@ -0,0 +1,110 @@
+package zx
+
+import (
+	"sync"
+)
+
+// The set of watch requests for a file system, to help implement Watcher.
+// The zero value is ready to use.
+struct Watches {
+	sync.Mutex
+	set map[*watch]bool
+}
+
+struct watch {
+	path    string
+	match   func(d Dir, depth int) bool
+	c       chan Dir
+	kickc   chan bool
+	lk      sync.Mutex
+	pending []Dir
+}
+
+// Add a watch for files at or under path for which match is true
+// (or for all of them if match is nil), and return the chan where
+// the changes are sent.
+// depth is the depth of d relative to path.
+// Changes are queued, so Notify never blocks on slow receivers.
+func (ws *Watches) Add(path string, match func(d Dir, depth int) bool) <-chan Dir {
+	w := &watch{
+		path:  path,
+		match: match,
+		c:     make(chan Dir),
+		kickc: make(chan bool, 1),
+	}
+	ws.Lock()
+	if ws.set == nil {
+		ws.set = map[*watch]bool{}
+	}
+	ws.set[w] = true
+	ws.Unlock()
+	go w.deliver(ws)
+	return w.c
+}
+
+// Return true if there are watches, so that file systems may skip
+// the work needed to notify changes if nobody is interested.
+func (ws *Watches) Active() bool {
+	ws.Lock()
+	defer ws.Unlock()
+	return len(ws.set) > 0
+}
+
+// Notify a change of the given type for the file with the given dir entry.
+func (ws *Watches) Notify(t ChgType, d Dir) {
+	p := d["path"]
+	ws.Lock()
+	defer ws.Unlock()
+	for w := range ws.set {
+		if !HasPrefix(p, w.path) {
+			continue
+		}
+		depth := len(Elems(p)) - len(Elems(w.path))
+		if w.match != nil && !w.match(d, depth) {
+			continue
+		}
+		nd := d.Dup()
+		nd["chg"] = t.String()
+		w.post(nd)
+	}
+}
+
+// Stop all the watches (eg., when the file system is gone) with
+// the given error.
+func (ws *Watches) Close(err error) {
+	ws.Lock()
+	set := ws.set
+	ws.set = nil
+	ws.Unlock()
+	for w := range set {
+		close(w.kickc)
+		close(w.c, err)
+	}
+}
+
+func (w *watch) post(d Dir) {
+	w.lk.Lock()
+	w.pending = append(w.pending, d)
+	w.lk.Unlock()
+	select {
+	case w.kickc <- true:
+	default:
+	}
+}
+
+func (w *watch) deliver(ws *Watches) {
+	for range w.kickc {
+		w.lk.Lock()
+		ds := w.pending
+		w.pending = nil
+		w.lk.Unlock()
+		for _, d := range ds {
+			if ok := w.c <- d; !ok {
+				ws.Lock()
+				delete(ws.set, w)
+				ws.Unlock()
+				return
+			}
+		}
+	}
+}
@@ -20,7 +20,10 @@ func (fs *Fs) chkWalk(p string, mkall bool) error {
 			if !mkall || !zx.IsNotExist(err) {
 				return err
 			}
-			path := fpath.Join(fs.root, rp)
+			path, e := fs.uxpath(rp, false)
+			if e != nil {
+				return e
+			}
 			if e := os.Mkdir(path, 0755); e != nil {
 				return err
 			}
@@ -13,15 +13,16 @@ import (
 	"clive/zx/pred"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/user"
 	fpath "path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 struct Fs {
@@ -30,8 +31,11 @@ struct Fs {
 	*zx.Stats
 	ai      *auth.Info
 	root    string
+	rroot   string // root, with links resolved
 	attrs   bool
 	zxperms bool
+	watches *zx.Watches // shared by the views made by Auth
+	locks   *zx.Locks   // idem
 }
 
 var ctldir = zx.Dir{
@@ -51,8 +55,9 @@ var (
 	uids   = map[uint32]string{}
 	uidslk sync.Mutex
 
-	dontremove bool      // set during testing to prevent removes
-	_fs        zx.FullFs = &Fs{}
+	dontremove bool         // set during testing to prevent removes
+	_fs        zx.FullFs    = &Fs{}
+	_fs2       zx.Symlinker = &Fs{}
 
 	paranoia = false // if true, would panic if removing outside /tmp/...
 )
@@ -91,13 +96,20 @@ func new(root string, attrs bool) (*Fs, error) {
 	if err != nil {
 		return nil, err
 	}
+	rp, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return nil, err
+	}
 	tag := fpath.Base(root)
 	fs := &Fs{
-		root:  p,
-		attrs: attrs,
-		Flag:  &dbg.Flag{Tag: tag},
-		Flags: &zx.Flags{},
-		Stats: &zx.Stats{},
+		root:    p,
+		rroot:   rp,
+		attrs:   attrs,
+		Flag:    &dbg.Flag{Tag: tag},
+		Flags:   &zx.Flags{},
+		Stats:   &zx.Stats{},
+		watches: &zx.Watches{},
+		locks:   &zx.Locks{},
 	}
 	fs.Flags.Add("debug", &fs.Debug)
 	fs.Flags.AddRO("attrs", &fs.attrs)
@@ -173,6 +185,91 @@ func (fs *Fs) CheckZXPerms() {
 }
 
 func (fs *Fs) stat(p string, chk bool) (zx.Dir, error) {
+	return fs.statf(p, chk, true)
+}
+
+func (fs *Fs) lstat(p string, chk bool) (zx.Dir, error) {
+	return fs.statf(p, chk, false)
+}
+
+// Return the target for the link at path, as a path in the tree
+// if it refers to a file within it.
+func (fs *Fs) target(path string) string {
+	t, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	if fpath.IsAbs(t) {
+		if tp := zx.Suffix(t, fs.root); tp != "" {
+			return tp
+		}
+	}
+	return t
+}
+
+// Resolve the links in the UNIX path, like filepath.EvalSymlinks,
+// but the last elements may be missing (eg. to create them),
+// and the last one is not followed if follow is not set.
+func evalLinks(path string, follow bool) (string, error) {
+	if !follow && path != "/" {
+		dir, err := evalLinks(fpath.Dir(path), true)
+		return fpath.Join(dir, fpath.Base(path)), err
+	}
+	for n := 0; n < 255; n++ {
+		r, err := filepath.EvalSymlinks(path)
+		if err == nil || !os.IsNotExist(err) {
+			return r, err
+		}
+		// a file is missing, or it's a dangling link.
+		dir, rest := path, ""
+		for {
+			fi, err := os.Lstat(dir)
+			if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				t, err := os.Readlink(dir)
+				if err != nil {
+					return "", err
+				}
+				if !fpath.IsAbs(t) {
+					t = fpath.Join(fpath.Dir(dir), t)
+				}
+				path = fpath.Join(t, rest)
+				break
+			}
+			if err == nil {
+				r, err := filepath.EvalSymlinks(dir)
+				return fpath.Join(r, rest), err
+			}
+			if dir == "/" || dir == "." {
+				return "", err
+			}
+			rest = fpath.Join(fpath.Base(dir), rest)
+			dir = fpath.Dir(dir)
+		}
+	}
+	return "", fmt.Errorf("%s: too many links", path)
+}
+
+// Return the UNIX path for p, checking that it refers to a file
+// within the tree once links are followed (but for the last one if
+// follow is not set).
+// Links made in the tree may be moved (or made by others), so
+// their targets are not trusted to stay within it.
+func (fs *Fs) uxpath(p string, follow bool) (string, error) {
+	if p == "/" {
+		follow = true // the root may be a link, and it's ok
+	}
+	path := fpath.Join(fs.root, p)
+	rp, err := evalLinks(path, follow)
+	if err != nil {
+		return "", err
+	}
+	if !zx.HasPrefix(rp, fs.rroot) {
+		return "", fmt.Errorf("%s: %s", p, zx.ErrPerm)
+	}
+	return path, nil
+}
+
+func (fs *Fs) statf(p string, chk, follow bool) (zx.Dir, error) {
 	p, err := zx.UseAbsPath(p)
 	if err != nil {
 		return nil, err
@@ -187,12 +284,24 @@ func (fs *Fs) stat(p string, chk bool) (zx.Dir, error) {
 		d["addr"] = fmt.Sprintf("lfs!%s!/Ctl", fs.root)
 		return d, nil
 	}
-	path := fpath.Join(fs.root, p)
-	st, err := os.Stat(path)
+	path, err := fs.uxpath(p, follow)
+	if err != nil {
+		return nil, err
+	}
+	var st os.FileInfo
+	if follow {
+		st, err = os.Stat(path)
+	} else {
+		st, err = os.Lstat(path)
+	}
 	if err != nil {
 		return nil, err
 	}
 	d := newDir(st)
+	if d["type"] == "l" {
+		d["target"] = fs.target(path)
+	}
+	d["name"] = fpath.Base(p)
 	d["path"] = p
 	d["addr"] = fmt.Sprintf("lfs!%s!%s", fs.root, p)
 	if p == "/" {
@@ -201,6 +310,7 @@ func (fs *Fs) stat(p string, chk bool) (zx.Dir, error) {
 	if fs.attrs || fs.zxperms {
 		ac.get(path, d)
 	}
+	fs.locks.SetAttrs(p, d)
 	return d, nil
 }
 
@@ -215,6 +325,17 @@ func (fs *Fs) Stat(p string) <-chan zx.Dir {
 	return c
 }
 
+func (fs *Fs) Lstat(p string) <-chan zx.Dir {
+	fs.Count(zx.Sstat)
+	c := make(chan zx.Dir, 1)
+	d, err := fs.lstat(p, false)
+	if err == nil {
+		c <- d
+	}
+	close(c, err)
+	return c
+}
+
 func (fs *Fs) getCtl(off, count int64, dc chan<- []byte) error {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "lfs %s:\n", fs.Tag)
@@ -238,6 +359,73 @@ func (fs *Fs) getCtl(off, count int64, dc chan<- []byte) error {
 	return nil
 }
 
+// Does the relative target for a link in dir refer to a file outside the tree?
+func escapes(dir, target string) bool {
+	n := 0
+	for _, el := range strings.Split(dir, "/") {
+		if el != "" {
+			n++
+		}
+	}
+	for _, el := range strings.Split(target, "/") {
+		switch el {
+		case "", ".":
+		case "..":
+			if n--; n < 0 {
+				return true
+			}
+		default:
+			n++
+		}
+	}
+	return false
+}
+
+func (fs *Fs) symlink(target, newp string) error {
+	newp, err := zx.UseAbsPath(newp)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return fmt.Errorf("symlink %s: no target", newp)
+	}
+	if newp == "/Ctl" || newp == "/" {
+		return fmt.Errorf("symlink %s: %s", newp, zx.ErrPerm)
+	}
+	if fs.zxperms {
+		if err := fs.chkPut(fpath.Dir(newp), false); err != nil {
+			return err
+		}
+	}
+	// Absolute targets are kept as UNIX paths, so that the system
+	// follows them within the tree and not outside of it.
+	uxtarget := target
+	if fpath.IsAbs(target) {
+		uxtarget = fpath.Join(fs.root, fpath.Clean(target))
+	} else if escapes(fpath.Dir(newp), target) {
+		return fmt.Errorf("symlink %s: %s: outside of the tree", newp, target)
+	}
+	path, err := fs.uxpath(newp, false)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(uxtarget, path)
+}
+
+func (fs *Fs) Symlink(target, newp string) <-chan error {
+	c := make(chan error, 1)
+	fs.Count(zx.Slink)
+	err := fs.symlink(target, newp)
+	if err == nil {
+		if d := fs.watched(newp); d != nil {
+			fs.watches.Notify(zx.Add, d)
+		}
+	}
+	c <- err
+	close(c, err)
+	return c
+}
+
 // can't use ch, because it uses chan<- face{} and not chan<- []byte
 func readBytes(r io.Reader, c chan<- []byte) error {
 	var err error
@@ -274,7 +462,10 @@ func (fs *Fs) get(p string, off, count int64, dc chan<- []byte) error {
 			return err
 		}
 	}
-	path := fpath.Join(fs.root, p)
+	path, err := fs.uxpath(p, true)
+	if err != nil {
+		return err
+	}
 	fd, err := os.Open(path)
 	if err != nil {
 		return err
@@ -298,10 +489,15 @@ func (fs *Fs) get(p string, off, count int64, dc chan<- []byte) error {
 		}
 	}
 
-	ds, err := ioutil.ReadDir(path)
+	// Only the entries sent are stated, so listing large
+	// directories a few entries at a time is cheap.
+	names, err := dirNames(fd)
+	if err != nil {
+		return err
+	}
 	ctlsent := false
 Dloop:
-	for i := 0; i < len(ds); {
+	for i := 0; i < len(names); {
 		if off > 0 {
 			off--
 			if !ctlsent && p == "/" {
@@ -329,34 +525,47 @@ Dloop:
 			// but not i++
 			continue
 		}
-		fi := ds[i]
-		if fi.Name() == AttrFile || fi.Name() == ".#zx" { // .#zx was the old AttrFile
-			if i == len(ds)-1 {
-				break
-			}
-			copy(ds[i:], ds[i+1:])
-			ds = ds[:len(ds)-1]
+		cp := fpath.Join(p, names[i])
+		cpath := fpath.Join(path, names[i])
+		i++
+		fi, err := os.Lstat(cpath)
+		if err != nil {
+			// removed while we list the dir
 			continue
 		}
 		d := newDir(fi)
-		if d["name"] == AttrFile {
-			dbg.Warn("zux get: dir has name .zx")
-		}
-		cp := fpath.Join(p, fi.Name())
-		cpath := fpath.Join(path, fi.Name())
 		d["path"] = cp
 		d["addr"] = fmt.Sprintf("lfs!%s!%s", fs.root, cp)
+		if d["type"] == "l" {
+			d["target"] = fs.target(cpath)
+		}
 		if fs.attrs || fs.zxperms {
 			ac.get(cpath, d)
 		}
 		if ok := dc <- d.Bytes(); !ok {
 			return cerror(dc)
 		}
-		i++
 	}
 	return nil
 }
 
+// Return the sorted names for the entries in the dir open at fd,
+// without our attribute files.
+func dirNames(fd *os.File) ([]string, error) {
+	all, err := fd.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	names := all[:0]
+	for _, n := range all {
+		if n != AttrFile && n != ".#zx" { // .#zx was the old AttrFile
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func (fs *Fs) Get(path string, off, count int64) <-chan []byte {
 	c := make(chan []byte)
 	go func() {
@@ -390,7 +599,10 @@ func (fs *Fs) wstat(p string, d zx.Dir, chk bool) error {
 			return err
 		}
 	}
-	path := fpath.Join(fs.root, p)
+	path, err := fs.uxpath(p, true)
+	if err != nil {
+		return err
+	}
 	if _, ok := d["size"]; ok && d["type"] != "d" {
 		sz := d.Size()
 		err = os.Truncate(path, sz)
@@ -416,6 +628,10 @@ func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
 	go func() {
 		fs.Count(zx.Swstat)
 		d = d.SysDup()
+		if err := d.CheckAttrs(); err != nil {
+			close(rc, err)
+			return
+		}
 		if d["wuid"] != "" || d["size"] != "" {
 			d["wuid"] = u.Uid
 			if fs.attrs && fs.ai != nil {
@@ -427,6 +643,7 @@ func (fs *Fs) Wstat(p string, d zx.Dir) <-chan zx.Dir {
 			var d zx.Dir
 			d, err = fs.stat(p, false)
 			if err == nil {
+				fs.watches.Notify(zx.Meta, d)
 				rc <- d
 			}
 		}
@@ -452,7 +669,10 @@ func (fs *Fs) remove(p string, all bool) error {
 			return err
 		}
 	}
-	path := fpath.Join(fs.root, p)
+	path, err := fs.uxpath(p, false)
+	if err != nil {
+		return err
+	}
 	if dontremove {
 		dbg.Warn("%s: dontremove: rm %s", fs.Tag, path)
 		return nil
@@ -475,9 +695,26 @@ func (fs *Fs) remove(p string, all bool) error {
 	return err
 }
 
+// Return the dir entry for p if someone may want to know
+// about changes to it, or nil.
+func (fs *Fs) watched(p string) zx.Dir {
+	if !fs.watches.Active() {
+		return nil
+	}
+	d, _ := fs.lstat(p, false)
+	return d
+}
+
 func (fs *Fs) Remove(p string) <-chan error {
 	c := make(chan error, 1)
+	d := fs.watched(p)
 	err := fs.remove(p, false)
+	if err == nil {
+		fs.locks.Clear(fpath.Clean(p))
+	}
+	if err == nil && d != nil {
+		fs.watches.Notify(zx.Del, d)
+	}
 	c <- err
 	close(c, err)
 	return c
@@ -485,7 +722,11 @@ func (fs *Fs) Remove(p string) <-chan error {
 
 func (fs *Fs) RemoveAll(p string) <-chan error {
 	c := make(chan error, 1)
+	d := fs.watched(p)
 	err := fs.remove(p, true)
+	if err == nil && d != nil {
+		fs.watches.Notify(zx.Del, d)
+	}
 	c <- err
 	close(c, err)
 	return c
@@ -532,8 +773,14 @@ func (fs *Fs) move(from, to string) error {
 	if inconsistentMove(from, to) {
 		return fmt.Errorf("move %s: inconsistent move", from)
 	}
-	pathfrom := fpath.Join(fs.root, pfrom)
-	pathto := fpath.Join(fs.root, pto)
+	pathfrom, err := fs.uxpath(pfrom, false)
+	if err != nil {
+		return err
+	}
+	pathto, err := fs.uxpath(pto, false)
+	if err != nil {
+		return err
+	}
 
 	var d zx.Dir
 	if fs.attrs {
@@ -550,7 +797,14 @@ func (fs *Fs) move(from, to string) error {
 func (fs *Fs) Move(from, to string) <-chan error {
 	c := make(chan error, 1)
 	fs.Count(zx.Smove)
+	d := fs.watched(from)
 	err := fs.move(from, to)
+	if err == nil && d != nil && from != to {
+		fs.watches.Notify(zx.Del, d)
+		if nd := fs.watched(to); nd != nil {
+			fs.watches.Notify(zx.Add, nd)
+		}
+	}
 	c <- err
 	close(c, err)
 	return c
@@ -591,8 +845,14 @@ func (fs *Fs) link(oldp, newp string) error {
 	if inconsistentLink(oldp, newp) {
 		return fmt.Errorf("link %s: inconsistent link", oldp)
 	}
-	pathold := fpath.Join(fs.root, oldp)
-	pathnew := fpath.Join(fs.root, newp)
+	pathold, err := fs.uxpath(oldp, false)
+	if err != nil {
+		return err
+	}
+	pathnew, err := fs.uxpath(newp, false)
+	if err != nil {
+		return err
+	}
 	return os.Link(pathold, pathnew)
 }
 
@@ -600,6 +860,11 @@ func (fs *Fs) Link(oldp, newp string) <-chan error {
 	c := make(chan error, 1)
 	fs.Count(zx.Slink)
 	err := fs.link(oldp, newp)
+	if err == nil {
+		if d := fs.watched(newp); d != nil {
+			fs.watches.Notify(zx.Add, d)
+		}
+	}
 	c <- err
 	close(c, err)
 	return c
@@ -624,7 +889,10 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) error {
 		return fs.putCtl(c)
 	}
 	mkall := false
-	path := fpath.Join(fs.root, p)
+	path, err := fs.uxpath(p, true)
+	if err != nil {
+		return err
+	}
 	flg := os.O_RDWR // in case we resize it
 	if d["type"] == "F" {
 		d["type"] = "-"
@@ -633,7 +901,7 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) error {
 		d["type"] = "d"
 		mkall = true
 	}
-	if d["type"] == "-" && d["size"] == "" {
+	if d["type"] == "-" && d["size"] == "" && off >= 0 {
 		d["size"] = "0"
 	}
 	mode := d.Mode()
@@ -690,10 +958,10 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) error {
 		close(c)
 	}
 	var sz = int64(-1)
-	whence := 0
 	if off < 0 {
+		// each write goes at the end, even if others append
+		flg |= os.O_APPEND
 		off = 0
-		whence = 2
 	}
 	if d["size"] != "" {
 		sz = d.Size()
@@ -714,8 +982,8 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) error {
 	//delete(d, "mode")
 	delete(d, "size")
 	fs.wstat(p, d, false)
-	if off != 0 || whence != 0 {
-		if _, err := fd.Seek(off, whence); err != nil {
+	if off != 0 {
+		if _, err := fd.Seek(off, 0); err != nil {
 			return err
 		}
 	}
@@ -736,6 +1004,7 @@ func (fs *Fs) Put(p string, d zx.Dir, off int64, c <-chan []byte) <-chan zx.Dir
 	go func() {
 		fs.Count(zx.Sput)
 		d = d.SysDup()
+		old := fs.watched(p)
 		err := fs.put(p, d, off, c)
 		if err != nil {
 			close(c, err)
@@ -744,6 +1013,11 @@ func (fs *Fs) Put(p string, d zx.Dir, off int64, c <-chan []byte) <-chan zx.Dir
 			var d zx.Dir
 			d, err = fs.stat(p, false)
 			if err == nil {
+				if old == nil {
+					fs.watches.Notify(zx.Add, d)
+				} else {
+					fs.watches.Notify(zx.Data, d)
+				}
 				rc <- d
 			}
 		}
@@ -825,6 +1099,10 @@ func (fs *Fs) find(p, fpred, spref, dpref string, depth int, c chan<- zx.Dir) er
 	if err != nil {
 		return err
 	}
+	// paths in dirs may be rewritten, but addrs are not.
+	fp.SetData(func(d zx.Dir) ([]byte, error) {
+		return zx.GetAll(fs, fs.dpath(d))
+	})
 	if spref != dpref {
 		suff := zx.Suffix(p, spref)
 		if suff == "" {
@@ -884,3 +1162,60 @@ func (fs *Fs) FindGet(path, fpred, spref, dpref string, depth0 int) <-chan face{
 	}()
 	return c
 }
+
+// Watch changes made through fs (see zx.Watcher).
+func (fs *Fs) Watch(p, fpred string) <-chan zx.Dir {
+	p, err := zx.UseAbsPath(p)
+	if err == nil {
+		var fp *pred.Pred
+		fp, err = pred.New(fpred)
+		if err == nil {
+			return fs.watches.Add(p, func(d zx.Dir, depth int) bool {
+				ok, _, _ := fp.EvalAt(d, depth)
+				return ok
+			})
+		}
+	}
+	c := make(chan zx.Dir)
+	close(c, err)
+	return c
+}
+
+// The user for locks.
+func (fs *Fs) user() string {
+	if fs.ai != nil {
+		return fs.ai.Uid
+	}
+	return u.Uid
+}
+
+// Lock a file (see zx.Locker).
+func (fs *Fs) Lock(p, owner string, tmout time.Duration) <-chan zx.Dir {
+	c := make(chan zx.Dir, 1)
+	d, err := fs.stat(p, true)
+	if err == nil && fs.zxperms {
+		err = fs.chkPut(d["path"], false)
+	}
+	if err == nil {
+		p = d["path"]
+		err = fs.locks.Lock(p, fs.user(), owner, tmout)
+	}
+	if err == nil {
+		fs.locks.SetAttrs(p, d)
+		c <- d
+	}
+	close(c, err)
+	return c
+}
+
+// Unlock a file (see zx.Locker).
+func (fs *Fs) Unlock(p, owner string) <-chan error {
+	c := make(chan error, 1)
+	p, err := zx.UseAbsPath(p)
+	if err == nil {
+		err = fs.locks.Release(p, fs.user(), owner)
+	}
+	c <- err
+	close(c, err)
+	return c
+}
@@ -82,6 +82,10 @@ func TestGets(t *testing.T) {
 	runTest(t, fstest.Gets)
 }
 
+func TestDirPages(t *testing.T) {
+	runTest(t, fstest.DirPages)
+}
+
 func TestFinds(t *testing.T) {
 	runTest(t, fstest.Finds)
 }
@@ -94,6 +98,22 @@ func TestPuts(t *testing.T) {
 	runTest(t, fstest.Puts)
 }
 
+func TestAppends(t *testing.T) {
+	runTest(t, fstest.Appends)
+}
+
+func TestCopies(t *testing.T) {
+	runTest(t, fstest.Copies)
+}
+
+func TestGreps(t *testing.T) {
+	runTest(t, fstest.Greps)
+}
+
+func TestSums(t *testing.T) {
+	runTest(t, fstest.Sums)
+}
+
 func TestMkdirs(t *testing.T) {
 	runTest(t, fstest.Mkdirs)
 }
@@ -110,6 +130,10 @@ func TestAttrs(t *testing.T) {
 	runTest(t, fstest.Attrs)
 }
 
+func TestUserAttrs(t *testing.T) {
+	runTest(t, fstest.UserAttrs)
+}
+
 func TestMoves(t *testing.T) {
 	runTest(t, fstest.Moves)
 }
@@ -117,3 +141,15 @@ func TestMoves(t *testing.T) {
 func TestAsAFile(t *testing.T) {
 	runTest(t, fstest.AsAFile)
 }
+
+func TestSymlinks(t *testing.T) {
+	runTest(t, fstest.Symlinks)
+}
+
+func TestWatches(t *testing.T) {
+	runTest(t, fstest.Watches)
+}
+
+func TestLocks(t *testing.T) {
+	runTest(t, fstest.Locks)
+}
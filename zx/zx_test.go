@@ -236,6 +236,41 @@ func TestDir(t *testing.T) {
 
 }
 
+func TestAttrs(t *testing.T) {
+	d := Dir{"type": "-", "mode": "0644", "Upath": "/x", "lockowner": "me"}
+	d.SetInt("cnt", -3)
+	d.SetList("tags", []string{"a", "b c"})
+	printf("dir is %s\n", d)
+	if d.Int("cnt") != -3 {
+		t.Fatalf("bad int")
+	}
+	if l := d.List("tags"); len(l) != 2 || l[1] != "b c" {
+		t.Fatalf("bad list %q", l)
+	}
+	if l := d.List("none"); len(l) != 0 {
+		t.Fatalf("bad empty list")
+	}
+	if err := d.CheckAttrs(); err != nil {
+		t.Fatalf("check: %s", err)
+	}
+	nd := d.SysDup()
+	if nd["Upath"] != "" || nd["lockowner"] != "" || nd["cnt"] != "-3" {
+		t.Fatalf("bad sysdup %s", nd)
+	}
+	if !IsSys("chg") || IsUser("chg") || IsUser("mode") || !IsUser("cnt") {
+		t.Fatalf("bad attr kinds")
+	}
+	bad := [][2]string{
+		{"mode", "0x"}, {"mode", "017777"}, {"size", "-1"}, {"type", "dd"},
+		{"9a", "x"}, {"a b", "x"}, {"a", "\xff"},
+	}
+	for _, b := range bad {
+		if err := CheckAttr(b[0], b[1]); !IsBadAttr(err) {
+			t.Fatalf("check %s=%q: %v", b[0], b[1], err)
+		}
+	}
+}
+
 struct ptest {
 	p, e string
 	m    bool
@@ -308,3 +343,61 @@ func TestPathPrefixMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestCollation(t *testing.T) {
+	debug = testing.Verbose()
+	ds := []Dir{
+		Dir{"name": "file10"},
+		Dir{"name": "file2"},
+		Dir{"name": "file02"},
+		Dir{"name": "a"},
+		Dir{"name": "file1b"},
+		Dir{"name": "file"},
+	}
+	SortDirsBy(ds, ByteOrder)
+	var out []string
+	for _, d := range ds {
+		out = append(out, d["name"])
+	}
+	printf("bytes: %v\n", out)
+	if s := fmt.Sprint(out); s != "[a file file02 file10 file1b file2]" {
+		t.Fatalf("bad byte order %s", s)
+	}
+	SortDirsBy(ds, Natural)
+	out = nil
+	for _, d := range ds {
+		out = append(out, d["name"])
+	}
+	printf("natural: %v\n", out)
+	if s := fmt.Sprint(out); s != "[a file file1b file2 file02 file10]" {
+		t.Fatalf("bad natural order %s", s)
+	}
+	c, err := ParseCollation("locale,natural")
+	if err != nil || c != Locale|Natural || c.String() != "locale,natural" {
+		t.Fatalf("parse: %v %s", c, err)
+	}
+	if _, err := ParseCollation("bad"); err == nil {
+		t.Fatal("bad collation didn't fail")
+	}
+	if !Natural.LessPath("/a/x2", "/a/x10/b") || !Natural.LessPath("/a", "/a/b") {
+		t.Fatal("bad path order")
+	}
+}
+
+func TestSumsOff(t *testing.T) {
+	dat := []byte("0123456789abcdef01234")
+	sums := DataSums(4, dat)
+	if len(sums) != 6 {
+		t.Fatalf("got %d sums", len(sums))
+	}
+	if n := SumsOff(sums, sums, 4); n != 20 {
+		t.Fatalf("same data: off %d", n)
+	}
+	other := DataSums(4, []byte("0123"), []byte("4567x"))
+	if n := SumsOff(sums, other, 4); n != 8 {
+		t.Fatalf("other data: off %d", n)
+	}
+	if n := SumsOff(sums, nil, 4); n != 0 {
+		t.Fatalf("no data: off %d", n)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	fpath "path"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,6 +45,8 @@ const (
 var (
 	cacheTout = 5 * time.Minute
 	syncIval  = time.Minute
+	// metadata timeout while the server notifies changes
+	watchTout = time.Hour
 )
 
 // operations for a zxc cached file
@@ -61,7 +65,9 @@ interface fsFile {
 	gotMeta(d zx.Dir) error
 	gotData(c <-chan []byte) error
 	gotDir(cds []zx.Dir) error
-	gone() // the file is gone from rfs
+	gone()            // the file is gone from rfs
+	journal()         // record its changes in the journal, if any
+	setBase(d zx.Dir) // set the server attributes we started from
 	walk1(el string) (fsFile, error)
 	wstat(nd zx.Dir) error
 	getDir() ([]zx.Dir, error)
@@ -89,6 +95,7 @@ struct mFile {
 	child map[string]*mFile
 	data  *mblk.Buffer
 	t     time.Time
+	base  zx.Dir // server attributes we started changing from
 }
 
 var ctlfile = &mFile{cFile: cFile{d: ctldir}}
@@ -99,6 +106,10 @@ interface fsCache {
 	root() fsFile
 	sync(rfs zx.Fs) error
 	inval()
+	stale()           // revalidate all metadata
+	notify(d zx.Dir)  // a change notified by the server
+	watching(on bool) // the server does (not) notify changes
+	setJournal(j *journal)
 	dump()
 }
 
@@ -107,9 +118,11 @@ interface fsCache {
 // underlying fs all the times, and we sync right after every update operation.
 struct mCache {
 	dbg.Flag
-	Verb  bool
-	stats bool // synchronous cache
-	slash *mFile
+	Verb    bool
+	stats   bool  // synchronous cache
+	watched int32 // the server notifies changes (atomic)
+	slash   *mFile
+	j       *journal // changes not yet synced, if any
 }
 
 func (c cStatus) String() string {
@@ -192,7 +205,11 @@ func (mf *mFile) metaOk() bool {
 	case cNewMeta, cMeta, cData, cDel, cGone:
 		return true
 	case cNew, cClean:
-		ok := time.Since(mf.t) < cacheTout && !mf.c.stats
+		tout := cacheTout
+		if mf.c.isWatched() {
+			tout = watchTout
+		}
+		ok := time.Since(mf.t) < tout && !mf.c.stats
 		if !ok {
 			mf.Dprintf("meta not ok\n")
 		}
@@ -280,6 +297,9 @@ func (mf *mFile) gotMeta(d zx.Dir) error {
 	}
 	mf.Dprintf("got meta\n")
 	mf.t = time.Now()
+	if !mf.dirty() {
+		mf.base = srvBase(d)
+	}
 	if d["type"] != mf.d["type"] {
 		mf.gone()
 		mf.sts = cNew
@@ -471,6 +491,7 @@ func (mf *mFile) remove(all bool) error {
 }
 
 func (mf *mFile) newFile(d zx.Dir, rfs zx.Fs) (fsFile, error) {
+	var base zx.Dir
 	nm := d["name"]
 	oc, ok := mf.child[nm]
 	if ok {
@@ -483,6 +504,7 @@ func (mf *mFile) newFile(d zx.Dir, rfs zx.Fs) (fsFile, error) {
 		oc.Lock()
 		must := oc.sts == cDel &&
 			(oc.d["type"] == "'d" || oc.d["type"] != d["type"])
+		base = oc.base
 		oc.Unlock()
 		if must {
 			oc.sync(fs)
@@ -494,6 +516,8 @@ func (mf *mFile) newFile(d zx.Dir, rfs zx.Fs) (fsFile, error) {
 		return nil, err
 	}
 	nf.sts = cData
+	// the server version, if any, is that of the removed file
+	nf.base = base
 	mf.child[nm] = nf
 	nf.c = mf.c
 	mf.Dprintf("new file %s\n", d["path"])
@@ -593,6 +617,90 @@ func (mf *mFile) invalAll() {
 	mf.Unlock()
 }
 
+// Like invalAll, but only the metadata is considered old, and
+// data is invalidated later if the mtime or size have changed.
+func (mf *mFile) staleAll() {
+	mf.Lock()
+	if mf.sts != cDel && mf.sts != cGone {
+		mf.t = time.Time{}
+		for _, cf := range mf.child {
+			cf.staleAll()
+		}
+	}
+	mf.Unlock()
+}
+
+// Return true if the file has changes not yet synced.
+func (mf *mFile) dirty() bool {
+	switch mf.sts {
+	case cNewMeta, cMeta, cData, cDel:
+		return true
+	default:
+		return false
+	}
+}
+
+// Return the attributes used to tell if the server file was
+// changed by others while we had changes not yet synced.
+func srvBase(d zx.Dir) zx.Dir {
+	if d == nil {
+		return nil
+	}
+	return zx.Dir{"mtime": d["mtime"], "wuid": d["wuid"]}
+}
+
+func (mf *mFile) setBase(d zx.Dir) {
+	mf.base = srvBase(d)
+}
+
+func (mf *mFile) journal() {
+	if mf.c == nil || mf.c.j == nil {
+		return
+	}
+	if err := mf.c.j.log(mf); err != nil {
+		dbg.Warn("journal: %s: %s", mf, err)
+	}
+}
+
+// Return true if the file was changed in the server since we
+// started changing it, or if it's new here and was created there.
+func (mf *mFile) conflicts(rfs zx.Fs) bool {
+	rd, err := zx.Stat(rfs, mf.d["path"])
+	if err != nil {
+		return false
+	}
+	return mf.base == nil || rd["mtime"] != mf.base["mtime"] ||
+		rd["wuid"] != mf.base["wuid"]
+}
+
+// Save our data for a file in conflict as a new file named after it,
+// and drop our changes, so the server version is used from now on.
+func (mf *mFile) keepConflict(rfs zx.RWFs) error {
+	cp := mf.d["path"] + ".conflict"
+	dbg.Warn("sync: %s: conflict: local changes saved in %s", mf, cp)
+	d := mf.d.Dup()
+	d["path"] = cp
+	d["name"] = fpath.Base(cp)
+	d["addr"] = "zxc!" + cp
+	d.SetSize(int64(mf.data.Len()))
+	c := make(chan []byte)
+	rc := rfs.Put(cp, d, 0, c)
+	_, _, err := mf.data.SendTo(0, -1, c)
+	close(c, err)
+	<-rc
+	if err := cerror(rc); err != nil {
+		dbg.Warn("sync: put: %s", err)
+		return err
+	}
+	mf.sts = cNew
+	mf.wd = nil
+	mf.base = nil
+	mf.t = time.Time{}
+	mf.data.Reset()
+	mf.Dprintf("sync: conflict, cNew\n")
+	return nil
+}
+
 func (mf *mFile) sync(fs zx.Fs) error {
 	rfs, ok := fs.(zx.RWFs)
 	if !ok {
@@ -625,6 +733,7 @@ func (mf *mFile) sync(fs zx.Fs) error {
 			mf.sts = cGone
 			mf.Dprintf("sync: rm, cGone\n")
 		}
+		mf.journal()
 		mf.Unlock()
 		return err
 	case cGone, cNew, cClean:
@@ -636,12 +745,16 @@ func (mf *mFile) sync(fs zx.Fs) error {
 			dbg.Warn("sync: wstat: %s", err)
 		} else {
 			// we could update our stat with the returned one
-			_ = rd
+			mf.base = srvBase(rd)
 			mf.wd = nil
 			mf.sts = cNew
 			mf.Dprintf("sync: wstat, cNew\n")
 		}
 	case cData:
+		if mf.d["type"] != "d" && mf.conflicts(rfs) {
+			err = mf.keepConflict(rfs)
+			break
+		}
 		mf.vprintf("sync: put %s", mf)
 		c := make(chan []byte)
 		if mf.d["type"] == "d" {
@@ -662,12 +775,13 @@ func (mf *mFile) sync(fs zx.Fs) error {
 			dbg.Warn("sync: put: %s", err)
 		} else {
 			// we could update our stat with the returned one
-			_ = rd
+			mf.base = srvBase(rd)
 			mf.wd = nil
 			mf.sts = cClean
 			mf.Dprintf("sync: put, cClean\n")
 		}
 	}
+	mf.journal()
 	// We copy the children pointers to avoid locking the
 	// entire tree while we sync
 	cs, ds := mf.children()
@@ -716,9 +830,10 @@ func (mc *mCache) newFile(d zx.Dir) (*mFile, error) {
 		cFile: cFile{
 			d: d,
 		},
-		c:   mc,
-		sts: cNew,
-		t:   time.Now(),
+		c:    mc,
+		sts:  cNew,
+		t:    time.Now(),
+		base: srvBase(d),
 	}
 	if d["type"] == "d" {
 		f.child = map[string]*mFile{}
@@ -773,6 +888,90 @@ func (mc *mCache) inval() {
 	mc.slash.invalAll()
 }
 
+func (mc *mCache) setJournal(j *journal) {
+	mc.j = j
+}
+
+func (mc *mCache) stale() {
+	mc.slash.staleAll()
+}
+
+func (mc *mCache) watching(on bool) {
+	if on {
+		atomic.StoreInt32(&mc.watched, 1)
+	} else {
+		atomic.StoreInt32(&mc.watched, 0)
+	}
+}
+
+func (mc *mCache) isWatched() bool {
+	return mc != nil && atomic.LoadInt32(&mc.watched) != 0
+}
+
+// Return the cached file for the given path elements, locked, or nil
+// if it's not in the cache.
+// Nothing is retrieved from the server.
+func (mc *mCache) lookup(els []string) *mFile {
+	f := mc.slash
+	f.Lock()
+	for _, el := range els {
+		cf := f.child[el]
+		f.Unlock()
+		if cf == nil {
+			return nil
+		}
+		cf.Lock()
+		f = cf
+	}
+	return f
+}
+
+// Update the cache for a change notified by the server.
+// Files with changes not yet synced are left alone; they will
+// overwrite the server ones when synced.
+// For new and removed files, the parent's metadata is considered old,
+// so it's checked again (and the dir reread if it changed) when used.
+func (mc *mCache) notify(d zx.Dir) {
+	p := d["path"]
+	chg := d["chg"]
+	mc.Dprintf("notify %s %s\n", chg, p)
+	els := zx.Elems(p)
+	if len(els) == 0 {
+		if f := mc.lookup(nil); f != nil {
+			f.t = time.Time{}
+			f.Unlock()
+		}
+		return
+	}
+	pf := mc.lookup(els[:len(els)-1])
+	if pf == nil {
+		return
+	}
+	defer pf.Unlock()
+	nm := els[len(els)-1]
+	if chg == "add" || chg == "del" {
+		pf.t = time.Time{}
+	}
+	cf, ok := pf.child[nm]
+	if !ok {
+		return
+	}
+	cf.Lock()
+	defer cf.Unlock()
+	if cf.dirty() {
+		return
+	}
+	if chg == "del" {
+		cf.gone()
+		delete(pf.child, nm)
+		return
+	}
+	nd := d.Dup()
+	delete(nd, "chg")
+	nd["addr"] = "zxc!" + p
+	cf.gotMeta(nd)
+}
+
 func (mc *mCache) dump() {
 	fmt.Fprintf(os.Stderr, "cache dump:\n")
 	mc.slash.dump(os.Stderr, 0)
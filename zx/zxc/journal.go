@@ -0,0 +1,170 @@
+package zxc
+
+import (
+	"bytes"
+	"clive/dbg"
+	"clive/zx"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The journal keeps the changes not yet synced in a local directory,
+// so they are not lost if we crash or are killed before syncing them.
+// There is one file per changed file, named after the order in which
+// it was first changed, with the packed dirs for the journal entry
+// (path and status), the file attributes, the attributes not yet synced,
+// and the server attributes we started from, followed by the file data
+// if it has to be written.
+struct journal {
+	sync.Mutex
+	dir   string
+	seq   int
+	names map[string]string // file path -> journal file name
+}
+
+// A change found in the journal
+struct jEntry {
+	path        string
+	sts         cStatus
+	d, wd, base zx.Dir
+	data        []byte
+}
+
+func parseStatus(s string) (cStatus, error) {
+	for st := cNew; st <= cGone; st++ {
+		if st.String() == s {
+			return st, nil
+		}
+	}
+	return cNew, fmt.Errorf("bad status '%s'", s)
+}
+
+func unpackEntry(b []byte) (*jEntry, error) {
+	var ds [4]zx.Dir
+	var err error
+	for i := range ds {
+		if b, ds[i], err = zx.UnpackDir(b); err != nil {
+			return nil, err
+		}
+	}
+	e := &jEntry{path: ds[0]["path"], d: ds[1], wd: ds[2], base: ds[3], data: b}
+	if e.path == "" {
+		return nil, errors.New("no path")
+	}
+	if e.sts, err = parseStatus(ds[0]["sts"]); err != nil {
+		return nil, err
+	}
+	if len(e.base) == 0 {
+		e.base = nil
+	}
+	return e, nil
+}
+
+// Open the journal at dir, creating it if needed, and return the
+// changes found there in the order they were made.
+func openJournal(dir string) (*journal, []*jEntry, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	j := &journal{dir: dir, names: map[string]string{}}
+	var es []*jEntry
+	for _, fi := range fis {
+		nm := fi.Name()
+		fn := filepath.Join(dir, nm)
+		if strings.HasSuffix(nm, ".tmp") {
+			// we died while writing it
+			os.Remove(fn)
+			continue
+		}
+		n, err := strconv.Atoi(nm)
+		if err != nil {
+			continue
+		}
+		if n >= j.seq {
+			j.seq = n + 1
+		}
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := unpackEntry(b)
+		if err != nil {
+			dbg.Warn("journal: %s: %s", fn, err)
+			continue
+		}
+		j.names[e.path] = nm
+		es = append(es, e)
+	}
+	return j, es, nil
+}
+
+// Record the changes for mf, which must be locked, or remove
+// its entry if it has no changes to sync.
+func (j *journal) log(mf *mFile) error {
+	p := mf.d["path"]
+	j.Lock()
+	defer j.Unlock()
+	nm, ok := j.names[p]
+	if !mf.dirty() {
+		if !ok {
+			return nil
+		}
+		delete(j.names, p)
+		return os.Remove(filepath.Join(j.dir, nm))
+	}
+	if !ok {
+		nm = fmt.Sprintf("%010d", j.seq)
+		j.seq++
+		j.names[p] = nm
+	}
+	var buf bytes.Buffer
+	hd := zx.Dir{"path": p, "sts": mf.sts.String()}
+	hd.WriteTo(&buf)
+	mf.d.WriteTo(&buf)
+	mf.wd.WriteTo(&buf)
+	mf.base.WriteTo(&buf)
+	if mf.sts == cData && mf.d["type"] != "d" {
+		if _, err := mf.data.WriteTo(&buf); err != nil {
+			return err
+		}
+	}
+	fn := filepath.Join(j.dir, nm)
+	fd, err := os.Create(fn + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(buf.Bytes())
+	if err == nil {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(fn+".tmp", fn)
+	}
+	if err != nil {
+		os.Remove(fn + ".tmp")
+	}
+	return err
+}
+
+// Forget about the changes for p.
+func (j *journal) drop(p string) {
+	j.Lock()
+	defer j.Unlock()
+	if nm, ok := j.names[p]; ok {
+		delete(j.names, p)
+		os.Remove(filepath.Join(j.dir, nm))
+	}
+}
@@ -44,7 +44,8 @@ struct Fs {
 	c        fsCache
 	syncc    chan bool
 	redialc  chan bool
-	redialok bool // do we redial?
+	donec    chan bool // closed when the fs is closed
+	redialok bool      // do we redial?
 }
 
 var ctldir = zx.Dir{
@@ -60,7 +61,13 @@ var ctldir = zx.Dir{
 	"wuid":  u.Uid,
 }
 
-var _fs zx.FullFs = &Fs{}
+var (
+	_fs  zx.FullFs    = &Fs{}
+	_fs2 zx.Symlinker = &Fs{}
+	_fs3 zx.Copier    = &Fs{}
+	_fs4 zx.Grepper   = &Fs{}
+	_fs5 zx.Summer    = &Fs{}
+)
 
 type ddir zx.Dir
 
@@ -83,6 +90,11 @@ func (fs *Fs) Auth(ai *auth.Info) (zx.Fs, error) {
 	return nfs, nil
 }
 
+// Return a caching fs for rfs.
+// Cached metadata is checked again with the server after a while, and
+// cached data is dropped if the mtime or size changed.
+// If rfs is a zx.Watcher, changes it notifies update the cache
+// as they happen and metadata is checked again less often.
 func New(rfs zx.Getter) (*Fs, error) {
 	rd, err := zx.Stat(rfs, "/")
 	if err != nil {
@@ -98,6 +110,7 @@ func New(rfs zx.Getter) (*Fs, error) {
 		perms:    true,
 		syncc:    make(chan bool),
 		redialc:  make(chan bool),
+		donec:    make(chan bool),
 		redialok: ok,
 	}
 	fs.Flags.Add("debug", &fs.Debug)
@@ -137,6 +150,9 @@ func New(rfs zx.Getter) (*Fs, error) {
 	}
 	fs.c = c
 	go fs.syncer()
+	if wfs, ok := rfs.(zx.Watcher); ok {
+		go fs.watcher(wfs)
+	}
 	return fs, nil
 }
 
@@ -239,8 +255,116 @@ func (fs *Fs) syncer() {
 	}
 }
 
+// Keep a journal of the changes not yet synced in the local
+// directory dir, so they are not lost if we crash before syncing them.
+// Changes found there (eg., after a crash) are first made again
+// in the cache, to be synced as usual.
+// Data changes are not synced if the server file was changed by
+// others in the mean time; a conflict is reported and our data is
+// saved in a new file named after the original one plus ".conflict".
+// It must be called before using fs.
+func (fs *Fs) Journal(dir string) error {
+	j, es, err := openJournal(dir)
+	if err != nil {
+		return err
+	}
+	fs.c.setJournal(j)
+	for _, e := range es {
+		if err := fs.replay(e); zx.IsNotExist(err) {
+			// nothing to change there
+			j.drop(e.path)
+		} else if err != nil {
+			dbg.Warn("%s: journal: %s: %s", fs.Tag, e.path, err)
+		}
+	}
+	if len(es) > 0 {
+		fs.needSync()
+	}
+	return nil
+}
+
+// Make again a change found in the journal.
+// We must remember the server attributes at the time of the change,
+// to detect conflicts later.
+func (fs *Fs) replay(e *jEntry) error {
+	fs.Dprintf("replay %s %s\n", e.sts, e.path)
+	var err error
+	switch e.sts {
+	case cDel:
+		return fs.remove(e.path, true)
+	case cNewMeta, cMeta:
+		_, err = fs.wstat(e.path, e.wd.Dup())
+	case cData:
+		d := e.d.Dup()
+		c := make(chan []byte, 1)
+		if d["type"] == "d" {
+			d["type"] = "D"
+			delete(d, "size")
+		} else {
+			d["type"] = "F"
+			d.SetSize(int64(len(e.data)))
+			c <- e.data
+		}
+		close(c)
+		_, err = fs.put(e.path, d, 0, c)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f, err := fs.walk(forStat, nil, zx.Elems(e.path)...)
+	if err != nil {
+		return err
+	}
+	f.setBase(e.base)
+	f.journal()
+	f.Unlock()
+	return nil
+}
+
+// Keep the cache coherent with the changes notified by the server.
+// If notifications stop (eg., we are disconnected) all the metadata is
+// checked again and we watch again later, perhaps after redialing.
+func (fs *Fs) watcher(wfs zx.Watcher) {
+	for {
+		wc := wfs.Watch("/", "")
+		// changes made before watching are not notified
+		fs.c.stale()
+		fs.c.watching(true)
+		for done := false; !done; {
+			select {
+			case <-fs.donec:
+				close(wc)
+				fs.c.watching(false)
+				return
+			case d, ok := <-wc:
+				if !ok {
+					done = true
+					break
+				}
+				fs.c.notify(d)
+			}
+		}
+		fs.c.watching(false)
+		fs.c.stale()
+		err := cerror(wc)
+		if !zx.IsIOError(err) {
+			fs.Dprintf("watch: %v\n", err)
+			return
+		}
+		fs.needRedial()
+		select {
+		case <-fs.donec:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
 // Syncs and closes both the fs and the underlying fs if it has a close op.
 func (fs *Fs) Close() error {
+	close(fs.donec)
 	close(fs.syncc)
 	close(fs.redialc)
 	err := fs.Sync()
@@ -453,9 +577,25 @@ func (fs *Fs) stat(p string) (zx.Dir, error) {
 }
 
 func (fs *Fs) Stat(p string) <-chan zx.Dir {
+	return fs.statc(p, true)
+}
+
+func (fs *Fs) Lstat(p string) <-chan zx.Dir {
+	return fs.statc(p, false)
+}
+
+func (fs *Fs) statc(p string, follow bool) <-chan zx.Dir {
 	fs.Count(zx.Sstat)
 	c := make(chan zx.Dir, 1)
 	d, err := fs.stat(p)
+	if err == nil && follow && d["type"] == "l" {
+		// We cache links and not the files they refer to.
+		p = d["path"]
+		d, err = zx.Stat(fs.rfs, p)
+		if err == nil {
+			d["addr"] = "zxc!" + p
+		}
+	}
 	if err == nil {
 		fs.Dprintf("stat %s: %s\n", p, ddir(d))
 		c <- d
@@ -502,6 +642,7 @@ func (fs *Fs) wstat(p string, nd zx.Dir) (zx.Dir, error) {
 		f.Unlock()
 		return nil, err
 	}
+	f.journal()
 	d = d.Dup()
 	f.Unlock()
 	if fs.sync {
@@ -516,7 +657,11 @@ func (fs *Fs) Wstat(p string, nd zx.Dir) <-chan zx.Dir {
 	fs.Count(zx.Swstat)
 	c := make(chan zx.Dir, 1)
 	nd = nd.SysDup()
-	d, err := fs.wstat(p, nd)
+	err := nd.CheckAttrs()
+	var d zx.Dir
+	if err == nil {
+		d, err = fs.wstat(p, nd)
+	}
 	if err == nil {
 		fs.Dprintf("wstat %s: %s\n\t-> %s\n", p, nd, ddir(d))
 		c <- d
@@ -649,6 +794,7 @@ func (fs *Fs) remove(p string, all bool) error {
 		return err
 	}
 	err = f.remove(all)
+	f.journal()
 	f.Unlock()
 	if fs.sync {
 		f.sync(fs.rfs)
@@ -860,6 +1006,120 @@ func (fs *Fs) Link(oldp, newp string) <-chan error {
 	return c
 }
 
+// Copies are made by the server if it's a Copier, and we retrieve
+// the directory where the copy is made again; otherwise we copy
+// the data ourselves.
+func (fs *Fs) copy(from, to string) error {
+	rfs, ok := fs.rfs.(zx.Copier)
+	if !ok {
+		return zx.CopyTo(fs, from, fs, to)
+	}
+	to, err := zx.UseAbsPath(to)
+	if err != nil {
+		return err
+	}
+	if to == "/" || to == "/Ctl" {
+		return fmt.Errorf("copy %s: %s", to, zx.ErrPerm)
+	}
+	if fs.perms {
+		if _, err := fs.stat(from); err != nil {
+			return err
+		}
+		pd, err := fs.stat(fpath.Dir(to))
+		if err != nil {
+			return err
+		}
+		if !pd.CanPut(fs.ai) {
+			return fmt.Errorf("%s: %s", to, zx.ErrPerm)
+		}
+	}
+	fs.c.sync(fs.rfs)
+	err = <-rfs.Copy(from, to)
+	f, werr := fs.walk(forStat, nil, zx.Elems(fpath.Dir(to))...)
+	if werr == nil {
+		f.inval()
+		fs.getDirData(f)
+		f.Unlock()
+	}
+	return err
+}
+
+func (fs *Fs) Copy(from, to string) <-chan error {
+	fs.Dprintf("copy %s %s...\n", from, to)
+	c := make(chan error, 1)
+	err := fs.copy(from, to)
+	if err != nil {
+		fs.Dprintf("copy %s: %s\n", from, err)
+	}
+	c <- err
+	close(c, err)
+	return c
+}
+
+// Searches are made by the server if it's a Grepper, once our changes
+// have been written back; otherwise, or when we check permissions,
+// we search the files ourselves.
+func (fs *Fs) Grep(p, fpred, rexp string, nctx int) <-chan face{} {
+	fs.Dprintf("grep %s %s %q...\n", p, fpred, rexp)
+	rfs, ok := fs.rfs.(zx.Grepper)
+	if !ok || fs.perms {
+		return zx.GrepFiles(fs, p, fpred, rexp, nctx)
+	}
+	fs.c.sync(fs.rfs)
+	return rfs.Grep(p, fpred, rexp, nctx)
+}
+
+// Checksums are computed by the server if it's a Summer, once our
+// changes have been written back; otherwise, or when we check
+// permissions, we compute them ourselves.
+func (fs *Fs) Sums(p string, blksz int64) <-chan string {
+	fs.Dprintf("sums %s %d...\n", p, blksz)
+	rfs, ok := fs.rfs.(zx.Summer)
+	if !ok || fs.perms {
+		return zx.GetSums(fs, p, blksz)
+	}
+	fs.c.sync(fs.rfs)
+	return rfs.Sums(p, blksz)
+}
+
+// Symlinks are forwarded to the server, like links are.
+func (fs *Fs) symlink(target, newp string) error {
+	rfs, ok := fs.rfs.(zx.Symlinker)
+	if !ok {
+		return fmt.Errorf("%s: symlink not supported", fs.Tag)
+	}
+	newp, err := zx.UseAbsPath(newp)
+	if err != nil {
+		return err
+	}
+	if newp == "/Ctl" || newp == "/" {
+		return fmt.Errorf("symlink %s: %s", newp, zx.ErrPerm)
+	}
+	fs.c.sync(fs.rfs)
+	f, err := fs.walk(forLink, nil, zx.Elems(newp)...)
+	if err != nil {
+		return err
+	}
+	defer f.Unlock()
+	f.inval()
+	err = <-rfs.Symlink(target, newp)
+	fs.getDirData(f)
+	return err
+}
+
+func (fs *Fs) Symlink(target, newp string) <-chan error {
+	fs.Dprintf("symlink %s %s...\n", target, newp)
+	c := make(chan error, 1)
+	fs.Count(zx.Slink)
+	err := fs.symlink(target, newp)
+	if err != nil {
+		fs.Dprintf("symlink %s: %s\n", newp, err)
+	}
+	c <- err
+	close(c, err)
+	return c
+}
+
 func (fs *Fs) putCtl(c <-chan []byte) error {
 	var buf bytes.Buffer
 	for d := range c {
@@ -985,6 +1245,9 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) (zx.Dir, error
 			return nil, err
 		}
 		if typ == "d" {
+			nf.Lock()
+			nf.journal()
+			nf.Unlock()
 			return d, nil
 		}
 		f = nf
@@ -1002,6 +1265,7 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) (zx.Dir, error
 	}
 	if typ == "d" {
 		d := f.dir().Dup()
+		f.journal()
 		f.Unlock()
 		if fs.sync {
 			f.sync(fs.rfs)
@@ -1014,6 +1278,7 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) (zx.Dir, error
 	err = f.putData(off, c, umtime)
 	f.Lock()
 	d = f.dir().Dup()
+	f.journal()
 	f.Unlock()
 	if fs.sync {
 		f.sync(fs.rfs)
@@ -1026,13 +1291,64 @@ func (fs *Fs) put(p string, d zx.Dir, off int64, c <-chan []byte) (zx.Dir, error
 	return d, nil
 }
 
+// Appends are not cached.
+// They are sent to the server, which writes the data at the end
+// of the file as it is then, and the parent directory is retrieved again.
+// Otherwise, appends made by different clients would overwrite
+// each other when the cache is synced.
+func (fs *Fs) append(p string, d zx.Dir, c <-chan []byte) (zx.Dir, error) {
+	rfs, ok := fs.rfs.(zx.Putter)
+	if !ok {
+		return nil, fmt.Errorf("%s: put not supported", fs.Tag)
+	}
+	p, err := zx.UseAbsPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if p == "/" || p == "/Ctl" {
+		return fs.put(p, d, -1, c)
+	}
+	if fs.perms {
+		fd, err := fs.stat(p)
+		if zx.IsNotExist(err) && d["type"] != "" {
+			fd, err = fs.stat(fpath.Dir(p))
+		}
+		if err != nil && !zx.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil && !fd.CanPut(fs.ai) {
+			return nil, fmt.Errorf("%s: %s", p, zx.ErrPerm)
+		}
+	}
+	fs.c.sync(fs.rfs)
+	rc := rfs.Put(p, d, -1, c)
+	rd := <-rc
+	err = cerror(rc)
+	f, werr := fs.walk(forStat, nil, zx.Elems(fpath.Dir(p))...)
+	if werr == nil {
+		f.inval()
+		fs.getDirData(f)
+		f.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	rd["addr"] = "zxc!" + p
+	return rd, nil
+}
+
 func (fs *Fs) Put(p string, d zx.Dir, off int64, c <-chan []byte) <-chan zx.Dir {
 	fs.Dprintf("put %s %d %s...\n", p, off, ddir(d))
 	rc := make(chan zx.Dir)
 	go func() {
 		fs.Count(zx.Sput)
 		d = d.SysDup()
-		d, err := fs.put(p, d, off, c)
+		var err error
+		if off < 0 && d["type"] != "d" && d["type"] != "D" {
+			d, err = fs.append(p, d, c)
+		} else {
+			d, err = fs.put(p, d, off, c)
+		}
 		if err == nil {
 			rc <- d
 		} else {
@@ -1225,3 +1541,39 @@ func (fs *Fs) FindGet(path, fpred, spref, dpref string, depth0 int) <-chan face{
 	}()
 	return c
 }
+
+// Watch changes in the cached file system, if it can (see zx.Watcher).
+// Changes are reported once they reach it, which for
+// write-back caches is after they are synced.
+func (fs *Fs) Watch(p, fpred string) <-chan zx.Dir {
+	if wfs, ok := fs.rfs.(zx.Watcher); ok {
+		return wfs.Watch(p, fpred)
+	}
+	c := make(chan zx.Dir)
+	close(c, fmt.Errorf("%s: watch: %s", fs.Tag, zx.ErrBug))
+	return c
+}
+
+// Lock a file in the cached file system, if it can (see zx.Locker).
+func (fs *Fs) Lock(p, owner string, tmout time.Duration) <-chan zx.Dir {
+	if lfs, ok := fs.rfs.(zx.Locker); ok {
+		return lfs.Lock(p, owner, tmout)
+	}
+	c := make(chan zx.Dir)
+	close(c, fmt.Errorf("%s: lock: %s", fs.Tag, zx.ErrBug))
+	return c
+}
+
+// Unlock a file in the cached file system, if it can (see zx.Locker).
+func (fs *Fs) Unlock(p, owner string) <-chan error {
+	c := make(chan error, 1)
+	var err error
+	if lfs, ok := fs.rfs.(zx.Locker); ok {
+		err = <-lfs.Unlock(p, owner)
+	} else {
+		err = fmt.Errorf("%s: unlock: %s", fs.Tag, zx.ErrBug)
+	}
+	c <- err
+	close(c, err)
+	return c
+}
@@ -9,6 +9,7 @@ import (
 	"clive/zx/fstest"
 	"clive/zx/zux"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -80,6 +81,10 @@ func TestGets(t *testing.T) {
 	runTest(t, fstest.Gets)
 }
 
+func TestDirPages(t *testing.T) {
+	runTest(t, fstest.DirPages)
+}
+
 func TestFinds(t *testing.T) {
 	runTest(t, fstest.Finds)
 }
@@ -92,6 +97,22 @@ func TestPuts(t *testing.T) {
 	runTest(t, fstest.Puts)
 }
 
+func TestAppends(t *testing.T) {
+	runTest(t, fstest.Appends)
+}
+
+func TestCopies(t *testing.T) {
+	runTest(t, fstest.Copies)
+}
+
+func TestGreps(t *testing.T) {
+	runTest(t, fstest.Greps)
+}
+
+func TestSums(t *testing.T) {
+	runTest(t, fstest.Sums)
+}
+
 func TestMkdirs(t *testing.T) {
 	runTest(t, fstest.Mkdirs)
 }
@@ -108,6 +129,10 @@ func TestAttrs(t *testing.T) {
 	runTest(t, fstest.Attrs)
 }
 
+func TestUserAttrs(t *testing.T) {
+	runTest(t, fstest.UserAttrs)
+}
+
 func TestMoves(t *testing.T) {
 	runTest(t, fstest.Moves)
 }
@@ -116,6 +141,10 @@ func TestAsAFile(t *testing.T) {
 	runTest(t, fstest.AsAFile)
 }
 
+func TestSymlinks(t *testing.T) {
+	runTest(t, fstest.Symlinks)
+}
+
 func TestSync(t *testing.T) {
 	os.Args[0] = "rzx.test"
 	fstest.Verb = testing.Verbose()
@@ -221,6 +250,7 @@ func TestExtChanges(t *testing.T) {
 	fstest.MkZXChgs(t, lfs)
 	fstest.MkZXChgs2(t, lfs)
 	cacheTout = time.Millisecond
+	watchTout = time.Millisecond
 	time.Sleep(cacheTout)
 	rc = fscmp.Diff(lfs, cfs)
 	out = ""
@@ -236,3 +266,134 @@ func TestExtChanges(t *testing.T) {
 		cfs.c.dump()
 	}
 }
+
+func TestWatchChanges(t *testing.T) {
+	os.Args[0] = "rzx.test"
+	fstest.Verb = testing.Verbose()
+	fstest.MkTree(t, tdir)
+	defer os.RemoveAll(tdir)
+	lfs, err := zux.NewZX(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lfs.Sync()
+
+	cfs, err := New(lfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfs.Close()
+	cfs.Debug = testing.Verbose()
+	cfs.Flags.Set("cachedebug", cfs.Debug)
+	cacheTout, watchTout = time.Hour, time.Hour
+	defer func() {
+		cacheTout, watchTout = 5*time.Minute, time.Hour
+	}()
+	for c := range fscmp.Diff(lfs, cfs) {
+		t.Fatalf("pre chg %s %s", c.Type, c.D.Fmt())
+	}
+	fstest.MkZXChgs(t, lfs)
+	fstest.MkZXChgs2(t, lfs)
+	// notifications are asynchronous
+	out := ""
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		out = ""
+		for c := range fscmp.Diff(lfs, cfs) {
+			out += fmt.Sprintf("post chg %s %s\n", c.Type, c.D.Fmt())
+		}
+		if out == "" {
+			break
+		}
+	}
+	if out != "" {
+		t.Fatalf("had missed notified changes:\n%s", out)
+	}
+	if cfs.Debug {
+		cfs.c.dump()
+	}
+}
+
+func TestJournal(t *testing.T) {
+	os.Args[0] = "rzx.test"
+	fstest.Verb = testing.Verbose()
+	fstest.MkTree(t, tdir)
+	defer os.RemoveAll(tdir)
+	jdir := tdir + ".journal"
+	os.RemoveAll(jdir)
+	defer os.RemoveAll(jdir)
+	lfs, err := zux.NewZX(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lfs.Sync()
+
+	// changes can't be synced to a read-only server, as if
+	// it was unreachable; then we crash and restart.
+	cfs, err := New(zx.MakeRO(lfs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfs.Debug = testing.Verbose()
+	if err := cfs.Journal(jdir); err != nil {
+		t.Fatalf("journal: %s", err)
+	}
+	if err := zx.PutAll(cfs, "/a/n1", []byte("hi there")); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	dc := cfs.Put("/nd", zx.Dir{"type": "d", "mode": "0750"}, 0, nil)
+	if <-dc; cerror(dc) != nil {
+		t.Fatalf("mkdir: %s", cerror(dc))
+	}
+	dc = cfs.Wstat("/1", zx.Dir{"mode": "0600", "color": "red"})
+	if <-dc; cerror(dc) != nil {
+		t.Fatalf("wstat: %s", cerror(dc))
+	}
+	if err := <-cfs.Remove("/2"); err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+	if err := zx.PutAll(cfs, "/a/a1", []byte("mine")); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := zx.PutAll(lfs, "/a/a1", []byte("theirs")); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+
+	cfs2, err := New(lfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfs2.Close()
+	cfs2.Debug = testing.Verbose()
+	if err := cfs2.Journal(jdir); err != nil {
+		t.Fatalf("journal: %s", err)
+	}
+	if err := cfs2.Sync(); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	if dat, err := zx.GetAll(lfs, "/a/n1"); err != nil || string(dat) != "hi there" {
+		t.Fatalf("put not replayed: %q %v", dat, err)
+	}
+	if d, err := zx.Stat(lfs, "/nd"); err != nil || d["type"] != "d" || d["mode"] != "0750" {
+		t.Fatalf("mkdir not replayed: %v %v", d, err)
+	}
+	if d, err := zx.Stat(lfs, "/1"); err != nil || d["mode"] != "0600" || d["color"] != "red" {
+		t.Fatalf("wstat not replayed: %v %v", d, err)
+	}
+	if _, err := zx.Stat(lfs, "/2"); !zx.IsNotExist(err) {
+		t.Fatalf("remove not replayed: %v", err)
+	}
+	if dat, err := zx.GetAll(lfs, "/a/a1"); err != nil || string(dat) != "theirs" {
+		t.Fatalf("conflict: server data: %q %v", dat, err)
+	}
+	if dat, err := zx.GetAll(lfs, "/a/a1.conflict"); err != nil || string(dat) != "mine" {
+		t.Fatalf("conflict: local data: %q %v", dat, err)
+	}
+	if dat, err := zx.GetAll(cfs2, "/a/a1"); err != nil || string(dat) != "theirs" {
+		t.Fatalf("conflict: cached data: %q %v", dat, err)
+	}
+	if fis, _ := ioutil.ReadDir(jdir); len(fis) != 0 {
+		t.Fatalf("journal not empty after sync")
+	}
+}